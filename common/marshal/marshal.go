@@ -7,9 +7,9 @@ import (
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
 	"github.com/Qitmeer/qitmeer-lib/core/protocol"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/core/json"
 	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/rpc"
@@ -136,6 +136,9 @@ func MarshJsonVout(tx *types.Transaction, filterAddrMap map[string]struct{}, par
 		voutSPK.Hex = hex.EncodeToString(v.PkScript)
 		voutSPK.Type = scriptClass
 		voutSPK.ReqSigs = int32(reqSigs)
+		if payload, ok := txscript.ExtractNullData(v.PkScript); ok {
+			voutSPK.NullData = hex.EncodeToString(payload)
+		}
 		voutList = append(voutList, vout)
 	}
 
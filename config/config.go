@@ -1,7 +1,7 @@
 package config
 
 import (
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"net"
 	"time"
 )
@@ -31,8 +31,19 @@ type Config struct {
 	DropTxIndex        bool     `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
 	AddrIndex          bool     `long:"addrindex" description:"Maintain a full address-based transaction index which makes the getrawtransactions RPC available"`
 	DropAddrIndex      bool     `long:"dropaddrindex" description:"Deletes the address-based transaction index from the database on start up and then exits."`
+	SpentIndex         bool     `long:"spentindex" description:"Maintain an index of the transactions spending every output which makes the getspendingtx RPC available"`
+	DropSpentIndex     bool     `long:"dropspentindex" description:"Deletes the spent index from the database on start up and then exits."`
+	AddrUtxoIndex      bool     `long:"addrutxoindex" description:"Maintain an index of the unspent outputs and the balance of every address which makes the getaddressbalance, getaddressutxos and getaddressdeltas RPCs available"`
+	DropAddrUtxoIndex  bool     `long:"dropaddrutxoindex" description:"Deletes the address utxo index from the database on start up and then exits."`
+	TimeIndex          bool     `long:"timeindex" description:"Maintain an index of the blocks by timestamp and median time which makes the getblockorderbytime and getblocksintimerange RPCs available"`
+	DropTimeIndex      bool     `long:"droptimeindex" description:"Deletes the time index from the database on start up and then exits."`
+	NullDataIndex      bool     `long:"nulldataindex" description:"Maintain an index of the null data payloads which makes the searchnulldata RPC available -- implies --txindex"`
+	DropNullDataIndex  bool     `long:"dropnulldataindex" description:"Deletes the null data index from the database on start up and then exits."`
+	NoPeerBloomFilters bool     `long:"nopeerbloomfilters" description:"Disable bloom filtering support"`
+	NoCFilters         bool     `long:"nocfilters" description:"Disable committed filtering (CF) support"`
 	LightNode          bool     `long:"light" description:"start as a qitmeer light node"`
 	SigCacheMaxSize    uint     `long:"sigcachemaxsize" description:"The maximum number of entries in the signature verification cache"`
+	PruneBlockData     bool     `long:"pruneblockdata" description:"Delete the data of old blocks from the database while keeping their headers. Not compatible with --txindex, --addrindex, --spentindex, --nulldataindex or the committed filters"`
 	DumpBlockchain     string   `long:"dumpblockchain" description:"Write blockchain as a flat file of blocks for use with addblock, to the specified filename"`
 	TestNet            bool     `long:"testnet" description:"Use the test network"`
 	PrivNet            bool     `long:"privnet" description:"Use the private network"`
@@ -41,11 +52,14 @@ type Config struct {
 	DebugLevel         string   `short:"d" long:"debuglevel" description:"Logging level {trace, debug, info, warn, error, critical} "`
 	DebugPrintOrigins  bool     `long:"printorigin" description:"Print log debug location (file:line) "`
 	// MemPool Config
-	NoRelayPriority  bool    `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
-	FreeTxRelayLimit float64 `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
-	AcceptNonStd     bool    `long:"acceptnonstd" description:"Accept and relay non-standard transactions to the network regardless of the default settings for the active network."`
-	MaxOrphanTxs     int     `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
-	MinTxFee         int64   `long:"mintxfee" description:"The minimum transaction fee in AtomMEER/kB."`
+	NoRelayPriority   bool    `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
+	FreeTxRelayLimit  float64 `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
+	AcceptNonStd      bool    `long:"acceptnonstd" description:"Accept and relay non-standard transactions to the network regardless of the default settings for the active network."`
+	RejectReplacement bool    `long:"rejectreplacement" description:"Reject transactions that attempt to replace existing transactions within the mempool through the Replace-By-Fee (RBF) signaling policy."`
+	MaxOrphanTxs      int     `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
+	MinTxFee          int64   `long:"mintxfee" description:"The minimum transaction fee in AtomMEER/kB."`
+	MaxMempoolSize    int64   `long:"maxmempoolsize" description:"Max size in bytes of the transactions to keep in the memory pool, the ones with the lowest fee rate are evicted beyond it (0 to disable)"`
+	NoPersistMempool  bool    `long:"nopersistmempool" description:"Do not save the memory pool on shutdown and load it back on startup"`
 	// Miner
 	Generate          bool     `long:"generate" description:"Generate (mine) coins using the CPU"`
 	MiningAddrs       []string `long:"miningaddr" description:"Add the specified payment address to the list of addresses to use for generated blocks -- At least one address is required if the generate option is set"`
@@ -53,6 +67,7 @@ type Config struct {
 	BlockMinSize      uint32   `long:"blockminsize" description:"Mininum block size in bytes to be used when creating a block"`
 	BlockMaxSize      uint32   `long:"blockmaxsize" description:"Maximum block size in bytes to be used when creating a block"`
 	BlockPrioritySize uint32   `long:"blockprioritysize" description:"Size in bytes for high-priority/low-fee transactions when creating a block"`
+	StratumListen     string   `long:"stratum" description:"Listen for Stratum mining connections on the address, e.g. :3177 (disabled by default)"`
 	miningAddrs       []types.Address
 	//WebSocket support
 	RPCMaxWebsockets int `long:"rpcmaxwebsockets" description:"Max number of RPC websocket connections"`
@@ -71,6 +86,9 @@ type Config struct {
 	BanThreshold   uint32        `long:"banthreshold" description:"Maximum allowed ban score before disconnecting and banning misbehaving peers."`
 	GetAddrPercent int           `short:"T" long:"getaddrpercent" description:"It is the percentage of total addresses known that we will share with a call to AddressCache."`
 
+	//Wallet
+	Wallet bool `long:"wallet" description:"Enable the wallet of the node, which keeps its seed encrypted in the data directory. Its RPCs are in the wallet module (see --modules)"`
+
 	DAGType     string `short:"G" long:"dagtype" description:"DAG type {phantom,conflux,spectre} "`
 	Cleanup     bool   `short:"L" long:"cleanup" description:"Cleanup the block database "`
 	BuildLedger bool   `long:"buildledger" description:"Generate the genesis ledger for the next qitmeer version."`
@@ -0,0 +1,86 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package pow
+
+import (
+	"github.com/Qitmeer/qitmeer/consensus"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+// blake2b is the proof of work engine which requires the double blake2b hash
+// of the block header to be less than the target difficulty.
+type blake2b struct {
+	engine
+}
+
+// Ensure the blake2b type implements the consensus.PoW interface.
+var _ consensus.PoW = (*blake2b)(nil)
+
+// newBlake2b returns a new blake2b proof of work engine.
+func newBlake2b(par *params.Params) *blake2b {
+	return &blake2b{engine: engine{params: par}}
+}
+
+// Verify ensures the hash of the header is less than the target difficulty
+// claimed by the header.
+//
+// This is part of the consensus.BlockChainConsensue interface implementation.
+func (e *blake2b) Verify(chain *blockchain.BlockChain, header *types.BlockHeader) error {
+	target, err := e.checkTarget(header)
+	if err != nil {
+		return err
+	}
+	if header.Version&types.CycleProofBlockVersion != 0 {
+		return ruleError(blockchain.ErrBadCycleProof, "block header "+
+			"carries a cycle proof which is not used by the %v "+
+			"network", e.params.Name)
+	}
+
+	h := header.BlockHash()
+	hashNum := blockchain.HashToBig(&h)
+	if hashNum.Cmp(target) > 0 {
+		return ruleError(blockchain.ErrHighHash, "block hash of %064x "+
+			"is higher than expected max of %064x", hashNum, target)
+	}
+	return nil
+}
+
+// Prepare sets the difficulty of the header to the difficulty required for the
+// next block.
+//
+// This is part of the consensus.BlockChainConsensue interface implementation.
+func (e *blake2b) Prepare(chain *blockchain.BlockChain, header *types.BlockHeader) error {
+	return e.prepare(chain, header)
+}
+
+// Generate searches the nonces starting at the nonce of the block header until
+// the hash of the header is less than the target difficulty.  It returns the
+// solved block, or nil when the stop channel is closed first in which case the
+// nonce of the header is the next one to try.
+//
+// This is part of the consensus.BlockChainConsensue interface implementation.
+func (e *blake2b) Generate(chain *blockchain.BlockChain, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	header := &block.Header
+	target := blockchain.CompactToBig(header.Difficulty)
+	for {
+		select {
+		case <-stop:
+			return nil, nil
+		default:
+		}
+
+		h := header.BlockHash()
+		e.meter.mark(1)
+		if blockchain.HashToBig(&h).Cmp(target) <= 0 {
+			return block, nil
+		}
+		if header.Nonce == maxNonce {
+			return nil, ErrNonceExhausted
+		}
+		header.Nonce++
+	}
+}
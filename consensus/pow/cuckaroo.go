@@ -0,0 +1,149 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package pow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/consensus"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/cuckoo"
+	"github.com/Qitmeer/qitmeer/params"
+	"math/big"
+	"sync"
+)
+
+// sipKeySize is the size of the siphash key derived from the block header.
+const sipKeySize = 16
+
+// cuckaroo is the proof of work engine which requires the block header to carry
+// the nonces of a cycle of the cuckoo graph keyed by the header, and the hash
+// of the cycle nonces to be less than the target difficulty.
+type cuckaroo struct {
+	engine
+
+	// solvers holds the cuckoo solvers which are expensive to allocate, so
+	// they are reused by the goroutines generating blocks.
+	solvers sync.Pool
+}
+
+// Ensure the cuckaroo type implements the consensus.PoW interface.
+var _ consensus.PoW = (*cuckaroo)(nil)
+
+// newCuckaroo returns a new cuckaroo proof of work engine.
+func newCuckaroo(par *params.Params) *cuckaroo {
+	return &cuckaroo{
+		engine: engine{params: par},
+		solvers: sync.Pool{
+			New: func() interface{} { return cuckoo.NewCuckoo() },
+		},
+	}
+}
+
+// sipKey returns the siphash key of the cuckoo graph of the header which is
+// the hash of the header without its cycle nonces.
+func sipKey(header *types.BlockHeader) []byte {
+	h := *header
+	h.Version |= types.CycleProofBlockVersion
+	h.CycleNonces = nil
+	buf := bytes.NewBuffer(make([]byte, 0, types.MaxBlockHeaderPayload))
+	_ = h.Serialize(buf)
+	return hash.DoubleHashB(buf.Bytes())[:sipKeySize]
+}
+
+// cycleHashToBig returns the hash of the passed cycle nonces as a big.Int that
+// can be compared to the target difficulty.
+func cycleHashToBig(nonces []uint32) *big.Int {
+	buf := make([]byte, 4*len(nonces))
+	for i, nonce := range nonces {
+		binary.LittleEndian.PutUint32(buf[4*i:], nonce)
+	}
+	h := hash.DoubleHashH(buf)
+	return blockchain.HashToBig(&h)
+}
+
+// Verify ensures the cycle nonces of the header form a cycle of the cuckoo
+// graph keyed by the header and that their hash is less than the target
+// difficulty claimed by the header.
+//
+// This is part of the consensus.BlockChainConsensue interface implementation.
+func (e *cuckaroo) Verify(chain *blockchain.BlockChain, header *types.BlockHeader) error {
+	target, err := e.checkTarget(header)
+	if err != nil {
+		return err
+	}
+	if header.Version&types.CycleProofBlockVersion == 0 {
+		return ruleError(blockchain.ErrBadCycleProof, "block header "+
+			"does not carry the cycle proof required by the %v "+
+			"network", e.params.Name)
+	}
+	if len(header.CycleNonces) != cuckoo.ProofSize {
+		return ruleError(blockchain.ErrBadCycleProof, "block header "+
+			"carries %d cycle nonces instead of %d",
+			len(header.CycleNonces), cuckoo.ProofSize)
+	}
+	err = cuckoo.Verify(sipKey(header), header.CycleNonces)
+	if err != nil {
+		return ruleError(blockchain.ErrBadCycleProof, "invalid cycle "+
+			"proof: %v", err)
+	}
+
+	hashNum := cycleHashToBig(header.CycleNonces)
+	if hashNum.Cmp(target) > 0 {
+		return ruleError(blockchain.ErrHighHash, "cycle hash of %064x "+
+			"is higher than expected max of %064x", hashNum, target)
+	}
+	return nil
+}
+
+// Prepare sets the difficulty of the header to the difficulty required for the
+// next block and flags the header to carry the cycle proof.
+//
+// This is part of the consensus.BlockChainConsensue interface implementation.
+func (e *cuckaroo) Prepare(chain *blockchain.BlockChain, header *types.BlockHeader) error {
+	header.Version |= types.CycleProofBlockVersion
+	header.CycleNonces = nil
+	return e.prepare(chain, header)
+}
+
+// Generate searches the nonces starting at the nonce of the block header until
+// the cuckoo graph keyed by the header has a cycle whose hash is less than the
+// target difficulty.  It returns the solved block with the cycle nonces set, or
+// nil when the stop channel is closed first in which case the nonce of the
+// header is the next one to try.
+//
+// This is part of the consensus.BlockChainConsensue interface implementation.
+func (e *cuckaroo) Generate(chain *blockchain.BlockChain, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	solver := e.solvers.Get().(*cuckoo.Cuckoo)
+	defer e.solvers.Put(solver)
+
+	header := &block.Header
+	header.Version |= types.CycleProofBlockVersion
+	target := blockchain.CompactToBig(header.Difficulty)
+	for {
+		select {
+		case <-stop:
+			return nil, nil
+		default:
+		}
+
+		header.CycleNonces = nil
+		key := sipKey(header)
+		nonces, found := solver.PoW(key)
+		e.meter.mark(1)
+		if found && cuckoo.Verify(key, nonces) == nil &&
+			cycleHashToBig(nonces).Cmp(target) <= 0 {
+
+			header.CycleNonces = nonces
+			return block, nil
+		}
+		if header.Nonce == maxNonce {
+			return nil, ErrNonceExhausted
+		}
+		header.Nonce++
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package pow implements the proof of work consensus engines of the chain.
+package pow
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/consensus"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/params"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxNonce is the maximum value a nonce can be in a block header.
+const maxNonce = ^uint64(0) // 2^64 - 1
+
+// ErrNonceExhausted is returned by Generate when the entire nonce range was
+// searched without finding a solution.
+var ErrNonceExhausted = errors.New("nonce range exhausted without a solution")
+
+// New returns the proof of work engine of the algorithm selected by the passed
+// network parameters.
+func New(par *params.Params) consensus.PoW {
+	switch par.PowType {
+	case params.CuckarooPow:
+		return newCuckaroo(par)
+	default:
+		return newBlake2b(par)
+	}
+}
+
+// hashMeter counts the attempts of an engine to solve a block to measure its
+// hash rate.
+type hashMeter struct {
+	attempts uint64 // atomic
+
+	sync.Mutex
+	last time.Time
+}
+
+// mark adds the passed number of attempts to the meter.
+func (m *hashMeter) mark(n uint64) {
+	atomic.AddUint64(&m.attempts, n)
+}
+
+// rate returns the number of attempts per second since the previous call.
+func (m *hashMeter) rate() float64 {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	attempts := atomic.SwapUint64(&m.attempts, 0)
+	elapsed := now.Sub(m.last).Seconds()
+	first := m.last.IsZero()
+	m.last = now
+	if first || elapsed <= 0 {
+		return 0
+	}
+	return float64(attempts) / elapsed
+}
+
+// engine houses the parts which are common to the proof of work engines.
+type engine struct {
+	params *params.Params
+	meter  hashMeter
+}
+
+// checkTarget ensures the difficulty bits of the header are in range and
+// returns the target difficulty they describe.
+func (e *engine) checkTarget(header *types.BlockHeader) (*big.Int, error) {
+	err := blockchain.CheckProofOfWorkLimit(header, e.params.PowLimit)
+	if err != nil {
+		return nil, err
+	}
+	return blockchain.CompactToBig(header.Difficulty), nil
+}
+
+// prepare sets the difficulty of the header to the difficulty required for the
+// next block.
+func (e *engine) prepare(chain *blockchain.BlockChain, header *types.BlockHeader) error {
+	difficulty, err := chain.CalcNextRequiredDifficulty(header.Timestamp)
+	if err != nil {
+		return err
+	}
+	header.Difficulty = difficulty
+	return nil
+}
+
+// Finalize returns the passed block as is since there are no post-transaction
+// state modifications for proof of work.
+//
+// This is part of the consensus.BlockChainConsensue interface implementation.
+func (e *engine) Finalize(chain *blockchain.BlockChain, block *types.Block) (*types.Block, error) {
+	return block, nil
+}
+
+// CalcDifficulty returns the target difficulty the block after the end of the
+// DAG with the passed timestamp must have.  The parent is ignored since the
+// difficulty is calculated from the DAG.
+//
+// This is part of the consensus.PoW interface implementation.
+func (e *engine) CalcDifficulty(chain *blockchain.BlockChain, time uint64, parent *types.BlockHeader) *big.Int {
+	difficulty, err := chain.CalcNextRequiredDifficulty(unixTime(time))
+	if err != nil {
+		return new(big.Int).Set(e.params.PowLimit)
+	}
+	return blockchain.CompactToBig(difficulty)
+}
+
+// Hashrate returns the number of attempts per second to solve a block since
+// the previous call.
+//
+// This is part of the consensus.PoW interface implementation.
+func (e *engine) Hashrate() float64 {
+	return e.meter.rate()
+}
+
+// unixTime returns the time of the passed unix timestamp.
+func unixTime(t uint64) time.Time {
+	return time.Unix(int64(t), 0)
+}
+
+// ruleError creates a blockchain.RuleError given a set of arguments.
+func ruleError(c blockchain.ErrorCode, format string, a ...interface{}) error {
+	return blockchain.RuleError{ErrorCode: c, Description: fmt.Sprintf(format, a...)}
+}
@@ -5,8 +5,8 @@ package address
 import (
 	"errors"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/common/encode/base58"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/params"
 	"golang.org/x/crypto/ripemd160"
 	//"github.com/Qitmeer/qitmeer-lib/crypto/ecc"
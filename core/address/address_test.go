@@ -8,9 +8,9 @@ package address
 import (
 	// "fmt"
 	"bytes"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer-lib/crypto/ecc"
 	"github.com/Qitmeer/qitmeer/common/encode/base58"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/params"
 	"reflect"
 	// "fmt"
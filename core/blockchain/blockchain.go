@@ -7,7 +7,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/dbnamespace"
 	"github.com/Qitmeer/qitmeer/database"
@@ -51,6 +51,7 @@ type BlockChain struct {
 	notifications NotificationCallback
 	sigCache      *txscript.SigCache
 	indexManager  IndexManager
+	pow           PowVerifier
 
 	// subsidyCache is the cache that provides quick lookup of subsidy
 	// values.
@@ -104,6 +105,13 @@ type BlockChain struct {
 	// it is unlikely to be referenced in the future.
 	pruner *chainPruner
 
+	// The following caches are used to efficiently keep track of the
+	// current deployment threshold state of each rule change deployment.
+	//
+	// deploymentCaches caches the current deployment threshold state for
+	// blocks in each of the deployments of the chain block version.
+	deploymentCaches []thresholdStateCache
+
 	//block dag
 	bd *blockdag.BlockDAG
 
@@ -173,6 +181,17 @@ type Config struct {
 
 	// block version
 	BlockVersion uint32
+
+	// PoW defines the proof of work engine which verifies the proof of work
+	// carried by the block headers.
+	//
+	// This field is required.
+	PoW PowVerifier
+
+	// PruneBlockData specifies whether the data of blocks far below the
+	// stable DAG order is deleted from the database.  The headers, the DAG
+	// index and the spend journal of pruned blocks are kept.
+	PruneBlockData bool
 }
 
 // orphanBlock represents a block that we don't yet have the parent for.  It
@@ -238,6 +257,9 @@ func New(config *Config) (*BlockChain, error) {
 	if config.ChainParams == nil {
 		return nil, AssertError("blockchain.New chain parameters nil")
 	}
+	if config.PoW == nil {
+		return nil, AssertError("blockchain.New proof of work engine is nil")
+	}
 
 	// Generate a checkpoint by height map from the provided checkpoints.
 	par := config.ChainParams
@@ -258,13 +280,19 @@ func New(config *Config) (*BlockChain, error) {
 		notifications:       config.Notifications,
 		sigCache:            config.SigCache,
 		indexManager:        config.IndexManager,
-		index:               newBlockIndex(config.DB, par),
+		pow:                 config.PoW,
 		orphans:             make(map[hash.Hash]*orphanBlock),
 		prevOrphans:         make(map[hash.Hash][]*orphanBlock),
 		BlockVersion:        config.BlockVersion,
 	}
+	if err := b.checkDeployments(); err != nil {
+		return nil, err
+	}
+	b.deploymentCaches = newThresholdCaches(uint32(len(b.deployments())))
 	b.bd = &blockdag.BlockDAG{}
 	b.bd.Init(config.DAGType)
+	b.bd.SetDB(config.DB)
+	b.index = newBlockIndex(config.DB, par, b.bd)
 	// Initialize the chain state from the passed database.  When the db
 	// does not yet contain any chain state, both it and the chain state
 	// will be initialized to contain only the genesis block.
@@ -281,7 +309,10 @@ func New(config *Config) (*BlockChain, error) {
 		}
 	}
 
-	b.pruner = newChainPruner(&b)
+	b.pruner = newChainPruner(&b, config.PruneBlockData)
+	if err := b.pruner.pruneChain(); err != nil {
+		return nil, err
+	}
 	b.subsidyCache = NewSubsidyCache(int64(b.BestSnapshot().GraphState.GetMainHeight()), b.params)
 
 	log.Info(fmt.Sprintf("DAG Type:%s", b.bd.GetName()))
@@ -432,41 +463,42 @@ func (b *BlockChain) initChainState(interrupt <-chan struct{}) error {
 		}
 		log.Info(fmt.Sprintf("Dag loaded:loadTime=%v", time.Since(bidxStart)))
 
-		// Determine how many blocks will be loaded into the index in order to
-		// allocate the right amount as a single alloc versus a whole bunch of
-		// littles ones to reduce pressure on the GC.
-		var block *types.SerializedBlock
+		// Only the block headers are loaded since the data of old blocks
+		// may have been pruned.  The parents are known by the DAG.
 		for i := uint(0); i < uint(state.total); i++ {
 			blockHash := b.bd.GetBlockHash(i)
-			block, err = dbFetchBlockByHash(dbTx, blockHash)
+			header, err := dbFetchHeaderByHash(dbTx, blockHash)
 			if err != nil {
 				return err
 			}
-			if i != 0 && block.Block().Header.Version != b.BlockVersion {
+			if i != 0 && header.Version&^blockVersionFlagsMask != b.BlockVersion {
 				return fmt.Errorf("The dag block is not match current genesis block. you can cleanup your block data base by '--cleanup'.")
 			}
+			refblock := b.bd.GetBlock(blockHash)
 			parents := []*blockNode{}
-			for _, pb := range block.Block().Parents {
-				parent := b.index.LookupNode(pb)
-				if parent == nil {
-					return fmt.Errorf("Can't find parent %s", pb.String())
+			if refblock.HasParents() {
+				for _, pb := range refblock.GetParents().SortList(false) {
+					parent := b.index.LookupNode(pb)
+					if parent == nil {
+						return fmt.Errorf("Can't find parent %s", pb.String())
+					}
+					parents = append(parents, parent)
 				}
-				parents = append(parents, parent)
 			}
-			refblock := b.bd.GetBlock(blockHash)
 			//
 			node := &blockNode{}
-			initBlockNode(node, &block.Block().Header, parents)
+			initBlockNode(node, header, parents)
 			b.index.addNode(node)
-			node.status = blockStatus(refblock.GetStatus())
-			node.SetOrder(uint64(refblock.GetOrder()))
-			node.SetHeight(refblock.GetHeight())
-
+			node.setDAGState(refblock)
 		}
 
 		// Set the best chain view to the stored best state.
 		// Load the raw block bytes for the best block.
 		mainTip := b.index.lookupNode(b.bd.GetMainChainTip().GetHash())
+		block, err := dbFetchBlockByHash(dbTx, mainTip.GetHash())
+		if err != nil {
+			return err
+		}
 		// Initialize the state related to the best block.
 		blockSize := uint64(block.Block().SerializeSize())
 		numTxns := uint64(len(block.Block().Transactions))
@@ -478,6 +510,19 @@ func (b *BlockChain) initChainState(interrupt <-chan struct{}) error {
 	if err != nil {
 		return err
 	}
+
+	// Build the utxo state of every block when the database was created
+	// before the utxo set commitment was tracked.
+	err = b.db.Update(func(dbTx database.Tx) error {
+		if dbTx.Metadata().Bucket(dbnamespace.UtxoStateBucketName) != nil {
+			return nil
+		}
+		log.Info("Building utxo state commitments...")
+		return b.dbInitUtxoState(dbTx, uint64(b.bd.GetBlockTotal()))
+	})
+	if err != nil {
+		return err
+	}
 	return b.index.flushToDB(b.bd)
 }
 
@@ -1061,6 +1106,14 @@ func (b *BlockChain) connectBlock(node *blockNode, block *types.SerializedBlock,
 		if err != nil {
 			return err
 		}
+
+		// Update the utxo set commitment with the outputs the block
+		// spends and creates.
+		err = b.dbConnectUtxoState(dbTx, node, block, stxos)
+		if err != nil {
+			return err
+		}
+
 		// Allow the index manager to call each of the currently active
 		// optional indexes with the block being connected so they can
 		// update themselves accordingly.
@@ -1116,6 +1169,13 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *types.SerializedBlo
 		if err != nil {
 			return err
 		}
+
+		// Remove the utxo set commitment as of the block.
+		err = dbRemoveUtxoState(dbTx, block.Hash())
+		if err != nil {
+			return err
+		}
+
 		// Allow the index manager to call each of the currently active
 		// optional indexes with the block being disconnected so they
 		// can update themselves accordingly.
@@ -1169,6 +1229,13 @@ func (b *BlockChain) reorganizeChain(detachNodes BlockNodeList, attachNodes *lis
 	var n *blockNode
 	var block *types.SerializedBlock
 	var err error
+	rd := &ReorganizationNotifyData{NewHash: *newBlock.Hash()}
+
+	// Drop the cached threshold states so they are recalculated from the
+	// reorganized DAG.
+	for i := range b.deploymentCaches {
+		b.deploymentCaches[i].clear()
+	}
 
 	dl := len(detachNodes)
 	for i := dl - 1; i >= 0; i-- {
@@ -1215,6 +1282,7 @@ func (b *BlockChain) reorganizeChain(detachNodes BlockNodeList, attachNodes *lis
 		if err != nil {
 			return err
 		}
+		rd.Detached = append(rd.Detached, BlockOrder{Hash: n.hash, Order: n.order})
 		b.index.UnsetStatusFlags(n, statusValid)
 		b.index.UnsetStatusFlags(newn, statusValid)
 		b.index.UnsetStatusFlags(n, statusInvalid)
@@ -1240,6 +1308,7 @@ func (b *BlockChain) reorganizeChain(detachNodes BlockNodeList, attachNodes *lis
 		if !n.IsOrdered() {
 			continue
 		}
+		rd.Attached = append(rd.Attached, BlockOrder{Hash: n.hash, Order: n.GetOrder()})
 		view := NewUtxoViewpoint()
 		view.SetBestHash(n.GetHash())
 		stxos := []SpentTxOut{}
@@ -1265,6 +1334,9 @@ func (b *BlockChain) reorganizeChain(detachNodes BlockNodeList, attachNodes *lis
 	// heads.
 	log.Debug(fmt.Sprintf("End DAG REORGANIZE: Old Len= %d;New Len= %d", attachNodes.Len(), detachNodes.Len()))
 
+	// Notify the caller that the DAG order of the blocks has changed.
+	b.sendNotification(Reorganization, rd)
+
 	return nil
 }
 
@@ -1380,6 +1452,32 @@ func (b *BlockChain) FetchSpendJournal(targetBlock *types.SerializedBlock) ([]Sp
 	return spendEntries, nil
 }
 
+// DBFetchSpendJournal uses an existing database transaction to fetch the
+// spend journal entry of the passed block.  The entry is empty for the blocks
+// known to be invalid since their transactions don't spend anything.
+//
+// This function MUST be called with the chain state lock held (for reads), see
+// WithChainLock.
+func (b *BlockChain) DBFetchSpendJournal(dbTx database.Tx, block *types.SerializedBlock) ([]SpentTxOut, error) {
+	node := b.index.LookupNode(block.Hash())
+	if node != nil && b.index.NodeStatus(node).KnownInvalid() {
+		return nil, nil
+	}
+	return dbFetchSpendJournalEntry(dbTx, block)
+}
+
+// WithChainLock calls the passed function with the chain state lock held for
+// reads, so no block is connected or disconnected while it runs.  It allows
+// the callers to update the database consistently with the main chain.
+//
+// The function MUST NOT call the methods of the chain acquiring the chain
+// state lock.
+func (b *BlockChain) WithChainLock(fn func() error) error {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+	return fn()
+}
+
 func (b *BlockChain) SetTxManager(txManager TxManager) {
 	b.txManager = txManager
 }
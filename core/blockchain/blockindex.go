@@ -2,9 +2,10 @@
 package blockchain
 
 import (
+	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/params"
 	"sync"
@@ -41,6 +42,7 @@ type blockIndex struct {
 	// separate mutex.
 	db     database.DB
 	params *params.Params
+	bd     *blockdag.BlockDAG
 
 	sync.RWMutex
 	index map[hash.Hash]*blockNode
@@ -50,21 +52,108 @@ type blockIndex struct {
 // newBlockIndex returns a new empty instance of a block index.  The index will
 // be dynamically populated as block nodes are loaded from the database and
 // manually added.
-func newBlockIndex(db database.DB, par *params.Params) *blockIndex {
+func newBlockIndex(db database.DB, par *params.Params, bd *blockdag.BlockDAG) *blockIndex {
 	return &blockIndex{
 		db:     db,
 		params: par,
+		bd:     bd,
 		index:  make(map[hash.Hash]*blockNode),
 		dirty:  make(map[*blockNode]struct{}),
 	}
 }
 
-// lookupNode returns the block node identified by the provided hash.  It will
-// return nil if there is no entry for the hash.
+// lookupNode returns the block node identified by the provided hash.  Nodes
+// which have been pruned from the index are reconstructed from the database and
+// added back to the index.  It will return nil if there is no entry for the
+// hash.
 //
-// This function MUST be called with the block index lock held (for reads).
+// This function is safe for concurrent access.
 func (bi *blockIndex) lookupNode(hash *hash.Hash) *blockNode {
-	return bi.index[*hash]
+	bi.RLock()
+	node := bi.index[*hash]
+	bi.RUnlock()
+	if node != nil {
+		return node
+	}
+
+	bi.Lock()
+	defer bi.Unlock()
+	node = bi.index[*hash]
+	if node == nil {
+		node = bi.loadNode(hash)
+		if node != nil {
+			bi.addNode(node)
+		}
+	}
+	return node
+}
+
+// loadNode reconstructs the block node identified by the provided hash from the
+// block header stored in the database and the block of the DAG.  Parents which
+// are not in the index either are reconstructed with the work sum stored when
+// they were pruned and without links of their own, since only their hashes
+// and work sums are used through the links of the node.  It will return nil if
+// the block is unknown.
+//
+// This function MUST be called with the block index lock held (for writes).
+func (bi *blockIndex) loadNode(h *hash.Hash) *blockNode {
+	ib := bi.bd.GetBlock(h)
+	if ib == nil {
+		return nil
+	}
+
+	var node *blockNode
+	err := bi.db.View(func(dbTx database.Tx) error {
+		var parents []*blockNode
+		if ib.HasParents() {
+			for _, ph := range ib.GetParents().SortList(false) {
+				parent := bi.index[*ph]
+				if parent == nil {
+					var err error
+					parent, err = bi.dbFetchPrunedNode(dbTx, ph)
+					if err != nil {
+						return err
+					}
+				}
+				parents = append(parents, parent)
+			}
+		}
+		header, err := dbFetchHeaderByHash(dbTx, h)
+		if err != nil {
+			return err
+		}
+		node = newBlockNode(header, parents)
+		node.setDAGState(ib)
+		return nil
+	})
+	if err != nil {
+		log.Error("Failed to load block node", "hash", h, "error", err)
+		return nil
+	}
+	return node
+}
+
+// dbFetchPrunedNode uses an existing database transaction to reconstruct the
+// node of a pruned block without its links to other nodes.
+//
+// This function MUST be called with the block index lock held (for reads).
+func (bi *blockIndex) dbFetchPrunedNode(dbTx database.Tx, h *hash.Hash) (*blockNode, error) {
+	ib := bi.bd.GetBlock(h)
+	if ib == nil {
+		return nil, fmt.Errorf("Can't find block %s", h)
+	}
+	header, err := dbFetchHeaderByHash(dbTx, h)
+	if err != nil {
+		return nil, err
+	}
+	workSum, err := dbFetchBlockWork(dbTx, h)
+	if err != nil {
+		return nil, err
+	}
+	node := newBlockNode(header, nil)
+	node.workSum = workSum
+	node.setDAGState(ib)
+	return node, nil
 }
 
 // LookupNode returns the block node identified by the provided hash.  It will
@@ -72,10 +161,7 @@ func (bi *blockIndex) lookupNode(hash *hash.Hash) *blockNode {
 //
 // This function is safe for concurrent access.
 func (bi *blockIndex) LookupNode(hash *hash.Hash) *blockNode {
-	bi.RLock()
-	node := bi.lookupNode(hash)
-	bi.RUnlock()
-	return node
+	return bi.lookupNode(hash)
 }
 
 // addNode adds the provided node to the block index.  Duplicate entries are not
@@ -109,6 +195,10 @@ func (bi *blockIndex) HaveBlock(hash *hash.Hash) bool {
 	bi.RLock()
 	_, hasBlock := bi.index[*hash]
 	bi.RUnlock()
+	if !hasBlock {
+		// The node may have been pruned from the index.
+		hasBlock = bi.bd.HasBlock(hash)
+	}
 	return hasBlock
 }
 
@@ -189,6 +279,82 @@ func (bi *blockIndex) flushToDB(bd *blockdag.BlockDAG) error {
 	return err
 }
 
+// pruneNodes removes the nodes whose DAG order is below the passed order from
+// the index so that they can be freed by the garbage collector.  The genesis
+// node, nodes which have not been ordered yet and nodes with pending status
+// changes are kept.  The work sums of the removed nodes are stored since they
+// are derived from the parents, so lookupNode can reconstruct the nodes on
+// demand.
+//
+// The removed nodes which are still linked to the kept nodes keep their own
+// links, but their links point to copies of the other removed nodes without
+// links, so that they don't keep the whole ancestry alive.  The links of the
+// other removed nodes are cut.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) pruneNodes(order uint64) (int, error) {
+	bi.Lock()
+	defer bi.Unlock()
+
+	pruned := make(map[*blockNode]struct{})
+	for _, node := range bi.index {
+		if node.order == 0 || !node.IsOrdered() || node.order >= order {
+			continue
+		}
+		if _, ok := bi.dirty[node]; ok {
+			continue
+		}
+		pruned[node] = struct{}{}
+	}
+	if len(pruned) == 0 {
+		return 0, nil
+	}
+	err := bi.db.Update(func(dbTx database.Tx) error {
+		for node := range pruned {
+			if err := dbPutBlockWork(dbTx, node); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	isLinkedToKept := func(node *blockNode) bool {
+		for _, links := range [][]*blockNode{node.parents, node.children} {
+			for _, n := range links {
+				if _, ok := pruned[n]; !ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	linked := make(map[*blockNode]struct{})
+	for node := range pruned {
+		if isLinkedToKept(node) {
+			linked[node] = struct{}{}
+		}
+	}
+	for node := range pruned {
+		delete(bi.index, node.hash)
+		if _, ok := linked[node]; !ok {
+			node.parents = nil
+			node.children = nil
+			continue
+		}
+		for _, links := range [][]*blockNode{node.parents, node.children} {
+			for i, n := range links {
+				if _, ok := pruned[n]; ok {
+					links[i] = n.unlinked()
+				}
+			}
+		}
+	}
+	return len(pruned), nil
+}
+
 func GetMaxLayerFromList(list []*blockNode) uint {
 	var maxLayer uint = 0
 	for _, v := range list {
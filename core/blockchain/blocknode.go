@@ -3,10 +3,9 @@ package blockchain
 
 import (
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/common/util"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
-	"github.com/Qitmeer/qitmeer/core/merkle"
 	"math/big"
 	"sort"
 	"time"
@@ -81,11 +80,13 @@ type blockNode struct {
 	blockVersion uint32
 	bits         uint32
 	timestamp    int64
+	parentRoot   hash.Hash
 	txRoot       hash.Hash
 	stateRoot    hash.Hash
 	nonce        uint64
 	exNonce      uint64
 	extraData    [32]byte
+	cycleNonces  []uint32
 
 	// status is a bitfield representing the validation state of the block.
 	// This field, unlike the other fields, may be changed after the block
@@ -128,10 +129,12 @@ func initBlockNode(node *blockNode, blockHeader *types.BlockHeader, parents []*b
 		blockVersion: blockHeader.Version,
 		bits:         blockHeader.Difficulty,
 		timestamp:    blockHeader.Timestamp.Unix(),
+		parentRoot:   blockHeader.ParentRoot,
 		txRoot:       blockHeader.TxRoot,
 		nonce:        blockHeader.Nonce,
 		exNonce:      blockHeader.ExNonce,
 		stateRoot:    blockHeader.StateRoot,
+		cycleNonces:  blockHeader.CycleNonces,
 		status:       statusNone,
 	}
 	if len(parents) > 0 {
@@ -148,20 +151,16 @@ func initBlockNode(node *blockNode, blockHeader *types.BlockHeader, parents []*b
 // This function is safe for concurrent access.
 func (node *blockNode) Header() types.BlockHeader {
 	// No lock is needed because all accessed fields are immutable.
-	var parentRoot hash.Hash
-	if node.parents != nil {
-		paMerkles := merkle.BuildParentsMerkleTreeStore(node.GetParents())
-		parentRoot = *paMerkles[len(paMerkles)-1]
-	}
 	return types.BlockHeader{
-		Version:    node.blockVersion,
-		ParentRoot: parentRoot,
-		TxRoot:     node.txRoot,
-		StateRoot:  node.stateRoot,
-		Difficulty: node.bits,
-		ExNonce:    node.exNonce,
-		Timestamp:  time.Unix(node.timestamp, 0),
-		Nonce:      node.nonce,
+		Version:     node.blockVersion,
+		ParentRoot:  node.parentRoot,
+		TxRoot:      node.txRoot,
+		StateRoot:   node.stateRoot,
+		Difficulty:  node.bits,
+		ExNonce:     node.exNonce,
+		Timestamp:   time.Unix(node.timestamp, 0),
+		Nonce:       node.nonce,
+		CycleNonces: node.cycleNonces,
 	}
 }
 
@@ -170,16 +169,22 @@ func (node *blockNode) Header() types.BlockHeader {
 //
 // This function is safe for concurrent access.
 func (node *blockNode) CalcPastMedianTime(b *BlockChain) time.Time {
+	return PastMedianTime(chainHeaderNode{b, node})
+}
+
+// PastMedianTime calculates the median time of the previous few blocks on the
+// chain of main parents prior to, and including, the passed node.
+func PastMedianTime(node HeaderNode) time.Time {
 	// Create a slice of the previous few block timestamps used to calculate
 	// the median per the number defined by the constant medianTimeBlocks.
 	timestamps := make([]int64, medianTimeBlocks)
 	numNodes := 0
 	iterNode := node
 	for i := 0; i < medianTimeBlocks && iterNode != nil; i++ {
-		timestamps[i] = iterNode.timestamp
+		timestamps[i] = iterNode.Timestamp()
 		numNodes++
 
-		iterNode = iterNode.GetMainParent(b)
+		iterNode = iterNode.MainParent()
 	}
 
 	// Prune the slice to the actual number of available timestamps which
@@ -260,6 +265,15 @@ func (node *blockNode) GetChildren() *blockdag.HashSet {
 	return result
 }
 
+// setDAGState restores the position and the validation state of the node
+// from the corresponding block of the DAG.
+func (node *blockNode) setDAGState(ib blockdag.IBlock) {
+	node.status = blockStatus(ib.GetStatus())
+	node.order = uint64(ib.GetOrder())
+	node.height = ib.GetHeight()
+	node.layer = ib.GetLayer()
+}
+
 func (node *blockNode) SetOrder(o uint64) {
 	node.order = o
 }
@@ -298,6 +312,15 @@ func (node *blockNode) Clone() *blockNode {
 	return newNode
 }
 
+// unlinked returns a copy of the node without its links to its parents and
+// children.
+func (node *blockNode) unlinked() *blockNode {
+	n := *node
+	n.parents = nil
+	n.children = nil
+	return &n
+}
+
 //return parent that position is rather forward
 func (node *blockNode) GetForwardParent() *blockNode {
 	if node.parents == nil || len(node.parents) <= 0 {
@@ -351,6 +374,21 @@ func (node *blockNode) GetMainParent(b *BlockChain) *blockNode {
 	return b.index.lookupNode(mainParent.GetHash())
 }
 
+// mainAncestor returns the ancestor block node at the provided height by
+// following the chain of main parents from this node.  The returned block will
+// be nil when a height is requested that is after the height of the passed
+// node.
+func (node *blockNode) mainAncestor(b *BlockChain, height uint) *blockNode {
+	if height > node.height {
+		return nil
+	}
+	n := node
+	for n != nil && n.height > height {
+		n = n.GetMainParent(b)
+	}
+	return n
+}
+
 func (node *blockNode) GetStatus() blockStatus {
 	return node.status
 }
@@ -3,7 +3,7 @@ package blockchain
 
 import (
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 )
 
 const (
@@ -48,6 +48,18 @@ const (
 	MaxCoinbaseScriptLen = 100
 )
 
+const (
+	// StateRootBlockVersion is the block version flag which indicates the
+	// StateRoot of the block header commits to the utxo set of its main
+	// parent.  Blocks setting the flag have the commitment enforced.
+	StateRootBlockVersion uint32 = 1 << 28
+
+	// blockVersionFlagsMask is the mask of the block version bits which are
+	// used to signal rules and are not part of the base block version.
+	blockVersionFlagsMask = StateRootBlockVersion | vbTopMask | vbBitsMask |
+		types.CycleProofBlockVersion
+)
+
 var (
 	// zeroHash is the zero value for a hash.Hash and is defined as a
 	// package level variable to avoid the need to create a new instance
@@ -5,9 +5,10 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/ecc/secp256k1"
 	"github.com/Qitmeer/qitmeer/database"
 	"math/big"
 	"time"
@@ -307,6 +308,12 @@ func (b *BlockChain) createChainState() error {
 			return err
 		}
 
+		// Create the bucket that houses the utxo set commitments.
+		_, err = meta.CreateBucket(dbnamespace.UtxoStateBucketName)
+		if err != nil {
+			return err
+		}
+
 		// Add the genesis block to the block index.
 		ib := b.bd.GetBlock(&node.hash)
 		ib.SetStatus(blockdag.BlockStatus(node.status))
@@ -341,6 +348,16 @@ func (b *BlockChain) createChainState() error {
 			return err
 		}
 
+		// Commit to the genesis utxo set.
+		ms := secp256k1.NewMultiset(secp256k1.S256())
+		for _, tx := range genesisBlock.Transactions() {
+			addTxOutsToUtxoState(ms, tx, genesisBlock.Hash())
+		}
+		err = dbPutUtxoState(dbTx, genesisBlock.Hash(), ms, ms)
+		if err != nil {
+			return err
+		}
+
 		// Store the genesis block into the database.
 		return dbTx.StoreBlock(genesisBlock)
 	})
@@ -573,6 +590,33 @@ func dbFetchHeaderByHash(dbTx database.Tx, hash *hash.Hash) (*types.BlockHeader,
 	return &header, nil
 }
 
+// dbPutBlockWork uses an existing database transaction to store the work sum
+// of the passed node, which can't be derived from its header alone once the
+// nodes of its parents have been pruned.
+func dbPutBlockWork(dbTx database.Tx, node *blockNode) error {
+	bucket, err := dbTx.Metadata().CreateBucketIfNotExists(
+		dbnamespace.BlockWorkBucketName)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(node.hash[:], node.workSum.Bytes())
+}
+
+// dbFetchBlockWork uses an existing database transaction to retrieve the work
+// sum of the block with the provided hash, which is stored when its node is
+// pruned.
+func dbFetchBlockWork(dbTx database.Tx, hash *hash.Hash) (*big.Int, error) {
+	var serialized []byte
+	bucket := dbTx.Metadata().Bucket(dbnamespace.BlockWorkBucketName)
+	if bucket != nil {
+		serialized = bucket.Get(hash[:])
+	}
+	if serialized == nil {
+		return nil, fmt.Errorf("no work sum stored for block %s", hash)
+	}
+	return new(big.Int).SetBytes(serialized), nil
+}
+
 // dbFetchHeaderByHeight uses an existing database transaction to retrieve the
 // block header for the provided height.
 func dbFetchHeaderByHeight(dbTx database.Tx, height uint64) (*types.BlockHeader, error) {
@@ -9,6 +9,7 @@ package blockchain
 import (
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/params"
 	"math/big"
 	"time"
 )
@@ -205,32 +206,69 @@ func (b *BlockChain) calcEasiestDifficulty(bits uint32, duration time.Duration)
 	return BigToCompact(newTarget)
 }
 
+// HeaderNode is a block header placed in the block DAG.  It provides what the
+// difficulty retarget and past median time rules need to know about a block,
+// so the light nodes which only keep the headers enforce the same rules as the
+// chain.
+type HeaderNode interface {
+	// Bits returns the difficulty bits of the header.
+	Bits() uint32
+
+	// Timestamp returns the unix timestamp of the header.
+	Timestamp() int64
+
+	// Order returns the order of the block in the DAG.
+	Order() uint
+
+	// Height returns the height of the block on the chain of main parents.
+	Height() uint
+
+	// MainParent returns the node of the main parent of the block, or nil
+	// for the genesis block.
+	MainParent() HeaderNode
+}
+
+// chainHeaderNode adapts a block node of the chain to the HeaderNode
+// interface.
+type chainHeaderNode struct {
+	b    *BlockChain
+	node *blockNode
+}
+
+func (n chainHeaderNode) Bits() uint32     { return n.node.bits }
+func (n chainHeaderNode) Timestamp() int64 { return n.node.timestamp }
+func (n chainHeaderNode) Order() uint      { return uint(n.node.order) }
+
+func (n chainHeaderNode) Height() uint {
+	return n.b.bd.GetBlock(n.node.GetHash()).GetHeight()
+}
+
+func (n chainHeaderNode) MainParent() HeaderNode {
+	mainParent := n.node.GetMainParent(n.b)
+	if mainParent == nil {
+		return nil
+	}
+	return chainHeaderNode{n.b, mainParent}
+}
+
 // findPrevTestNetDifficulty returns the difficulty of the previous block which
 // did not have the special testnet minimum difficulty rule applied.
-//
-// This function MUST be called with the chain state lock held (for writes).
-func (b *BlockChain) findPrevTestNetDifficulty(startNode *blockNode) uint32 {
+func findPrevTestNetDifficulty(par *params.Params, startNode HeaderNode) uint32 {
 	// Search backwards through the chain for the last block without
 	// the special rule applied.
-	blocksPerRetarget := uint64(b.params.WorkDiffWindowSize *
-		b.params.WorkDiffWindows)
-	iterBlock := b.bd.GetBlock(startNode.GetHash())
-	var iterNode *blockNode
-	for {
-		if iterBlock == nil ||
-			uint64(iterBlock.GetHeight())%blocksPerRetarget == 0 {
-			break
-		}
-		iterNode = b.index.lookupNode(iterBlock.GetHash())
-		if iterNode.bits != b.params.PowLimitBits {
-			break
-		}
+	blocksPerRetarget := uint64(par.WorkDiffWindowSize * par.WorkDiffWindows)
+	iterNode := startNode
+	for iterNode != nil && uint64(iterNode.Height())%blocksPerRetarget != 0 &&
+		iterNode.Bits() == par.PowLimitBits {
+
+		iterNode = iterNode.MainParent()
 	}
+
 	// Return the found difficulty or the minimum difficulty if no
 	// appropriate block was found.
-	lastBits := b.params.PowLimitBits
+	lastBits := par.PowLimitBits
 	if iterNode != nil {
-		lastBits = iterNode.bits
+		lastBits = iterNode.Bits()
 	}
 	return lastBits
 }
@@ -245,33 +283,45 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 	if curNode == nil {
 		return b.params.PowLimitBits, nil
 	}
+	return NextRequiredDifficulty(b.params, chainHeaderNode{b, curNode},
+		newBlockTime), nil
+}
+
+// NextRequiredDifficulty calculates the required difficulty for the block with
+// the passed time whose main parent is the passed node, based on the
+// difficulty retarget rules.
+func NextRequiredDifficulty(par *params.Params, curNode HeaderNode, newBlockTime time.Time) uint32 {
+	// Genesis block.
+	if curNode == nil {
+		return par.PowLimitBits
+	}
 	// Get the old difficulty; if we aren't at a block height where it changes,
 	// just return this.
-	oldDiff := curNode.bits
-	oldDiffBig := CompactToBig(curNode.bits)
-	curBlock := b.bd.GetBlock(curNode.GetHash())
+	oldDiff := curNode.Bits()
+	oldDiffBig := CompactToBig(curNode.Bits())
+	curHeight := curNode.Height()
 	// We're not at a retarget point, return the oldDiff.
-	if int64(curBlock.GetHeight()+1)%b.params.WorkDiffWindowSize != 0 {
+	if int64(curHeight+1)%par.WorkDiffWindowSize != 0 {
 		// For networks that support it, allow special reduction of the
 		// required difficulty once too much time has elapsed without
 		// mining a block.
-		if b.params.ReduceMinDifficulty {
+		if par.ReduceMinDifficulty {
 			// Return minimum difficulty when more than the desired
 			// amount of time has elapsed without mining a block.
-			reductionTime := int64(b.params.MinDiffReductionTime /
+			reductionTime := int64(par.MinDiffReductionTime /
 				time.Second)
-			allowMinTime := curNode.timestamp + reductionTime
+			allowMinTime := curNode.Timestamp() + reductionTime
 
 			// For every extra target timespan that passes, we halve the
 			// difficulty.
 			if newBlockTime.Unix() > allowMinTime {
-				timePassed := newBlockTime.Unix() - curNode.timestamp
+				timePassed := newBlockTime.Unix() - curNode.Timestamp()
 				timePassed -= reductionTime
-				shifts := uint((timePassed / int64(b.params.TargetTimePerBlock/
+				shifts := uint((timePassed / int64(par.TargetTimePerBlock/
 					time.Second)) + 1)
 
 				// Scale the difficulty with time passed.
-				oldTarget := CompactToBig(curNode.bits)
+				oldTarget := CompactToBig(curNode.Bits())
 				newTarget := new(big.Int)
 				if shifts < maxShift {
 					newTarget.Lsh(oldTarget, shifts)
@@ -280,62 +330,62 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 				}
 
 				// Limit new value to the proof of work limit.
-				if newTarget.Cmp(b.params.PowLimit) > 0 {
-					newTarget.Set(b.params.PowLimit)
+				if newTarget.Cmp(par.PowLimit) > 0 {
+					newTarget.Set(par.PowLimit)
 				}
 
-				return BigToCompact(newTarget), nil
+				return BigToCompact(newTarget)
 			}
 
 			// The block was mined within the desired timeframe, so
 			// return the difficulty for the last block which did
 			// not have the special minimum difficulty rule applied.
-			return b.findPrevTestNetDifficulty(curNode), nil
+			return findPrevTestNetDifficulty(par, curNode)
 		}
 
-		return oldDiff, nil
+		return oldDiff
 	}
 
 	// Declare some useful variables.
-	RAFBig := big.NewInt(b.params.RetargetAdjustmentFactor)
-	nextDiffBigMin := CompactToBig(curNode.bits)
+	RAFBig := big.NewInt(par.RetargetAdjustmentFactor)
+	nextDiffBigMin := CompactToBig(curNode.Bits())
 	nextDiffBigMin.Div(nextDiffBigMin, RAFBig)
-	nextDiffBigMax := CompactToBig(curNode.bits)
+	nextDiffBigMax := CompactToBig(curNode.Bits())
 	nextDiffBigMax.Mul(nextDiffBigMax, RAFBig)
 
-	alpha := b.params.WorkDiffAlpha
+	alpha := par.WorkDiffAlpha
 
 	// Number of nodes to traverse while calculating difficulty.
-	nodesToTraverse := (b.params.WorkDiffWindowSize *
-		b.params.WorkDiffWindows)
+	nodesToTraverse := (par.WorkDiffWindowSize *
+		par.WorkDiffWindows)
 
 	// Initialize bigInt slice for the percentage changes for each window period
 	// above or below the target.
-	windowChanges := make([]*big.Int, b.params.WorkDiffWindows)
+	windowChanges := make([]*big.Int, par.WorkDiffWindows)
 
 	// Regress through all of the previous blocks and store the percent changes
 	// per window period; use bigInts to emulate 64.32 bit fixed point.
 	var olderTime, windowPeriod int64
 	var weights uint64
 	oldNode := curNode
-	recentTime := curNode.timestamp
+	recentTime := curNode.Timestamp()
 
 	for i := uint64(0); ; i++ {
 		// Store and reset after reaching the end of every window period.
-		if i%uint64(b.params.WorkDiffWindowSize) == 0 && i != 0 {
-			olderTime = oldNode.timestamp
+		if i%uint64(par.WorkDiffWindowSize) == 0 && i != 0 {
+			olderTime = oldNode.Timestamp()
 			timeDifference := recentTime - olderTime
 
 			// Just assume we're at the target (no change) if we've
 			// gone all the way back to the genesis block.
-			if oldNode.order == 0 {
-				timeDifference = int64(b.params.TargetTimespan /
+			if oldNode.Order() == 0 {
+				timeDifference = int64(par.TargetTimespan /
 					time.Second)
 			}
 
 			timeDifBig := big.NewInt(timeDifference)
 			timeDifBig.Lsh(timeDifBig, 32) // Add padding
-			targetTemp := big.NewInt(int64(b.params.TargetTimespan /
+			targetTemp := big.NewInt(int64(par.TargetTimespan /
 				time.Second))
 
 			windowAdjusted := targetTemp.Div(timeDifBig, targetTemp)
@@ -343,10 +393,10 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 			// Weight it exponentially. Be aware that this could at some point
 			// overflow if alpha or the number of blocks used is really large.
 			windowAdjusted = windowAdjusted.Lsh(windowAdjusted,
-				uint((b.params.WorkDiffWindows-windowPeriod)*alpha))
+				uint((par.WorkDiffWindows-windowPeriod)*alpha))
 
 			// Sum up all the different weights incrementally.
-			weights += 1 << uint64((b.params.WorkDiffWindows-windowPeriod)*
+			weights += 1 << uint64((par.WorkDiffWindows-windowPeriod)*
 				alpha)
 
 			// Store it in the slice.
@@ -363,18 +413,14 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 
 		// Get the previous node while staying at the genesis block as
 		// needed.
-		if oldNode.parents != nil {
-			oldBlock := b.bd.GetBlock(oldNode.GetHash())
-			oldMainParent := b.bd.GetBlock(oldBlock.GetMainParent())
-			if oldMainParent != nil {
-				oldNode = b.index.lookupNode(oldMainParent.GetHash())
-			}
+		if mainParent := oldNode.MainParent(); mainParent != nil {
+			oldNode = mainParent
 		}
 	}
 
 	// Sum up the weighted window periods.
 	weightedSum := big.NewInt(0)
-	for i := int64(0); i < b.params.WorkDiffWindows; i++ {
+	for i := int64(0); i < par.WorkDiffWindows; i++ {
 		weightedSum.Add(weightedSum, windowChanges[i])
 	}
 
@@ -394,7 +440,7 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 	if oldDiffBig.Cmp(bigZero) == 0 { // This should never really happen,
 		nextDiffBig.Set(nextDiffBig) // but in case it does...
 	} else if nextDiffBig.Cmp(bigZero) == 0 {
-		nextDiffBig.Set(b.params.PowLimit)
+		nextDiffBig.Set(par.PowLimit)
 	} else if nextDiffBig.Cmp(nextDiffBigMax) == 1 {
 		nextDiffBig.Set(nextDiffBigMax)
 	} else if nextDiffBig.Cmp(nextDiffBigMin) == -1 {
@@ -402,8 +448,8 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 	}
 
 	// Limit new value to the proof of work limit.
-	if nextDiffBig.Cmp(b.params.PowLimit) > 0 {
-		nextDiffBig.Set(b.params.PowLimit)
+	if nextDiffBig.Cmp(par.PowLimit) > 0 {
+		nextDiffBig.Set(par.PowLimit)
 	}
 
 	// Log new target difficulty and return it.  The new target logging is
@@ -411,13 +457,13 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 	// newTarget since conversion to the compact representation loses
 	// precision.
 	nextDiffBits := BigToCompact(nextDiffBig)
-	log.Debug("Difficulty retarget", "block main height", curBlock.GetHeight()+1)
-	log.Debug("Old target", "bits", fmt.Sprintf("%08x", curNode.bits),
+	log.Debug("Difficulty retarget", "block main height", curHeight+1)
+	log.Debug("Old target", "bits", fmt.Sprintf("%08x", curNode.Bits()),
 		"diff", fmt.Sprintf("(%064x)", oldDiffBig))
 	log.Debug("New target", "bits", fmt.Sprintf("%08x", nextDiffBits),
 		"diff", fmt.Sprintf("(%064x)", CompactToBig(nextDiffBits)))
 
-	return nextDiffBits, nil
+	return nextDiffBits
 }
 
 // CalcNextRequiredDiffFromNode calculates the required difficulty for the block
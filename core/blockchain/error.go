@@ -317,6 +317,19 @@ const (
 
 	// ErrMissingCoinbaseHeight
 	ErrMissingCoinbaseHeight
+
+	// ErrBadStateRoot indicates the state root of a block does not match
+	// the commitment to the utxo set of its main parent.
+	ErrBadStateRoot
+
+	// ErrMissingStateRoot indicates a block does not signal the commitment
+	// to the utxo set in its version although the state root deployment is
+	// active.
+	ErrMissingStateRoot
+
+	// ErrBadCycleProof indicates the cycle nonces of a block header are
+	// missing, unexpected or do not form a cycle of the cuckoo graph.
+	ErrBadCycleProof
 )
 
 // Map of ErrorCode values back to their constant names for pretty printing.
@@ -383,6 +396,9 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrInvalidAncestorBlock:   "ErrInvalidAncestorBlock",
 	ErrInvalidTemplateParent:  "ErrInvalidTemplateParent",
 	ErrMissingCoinbaseHeight:  "ErrMissingCoinbaseHeight",
+	ErrBadStateRoot:           "ErrBadStateRoot",
+	ErrMissingStateRoot:       "ErrMissingStateRoot",
+	ErrBadCycleProof:          "ErrBadCycleProof",
 }
 
 // String returns the ErrorCode as a human-readable name.
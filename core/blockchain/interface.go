@@ -2,7 +2,7 @@ package blockchain
 
 import (
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 )
 
 type TxManager interface {
@@ -15,6 +15,14 @@ type TxManager interface {
 	AddInvalidTx(txh *hash.Hash, bh *hash.Hash)
 
 	MemPool() TxPool
+
+	FeeEstimator() FeeEstimator
+}
+
+// FeeEstimator learns the fee rates which get the transactions confirmed from
+// the blocks connected to the main chain.
+type FeeEstimator interface {
+	RegisterBlock(block *types.SerializedBlock)
 }
 
 type TxPool interface {
@@ -37,3 +45,10 @@ type TxPool interface {
 
 	ProcessTransaction(tx *types.Tx, allowOrphan, rateLimit, allowHighFees bool) ([]*types.Tx, error)
 }
+
+// PowVerifier verifies the proof of work of the block headers according to the
+// proof of work algorithm of the chain.  The chain is nil when a light node
+// verifies the headers it syncs on their own.
+type PowVerifier interface {
+	Verify(chain *BlockChain, header *types.BlockHeader) error
+}
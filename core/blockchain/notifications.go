@@ -10,7 +10,7 @@ import (
 	"fmt"
 
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 )
 
 // NotificationType represents the type of a notification message.
@@ -74,13 +74,26 @@ type BlockAcceptedNotifyData struct {
 	Block *types.SerializedBlock
 }
 
+// BlockOrder is the DAG order of a block.
+type BlockOrder struct {
+	Hash  hash.Hash
+	Order uint64
+}
+
 // ReorganizationNotifyData is the structure for data indicating information
-// about a reorganization.
+// about a reorganization, which is when a new block changes the DAG order of
+// the blocks already ordered.
 type ReorganizationNotifyData struct {
-	OldHash   hash.Hash
-	OldHeight uint64
-	NewHash   hash.Hash
-	NewHeight uint64
+	// NewHash is the hash of the block which caused the reorganization.
+	NewHash hash.Hash
+
+	// Detached are the blocks which were disconnected along with the orders
+	// they had before the reorganization.
+	Detached []BlockOrder
+
+	// Attached are the blocks which were connected, including the new
+	// block, along with their new orders.
+	Attached []BlockOrder
 }
 
 // Notification defines notification that is sent to the caller via the callback
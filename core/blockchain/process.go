@@ -8,7 +8,7 @@ package blockchain
 import (
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"time"
 )
 
@@ -113,7 +113,7 @@ func (b *BlockChain) ProcessBlock(block *types.SerializedBlock, flags BehaviorFl
 	}
 
 	// Perform preliminary sanity checks on the block and its transactions.
-	err := checkBlockSanity(block, b.timeSource, flags, b.params)
+	err := b.checkBlockSanity(block, flags)
 	if err != nil {
 		return false, false, err
 	}
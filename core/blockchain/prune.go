@@ -2,26 +2,123 @@
 package blockchain
 
 import (
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/params"
 	"time"
 )
 
-// pruningIntervalInMinutes is the interval in which to prune the blockchain's
-// nodes and restore memory to the garbage collector.
-const pruningIntervalInMinutes = 5
+const (
+	// pruningIntervalInMinutes is the interval in which to prune the
+	// blockchain's nodes and restore memory to the garbage collector.
+	pruningIntervalInMinutes = 5
+
+	// pruneDepthMargin is the number of blocks kept on top of the deepest
+	// lookback of the consensus rules.  Blocks this far below the stable
+	// order are not expected to be touched by a reorganization, and their
+	// nodes are reloaded from the database in case they are.
+	pruneDepthMargin = blockdag.StableConfirmations * 100
+)
+
+// calcPruneDepth returns the number of blocks below the stable DAG order which
+// are kept in memory and whose data is kept in the database.  It covers the
+// deepest lookback of the consensus rules, that is the difficulty retarget
+// window, the median time and the previous and current threshold windows of
+// the rule change deployments, plus a margin.
+func calcPruneDepth(params *params.Params) uint64 {
+	depth := uint64(medianTimeBlocks)
+	diffWindow := uint64(params.WorkDiffWindowSize * params.WorkDiffWindows)
+	if diffWindow > depth {
+		depth = diffWindow
+	}
+	thresholdWindows := 2 * uint64(params.MinerConfirmationWindow)
+	if thresholdWindows > depth {
+		depth = thresholdWindows
+	}
+	return depth + pruneDepthMargin
+}
 
 // chainPruner is used to occasionally prune the blockchain of old nodes that
 // can be freed to the garbage collector.
 type chainPruner struct {
 	chain              *BlockChain
 	lastNodeInsertTime time.Time
+
+	// pruneDepth is the number of blocks below the stable DAG order which
+	// are not pruned.
+	pruneDepth uint64
+
+	// pruneBlockData specifies whether the data of pruned blocks is deleted
+	// from the database as well.
+	pruneBlockData bool
 }
 
 // newChainPruner returns a new chain pruner.
-func newChainPruner(chain *BlockChain) *chainPruner {
+func newChainPruner(chain *BlockChain, pruneBlockData bool) *chainPruner {
 	return &chainPruner{
 		chain:              chain,
 		lastNodeInsertTime: time.Now(),
+		pruneDepth:         calcPruneDepth(chain.params),
+		pruneBlockData:     pruneBlockData,
+	}
+}
+
+// pruneOrder returns the DAG order below which blocks are pruned.  It returns
+// zero when nothing may be pruned yet.
+//
+// This function MUST be called with the chainLock held (for reads).
+func (c *chainPruner) pruneOrder() uint64 {
+	mainOrder := uint64(c.chain.bd.GetMainChainTip().GetOrder())
+	if mainOrder <= blockdag.StableConfirmations+c.pruneDepth {
+		return 0
 	}
+	return mainOrder - blockdag.StableConfirmations - c.pruneDepth
+}
+
+// pruneChain removes the nodes of the blocks below the prune order from the
+// block index and, when enabled, deletes the data of those blocks from the
+// database.
+//
+// This function MUST be called with the chainLock held (for writes).
+func (c *chainPruner) pruneChain() error {
+	order := c.pruneOrder()
+	if order == 0 {
+		return nil
+	}
+
+	// Make sure no status changes of the nodes to prune are pending.
+	b := c.chain
+	err := b.index.flushToDB(b.bd)
+	if err != nil {
+		return err
+	}
+	pruned, err := b.index.pruneNodes(order)
+	if err != nil {
+		return err
+	}
+	prunedBlocks := b.bd.PruneBlocks(uint(order))
+	log.Debug("Pruned block nodes", "count", pruned, "blocks",
+		len(prunedBlocks), "order", order)
+
+	if !c.pruneBlockData {
+		return nil
+	}
+	return b.db.Update(func(dbTx database.Tx) error {
+		hashes, err := dbTx.PruneBlocks(func(h *hash.Hash) bool {
+			ib := b.bd.GetBlock(h)
+			return ib != nil && ib.IsOrdered() &&
+				uint64(ib.GetOrder()) < order
+		})
+		if err != nil {
+			return err
+		}
+		if len(hashes) > 0 {
+			log.Info("Pruned block data", "count", len(hashes),
+				"order", order)
+		}
+		return nil
+	})
 }
 
 // pruneChainIfNeeded checks the current time versus the time of the last pruning.
@@ -35,4 +132,9 @@ func (c *chainPruner) pruneChainIfNeeded() {
 		return
 	}
 	c.lastNodeInsertTime = now
+
+	err := c.pruneChain()
+	if err != nil {
+		log.Warn("Failed to prune the chain", "error", err)
+	}
 }
@@ -0,0 +1,180 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package blockchain
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+// TestCalcPruneDepth ensures the prune depth covers the lookback of the
+// consensus rules on every network.
+func TestCalcPruneDepth(t *testing.T) {
+	for _, p := range []*params.Params{&params.MainNetParams,
+		&params.TestNetParams, &params.PrivNetParams} {
+
+		depth := calcPruneDepth(p)
+		diffWindow := uint64(p.WorkDiffWindowSize * p.WorkDiffWindows)
+		thresholdWindows := 2 * uint64(p.MinerConfirmationWindow)
+		if depth < diffWindow+pruneDepthMargin ||
+			depth < thresholdWindows+pruneDepthMargin ||
+			depth < medianTimeBlocks+pruneDepthMargin {
+			t.Errorf("%s: prune depth %d does not cover the lookback",
+				p.Name, depth)
+		}
+	}
+}
+
+// TestPruneNodes ensures the nodes pruned from the block index and the blocks
+// pruned from the DAG are reconstructed with the same height, work sum, median
+// time and main ancestors, and so are the nodes left in the index whose
+// ancestors were pruned.
+func TestPruneNodes(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "blockchain-prune")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	par := &params.PrivNetParams
+	db, err := database.Create("ffldb", dbPath, par.Net)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer db.Close()
+	err = db.Update(func(dbTx database.Tx) error {
+		_, err := dbTx.Metadata().CreateBucket(
+			dbnamespace.BlockIndexBucketName)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+
+	var bd blockdag.BlockDAG
+	bd.Init(blockdag.GetDAGTypeByIndex(0))
+	bd.SetDB(db)
+	b := &BlockChain{bd: &bd, db: db, params: par}
+	b.index = newBlockIndex(db, par, &bd)
+
+	// Every block is stored with its header, so the pruned nodes can be
+	// reconstructed.
+	var hashes []*hash.Hash
+	addBlock := func(nonce uint64, parents ...*blockNode) *blockNode {
+		header := types.BlockHeader{
+			Version:    1,
+			Difficulty: par.PowLimitBits - uint32(nonce%3),
+			Timestamp:  time.Unix(1568000000+int64(nonce*nonce%97), 0),
+			Nonce:      nonce,
+		}
+		block := &types.Block{Header: header}
+		dagBlock := &testDAGBlock{hash: header.BlockHash()}
+		for _, parent := range parents {
+			block.Parents = append(block.Parents, parent.GetHash())
+			dagBlock.parents = append(dagBlock.parents,
+				parent.GetHash())
+		}
+		err := db.Update(func(dbTx database.Tx) error {
+			return dbTx.StoreBlock(types.NewBlock(block))
+		})
+		if err != nil {
+			t.Fatalf("StoreBlock: %v", err)
+		}
+		if l := bd.AddBlock(dagBlock); l == nil || l.Len() == 0 {
+			t.Fatalf("unable to add block %v", dagBlock.hash)
+		}
+		node := newBlockNode(&header, parents)
+		node.setDAGState(bd.GetBlock(node.GetHash()))
+		b.index.AddNode(node)
+		hashes = append(hashes, node.GetHash())
+		return node
+	}
+
+	// A chain whose every fifth block merges a sibling of its parent, so
+	// the work sums add up the work of several parents.
+	tip := addBlock(0)
+	for i := uint64(1); i < 60; i++ {
+		if i%5 != 0 {
+			tip = addBlock(i, tip)
+			continue
+		}
+		sibling := addBlock(1000+i, tip.GetMainParent(b))
+		tip = addBlock(i, tip, sibling)
+	}
+	if err := b.index.flushToDB(&bd); err != nil {
+		t.Fatalf("flushToDB: %v", err)
+	}
+
+	// The orders have settled once every block is added.
+	type nodeState struct {
+		height     uint
+		order      uint64
+		workSum    *big.Int
+		medianTime time.Time
+		ancestor   hash.Hash
+	}
+	nodeStateOf := func(node *blockNode) nodeState {
+		return nodeState{
+			height:     node.GetHeight(),
+			order:      node.GetOrder(),
+			workSum:    node.workSum,
+			medianTime: node.CalcPastMedianTime(b),
+			ancestor:   node.mainAncestor(b, node.GetHeight()/2).hash,
+		}
+	}
+	want := make(map[hash.Hash]nodeState)
+	for _, h := range hashes {
+		node := b.index.LookupNode(h)
+		node.setDAGState(bd.GetBlock(h))
+		want[*h] = nodeStateOf(node)
+	}
+
+	const pruneOrder = 40
+	numPruned, err := b.index.pruneNodes(pruneOrder)
+	if err != nil {
+		t.Fatalf("pruneNodes: %v", err)
+	}
+	prunedBlocks := bd.PruneBlocks(pruneOrder)
+	if numPruned != pruneOrder-1 || len(prunedBlocks) != pruneOrder-1 {
+		t.Fatalf("pruned %d nodes and %d blocks, want %d", numPruned,
+			len(prunedBlocks), pruneOrder-1)
+	}
+	for _, h := range prunedBlocks {
+		if _, ok := b.index.index[*h]; ok {
+			t.Fatalf("node %v was not pruned", h)
+		}
+		if !bd.HasBlock(h) {
+			t.Fatalf("pruned block %v is unknown", h)
+		}
+	}
+
+	// The nodes left in the index come first, so their ancestors are
+	// reconstructed through them, and then the pruned nodes.
+	for i := len(hashes); i > 0; i-- {
+		h := hashes[i-1]
+		node := b.index.LookupNode(h)
+		if node == nil {
+			t.Fatalf("node %v not found", h)
+		}
+		if got := nodeStateOf(node); got.height != want[*h].height ||
+			got.order != want[*h].order ||
+			got.workSum.Cmp(want[*h].workSum) != 0 ||
+			!got.medianTime.Equal(want[*h].medianTime) ||
+			got.ancestor != want[*h].ancestor {
+			t.Errorf("node %v: got %+v, want %+v", h, got, want[*h])
+		}
+		if b.index.LookupNode(h) != node {
+			t.Errorf("node %v is not cached", h)
+		}
+	}
+}
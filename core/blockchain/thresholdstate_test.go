@@ -0,0 +1,249 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2016-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// TestThresholdStateStringer tests the stringized output for the
+// ThresholdState type.
+func TestThresholdStateStringer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   ThresholdState
+		want string
+	}{
+		{ThresholdDefined, "ThresholdDefined"},
+		{ThresholdStarted, "ThresholdStarted"},
+		{ThresholdLockedIn, "ThresholdLockedIn"},
+		{ThresholdActive, "ThresholdActive"},
+		{ThresholdFailed, "ThresholdFailed"},
+		{0xff, "Unknown ThresholdState (255)"},
+	}
+
+	// Detect additional threshold states that don't have the stringer added.
+	if len(tests)-1 != int(numThresholdsStates) {
+		t.Errorf("It appears a threshold state was added without " +
+			"adding an associated stringer test")
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestThresholdStateCache ensure the threshold state cache works as intended
+// including adding entries, updating existing entries, and clearing them.
+func TestThresholdStateCache(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		numEntries int
+		state      ThresholdState
+	}{
+		{name: "2 entries defined", numEntries: 2, state: ThresholdDefined},
+		{name: "7 entries started", numEntries: 7, state: ThresholdStarted},
+		{name: "10 entries active", numEntries: 10, state: ThresholdActive},
+		{name: "5 entries locked in", numEntries: 5, state: ThresholdLockedIn},
+		{name: "3 entries failed", numEntries: 3, state: ThresholdFailed},
+	}
+
+nextTest:
+	for _, test := range tests {
+		cache := &newThresholdCaches(1)[0]
+		for i := 0; i < test.numEntries; i++ {
+			var hash hash.Hash
+			hash[0] = uint8(i + 1)
+
+			// Ensure the hash isn't available in the cache already.
+			_, ok := cache.Lookup(&hash)
+			if ok {
+				t.Errorf("Lookup (%s): has entry for hash %v",
+					test.name, hash)
+				continue nextTest
+			}
+
+			// Ensure hash that was added to the cache reports it's
+			// available and the state is the expected value.
+			cache.Update(&hash, test.state)
+			state, ok := cache.Lookup(&hash)
+			if !ok {
+				t.Errorf("Lookup (%s): missing entry for hash "+
+					"%v", test.name, hash)
+				continue nextTest
+			}
+			if state != test.state {
+				t.Errorf("Lookup (%s): state mismatch - got "+
+					"%v, want %v", test.name, state,
+					test.state)
+				continue nextTest
+			}
+
+			// Ensure adding an existing hash with the same state
+			// doesn't break the existing entry.
+			cache.Update(&hash, test.state)
+			state, ok = cache.Lookup(&hash)
+			if !ok {
+				t.Errorf("Lookup (%s): missing entry after "+
+					"second add for hash %v", test.name,
+					hash)
+				continue nextTest
+			}
+			if state != test.state {
+				t.Errorf("Lookup (%s): state mismatch after "+
+					"second add - got %v, want %v",
+					test.name, state, test.state)
+				continue nextTest
+			}
+
+			// Ensure adding an existing hash with a different state
+			// updates the existing entry.
+			newState := ThresholdFailed
+			if newState == test.state {
+				newState = ThresholdStarted
+			}
+			cache.Update(&hash, newState)
+			state, ok = cache.Lookup(&hash)
+			if !ok {
+				t.Errorf("Lookup (%s): missing entry after "+
+					"state change for hash %v", test.name,
+					hash)
+				continue nextTest
+			}
+			if state != newState {
+				t.Errorf("Lookup (%s): state mismatch after "+
+					"state change - got %v, want %v",
+					test.name, state, newState)
+				continue nextTest
+			}
+		}
+
+		// Ensure no entries are left once the cache is cleared.
+		cache.clear()
+		for i := 0; i < test.numEntries; i++ {
+			var hash hash.Hash
+			hash[0] = uint8(i + 1)
+			if _, ok := cache.Lookup(&hash); ok {
+				t.Errorf("Lookup (%s): has entry for hash %v "+
+					"after clear", test.name, hash)
+				continue nextTest
+			}
+		}
+	}
+}
+
+// testThresholdChecker provides a thresholdConditionChecker which is true for
+// the blocks signalling the bit 16 in their version.
+type testThresholdChecker struct{}
+
+func (c testThresholdChecker) BeginTime() uint64                     { return 0 }
+func (c testThresholdChecker) EndTime() uint64                       { return math.MaxUint64 }
+func (c testThresholdChecker) RuleChangeActivationThreshold() uint32 { return 3 }
+func (c testThresholdChecker) MinerConfirmationWindow() uint32       { return 4 }
+func (c testThresholdChecker) Condition(node *blockNode) (bool, error) {
+	return node.blockVersion&(1<<16) != 0, nil
+}
+
+// TestThresholdStatePast ensures the threshold state of a block only depends on
+// the chain of its main parents and not on the blocks of other branches which
+// are ordered before it.
+func TestThresholdStatePast(t *testing.T) {
+	var bd blockdag.BlockDAG
+	bd.Init(blockdag.GetDAGTypeByIndex(0))
+	b := &BlockChain{bd: &bd}
+	b.index = newBlockIndex(nil, nil, &bd)
+
+	addNode := func(parent *blockNode, nonce uint64, vote bool) *blockNode {
+		header := types.BlockHeader{
+			Version:   vbTopBits | 1,
+			Timestamp: time.Unix(1568000000+int64(nonce), 0),
+			Nonce:     nonce,
+		}
+		if vote {
+			header.Version |= 1 << 16
+		}
+		var parents []*blockNode
+		dagBlock := &testDAGBlock{hash: header.BlockHash()}
+		if parent != nil {
+			parents = []*blockNode{parent}
+			dagBlock.parents = []*hash.Hash{parent.GetHash()}
+		}
+		node := newBlockNode(&header, parents)
+		if l := bd.AddBlock(dagBlock); l == nil || l.Len() == 0 {
+			t.Fatalf("unable to add block %v", node.GetHash())
+		}
+		if parent != nil {
+			node.SetHeight(parent.GetHeight() + 1)
+		}
+		b.index.AddNode(node)
+		return node
+	}
+
+	// The first window starts the deployment.  Branch a then votes for it
+	// in every block of the second window, while branch b forks from its
+	// second block and only has two votes.
+	node := addNode(nil, 0, false)
+	for i := uint64(1); i < 6; i++ {
+		node = addNode(node, i, true)
+	}
+	forkNode := node
+	a := node
+	for i := uint64(6); i < 12; i++ {
+		a = addNode(a, i, true)
+	}
+	branchB := forkNode
+	for i := uint64(106); i < 112; i++ {
+		branchB = addNode(branchB, i, false)
+	}
+
+	tests := []struct {
+		name     string
+		prevNode *blockNode
+		want     ThresholdState
+	}{
+		{"first window", forkNode.mainAncestor(b, 2), ThresholdDefined},
+		{"second window", forkNode, ThresholdStarted},
+		{"a third window", a.mainAncestor(b, 7), ThresholdLockedIn},
+		{"a fourth window", a.mainAncestor(b, 11), ThresholdActive},
+		{"b third window", branchB.mainAncestor(b, 7), ThresholdStarted},
+		{"b fourth window", branchB.mainAncestor(b, 11), ThresholdStarted},
+	}
+
+	cache := &newThresholdCaches(1)[0]
+	for pass := 0; pass < 2; pass++ {
+		for _, test := range tests {
+			state, err := b.thresholdState(test.prevNode,
+				testThresholdChecker{}, cache)
+			if err != nil {
+				t.Fatalf("%s: thresholdState: %v", test.name, err)
+			}
+			if state != test.want {
+				t.Errorf("%s (pass %d): got %v, want %v",
+					test.name, pass, state, test.want)
+			}
+		}
+
+		// The cached states have to give the same results.
+		if len(cache.entries) == 0 {
+			t.Fatalf("no threshold states were cached")
+		}
+	}
+}
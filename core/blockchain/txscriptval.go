@@ -11,7 +11,7 @@ import (
 	"math"
 	"runtime"
 
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 )
 
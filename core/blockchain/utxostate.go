@@ -0,0 +1,468 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+package blockchain
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/ecc/secp256k1"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+)
+
+// -----------------------------------------------------------------------------
+// The utxo state is a commitment to the unspent transaction output set as seen
+// by a block, that is after the block and all blocks in its past have been
+// connected.  It is an elliptic curve multiset hash (ECMH) which is updated
+// incrementally by adding every output created by a block and removing every
+// output it spends, so the state of a block never has to be recomputed from the
+// full utxo set.
+//
+// The past of a block consists of its main parent, the past of its main parent
+// and the merge set of the block, which are the blocks of its past in the
+// anticone of the main parent.  Hence the state of a block is the state of its
+// main parent combined with the changes made by the blocks of its merge set and
+// by the block itself.  Since the multiset is commutative, the state only
+// depends on the past of the block and never on the blocks in its anticone or
+// on how the DAG happens to be ordered when the block is connected.  Blocks
+// which are known invalid don't modify the utxo set, so they make no change.
+//
+// The state and the change made by every connected block are stored in the utxo
+// state bucket keyed by the block hash:
+//
+//   <state><delta>
+//
+//   Field      Type                Size
+//   state      secp256k1.Multiset  64 bytes
+//   delta      secp256k1.Multiset  64 bytes
+//
+// Each element of the multiset is serialized as:
+//
+//   <outpoint hash><outpoint index><block hash><coinbase><amount><pkscript>
+//
+//   Field            Type        Size
+//   outpoint hash    hash.Hash   hash.HashSize
+//   outpoint index   uint32      4 bytes
+//   block hash       hash.Hash   hash.HashSize
+//   coinbase         byte        1 byte
+//   amount           uint64      8 bytes
+//   pkscript         []byte      variable
+//
+// The StateRoot of a block which sets StateRootBlockVersion in its header
+// version must be the hash of the utxo state of its main parent.
+// -----------------------------------------------------------------------------
+
+// utxoStateElement returns the serialized unspent output which is hashed onto
+// the curve when the output is added to or removed from the utxo state.
+func utxoStateElement(outpoint *types.TxOutPoint, amount uint64, pkScript []byte, blockHash *hash.Hash, isCoinBase bool) []byte {
+	serialized := make([]byte, hash.HashSize*2+4+1+8+len(pkScript))
+	offset := copy(serialized, outpoint.Hash[:])
+	binary.LittleEndian.PutUint32(serialized[offset:], outpoint.OutIndex)
+	offset += 4
+	offset += copy(serialized[offset:], blockHash[:])
+	if isCoinBase {
+		serialized[offset] = 0x01
+	}
+	offset++
+	binary.LittleEndian.PutUint64(serialized[offset:], amount)
+	offset += 8
+	copy(serialized[offset:], pkScript)
+	return serialized
+}
+
+// addTxOutsToUtxoState adds all outputs in the passed transaction which are
+// not provably unspendable to the utxo state.  It mirrors AddTxOuts on the
+// utxo view.
+func addTxOutsToUtxoState(ms *secp256k1.Multiset, tx *types.Tx, blockHash *hash.Hash) {
+	isCoinBase := tx.Tx.IsCoinBase()
+	prevOut := types.TxOutPoint{Hash: *tx.Hash()}
+	for txOutIdx, txOut := range tx.Tx.TxOut {
+		if txscript.IsUnspendable(txOut.PkScript) {
+			continue
+		}
+		prevOut.OutIndex = uint32(txOutIdx)
+		ms.Add(utxoStateElement(&prevOut, txOut.Amount, txOut.PkScript,
+			blockHash, isCoinBase))
+	}
+}
+
+// connectUtxoStateTransactions updates the passed utxo state the same way
+// connecting the block updates the utxo set.  The stxos must contain an entry
+// for every input of the block as provided by checkConnectBlock or loaded from
+// the spend journal.
+func connectUtxoStateTransactions(ms *secp256k1.Multiset, block *types.SerializedBlock, stxos []SpentTxOut) error {
+	// Sanity check the correct number of stxos are provided.
+	if len(stxos) != countSpentOutputs(block) {
+		return AssertError("connectUtxoStateTransactions called with " +
+			"bad spent transaction out information")
+	}
+
+	stxoIdx := 0
+	for txIdx, tx := range block.Transactions() {
+		if txIdx != 0 {
+			for _, txIn := range tx.Tx.TxIn {
+				stxo := &stxos[stxoIdx]
+				stxoIdx++
+				ms.Remove(utxoStateElement(&txIn.PreviousOut,
+					stxo.Amount, stxo.PkScript, &stxo.BlockHash,
+					stxo.IsCoinBase))
+			}
+		}
+		addTxOutsToUtxoState(ms, tx, block.Hash())
+	}
+	return nil
+}
+
+// disconnectUtxoStateTransactions undoes the changes connectUtxoStateTransactions
+// makes to the passed utxo state.
+func disconnectUtxoStateTransactions(ms *secp256k1.Multiset, block *types.SerializedBlock, stxos []SpentTxOut) error {
+	// Sanity check the correct number of stxos are provided.
+	if len(stxos) != countSpentOutputs(block) {
+		return AssertError("disconnectUtxoStateTransactions called with " +
+			"bad spent transaction out information")
+	}
+
+	stxoIdx := 0
+	for txIdx, tx := range block.Transactions() {
+		isCoinBase := tx.Tx.IsCoinBase()
+		prevOut := types.TxOutPoint{Hash: *tx.Hash()}
+		for txOutIdx, txOut := range tx.Tx.TxOut {
+			if txscript.IsUnspendable(txOut.PkScript) {
+				continue
+			}
+			prevOut.OutIndex = uint32(txOutIdx)
+			ms.Remove(utxoStateElement(&prevOut, txOut.Amount,
+				txOut.PkScript, block.Hash(), isCoinBase))
+		}
+		if txIdx != 0 {
+			for _, txIn := range tx.Tx.TxIn {
+				stxo := &stxos[stxoIdx]
+				stxoIdx++
+				ms.Add(utxoStateElement(&txIn.PreviousOut,
+					stxo.Amount, stxo.PkScript, &stxo.BlockHash,
+					stxo.IsCoinBase))
+			}
+		}
+	}
+	return nil
+}
+
+// utxoStateDelta returns the change the passed block makes to the utxo state,
+// which is empty when the block is invalid.  The stxos must contain an entry
+// for every input of a valid block.
+func utxoStateDelta(block *types.SerializedBlock, stxos []SpentTxOut, invalid bool) (*secp256k1.Multiset, error) {
+	delta := secp256k1.NewMultiset(secp256k1.S256())
+	if invalid {
+		return delta, nil
+	}
+	err := connectUtxoStateTransactions(delta, block, stxos)
+	if err != nil {
+		return nil, err
+	}
+	return delta, nil
+}
+
+// dbFetchUtxoState uses an existing database transaction to fetch the utxo
+// state as of the passed block along with the change the block made to it.
+func dbFetchUtxoState(dbTx database.Tx, blockHash *hash.Hash) (*secp256k1.Multiset, *secp256k1.Multiset, error) {
+	bucket := dbTx.Metadata().Bucket(dbnamespace.UtxoStateBucketName)
+	serialized := bucket.Get(blockHash[:])
+	if serialized == nil {
+		return nil, nil, fmt.Errorf("no utxo state for block %v",
+			blockHash)
+	}
+
+	var state, delta *secp256k1.Multiset
+	err := fmt.Errorf("unexpected length %d", len(serialized))
+	if len(serialized) == secp256k1.MultisetSerializeSize*2 {
+		state, err = secp256k1.ParseMultiset(secp256k1.S256(),
+			serialized[:secp256k1.MultisetSerializeSize])
+		if err == nil {
+			delta, err = secp256k1.ParseMultiset(secp256k1.S256(),
+				serialized[secp256k1.MultisetSerializeSize:])
+		}
+	}
+	if err != nil {
+		return nil, nil, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt utxo state for %v: %v",
+				blockHash, err),
+		}
+	}
+	return state, delta, nil
+}
+
+// dbPutUtxoState uses an existing database transaction to store the utxo state
+// as of the passed block along with the change the block made to it.
+func dbPutUtxoState(dbTx database.Tx, blockHash *hash.Hash, state, delta *secp256k1.Multiset) error {
+	serialized := make([]byte, 0, secp256k1.MultisetSerializeSize*2)
+	serialized = append(serialized, state.Serialize()...)
+	serialized = append(serialized, delta.Serialize()...)
+	bucket := dbTx.Metadata().Bucket(dbnamespace.UtxoStateBucketName)
+	return bucket.Put(blockHash[:], serialized)
+}
+
+// dbRemoveUtxoState uses an existing database transaction to remove the utxo
+// state of the passed block.
+func dbRemoveUtxoState(dbTx database.Tx, blockHash *hash.Hash) error {
+	bucket := dbTx.Metadata().Bucket(dbnamespace.UtxoStateBucketName)
+	return bucket.Delete(blockHash[:])
+}
+
+// utxoStateFetcher returns the utxo state as of a block along with the change
+// the block made to it.
+type utxoStateFetcher func(blockHash *hash.Hash) (*secp256k1.Multiset, *secp256k1.Multiset, error)
+
+// calcPastUtxoState returns the utxo state as of the past of the passed block,
+// that is the state of its main parent combined with the changes made by the
+// blocks of its merge set.  The empty state is returned for the genesis block.
+func calcPastUtxoState(bd *blockdag.BlockDAG, blockHash *hash.Hash, fetch utxoStateFetcher) (*secp256k1.Multiset, error) {
+	ib := bd.GetBlock(blockHash)
+	if ib == nil {
+		return nil, fmt.Errorf("no block %v in the DAG", blockHash)
+	}
+	if !ib.HasParents() {
+		return secp256k1.NewMultiset(secp256k1.S256()), nil
+	}
+
+	mainParent := bd.GetMainParent(ib.GetParents())
+	ms, _, err := fetch(mainParent.GetHash())
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range bd.GetMergeSet(blockHash).List() {
+		_, delta, err := fetch(h)
+		if err != nil {
+			return nil, err
+		}
+		ms.Combine(delta)
+	}
+	return ms, nil
+}
+
+// dbUtxoStateFetcher returns a utxoStateFetcher which fetches the utxo states with the
+// passed database transaction.
+func dbUtxoStateFetcher(dbTx database.Tx) utxoStateFetcher {
+	return func(blockHash *hash.Hash) (*secp256k1.Multiset, *secp256k1.Multiset, error) {
+		return dbFetchUtxoState(dbTx, blockHash)
+	}
+}
+
+// dbConnectUtxoState uses an existing database transaction to calculate and
+// store the utxo state as of the passed block, which is the state as of its
+// past updated with the outputs the block creates and spends.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) dbConnectUtxoState(dbTx database.Tx, node *blockNode, block *types.SerializedBlock, stxos []SpentTxOut) error {
+	delta, err := utxoStateDelta(block, stxos,
+		b.index.NodeStatus(node).KnownInvalid())
+	if err != nil {
+		return err
+	}
+	ms, err := calcPastUtxoState(b.bd, block.Hash(), dbUtxoStateFetcher(dbTx))
+	if err != nil {
+		return err
+	}
+	ms.Combine(delta)
+	return dbPutUtxoState(dbTx, block.Hash(), ms, delta)
+}
+
+// dbInitUtxoState creates the utxo state bucket and builds the utxo state of
+// the blocks in the database.  It is used to upgrade databases which were
+// created before the utxo state was tracked.
+//
+// The utxo set in the database is the state as of the last block in DAG order.
+// The blocks are disconnected from it in reverse DAG order by replaying their
+// spend journal, which yields the state as of every block of the main chain,
+// since the blocks before a main chain block in DAG order are exactly its past.
+// The state of the other blocks is then derived from their past.  The walk
+// stops at the first block whose data was pruned, so the blocks below it get
+// no utxo state.  They are too deep to be the main parent of a new block.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) dbInitUtxoState(dbTx database.Tx, total uint64) error {
+	_, err := dbTx.Metadata().CreateBucket(dbnamespace.UtxoStateBucketName)
+	if err != nil {
+		return err
+	}
+
+	// Load the utxo set as of the last block.
+	ms := secp256k1.NewMultiset(secp256k1.S256())
+	cursor := dbTx.Metadata().Bucket(dbnamespace.UtxoSetBucketName).Cursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		key := cursor.Key()
+		if len(key) <= hash.HashSize {
+			return AssertError("utxo set contains a malformed key")
+		}
+		var outpoint types.TxOutPoint
+		copy(outpoint.Hash[:], key)
+		index, _ := deserializeVLQ(key[hash.HashSize:])
+		outpoint.OutIndex = uint32(index)
+		entry, err := DeserializeUtxoEntry(cursor.Value())
+		if err != nil {
+			return err
+		}
+		ms.Add(utxoStateElement(&outpoint, entry.Amount(),
+			entry.PkScript(), entry.BlockHash(), entry.IsCoinBase()))
+	}
+
+	// Disconnect the blocks in reverse DAG order, storing the state of the
+	// main chain blocks and the change made by every block.
+	lowest := total
+	for order := total; order > 0; order-- {
+		h, err := dbFetchHashByOrder(dbTx, order-1)
+		if err != nil {
+			return err
+		}
+		blockBytes, err := dbTx.FetchBlock(h)
+		if database.IsError(err, database.ErrBlockPruned) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		block, err := types.NewBlockFromBytes(blockBytes)
+		if err != nil {
+			return err
+		}
+
+		// The genesis block spends nothing, so it has no spend journal
+		// entry.
+		var stxos []SpentTxOut
+		ib := b.bd.GetBlock(h)
+		invalid := ib != nil && blockStatus(ib.GetStatus()).KnownInvalid()
+		if order-1 != 0 && !invalid {
+			stxos, err = dbFetchSpendJournalEntry(dbTx, block)
+			if err != nil {
+				return err
+			}
+		}
+		delta, err := utxoStateDelta(block, stxos, invalid)
+		if err != nil {
+			return err
+		}
+		err = dbPutUtxoState(dbTx, h, ms, delta)
+		if err != nil {
+			return err
+		}
+		if !invalid {
+			err = disconnectUtxoStateTransactions(ms, block, stxos)
+			if err != nil {
+				return err
+			}
+		}
+		lowest = order - 1
+	}
+
+	// Derive the state of the blocks off the main chain from their past.
+	fetch := dbUtxoStateFetcher(dbTx)
+	for order := lowest; order < total; order++ {
+		h, err := dbFetchHashByOrder(dbTx, order)
+		if err != nil {
+			return err
+		}
+		if b.bd.IsOnMainChain(h) {
+			continue
+		}
+		_, delta, err := dbFetchUtxoState(dbTx, h)
+		if err != nil {
+			return err
+		}
+		state, err := calcPastUtxoState(b.bd, h, fetch)
+		if err != nil {
+			log.Warn("Unable to build the utxo state", "hash", h,
+				"error", err)
+			err = dbRemoveUtxoState(dbTx, h)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		state.Combine(delta)
+		err = dbPutUtxoState(dbTx, h, state, delta)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbFetchStateRoot uses an existing database transaction to fetch the
+// commitment to the utxo set as of the passed block.
+func dbFetchStateRoot(dbTx database.Tx, blockHash *hash.Hash) (*hash.Hash, error) {
+	ms, _, err := dbFetchUtxoState(dbTx, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	root := hash.Hash(ms.Hash())
+	return &root, nil
+}
+
+// dbCheckStateRoot uses an existing database transaction to ensure a block
+// which signals the utxo commitment in its header version commits to the utxo
+// state of its main parent.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) dbCheckStateRoot(dbTx database.Tx, node *blockNode) error {
+	if node.blockVersion&StateRootBlockVersion == 0 {
+		return nil
+	}
+	mainParent := node.GetMainParent(b)
+	if mainParent == nil {
+		return nil
+	}
+	expected, err := dbFetchStateRoot(dbTx, mainParent.GetHash())
+	if err != nil {
+		return err
+	}
+	if !node.stateRoot.IsEqual(expected) {
+		str := fmt.Sprintf("block state root is invalid - block "+
+			"header indicates %v, but calculated value is %v",
+			node.stateRoot, expected)
+		return ruleError(ErrBadStateRoot, str)
+	}
+	return nil
+}
+
+// StateRoot returns the commitment to the utxo set as of the passed block,
+// that is after the block and all blocks in its past have been connected.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) StateRoot(blockHash *hash.Hash) (*hash.Hash, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	var root *hash.Hash
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		root, err = dbFetchStateRoot(dbTx, blockHash)
+		return err
+	})
+	return root, err
+}
+
+// CalcNextStateRoot returns the state root a block built on the passed parents
+// is required to commit to, which is the utxo state of its main parent.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CalcNextStateRoot(parents []*hash.Hash) (*hash.Hash, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	parentsSet := blockdag.NewHashSet()
+	parentsSet.AddList(parents)
+	mainParent := b.bd.GetMainParent(parentsSet)
+	if mainParent == nil {
+		return nil, fmt.Errorf("Can't find main parent")
+	}
+	var root *hash.Hash
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		root, err = dbFetchStateRoot(dbTx, mainParent.GetHash())
+		return err
+	})
+	return root, err
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package blockchain
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/crypto/ecc/secp256k1"
+)
+
+// testDAGBlock implements the blockdag.IBlockData interface.
+type testDAGBlock struct {
+	hash    hash.Hash
+	parents []*hash.Hash
+}
+
+func (b *testDAGBlock) GetHash() *hash.Hash      { return &b.hash }
+func (b *testDAGBlock) GetParents() []*hash.Hash { return b.parents }
+func (b *testDAGBlock) GetTimestamp() int64      { return 0 }
+
+// testUtxoStateNode tracks the utxo states of the blocks of a DAG the way the
+// chain does, connecting every block in DAG order and reconnecting all of them
+// after a reorganization.
+type testUtxoStateNode struct {
+	bd     blockdag.BlockDAG
+	blocks []*hash.Hash
+	deltas map[hash.Hash]*secp256k1.Multiset
+	states map[hash.Hash]*secp256k1.Multiset
+}
+
+func newTestUtxoStateNode(deltas map[hash.Hash]*secp256k1.Multiset) *testUtxoStateNode {
+	node := &testUtxoStateNode{
+		deltas: deltas,
+		states: make(map[hash.Hash]*secp256k1.Multiset),
+	}
+	node.bd.Init(blockdag.GetDAGTypeByIndex(0))
+	return node
+}
+
+func (n *testUtxoStateNode) fetch(blockHash *hash.Hash) (*secp256k1.Multiset, *secp256k1.Multiset, error) {
+	return n.states[*blockHash].Clone(), n.deltas[*blockHash].Clone(), nil
+}
+
+// addBlock adds the block to the DAG and reconnects every block in the new DAG
+// order.
+func (n *testUtxoStateNode) addBlock(t *testing.T, b *testDAGBlock) {
+	if l := n.bd.AddBlock(b); l == nil || l.Len() == 0 {
+		t.Fatalf("unable to add block %v", b.hash)
+	}
+	n.blocks = append(n.blocks, b.GetHash())
+	sort.Slice(n.blocks, func(i, j int) bool {
+		return n.bd.GetBlock(n.blocks[i]).GetOrder() <
+			n.bd.GetBlock(n.blocks[j]).GetOrder()
+	})
+	n.states = make(map[hash.Hash]*secp256k1.Multiset)
+	for _, h := range n.blocks {
+		ms, err := calcPastUtxoState(&n.bd, h, n.fetch)
+		if err != nil {
+			t.Fatalf("calcPastUtxoState %v: %v", h, err)
+		}
+		ms.Combine(n.deltas[*h])
+		n.states[*h] = ms
+	}
+}
+
+// TestPastUtxoState ensures the utxo state of a block only depends on its past
+// and not on how the DAG was ordered while the state was connected.
+func TestPastUtxoState(t *testing.T) {
+	// Two sibling blocks a and b with a common child c.  Block a is seen
+	// first, so it is ordered right after the genesis block until block b
+	// is ordered before it.
+	genesis := &testDAGBlock{hash: hash.HashH([]byte("genesis"))}
+	a := &testDAGBlock{hash: hash.Hash{0x02},
+		parents: []*hash.Hash{&genesis.hash}}
+	b := &testDAGBlock{hash: hash.Hash{0x01},
+		parents: []*hash.Hash{&genesis.hash}}
+	c := &testDAGBlock{hash: hash.HashH([]byte("c")),
+		parents: []*hash.Hash{&a.hash, &b.hash}}
+
+	deltas := make(map[hash.Hash]*secp256k1.Multiset)
+	for _, block := range []*testDAGBlock{genesis, a, b, c} {
+		delta := secp256k1.NewMultiset(secp256k1.S256())
+		delta.Add(block.hash[:])
+		deltas[block.hash] = delta
+	}
+	stateOf := func(blocks ...*testDAGBlock) *secp256k1.Multiset {
+		ms := secp256k1.NewMultiset(secp256k1.S256())
+		for _, block := range blocks {
+			ms.Add(block.hash[:])
+		}
+		return ms
+	}
+
+	first := newTestUtxoStateNode(deltas)
+	first.addBlock(t, genesis)
+	first.addBlock(t, a)
+	orderA := first.bd.GetBlock(&a.hash).GetOrder()
+	first.addBlock(t, b)
+	if first.bd.GetBlock(&a.hash).GetOrder() == orderA {
+		t.Fatalf("block a was not reordered by its sibling")
+	}
+	first.addBlock(t, c)
+
+	second := newTestUtxoStateNode(deltas)
+	for _, block := range []*testDAGBlock{genesis, b, a, c} {
+		second.addBlock(t, block)
+	}
+
+	tests := []struct {
+		name  string
+		block *testDAGBlock
+		want  *secp256k1.Multiset
+	}{
+		{"genesis", genesis, stateOf(genesis)},
+		{"a", a, stateOf(genesis, a)},
+		{"b", b, stateOf(genesis, b)},
+		{"c", c, stateOf(genesis, a, b, c)},
+	}
+	for _, test := range tests {
+		want := test.want.Hash()
+		if got := first.states[test.block.hash].Hash(); got != want {
+			t.Errorf("%s: first node state %x, want %x", test.name,
+				got, want)
+		}
+		if got := second.states[test.block.hash].Hash(); got != want {
+			t.Errorf("%s: second node state %x, want %x", test.name,
+				got, want)
+		}
+	}
+}
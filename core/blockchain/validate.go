@@ -11,9 +11,10 @@ import (
 	"encoding/hex"
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/merkle"
+	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/params"
 	"math"
@@ -43,10 +44,11 @@ func IsExpired(tx *types.Tx, blockHeight uint64) bool {
 //
 // The flags do not modify the behavior of this function directly, however they
 // are needed to pass along to checkBlockHeaderSanity.
-func checkBlockSanity(block *types.SerializedBlock, timeSource MedianTimeSource, flags BehaviorFlags, chainParams *params.Params) error {
+func (b *BlockChain) checkBlockSanity(block *types.SerializedBlock, flags BehaviorFlags) error {
 	msgBlock := block.Block()
 	header := &msgBlock.Header
-	err := checkBlockHeaderSanity(header, timeSource, flags, chainParams)
+	chainParams := b.params
+	err := b.checkBlockHeaderSanity(header, flags)
 	if err != nil {
 		return err
 	}
@@ -197,12 +199,12 @@ func checkBlockSanity(block *types.SerializedBlock, timeSource MedianTimeSource,
 //
 // The flags do not modify the behavior of this function directly, however they
 // are needed to pass along to checkProofOfWork.
-func checkBlockHeaderSanity(header *types.BlockHeader, timeSource MedianTimeSource, flags BehaviorFlags, chainParams *params.Params) error {
+func (b *BlockChain) checkBlockHeaderSanity(header *types.BlockHeader, flags BehaviorFlags) error {
 
 	// Ensure the proof of work bits in the block header is in min/max
-	// range and the block hash is less than the target value described by
+	// range and the block header carries the proof of work described by
 	// the bits.
-	err := checkProofOfWork(header, chainParams.PowLimit, flags)
+	err := b.checkProofOfWork(header, flags)
 	if err != nil {
 		return err
 	}
@@ -219,7 +221,7 @@ func checkBlockHeaderSanity(header *types.BlockHeader, timeSource MedianTimeSour
 	}
 
 	// Ensure the block time is not too far in the future.
-	maxTimestamp := timeSource.AdjustedTime().Add(time.Second *
+	maxTimestamp := b.timeSource.AdjustedTime().Add(time.Second *
 		MaxTimeOffsetSeconds)
 	if header.Timestamp.After(maxTimestamp) {
 		str := fmt.Sprintf("block timestamp of %v is too far in the "+
@@ -231,13 +233,23 @@ func checkBlockHeaderSanity(header *types.BlockHeader, timeSource MedianTimeSour
 }
 
 // checkProofOfWork ensures the block header bits which indicate the target
-// difficulty is in min/max range and that the block hash is less than the
-// target difficulty as claimed.
+// difficulty is in min/max range and that the header carries the proof of work
+// of the target difficulty as claimed, as verified by the proof of work engine
+// of the chain.
 //
 // The flags modify the behavior of this function as follows:
-//  - BFNoPoWCheck: The check to ensure the block hash is less than the target
+//  - BFNoPoWCheck: The check to ensure the proof of work meets the target
 //    difficulty is not performed.
-func checkProofOfWork(header *types.BlockHeader, powLimit *big.Int, flags BehaviorFlags) error {
+func (b *BlockChain) checkProofOfWork(header *types.BlockHeader, flags BehaviorFlags) error {
+	if flags&BFNoPoWCheck == BFNoPoWCheck {
+		return CheckProofOfWorkLimit(header, b.params.PowLimit)
+	}
+	return b.pow.Verify(b, header)
+}
+
+// CheckProofOfWorkLimit ensures the block header bits which indicate the target
+// difficulty is in min/max range.
+func CheckProofOfWorkLimit(header *types.BlockHeader, powLimit *big.Int) error {
 	// The target difficulty must be larger than zero.
 	target := CompactToBig(header.Difficulty)
 	if target.Sign() <= 0 {
@@ -253,19 +265,6 @@ func checkProofOfWork(header *types.BlockHeader, powLimit *big.Int, flags Behavi
 		return ruleError(ErrUnexpectedDifficulty, str)
 	}
 
-	// The block hash must be less than the claimed target unless the flag
-	// to avoid proof of work checks is set.
-	if flags&BFNoPoWCheck != BFNoPoWCheck {
-		// The block hash must be less than the claimed target.
-		h := header.BlockHash()
-		hashNum := HashToBig(&h)
-		if hashNum.Cmp(target) > 0 {
-			str := fmt.Sprintf("block hash of %064x is higher than"+
-				" expected max of %064x", hashNum, target)
-			return ruleError(ErrHighHash, str)
-		}
-	}
-
 	return nil
 }
 
@@ -675,6 +674,29 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *types.SerializedB
 		return err
 	}
 
+	// Ensure the block signals the commitment to the utxo set once the
+	// state root deployment is active.
+	stateRootActive, err := b.isDeploymentActive(node.GetMainParent(b),
+		params.DeploymentIdStateRoot)
+	if err != nil {
+		return err
+	}
+	if stateRootActive && node.blockVersion&StateRootBlockVersion == 0 {
+		str := fmt.Sprintf("block version %#x does not commit to the "+
+			"utxo set although the %s deployment is active",
+			node.blockVersion, params.DeploymentIdStateRoot)
+		return ruleError(ErrMissingStateRoot, str)
+	}
+
+	// Ensure the block commits to the utxo set of its main parent when it
+	// signals the commitment in its version.
+	err = b.db.View(func(dbTx database.Tx) error {
+		return b.dbCheckStateRoot(dbTx, node)
+	})
+	if err != nil {
+		return err
+	}
+
 	// Check that the coinbase pays the tax, if applicable.
 	// TODO tax pay
 
@@ -1064,7 +1086,7 @@ func (b *BlockChain) CheckConnectBlockTemplate(block *types.SerializedBlock) err
 	// or its parent.
 
 	// Perform context-free sanity checks on the block and its transactions.
-	err := checkBlockSanity(block, b.timeSource, flags, b.params)
+	err := b.checkBlockSanity(block, flags)
 	if err != nil {
 		return err
 	}
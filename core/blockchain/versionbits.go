@@ -0,0 +1,246 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2016-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+const (
+	// vbTopBits defines the bits to set in the version to signal that the
+	// version bits scheme is being used.
+	vbTopBits = 0x20000000
+
+	// vbTopMask is the bitmask to use to determine whether or not the
+	// version bits scheme is in use.
+	vbTopMask = 0xe0000000
+
+	// vbFirstBit is the first bit of the block version which may be used by
+	// a deployment.  The bits below it hold the base block version.
+	vbFirstBit = 16
+
+	// vbNumBits is the total number of bits available for use with the
+	// version bits scheme.  The bit above the last one is the state root
+	// flag.
+	vbNumBits = 12
+
+	// vbBitsMask is the mask of the bits of the block version which may be
+	// used by a deployment.
+	vbBitsMask = ((1 << vbNumBits) - 1) << vbFirstBit
+)
+
+// deploymentChecker provides a thresholdConditionChecker which can be used to
+// test a specific deployment rule.  This is required for properly detecting
+// and activating consensus rule changes.
+type deploymentChecker struct {
+	deployment *params.ConsensusDeployment
+	chain      *BlockChain
+}
+
+// Ensure the deploymentChecker type implements the thresholdConditionChecker
+// interface.
+var _ thresholdConditionChecker = deploymentChecker{}
+
+// BeginTime returns the unix timestamp for the median block time after which
+// voting on a rule change starts (at the next window).
+//
+// This implementation returns the value defined by the specific deployment the
+// checker is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) BeginTime() uint64 {
+	return c.deployment.StartTime
+}
+
+// EndTime returns the unix timestamp for the median block time after which an
+// attempted rule change fails if it has not already been locked in or
+// activated.
+//
+// This implementation returns the value defined by the specific deployment the
+// checker is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) EndTime() uint64 {
+	return c.deployment.ExpireTime
+}
+
+// RuleChangeActivationThreshold is the number of blocks for which the condition
+// must be true in order to lock in a rule change.
+//
+// This implementation returns the value defined by the chain params the checker
+// is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) RuleChangeActivationThreshold() uint32 {
+	return c.chain.params.RuleChangeActivationThreshold
+}
+
+// MinerConfirmationWindow is the number of blocks in each threshold state
+// retarget window.
+//
+// This implementation returns the value defined by the chain params the checker
+// is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) MinerConfirmationWindow() uint32 {
+	return c.chain.params.MinerConfirmationWindow
+}
+
+// Condition returns true when the specific bit defined by the deployment
+// associated with the checker is set.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) Condition(node *blockNode) (bool, error) {
+	conditionMask := uint32(1) << c.deployment.BitNumber
+	version := node.blockVersion
+	return (version&vbTopMask == vbTopBits) && (version&conditionMask != 0),
+		nil
+}
+
+// deployments returns the consensus rule change deployments which are voted on
+// with the block version of the chain.
+func (b *BlockChain) deployments() []params.ConsensusDeployment {
+	return b.params.Deployments[b.BlockVersion]
+}
+
+// Deployments returns the consensus rule change deployments which are voted on
+// with the block version of the chain.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) Deployments() []params.ConsensusDeployment {
+	return b.deployments()
+}
+
+// checkDeployments ensures the deployments of the chain only use the bits of
+// the block version which are reserved for the version bits scheme and have
+// unique ids.
+func (b *BlockChain) checkDeployments() error {
+	ids := make(map[string]struct{})
+	for _, deployment := range b.deployments() {
+		if deployment.BitNumber < vbFirstBit ||
+			deployment.BitNumber >= vbFirstBit+vbNumBits {
+			return fmt.Errorf("deployment %s uses bit %d which is "+
+				"outside of the version bits %d-%d", deployment.Id,
+				deployment.BitNumber, vbFirstBit,
+				vbFirstBit+vbNumBits-1)
+		}
+		if _, ok := ids[deployment.Id]; ok {
+			return fmt.Errorf("duplicate deployment %s", deployment.Id)
+		}
+		ids[deployment.Id] = struct{}{}
+	}
+	return nil
+}
+
+// deploymentState returns the current rule change threshold for the passed
+// deployment and the block AFTER the passed node, which is its main parent.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) deploymentState(prevNode *blockNode, deploymentID string) (ThresholdState, error) {
+	deployments := b.deployments()
+	for i := range deployments {
+		if deployments[i].Id != deploymentID {
+			continue
+		}
+		checker := deploymentChecker{deployment: &deployments[i], chain: b}
+		cache := &b.deploymentCaches[i]
+		return b.thresholdState(prevNode, checker, cache)
+	}
+	return ThresholdFailed, fmt.Errorf("deployment %s is not defined",
+		deploymentID)
+}
+
+// isDeploymentActive returns whether the passed deployment is active for the
+// block AFTER the passed node, which is its main parent.  Deployments which are
+// not defined for the chain are never active.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) isDeploymentActive(prevNode *blockNode, deploymentID string) (bool, error) {
+	for _, deployment := range b.deployments() {
+		if deployment.Id != deploymentID {
+			continue
+		}
+		state, err := b.deploymentState(prevNode, deploymentID)
+		if err != nil {
+			return false, err
+		}
+		return state == ThresholdActive, nil
+	}
+	return false, nil
+}
+
+// mainChainTipNode returns the block node of the tip of the main chain, which
+// is the main parent of the next block.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) mainChainTipNode() *blockNode {
+	return b.index.LookupNode(b.bd.GetMainChainTip().GetHash())
+}
+
+// ThresholdState returns the current rule change threshold state of the given
+// deployment ID for the block after the end of the DAG.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ThresholdState(deploymentID string) (ThresholdState, error) {
+	b.chainLock.Lock()
+	state, err := b.deploymentState(b.mainChainTipNode(), deploymentID)
+	b.chainLock.Unlock()
+
+	return state, err
+}
+
+// IsDeploymentActive returns true if the target deploymentID is active, and
+// false otherwise.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsDeploymentActive(deploymentID string) (bool, error) {
+	b.chainLock.Lock()
+	active, err := b.isDeploymentActive(b.mainChainTipNode(), deploymentID)
+	b.chainLock.Unlock()
+
+	return active, err
+}
+
+// calcNextBlockVersion calculates the expected version of the block AFTER the
+// passed node, which is its main parent.
+//
+// This uses the current rule change threshold states of the deployments to
+// set the version bits of all deployments which are being voted on or locked
+// in.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) calcNextBlockVersion(prevNode *blockNode) (uint32, error) {
+	// Set the appropriate bits for each actively defined rule deployment
+	// that is either in the process of being voted on, or locked in for the
+	// activation at the next threshold window change.
+	expectedVersion := b.BlockVersion | vbTopBits
+	deployments := b.deployments()
+	for i := range deployments {
+		deployment := &deployments[i]
+		checker := deploymentChecker{deployment: deployment, chain: b}
+		state, err := b.thresholdState(prevNode, checker,
+			&b.deploymentCaches[i])
+		if err != nil {
+			return 0, err
+		}
+		if state == ThresholdStarted || state == ThresholdLockedIn {
+			expectedVersion |= uint32(1) << deployment.BitNumber
+		}
+	}
+	return expectedVersion, nil
+}
+
+// CalcNextBlockVersion calculates the expected version of the block after the
+// end of the DAG.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CalcNextBlockVersion() (uint32, error) {
+	b.chainLock.Lock()
+	version, err := b.calcNextBlockVersion(b.mainChainTipNode())
+	b.chainLock.Unlock()
+	return version, err
+}
@@ -150,6 +150,20 @@ type BlockDAG struct {
 
 	// Use block id to save all blocks with mapping
 	blockids map[uint]*hash.Hash
+
+	// The database the blocks pruned from memory are reloaded from.
+	db database.DB
+
+	// The blocks which have been pruned from memory.
+	pruned map[hash.Hash]*prunedBlock
+}
+
+// prunedBlock is what is kept in memory of a block pruned from the DAG.  The
+// children of a block are not stored with it in the database, so they are
+// kept along with the id the block is stored at.
+type prunedBlock struct {
+	id       uint
+	children []hash.Hash
 }
 
 // Acquire the name of DAG instance
@@ -203,6 +217,9 @@ func (bd *BlockDAG) AddBlock(b IBlockData) *list.List {
 			parent := bd.GetBlock(h)
 			block.parents.AddPair(h, parent)
 			parent.AddChild(&block)
+			if pb, ok := bd.pruned[*h]; ok {
+				pb.children = append(pb.children, block.hash)
+			}
 			if k == 0 {
 				block.mainParent = parent.GetHash()
 			}
@@ -262,7 +279,14 @@ func (bd *BlockDAG) IsDAG(b IBlockData) bool {
 
 // Is there a block in DAG?
 func (bd *BlockDAG) HasBlock(h *hash.Hash) bool {
-	return bd.GetBlock(h) != nil
+	if h == nil {
+		return false
+	}
+	if _, ok := bd.blocks[*h]; ok {
+		return true
+	}
+	_, ok := bd.pruned[*h]
+	return ok
 }
 
 // Is there some block in DAG?
@@ -275,18 +299,99 @@ func (bd *BlockDAG) HasBlocks(hs []*hash.Hash) bool {
 	return true
 }
 
-// Acquire one block by hash
+// Acquire one block by hash.  The blocks which have been pruned from memory
+// are reloaded from the database, and a new instance is returned every time.
 func (bd *BlockDAG) GetBlock(h *hash.Hash) IBlock {
 	if h == nil {
 		return nil
 	}
 	block, ok := bd.blocks[*h]
 	if !ok {
-		return nil
+		return bd.loadPrunedBlock(h)
 	}
 	return block
 }
 
+// SetDB sets the database the blocks pruned from memory are reloaded from.
+func (bd *BlockDAG) SetDB(db database.DB) {
+	bd.db = db
+}
+
+// PruneBlocks removes the blocks whose order is below the passed order from
+// memory.  The genesis block, the tips and the blocks which have not been
+// ordered yet are kept.  The removed blocks only keep the hashes of their
+// parents and children, so the remaining blocks which still reference them
+// don't keep the whole past of the DAG in memory.  It returns the hashes of
+// the removed blocks.
+//
+// The blocks are reloaded from the database by GetBlock, so it must have been
+// set with SetDB and the blocks must have been stored.
+func (bd *BlockDAG) PruneBlocks(order uint) []*hash.Hash {
+	if bd.db == nil {
+		return nil
+	}
+	if bd.pruned == nil {
+		bd.pruned = map[hash.Hash]*prunedBlock{}
+	}
+
+	var result []*hash.Hash
+	for h, ib := range bd.blocks {
+		if ib.GetID() == 0 || !ib.IsOrdered() || ib.GetOrder() >= order ||
+			bd.tips.Has(&h) {
+			continue
+		}
+		pb := &prunedBlock{id: ib.GetID()}
+		if ib.HasChildren() {
+			for k := range ib.GetChildren().GetMap() {
+				pb.children = append(pb.children, k)
+			}
+			ib.GetChildren().keepHashes()
+		}
+		if ib.HasParents() {
+			ib.GetParents().keepHashes()
+		}
+		// The indexes by id and order point to the hash held by the
+		// block, so they get a copy of it to not keep the block alive.
+		blockHash := h
+		bd.blockids[ib.GetID()] = &blockHash
+		if oh, ok := bd.order[ib.GetOrder()]; ok && oh.IsEqual(&h) {
+			bd.order[ib.GetOrder()] = &blockHash
+		}
+		delete(bd.blocks, h)
+		bd.pruned[h] = pb
+		result = append(result, &blockHash)
+	}
+	return result
+}
+
+// loadPrunedBlock reloads the block identified by the passed hash from the
+// database when it has been pruned from memory.  The parents and children of
+// the block are only known by their hashes.  It will return nil if the block
+// has not been pruned or can't be loaded.
+func (bd *BlockDAG) loadPrunedBlock(h *hash.Hash) IBlock {
+	pb, ok := bd.pruned[*h]
+	if !ok {
+		return nil
+	}
+	block := Block{id: pb.id}
+	ib := bd.instance.CreateBlock(&block)
+	err := bd.db.View(func(dbTx database.Tx) error {
+		return DBGetDAGBlock(dbTx, ib)
+	})
+	if err != nil {
+		log.Error("Failed to load pruned DAG block", "hash", h,
+			"error", err)
+		return nil
+	}
+	if len(pb.children) > 0 {
+		block.children = NewHashSet()
+		for i := range pb.children {
+			block.children.Add(&pb.children[i])
+		}
+	}
+	return ib
+}
+
 // Total number of blocks
 func (bd *BlockDAG) GetBlockTotal() uint {
 	return bd.blockTotal
@@ -454,8 +559,8 @@ func (bd *BlockDAG) LocateBlocks(gs *GraphState, maxHashes uint) []*hash.Hash {
 		}
 		needRec := true
 		if cur.HasChildren() {
-			for _, v := range cur.GetChildren().GetMap() {
-				ib := v.(IBlock)
+			for k := range cur.GetChildren().GetMap() {
+				ib := bd.GetBlock(&k)
 				if gs.GetTips().Has(ib.GetHash()) || !fs.Has(ib.GetHash()) && ib.IsOrdered() {
 					needRec = false
 					break
@@ -465,13 +570,11 @@ func (bd *BlockDAG) LocateBlocks(gs *GraphState, maxHashes uint) []*hash.Hash {
 		if needRec {
 			fs.AddPair(cur.GetHash(), cur)
 			if cur.HasParents() {
-				for _, v := range cur.GetParents().GetMap() {
-					value := v.(IBlock)
-					ib := value
-					if fs.Has(ib.GetHash()) {
+				for k := range cur.GetParents().GetMap() {
+					if fs.Has(&k) {
 						continue
 					}
-					queue = append(queue, ib)
+					queue = append(queue, bd.GetBlock(&k))
 
 				}
 			}
@@ -487,9 +590,8 @@ func (bd *BlockDAG) LocateBlocks(gs *GraphState, maxHashes uint) []*hash.Hash {
 		}
 		if ib.HasChildren() {
 			need := true
-			for _, v := range ib.GetChildren().GetMap() {
-				ib := v.(IBlock)
-				if gs.GetTips().Has(ib.GetHash()) {
+			for k := range ib.GetChildren().GetMap() {
+				if gs.GetTips().Has(&k) {
 					need = false
 					break
 				}
@@ -568,6 +670,27 @@ func (bd *BlockDAG) GetAnticone(b IBlock, exclude *HashSet) *HashSet {
 	return anticone
 }
 
+// GetMergeSet returns the blocks in the past of the block which are neither its
+// main parent nor in the past of its main parent.  Together with the main
+// parent and its past they make up the past of the block, so the set is fixed
+// once the block is in the DAG.
+func (bd *BlockDAG) GetMergeSet(h *hash.Hash) *HashSet {
+	ib := bd.GetBlock(h)
+	if ib == nil || !ib.HasParents() {
+		return NewHashSet()
+	}
+	if pb, ok := ib.(*PhantomBlock); ok {
+		mergeSet := pb.blueDiffAnticone.Clone()
+		mergeSet.AddSet(pb.redDiffAnticone)
+		return mergeSet
+	}
+	// The merge set is the anticone of the main parent in the past of the
+	// block.
+	mergeSet := bd.GetAnticone(bd.GetMainParent(ib.GetParents()), nil)
+	mergeSet.RemoveSet(bd.GetAnticone(ib, nil))
+	return mergeSet
+}
+
 // Sort block by id
 func (bd *BlockDAG) SortBlock(src []*hash.Hash) []*hash.Hash {
 	if len(src) <= 1 {
@@ -620,9 +743,8 @@ func (bd *BlockDAG) GetConfirmations(h *hash.Hash) uint {
 		if !cur.HasChildren() {
 			return 0
 		} else {
-			for _, v := range cur.GetChildren().GetMap() {
-				ib := v.(IBlock)
-				queue = append(queue, ib)
+			for k := range cur.GetChildren().GetMap() {
+				queue = append(queue, bd.GetBlock(&k))
 			}
 		}
 	}
@@ -25,6 +25,14 @@ func (s *HashSet) AddPair(elem *hash.Hash, data interface{}) {
 	s.m[*elem] = data
 }
 
+// keepHashes drops the data of the elements while keeping their keys, so the
+// set doesn't reference the data anymore.
+func (s *HashSet) keepHashes() {
+	for k := range s.m {
+		s.m[k] = Empty{}
+	}
+}
+
 // Remove the element
 func (s *HashSet) Remove(elem *hash.Hash) {
 	delete(s.m, *elem)
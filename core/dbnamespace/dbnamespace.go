@@ -56,10 +56,19 @@ var (
 	// unspent transaction output set.
 	UtxoSetBucketName = []byte("utxoset")
 
+	// UtxoStateBucketName is the name of the db bucket used to house the
+	// multiset commitment to the unspent transaction output set as of
+	// each block.
+	UtxoStateBucketName = []byte("utxostate")
+
 	// BlockIndexBucketName is the name of the db bucket used to house the
 	// block which consists of metadata for all known blocks in DAG.
 	BlockIndexBucketName = []byte("blockidx")
 
+	// BlockWorkBucketName is the name of the db bucket used to house the
+	// work sum of the blocks whose nodes have been pruned from memory.
+	BlockWorkBucketName = []byte("blockwork")
+
 	// IndexTipsBucketName is the name of the db bucket used to house the
 	// current tip of each index.
 	IndexTipsBucketName = []byte("idxtips")
@@ -39,3 +39,23 @@ type GetBlockHeaderVerboseResult struct {
 	Time          int64   `json:"time"`
 	Nonce         uint64  `json:"nonce"`
 }
+
+// DeploymentInfoResult models the data of a consensus rule change deployment
+// returned by the getDeploymentInfo command.
+type DeploymentInfoResult struct {
+	Id          string `json:"id"`
+	Description string `json:"description"`
+	Bit         uint8  `json:"bit"`
+	StartTime   uint64 `json:"startTime"`
+	ExpireTime  uint64 `json:"expireTime"`
+	Status      string `json:"status"`
+}
+
+// BlockTimeResult models a block found by the getblockorderbytime and the
+// getblocksintimerange commands.
+type BlockTimeResult struct {
+	Order      uint32 `json:"order"`
+	Hash       string `json:"hash"`
+	Time       int64  `json:"time"`
+	MedianTime int64  `json:"mediantime"`
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package json
+
+// MempoolEntryResult models the data of a transaction in the mempool returned
+// by the getMempoolEntry and the verbose getMempoolAncestors and
+// getMempoolDescendants requests.
+type MempoolEntryResult struct {
+	Size             int32    `json:"size"`
+	Fee              float64  `json:"fee"`
+	Time             int64    `json:"time"`
+	Height           int64    `json:"height"`
+	StartingPriority float64  `json:"startingpriority"`
+	CurrentPriority  float64  `json:"currentpriority"`
+	AncestorCount    int64    `json:"ancestorcount"`
+	AncestorSize     int64    `json:"ancestorsize"`
+	AncestorFees     float64  `json:"ancestorfees"`
+	DescendantCount  int64    `json:"descendantcount"`
+	DescendantSize   int64    `json:"descendantsize"`
+	DescendantFees   float64  `json:"descendantfees"`
+	Depends          []string `json:"depends"`
+}
+
+// GetMempoolInfoResult models the data returned from the getMempoolInfo
+// command.
+type GetMempoolInfoResult struct {
+	Size          int64   `json:"size"`
+	Bytes         int64   `json:"bytes"`
+	MaxMempool    int64   `json:"maxmempool"`
+	MempoolMinFee float64 `json:"mempoolminfee"`
+	MinRelayTxFee float64 `json:"minrelaytxfee"`
+	Orphans       int64   `json:"orphans"`
+	OrphanBytes   int64   `json:"orphanbytes"`
+}
+
+// TestMempoolAcceptResult models the data returned from the testMempoolAccept
+// command for every transaction.
+type TestMempoolAcceptResult struct {
+	TxId         string `json:"txid"`
+	Allowed      bool   `json:"allowed"`
+	RejectCode   string `json:"rejectcode,omitempty"`
+	RejectReason string `json:"rejectreason,omitempty"`
+}
+
+// FeeHistogramBucket models a fee rate range of the histogram returned from the
+// getMempoolFeeHistogram command.  FeeRateTo is zero for the last range.
+type FeeHistogramBucket struct {
+	FeeRateFrom float64 `json:"feeratefrom"`
+	FeeRateTo   float64 `json:"feerateto"`
+	Count       int64   `json:"count"`
+	Size        int64   `json:"size"`
+	Fees        float64 `json:"fees"`
+}
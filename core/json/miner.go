@@ -0,0 +1,17 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package json
+
+// StratumWorkerResult models a connection of a miner to the Stratum server in
+// the data returned by the getStratumWorkers command.
+type StratumWorkerResult struct {
+	Worker     string  `json:"worker"`
+	Addr       string  `json:"addr"`
+	Difficulty float64 `json:"difficulty"`
+	Accepted   uint64  `json:"accepted"`
+	Rejected   uint64  `json:"rejected"`
+	Blocks     uint64  `json:"blocks"`
+	HashRate   float64 `json:"hashrate"`
+	LastShare  int64   `json:"lastshare"`
+	ConnTime   int64   `json:"conntime"`
+}
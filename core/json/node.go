@@ -46,4 +46,22 @@ type GetGraphStateResult struct {
 	MainOrder uint32 `json:"mainorder"`
 	MainHeight uint32 `json:"mainheight"`
 	Layer uint32 `json:"layer"`
-}
\ No newline at end of file
+}
+
+// IndexInfoResult models the data of an index from the getindexinfo command.
+// The tip order is -1 when no block is indexed yet, and the ETA is the
+// estimated number of seconds left for a syncing index to catch up.
+type IndexInfoResult struct {
+	Name     string `json:"name"`
+	Key      string `json:"key"`
+	State    string `json:"state"`
+	TipOrder int64  `json:"tiporder"`
+	TipHash  string `json:"tiphash,omitempty"`
+	ETA      int64  `json:"eta,omitempty"`
+}
+
+// GetIndexInfoResult models the data from the getindexinfo command.
+type GetIndexInfoResult struct {
+	BestOrder int64             `json:"bestorder"`
+	Indexes   []IndexInfoResult `json:"indexes"`
+}
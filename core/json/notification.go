@@ -0,0 +1,38 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package json
+
+// BlockConnectedNtfn models the data of the notification sent to the
+// websocket clients subscribed to new blocks when a block is connected.
+type BlockConnectedNtfn struct {
+	Hash    string   `json:"hash"`
+	Order   uint64   `json:"order"`
+	Time    int64    `json:"time"`
+	Parents []string `json:"parents"`
+	Tx      []string `json:"tx"`
+}
+
+// BlockOrderResult models the DAG order of a block.
+type BlockOrderResult struct {
+	Hash  string `json:"hash"`
+	Order uint64 `json:"order"`
+}
+
+// ReorganizationNtfn models the data of the notification sent to the
+// websocket clients subscribed to DAG order changes when a new block causes
+// the DAG to be reordered.  Detached holds the blocks with the orders they
+// lost, and Attached the blocks with the orders they were given.
+type ReorganizationNtfn struct {
+	Block    string             `json:"block"`
+	Detached []BlockOrderResult `json:"detached"`
+	Attached []BlockOrderResult `json:"attached"`
+}
+
+// TxAcceptedNtfn models the data of the notification sent to the websocket
+// clients subscribed to new transactions when a transaction is accepted into
+// the mempool.  The hex-encoded transaction is only set for the clients which
+// asked for full transactions.
+type TxAcceptedNtfn struct {
+	TxID string `json:"txid"`
+	Hex  string `json:"hex,omitempty"`
+}
@@ -81,6 +81,7 @@ type ScriptPubKeyResult struct {
 	ReqSigs   int32    `json:"reqSigs,omitempty"`
 	Type      string   `json:"type"`
 	Addresses []string `json:"addresses,omitempty"`
+	NullData  string   `json:"nulldata,omitempty"`
 }
 
 // ScriptSig models a signature script.  It is defined separately since it only
@@ -131,4 +132,51 @@ type VinPrevOut struct {
 type PrevOut struct {
 	Addresses []string `json:"addresses,omitempty"`
 	Value     float64  `json:"value"`
-}
\ No newline at end of file
+}
+// GetAddressBalanceResult models the data from the getaddressbalance command.
+// The amounts are in atoms.
+type GetAddressBalanceResult struct {
+	Balance     uint64 `json:"balance"`
+	Received    uint64 `json:"received"`
+	Unconfirmed int64  `json:"unconfirmed"`
+}
+
+// AddressUtxoResult models an output returned by the getaddressutxos command.
+type AddressUtxoResult struct {
+	Txid          string `json:"txid"`
+	Vout          uint32 `json:"vout"`
+	Amount        uint64 `json:"amount"`
+	ScriptPubKey  string `json:"scriptPubKey"`
+	BlockHash     string `json:"blockhash,omitempty"`
+	Confirmations int64  `json:"confirmations"`
+	Coinbase      bool   `json:"coinbase"`
+}
+
+// AddressDeltaResult models a balance change returned by the getaddressdeltas
+// command.  The amount is negative for inputs.
+type AddressDeltaResult struct {
+	Txid       string `json:"txid"`
+	Index      uint32 `json:"index"`
+	Input      bool   `json:"input"`
+	Amount     int64  `json:"amount"`
+	BlockHash  string `json:"blockhash,omitempty"`
+	BlockOrder uint64 `json:"blockorder,omitempty"`
+}
+
+// NullDataResult models a null data output from the searchnulldata command.
+type NullDataResult struct {
+	Txid          string `json:"txid"`
+	Vout          uint32 `json:"vout"`
+	BlockHash     string `json:"blockhash"`
+	Confirmations int64  `json:"confirmations"`
+	Payload       string `json:"payload"`
+}
+
+// SpendingTxResult models the data from the getspendingtx command.  The block
+// hash is empty for the transactions in the mempool.
+type SpendingTxResult struct {
+	Txid          string `json:"txid"`
+	Vin           uint32 `json:"vin"`
+	BlockHash     string `json:"blockhash,omitempty"`
+	Confirmations int64  `json:"confirmations"`
+}
@@ -0,0 +1,15 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package json
+
+// ListUnspentResult models a successful response from the listUnspent request.
+type ListUnspentResult struct {
+	TxId          string  `json:"txid"`
+	Vout          uint32  `json:"vout"`
+	Address       string  `json:"address"`
+	ScriptPubKey  string  `json:"scriptPubKey"`
+	Amount        float64 `json:"amount"`
+	Confirmations int64   `json:"confirmations"`
+	Coinbase      bool    `json:"coinbase"`
+	Spendable     bool    `json:"spendable"`
+}
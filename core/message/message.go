@@ -12,8 +12,8 @@ import (
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
 	"github.com/Qitmeer/qitmeer-lib/core/protocol"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"io"
 	"unicode/utf8"
 )
@@ -67,6 +67,10 @@ const (
 	CmdCFilter      = "cfilter"
 	CmdCFHeaders    = "cfheaders"
 	CmdCFTypes      = "cftypes"
+	CmdFilterAdd    = "filteradd"
+	CmdFilterClear  = "filterclear"
+	CmdFilterLoad   = "filterload"
+	CmdMerkleBlock  = "merkleblock"
 )
 
 // Message is an interface that describes a qitmeer message.  A type that
@@ -121,35 +125,32 @@ func makeEmptyMessage(command string) (Message, error) {
 		msg = &MsgGetMiningState{}
 	case CmdGraphState:
 		msg = &MsgGraphState{}
-	/*
-
-		case CmdMemPool:
-			msg = &MsgMemPool{}
-
-		case CmdSendHeaders:
-			msg = &MsgSendHeaders{}
-
-		case CmdFeeFilter:
-			msg = &MsgFeeFilter{}
-
-		case CmdGetCFilter:
-			msg = &MsgGetCFilter{}
-
-		case CmdGetCFHeaders:
-			msg = &MsgGetCFHeaders{}
-
-		case CmdGetCFTypes:
-			msg = &MsgGetCFTypes{}
-
-		case CmdCFilter:
-			msg = &MsgCFilter{}
-
-		case CmdCFHeaders:
-			msg = &MsgCFHeaders{}
-
-		case CmdCFTypes:
-			msg = &MsgCFTypes{}
-	*/
+	case CmdMemPool:
+		msg = &MsgMemPool{}
+	case CmdSendHeaders:
+		msg = &MsgSendHeaders{}
+	case CmdFeeFilter:
+		msg = &MsgFeeFilter{}
+	case CmdGetCFilter:
+		msg = &MsgGetCFilter{}
+	case CmdGetCFHeaders:
+		msg = &MsgGetCFHeaders{}
+	case CmdGetCFTypes:
+		msg = &MsgGetCFTypes{}
+	case CmdCFilter:
+		msg = &MsgCFilter{}
+	case CmdCFHeaders:
+		msg = &MsgCFHeaders{}
+	case CmdCFTypes:
+		msg = &MsgCFTypes{}
+	case CmdFilterAdd:
+		msg = &MsgFilterAdd{}
+	case CmdFilterClear:
+		msg = &MsgFilterClear{}
+	case CmdFilterLoad:
+		msg = &MsgFilterLoad{}
+	case CmdMerkleBlock:
+		msg = &MsgMerkleBlock{}
 
 	default:
 		return nil, fmt.Errorf("unhandled command [%s]", command)
@@ -40,10 +40,17 @@ func Summary(msg Message) string {
 		return fmt.Sprintf("hash %s, %d inputs, %d outputs, lock %s",
 			msg.Tx.TxHash(), len(msg.Tx.TxIn), len(msg.Tx.TxOut),
 			formatLockTime(msg.Tx.LockTime))
-	// TODO
-	/*
+
 	case *MsgMemPool:
 		// No summary.
+
+	case *MsgSendHeaders:
+		// No summary.
+
+	case *MsgFeeFilter:
+		return fmt.Sprintf("min fee %d atoms/kB", msg.MinFee)
+	// TODO
+	/*
 	case *MsgBlock:
 		header := &msg.Header
 		return fmt.Sprintf("hash %s, ver %d, %d tx, %s", msg.BlockHash(),
@@ -8,8 +8,8 @@ package message
 
 import (
 	"fmt"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"io"
 )
 
@@ -0,0 +1,141 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2017 The btcsuite developers
+// Copyright (c) 2017 The Lightning Network Developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"io"
+)
+
+// MaxCFHeadersPerMsg is the maximum number of committed filter hashes that can
+// be in a single cfheaders message.
+const MaxCFHeadersPerMsg = 2000
+
+// MsgCFHeaders implements the Message interface and represents a cfheaders
+// message.  It is used to deliver the filter hashes of a range of blocks on a
+// chain of main parents in response to a getcfheaders (MsgGetCFHeaders)
+// message, along with the filter header of the main parent of the first block
+// of the range.  The filter header of each block is the hash of its filter
+// hash and the filter header of its main parent.
+type MsgCFHeaders struct {
+	FilterType       FilterType
+	StopHash         hash.Hash
+	PrevFilterHeader hash.Hash
+	FilterHashes     []*hash.Hash
+}
+
+// AddCFHash adds a new filter hash to the message.
+func (msg *MsgCFHeaders) AddCFHash(h *hash.Hash) error {
+	if len(msg.FilterHashes)+1 > MaxCFHeadersPerMsg {
+		str := fmt.Sprintf("too many block headers in message [max %v]",
+			MaxCFHeadersPerMsg)
+		return messageError("MsgCFHeaders.AddCFHash", str)
+	}
+
+	msg.FilterHashes = append(msg.FilterHashes, h)
+	return nil
+}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCFHeaders) Decode(r io.Reader, pver uint32) error {
+	err := s.ReadElements(r, &msg.FilterType, &msg.StopHash,
+		&msg.PrevFilterHeader)
+	if err != nil {
+		return err
+	}
+
+	// Read number of filter hashes and limit to max.
+	count, err := s.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > MaxCFHeadersPerMsg {
+		str := fmt.Sprintf("too many committed filter hashes for "+
+			"message [count %v, max %v]", count,
+			MaxCFHeadersPerMsg)
+		return messageError("MsgCFHeaders.Decode", str)
+	}
+
+	// Create a contiguous slice of hashes to deserialize into in order to
+	// reduce the number of allocations.
+	filterHashes := make([]hash.Hash, count)
+	msg.FilterHashes = make([]*hash.Hash, 0, count)
+	for i := uint64(0); i < count; i++ {
+		h := &filterHashes[i]
+		err := s.ReadElements(r, h)
+		if err != nil {
+			return err
+		}
+		msg.AddCFHash(h)
+	}
+
+	return nil
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCFHeaders) Encode(w io.Writer, pver uint32) error {
+	// Limit to max committed filter hashes per message.
+	count := len(msg.FilterHashes)
+	if count > MaxCFHeadersPerMsg {
+		str := fmt.Sprintf("too many committed filter hashes for "+
+			"message [count %v, max %v]", count,
+			MaxCFHeadersPerMsg)
+		return messageError("MsgCFHeaders.Encode", str)
+	}
+
+	err := s.WriteElements(w, msg.FilterType, &msg.StopHash,
+		&msg.PrevFilterHeader)
+	if err != nil {
+		return err
+	}
+
+	err = s.WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, h := range msg.FilterHashes {
+		err := s.WriteElements(w, h)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCFHeaders) Command() string {
+	return CmdCFHeaders
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCFHeaders) MaxPayloadLength(pver uint32) uint32 {
+	// Filter type + stop hash + previous filter header + num filter hashes
+	// (varInt) + filter hashes.
+	return 1 + hash.HashSize + hash.HashSize + MaxVarIntPayload +
+		(MaxCFHeadersPerMsg * hash.HashSize)
+}
+
+func (msg *MsgCFHeaders) String() string {
+	return fmt.Sprintf("FilterType:%d StopHash:%s FilterHashes:%d",
+		msg.FilterType, msg.StopHash.String(), len(msg.FilterHashes))
+}
+
+// NewMsgCFHeaders returns a new cfheaders message that conforms to the Message
+// interface.  See MsgCFHeaders for details.
+func NewMsgCFHeaders() *MsgCFHeaders {
+	return &MsgCFHeaders{
+		FilterHashes: make([]*hash.Hash, 0, MaxCFHeadersPerMsg),
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2017 The btcsuite developers
+// Copyright (c) 2017 The Lightning Network Developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"io"
+)
+
+// MaxCFilterDataSize is the maximum byte size of a committed filter.
+const MaxCFilterDataSize = 256 * 1024
+
+// MsgCFilter implements the Message interface and represents a cfilter message.
+// It is used to deliver a committed filter in response to a getcfilter
+// (MsgGetCFilter) message.
+type MsgCFilter struct {
+	FilterType FilterType
+	BlockHash  hash.Hash
+	Data       []byte
+}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCFilter) Decode(r io.Reader, pver uint32) error {
+	err := s.ReadElements(r, &msg.FilterType, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	msg.Data, err = s.ReadVarBytes(r, pver, MaxCFilterDataSize,
+		"cfilter data")
+	return err
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCFilter) Encode(w io.Writer, pver uint32) error {
+	size := len(msg.Data)
+	if size > MaxCFilterDataSize {
+		str := fmt.Sprintf("cfilter size too large for message "+
+			"[size %v, max %v]", size, MaxCFilterDataSize)
+		return messageError("MsgCFilter.Encode", str)
+	}
+
+	err := s.WriteElements(w, msg.FilterType, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	return s.WriteVarBytes(w, pver, msg.Data)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCFilter) Command() string {
+	return CmdCFilter
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCFilter) MaxPayloadLength(pver uint32) uint32 {
+	// Filter type + block hash + num filter bytes (varInt) + filter.
+	return 1 + hash.HashSize + MaxVarIntPayload + MaxCFilterDataSize
+}
+
+func (msg *MsgCFilter) String() string {
+	return fmt.Sprintf("FilterType:%d BlockHash:%s Size:%d", msg.FilterType,
+		msg.BlockHash.String(), len(msg.Data))
+}
+
+// NewMsgCFilter returns a new cfilter message that conforms to the Message
+// interface.  See MsgCFilter for details.
+func NewMsgCFilter(filterType FilterType, blockHash *hash.Hash, data []byte) *MsgCFilter {
+	return &MsgCFilter{
+		FilterType: filterType,
+		BlockHash:  *blockHash,
+		Data:       data,
+	}
+}
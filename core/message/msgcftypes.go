@@ -0,0 +1,96 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"fmt"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"io"
+)
+
+// MaxFilterTypesPerMsg is the maximum number of filter types allowed per
+// message.
+const MaxFilterTypesPerMsg = 256
+
+// MsgCFTypes implements the Message interface and represents a cftypes message.
+// It is used to deliver the committed filter types supported by a peer in
+// response to a getcftypes (MsgGetCFTypes) message.
+type MsgCFTypes struct {
+	SupportedFilters []FilterType
+}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCFTypes) Decode(r io.Reader, pver uint32) error {
+	// Read the number of filter types supported.
+	count, err := s.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > MaxFilterTypesPerMsg {
+		str := fmt.Sprintf("too many filter types for message "+
+			"[count %v, max %v]", count, MaxFilterTypesPerMsg)
+		return messageError("MsgCFTypes.Decode", str)
+	}
+
+	// Read each filter type.
+	msg.SupportedFilters = make([]FilterType, count)
+	for i := uint64(0); i < count; i++ {
+		err = s.ReadElements(r, &msg.SupportedFilters[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCFTypes) Encode(w io.Writer, pver uint32) error {
+	count := len(msg.SupportedFilters)
+	if count > MaxFilterTypesPerMsg {
+		str := fmt.Sprintf("too many filter types for message "+
+			"[count %v, max %v]", count, MaxFilterTypesPerMsg)
+		return messageError("MsgCFTypes.Encode", str)
+	}
+
+	// Write length of supported filters slice.
+	err := s.WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for i := range msg.SupportedFilters {
+		err = s.WriteElements(w, msg.SupportedFilters[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCFTypes) Command() string {
+	return CmdCFTypes
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCFTypes) MaxPayloadLength(pver uint32) uint32 {
+	// Num filter types (varInt) + filter types.
+	return MaxVarIntPayload + MaxFilterTypesPerMsg
+}
+
+// NewMsgCFTypes returns a new cftypes message that conforms to the Message
+// interface.  See MsgCFTypes for details.
+func NewMsgCFTypes(filterTypes []FilterType) *MsgCFTypes {
+	return &MsgCFTypes{
+		SupportedFilters: filterTypes,
+	}
+}
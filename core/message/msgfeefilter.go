@@ -0,0 +1,53 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"io"
+)
+
+// MsgFeeFilter implements the Message interface and represents a feefilter
+// message.  It is used to request the receiving peer does not announce any
+// transactions below the specified minimum fee rate.
+//
+// The payload for this message consists of the minimum fee rate in atoms/kB.
+type MsgFeeFilter struct {
+	MinFee int64
+}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgFeeFilter) Decode(r io.Reader, pver uint32) error {
+	return s.ReadElements(r, &msg.MinFee)
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgFeeFilter) Encode(w io.Writer, pver uint32) error {
+	return s.WriteElements(w, msg.MinFee)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgFeeFilter) Command() string {
+	return CmdFeeFilter
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgFeeFilter) MaxPayloadLength(pver uint32) uint32 {
+	// Min fee 8 bytes.
+	return 8
+}
+
+// NewMsgFeeFilter returns a new feefilter message that conforms to the
+// Message interface.  See MsgFeeFilter for details.
+func NewMsgFeeFilter(minfee int64) *MsgFeeFilter {
+	return &MsgFeeFilter{
+		MinFee: minfee,
+	}
+}
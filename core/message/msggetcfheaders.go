@@ -0,0 +1,69 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2017 The btcsuite developers
+// Copyright (c) 2017 The Lightning Network Developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"io"
+)
+
+// MsgGetCFHeaders implements the Message interface and represents a
+// getcfheaders message.  It is used to request the filter hashes of the blocks
+// on the chain of main parents of the block with the hash StopHash, starting
+// at the main chain height StartHeight and ending at the stop block, in order
+// to verify the chain of the committed filter headers.  The range is limited
+// to MaxCFHeadersPerMsg blocks.
+type MsgGetCFHeaders struct {
+	FilterType  FilterType
+	StartHeight uint32
+	StopHash    hash.Hash
+}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFHeaders) Decode(r io.Reader, pver uint32) error {
+	return s.ReadElements(r, &msg.FilterType, &msg.StartHeight,
+		&msg.StopHash)
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFHeaders) Encode(w io.Writer, pver uint32) error {
+	return s.WriteElements(w, msg.FilterType, msg.StartHeight,
+		&msg.StopHash)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetCFHeaders) Command() string {
+	return CmdGetCFHeaders
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetCFHeaders) MaxPayloadLength(pver uint32) uint32 {
+	// Filter type + start height + stop hash.
+	return 1 + 4 + hash.HashSize
+}
+
+func (msg *MsgGetCFHeaders) String() string {
+	return fmt.Sprintf("FilterType:%d StartHeight:%d StopHash:%s",
+		msg.FilterType, msg.StartHeight, msg.StopHash.String())
+}
+
+// NewMsgGetCFHeaders returns a new getcfheaders message that conforms to the
+// Message interface using the passed parameters and defaults for the remaining
+// fields.
+func NewMsgGetCFHeaders(filterType FilterType, startHeight uint32, stopHash *hash.Hash) *MsgGetCFHeaders {
+	return &MsgGetCFHeaders{
+		FilterType:  filterType,
+		StartHeight: startHeight,
+		StopHash:    *stopHash,
+	}
+}
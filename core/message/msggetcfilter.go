@@ -0,0 +1,69 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2017 The btcsuite developers
+// Copyright (c) 2017 The Lightning Network Developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"io"
+)
+
+// FilterType is used to represent a filter type.
+type FilterType uint8
+
+const (
+	// GCSFilterRegular is the regular filter type.
+	GCSFilterRegular FilterType = iota
+)
+
+// MsgGetCFilter implements the Message interface and represents a getcfilter
+// message.  It is used to request the committed filter of a block.
+type MsgGetCFilter struct {
+	FilterType FilterType
+	BlockHash  hash.Hash
+}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFilter) Decode(r io.Reader, pver uint32) error {
+	return s.ReadElements(r, &msg.FilterType, &msg.BlockHash)
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFilter) Encode(w io.Writer, pver uint32) error {
+	return s.WriteElements(w, msg.FilterType, &msg.BlockHash)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetCFilter) Command() string {
+	return CmdGetCFilter
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetCFilter) MaxPayloadLength(pver uint32) uint32 {
+	// Filter type + block hash.
+	return 1 + hash.HashSize
+}
+
+func (msg *MsgGetCFilter) String() string {
+	return fmt.Sprintf("FilterType:%d BlockHash:%s", msg.FilterType,
+		msg.BlockHash.String())
+}
+
+// NewMsgGetCFilter returns a new getcfilter message that conforms to the
+// Message interface using the passed parameters and defaults for the remaining
+// fields.
+func NewMsgGetCFilter(filterType FilterType, blockHash *hash.Hash) *MsgGetCFilter {
+	return &MsgGetCFilter{
+		FilterType: filterType,
+		BlockHash:  *blockHash,
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2015 The btcsuite developers
+// Copyright (c) 2015-2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"io"
+)
+
+// MsgMemPool implements the Message interface and represents a mempool
+// message.  It is used to request a list of transactions still in the active
+// memory pool of a relay.  The list is returned via an inv message (MsgInv)
+// which only holds the transactions matching the bloom filter and the fee
+// filter of the requesting peer.
+//
+// This message has no payload.
+type MsgMemPool struct{}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgMemPool) Decode(r io.Reader, pver uint32) error {
+	return nil
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgMemPool) Encode(w io.Writer, pver uint32) error {
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgMemPool) Command() string {
+	return CmdMemPool
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgMemPool) MaxPayloadLength(pver uint32) uint32 {
+	return 0
+}
+
+// NewMsgMemPool returns a new mempool message that conforms to the Message
+// interface.  See MsgMemPool for details.
+func NewMsgMemPool() *MsgMemPool {
+	return &MsgMemPool{}
+}
@@ -2,7 +2,7 @@
 package message
 
 import (
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"io"
 )
 
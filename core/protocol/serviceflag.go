@@ -8,6 +8,7 @@ import (
 // Map of service flags back to their constant names for pretty printing.
 var sfStrings = map[ServiceFlag]string{
 	Full: "Full",
+	Light:   "Light",
 	Bloom:   "Bloom",
 	CF:      "CF",
 }
@@ -16,6 +17,7 @@ var sfStrings = map[ServiceFlag]string{
 // lowest.
 var orderedSFStrings = []ServiceFlag{
 	Full,
+	Light,
 	Bloom,
 	CF,
 }
@@ -16,7 +16,17 @@ import (
 // Version 4 bytes + ParentRoot 32 bytes + TxRoot 32 bytes + StateRoot 32 bytes
 // Difficulty 4 bytes   + Timestamp 8 bytes + Nonce 8 bytes +ExNonce 8 bytes
 // --> Total 128 bytes.
-const MaxBlockHeaderPayload = 4 + (hash.HashSize * 3) + 4 + 8 + 8 + 8
+// Headers carrying a cycle proof append the number of cycle nonces 1 byte +
+// the cycle nonces 4 bytes each.
+const MaxBlockHeaderPayload = 4 + (hash.HashSize * 3) + 4 + 8 + 8 + 8 +
+	1 + 4*MaxCycleNonces
+
+// CycleProofBlockVersion is the block version flag which indicates the header
+// carries the cycle nonces of a cuckoo cycle proof of work after the nonce.
+const CycleProofBlockVersion uint32 = 1 << 15
+
+// MaxCycleNonces is the maximum number of cycle nonces a header can carry.
+const MaxCycleNonces = 42
 
 // MaxBlockPayload is the maximum bytes a block message can be in bytes.
 const MaxBlockPayload = 1048576 // 1024*1024 (1MB)
@@ -77,6 +87,10 @@ type BlockHeader struct {
 	// Nonce
 	Nonce uint64
 
+	// The nonces of the edges of the cycle found by a cuckoo cycle proof of
+	// work, only serialized when the version sets CycleProofBlockVersion
+	CycleNonces []uint32
+
 	//might extra data here
 
 	// Size is the size of the serialized block/block-header in its entirety.
@@ -106,9 +120,35 @@ func (h *BlockHeader) BlockHash() hash.Hash {
 // TODO, redefine the protocol version and storage
 func readBlockHeader(r io.Reader, pver uint32, bh *BlockHeader) error {
 	// TODO fix time ambiguous
-	return s.ReadElements(r, &bh.Version, &bh.ParentRoot, &bh.TxRoot,
+	err := s.ReadElements(r, &bh.Version, &bh.ParentRoot, &bh.TxRoot,
 		&bh.StateRoot, &bh.Difficulty, &bh.ExNonce, (*s.Int64Time)(&bh.Timestamp),
 		&bh.Nonce)
+	if err != nil {
+		return err
+	}
+	bh.CycleNonces = nil
+	if bh.Version&CycleProofBlockVersion == 0 {
+		return nil
+	}
+
+	// The cycle proof is the number of cycle nonces followed by the nonces.
+	var count uint8
+	err = s.ReadElements(r, &count)
+	if err != nil {
+		return err
+	}
+	if count > MaxCycleNonces {
+		return fmt.Errorf("too many cycle nonces in block header "+
+			"[count %d, max %d]", count, MaxCycleNonces)
+	}
+	bh.CycleNonces = make([]uint32, count)
+	for i := range bh.CycleNonces {
+		err = s.ReadElements(r, &bh.CycleNonces[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // writeBlockHeader writes a block header to w.  See Serialize for
@@ -118,8 +158,46 @@ func readBlockHeader(r io.Reader, pver uint32, bh *BlockHeader) error {
 func writeBlockHeader(w io.Writer, pver uint32, bh *BlockHeader) error {
 	// TODO fix time ambiguous
 	sec := bh.Timestamp.Unix()
-	return s.WriteElements(w, bh.Version, &bh.ParentRoot, &bh.TxRoot,
+	err := s.WriteElements(w, bh.Version, &bh.ParentRoot, &bh.TxRoot,
 		&bh.StateRoot, bh.Difficulty, bh.ExNonce, sec, bh.Nonce)
+	if err != nil {
+		return err
+	}
+	if bh.Version&CycleProofBlockVersion == 0 {
+		return nil
+	}
+
+	if len(bh.CycleNonces) > MaxCycleNonces {
+		return fmt.Errorf("too many cycle nonces in block header "+
+			"[count %d, max %d]", len(bh.CycleNonces), MaxCycleNonces)
+	}
+	err = s.WriteElements(w, uint8(len(bh.CycleNonces)))
+	if err != nil {
+		return err
+	}
+	for _, nonce := range bh.CycleNonces {
+		err = s.WriteElements(w, nonce)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cycleProofSerializeSize returns the number of bytes the cycle proof of the
+// header takes when it is serialized.
+func (h *BlockHeader) cycleProofSerializeSize() int {
+	if h.Version&CycleProofBlockVersion == 0 {
+		return 0
+	}
+	return 1 + 4*len(h.CycleNonces)
+}
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// block header.
+func (h *BlockHeader) SerializeSize() int {
+	return 4 + (hash.HashSize * 3) + 4 + 8 + 8 + 8 +
+		h.cycleProofSerializeSize()
 }
 
 // This function get the simple hash use each parents string, so it can't use to
@@ -178,6 +256,7 @@ func (block *Block) SerializeSize() int {
 	// stake transactions
 
 	n := blockHeaderLen + s.VarIntSerializeSize(uint64(len(block.Parents))) + s.VarIntSerializeSize(uint64(len(block.Transactions)))
+	n += block.Header.cycleProofSerializeSize()
 
 	for i := 0; i < len(block.Parents); i++ {
 		n += hash.HashSize
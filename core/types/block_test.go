@@ -0,0 +1,114 @@
+package types
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	libtypes "github.com/Qitmeer/qitmeer-lib/core/types"
+)
+
+func testBlockHeader(version uint32, cycleNonces []uint32) *BlockHeader {
+	return &BlockHeader{
+		Version:     version,
+		ParentRoot:  hash.HashH([]byte("parent root")),
+		TxRoot:      hash.HashH([]byte("tx root")),
+		StateRoot:   hash.HashH([]byte("state root")),
+		Difficulty:  0x1d00ffff,
+		ExNonce:     0x0102030405060708,
+		Timestamp:   time.Unix(1568000000, 0),
+		Nonce:       0x1122334455667788,
+		CycleNonces: cycleNonces,
+	}
+}
+
+// TestBlockHeaderSerialize tests the serialize and deserialize round trip of
+// the block headers with and without a cycle proof.
+func TestBlockHeaderSerialize(t *testing.T) {
+	nonces := make([]uint32, MaxCycleNonces)
+	for i := range nonces {
+		nonces[i] = uint32(i) * 0x01010101
+	}
+	tests := []struct {
+		name   string
+		header *BlockHeader
+		size   int
+	}{
+		{"legacy", testBlockHeader(1, nil), 4 + 32*3 + 4 + 8 + 8 + 8},
+		{"cycle proof", testBlockHeader(1|CycleProofBlockVersion, nonces),
+			4 + 32*3 + 4 + 8 + 8 + 8 + 1 + 4*MaxCycleNonces},
+		{"empty cycle proof", testBlockHeader(1|CycleProofBlockVersion, []uint32{}),
+			4 + 32*3 + 4 + 8 + 8 + 8 + 1},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := test.header.Serialize(&buf); err != nil {
+			t.Errorf("%s: Serialize: %v", test.name, err)
+			continue
+		}
+		if buf.Len() != test.size || test.header.SerializeSize() != test.size {
+			t.Errorf("%s: size got %d, SerializeSize %d, want %d",
+				test.name, buf.Len(), test.header.SerializeSize(), test.size)
+			continue
+		}
+
+		var header BlockHeader
+		if err := header.Deserialize(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Errorf("%s: Deserialize: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(&header, test.header) {
+			t.Errorf("%s: got %+v, want %+v", test.name, header, test.header)
+		}
+		if header.BlockHash() != test.header.BlockHash() {
+			t.Errorf("%s: hash mismatch", test.name)
+		}
+	}
+}
+
+// TestBlockHeaderLegacyHash ensures the headers without a cycle proof serialize
+// and hash the same as the headers of qitmeer-lib.
+func TestBlockHeaderLegacyHash(t *testing.T) {
+	header := testBlockHeader(1, nil)
+	libHeader := libtypes.BlockHeader{
+		Version:    header.Version,
+		ParentRoot: header.ParentRoot,
+		TxRoot:     header.TxRoot,
+		StateRoot:  header.StateRoot,
+		Difficulty: header.Difficulty,
+		ExNonce:    header.ExNonce,
+		Timestamp:  header.Timestamp,
+		Nonce:      header.Nonce,
+	}
+	if header.BlockHash() != libHeader.BlockHash() {
+		t.Fatalf("hash got %v, want %v", header.BlockHash(),
+			libHeader.BlockHash())
+	}
+}
+
+// TestBlockHeaderCycleProofErrors ensures the headers with too many cycle
+// nonces are rejected.
+func TestBlockHeaderCycleProofErrors(t *testing.T) {
+	header := testBlockHeader(1|CycleProofBlockVersion,
+		make([]uint32, MaxCycleNonces+1))
+	var buf bytes.Buffer
+	if err := header.Serialize(&buf); err == nil {
+		t.Fatal("Serialize: expected an error for too many cycle nonces")
+	}
+
+	// Forge the count of a serialized header past the limit.
+	header.CycleNonces = nil
+	buf.Reset()
+	if err := header.Serialize(&buf); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	serialized := buf.Bytes()
+	serialized[len(serialized)-1] = MaxCycleNonces + 1
+	var decoded BlockHeader
+	if err := decoded.Deserialize(bytes.NewReader(serialized)); err == nil {
+		t.Fatal("Deserialize: expected an error for too many cycle nonces")
+	}
+}
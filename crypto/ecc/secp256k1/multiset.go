@@ -0,0 +1,162 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2018 The bchd developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package secp256k1
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// MultisetSerializeSize is the number of bytes of a serialized multiset.  The
+// multiset is serialized as the 32-byte big endian x coordinate followed by the
+// 32-byte big endian y coordinate of the underlying point.
+const MultisetSerializeSize = 64
+
+// Multiset tracks the state of a multiset as used to calculate the ECMH
+// (elliptic curve multiset hash) hash of an unordered set.  The state is
+// a point on the curve.  New elements are hashed onto a point on the curve
+// and then added to the current state.  Hence elements can be added in any
+// order and we can also remove elements to return to a prior hash.
+//
+// The empty multiset is represented by the point at infinity.
+type Multiset struct {
+	curve *KoblitzCurve
+	x     *big.Int
+	y     *big.Int
+}
+
+// NewMultiset returns an empty multiset.  The hash of an empty multiset is
+// 32 zero bytes.
+func NewMultiset(curve *KoblitzCurve) *Multiset {
+	return &Multiset{curve: curve, x: big.NewInt(0), y: big.NewInt(0)}
+}
+
+// NewMultisetFromPoint initializes a new multiset with the given x, y
+// coordinate.
+func NewMultisetFromPoint(curve *KoblitzCurve, x, y *big.Int) *Multiset {
+	var copyX, copyY big.Int
+	if x != nil {
+		copyX.Set(x)
+	}
+	if y != nil {
+		copyY.Set(y)
+	}
+	return &Multiset{curve: curve, x: &copyX, y: &copyY}
+}
+
+// ParseMultiset deserializes a multiset which was previously serialized with
+// the Serialize method.
+func ParseMultiset(curve *KoblitzCurve, serialized []byte) (*Multiset, error) {
+	if len(serialized) != MultisetSerializeSize {
+		return nil, errors.New("malformed multiset: invalid length")
+	}
+	x := new(big.Int).SetBytes(serialized[:32])
+	y := new(big.Int).SetBytes(serialized[32:])
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return NewMultiset(curve), nil
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("malformed multiset: point is not on curve")
+	}
+	return &Multiset{curve: curve, x: x, y: y}, nil
+}
+
+// Add hashes the data onto the curve and updates the state of the multiset.
+func (ms *Multiset) Add(data []byte) {
+	x, y := hashToPoint(ms.curve, data)
+	ms.addPoint(x, y)
+}
+
+// Remove hashes the data onto the curve and subtracts the value from the
+// state.  This function will execute regardless of whether or not the passed
+// data was previously added to the set.  Hence if you remove an element that
+// was never added and also remove all the elements that were added, you will
+// not get back to the point at infinity (empty set).
+func (ms *Multiset) Remove(data []byte) {
+	x, y := hashToPoint(ms.curve, data)
+	ms.addPoint(x, new(big.Int).Sub(ms.curve.P, y))
+}
+
+// Combine will add the state of the passed multiset to the current one.
+// It is the equivalent of adding every element of the other set.
+func (ms *Multiset) Combine(other *Multiset) {
+	ms.addPoint(other.x, other.y)
+}
+
+// Point returns a copy of the x and y coordinates of the current multiset
+// state.
+func (ms *Multiset) Point() (x *big.Int, y *big.Int) {
+	return new(big.Int).Set(ms.x), new(big.Int).Set(ms.y)
+}
+
+// Clone returns an independent copy of the multiset.
+func (ms *Multiset) Clone() *Multiset {
+	return NewMultisetFromPoint(ms.curve, ms.x, ms.y)
+}
+
+// Serialize returns the serialized representation of the multiset state.
+func (ms *Multiset) Serialize() []byte {
+	serialized := make([]byte, MultisetSerializeSize)
+	xBytes := ms.x.Bytes()
+	yBytes := ms.y.Bytes()
+	copy(serialized[32-len(xBytes):32], xBytes)
+	copy(serialized[64-len(yBytes):], yBytes)
+	return serialized
+}
+
+// Hash returns the hash of the multiset state.  The hash of the empty set is
+// 32 zero bytes, otherwise it is the sha256 digest of the serialized point.
+func (ms *Multiset) Hash() [32]byte {
+	var h [32]byte
+	if ms.x.Sign() == 0 && ms.y.Sign() == 0 {
+		return h
+	}
+	return sha256.Sum256(ms.Serialize())
+}
+
+// addPoint adds the passed point to the multiset state.
+func (ms *Multiset) addPoint(x, y *big.Int) {
+	ms.x, ms.y = ms.curve.Add(ms.x, ms.y, x, y)
+}
+
+// hashToPoint maps the passed data onto a point of the curve using the
+// try-and-increment method.  A counter is prepended to the data and the
+// sha256 digest is interpreted as a candidate x coordinate until one is found
+// for which x^3+7 is a quadratic residue.  The root with an even y coordinate
+// is always chosen so that the mapping is deterministic.
+func hashToPoint(curve *KoblitzCurve, data []byte) (*big.Int, *big.Int) {
+	var counter [8]byte
+	preimage := make([]byte, 8+len(data))
+	copy(preimage[8:], data)
+
+	for i := uint64(0); ; i++ {
+		binary.LittleEndian.PutUint64(counter[:], i)
+		copy(preimage[:8], counter[:])
+		digest := sha256.Sum256(preimage)
+
+		x := new(big.Int).SetBytes(digest[:])
+		if x.Cmp(curve.P) >= 0 {
+			continue
+		}
+
+		// y^2 = x^3 + 7 (mod P)
+		x3 := new(big.Int).Mul(x, x)
+		x3.Mul(x3, x)
+		x3.Add(x3, curve.Params().B)
+		x3.Mod(x3, curve.P)
+
+		y := new(big.Int).Exp(x3, curve.QPlus1Div4(), curve.P)
+		if new(big.Int).Exp(y, big.NewInt(2), curve.P).Cmp(x3) != 0 {
+			continue
+		}
+		if isOdd(y) {
+			y.Sub(curve.P, y)
+		}
+		return x, y
+	}
+}
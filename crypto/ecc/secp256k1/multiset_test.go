@@ -0,0 +1,87 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package secp256k1
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestMultisetOrderIndependence ensures the multiset hash does not depend on
+// the order elements are added and that removing elements returns the set to
+// its prior state.
+func TestMultisetOrderIndependence(t *testing.T) {
+	elems := [][]byte{
+		[]byte("qitmeer"),
+		[]byte("utxo"),
+		{0x00},
+		{0xff, 0xfe, 0xfd},
+	}
+
+	forward := NewMultiset(S256())
+	for _, e := range elems {
+		forward.Add(e)
+	}
+	backward := NewMultiset(S256())
+	for i := len(elems) - 1; i >= 0; i-- {
+		backward.Add(elems[i])
+	}
+	if forward.Hash() != backward.Hash() {
+		t.Fatalf("hash depends on insertion order: %x != %x",
+			forward.Hash(), backward.Hash())
+	}
+
+	// Removing the last two elements must yield the same state as only
+	// adding the first two.
+	partial := NewMultiset(S256())
+	partial.Add(elems[0])
+	partial.Add(elems[1])
+	forward.Remove(elems[3])
+	forward.Remove(elems[2])
+	if forward.Hash() != partial.Hash() {
+		t.Fatalf("remove did not restore prior state: %x != %x",
+			forward.Hash(), partial.Hash())
+	}
+
+	// Removing everything results in the empty set.
+	forward.Remove(elems[1])
+	forward.Remove(elems[0])
+	if forward.Hash() != [32]byte{} {
+		t.Fatalf("expected empty multiset hash, got %x", forward.Hash())
+	}
+}
+
+// TestMultisetSerialize ensures multisets survive a serialization round trip
+// including the empty set.
+func TestMultisetSerialize(t *testing.T) {
+	empty := NewMultiset(S256())
+	ms := NewMultiset(S256())
+	ms.Add([]byte("qitmeer"))
+
+	for _, set := range []*Multiset{empty, ms} {
+		serialized := set.Serialize()
+		if len(serialized) != MultisetSerializeSize {
+			t.Fatalf("unexpected serialized size %d", len(serialized))
+		}
+		parsed, err := ParseMultiset(S256(), serialized)
+		if err != nil {
+			t.Fatalf("ParseMultiset: %v", err)
+		}
+		if !bytes.Equal(parsed.Serialize(), serialized) {
+			t.Fatalf("round trip mismatch: %x != %x",
+				parsed.Serialize(), serialized)
+		}
+		if parsed.Hash() != set.Hash() {
+			t.Fatalf("hash mismatch after round trip")
+		}
+	}
+
+	// A point which is not on the curve must be rejected.
+	bad := ms.Serialize()
+	bad[63] ^= 0x01
+	if _, err := ParseMultiset(S256(), bad); err == nil {
+		t.Fatalf("expected error parsing point not on curve")
+	}
+}
@@ -118,6 +118,11 @@ const (
 	// ErrBlockNotFound instead.
 	ErrBlockRegionInvalid
 
+	// ErrBlockPruned indicates the data of a block with the provided hash
+	// has been pruned from the database.  The header of the block is still
+	// available.
+	ErrBlockPruned
+
 	// ***********************************
 	// Support for driver-specific errors.
 	// ***********************************
@@ -154,6 +159,7 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrBlockNotFound:      "ErrBlockNotFound",
 	ErrBlockExists:        "ErrBlockExists",
 	ErrBlockRegionInvalid: "ErrBlockRegionInvalid",
+	ErrBlockPruned:        "ErrBlockPruned",
 	ErrDriverSpecific:     "ErrDriverSpecific",
 }
 
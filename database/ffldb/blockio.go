@@ -20,6 +20,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -258,6 +260,11 @@ func (s *blockStore) openFile(fileNum uint32) (*lockableFile, error) {
 	filePath := blockFilePath(s.basePath, fileNum)
 	file, err := os.Open(filePath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			str := fmt.Sprintf("block file %d has been pruned",
+				fileNum)
+			return nil, makeDbErr(database.ErrBlockPruned, str, err)
+		}
 		return nil, makeDbErr(database.ErrDriverSpecific, err.Error(),
 			err)
 	}
@@ -311,6 +318,31 @@ func (s *blockStore) deleteFile(fileNum uint32) error {
 	return nil
 }
 
+// pruneFile closes the block file for the passed flat file number when it is
+// open and removes it from disk.  Subsequent reads of blocks which were stored
+// in the file fail with ErrBlockPruned.
+func (s *blockStore) pruneFile(fileNum uint32) error {
+	s.obfMutex.Lock()
+	defer s.obfMutex.Unlock()
+
+	if obf, ok := s.openBlockFiles[fileNum]; ok {
+		s.lruMutex.Lock()
+		s.openBlocksLRU.Remove(s.fileNumToLRUElem[fileNum])
+		delete(s.fileNumToLRUElem, fileNum)
+		s.lruMutex.Unlock()
+
+		// Close the file under the write lock for the file in case any
+		// readers are currently reading from it.
+		obf.Lock()
+		_ = obf.file.Close()
+		obf.Unlock()
+
+		delete(s.openBlockFiles, fileNum)
+	}
+
+	return s.deleteFileFunc(fileNum)
+}
+
 // blockFile attempts to return an existing file handle for the passed flat file
 // number if it is already open as well as marking it as most recently used.  It
 // will also open the file when it's not already open subject to the rules
@@ -723,11 +755,19 @@ func (s *blockStore) handleRollback(oldBlockFileNum, oldBlockOffset uint32) {
 func scanBlockFiles(dbPath string) (int, uint32) {
 	lastFile := -1
 	fileLen := uint32(0)
-	for i := 0; ; i++ {
-		filePath := blockFilePath(dbPath, uint32(i))
+
+	// Older block files may have been pruned, so look at all of the block
+	// files on disk instead of stopping at the first missing one.
+	filePaths, _ := filepath.Glob(filepath.Join(dbPath, "*.fdb"))
+	for _, filePath := range filePaths {
+		fileName := strings.TrimSuffix(filepath.Base(filePath), ".fdb")
+		i, err := strconv.Atoi(fileName)
+		if err != nil || i <= lastFile {
+			continue
+		}
 		st, err := os.Stat(filePath)
 		if err != nil {
-			break
+			continue
 		}
 		lastFile = i
 
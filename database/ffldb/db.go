@@ -18,7 +18,7 @@ import (
 
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
 	"github.com/Qitmeer/qitmeer-lib/core/protocol"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/database/ffldb/treap"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -34,11 +34,6 @@ const (
 	// metadataDbName is the name used for the metadata database.
 	metadataDbName = "metadata"
 
-	// blockHdrSize is the size of a block header.  This is simply the
-	// constant from wire and is only provided here for convenience since
-	// wire.MaxBlockHeaderPayload is quite long.
-	blockHdrSize = types.MaxBlockHeaderPayload
-
 	// blockHdrOffset defines the offsets into a block index row for the
 	// block header.
 	//
@@ -968,6 +963,10 @@ type transaction struct {
 	pendingBlocks    map[hash.Hash]int
 	pendingBlockData []pendingBlock
 
+	// Block files that need to be pruned once the transaction has been
+	// committed.
+	pendingPruneFiles []uint32
+
 	// Keys that need to be stored or deleted on commit.
 	pendingKeys   *treap.Mutable
 	pendingRemove *treap.Mutable
@@ -1228,6 +1227,85 @@ func (tx *transaction) HasBlocks(hashes []hash.Hash) ([]bool, error) {
 	return results, nil
 }
 
+// PruneBlocks marks the flat block files which only contain blocks for which
+// the passed function returns true for deletion and returns the hashes of the
+// blocks that were stored in them.  The files are deleted once the transaction
+// has been committed.  The block index rows, and therefore the block headers,
+// of the pruned blocks are kept.  The file currently being written to is never
+// pruned.
+//
+// Returns the following errors as required by the interface contract:
+//   - ErrTxNotWritable if attempted against a read-only transaction
+//   - ErrTxClosed if the transaction has already been closed
+//
+// This function is part of the database.Tx interface implementation.
+func (tx *transaction) PruneBlocks(prunable func(hash *hash.Hash) bool) ([]hash.Hash, error) {
+	// Ensure transaction state is valid.
+	if err := tx.checkClosed(); err != nil {
+		return nil, err
+	}
+
+	// Ensure the transaction is writable.
+	if !tx.writable {
+		str := "prune blocks requires a writable database transaction"
+		return nil, makeDbErr(database.ErrTxNotWritable, str, nil)
+	}
+
+	wc := tx.db.store.writeCursor
+	wc.RLock()
+	curFileNum := wc.curFileNum
+	wc.RUnlock()
+
+	// Determine which of the block files still on disk only contain
+	// prunable blocks.
+	onDisk := make(map[uint32]bool)
+	candidates := make(map[uint32]bool)
+	cursor := tx.blockIdxBucket.Cursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		loc := deserializeBlockLoc(cursor.Value())
+		fileNum := loc.blockFileNum
+		if fileNum == curFileNum {
+			continue
+		}
+		exists, ok := onDisk[fileNum]
+		if !ok {
+			exists = fileExists(blockFilePath(tx.db.store.basePath,
+				fileNum))
+			onDisk[fileNum] = exists
+		}
+		if !exists {
+			continue
+		}
+
+		var blockHash hash.Hash
+		copy(blockHash[:], cursor.Key())
+		canPrune, ok := candidates[fileNum]
+		if !ok || canPrune {
+			candidates[fileNum] = prunable(&blockHash)
+		}
+	}
+
+	// Collect the blocks stored in the files to prune.
+	var pruned []hash.Hash
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		loc := deserializeBlockLoc(cursor.Value())
+		if candidates[loc.blockFileNum] {
+			var blockHash hash.Hash
+			copy(blockHash[:], cursor.Key())
+			pruned = append(pruned, blockHash)
+		}
+	}
+
+	for fileNum, canPrune := range candidates {
+		if canPrune {
+			tx.pendingPruneFiles = append(tx.pendingPruneFiles,
+				fileNum)
+		}
+	}
+
+	return pruned, nil
+}
+
 // fetchBlockRow fetches the metadata stored in the block index for the provided
 // hash.  It will return ErrBlockNotFound if there is no entry.
 func (tx *transaction) fetchBlockRow(hash *hash.Hash) ([]byte, error) {
@@ -1266,6 +1344,10 @@ func (tx *transaction) FetchBlockHeader(hash *hash.Hash) ([]byte, error) {
 	// from there.
 	if idx, exists := tx.pendingBlocks[*hash]; exists {
 		blockBytes := tx.pendingBlockData[idx].bytes
+		blockHdrSize, err := blockHeaderSize(blockBytes)
+		if err != nil {
+			return nil, err
+		}
 		return blockBytes[0:blockHdrSize:blockHdrSize], nil
 	}
 
@@ -1276,7 +1358,7 @@ func (tx *transaction) FetchBlockHeader(hash *hash.Hash) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	endOffset := blockLocSize + blockHdrSize
+	endOffset := len(blockRow)
 	return blockRow[blockLocSize:endOffset:endOffset], nil
 }
 
@@ -1315,6 +1397,10 @@ func (tx *transaction) FetchBlockHeaders(hashes []hash.Hash) ([][]byte, error) {
 		// bytes from there.
 		if idx, exists := tx.pendingBlocks[*h]; exists {
 			blkBytes := tx.pendingBlockData[idx].bytes
+			blockHdrSize, err := blockHeaderSize(blkBytes)
+			if err != nil {
+				return nil, err
+			}
 			headers[i] = blkBytes[0:blockHdrSize:blockHdrSize]
 			continue
 		}
@@ -1326,7 +1412,7 @@ func (tx *transaction) FetchBlockHeaders(hashes []hash.Hash) ([][]byte, error) {
 		if err != nil {
 			return nil, err
 		}
-		endOffset := blockLocSize + blockHdrSize
+		endOffset := len(blockRow)
 		headers[i] = blockRow[blockLocSize:endOffset:endOffset]
 	}
 
@@ -1638,6 +1724,7 @@ func (tx *transaction) close() {
 	// Clear pending blocks that would have been written on commit.
 	tx.pendingBlocks = nil
 	tx.pendingBlockData = nil
+	tx.pendingPruneFiles = nil
 
 	// Clear pending keys that would have been written or deleted on commit.
 	tx.pendingKeys = nil
@@ -1658,14 +1745,27 @@ func (tx *transaction) close() {
 	}
 }
 
+// blockHeaderSize returns the size of the header at the start of the passed
+// serialized block.  The size of a header depends on whether it carries a
+// cycle proof.
+func blockHeaderSize(blockBytes []byte) (int, error) {
+	var header types.BlockHeader
+	err := header.Deserialize(bytes.NewReader(blockBytes))
+	if err != nil {
+		str := "failed to deserialize block header"
+		return 0, makeDbErr(database.ErrCorruption, str, err)
+	}
+	return header.SerializeSize(), nil
+}
+
 // serializeBlockRow serializes a block row into a format suitable for storage
 // into the block index.
 func serializeBlockRow(blockLoc blockLocation, blockHdr []byte) []byte {
 	// The serialized block index row format is:
 	//
 	//  [0:blockLocSize]                          Block location
-	//  [blockLocSize:blockLocSize+blockHdrSize]  Block header
-	serializedRow := make([]byte, blockLocSize+blockHdrSize)
+	//  [blockLocSize:blockLocSize+len(blockHdr)] Block header
+	serializedRow := make([]byte, blockLocSize+len(blockHdr))
 	copy(serializedRow, serializeBlockLoc(blockLoc))
 	copy(serializedRow[blockHdrOffset:], blockHdr)
 	return serializedRow
@@ -1708,6 +1808,11 @@ func (tx *transaction) writePendingAndCommit() error {
 		// includes the location information needed to locate the block
 		// on the filesystem as well as the block header since they are
 		// so commonly needed.
+		blockHdrSize, err := blockHeaderSize(blockData.bytes)
+		if err != nil {
+			rollback()
+			return err
+		}
 		blockHdr := blockData.bytes[0:blockHdrSize]
 		blockRow := serializeBlockRow(location, blockHdr)
 		err = tx.blockIdxBucket.Put(blockData.hash[:], blockRow)
@@ -1726,7 +1831,21 @@ func (tx *transaction) writePendingAndCommit() error {
 
 	// Atomically update the database cache.  The cache automatically
 	// handles flushing to the underlying persistent storage database.
-	return tx.db.cache.commitTx(tx)
+	if err := tx.db.cache.commitTx(tx); err != nil {
+		return err
+	}
+
+	// Delete the pruned block files now that the transaction can no
+	// longer be rolled back.  A file which fails to be deleted is only
+	// logged since it is pruned again the next time.
+	for _, fileNum := range tx.pendingPruneFiles {
+		dblog.Debug("Pruning block file", "fileNum", fileNum)
+		if err := tx.db.store.pruneFile(fileNum); err != nil {
+			dblog.Warn("Failed to prune block file", "fileNum",
+				fileNum, "error", err)
+		}
+	}
+	return nil
 }
 
 // Commit commits all changes that have been made to the root metadata bucket
@@ -0,0 +1,147 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package ffldb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer-lib/core/protocol"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+)
+
+// testPruneBlocks returns blocks which all have the same serialized size.
+func testPruneBlocks(count int) []*types.SerializedBlock {
+	blocks := make([]*types.SerializedBlock, count)
+	for i := range blocks {
+		blocks[i] = types.NewBlock(&types.Block{
+			Header: types.BlockHeader{
+				Version:   1,
+				Timestamp: time.Unix(1568000000, 0),
+				Nonce:     uint64(i),
+			},
+		})
+	}
+	return blocks
+}
+
+// storeOneBlockPerFile limits the size of the block files of the passed
+// database to a single block of the passed size.
+func storeOneBlockPerFile(t *testing.T, pdb database.DB, block *types.SerializedBlock) {
+	blockBytes, err := block.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+	pdb.(*db).store.maxBlockFileSize = uint32(len(blockBytes) + 12)
+}
+
+// checkPrunedBlocks ensures the data of the first numPruned blocks has been
+// pruned while their headers are kept, and the rest of the blocks are intact.
+func checkPrunedBlocks(t *testing.T, pdb database.DB, blocks []*types.SerializedBlock, numPruned int) {
+	err := pdb.View(func(dbTx database.Tx) error {
+		for i, block := range blocks {
+			if _, err := dbTx.FetchBlockHeader(block.Hash()); err != nil {
+				t.Errorf("block %d: FetchBlockHeader: %v", i, err)
+			}
+			_, err := dbTx.FetchBlock(block.Hash())
+			if i < numPruned {
+				if !database.IsError(err, database.ErrBlockPruned) {
+					t.Errorf("block %d: FetchBlock got %v, "+
+						"want ErrBlockPruned", i, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("block %d: FetchBlock: %v", i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %v", err)
+	}
+}
+
+// TestPruneBlocks ensures the block files are only pruned once the transaction
+// is committed and the pruned blocks stay pruned after the database is
+// reopened.
+func TestPruneBlocks(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "ffldb-prune")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+
+	pdb, err := database.Create(dbType, dbPath, protocol.MainNet)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// Store every block in its own block file.
+	blocks := testPruneBlocks(5)
+	storeOneBlockPerFile(t, pdb, blocks[0])
+	for i, block := range blocks[:4] {
+		err := pdb.Update(func(dbTx database.Tx) error {
+			return dbTx.StoreBlock(block)
+		})
+		if err != nil {
+			t.Fatalf("block %d: StoreBlock: %v", i, err)
+		}
+	}
+
+	prunable := func(h *hash.Hash) bool {
+		return h.IsEqual(blocks[0].Hash()) || h.IsEqual(blocks[1].Hash())
+	}
+
+	// Nothing is pruned when the transaction is rolled back.
+	errRollback := errors.New("rollback")
+	err = pdb.Update(func(dbTx database.Tx) error {
+		hashes, err := dbTx.PruneBlocks(prunable)
+		if err != nil {
+			return err
+		}
+		if len(hashes) != 2 {
+			t.Errorf("PruneBlocks: got %d hashes, want 2", len(hashes))
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatalf("Update: got %v, want %v", err, errRollback)
+	}
+	checkPrunedBlocks(t, pdb, blocks[:4], 0)
+
+	err = pdb.Update(func(dbTx database.Tx) error {
+		_, err := dbTx.PruneBlocks(prunable)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("PruneBlocks: %v", err)
+	}
+	checkPrunedBlocks(t, pdb, blocks[:4], 2)
+
+	// The pruned blocks stay pruned after a restart and new blocks are
+	// still stored after the last block file.
+	if err := pdb.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	pdb, err = database.Open(dbType, dbPath, protocol.MainNet)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer pdb.Close()
+	storeOneBlockPerFile(t, pdb, blocks[0])
+	err = pdb.Update(func(dbTx database.Tx) error {
+		return dbTx.StoreBlock(blocks[4])
+	})
+	if err != nil {
+		t.Fatalf("StoreBlock: %v", err)
+	}
+	checkPrunedBlocks(t, pdb, blocks, 2)
+}
@@ -11,7 +11,7 @@ package database
 
 import (
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 )
 
 // Cursor represents a cursor over key/value pairs and nested buckets of a
@@ -301,6 +301,22 @@ type Tx interface {
 	// implementations.
 	FetchBlockHeaders(hashes []hash.Hash) ([][]byte, error)
 
+	// PruneBlocks deletes the stored data of blocks for which the passed
+	// function returns true and returns the hashes of the blocks that were
+	// pruned.  The headers of pruned blocks are kept and remain available
+	// through FetchBlockHeader(s).  Implementations may store several
+	// blocks together, in which case data is only deleted when every block
+	// stored alongside it is prunable too.
+	//
+	// The interface contract guarantees at least the following errors will
+	// be returned (other implementation-specific errors are possible):
+	//   - ErrTxNotWritable if attempted against a read-only transaction
+	//   - ErrTxClosed if the transaction has already been closed
+	//
+	// NOTE: The data of pruned blocks is only deleted once the transaction
+	// has been committed and is kept if the transaction is rolled back.
+	PruneBlocks(prunable func(hash *hash.Hash) bool) ([]hash.Hash, error)
+
 	// FetchBlock returns the raw serialized bytes for the block identified
 	// by the given hash.  The raw bytes are in the format returned by
 	// Serialize on a wire.MsgBlock.
@@ -308,6 +324,7 @@ type Tx interface {
 	// The interface contract guarantees at least the following errors will
 	// be returned (other implementation-specific errors are possible):
 	//   - ErrBlockNotFound if the requested block hash does not exist
+	//   - ErrBlockPruned if the data of the requested block was pruned
 	//   - ErrTxClosed if the transaction has already been closed
 	//   - ErrCorruption if the database has somehow become corrupted
 	//
@@ -0,0 +1,47 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"github.com/Qitmeer/qitmeer-lib/params/btc/types"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// BtcScriptTx wraps a bitcoin transaction so it implements the ScriptTx
+// interface of the core types, which is needed to sign and verify its scripts.
+type BtcScriptTx struct {
+	*btctypes.BtcTx
+}
+
+// Ensure the BtcScriptTx type implements the ScriptTx interface.
+var _ types.ScriptTx = (*BtcScriptTx)(nil)
+
+// NewBtcScriptTx returns the passed bitcoin transaction as a ScriptTx.
+func NewBtcScriptTx(tx *btctypes.BtcTx) *BtcScriptTx {
+	return &BtcScriptTx{tx}
+}
+
+// GetInput returns the inputs of the transaction.
+func (tx *BtcScriptTx) GetInput() []types.Input {
+	inputs := make([]types.Input, len(tx.TxIn))
+	for i, txIn := range tx.TxIn {
+		inputs[i] = txIn
+	}
+	return inputs
+}
+
+// GetOutput returns the outputs of the transaction.
+func (tx *BtcScriptTx) GetOutput() []types.Output {
+	outputs := make([]types.Output, len(tx.TxOut))
+	for i, txOut := range tx.TxOut {
+		outputs[i] = txOut
+	}
+	return outputs
+}
+
+// GetType returns the script type of the transaction.
+func (tx *BtcScriptTx) GetType() types.ScriptTxType {
+	return types.BtcScriptTx
+}
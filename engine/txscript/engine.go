@@ -8,9 +8,9 @@ package txscript
 
 import (
 	"fmt"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer-lib/crypto/ecc"
 	"github.com/Qitmeer/qitmeer-lib/params/btc/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"math/big"
 )
 
@@ -764,8 +764,8 @@ func NewEngine2(scriptPubKey []byte, tx types.ScriptTx, txIdx int,
 		qitmeertx, _ := tx.(*types.Transaction)
 		vm.tx = *qitmeertx
 	case types.BtcScriptTx:
-		btctx, _ := tx.(*btctypes.BtcTx)
-		vm.btctx = *btctx
+		btctx, _ := tx.(*BtcScriptTx)
+		vm.btctx = *btctx.BtcTx
 	}
 	vm.txIdx = txIdx
 
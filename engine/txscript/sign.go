@@ -14,7 +14,7 @@ import (
 	"encoding/binary"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
 	"github.com/Qitmeer/qitmeer-lib/common/hash/btc"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer-lib/crypto/ecc"
 	"github.com/Qitmeer/qitmeer-lib/params/btc/types"
 	"github.com/Qitmeer/qitmeer/core/address"
@@ -972,7 +972,7 @@ func calcSignatureHash2(prevOutScript []ParsedOpcode, hashType SigHashType, txSc
 // calcSignatureHash_btc (refactor of btcd's calcSignatureHash)
 func calcSignatureHash_btc(script []ParsedOpcode, hashType SigHashType, scriptTx types.ScriptTx, idx int) []byte {
 
-	tx := scriptTx.(*btctypes.BtcTx)
+	tx := scriptTx.(*BtcScriptTx).BtcTx
 
 	// The SigHashSingle signature type signs only the corresponding input
 	// and output (the output with the same index number as the input).
@@ -10,8 +10,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/core/address"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/params"
 	//"github.com/Qitmeer/qitmeer-lib/crypto/ecc"
 	"github.com/Qitmeer/qitmeer-lib/crypto/ecc"
@@ -1170,6 +1170,20 @@ func PushedData(script []byte) ([][]byte, error) {
 	return data, nil
 }
 
+// ExtractNullData returns the data pushed by the passed null data script, which
+// is empty for a lone OP_RETURN.  It returns false when the script isn't a null
+// data script.
+func ExtractNullData(script []byte) ([]byte, bool) {
+	pops, err := parseScript(script)
+	if err != nil || !isNullData(pops) {
+		return nil, false
+	}
+	if len(pops) == 1 {
+		return nil, true
+	}
+	return pops[1].data, true
+}
+
 // GetMultisigMandN returns the number of public keys and the number of
 // signatures required to redeem the multisignature script.
 func GetMultisigMandN(script []byte) (uint8, uint8, error) {
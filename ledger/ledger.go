@@ -4,9 +4,9 @@ import (
 	"encoding/hex"
 	"fmt"
 	//"github.com/Qitmeer/qitmeer-lib/core/protocol"
-	//"github.com/Qitmeer/qitmeer-lib/core/types"
+	//"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer-lib/core/protocol"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 )
 
 // TokenPayout is a payout for block 1 which specifies an address and an amount
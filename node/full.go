@@ -11,6 +11,7 @@ import (
 	"github.com/Qitmeer/qitmeer/p2p/peerserver"
 	"github.com/Qitmeer/qitmeer/services/acct"
 	"github.com/Qitmeer/qitmeer/services/blkmgr"
+	"github.com/Qitmeer/qitmeer/services/cf"
 	"github.com/Qitmeer/qitmeer/services/common"
 	"github.com/Qitmeer/qitmeer/services/index"
 	"github.com/Qitmeer/qitmeer/services/mempool"
@@ -37,11 +38,17 @@ type QitmeerFull struct {
 
 	// miner service
 	cpuMiner             *miner.CPUMiner
+	// stratum server of the remote miners
+	stratumServer        *miner.StratumServer
 
 	// clock time service
 	timeSource    		 blockchain.MedianTimeSource
 	// signature cache
 	sigCache             *txscript.SigCache
+	// committed filter index
+	cfIndex              *cf.CfIndex
+	// optional index manager
+	indexManager         *index.Manager
 }
 
 func (qm *QitmeerFull) Start(server *peerserver.PeerServer) error {
@@ -52,14 +59,35 @@ func (qm *QitmeerFull) Start(server *peerserver.PeerServer) error {
 		qm.cpuMiner.Start()
 	}
 
+	// The wallet catches up with the blocks before the block manager
+	// notifies it of new ones.
+	err := qm.acctmanager.Start()
+	if err != nil {
+		return err
+	}
 	qm.blockManager.Start()
 	qm.txManager.Start()
+	qm.indexManager.Start()
+	if qm.stratumServer != nil {
+		err = qm.stratumServer.Start()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (qm *QitmeerFull) Stop() error {
 	log.Debug("Stopping Qitmeer full node service")
 
+	if qm.stratumServer != nil {
+		qm.stratumServer.Stop()
+	}
+
+	// Interrupt the index catch-ups and drops, an interrupted drop is
+	// resumed on the next start.
+	qm.indexManager.Stop()
+
 	log.Info("try stop bm")
 
 	qm.blockManager.Stop()
@@ -67,6 +95,8 @@ func (qm *QitmeerFull) Stop() error {
 
 	qm.txManager.Stop()
 
+	qm.acctmanager.Stop()
+
 	log.Info("try stop cpu miner")
 	// Stop the CPU miner if needed.
 	if qm.node.Config.Generate && qm.cpuMiner != nil {
@@ -81,55 +111,95 @@ func (qm *QitmeerFull)	APIs() []rpc.API {
 	apis = append(apis,qm.cpuMiner.APIs()...)
 	apis = append(apis,qm.blockManager.API())
 	apis = append(apis,qm.txManager.APIs()...)
+	apis = append(apis,qm.indexManager.APIs()...)
 	apis = append(apis,qm.API())
+	if qm.cfIndex != nil {
+		apis = append(apis,qm.cfIndex.API())
+	}
+	if qm.node.rpcServer != nil {
+		apis = append(apis,qm.node.rpcServer.NotificationAPI())
+	}
 	return apis
 }
 func newQitmeerFullNode(node *Node) (*QitmeerFull, error){
 
-	// account manager
-	acctmgr, err := acct.New()
-	if err != nil{
-		return nil,err
-	}
 	qm := QitmeerFull{
 		node:         node,
 		db:           node.DB,
-		acctmanager:  acctmgr,
 		timeSource:   blockchain.NewMedianTime(),
 		sigCache:     txscript.NewSigCache(node.Config.SigCacheMaxSize),
 	}
-	// Create the transaction and address indexes if needed.
+	// Create the transaction and address indexes.  The disabled ones are
+	// known to the index manager as well, so they can be started while
+	// the node is running.
 	var indexes []index.Indexer
+	var disabledIndexes []index.Indexer
 	cfg := node.Config
 
-	var txIndex *index.TxIndex
-	var addrIndex *index.AddrIndex
-	if cfg.TxIndex || cfg.AddrIndex {
+	txIndex := index.NewTxIndex(qm.db)
+	if cfg.TxIndex || cfg.AddrIndex || cfg.SpentIndex || cfg.NullDataIndex {
 		if !cfg.TxIndex {
 			log.Info("Transaction index enabled because it " +
-				"is required by the address index")
+				"is required by the address, spent and null data indexes")
 			cfg.TxIndex = true
 		} else {
 			log.Info("Transaction index is enabled")
 		}
-		txIndex = index.NewTxIndex(qm.db)
 		indexes = append(indexes, txIndex)
+	} else {
+		disabledIndexes = append(disabledIndexes, txIndex)
 	}
+	addrIndex := index.NewAddrIndex(qm.db, node.Params)
 	if cfg.AddrIndex {
 		log.Info("Address index is enabled")
-		addrIndex = index.NewAddrIndex(qm.db, node.Params)
 		indexes = append(indexes, addrIndex)
+	} else {
+		disabledIndexes = append(disabledIndexes, addrIndex)
+	}
+	spentIndex := index.NewSpentIndex(qm.db)
+	if cfg.SpentIndex {
+		log.Info("Spent index is enabled")
+		indexes = append(indexes, spentIndex)
+	} else {
+		disabledIndexes = append(disabledIndexes, spentIndex)
+	}
+	nullDataIndex := index.NewNullDataIndex(qm.db)
+	if cfg.NullDataIndex {
+		log.Info("Null data index is enabled")
+		indexes = append(indexes, nullDataIndex)
+	} else {
+		disabledIndexes = append(disabledIndexes, nullDataIndex)
+	}
+	addrUtxoIndex := index.NewAddrUtxoIndex(qm.db, node.Params)
+	if cfg.AddrUtxoIndex {
+		log.Info("Address utxo index is enabled")
+		indexes = append(indexes, addrUtxoIndex)
+	} else {
+		disabledIndexes = append(disabledIndexes, addrUtxoIndex)
+	}
+	timeIndex := index.NewTimeIndex(qm.db)
+	if cfg.TimeIndex {
+		log.Info("Time index is enabled")
+		indexes = append(indexes, timeIndex)
+	} else {
+		disabledIndexes = append(disabledIndexes, timeIndex)
+	}
+	if !cfg.NoCFilters {
+		log.Info("Committed filter index is enabled")
+		qm.cfIndex = cf.NewCfIndex(qm.db)
+		indexes = append(indexes, qm.cfIndex)
 	}
 	// index-manager
-	var indexManager blockchain.IndexManager
-	if len(indexes) > 0 {
-		indexManager = index.NewManager(qm.db,indexes,node.Params)
+	qm.indexManager = index.NewManager(qm.db,indexes,node.Params)
+	for _, indexer := range disabledIndexes {
+		qm.indexManager.AddIndex(indexer)
 	}
 
-	qm.nfManager = &notifymgr.NotifyMgr{Server:node.peerServer, RpcServer:node.rpcServer}
+	nfManager := &notifymgr.NotifyMgr{Server:node.peerServer, RpcServer:node.rpcServer}
+	qm.nfManager = nfManager
 
 	// block-manager
-	bm, err := blkmgr.NewBlockManager(qm.nfManager,indexManager,node.DB, qm.timeSource, qm.sigCache, node.Config, node.Params,
+	bm, err := blkmgr.NewBlockManager(qm.nfManager,qm.indexManager,timeIndex,node.DB, qm.timeSource, qm.sigCache, node.Config, node.Params,
 		mining.BlockVersion(node.Params.Net),node.quit)
 	if err != nil {
 		return nil, err
@@ -137,7 +207,7 @@ func newQitmeerFullNode(node *Node) (*QitmeerFull, error){
 	qm.blockManager = bm
 
 	// txmanager
-	tm,err:=tx.NewTxManager(bm,txIndex,addrIndex,cfg,qm.nfManager,qm.sigCache,node.DB)
+	tm,err:=tx.NewTxManager(bm,txIndex,addrIndex,spentIndex,nullDataIndex,addrUtxoIndex,cfg,qm.nfManager,qm.sigCache,node.DB)
 	if err != nil {
 		return nil, err
 	}
@@ -147,6 +217,16 @@ func newQitmeerFullNode(node *Node) (*QitmeerFull, error){
 	node.peerServer.BlockManager = bm
 	node.peerServer.TimeSource = qm.timeSource
 	node.peerServer.TxMemPool = qm.txManager.MemPool().(*mempool.TxPool)
+	node.peerServer.CfIndex = qm.cfIndex
+
+	// account manager
+	acctmgr, err := acct.New(cfg, node.Params, node.DB, bm,
+		qm.txManager.MemPool().(*mempool.TxPool), qm.nfManager)
+	if err != nil{
+		return nil,err
+	}
+	qm.acctmanager = acctmgr
+	nfManager.AcctManager = acctmgr
 
 	// Cpu Miner
 	// Create the mining policy based on the configuration options.
@@ -168,6 +248,10 @@ func newQitmeerFullNode(node *Node) (*QitmeerFull, error){
 
 	qm.cpuMiner = miner.NewCPUMiner(cfg,node.Params,&policy,qm.sigCache,
 		qm.txManager.MemPool().(*mempool.TxPool),qm.timeSource,qm.blockManager,defaultNumWorkers)
+	nfManager.CPUMiner = qm.cpuMiner
+	if cfg.StratumListen != "" {
+		qm.stratumServer = miner.NewStratumServer(qm.cpuMiner, cfg.StratumListen)
+	}
 
 	return &qm, nil
 }
@@ -3,9 +3,11 @@ package node
 
 import (
 	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/rpc"
 	"github.com/Qitmeer/qitmeer/p2p/peerserver"
+	"github.com/Qitmeer/qitmeer/services/spv"
 )
 
 // QitmeerLight implements the qitmeer light node service.
@@ -13,26 +15,43 @@ type QitmeerLight struct {
 	// database
 	db               database.DB
 	config           *config.Config
+	// clock time service
+	timeSource       blockchain.MedianTimeSource
+	// header sync manager
+	syncManager      *spv.SyncManager
 }
 
 func (light *QitmeerLight) Start(server *peerserver.PeerServer) error {
 	log.Debug("Starting Qitmeer light node service")
+	light.syncManager.Start()
 	return nil
 }
 
 func (light *QitmeerLight) Stop() error {
 	log.Debug("Stopping Qitmeer light node service")
+	light.syncManager.Stop()
+	light.syncManager.WaitForStop()
 	return nil
 }
 
 func (light *QitmeerLight)	APIs() []rpc.API {
-	return []rpc.API{}
+	return []rpc.API{light.syncManager.API()}
 }
 
 func newQitmeerLight(n *Node) (*QitmeerLight, error){
 	light := QitmeerLight{
 		config : n.Config,
 		db : n.DB,
+		timeSource: blockchain.NewMedianTime(),
 	}
+	sm, err := spv.NewSyncManager(n.DB, light.timeSource, n.Config, n.Params)
+	if err != nil {
+		return nil, err
+	}
+	light.syncManager = sm
+
+	// prepare peerServer
+	n.peerServer.TimeSource = light.timeSource
+	n.peerServer.SpvManager = sm
 	return &light, nil
 }
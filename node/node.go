@@ -251,8 +251,8 @@ func (n *Node)registerQitmeerLight() error{
 // return qitmeer full
 func (n *Node) GetQitmeerFull() *QitmeerFull{
 	for _,server:=range n.runningSvcs{
-		fullqm:=server.(*QitmeerFull)
-		if fullqm!=nil {
+		fullqm, ok := server.(*QitmeerFull)
+		if ok {
 			return fullqm
 		}
 	}
@@ -1,8 +1,9 @@
 package notify
 
 import (
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
 )
 
 // Notify interface manage message announce & relay & notification between mempool, websocket, gbt long pull
@@ -11,4 +12,7 @@ type Notify interface {
 	AnnounceNewTransactions(newTxs []*types.Tx)
 	RelayInventory(invVect *message.InvVect, data interface{})
 	BroadcastMessage(msg message.Message)
+	NotifyBlockConnected(block *types.SerializedBlock)
+	NotifyBlockDisconnected(block *types.SerializedBlock)
+	NotifyReorganization(rd *blockchain.ReorganizationNotifyData)
 }
@@ -90,12 +90,17 @@ type MessageListeners struct {
 	// OnGraphState
 	OnGraphState func(p *Peer, msg *message.MsgGraphState)
 
-	/*
-	// OnSendHeaders is invoked when a peer receives a sendheaders message.
-	OnSendHeaders func(p *Peer, msg *message.MsgSendHeaders)
+	// OnGetCFilter is invoked when a peer receives a getcfilter wire
+	// message.
+	OnGetCFilter func(p *Peer, msg *message.MsgGetCFilter)
 
-	// OnMemPool is invoked when a peer receives a mempool wire message.
-	OnMemPool func(p *Peer, msg *message.MsgMemPool)
+	// OnGetCFHeaders is invoked when a peer receives a getcfheaders
+	// wire message.
+	OnGetCFHeaders func(p *Peer, msg *message.MsgGetCFHeaders)
+
+	// OnGetCFTypes is invoked when a peer receives a getcftypes wire
+	// message.
+	OnGetCFTypes func(p *Peer, msg *message.MsgGetCFTypes)
 
 	// OnCFilter is invoked when a peer receives a cfilter wire message.
 	OnCFilter func(p *Peer, msg *message.MsgCFilter)
@@ -107,23 +112,31 @@ type MessageListeners struct {
 	// OnCFTypes is invoked when a peer receives a cftypes wire message.
 	OnCFTypes func(p *Peer, msg *message.MsgCFTypes)
 
-	// OnHeaders is invoked when a peer receives a headers wire message.
-	OnHeaders func(p *Peer, msg *message.MsgHeaders)
+	// OnFilterAdd is invoked when a peer receives a filteradd wire message.
+	OnFilterAdd func(p *Peer, msg *message.MsgFilterAdd)
 
-	// OnGetCFilter is invoked when a peer receives a getcfilter wire
+	// OnFilterClear is invoked when a peer receives a filterclear wire
 	// message.
-	OnGetCFilter func(p *Peer, msg *message.MsgGetCFilter)
+	OnFilterClear func(p *Peer, msg *message.MsgFilterClear)
 
-	// OnGetCFHeaders is invoked when a peer receives a getcfheaders
-	// wire message.
-	OnGetCFHeaders func(p *Peer, msg *message.MsgGetCFHeaders)
+	// OnFilterLoad is invoked when a peer receives a filterload wire
+	// message.
+	OnFilterLoad func(p *Peer, msg *message.MsgFilterLoad)
 
-	// OnGetCFTypes is invoked when a peer receives a getcftypes wire
+	// OnMerkleBlock is invoked when a peer receives a merkleblock wire
 	// message.
-	OnGetCFTypes func(p *Peer, msg *message.MsgGetCFTypes)
+	OnMerkleBlock func(p *Peer, msg *message.MsgMerkleBlock)
+
+	// OnSendHeaders is invoked when a peer receives a sendheaders message.
+	OnSendHeaders func(p *Peer, msg *message.MsgSendHeaders)
+
+	// OnMemPool is invoked when a peer receives a mempool wire message.
+	OnMemPool func(p *Peer, msg *message.MsgMemPool)
+
+	// OnHeaders is invoked when a peer receives a headers wire message.
+	OnHeaders func(p *Peer, msg *message.MsgHeaders)
 
 	// OnFeeFilter is invoked when a peer receives a feefilter wire message.
 	OnFeeFilter func(p *Peer, msg *message.MsgFeeFilter)
-	*/
 }
 
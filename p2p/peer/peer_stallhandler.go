@@ -85,9 +85,12 @@ out:
 					fallthrough
 				case wire.CmdTx:
 					fallthrough
+				case message.CmdMerkleBlock:
+					fallthrough
 				case wire.CmdNotFound:
 					delete(pendingResponses, wire.CmdBlock)
 					delete(pendingResponses, wire.CmdTx)
+					delete(pendingResponses, message.CmdMerkleBlock)
 					delete(pendingResponses, wire.CmdNotFound)
 
 				default:
@@ -216,9 +219,10 @@ func (p *Peer) maybeAddDeadline(pendingResponses map[string]time.Time, msgCmd st
 		pendingResponses[wire.CmdInv] = deadline
 
 	case message.CmdGetData:
-		// Expects a block, tx, or notfound message.
+		// Expects a block, merkleblock, tx, or notfound message.
 		pendingResponses[wire.CmdBlock] = deadline
 		pendingResponses[wire.CmdTx] = deadline
+		pendingResponses[message.CmdMerkleBlock] = deadline
 		pendingResponses[wire.CmdNotFound] = deadline
 
 	case message.CmdGetHeaders:
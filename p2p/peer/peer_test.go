@@ -0,0 +1,127 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// pipeConn is a net.Conn of a net.Pipe with the addresses of a TCP connection.
+type pipeConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+// pipeConns returns the two ends of a net.Pipe with the passed addresses.
+func pipeConns(addrA, addrB string) (*pipeConn, *pipeConn) {
+	a, b := net.Pipe()
+	tcpA, _ := net.ResolveTCPAddr("tcp", addrA)
+	tcpB, _ := net.ResolveTCPAddr("tcp", addrB)
+	return &pipeConn{a, tcpA, tcpB}, &pipeConn{b, tcpB, tcpA}
+}
+
+// testGS returns a graph state with a single tip.
+func testGS() *blockdag.GraphState {
+	gs := blockdag.NewGraphState()
+	tips := blockdag.NewHashSet()
+	tips.Add(&hash.ZeroHash)
+	gs.SetTips(tips)
+	gs.SetTotal(1)
+	return gs
+}
+
+// newestTestGS returns the graph state advertised by the test peers.
+func newestTestGS() (*blockdag.GraphState, error) {
+	return testGS(), nil
+}
+
+// TestSendHeaders ensures a peer asked with a sendheaders message after the
+// version negotiation prefers headers, and the headers it sends are delivered
+// to the listener of the remote peer.
+func TestSendHeaders(t *testing.T) {
+	allowSelfConns = true
+	defer func() { allowSelfConns = false }()
+
+	headers := make(chan *message.MsgHeaders, 1)
+	inCfg := &Config{
+		NewestGS: newestTestGS,
+		Listeners: MessageListeners{
+			// The inbound peer asks for headers once it knows the
+			// version of the remote peer, like the server does.
+			OnVersion: func(p *Peer, msg *message.MsgVersion) *message.MsgReject {
+				p.QueueMessage(message.NewMsgSendHeaders(), nil)
+				return nil
+			},
+			OnHeaders: func(p *Peer, msg *message.MsgHeaders) {
+				headers <- msg
+			},
+		},
+	}
+	sendHeaders := make(chan struct{}, 1)
+	outCfg := &Config{
+		NewestGS: newestTestGS,
+		Listeners: MessageListeners{
+			OnSendHeaders: func(p *Peer, msg *message.MsgSendHeaders) {
+				sendHeaders <- struct{}{}
+			},
+		},
+	}
+
+	inConn, outConn := pipeConns("10.0.0.1:18130", "10.0.0.2:18130")
+	inPeer := NewInboundPeer(inCfg)
+	outPeer, err := NewOutboundPeer(outCfg, "10.0.0.1:18130")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: %v", err)
+	}
+	inPeer.AssociateConnection(inConn)
+	outPeer.AssociateConnection(outConn)
+	defer inPeer.Disconnect()
+	defer outPeer.Disconnect()
+
+	select {
+	case <-sendHeaders:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("sendheaders message not received")
+	}
+	if !outPeer.WantsHeaders() {
+		t.Fatalf("outbound peer does not prefer headers")
+	}
+	if inPeer.WantsHeaders() {
+		t.Fatalf("inbound peer prefers headers without a sendheaders " +
+			"message")
+	}
+
+	header := types.BlockHeader{
+		Version:   1,
+		Timestamp: time.Unix(1568000000, 0),
+		Nonce:     1,
+	}
+	msg := message.NewMsgHeaders(testGS())
+	if err := msg.AddBlockHeader(&header); err != nil {
+		t.Fatalf("AddBlockHeader: %v", err)
+	}
+	outPeer.QueueMessage(msg, nil)
+
+	select {
+	case got := <-headers:
+		if len(got.Headers) != 1 ||
+			got.Headers[0].BlockHash() != header.BlockHash() {
+			t.Fatalf("got headers %v, want the header of block %v",
+				got.Headers, header.BlockHash())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("headers message not received")
+	}
+}
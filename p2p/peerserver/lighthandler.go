@@ -0,0 +1,125 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package peerserver
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/core/protocol"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/log"
+	"github.com/Qitmeer/qitmeer/p2p/peer"
+	"github.com/satori/go.uuid"
+)
+
+// newLightPeerConfig returns the configuration for the given serverPeer of a
+// light node.  A light node only syncs the headers and fetches transactions
+// on demand, so it serves no blocks and transactions to its peers.
+func newLightPeerConfig(sp *serverPeer) *peer.Config {
+	return &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVersion:     sp.OnLightVersion,
+			OnGetAddr:     sp.OnGetAddr,
+			OnAddr:        sp.OnAddr,
+			OnRead:        sp.OnRead,
+			OnWrite:       sp.OnWrite,
+			OnInv:         sp.OnLightInv,
+			OnMerkleBlock: sp.OnLightMerkleBlock,
+			OnTx:          sp.OnLightTx,
+			OnGraphState:  sp.OnGraphState,
+		},
+		NewestGS:         sp.newestLightGS,
+		HostToNetAddress: sp.server.addrManager.HostToNetAddress,
+		UserAgentName:    userAgentName,
+		UserAgentVersion: userAgentVersion,
+		ChainParams:      sp.server.chainParams,
+		Services:         sp.server.services,
+		DisableRelayTx:   true,
+		ProtocolVersion:  maxProtocolVersion,
+	}
+}
+
+// newestLightGS returns the graph state of the headers synced by the light
+// node using the format required by the configuration for the peer package.
+func (sp *serverPeer) newestLightGS() (*blockdag.GraphState, error) {
+	return sp.server.SpvManager.GraphState(), nil
+}
+
+// OnLightVersion is invoked when a peer of a light node receives a version
+// wire message.  Outbound peers must be able to serve merkle blocks, since
+// that is how the headers and the transaction proofs are fetched.
+func (sp *serverPeer) OnLightVersion(p *peer.Peer, msg *message.MsgVersion) *message.MsgReject {
+	if !uuid.Equal(p.UUID(), uuid.Nil) && sp.server.HasPeer(p.UUID()) {
+		return message.NewMsgReject(msg.Command(), message.RejectDuplicate, "duplicate peer version message")
+	}
+	isInbound := sp.Inbound()
+	remoteAddr := sp.NA()
+	addrManager := sp.server.addrManager
+	if !sp.server.cfg.PrivNet && !isInbound {
+		addrManager.SetServices(remoteAddr, msg.Services)
+	}
+
+	// Ignore peers that have a protcol version that is too old.  The peer
+	// negotiation logic will disconnect it after this callback returns.
+	if msg.ProtocolVersion < int32(protocol.InitialProcotolVersion) {
+		return nil
+	}
+
+	// Reject outbound peers that are not full nodes serving bloom filters.
+	wantServices := protocol.Full | protocol.Bloom
+	if !isInbound && !protocol.HasServices(msg.Services, wantServices) {
+		missingServices := protocol.MissingServices(msg.Services, wantServices)
+		log.Debug(fmt.Sprintf("Rejecting peer %s with services %v due to not "+
+			"providing desired services %v", sp.Peer, msg.Services,
+			missingServices))
+		reason := fmt.Sprintf("required services %#x not offered",
+			uint64(missingServices))
+		return message.NewMsgReject(msg.Command(), message.RejectNonstandard, reason)
+	}
+
+	// Request known addresses from the remote peer for outbound
+	// connections.  The local address is not advertised since a light node
+	// has nothing to serve.
+	if !sp.server.cfg.PrivNet && !isInbound {
+		if addrManager.NeedMoreAddresses() {
+			p.QueueMessage(message.NewMsgGetAddr(), nil)
+		}
+		addrManager.Good(remoteAddr)
+	}
+
+	// Add the remote peer time as a sample for creating an offset against
+	// the local clock to keep the network time in sync.
+	sp.server.TimeSource.AddTimeSample(p.Addr(), msg.Timestamp)
+
+	// Signal the sync manager this peer is a new sync candidate.
+	sp.server.SpvManager.NewPeer(sp.syncPeer)
+
+	// Add valid peer to the server.
+	sp.server.AddPeer(sp)
+	return nil
+}
+
+// OnLightInv is invoked when a peer of a light node receives an inv message.
+// The blocks it advertises are passed to the sync manager.
+func (sp *serverPeer) OnLightInv(p *peer.Peer, msg *message.MsgInv) {
+	if len(msg.InvList) > 0 {
+		sp.server.SpvManager.QueueInv(msg, sp.syncPeer)
+	}
+}
+
+// OnLightMerkleBlock is invoked when a peer of a light node receives a
+// merkleblock message, which carries a header to sync or the proof of a
+// requested transaction.
+func (sp *serverPeer) OnLightMerkleBlock(p *peer.Peer, msg *message.MsgMerkleBlock) {
+	sp.server.SpvManager.QueueMerkleBlock(msg, sp.syncPeer)
+}
+
+// OnLightTx is invoked when a peer of a light node receives a tx message,
+// which is sent after the merkle block proving it.
+func (sp *serverPeer) OnLightTx(p *peer.Peer, msg *message.MsgTx) {
+	tx := types.NewTx(msg.Tx)
+	p.AddKnownInventory(message.NewInvVect(message.InvTypeTx, tx.Hash()))
+	sp.server.SpvManager.QueueTx(tx, sp.syncPeer)
+}
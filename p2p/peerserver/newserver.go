@@ -3,9 +3,10 @@ package peerserver
 import (
 	"errors"
 	"fmt"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer-lib/core/protocol"
 	"github.com/Qitmeer/qitmeer/common/network"
 	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/p2p/addmgr"
 	"github.com/Qitmeer/qitmeer/p2p/connmgr"
@@ -18,6 +19,20 @@ import (
 func NewPeerServer(cfg *config.Config, chainParams *params.Params) (*PeerServer, error) {
 
 	services := defaultServices
+	if cfg.LightNode {
+		// A light node has no blocks to serve.
+		services = protocol.Light
+	}
+	if cfg.PruneBlockData {
+		// A node which prunes old block data can't serve the full DAG.
+		services &^= protocol.Full
+	}
+	if cfg.NoPeerBloomFilters {
+		services &^= protocol.Bloom
+	}
+	if cfg.NoCFilters {
+		services &^= protocol.CF
+	}
 
 	s := PeerServer{
 		services:    services,
@@ -9,13 +9,14 @@ import (
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
 	"github.com/Qitmeer/qitmeer-lib/core/protocol"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer-lib/params/dcr/types"
 	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/p2p/addmgr"
 	"github.com/Qitmeer/qitmeer/p2p/peer"
 	"github.com/satori/go.uuid"
+	"sync/atomic"
 	"time"
 )
 
@@ -104,6 +105,10 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *message.MsgVersion) *message.
 
 	// Add valid peer to the server.
 	sp.server.AddPeer(sp)
+
+	// Request the new blocks to be announced with their headers rather
+	// than with inventory vectors.
+	p.QueueMessage(message.NewMsgSendHeaders(), nil)
 	return nil
 }
 
@@ -308,6 +313,253 @@ func (sp *serverPeer) OnGetHeaders(p *peer.Peer, msg *message.MsgGetHeaders) {
 	}
 }
 
+// OnGetCFilter is invoked when a peer receives a getcfilter message.
+func (sp *serverPeer) OnGetCFilter(p *peer.Peer, msg *message.MsgGetCFilter) {
+	// Ignore getcfilter requests if cf is not enabled or if the filter
+	// type is unknown.
+	cfIndex := sp.server.CfIndex
+	if cfIndex == nil || msg.FilterType != message.GCSFilterRegular {
+		return
+	}
+
+	filterBytes, err := cfIndex.FilterByBlockHash(&msg.BlockHash)
+	if err != nil {
+		log.Error("Error obtaining cfilter", "error", err)
+		return
+	}
+	if filterBytes == nil {
+		log.Debug(fmt.Sprintf("Could not obtain cfilter for %v",
+			msg.BlockHash))
+		return
+	}
+
+	filterMsg := message.NewMsgCFilter(msg.FilterType, &msg.BlockHash,
+		filterBytes)
+	p.QueueMessage(filterMsg, nil)
+}
+
+// OnGetCFHeaders is invoked when a peer receives a getcfheaders message.  The
+// headers of the filters are sent for the blocks on the chain of main parents
+// of the stop hash from the start height up to the stop hash.
+func (sp *serverPeer) OnGetCFHeaders(p *peer.Peer, msg *message.MsgGetCFHeaders) {
+	// Ignore getcfheaders requests if cf is not enabled, if the filter
+	// type is unknown or if not in sync.
+	cfIndex := sp.server.CfIndex
+	if cfIndex == nil || msg.FilterType != message.GCSFilterRegular ||
+		!sp.server.BlockManager.IsCurrent() {
+		return
+	}
+
+	bd := sp.server.BlockManager.GetChain().BlockDAG()
+	stopBlock := bd.GetBlock(&msg.StopHash)
+	if stopBlock == nil {
+		log.Debug(fmt.Sprintf("Unknown stop hash %v in getcfheaders "+
+			"from %s", msg.StopHash, p))
+		return
+	}
+	stopHeight := uint32(stopBlock.GetHeight())
+	if msg.StartHeight > stopHeight ||
+		stopHeight-msg.StartHeight >= message.MaxCFHeadersPerMsg {
+		log.Debug(fmt.Sprintf("Invalid getcfheaders range %d-%d from %s",
+			msg.StartHeight, stopHeight, p))
+		return
+	}
+
+	// Walk back the chain of main parents from the stop block to the
+	// block at the start height.
+	hashes := make([]*hash.Hash, stopHeight-msg.StartHeight+1)
+	ib := stopBlock
+	for i := len(hashes) - 1; i >= 0; i-- {
+		hashes[i] = ib.GetHash()
+		if i > 0 || msg.StartHeight > 0 {
+			ib = bd.GetBlock(ib.GetMainParent())
+			if ib == nil {
+				log.Debug(fmt.Sprintf("Missing main parent of %v",
+					hashes[i]))
+				return
+			}
+		}
+	}
+	filterHashes, err := cfIndex.FilterHashesByBlockHashes(hashes)
+	if err != nil {
+		log.Error("Error obtaining cfilter hashes", "error", err)
+		return
+	}
+
+	headersMsg := message.NewMsgCFHeaders()
+	headersMsg.FilterType = msg.FilterType
+	headersMsg.StopHash = msg.StopHash
+	if msg.StartHeight > 0 {
+		prevHeader, err := cfIndex.FilterHeaderByBlockHash(ib.GetHash())
+		if err != nil || len(prevHeader) != hash.HashSize {
+			log.Debug(fmt.Sprintf("Could not obtain cfheader of %v",
+				ib.GetHash()))
+			return
+		}
+		copy(headersMsg.PrevFilterHeader[:], prevHeader)
+	}
+	for i, filterHash := range filterHashes {
+		if len(filterHash) != hash.HashSize {
+			log.Debug(fmt.Sprintf("Could not obtain cfilter hash for %v",
+				hashes[i]))
+			return
+		}
+		var fh hash.Hash
+		copy(fh[:], filterHash)
+		err = headersMsg.AddCFHash(&fh)
+		if err != nil {
+			log.Error("Failed to add cfilter hash", "error", err)
+			return
+		}
+	}
+	p.QueueMessage(headersMsg, nil)
+}
+
+// OnGetCFTypes is invoked when a peer receives a getcftypes message.
+func (sp *serverPeer) OnGetCFTypes(p *peer.Peer, msg *message.MsgGetCFTypes) {
+	// Ignore getcftypes requests if cf is not enabled.
+	if sp.server.CfIndex == nil {
+		return
+	}
+
+	cfTypesMsg := message.NewMsgCFTypes([]message.FilterType{
+		message.GCSFilterRegular})
+	p.QueueMessage(cfTypesMsg, nil)
+}
+
+// OnFilterAdd is invoked when a peer receives a filteradd message and is used
+// by remote peers to add data to an already loaded bloom filter.  The peer
+// will be disconnected if a filter is not loaded when this message is received
+// or the server is not configured to allow bloom filters.
+func (sp *serverPeer) OnFilterAdd(p *peer.Peer, msg *message.MsgFilterAdd) {
+	// Disconnect and ban the peer when the node does not support bloom
+	// filters.
+	if !sp.enforceNodeBloomFlag(msg.Command()) {
+		return
+	}
+
+	if !sp.filter.IsLoaded() {
+		log.Debug(fmt.Sprintf("%s sent a filteradd request with no filter "+
+			"loaded -- disconnecting", p))
+		p.Disconnect()
+		return
+	}
+
+	sp.filter.Add(msg.Data)
+}
+
+// OnFilterClear is invoked when a peer receives a filterclear message and is
+// used by remote peers to clear an already loaded bloom filter.  The peer
+// will be disconnected if a filter is not loaded when this message is received
+// or the server is not configured to allow bloom filters.
+func (sp *serverPeer) OnFilterClear(p *peer.Peer, msg *message.MsgFilterClear) {
+	// Disconnect and ban the peer when the node does not support bloom
+	// filters.
+	if !sp.enforceNodeBloomFlag(msg.Command()) {
+		return
+	}
+
+	if !sp.filter.IsLoaded() {
+		log.Debug(fmt.Sprintf("%s sent a filterclear request with no "+
+			"filter loaded -- disconnecting", p))
+		p.Disconnect()
+		return
+	}
+
+	sp.filter.Unload()
+}
+
+// OnFilterLoad is invoked when a peer receives a filterload message and it
+// is used to load a bloom filter that should be used for delivering merkle
+// blocks and associated transactions that match the filter.  The peer will be
+// disconnected if the server is not configured to allow bloom filters.
+func (sp *serverPeer) OnFilterLoad(p *peer.Peer, msg *message.MsgFilterLoad) {
+	// Disconnect and ban the peer when the node does not support bloom
+	// filters.
+	if !sp.enforceNodeBloomFlag(msg.Command()) {
+		return
+	}
+
+	// Disconnect and ban the peer when the filter is empty, since the
+	// filter can't match anything.
+	if len(msg.Filter) == 0 {
+		log.Debug(fmt.Sprintf("%s sent an empty filterload filter -- "+
+			"disconnecting", p))
+		sp.addBanScore(100, 0, msg.Command())
+		sp.Disconnect()
+		return
+	}
+
+	sp.setDisableRelayTx(false)
+
+	sp.filter.Reload(msg)
+}
+
+// OnMemPool is invoked when a peer receives a mempool message.  It creates
+// and sends an inventory message with the contents of the memory pool up to
+// the maximum inventory allowed per message.  When the peer has a bloom filter
+// loaded, the contents are filtered accordingly, and the transactions below
+// the fee filter of the peer are left out.
+func (sp *serverPeer) OnMemPool(p *peer.Peer, msg *message.MsgMemPool) {
+	// Only allow mempool requests if the server has bloom filtering
+	// enabled.
+	if !sp.enforceNodeBloomFlag(msg.Command()) {
+		return
+	}
+
+	// A decaying ban score increase is applied to prevent flooding.
+	// The ban score accumulates and passes the ban threshold if a burst of
+	// mempool messages comes from a peer. The score decays each minute to
+	// half of its value.
+	sp.addBanScore(0, 33, "mempool")
+
+	// Generate inventory message with the available transactions in the
+	// transaction memory pool.  Limit it to the max allowed inventory
+	// per message.  The NewMsgInvSizeHint function automatically limits
+	// the passed hint to the maximum allowed, so it's safe to pass it
+	// without double checking it here.
+	txDescs := sp.server.TxMemPool.TxDescs()
+	feeFilter := atomic.LoadInt64(&sp.feeFilter)
+	invMsg := message.NewMsgInvSizeHint(uint(len(txDescs)))
+	invMsg.GS = sp.server.BlockManager.GetChain().BestSnapshot().GraphState
+	for _, txDesc := range txDescs {
+		if feeFilter > 0 && txDesc.FeePerKB < feeFilter {
+			continue
+		}
+		// Either add all transactions when there is no bloom filter,
+		// or only the transactions that match the filter when there is
+		// one.
+		if !sp.filter.IsLoaded() || sp.filter.MatchTxAndUpdate(txDesc.Tx) {
+			iv := message.NewInvVect(message.InvTypeTx, txDesc.Tx.Hash())
+			invMsg.AddInvVect(iv)
+			if len(invMsg.InvList)+1 > message.MaxInvPerMsg {
+				break
+			}
+		}
+	}
+
+	// Send the inventory message if there is anything to send.
+	if len(invMsg.InvList) > 0 {
+		p.QueueMessage(invMsg, nil)
+	}
+}
+
+// OnFeeFilter is invoked when a peer receives a feefilter message and is used
+// by remote peers to request that no transactions which have a fee rate lower
+// than provided value are inventoried to them.  The peer will be disconnected
+// if an invalid fee filter value is provided.
+func (sp *serverPeer) OnFeeFilter(p *peer.Peer, msg *message.MsgFeeFilter) {
+	// Check that the passed minimum fee is a valid amount.
+	if msg.MinFee < 0 || msg.MinFee > types.MaxAmount {
+		log.Debug(fmt.Sprintf("Peer %v sent an invalid feefilter '%v' -- "+
+			"disconnecting", sp, types.Amount(msg.MinFee)))
+		sp.Disconnect()
+		return
+	}
+
+	atomic.StoreInt64(&sp.feeFilter, msg.MinFee)
+}
+
 // OnInv is invoked when a peer receives an inv  message and is used to
 // examine the inventory being advertised by the remote peer and react
 // accordingly.  We pass the message down to blockmanager which will call
@@ -341,6 +593,16 @@ func (sp *serverPeer) OnInv(p *peer.Peer, msg *message.MsgInv) {
 	}
 }
 
+// OnHeaders is invoked when a peer receives a headers message, which the peer
+// sends to announce new blocks once it was asked to with a sendheaders
+// message.  The message is passed down to the block manager which requests
+// the blocks it doesn't have yet.
+func (sp *serverPeer) OnHeaders(p *peer.Peer, msg *message.MsgHeaders) {
+	if len(msg.Headers) > 0 {
+		sp.server.BlockManager.QueueHeaders(msg, sp.syncPeer)
+	}
+}
+
 // handleGetData is invoked when a peer receives a getdata wire message and is
 // used to deliver block and transaction information.
 func (sp *serverPeer) OnGetData(p *peer.Peer, msg *message.MsgGetData) {
@@ -383,6 +645,8 @@ func (sp *serverPeer) OnGetData(p *peer.Peer, msg *message.MsgGetData) {
 			err = sp.server.pushTxMsg(sp, &iv.Hash, c, waitChan)
 		case message.InvTypeBlock:
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan)
+		case message.InvTypeFilteredBlock:
+			err = sp.server.pushMerkleBlockMsg(sp, &iv.Hash, c, waitChan)
 		default:
 			log.Warn("Unknown type in inventory request", "type", iv.Type)
 			continue
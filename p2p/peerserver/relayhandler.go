@@ -1,10 +1,11 @@
 package peerserver
 
 import (
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/log"
+	"sync/atomic"
 )
 
 // handleRelayInvMsg deals with relaying inventory to peers that are not already
@@ -12,6 +13,18 @@ import (
 func (s *PeerServer) handleRelayInvMsg(state *peerState, msg relayMsg) {
 	log.Trace("handleRelayInvMsg", "msg", msg)
 	var gs *blockdag.GraphState
+
+	// The fee rate of a transaction is looked up once for the fee filters
+	// of all the peers.  It is unknown when the transaction already left
+	// the memory pool.
+	txFeeRate := int64(-1)
+	if msg.invVect.Type == message.InvTypeTx && s.TxMemPool != nil {
+		txDesc, err := s.TxMemPool.FetchTxDesc(&msg.invVect.Hash)
+		if err == nil {
+			txFeeRate = txDesc.FeePerKB
+		}
+	}
+
 	state.forAllPeers(func(sp *serverPeer) {
 		if !sp.Connected() {
 			return
@@ -41,6 +54,28 @@ func (s *PeerServer) handleRelayInvMsg(state *peerState, msg relayMsg) {
 			if sp.relayTxDisabled() {
 				return
 			}
+
+			// Don't relay the transaction if its fee rate is below
+			// the fee filter of the peer.
+			feeFilter := atomic.LoadInt64(&sp.feeFilter)
+			if feeFilter > 0 && txFeeRate >= 0 && txFeeRate < feeFilter {
+				return
+			}
+
+			// Don't relay the transaction if there is a bloom
+			// filter loaded and the transaction doesn't match it.
+			if sp.filter.IsLoaded() {
+				tx, ok := msg.data.(*types.Tx)
+				if !ok {
+					log.Warn("Underlying data for tx inv " +
+						"relay is not a transaction")
+					return
+				}
+
+				if !sp.filter.MatchTxAndUpdate(tx) {
+					return
+				}
+			}
 		}
 
 		// Either queue the inventory to be relayed immediately or with
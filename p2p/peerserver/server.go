@@ -9,18 +9,20 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/core/protocol"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/config"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/p2p/addmgr"
 	"github.com/Qitmeer/qitmeer/p2p/connmgr"
 	"github.com/Qitmeer/qitmeer/p2p/peer"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/services/blkmgr"
+	"github.com/Qitmeer/qitmeer/services/cf"
 	"github.com/Qitmeer/qitmeer/services/mempool"
+	"github.com/Qitmeer/qitmeer/services/spv"
 	"github.com/Qitmeer/qitmeer/version"
 	"github.com/satori/go.uuid"
 	"net"
@@ -32,7 +34,7 @@ import (
 
 const (
 	// the default services supported by the node
-	defaultServices = protocol.Full | protocol.CF
+	defaultServices = protocol.Full | protocol.Bloom | protocol.CF
 
 	// the default services that are required to be supported
 	defaultRequiredServices = protocol.Full
@@ -99,6 +101,8 @@ type PeerServer struct {
 	TimeSource   blockchain.MedianTimeSource
 	BlockManager *blkmgr.BlockManager
 	TxMemPool    *mempool.TxPool
+	CfIndex      *cf.CfIndex
+	SpvManager   *spv.SyncManager
 
 	services protocol.ServiceFlag
 }
@@ -118,7 +122,7 @@ func (s *PeerServer) OutboundGroupCount(key string) int {
 func (s *PeerServer) inboundPeerConnected(conn net.Conn) {
 	sp := newServerPeer(s, false)
 	sp.isWhitelisted = isWhitelisted(s.cfg, conn.RemoteAddr())
-	sp.Peer = peer.NewInboundPeer(newPeerConfig(sp))
+	sp.Peer = peer.NewInboundPeer(s.newPeerConfig(sp))
 	sp.syncPeer.Peer = sp.Peer
 	sp.AssociateConnection(conn)
 	go s.peerDoneHandler(sp)
@@ -131,7 +135,7 @@ func (s *PeerServer) inboundPeerConnected(conn net.Conn) {
 // manager of the attempt.
 func (s *PeerServer) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 	sp := newServerPeer(s, c.Permanent)
-	p, err := peer.NewOutboundPeer(newPeerConfig(sp), c.Addr.String())
+	p, err := peer.NewOutboundPeer(s.newPeerConfig(sp), c.Addr.String())
 	if err != nil {
 		log.Debug(fmt.Sprintf("Cannot create outbound peer %s: %v", c.Addr, err))
 		s.connManager.Disconnect(c.ID())
@@ -145,8 +149,18 @@ func (s *PeerServer) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 	s.addrManager.Attempt(sp.NA())
 }
 
-// newPeerConfig returns the configuration for the given serverPeer.
-func newPeerConfig(sp *serverPeer) *peer.Config {
+// newPeerConfig returns the configuration for the given serverPeer according
+// to whether the server runs a full or a light node.
+func (s *PeerServer) newPeerConfig(sp *serverPeer) *peer.Config {
+	if s.cfg.LightNode {
+		return newLightPeerConfig(sp)
+	}
+	return newFullPeerConfig(sp)
+}
+
+// newFullPeerConfig returns the configuration for the given serverPeer of a
+// full node.
+func newFullPeerConfig(sp *serverPeer) *peer.Config {
 
 	return &peer.Config{
 		Listeners: peer.MessageListeners{
@@ -164,11 +178,15 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnMiningState:    sp.OnMiningState,
 			OnTx:             sp.OnTx,
 			OnGraphState:     sp.OnGraphState,
-			//OnMemPool:        sp.OnMemPool,
-			//OnHeaders:        sp.OnHeaders,
-			//OnGetCFilter:     sp.OnGetCFilter,
-			//OnGetCFHeaders:   sp.OnGetCFHeaders,
-			//OnGetCFTypes:     sp.OnGetCFTypes,
+			OnGetCFilter:     sp.OnGetCFilter,
+			OnGetCFHeaders:   sp.OnGetCFHeaders,
+			OnGetCFTypes:     sp.OnGetCFTypes,
+			OnFilterAdd:      sp.OnFilterAdd,
+			OnFilterClear:    sp.OnFilterClear,
+			OnFilterLoad:     sp.OnFilterLoad,
+			OnMemPool:        sp.OnMemPool,
+			OnFeeFilter:      sp.OnFeeFilter,
+			OnHeaders:        sp.OnHeaders,
 		},
 		NewestGS:         sp.newestGS,
 		HostToNetAddress: sp.server.addrManager.HostToNetAddress,
@@ -313,8 +331,12 @@ func (s *PeerServer) peerDoneHandler(sp *serverPeer) {
 
 	// Only tell block manager we are gone if we ever told it we existed.
 	if sp.VersionKnown() {
-		log.Trace("peerDoneHandler send blkmgr donePeerMsg ")
-		s.BlockManager.DonePeer(sp.syncPeer)
+		if s.SpvManager != nil {
+			s.SpvManager.DonePeer(sp.syncPeer)
+		} else {
+			log.Trace("peerDoneHandler send blkmgr donePeerMsg ")
+			s.BlockManager.DonePeer(sp.syncPeer)
+		}
 	}
 	close(sp.quit)
 	log.Trace("stop peerDoneHandler")
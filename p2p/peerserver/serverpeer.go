@@ -6,13 +6,16 @@
 package peerserver
 
 import (
+	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer-lib/core/protocol"
 	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/p2p/addmgr"
 	"github.com/Qitmeer/qitmeer/p2p/connmgr"
 	"github.com/Qitmeer/qitmeer/p2p/peer"
+	"github.com/Qitmeer/qitmeer/services/bloom"
 	"sync"
 )
 
@@ -27,6 +30,7 @@ type serverPeer struct {
 	relayMtx       sync.Mutex
 	disableRelayTx bool
 	isWhitelisted  bool
+	filter         *bloom.Filter
 	requestQueue   []*message.InvVect
 	requestedTxns  map[hash.Hash]struct{}
 	knownAddresses map[string]struct{}
@@ -37,6 +41,11 @@ type serverPeer struct {
 	// request.  It is used to prevent more than one response per connection.
 	addrsSent bool
 
+	// feeFilter is the minimum fee rate in atoms/kB of the transactions
+	// announced to the peer, as requested by its feefilter message.  It
+	// must only be used atomically.
+	feeFilter int64
+
 	// The following chans are used to sync blockmanager and server.
 	syncPeer *peer.ServerPeer
 }
@@ -47,6 +56,7 @@ func newServerPeer(s *PeerServer, isPersistent bool) *serverPeer {
 	return &serverPeer{
 		server:         s,
 		persistent:     isPersistent,
+		filter:         bloom.LoadFilter(nil),
 		knownAddresses: make(map[string]struct{}),
 		quit:           make(chan struct{}),
 		syncPeer: &peer.ServerPeer{
@@ -115,6 +125,22 @@ func (sp *serverPeer) IsTxRelayDisabled() bool {
 	return sp.disableRelayTx
 }
 
+// enforceNodeBloomFlag disconnects the peer if the server is not configured to
+// allow bloom filters.  The peer is also banned since it sent a bloom filter
+// request although the server does not advertise the bloom filter service.
+func (sp *serverPeer) enforceNodeBloomFlag(cmd string) bool {
+	if !protocol.HasServices(sp.server.services, protocol.Bloom) {
+		// Disconnect the peer regardless of the banning state.
+		log.Debug(fmt.Sprintf("%s sent an unsupported %s request -- "+
+			"disconnecting", sp, cmd))
+		sp.addBanScore(100, 0, cmd)
+		sp.Disconnect()
+		return false
+	}
+
+	return true
+}
+
 // BanScore returns the current integer value that represents how close the peer
 // is to being banned.
 func (sp *serverPeer) BanScore() uint32 {
@@ -10,7 +10,7 @@ import (
 	"errors"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
 	"github.com/Qitmeer/qitmeer-lib/core/protocol"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"math/big"
 	"time"
 )
@@ -59,9 +59,23 @@ type DNSSeed struct {
 	HasFiltering bool
 }
 
+// Constants that define the ids of the consensus rule change deployments.
+const (
+	// DeploymentIdStateRoot is the id of the deployment which requires
+	// every block to commit to the utxo set of its main parent in the
+	// StateRoot of its header.
+	DeploymentIdStateRoot = "stateroot"
+)
+
 // ConsensusDeployment defines details related to a specific consensus rule
 // change that is voted in.  This is part of BIP0009.
 type ConsensusDeployment struct {
+	// Id is the unique identifier of the deployment.
+	Id string
+
+	// Description is a human-readable description of the rule change.
+	Description string
+
 	// BitNumber defines the specific bit number within the block version
 	// this particular soft-fork deployment refers to.
 	BitNumber uint8
@@ -75,6 +89,20 @@ type ConsensusDeployment struct {
 	ExpireTime uint64
 }
 
+// PowType identifies the proof of work algorithm of a network.
+type PowType byte
+
+const (
+	// Blake2bPow is the proof of work which requires the double blake2b
+	// hash of the block header to be below the target difficulty.
+	Blake2bPow PowType = iota
+
+	// CuckarooPow is the cuckaroo cycle proof of work which requires the
+	// header to carry a cycle in the graph keyed by the header whose
+	// double blake2b hash is below the target difficulty.
+	CuckarooPow
+)
+
 // Params defines a qitmeer network by its parameters.  These parameters may be
 // used by qitmeer applications to differentiate networks as well as addresses
 // and keys for one network from those intended for use on another network.
@@ -106,6 +134,10 @@ type Params struct {
 	// block in compact form.
 	PowLimitBits uint32
 
+	// PowType defines the proof of work algorithm blocks are mined and
+	// validated with.
+	PowType PowType
+
 	// WorkDiffAlpha is the stake difficulty EMA calculation alpha (smoothing)
 	// value. It is different from a normal EMA alpha. Closer to 1 --> smoother.
 	WorkDiffAlpha int64
@@ -214,7 +246,7 @@ type Params struct {
 	// state retarget window.
 	//
 	// Deployments define the specific consensus rule changes to be voted
-	// on.
+	// on, keyed by the block version the votes are cast with.
 	RuleChangeActivationThreshold uint32
 	MinerConfirmationWindow       uint32
 	Deployments                   map[uint32][]ConsensusDeployment
@@ -9,6 +9,7 @@ package params
 import (
 	"github.com/Qitmeer/qitmeer-lib/core/protocol"
 	"github.com/Qitmeer/qitmeer/common"
+	"math"
 	"math/big"
 	"time"
 )
@@ -35,6 +36,7 @@ var PrivNetParams = Params{
 	GenesisHash:              &privNetGenesisHash,
 	PowLimit:                 privNetPowLimit,
 	PowLimitBits:             0x207fffff,
+	PowType:                  Blake2bPow,
 	ReduceMinDifficulty:      false,
 	MinDiffReductionTime:     0, // Does not apply since ReduceMinDifficulty false
 	GenerateSupported:        true,
@@ -60,7 +62,18 @@ var PrivNetParams = Params{
 	Checkpoints: nil,
 
 	// Consensus rule change deployments.
-	Deployments: map[uint32][]ConsensusDeployment{},
+	//
+	RuleChangeActivationThreshold: 108, // 75% of MinerConfirmationWindow
+	MinerConfirmationWindow:       144,
+	Deployments: map[uint32][]ConsensusDeployment{
+		8: {{
+			Id:          DeploymentIdStateRoot,
+			Description: "Require blocks to commit to the utxo set",
+			BitNumber:   16,
+			StartTime:   0,
+			ExpireTime:  math.MaxInt64,
+		}},
+	},
 
 	// Address encoding magics
 	NetworkAddressPrefix: "R",
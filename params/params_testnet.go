@@ -33,6 +33,7 @@ var TestNetParams = Params{
 	GenesisHash:              &testNetGenesisHash,
 	PowLimit:                 testNetPowLimit,
 	PowLimitBits:             0x1e00ffff,
+	PowType:                  Blake2bPow,
 	ReduceMinDifficulty:      false,
 	MinDiffReductionTime:     0, // Does not apply since ReduceMinDifficulty false
 	GenerateSupported:        true,
@@ -59,7 +60,17 @@ var TestNetParams = Params{
 
 	// Consensus rule change deployments.
 	//
-	Deployments: map[uint32][]ConsensusDeployment{},
+	RuleChangeActivationThreshold: 1512, // 75% of MinerConfirmationWindow
+	MinerConfirmationWindow:       2016,
+	Deployments: map[uint32][]ConsensusDeployment{
+		8: {{
+			Id:          DeploymentIdStateRoot,
+			Description: "Require blocks to commit to the utxo set",
+			BitNumber:   16,
+			StartTime:   1577836800, // Jan 1st, 2020
+			ExpireTime:  1609459200, // Jan 1st, 2021
+		}},
+	},
 
 	// Address encoding magics
 	NetworkAddressPrefix: "T",
@@ -93,6 +93,38 @@ func qitmeerdMain(nodeChan chan<- *node.Node) error {
 
 		return nil
 	}
+	if cfg.DropSpentIndex {
+		if err := index.DropSpentIndex(db, interrupt); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return err
+		}
+
+		return nil
+	}
+	if cfg.DropAddrUtxoIndex {
+		if err := index.DropAddrUtxoIndex(db, interrupt); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return err
+		}
+
+		return nil
+	}
+	if cfg.DropNullDataIndex {
+		if err := index.DropNullDataIndex(db, interrupt); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return err
+		}
+
+		return nil
+	}
+	if cfg.DropTimeIndex {
+		if err := index.DropTimeIndex(db, interrupt); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return err
+		}
+
+		return nil
+	}
 	if cfg.DropTxIndex {
 		if err := index.DropTxIndex(db, interrupt); err != nil {
 			log.Error(fmt.Sprintf("%v", err))
@@ -5,7 +5,7 @@ package rpc
 import (
 	"errors"
 	"fmt"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"mime"
 	"net/http"
 )
@@ -41,6 +41,8 @@ const (
 const (
 	DefaultServiceNameSpace  = "qitmeer"
 	MinerNameSpace           = "miner"
+	IndexNameSpace           = "index"
+	WalletNameSpace          = "wallet"
 )
 
 
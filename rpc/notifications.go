@@ -0,0 +1,268 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/log"
+	"sync"
+)
+
+// ntfnType identifies a kind of notification the websocket clients can
+// subscribe to.
+type ntfnType int
+
+const (
+	// ntfnBlockConnected is sent when a block is connected.
+	ntfnBlockConnected ntfnType = iota
+
+	// ntfnReorganization is sent when a new block changes the DAG order
+	// of the blocks already ordered.
+	ntfnReorganization
+
+	// ntfnTxAccepted is sent when a transaction is accepted into the
+	// mempool.
+	ntfnTxAccepted
+)
+
+// maxPendingNtfns is the maximum number of notifications queued for one
+// subscription.  The client of a subscription whose queue overflows is too
+// slow to read its notifications and is disconnected.
+const maxPendingNtfns = 1000
+
+// ntfnSubscriber queues the notifications of one subscription.  The chain and
+// the mempool never wait for a slow client, which is dropped instead once its
+// queue overflows.
+type ntfnSubscriber struct {
+	mtx      sync.Mutex
+	pending  []interface{}
+	overflow bool
+	signal   chan struct{}
+
+	// drop disconnects the client of the subscription when its queue
+	// overflows.
+	drop func()
+}
+
+// queue adds the passed notification to the queue and signals the
+// subscription.  It returns true when the queue overflows, in which case the
+// queued notifications are discarded and the subscriber has to be dropped.
+// The notifications of a subscriber which overflowed are ignored.
+func (sub *ntfnSubscriber) queue(n interface{}) bool {
+	sub.mtx.Lock()
+	if sub.overflow {
+		sub.mtx.Unlock()
+		return false
+	}
+	if len(sub.pending) >= maxPendingNtfns {
+		sub.overflow = true
+		sub.pending = nil
+		sub.mtx.Unlock()
+		return true
+	}
+	sub.pending = append(sub.pending, n)
+	sub.mtx.Unlock()
+
+	select {
+	case sub.signal <- struct{}{}:
+	default:
+	}
+	return false
+}
+
+// dequeue removes and returns all the queued notifications.
+func (sub *ntfnSubscriber) dequeue() []interface{} {
+	sub.mtx.Lock()
+	defer sub.mtx.Unlock()
+	pending := sub.pending
+	sub.pending = nil
+	return pending
+}
+
+// wsNotificationManager fans out the notifications of the node to the
+// subscriptions of the websocket clients.
+type wsNotificationManager struct {
+	mtx         sync.RWMutex
+	subscribers map[ntfnType]map[*ntfnSubscriber]struct{}
+}
+
+func newWsNotificationManager() *wsNotificationManager {
+	return &wsNotificationManager{
+		subscribers: make(map[ntfnType]map[*ntfnSubscriber]struct{}),
+	}
+}
+
+// subscribe returns a new subscriber to the passed kind of notifications which
+// is dropped by the passed function when its queue overflows.
+func (m *wsNotificationManager) subscribe(t ntfnType, drop func()) *ntfnSubscriber {
+	sub := &ntfnSubscriber{signal: make(chan struct{}, 1), drop: drop}
+	m.mtx.Lock()
+	if m.subscribers[t] == nil {
+		m.subscribers[t] = make(map[*ntfnSubscriber]struct{})
+	}
+	m.subscribers[t][sub] = struct{}{}
+	m.mtx.Unlock()
+	return sub
+}
+
+// unsubscribe removes the passed subscriber.
+func (m *wsNotificationManager) unsubscribe(t ntfnType, sub *ntfnSubscriber) {
+	m.mtx.Lock()
+	delete(m.subscribers[t], sub)
+	m.mtx.Unlock()
+}
+
+// hasSubscribers returns whether any client subscribed to the passed kind of
+// notifications, so the notification isn't built for nobody.
+func (m *wsNotificationManager) hasSubscribers(t ntfnType) bool {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return len(m.subscribers[t]) > 0
+}
+
+// notify queues the passed notification to all the subscribers of its kind.
+// The subscribers whose queue overflows are removed and dropped.
+func (m *wsNotificationManager) notify(t ntfnType, n interface{}) {
+	var overflowed []*ntfnSubscriber
+	m.mtx.RLock()
+	for sub := range m.subscribers[t] {
+		if sub.queue(n) {
+			overflowed = append(overflowed, sub)
+		}
+	}
+	m.mtx.RUnlock()
+
+	for _, sub := range overflowed {
+		m.unsubscribe(t, sub)
+		sub.drop()
+	}
+}
+
+// NotifyBlockConnected notifies the websocket clients subscribed to new
+// blocks that the passed block has been connected.
+func (s *RpcServer) NotifyBlockConnected(block *types.SerializedBlock) {
+	if !s.ntfnMgr.hasSubscribers(ntfnBlockConnected) {
+		return
+	}
+	parents := make([]string, len(block.Block().Parents))
+	for i, h := range block.Block().Parents {
+		parents[i] = h.String()
+	}
+	txs := make([]string, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		txs[i] = tx.Hash().String()
+	}
+	s.ntfnMgr.notify(ntfnBlockConnected, &json.BlockConnectedNtfn{
+		Hash:    block.Hash().String(),
+		Order:   block.Order(),
+		Time:    block.Block().Header.Timestamp.Unix(),
+		Parents: parents,
+		Tx:      txs,
+	})
+}
+
+// NotifyReorganization notifies the websocket clients subscribed to DAG order
+// changes that a new block has reordered the DAG.
+func (s *RpcServer) NotifyReorganization(ntfn *json.ReorganizationNtfn) {
+	s.ntfnMgr.notify(ntfnReorganization, ntfn)
+}
+
+// NotifyMempoolTx notifies the websocket clients subscribed to new
+// transactions that the passed transaction has been accepted into the mempool.
+func (s *RpcServer) NotifyMempoolTx(tx *types.Tx) {
+	s.ntfnMgr.notify(ntfnTxAccepted, tx)
+}
+
+// NotificationAPI returns the API of the subscriptions to the notifications
+// of the node.  The subscriptions are only served on websocket connections.
+func (s *RpcServer) NotificationAPI() API {
+	return API{
+		NameSpace: DefaultServiceNameSpace,
+		Service:   &PublicNotificationAPI{s.ntfnMgr},
+		Public:    true,
+	}
+}
+
+// PublicNotificationAPI offers the subscriptions to the notifications of new
+// blocks, DAG order changes and new mempool transactions.
+type PublicNotificationAPI struct {
+	ntfnMgr *wsNotificationManager
+}
+
+// NewBlocks sends a notification each time a block is connected.
+func (api *PublicNotificationAPI) NewBlocks(ctx context.Context) (*Subscription, error) {
+	return api.subscribe(ctx, ntfnBlockConnected, func(n interface{}) interface{} {
+		return n
+	})
+}
+
+// Reorganizations sends a notification each time a new block changes the DAG
+// order of the blocks already ordered.
+func (api *PublicNotificationAPI) Reorganizations(ctx context.Context) (*Subscription, error) {
+	return api.subscribe(ctx, ntfnReorganization, func(n interface{}) interface{} {
+		return n
+	})
+}
+
+// NewTransactions sends a notification each time a transaction is accepted
+// into the mempool.  The hex-encoded transaction is included when fullTx is
+// set, otherwise only the hash is sent.
+func (api *PublicNotificationAPI) NewTransactions(ctx context.Context, fullTx *bool) (*Subscription, error) {
+	full := fullTx != nil && *fullTx
+	return api.subscribe(ctx, ntfnTxAccepted, func(n interface{}) interface{} {
+		tx := n.(*types.Tx)
+		ntfn := &json.TxAcceptedNtfn{TxID: tx.Hash().String()}
+		if full {
+			serialized, err := tx.Transaction().Serialize()
+			if err != nil {
+				log.Error("Failed to serialize transaction", "tx", tx.Hash(), "error", err)
+				return ntfn
+			}
+			ntfn.Hex = hex.EncodeToString(serialized)
+		}
+		return ntfn
+	})
+}
+
+// subscribe creates a subscription of the connection of ctx to the passed kind
+// of notifications, which are converted by format before they are sent.
+func (api *PublicNotificationAPI) subscribe(ctx context.Context, t ntfnType,
+	format func(n interface{}) interface{}) (*Subscription, error) {
+	notifier, supported := NotifierFromContext(ctx)
+	if !supported {
+		return &Subscription{}, ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	// Closing the connection of a client which doesn't read also fails the
+	// notification being written to it.
+	sub := api.ntfnMgr.subscribe(t, func() {
+		log.Warn("Disconnecting websocket client too slow to read its "+
+			"notifications", "subscription", rpcSub.ID)
+		notifier.codec.Close()
+	})
+
+	go func() {
+		defer api.ntfnMgr.unsubscribe(t, sub)
+		for {
+			select {
+			case <-sub.signal:
+				for _, n := range sub.dequeue() {
+					if err := notifier.Notify(rpcSub.ID, format(n)); err != nil {
+						return
+					}
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
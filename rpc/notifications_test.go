@@ -0,0 +1,97 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestSlowSubscriber ensures a client which never reads its notifications
+// doesn't block the notifications of the node and is disconnected once its
+// queue overflows.
+func TestSlowSubscriber(t *testing.T) {
+	// Nothing is ever read from the client side of the pipe, so the first
+	// notification written to the server side blocks.
+	server, client := net.Pipe()
+	defer client.Close()
+	codec := NewJSONCodec(server)
+	notifier := newNotifier(codec)
+	ctx := context.WithValue(context.Background(), notifierKey{}, notifier)
+
+	ntfnMgr := newWsNotificationManager()
+	api := &PublicNotificationAPI{ntfnMgr}
+	sub, err := api.NewBlocks(ctx)
+	if err != nil {
+		t.Fatalf("NewBlocks: %v", err)
+	}
+	notifier.activate(sub.ID, DefaultServiceNameSpace)
+	if !ntfnMgr.hasSubscribers(ntfnBlockConnected) {
+		t.Fatalf("no subscriber to the connected blocks")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < maxPendingNtfns+2; i++ {
+			ntfnMgr.notify(ntfnBlockConnected, i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("notifications blocked by a slow client")
+	}
+
+	if ntfnMgr.hasSubscribers(ntfnBlockConnected) {
+		t.Fatalf("slow subscriber was not removed")
+	}
+	select {
+	case <-codec.Closed():
+	case <-time.After(time.Second * 5):
+		t.Fatalf("slow client was not disconnected")
+	}
+}
+
+// TestSubscriberQueue ensures the notifications are queued in order until the
+// queue overflows.
+func TestSubscriberQueue(t *testing.T) {
+	drops := 0
+	ntfnMgr := newWsNotificationManager()
+	sub := ntfnMgr.subscribe(ntfnTxAccepted, func() { drops++ })
+
+	for i := 0; i < 3; i++ {
+		ntfnMgr.notify(ntfnTxAccepted, i)
+	}
+	pending := sub.dequeue()
+	if len(pending) != 3 {
+		t.Fatalf("got %d queued notifications, want 3", len(pending))
+	}
+	for i, n := range pending {
+		if n.(int) != i {
+			t.Fatalf("notification #%d is %v", i, n)
+		}
+	}
+
+	for i := 0; i < maxPendingNtfns; i++ {
+		ntfnMgr.notify(ntfnTxAccepted, i)
+	}
+	if drops != 0 {
+		t.Fatalf("subscriber dropped with a full queue")
+	}
+	ntfnMgr.notify(ntfnTxAccepted, maxPendingNtfns)
+	ntfnMgr.notify(ntfnTxAccepted, maxPendingNtfns+1)
+	if drops != 1 {
+		t.Fatalf("subscriber dropped %d times, want 1", drops)
+	}
+	if len(sub.dequeue()) != 0 {
+		t.Fatalf("overflowed queue was not discarded")
+	}
+	if ntfnMgr.hasSubscribers(ntfnTxAccepted) {
+		t.Fatalf("overflowed subscriber was not removed")
+	}
+}
@@ -47,6 +47,8 @@ type RpcServer struct {
 
 	authsha                [sha256.Size]byte
 	numClients             int32
+	numWebsockets          int32
+	ntfnMgr                *wsNotificationManager
 	statusLines            map[int]string
 	requestProcessShutdown chan struct{}
 
@@ -108,6 +110,7 @@ func NewRPCServer(cfg *config.Config) (*RpcServer, error) {
 		codecs:                 mapset.NewSet(),
 
 		statusLines:            make(map[int]string),
+		ntfnMgr:                newWsNotificationManager(),
 		requestProcessShutdown: make(chan struct{}),
 		quit: make(chan int),
 	}
@@ -182,6 +185,8 @@ func (s *RpcServer) startHTTP(listenAddrs []string) error{
 		// Read and respond to the request.
 		s.jsonRPCRead(w, r)
 	})
+	// Websocket endpoint.
+	rpcServeMux.HandleFunc("/ws", s.websocketHandler)
 	listeners, err := parseListeners(s.config,listenAddrs);
 	if err!=nil {
 		return err
@@ -0,0 +1,76 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"github.com/Qitmeer/qitmeer/log"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxWebsocketPayloadBytes is the maximum size of a message a websocket
+	// client is allowed to send.
+	maxWebsocketPayloadBytes = maxRequestContentLength
+)
+
+// websocketHandler upgrades the passed request to a websocket connection
+// which serves requests and subscriptions until it is closed.  The websocket
+// clients are authenticated the same way as the HTTP clients.
+func (s *RpcServer) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.run) != 1 { // server stopped
+		http.Error(w, "503 Server is stopping.", http.StatusServiceUnavailable)
+		return
+	}
+	_, err := s.checkAuth(r, true)
+	if err != nil {
+		jsonAuthFail(w)
+		return
+	}
+
+	// Limit the number of websocket connections to max allowed.
+	if int(atomic.AddInt32(&s.numWebsockets, 1)) > s.config.RPCMaxWebsockets {
+		atomic.AddInt32(&s.numWebsockets, -1)
+		log.Info("RPC websocket clients exceeded", "max", s.config.RPCMaxWebsockets,
+			"client", r.RemoteAddr)
+		http.Error(w, "503 Too busy.  Try again later.",
+			http.StatusServiceUnavailable)
+		return
+	}
+	defer atomic.AddInt32(&s.numWebsockets, -1)
+
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, "remote", r.RemoteAddr)
+	ctx = context.WithValue(ctx, "scheme", "ws")
+	ctx = context.WithValue(ctx, "local", r.Host)
+
+	wsServer := websocket.Server{
+		// The websocket clients are authenticated already and the
+		// origin is not checked, as with the HTTP clients.
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			// Clear the read deadline of the HTTP server, which only
+			// applies to the handshake of a long lived connection.
+			conn.SetReadDeadline(time.Time{})
+			conn.MaxPayloadBytes = maxWebsocketPayloadBytes
+
+			log.Debug("RPC websocket client connected", "client", r.RemoteAddr)
+			codec := NewCodec(conn, func(v interface{}) error {
+				return websocket.JSON.Send(conn, v)
+			}, func(v interface{}) error {
+				return websocket.JSON.Receive(conn, v)
+			})
+			defer codec.Close()
+			s.serveRequest(ctx, codec, false, OptionMethodInvocation|OptionSubscriptions)
+			log.Debug("RPC websocket client disconnected", "client", r.RemoteAddr)
+		},
+	}
+	wsServer.ServeHTTP(w, r)
+}
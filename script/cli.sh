@@ -112,6 +112,67 @@ function get_mempool(){
   get_result "$data"
 }
 
+function save_mempool(){
+  local data='{"jsonrpc":"2.0","method":"saveMempool","params":[],"id":1}'
+  get_result "$data"
+}
+
+function estimate_fee(){
+  local num_blocks=$1
+  local confidence=$2
+  if [ "$confidence" == "" ]; then
+    confidence="0.85"
+  fi
+  local data='{"jsonrpc":"2.0","method":"estimateFee","params":['$num_blocks','$confidence'],"id":1}'
+  get_result "$data"
+}
+
+function get_mempool_entry(){
+  local tx_hash=$1
+  local data='{"jsonrpc":"2.0","method":"getMempoolEntry","params":["'$tx_hash'"],"id":1}'
+  get_result "$data"
+}
+
+function get_mempool_info(){
+  local data='{"jsonrpc":"2.0","method":"getMempoolInfo","params":[],"id":1}'
+  get_result "$data"
+}
+
+function get_mempool_fee_histogram(){
+  local data='{"jsonrpc":"2.0","method":"getMempoolFeeHistogram","params":[],"id":1}'
+  get_result "$data"
+}
+
+function test_mempool_accept(){
+  local raw_tx=$1
+  local allow_high_fee=$2
+  if [ "$allow_high_fee" == "" ]; then
+    allow_high_fee="false"
+  fi
+  local data='{"jsonrpc":"2.0","method":"testMempoolAccept","params":[["'$raw_tx'"],'$allow_high_fee'],"id":1}'
+  get_result "$data"
+}
+
+function get_mempool_ancestors(){
+  local tx_hash=$1
+  local verbose=$2
+  if [ "$verbose" == "" ]; then
+    verbose="false"
+  fi
+  local data='{"jsonrpc":"2.0","method":"getMempoolAncestors","params":["'$tx_hash'",'$verbose'],"id":1}'
+  get_result "$data"
+}
+
+function get_mempool_descendants(){
+  local tx_hash=$1
+  local verbose=$2
+  if [ "$verbose" == "" ]; then
+    verbose="false"
+  fi
+  local data='{"jsonrpc":"2.0","method":"getMempoolDescendants","params":["'$tx_hash'",'$verbose'],"id":1}'
+  get_result "$data"
+}
+
 # return block by hash
 #   func (s *PublicBlockChainAPI) GetBlockByHash(ctx context.Context, blockHash common.Hash, fullTx bool) (map[string]interface{}, error)
 function get_block_by_hash(){
@@ -146,6 +207,18 @@ function get_tx_by_hash(){
   get_result "$data"
 }
 
+# return tx by hash from the block by hash, for light nodes
+function get_tx_proof(){
+  local tx_hash=$1
+  local block_hash=$2
+  local verbose=$3
+  if [ "$verbose" == "" ]; then
+    verbose="true"
+  fi
+  local data='{"jsonrpc":"2.0","method":"getRawTransaction","params":["'$tx_hash'",'$verbose',"'$block_hash'"],"id":1}'
+  get_result "$data"
+}
+
 # return info about UTXO
 function get_utxo() {
   local tx_hash=$1
@@ -158,6 +231,62 @@ function get_utxo() {
   get_result "$data"
 }
 
+function get_spending_tx() {
+  local tx_hash=$1
+  local vout=$2
+  local data='{"jsonrpc":"2.0","method":"getSpendingTx","params":["'$tx_hash'",'$vout'],"id":1}'
+  get_result "$data"
+}
+
+function search_null_data() {
+  local prefix=$1
+  local skip=$2
+  local count=$3
+  if [ "$skip" == "" ]; then
+    skip=0
+  fi
+  if [ "$count" == "" ]; then
+    count=100
+  fi
+  local data='{"jsonrpc":"2.0","method":"searchNullData","params":["'$prefix'",'$skip','$count'],"id":1}'
+  get_result "$data"
+}
+
+# return the balance of an address from the address utxo index
+function get_address_balance() {
+  local address=$1
+  local data='{"jsonrpc":"2.0","method":"getAddressBalance","params":["'$address'"],"id":1}'
+  get_result "$data"
+}
+
+function get_address_utxos() {
+  local address=$1
+  local include_mempool=$2
+  if [ "$include_mempool" == "" ]; then
+    include_mempool="true"
+  fi
+  local data='{"jsonrpc":"2.0","method":"getAddressUtxos","params":["'$address'",'$include_mempool'],"id":1}'
+  get_result "$data"
+}
+
+function get_address_deltas() {
+  local address=$1
+  local start=$2
+  local end=$3
+  local include_mempool=$4
+  if [ "$start" == "" ]; then
+    start="0"
+  fi
+  if [ "$end" == "" ]; then
+    end="4294967295"
+  fi
+  if [ "$include_mempool" == "" ]; then
+    include_mempool="false"
+  fi
+  local data='{"jsonrpc":"2.0","method":"getAddressDeltas","params":["'$address'",'$start','$end','$include_mempool'],"id":1}'
+  get_result "$data"
+}
+
 function tx_sign(){
    local private_key=$1
    local raw_tx=$2
@@ -213,10 +342,30 @@ function is_on_mainchain(){
 
 function get_block_template(){
   local capabilities=$1
-  local data='{"jsonrpc":"2.0","method":"getBlockTemplate","params":[["'$capabilities'"]],"id":1}'
+  local longpollid=$2
+  if [ "$longpollid" == "" ]; then
+    local data='{"jsonrpc":"2.0","method":"getBlockTemplate","params":[["'$capabilities'"]],"id":1}'
+  else
+    local data='{"jsonrpc":"2.0","method":"getBlockTemplate","params":[["'$capabilities'"],"'$longpollid'"],"id":1}'
+  fi
+  get_result "$data"
+}
+
+function get_stratum_workers(){
+  local data='{"jsonrpc":"2.0","method":"miner_getStratumWorkers","params":[],"id":1}'
   get_result "$data"
 }
 
+# subscribe to the stratum server and print the difficulty and the jobs it
+# sends for a few seconds
+function stratum_subscribe(){
+  local addr=${1:-127.0.0.1:3177}
+  local secs=${2:-10}
+  { echo '{"id":1,"method":"mining.subscribe","params":[]}'
+    echo '{"id":2,"method":"mining.authorize","params":["cli","x"]}'
+    sleep $secs; } | nc ${addr%:*} ${addr##*:}
+}
+
 function get_mainchain_height(){
   local data='{"jsonrpc":"2.0","method":"getMainChainHeight","params":[],"id":1}'
   get_result "$data"
@@ -253,6 +402,91 @@ function get_orphans_total(){
   get_result "$data"
 }
 
+function get_deployment_info(){
+  local data='{"jsonrpc":"2.0","method":"getDeploymentInfo","params":[],"id":null}'
+  get_result "$data"
+}
+
+function get_cfilter(){
+  local hash=$1
+  local data='{"jsonrpc":"2.0","method":"getCFilter","params":["'$hash'"],"id":null}'
+  get_result "$data"
+}
+
+function get_cfilter_header(){
+  local hash=$1
+  local data='{"jsonrpc":"2.0","method":"getCFilterHeader","params":["'$hash'"],"id":null}'
+  get_result "$data"
+}
+
+function get_block_order_by_time(){
+  local ts=$1
+  local mode=$2
+  if [ "$mode" == "" ]; then
+    mode="after"
+  fi
+  local data='{"jsonrpc":"2.0","method":"getBlockOrderByTime","params":['$ts',"'$mode'"],"id":null}'
+  get_result "$data"
+}
+
+function get_blocks_in_time_range(){
+  local start=$1
+  local end=$2
+  local limit=$3
+  if [ "$limit" == "" ]; then
+    limit=100
+  fi
+  local data='{"jsonrpc":"2.0","method":"getBlocksInTimeRange","params":['$start','$end','$limit'],"id":null}'
+  get_result "$data"
+}
+
+function get_index_info(){
+  local data='{"jsonrpc":"2.0","method":"getIndexInfo","params":[],"id":null}'
+  get_result "$data"
+}
+
+function start_index(){
+  local name=$1
+  local data='{"jsonrpc":"2.0","method":"startIndex","params":["'$name'"],"id":null}'
+  get_result "$data"
+}
+
+function drop_index(){
+  local name=$1
+  local data='{"jsonrpc":"2.0","method":"dropIndex","params":["'$name'"],"id":null}'
+  get_result "$data"
+}
+
+function get_wallet_balance(){
+  local minconf=$1
+  if [ "$minconf" == "" ]; then
+    minconf=1
+  fi
+  local data='{"jsonrpc":"2.0","method":"getBalance","params":['$minconf'],"id":null}'
+  get_result "$data"
+}
+
+function list_unspent(){
+  local minconf=$1
+  if [ "$minconf" == "" ]; then
+    minconf=1
+  fi
+  local data='{"jsonrpc":"2.0","method":"listUnspent","params":['$minconf'],"id":null}'
+  get_result "$data"
+}
+
+function get_new_address(){
+  local data='{"jsonrpc":"2.0","method":"getNewAddress","params":[],"id":null}'
+  get_result "$data"
+}
+
+function send_to_address(){
+  local address=$1
+  local amount=$2
+  local data='{"jsonrpc":"2.0","method":"sendToAddress","params":["'$address'",'$amount'],"id":null}'
+  get_result "$data"
+}
+
 function get_stop_node(){
   local data='{"jsonrpc":"2.0","method":"stop","params":[],"id":null}'
   get_result "$data"
@@ -349,17 +583,48 @@ function usage(){
   echo "  mainHeight"
   echo "  weight <hash>"
   echo "  orphanstotal"
+  echo "  deployments"
+  echo "  cfilter <hash>"
+  echo "  cfilterheader <hash>"
+  echo "  blockbytime <unix_time> <mode,default=after>"
+  echo "  blocksintime <start_unix_time> <end_unix_time> <limit,default=100>"
+  echo "  indexinfo"
+  echo "  startindex <name>"
+  echo "  dropindex <name>"
   echo "tx     :"
   echo "  tx <hash>"
+  echo "  txproof <hash> <block_hash>"
   echo "  createRawTx"
   echo "  txSign <rawTx>"
   echo "  sendRawTx <signedRawTx>"
   echo "  getrawtxs <address>"
+  echo "  searchnulldata <prefix_hex> <skip,default=0> <count,default=100>"
+  echo "  estimatefee <num_blocks> <confidence,default=0.85>"
+  echo "mempool:"
+  echo "  mempool <type,default=regular> <verbose,default=false>"
+  echo "  mempoolentry <tx_id>"
+  echo "  mempoolinfo"
+  echo "  mempoolfeehistogram"
+  echo "  testmempoolaccept <rawTx> <allow_high_fee,default=false>"
+  echo "  mempoolancestors <tx_id> <verbose,default=false>"
+  echo "  mempooldescendants <tx_id> <verbose,default=false>"
+  echo "  savemempool"
   echo "utxo   :"
   echo "  getutxo <tx_id> <index> <include_mempool,default=true>"
+  echo "  spendingtx <tx_id> <index>"
+  echo "  addressbalance <address>"
+  echo "  addressutxos <address> <include_mempool,default=true>"
+  echo "  addressdeltas <address> <start_order,default=0> <end_order> <include_mempool,default=false>"
+  echo "wallet :"
+  echo "  getbalance <minconf,default=1>"
+  echo "  listunspent <minconf,default=1>"
+  echo "  getnewaddress"
+  echo "  sendtoaddress <address> <amount_in_atoms>"
   echo "miner  :"
-  echo "  template"
+  echo "  template <capabilities> <longpollid>"
   echo "  generate <num>"
+  echo "  stratumworkers"
+  echo "  stratum <host:port,default=127.0.0.1:3177> <seconds,default=10>"
 }
 
 # -------------------
@@ -610,9 +875,17 @@ elif [ "$1" == "main" ]; then
     shift
     is_on_mainchain $1
 
+elif [ "$1" == "stratumworkers" ]; then
+    shift
+    get_stratum_workers|jq .
+
+elif [ "$1" == "stratum" ]; then
+    shift
+    stratum_subscribe $@
+
 elif [ "$1" == "template" ]; then
     shift
-    get_block_template $1 | jq .
+    get_block_template $@ | jq .
 
 elif [ "$1" == "mainHeight" ]; then
     shift
@@ -638,6 +911,38 @@ elif [ "$1" == "orphanstotal" ]; then
   shift
   get_orphans_total | jq .
 
+elif [ "$1" == "deployments" ]; then
+  shift
+  get_deployment_info | jq .
+
+elif [ "$1" == "cfilter" ]; then
+  shift
+  get_cfilter $@
+
+elif [ "$1" == "cfilterheader" ]; then
+  shift
+  get_cfilter_header $@
+
+elif [ "$1" == "blockbytime" ]; then
+  shift
+  get_block_order_by_time $@|jq .
+
+elif [ "$1" == "blocksintime" ]; then
+  shift
+  get_blocks_in_time_range $@|jq .
+
+elif [ "$1" == "indexinfo" ]; then
+  shift
+  get_index_info|jq .
+
+elif [ "$1" == "startindex" ]; then
+  shift
+  start_index "$@"|jq .
+
+elif [ "$1" == "dropindex" ]; then
+  shift
+  drop_index "$@"|jq .
+
 elif [ "$1" == "stop" ]; then
   shift
   get_stop_node
@@ -651,6 +956,14 @@ elif [ "$1" == "tx" ]; then
     get_tx_by_hash $@|jq .
   fi
 
+elif [ "$1" == "txproof" ]; then
+  shift
+  if [ "$3" == "false" ]; then
+    get_tx_proof $@
+  else
+    get_tx_proof $@|jq .
+  fi
+
 elif [ "$1" == "createRawTx" ]; then
   shift
   create_raw_tx $@
@@ -667,6 +980,10 @@ elif [ "$1" == "getrawtxs" ]; then
   shift
   get_rawtxs $@
 
+elif [ "$1" == "searchnulldata" ]; then
+  shift
+  search_null_data $@|jq .
+
 elif [ "$1" == "get_tx_by_block_and_index" ]; then
   shift
   # note: the input is block number & tx index in hex
@@ -677,6 +994,38 @@ elif [ "$1" == "mempool" ]; then
   shift
   get_mempool $@|jq .
 
+elif [ "$1" == "mempoolentry" ]; then
+  shift
+  get_mempool_entry $@|jq .
+
+elif [ "$1" == "mempoolinfo" ]; then
+  shift
+  get_mempool_info $@|jq .
+
+elif [ "$1" == "mempoolfeehistogram" ]; then
+  shift
+  get_mempool_fee_histogram $@|jq .
+
+elif [ "$1" == "testmempoolaccept" ]; then
+  shift
+  test_mempool_accept $@|jq .
+
+elif [ "$1" == "mempoolancestors" ]; then
+  shift
+  get_mempool_ancestors $@|jq .
+
+elif [ "$1" == "mempooldescendants" ]; then
+  shift
+  get_mempool_descendants $@|jq .
+
+elif [ "$1" == "estimatefee" ]; then
+  shift
+  estimate_fee $@|jq .
+
+elif [ "$1" == "savemempool" ]; then
+  shift
+  save_mempool $@|jq .
+
 
 elif [ "$1" == "txSign" ]; then
   shift
@@ -689,6 +1038,39 @@ elif [ "$1" == "getutxo" ]; then
   shift
   get_utxo $@|jq .
 
+elif [ "$1" == "spendingtx" ]; then
+  shift
+  get_spending_tx $@|jq .
+
+elif [ "$1" == "addressbalance" ]; then
+  shift
+  get_address_balance $@|jq .
+
+elif [ "$1" == "addressutxos" ]; then
+  shift
+  get_address_utxos $@|jq .
+
+elif [ "$1" == "addressdeltas" ]; then
+  shift
+  get_address_deltas $@|jq .
+
+## Wallet
+elif [ "$1" == "getbalance" ]; then
+  shift
+  get_wallet_balance $@
+
+elif [ "$1" == "listunspent" ]; then
+  shift
+  list_unspent $@|jq .
+
+elif [ "$1" == "getnewaddress" ]; then
+  shift
+  get_new_address
+
+elif [ "$1" == "sendtoaddress" ]; then
+  shift
+  send_to_address $@
+
 ## Accounts
 elif [ "$1" == "newaccount" ]; then
   shift
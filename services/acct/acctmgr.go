@@ -1,39 +1,702 @@
 package acct
 
 import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/bip32"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/log"
+	"github.com/Qitmeer/qitmeer/node/notify"
+	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/rpc"
+	"github.com/Qitmeer/qitmeer/services/blkmgr"
+	"github.com/Qitmeer/qitmeer/services/mempool"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 )
 
 // account manager communicate with various backends for signing transactions.
+//
+// When the wallet is enabled, the account manager keeps the seed encrypted in
+// the data directory, derives the BIP44 addresses of the first account and
+// tracks their unspent outputs as the blocks are connected and disconnected.
+// The addresses are derived from the public key of the account, and the
+// private keys are only derived while the wallet is unlocked to send.
 type AccountManager struct {
+	cfg    *config.Config
+	params *params.Params
+	db     database.DB
+	bm     *blkmgr.BlockManager
+	txPool *mempool.TxPool
+	notify notify.Notify
 
+	// mtx protects the keys, the addresses and the state of the wallet in
+	// the database.  The public branch keys are set once the wallet is
+	// opened, and the private ones while it is unlocked.  The unlock id
+	// tells the lock timers of the earlier unlocks apart.
+	mtx            sync.Mutex
+	branchKeys     [2]*bip32.Key
+	privBranchKeys [2]*bip32.Key
+	lockTimer      *time.Timer
+	unlockID       uint64
+	addrs          map[[20]byte]addrPath
+
+	// sendMtx serializes the transactions sent by the wallet, so two of them
+	// never select the same outputs before the mempool knows about them.
+	sendMtx sync.Mutex
 }
 
 func (a *AccountManager) Start() error {
 	log.Debug("Starting account manager")
-	return nil
+	if !a.cfg.Wallet {
+		return nil
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	// The wallet is opened with the public key of the account of its seed
+	// file, which is created by the createWallet RPC.
+	seedPath := filepath.Join(a.cfg.DataDir, seedFileName)
+	if _, err := os.Stat(seedPath); os.IsNotExist(err) {
+		log.Info("The wallet has no seed yet, create it with the " +
+			"createWallet RPC")
+		return nil
+	}
+	accountKey, err := loadAccountKey(seedPath)
+	if err != nil {
+		return fmt.Errorf("failed to open the wallet: %v", err)
+	}
+	return a.open(accountKey, false)
+}
+
+// open derives the public branch keys of the passed account key, loads the
+// addresses of the wallet and syncs it with the chain.  The state of the
+// wallet of a previous seed is removed when the seed has just been created.
+//
+// This function MUST be called with the wallet lock held.
+func (a *AccountManager) open(accountKey *bip32.Key, created bool) error {
+	branchKeys, err := deriveBranchKeys(accountKey)
+	if err != nil {
+		return err
+	}
+
+	err = a.db.Update(func(dbTx database.Tx) error {
+		// The state of the wallet of a seed which has been removed is of
+		// no use with the new one.
+		if created && dbTx.Metadata().Bucket(walletParentBucketKey) != nil {
+			log.Warn("Removing the state of the wallet of a missing seed")
+			err := dbTx.Metadata().DeleteBucket(walletParentBucketKey)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := dbCreateWalletBuckets(dbTx)
+		if err != nil {
+			return err
+		}
+		a.addrs, err = dbFetchAddresses(dbTx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	a.branchKeys = branchKeys
+
+	return a.syncChain(created)
 }
 
 func (a *AccountManager) Stop() error {
 	log.Debug("Stopping account manager")
+	a.mtx.Lock()
+	a.lock()
+	a.mtx.Unlock()
 	return nil
 }
 
-func (a AccountManager)	APIs() []rpc.API {
+func (a *AccountManager) APIs() []rpc.API {
 	return []rpc.API{
 		{
 			NameSpace: rpc.DefaultServiceNameSpace,
-			Service:   NewPublicAccountManagerAPI(&a),
+			Service:   NewPublicAccountManagerAPI(a),
 			Public:    true,
 		},
+		{
+			NameSpace: rpc.WalletNameSpace,
+			Service:   NewPrivateAccountManagerAPI(a),
+			Public:    false,
+		},
+	}
+}
+
+func New(cfg *config.Config, par *params.Params, db database.DB,
+	bm *blkmgr.BlockManager, txPool *mempool.TxPool, ntmgr notify.Notify) (*AccountManager, error) {
+	a := AccountManager{
+		cfg:    cfg,
+		params: par,
+		db:     db,
+		bm:     bm,
+		txPool: txPool,
+		notify: ntmgr,
+	}
+	return &a, nil
+}
+
+// enabled returns whether the wallet has been opened.
+//
+// This function MUST be called with the wallet lock held.
+func (a *AccountManager) enabled() bool {
+	return a.branchKeys[externalBranch] != nil
+}
+
+// unlocked returns whether the private keys of the wallet are available.
+//
+// This function MUST be called with the wallet lock held.
+func (a *AccountManager) unlocked() bool {
+	return a.privBranchKeys[externalBranch] != nil
+}
+
+// CreateWallet creates the seed of the wallet encrypted with the passed
+// passphrase and opens the wallet.  It returns the mnemonic of the seed, which
+// is not shown again and has to be backed up.
+func (a *AccountManager) CreateWallet(passphrase string) (string, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if !a.cfg.Wallet {
+		return "", errWalletDisabled
+	}
+	if a.enabled() {
+		return "", fmt.Errorf("the wallet already exists")
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("the wallet requires a passphrase")
+	}
+
+	seedPath := filepath.Join(a.cfg.DataDir, seedFileName)
+	mnemonic, accountKey, err := createSeedFile(seedPath, passphrase)
+	if err != nil {
+		return "", fmt.Errorf("failed to create the wallet: %v", err)
+	}
+	log.Info("Created a new wallet, keep a backup of its mnemonic",
+		"path", seedPath)
+	err = a.open(accountKey, true)
+	if err != nil {
+		return "", err
+	}
+	return mnemonic, nil
+}
+
+// Unlock decrypts the seed of the wallet with the passed passphrase and keeps
+// the private keys for the passed duration, so the wallet can send.
+func (a *AccountManager) Unlock(passphrase string, timeout time.Duration) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if !a.enabled() {
+		return errWalletDisabled
+	}
+
+	seed, err := loadSeedFile(filepath.Join(a.cfg.DataDir, seedFileName),
+		passphrase)
+	if err != nil {
+		return err
+	}
+	accountKey, err := deriveAccountKey(seed)
+	if err != nil {
+		return err
+	}
+	privBranchKeys, err := deriveBranchKeys(accountKey)
+	if err != nil {
+		return err
+	}
+
+	a.lock()
+	a.privBranchKeys = privBranchKeys
+	a.unlockID++
+	unlockID := a.unlockID
+	a.lockTimer = time.AfterFunc(timeout, func() {
+		a.mtx.Lock()
+		defer a.mtx.Unlock()
+		if a.unlockID == unlockID {
+			a.lock()
+		}
+	})
+	return nil
+}
+
+// Lock forgets the private keys of the wallet.
+func (a *AccountManager) Lock() error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if !a.enabled() {
+		return errWalletDisabled
 	}
+	a.lock()
+	return nil
 }
 
-func New() (*AccountManager, error){
-	a := AccountManager{}
-	return &a,nil
+// lock forgets the private keys of the wallet and stops the lock timer.
+//
+// This function MUST be called with the wallet lock held.
+func (a *AccountManager) lock() {
+	if a.lockTimer != nil {
+		a.lockTimer.Stop()
+		a.lockTimer = nil
+	}
+	for i, key := range a.privBranchKeys {
+		if key == nil {
+			continue
+		}
+		for j := range key.Key {
+			key.Key[j] = 0
+		}
+		a.privBranchKeys[i] = nil
+	}
 }
 
+// syncChain brings the outputs of the wallet up to date with the blocks
+// connected since the wallet was last running.  A new wallet starts at the
+// latest block, since there is nothing paying to its addresses yet.  When the
+// block the wallet was synced to is no longer at its order the blocks are
+// scanned again from the genesis.
+//
+// This function MUST be called with the wallet lock held.
+func (a *AccountManager) syncChain(fresh bool) error {
+	chain := a.bm.GetChain()
+	mainOrder := uint64(chain.BestSnapshot().GraphState.GetMainOrder())
+
+	var start uint64
+	err := a.db.Update(func(dbTx database.Tx) error {
+		h, order := dbFetchSynced(dbTx)
+		if h == nil && fresh {
+			latest, err := chain.BlockHashByOrder(mainOrder)
+			if err != nil {
+				return err
+			}
+			start = mainOrder + 1
+			return dbPutSynced(dbTx, latest, mainOrder)
+		}
+		if h != nil {
+			cur, err := chain.BlockHashByOrder(order)
+			if err == nil && cur.IsEqual(h) {
+				start = order + 1
+				return nil
+			}
+		}
+		log.Info("Rescanning the blocks for the wallet")
+		start = 0
+		return dbClearUtxos(dbTx)
+	})
+	if err != nil {
+		return err
+	}
+
+	for order := start; order <= mainOrder; order++ {
+		block, err := chain.BlockByOrder(order)
+		if err != nil {
+			return fmt.Errorf("failed to sync the wallet with the "+
+				"block of order %d: %v", order, err)
+		}
+		err = a.connectBlock(block)
+		if err != nil {
+			return err
+		}
+	}
+	if start <= mainOrder {
+		log.Info("Wallet synced", "order", mainOrder)
+	}
+	return nil
+}
 
+// isMine returns the path of the address of the wallet the passed script
+// pays to.
+//
+// This function MUST be called with the wallet lock held.
+func (a *AccountManager) isMine(pkScript []byte) (addrPath, bool) {
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, a.params)
+	if err != nil || class != txscript.PubKeyHashTy || len(addrs) != 1 {
+		return addrPath{}, false
+	}
+	path, ok := a.addrs[*addrs[0].Hash160()]
+	return path, ok
+}
 
+// ConnectBlock adds the outputs the passed block pays to the wallet and
+// removes the ones it spends.  It is invoked when a block is connected.
+func (a *AccountManager) ConnectBlock(block *types.SerializedBlock) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if !a.enabled() {
+		return
+	}
+	err := a.connectBlock(block)
+	if err != nil {
+		log.Error("Failed to connect block to the wallet", "block",
+			block.Hash(), "error", err)
+	}
+}
+
+// connectBlock updates the outputs of the wallet with the passed block and
+// records what was changed, so the block can be disconnected.  The
+// transactions of an invalid block don't change the outputs.
+//
+// This function MUST be called with the wallet lock held.
+func (a *AccountManager) connectBlock(block *types.SerializedBlock) error {
+	node := a.bm.GetChain().BlockIndex().LookupNode(block.Hash())
+	valid := node == nil || !node.GetStatus().KnownInvalid()
+
+	return a.db.Update(func(dbTx database.Tx) error {
+		if valid {
+			walletBucket := dbTx.Metadata().Bucket(walletParentBucketKey)
+			utxoBucket := walletBucket.Bucket(utxoBucketKey)
+			var je journalEntry
+			for _, tx := range block.Transactions() {
+				msgTx := tx.Transaction()
+				isCoinBase := msgTx.IsCoinBase()
+				if !isCoinBase {
+					for _, txIn := range msgTx.TxIn {
+						key := outpointKey(&txIn.PreviousOut)
+						value := utxoBucket.Get(key)
+						if value == nil {
+							continue
+						}
+						je.spent = append(je.spent, [2][]byte{key,
+							append([]byte(nil), value...)})
+						err := utxoBucket.Delete(key)
+						if err != nil {
+							return err
+						}
+					}
+				}
+				for i, txOut := range msgTx.TxOut {
+					if _, ok := a.isMine(txOut.PkScript); !ok {
+						continue
+					}
+					key := outpointKey(types.NewOutPoint(tx.Hash(), uint32(i)))
+					err := utxoBucket.Put(key, serializeUtxo(&walletUtxo{
+						amount:    txOut.Amount,
+						blockHash: *block.Hash(),
+						coinbase:  isCoinBase,
+						pkScript:  txOut.PkScript,
+					}))
+					if err != nil {
+						return err
+					}
+					je.created = append(je.created, key)
+				}
+			}
+			if len(je.created) > 0 || len(je.spent) > 0 {
+				serialized, err := je.serialize()
+				if err != nil {
+					return err
+				}
+				err = walletBucket.Bucket(journalBucketKey).Put(
+					block.Hash()[:], serialized)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return dbPutSynced(dbTx, block.Hash(), block.Order())
+	})
+}
+
+// DisconnectBlock restores the outputs of the wallet the passed block spent
+// and removes the ones it created.  It is invoked when a block is
+// disconnected.
+func (a *AccountManager) DisconnectBlock(block *types.SerializedBlock) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if !a.enabled() {
+		return
+	}
+	err := a.disconnectBlock(block)
+	if err != nil {
+		log.Error("Failed to disconnect block from the wallet", "block",
+			block.Hash(), "error", err)
+	}
+}
+
+// disconnectBlock reverts the changes recorded when the passed block was
+// connected.
+//
+// This function MUST be called with the wallet lock held.
+func (a *AccountManager) disconnectBlock(block *types.SerializedBlock) error {
+	return a.db.Update(func(dbTx database.Tx) error {
+		walletBucket := dbTx.Metadata().Bucket(walletParentBucketKey)
+		journalBucket := walletBucket.Bucket(journalBucketKey)
+		serialized := journalBucket.Get(block.Hash()[:])
+		if serialized != nil {
+			je, err := deserializeJournalEntry(serialized)
+			if err != nil {
+				return err
+			}
+			// The spent outputs are restored first, since one of them
+			// might have been created by the block too.
+			utxoBucket := walletBucket.Bucket(utxoBucketKey)
+			for _, kv := range je.spent {
+				err = utxoBucket.Put(kv[0], kv[1])
+				if err != nil {
+					return err
+				}
+			}
+			for _, key := range je.created {
+				err = utxoBucket.Delete(key)
+				if err != nil {
+					return err
+				}
+			}
+			err = journalBucket.Delete(block.Hash()[:])
+			if err != nil {
+				return err
+			}
+		}
+
+		// The wallet is synced to the block before the disconnected one.
+		// When it can't be found the blocks are scanned again on the next
+		// start.
+		if block.Order() > 0 {
+			prev, err := a.bm.GetChain().BlockHashByOrder(block.Order() - 1)
+			if err == nil {
+				return dbPutSynced(dbTx, prev, block.Order()-1)
+			}
+		}
+		return walletBucket.Bucket(stateBucketKey).Delete(syncedKey)
+	})
+}
+
+const (
+	// sigScriptEstimate is the estimated size of the signature script of
+	// an input spending a pay-to-pubkey-hash output.
+	sigScriptEstimate = 1 + 73 + 1 + 33
+
+	// changeOutputEstimate is the estimated size of a pay-to-pubkey-hash
+	// change output.
+	changeOutputEstimate = 8 + 2 + 1 + 25
+)
+
+// errWalletDisabled is returned by the wallet operations when the node runs
+// without the wallet.
+var errWalletDisabled = fmt.Errorf("the wallet is not enabled, start the node with --wallet and create it with createWallet")
+
+// errWalletLocked is returned when the wallet has to sign while it is locked.
+var errWalletLocked = fmt.Errorf("the wallet is locked, unlock it with walletPassphrase")
+
+// walletOutput houses an unspent output of the wallet with its confirmations.
+type walletOutput struct {
+	outPoint      types.TxOutPoint
+	utxo          *walletUtxo
+	confirmations uint
+	spendable     bool
+}
+
+// NewAddress derives the next unused receiving address of the wallet.
+func (a *AccountManager) NewAddress() (types.Address, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if !a.enabled() {
+		return nil, errWalletDisabled
+	}
+	return a.newAddress(externalBranch)
+}
+
+// newAddress derives the next unused address of the passed branch and stores
+// it, so the outputs paying to it are tracked.
+//
+// This function MUST be called with the wallet lock held.
+func (a *AccountManager) newAddress(branch uint32) (types.Address, error) {
+	var h160 []byte
+	var path addrPath
+	err := a.db.Update(func(dbTx database.Tx) error {
+		path = addrPath{branch: branch, index: dbFetchNextIndex(dbTx, branch)}
+		key, err := deriveKey(a.branchKeys, path)
+		if err != nil {
+			return err
+		}
+		h160 = pubKeyHash(key)
+		return dbPutAddress(dbTx, h160, path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var k [20]byte
+	copy(k[:], h160)
+	a.addrs[k] = path
+	return pubKeyHashAddress(h160, a.params)
+}
+
+// outputs returns the unspent outputs of the wallet which are not spent by a
+// transaction of the mempool.  The outputs with fewer confirmations than
+// minConf or an immature coinbase are not spendable.
+func (a *AccountManager) outputs(minConf uint) ([]*walletOutput, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if !a.enabled() {
+		return nil, errWalletDisabled
+	}
+	var utxos map[types.TxOutPoint]*walletUtxo
+	err := a.db.View(func(dbTx database.Tx) error {
+		var err error
+		utxos, err = dbFetchUtxos(dbTx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bd := a.bm.GetChain().BlockDAG()
+	outputs := make([]*walletOutput, 0, len(utxos))
+	for op, u := range utxos {
+		if a.txPool.CheckSpend(op) != nil {
+			continue
+		}
+		confs := bd.GetConfirmations(&u.blockHash)
+		spendable := confs >= minConf
+		if u.coinbase && confs < uint(a.params.CoinbaseMaturity) {
+			spendable = false
+		}
+		outputs = append(outputs, &walletOutput{
+			outPoint:      op,
+			utxo:          u,
+			confirmations: confs,
+			spendable:     spendable,
+		})
+	}
+	return outputs, nil
+}
+
+// Balance returns the total amount of the spendable outputs of the wallet.
+func (a *AccountManager) Balance(minConf uint) (types.Amount, error) {
+	outputs, err := a.outputs(minConf)
+	if err != nil {
+		return 0, err
+	}
+	var balance types.Amount
+	for _, o := range outputs {
+		if o.spendable {
+			balance += types.Amount(o.utxo.amount)
+		}
+	}
+	return balance, nil
+}
+
+// SendToAddress pays the passed amount of atoms to the address from the
+// spendable outputs of the wallet, sends the change to a new internal address
+// and submits the transaction to the mempool.
+func (a *AccountManager) SendToAddress(addr types.Address, amount uint64) (*types.Tx, error) {
+	// The outputs selected are spent by the mempool before the next
+	// transaction is built.
+	a.sendMtx.Lock()
+	defer a.sendMtx.Unlock()
+
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := a.outputs(1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].utxo.amount > outputs[j].utxo.amount
+	})
+
+	minTxFee := types.Amount(a.cfg.MinTxFee)
+	mtx := types.NewTransaction()
+	mtx.AddTxOut(types.NewTxOutput(amount, pkScript))
+	var selected []*walletOutput
+	var total, fee uint64
+	for _, o := range outputs {
+		if !o.spendable {
+			continue
+		}
+		mtx.AddTxIn(types.NewTxInput(&o.outPoint, nil))
+		selected = append(selected, o)
+		total += o.utxo.amount
+
+		// The size is estimated with the signature scripts of the inputs
+		// and a change output.
+		size := mtx.SerializeSize() + len(mtx.TxIn)*sigScriptEstimate +
+			changeOutputEstimate
+		fee = uint64(mempool.CalcMinRequiredTxRelayFee(int64(size), minTxFee))
+		if total >= amount+fee {
+			break
+		}
+	}
+	if total < amount+fee {
+		return nil, fmt.Errorf("insufficient funds: %v available, %v "+
+			"needed", types.Amount(total).ToUnit(types.AmountCoin),
+			types.Amount(amount+fee).ToUnit(types.AmountCoin))
+	}
+
+	tx, err := a.signTx(mtx, selected, total-amount-fee, minTxFee)
+	if err != nil {
+		return nil, err
+	}
+
+	// The wallet lock is not held while the transaction is processed, since
+	// the block manager notifies the wallet of the blocks it connects.
+	acceptedTxs, err := a.bm.ProcessTransaction(tx, false, false, false)
+	if err != nil {
+		return nil, err
+	}
+	a.notify.AnnounceNewTransactions(acceptedTxs)
+	return tx, nil
+}
+
+// signTx adds the change output to the passed transaction, unless it is dust,
+// and signs the inputs spending the selected outputs.
+func (a *AccountManager) signTx(mtx *types.Transaction, selected []*walletOutput,
+	change uint64, minTxFee types.Amount) (*types.Tx, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if !a.enabled() {
+		return nil, errWalletDisabled
+	}
+	if !a.unlocked() {
+		return nil, errWalletLocked
+	}
+
+	if change > 0 {
+		changeAddr, err := a.newAddress(internalBranch)
+		if err != nil {
+			return nil, err
+		}
+		changeScript, err := txscript.PayToAddrScript(changeAddr)
+		if err != nil {
+			return nil, err
+		}
+		// Dust change is left to the miner as fee.
+		changeOut := types.NewTxOutput(change, changeScript)
+		if !mempool.IsDust(changeOut, minTxFee) {
+			mtx.AddTxOut(changeOut)
+		}
+	}
+
+	kdb := txscript.KeyClosure(func(addr types.Address) (ecc.PrivateKey, bool, error) {
+		path, ok := a.addrs[*addr.Hash160()]
+		if !ok {
+			return nil, false, fmt.Errorf("no key for address %v", addr)
+		}
+		key, err := deriveKey(a.privBranchKeys, path)
+		if err != nil {
+			return nil, false, err
+		}
+		return privKey(key), true, nil
+	})
+	for i, o := range selected {
+		sigScript, err := txscript.SignTxOutput(a.params, mtx, i,
+			o.utxo.pkScript, txscript.SigHashAll, kdb, nil, nil,
+			ecc.ECDSA_Secp256k1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign input %d: %v", i, err)
+		}
+		mtx.TxIn[i].SignScript = sigScript
+	}
+	return types.NewTx(mtx), nil
+}
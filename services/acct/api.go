@@ -1,5 +1,22 @@
 package acct
 
+import (
+	"encoding/hex"
+	"github.com/Qitmeer/qitmeer/core/address"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/log"
+	"github.com/Qitmeer/qitmeer/rpc"
+	"github.com/Qitmeer/qitmeer/services/mempool"
+	"sort"
+	"time"
+)
+
+// maxUnlockTimeout is the longest time in seconds the wallet can be unlocked
+// for by walletPassphrase.
+const maxUnlockTimeout = 100000000
+
 // PublicEthereumAPI provides an API to access Ethereum full node-related
 // information.
 type PublicAccountManagerAPI struct {
@@ -11,7 +28,143 @@ func NewPublicAccountManagerAPI(a *AccountManager) *PublicAccountManagerAPI {
 	return &PublicAccountManagerAPI{a}
 }
 
-func (api *PublicAccountManagerAPI) GetBalance() int32 {
-	return 0
+// GetBalance returns the amount of the spendable outputs of the wallet with at
+// least minConf confirmations, 1 by default.
+func (api *PublicAccountManagerAPI) GetBalance(minConf *uint) (interface{}, error) {
+	conf := uint(1)
+	if minConf != nil {
+		conf = *minConf
+	}
+	balance, err := api.a.Balance(conf)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Wallet balance")
+	}
+	return balance.ToUnit(types.AmountCoin), nil
+}
+
+// ListUnspent returns the unspent outputs of the wallet with at least minConf
+// confirmations, 1 by default.
+func (api *PublicAccountManagerAPI) ListUnspent(minConf *uint) (interface{}, error) {
+	conf := uint(1)
+	if minConf != nil {
+		conf = *minConf
+	}
+	outputs, err := api.a.outputs(conf)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Wallet outputs")
+	}
+
+	result := make([]json.ListUnspentResult, 0, len(outputs))
+	for _, o := range outputs {
+		if o.confirmations < conf {
+			continue
+		}
+		var encodedAddr string
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(o.utxo.pkScript,
+			api.a.params)
+		if err == nil && len(addrs) == 1 {
+			encodedAddr = addrs[0].Encode()
+		}
+		result = append(result, json.ListUnspentResult{
+			TxId:          o.outPoint.Hash.String(),
+			Vout:          o.outPoint.OutIndex,
+			Address:       encodedAddr,
+			ScriptPubKey:  hex.EncodeToString(o.utxo.pkScript),
+			Amount:        types.Amount(o.utxo.amount).ToUnit(types.AmountCoin),
+			Confirmations: int64(o.confirmations),
+			Coinbase:      o.utxo.coinbase,
+			Spendable:     o.spendable,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Confirmations < result[j].Confirmations
+	})
+	return result, nil
 }
 
+// PrivateAccountManagerAPI provides private RPC methods to create, unlock and
+// spend from the wallet.
+type PrivateAccountManagerAPI struct {
+	a *AccountManager
+}
+
+func NewPrivateAccountManagerAPI(a *AccountManager) *PrivateAccountManagerAPI {
+	return &PrivateAccountManagerAPI{a}
+}
+
+// CreateWallet creates the seed of the wallet encrypted with the passphrase
+// and returns its mnemonic, which is not shown again and has to be backed up.
+func (api *PrivateAccountManagerAPI) CreateWallet(passphrase string) (interface{}, error) {
+	mnemonic, err := api.a.CreateWallet(passphrase)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Create wallet")
+	}
+	return mnemonic, nil
+}
+
+// WalletPassphrase unlocks the wallet with the passphrase for timeout seconds,
+// so it can send.
+func (api *PrivateAccountManagerAPI) WalletPassphrase(passphrase string, timeout int64) (interface{}, error) {
+	if timeout <= 0 || timeout > maxUnlockTimeout {
+		return nil, rpc.RpcInvalidError("Invalid timeout: 0 >= %v "+
+			"> %v", timeout, maxUnlockTimeout)
+	}
+	err := api.a.Unlock(passphrase, time.Duration(timeout)*time.Second)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Unlock wallet")
+	}
+	return nil, nil
+}
+
+// WalletLock locks the wallet before the timeout of walletPassphrase.
+func (api *PrivateAccountManagerAPI) WalletLock() (interface{}, error) {
+	if err := api.a.Lock(); err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Lock wallet")
+	}
+	return nil, nil
+}
+
+// GetNewAddress returns a new receiving address of the wallet.
+func (api *PrivateAccountManagerAPI) GetNewAddress() (interface{}, error) {
+	addr, err := api.a.NewAddress()
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "New address")
+	}
+	return addr.Encode(), nil
+}
+
+// SendToAddress pays amount atoms to the address from the wallet and returns
+// the hash of the transaction.
+func (api *PrivateAccountManagerAPI) SendToAddress(encodedAddr string, amount uint64) (interface{}, error) {
+	// Ensure amount is in the valid range for monetary amounts.
+	if amount <= 0 || amount > types.MaxAmount {
+		return nil, rpc.RpcInvalidError("Invalid amount: 0 >= %v "+
+			"> %v", amount, types.MaxAmount)
+	}
+
+	addr, err := address.DecodeAddress(encodedAddr)
+	if err != nil {
+		return nil, rpc.RpcAddressKeyError("Could not decode "+
+			"address: %v", err)
+	}
+	switch addr.(type) {
+	case *address.PubKeyHashAddress:
+	case *address.ScriptHashAddress:
+	default:
+		return nil, rpc.RpcAddressKeyError("Invalid type: %T", addr)
+	}
+	if !address.IsForNetwork(addr, api.a.params) {
+		return nil, rpc.RpcAddressKeyError("Wrong network: %v",
+			addr)
+	}
+
+	tx, err := api.a.SendToAddress(addr, amount)
+	if err != nil {
+		if _, ok := err.(mempool.RuleError); ok {
+			log.Error("Failed to process wallet transaction", "mempool.RuleError", err)
+			return nil, rpc.RpcRuleError("%v", err)
+		}
+		return nil, rpc.RpcInternalError(err.Error(), "Send to address")
+	}
+	return tx.Hash().String(), nil
+}
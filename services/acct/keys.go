@@ -0,0 +1,87 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package acct
+
+import (
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer-lib/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/core/address"
+	"github.com/Qitmeer/qitmeer/crypto/bip32"
+	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/wallet"
+)
+
+const (
+	// externalBranch is the BIP44 branch of the addresses handed out to
+	// receive payments.
+	externalBranch uint32 = 0
+
+	// internalBranch is the BIP44 branch of the change addresses.
+	internalBranch uint32 = 1
+)
+
+// addrPath is the position of an address of the wallet below the account key.
+type addrPath struct {
+	branch uint32
+	index  uint32
+}
+
+// deriveAccountKey derives the key of the first account from the passed seed,
+// which is m/44'/223'/0'.
+func deriveAccountKey(seed []byte) (*bip32.Key, error) {
+	key, err := bip32.NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	// The account key is the base derivation path without the branch and
+	// the address index.
+	for _, i := range wallet.QitmeerBaseDerivationPath[:3] {
+		key, err = key.NewChildKey(i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// deriveBranchKeys derives the keys of the external and the internal branch
+// from the passed account key, which are m/44'/223'/0'/0 and m/44'/223'/0'/1.
+// The branch keys are public when the account key is public, in which case
+// they only derive the addresses.
+func deriveBranchKeys(accountKey *bip32.Key) ([2]*bip32.Key, error) {
+	var branchKeys [2]*bip32.Key
+	for _, branch := range []uint32{externalBranch, internalBranch} {
+		var err error
+		branchKeys[branch], err = accountKey.NewChildKey(branch)
+		if err != nil {
+			return branchKeys, err
+		}
+	}
+	return branchKeys, nil
+}
+
+// deriveKey derives the key of the address at the passed path, which is
+// private when the branch keys are.
+func deriveKey(branchKeys [2]*bip32.Key, path addrPath) (*bip32.Key, error) {
+	return branchKeys[path.branch].NewChildKey(path.index)
+}
+
+// pubKeyHash returns the hash160 of the compressed public key of the passed
+// private key.
+func pubKeyHash(key *bip32.Key) []byte {
+	return hash.Hash160(key.PublicKey().Key)
+}
+
+// pubKeyHashAddress returns the pay-to-pubkey-hash address of the passed
+// hash160.
+func pubKeyHashAddress(h160 []byte, par *params.Params) (*address.PubKeyHashAddress, error) {
+	return address.NewPubKeyHashAddress(h160, par, ecc.ECDSA_Secp256k1)
+}
+
+// privKey returns the private key of the passed bip32 key for signing.
+func privKey(key *bip32.Key) ecc.PrivateKey {
+	priv, _ := ecc.Secp256k1.PrivKeyFromBytes(key.Key)
+	return priv
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package acct
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/crypto/bip32"
+	"github.com/Qitmeer/qitmeer/crypto/bip39"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	// seedFileName is the name of the file in the data directory which
+	// houses the encrypted mnemonic of the wallet.
+	seedFileName = "wallet.seed"
+
+	// seedFileVersion is the current version of the seed file.
+	seedFileVersion = 1
+
+	// The scrypt parameters used to derive the key which encrypts the
+	// mnemonic from the passphrase, along with scryptN.
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+
+	// mnemonicEntropyBits is the entropy of the mnemonic of a new wallet.
+	mnemonicEntropyBits = 256
+)
+
+// scryptN is the CPU and memory cost of scrypt.  It is a variable so the tests
+// can use a cheaper one.
+var scryptN = 1 << 18
+
+// ErrWrongPassphrase is returned when the seed file can't be decrypted with
+// the passphrase.
+var ErrWrongPassphrase = errors.New("wrong wallet passphrase")
+
+// seedFile is the json format of the seed file.  The extended public key of
+// the account is kept in the clear, so the wallet derives and tracks its
+// addresses without the passphrase.
+type seedFile struct {
+	Version    int    `json:"version"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Cipher     string `json:"cipher"`
+	AccountKey string `json:"accountkey"`
+}
+
+// passphraseKey derives the key which encrypts the mnemonic from the passed
+// passphrase and salt.
+func passphraseKey(passphrase string, salt []byte) (*[scryptKeyLen]byte, error) {
+	k, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	var key [scryptKeyLen]byte
+	copy(key[:], k)
+	return &key, nil
+}
+
+// createSeedFile creates a new mnemonic and writes it to the passed path
+// encrypted with the passphrase.  It returns the mnemonic, which is only shown
+// once for backup, and the extended public key of the account.
+func createSeedFile(path string, passphrase string) (string, *bip32.Key, error) {
+	entropy, err := bip39.NewEntropy(mnemonicEntropyBits)
+	if err != nil {
+		return "", nil, err
+	}
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		return "", nil, err
+	}
+	accountKey, err := deriveAccountKey(bip39.NewSeed(mnemonic, ""))
+	if err != nil {
+		return "", nil, err
+	}
+	accountKey = accountKey.PublicKey()
+
+	var salt [32]byte
+	var nonce [24]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", nil, err
+	}
+	key, err := passphraseKey(passphrase, salt[:])
+	if err != nil {
+		return "", nil, err
+	}
+	sealed := secretbox.Seal(nil, []byte(mnemonic), &nonce, key)
+
+	content, err := json.Marshal(&seedFile{
+		Version:    seedFileVersion,
+		Salt:       hex.EncodeToString(salt[:]),
+		Nonce:      hex.EncodeToString(nonce[:]),
+		Cipher:     hex.EncodeToString(sealed),
+		AccountKey: accountKey.B58Serialize(),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	// The file must not replace the seed of an existing wallet.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = f.Write(content)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", nil, err
+	}
+	err = f.Close()
+	if err != nil {
+		return "", nil, err
+	}
+	return mnemonic, accountKey, nil
+}
+
+// readSeedFile reads and parses the seed file at the passed path.
+func readSeedFile(path string) (*seedFile, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sf seedFile
+	err = json.Unmarshal(content, &sf)
+	if err != nil {
+		return nil, fmt.Errorf("malformed seed file %s: %v", path, err)
+	}
+	if sf.Version != seedFileVersion {
+		return nil, fmt.Errorf("unsupported seed file version %d", sf.Version)
+	}
+	return &sf, nil
+}
+
+// loadAccountKey returns the extended public key of the account of the seed
+// file at the passed path.
+func loadAccountKey(path string) (*bip32.Key, error) {
+	sf, err := readSeedFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := bip32.B58Deserialize(sf.AccountKey, bip32.DefaultBip32Version)
+	if err != nil {
+		return nil, fmt.Errorf("malformed seed file account key: %v", err)
+	}
+	if key.IsPrivate {
+		return nil, fmt.Errorf("seed file account key is private")
+	}
+	return key, nil
+}
+
+// loadSeedFile decrypts the mnemonic of the seed file at the passed path with
+// the passphrase and returns its seed.
+func loadSeedFile(path string, passphrase string) ([]byte, error) {
+	sf, err := readSeedFile(path)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(sf.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("malformed seed file salt: %v", err)
+	}
+	nonceBytes, err := hex.DecodeString(sf.Nonce)
+	if err != nil || len(nonceBytes) != 24 {
+		return nil, fmt.Errorf("malformed seed file nonce")
+	}
+	sealed, err := hex.DecodeString(sf.Cipher)
+	if err != nil {
+		return nil, fmt.Errorf("malformed seed file cipher: %v", err)
+	}
+
+	key, err := passphraseKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	copy(nonce[:], nonceBytes)
+	mnemonic, ok := secretbox.Open(nil, sealed, &nonce, key)
+	if !ok {
+		return nil, ErrWrongPassphrase
+	}
+	return bip39.NewSeedWithErrorChecking(string(mnemonic), "")
+}
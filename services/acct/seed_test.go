@@ -0,0 +1,175 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package acct
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/crypto/bip39"
+)
+
+func init() {
+	// The default cost of scrypt makes the tests needlessly slow.
+	scryptN = 1 << 4
+}
+
+// TestSeedFileRoundTrip ensures the seed of a created seed file is decrypted
+// with its passphrase only, and the account key kept in the clear derives the
+// same addresses as the private keys of the seed.
+func TestSeedFileRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acct-seed")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, seedFileName)
+
+	mnemonic, accountKey, err := createSeedFile(path, "passphrase")
+	if err != nil {
+		t.Fatalf("createSeedFile: %v", err)
+	}
+	if accountKey.IsPrivate {
+		t.Fatalf("createSeedFile returned a private account key")
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if bytes.Contains(content, []byte(mnemonic)) {
+		t.Fatalf("seed file contains the mnemonic")
+	}
+
+	// The file of an existing wallet is never replaced.
+	if _, _, err := createSeedFile(path, "other"); err == nil {
+		t.Fatalf("createSeedFile replaced the seed file")
+	}
+
+	seed, err := loadSeedFile(path, "passphrase")
+	if err != nil {
+		t.Fatalf("loadSeedFile: %v", err)
+	}
+	if !bytes.Equal(seed, bip39.NewSeed(mnemonic, "")) {
+		t.Fatalf("loadSeedFile returned the seed %x, want the seed of "+
+			"the mnemonic", seed)
+	}
+	if _, err := loadSeedFile(path, "wrong"); err != ErrWrongPassphrase {
+		t.Fatalf("loadSeedFile with the wrong passphrase: got %v, "+
+			"want %v", err, ErrWrongPassphrase)
+	}
+
+	loadedKey, err := loadAccountKey(path)
+	if err != nil {
+		t.Fatalf("loadAccountKey: %v", err)
+	}
+	if loadedKey.B58Serialize() != accountKey.B58Serialize() {
+		t.Fatalf("loadAccountKey got %v, want %v", loadedKey, accountKey)
+	}
+
+	privAccountKey, err := deriveAccountKey(seed)
+	if err != nil {
+		t.Fatalf("deriveAccountKey: %v", err)
+	}
+	privBranchKeys, err := deriveBranchKeys(privAccountKey)
+	if err != nil {
+		t.Fatalf("deriveBranchKeys: %v", err)
+	}
+	pubBranchKeys, err := deriveBranchKeys(loadedKey)
+	if err != nil {
+		t.Fatalf("deriveBranchKeys: %v", err)
+	}
+	for _, path := range []addrPath{{externalBranch, 0},
+		{externalBranch, 7}, {internalBranch, 3}} {
+
+		privKey, err := deriveKey(privBranchKeys, path)
+		if err != nil {
+			t.Fatalf("deriveKey: %v", err)
+		}
+		pubKey, err := deriveKey(pubBranchKeys, path)
+		if err != nil {
+			t.Fatalf("deriveKey: %v", err)
+		}
+		if !bytes.Equal(pubKeyHash(privKey), pubKeyHash(pubKey)) {
+			t.Errorf("address %v of the public account key differs",
+				path)
+		}
+	}
+}
+
+// TestUnlockTimeout ensures the private keys of the wallet are only kept until
+// the timeout of the unlock or until it is locked.
+func TestUnlockTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acct-unlock")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := &AccountManager{cfg: &config.Config{DataDir: dir, Wallet: true}}
+	if err := a.Unlock("passphrase", time.Minute); err != errWalletDisabled {
+		t.Fatalf("Unlock without a wallet: got %v, want %v", err,
+			errWalletDisabled)
+	}
+	_, accountKey, err := createSeedFile(filepath.Join(dir, seedFileName),
+		"passphrase")
+	if err != nil {
+		t.Fatalf("createSeedFile: %v", err)
+	}
+	a.branchKeys, err = deriveBranchKeys(accountKey)
+	if err != nil {
+		t.Fatalf("deriveBranchKeys: %v", err)
+	}
+	unlocked := func() bool {
+		a.mtx.Lock()
+		defer a.mtx.Unlock()
+		return a.unlocked()
+	}
+
+	if err := a.Unlock("wrong", time.Minute); err != ErrWrongPassphrase {
+		t.Fatalf("Unlock with the wrong passphrase: got %v, want %v",
+			err, ErrWrongPassphrase)
+	}
+	if unlocked() {
+		t.Fatalf("wallet unlocked with the wrong passphrase")
+	}
+
+	if err := a.Unlock("passphrase", time.Minute); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if !unlocked() {
+		t.Fatalf("wallet is not unlocked")
+	}
+	if err := a.Lock(); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if unlocked() {
+		t.Fatalf("wallet is unlocked after Lock")
+	}
+
+	// The timer of an earlier unlock doesn't lock the wallet again.
+	if err := a.Unlock("passphrase", time.Millisecond*10); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := a.Unlock("passphrase", time.Minute); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if !unlocked() {
+		t.Fatalf("wallet was locked by the timer of an earlier unlock")
+	}
+
+	if err := a.Unlock("passphrase", time.Millisecond*10); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if unlocked() {
+		t.Fatalf("wallet is still unlocked after the timeout")
+	}
+}
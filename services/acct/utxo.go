@@ -0,0 +1,320 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package acct
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"io"
+)
+
+var (
+	// walletParentBucketKey is the name of the parent db bucket of the
+	// buckets used to house the state of the wallet.
+	walletParentBucketKey = []byte("walletparentbucket")
+
+	// utxoBucketKey is the name of the db bucket used to house the
+	// mapping of outpoints to the unspent outputs of the wallet.
+	utxoBucketKey = []byte("utxos")
+
+	// journalBucketKey is the name of the db bucket used to house the
+	// mapping of block hashes to the outputs of the wallet the block
+	// created and spent, which is needed to disconnect the block.
+	journalBucketKey = []byte("journal")
+
+	// addrBucketKey is the name of the db bucket used to house the mapping
+	// of the hash160 of the addresses of the wallet to their derivation
+	// branch and index.
+	addrBucketKey = []byte("addrs")
+
+	// stateBucketKey is the name of the db bucket used to house the next
+	// address index of every branch and the last synced block.
+	stateBucketKey = []byte("state")
+
+	// syncedKey is the key of the hash and order of the last block the
+	// wallet is synced to in the state bucket.
+	syncedKey = []byte("synced")
+
+	// walletBucketKeys are the names of the buckets of the wallet.
+	walletBucketKeys = [][]byte{utxoBucketKey, journalBucketKey,
+		addrBucketKey, stateBucketKey}
+)
+
+// outpointKeySize is the size of the key of an outpoint.
+const outpointKeySize = hash.HashSize + 4
+
+// walletUtxo houses an unspent output paying to an address of the wallet.
+type walletUtxo struct {
+	amount    uint64
+	blockHash hash.Hash
+	coinbase  bool
+	pkScript  []byte
+}
+
+// outpointKey returns the key of the passed outpoint in the utxo bucket.
+func outpointKey(op *types.TxOutPoint) []byte {
+	key := make([]byte, outpointKeySize)
+	copy(key, op.Hash[:])
+	binary.BigEndian.PutUint32(key[hash.HashSize:], op.OutIndex)
+	return key
+}
+
+// outpointFromKey decodes a key returned by outpointKey.
+func outpointFromKey(key []byte) types.TxOutPoint {
+	var op types.TxOutPoint
+	copy(op.Hash[:], key[:hash.HashSize])
+	op.OutIndex = binary.BigEndian.Uint32(key[hash.HashSize:])
+	return op
+}
+
+// serializeUtxo returns the amount, the block hash, the coinbase flag and the
+// script of the passed output.
+func serializeUtxo(u *walletUtxo) []byte {
+	serialized := make([]byte, 8+hash.HashSize+1+len(u.pkScript))
+	binary.LittleEndian.PutUint64(serialized, u.amount)
+	copy(serialized[8:], u.blockHash[:])
+	if u.coinbase {
+		serialized[8+hash.HashSize] = 1
+	}
+	copy(serialized[8+hash.HashSize+1:], u.pkScript)
+	return serialized
+}
+
+// deserializeUtxo decodes an output serialized by serializeUtxo.
+func deserializeUtxo(serialized []byte) (*walletUtxo, error) {
+	if len(serialized) < 8+hash.HashSize+1 {
+		return nil, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: "corrupt wallet utxo entry",
+		}
+	}
+	u := &walletUtxo{
+		amount:   binary.LittleEndian.Uint64(serialized),
+		coinbase: serialized[8+hash.HashSize] == 1,
+		pkScript: append([]byte(nil), serialized[8+hash.HashSize+1:]...),
+	}
+	copy(u.blockHash[:], serialized[8:])
+	return u, nil
+}
+
+// journalEntry houses the outputs of the wallet a block created and the ones
+// it spent.
+type journalEntry struct {
+	created [][]byte
+	spent   [][2][]byte
+}
+
+// serialize returns the keys of the created outputs followed by the keys and
+// the values of the spent outputs.
+func (je *journalEntry) serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	err := s.WriteVarInt(&buf, 0, uint64(len(je.created)))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range je.created {
+		buf.Write(key)
+	}
+	err = s.WriteVarInt(&buf, 0, uint64(len(je.spent)))
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range je.spent {
+		buf.Write(kv[0])
+		err = s.WriteVarBytes(&buf, 0, kv[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// deserializeJournalEntry decodes an entry serialized by serialize.
+func deserializeJournalEntry(serialized []byte) (*journalEntry, error) {
+	r := bytes.NewReader(serialized)
+	je := &journalEntry{}
+	count, err := s.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(len(serialized)/outpointKeySize) {
+		return nil, fmt.Errorf("corrupt wallet journal entry")
+	}
+	for i := uint64(0); i < count; i++ {
+		key := make([]byte, outpointKeySize)
+		_, err = io.ReadFull(r, key)
+		if err != nil {
+			return nil, err
+		}
+		je.created = append(je.created, key)
+	}
+	count, err = s.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(len(serialized)/outpointKeySize) {
+		return nil, fmt.Errorf("corrupt wallet journal entry")
+	}
+	for i := uint64(0); i < count; i++ {
+		key := make([]byte, outpointKeySize)
+		_, err = io.ReadFull(r, key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := s.ReadVarBytes(r, 0, uint32(len(serialized)),
+			"wallet utxo")
+		if err != nil {
+			return nil, err
+		}
+		je.spent = append(je.spent, [2][]byte{key, value})
+	}
+	return je, nil
+}
+
+// serializeSynced returns the hash followed by the order of a block.
+func serializeSynced(h *hash.Hash, order uint64) []byte {
+	serialized := make([]byte, hash.HashSize+8)
+	copy(serialized, h[:])
+	binary.LittleEndian.PutUint64(serialized[hash.HashSize:], order)
+	return serialized
+}
+
+// dbFetchSynced returns the hash and order of the last block the wallet is
+// synced to, or a nil hash when the wallet has never been synced.
+func dbFetchSynced(dbTx database.Tx) (*hash.Hash, uint64) {
+	serialized := dbTx.Metadata().Bucket(walletParentBucketKey).
+		Bucket(stateBucketKey).Get(syncedKey)
+	if len(serialized) != hash.HashSize+8 {
+		return nil, 0
+	}
+	var h hash.Hash
+	copy(h[:], serialized)
+	return &h, binary.LittleEndian.Uint64(serialized[hash.HashSize:])
+}
+
+// dbPutSynced stores the hash and order of the last block the wallet is
+// synced to.
+func dbPutSynced(dbTx database.Tx, h *hash.Hash, order uint64) error {
+	return dbTx.Metadata().Bucket(walletParentBucketKey).
+		Bucket(stateBucketKey).Put(syncedKey, serializeSynced(h, order))
+}
+
+// branchKey returns the key of the next address index of the passed branch in
+// the state bucket.
+func branchKey(branch uint32) []byte {
+	return []byte(fmt.Sprintf("next%d", branch))
+}
+
+// dbFetchNextIndex returns the index of the next address of the passed branch.
+func dbFetchNextIndex(dbTx database.Tx, branch uint32) uint32 {
+	serialized := dbTx.Metadata().Bucket(walletParentBucketKey).
+		Bucket(stateBucketKey).Get(branchKey(branch))
+	if len(serialized) != 4 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(serialized)
+}
+
+// dbPutAddress stores the passed address of the wallet and advances the next
+// address index of its branch.
+func dbPutAddress(dbTx database.Tx, h160 []byte, path addrPath) error {
+	walletBucket := dbTx.Metadata().Bucket(walletParentBucketKey)
+	var serialized [8]byte
+	binary.LittleEndian.PutUint32(serialized[:], path.branch)
+	binary.LittleEndian.PutUint32(serialized[4:], path.index)
+	err := walletBucket.Bucket(addrBucketKey).Put(h160, serialized[:])
+	if err != nil {
+		return err
+	}
+	var next [4]byte
+	binary.LittleEndian.PutUint32(next[:], path.index+1)
+	return walletBucket.Bucket(stateBucketKey).Put(branchKey(path.branch),
+		next[:])
+}
+
+// dbFetchAddresses returns all the addresses of the wallet.
+func dbFetchAddresses(dbTx database.Tx) (map[[20]byte]addrPath, error) {
+	addrs := make(map[[20]byte]addrPath)
+	err := dbTx.Metadata().Bucket(walletParentBucketKey).
+		Bucket(addrBucketKey).ForEach(func(k, v []byte) error {
+		if len(k) != 20 || len(v) != 8 {
+			return database.Error{
+				ErrorCode:   database.ErrCorruption,
+				Description: "corrupt wallet address entry",
+			}
+		}
+		var h160 [20]byte
+		copy(h160[:], k)
+		addrs[h160] = addrPath{
+			branch: binary.LittleEndian.Uint32(v),
+			index:  binary.LittleEndian.Uint32(v[4:]),
+		}
+		return nil
+	})
+	return addrs, err
+}
+
+// dbFetchUtxos returns all the unspent outputs of the wallet.
+func dbFetchUtxos(dbTx database.Tx) (map[types.TxOutPoint]*walletUtxo, error) {
+	utxos := make(map[types.TxOutPoint]*walletUtxo)
+	err := dbTx.Metadata().Bucket(walletParentBucketKey).
+		Bucket(utxoBucketKey).ForEach(func(k, v []byte) error {
+		if len(k) != outpointKeySize {
+			return database.Error{
+				ErrorCode:   database.ErrCorruption,
+				Description: "corrupt wallet utxo key",
+			}
+		}
+		u, err := deserializeUtxo(v)
+		if err != nil {
+			return err
+		}
+		utxos[outpointFromKey(k)] = u
+		return nil
+	})
+	return utxos, err
+}
+
+// dbCreateWalletBuckets creates the buckets of the wallet if they don't exist
+// yet and returns whether they were created.
+func dbCreateWalletBuckets(dbTx database.Tx) (bool, error) {
+	meta := dbTx.Metadata()
+	if meta.Bucket(walletParentBucketKey) != nil {
+		return false, nil
+	}
+	walletBucket, err := meta.CreateBucket(walletParentBucketKey)
+	if err != nil {
+		return false, err
+	}
+	for _, bucketName := range walletBucketKeys {
+		_, err = walletBucket.CreateBucket(bucketName)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// dbClearUtxos removes all the outputs and the journal of the wallet before
+// the blocks are scanned again.  The addresses are kept.
+func dbClearUtxos(dbTx database.Tx) error {
+	walletBucket := dbTx.Metadata().Bucket(walletParentBucketKey)
+	for _, bucketName := range [][]byte{utxoBucketKey, journalBucketKey} {
+		err := walletBucket.DeleteBucket(bucketName)
+		if err != nil {
+			return err
+		}
+		_, err = walletBucket.CreateBucket(bucketName)
+		if err != nil {
+			return err
+		}
+	}
+	return walletBucket.Bucket(stateBucketKey).Delete(syncedKey)
+}
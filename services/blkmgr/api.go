@@ -7,12 +7,14 @@ import (
 	"encoding/hex"
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/common/marshal"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/json"
 	"github.com/Qitmeer/qitmeer/rpc"
+	"github.com/Qitmeer/qitmeer/services/index"
 	"strconv"
+	"time"
 )
 
 func (b *BlockManager) GetChain() *blockchain.BlockChain {
@@ -273,3 +275,123 @@ func (api *PublicBlockAPI) GetBlockByID(id uint64, verbose *bool, inclTx *bool,
 	}
 	return api.GetBlock(*blockHash, &vb, &iTx, &fTx)
 }
+
+// GetDeploymentInfo returns the consensus rule change deployments of the chain
+// along with their threshold state for the next block.
+func (api *PublicBlockAPI) GetDeploymentInfo() (interface{}, error) {
+	chain := api.bm.GetChain()
+	deployments := chain.Deployments()
+	result := make([]json.DeploymentInfoResult, 0, len(deployments))
+	for _, deployment := range deployments {
+		state, err := chain.ThresholdState(deployment.Id)
+		if err != nil {
+			context := "Failed to get deployment state"
+			return nil, rpc.RpcInternalError(err.Error(), context)
+		}
+		result = append(result, json.DeploymentInfoResult{
+			Id:          deployment.Id,
+			Description: deployment.Description,
+			Bit:         deployment.BitNumber,
+			StartTime:   deployment.StartTime,
+			ExpireTime:  deployment.ExpireTime,
+			Status:      deploymentStatus(state),
+		})
+	}
+	return result, nil
+}
+
+// timeIndex returns the time index, or an error when it isn't ready.
+func (api *PublicBlockAPI) timeIndex() (*index.TimeIndex, error) {
+	timeIndex := api.bm.timeIndex
+	if err := timeIndex.Ready(); err != nil {
+		if err == index.ErrIndexSyncing {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Time index must be enabled (--timeindex)")
+	}
+	return timeIndex, nil
+}
+
+// blockTimeResult returns the json result of a block of the time index.
+func blockTimeResult(blockTime *index.BlockTime) json.BlockTimeResult {
+	return json.BlockTimeResult{
+		Order:      blockTime.Order,
+		Hash:       blockTime.Hash.String(),
+		Time:       blockTime.Timestamp.Unix(),
+		MedianTime: blockTime.MedianTime.Unix(),
+	}
+}
+
+// Return the block with the earliest timestamp at or after the unix time 'ts'
+// with the mode "after", which is the default, or the block with the latest
+// timestamp at or before it with the mode "before". The modes "medianafter"
+// and "medianbefore" search by the past median time of the blocks instead
+func (api *PublicBlockAPI) GetBlockOrderByTime(ts int64, mode *string) (interface{}, error) {
+	var median, before bool
+	if mode != nil {
+		switch *mode {
+		case "after":
+		case "before":
+			before = true
+		case "medianafter":
+			median = true
+		case "medianbefore":
+			median, before = true, true
+		default:
+			return nil, rpc.RpcInvalidError("Invalid mode %q", *mode)
+		}
+	}
+	timeIndex, err := api.timeIndex()
+	if err != nil {
+		return nil, err
+	}
+	blockTime, err := timeIndex.BlockByTime(time.Unix(ts, 0), median, before)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to search the time index")
+	}
+	if blockTime == nil {
+		return nil, fmt.Errorf("No block found for time %d", ts)
+	}
+	return blockTimeResult(blockTime), nil
+}
+
+// Return the blocks with a timestamp from the unix time 'start' to 'end'
+// (exclude self), ordered by timestamp then by order. At most 'limit' blocks
+// are returned, 100 by default
+func (api *PublicBlockAPI) GetBlocksInTimeRange(start int64, end int64, limit *uint) (interface{}, error) {
+	numRequested := 100
+	if limit != nil {
+		numRequested = int(*limit)
+	}
+	timeIndex, err := api.timeIndex()
+	if err != nil {
+		return nil, err
+	}
+	blockTimes, err := timeIndex.BlocksInTimeRange(time.Unix(start, 0),
+		time.Unix(end, 0), numRequested)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to search the time index")
+	}
+	result := make([]json.BlockTimeResult, 0, len(blockTimes))
+	for _, blockTime := range blockTimes {
+		result = append(result, blockTimeResult(blockTime))
+	}
+	return result, nil
+}
+
+// deploymentStatus returns the status string of the passed threshold state.
+func deploymentStatus(state blockchain.ThresholdState) string {
+	switch state {
+	case blockchain.ThresholdDefined:
+		return "defined"
+	case blockchain.ThresholdStarted:
+		return "started"
+	case blockchain.ThresholdLockedIn:
+		return "lockedin"
+	case blockchain.ThresholdActive:
+		return "active"
+	case blockchain.ThresholdFailed:
+		return "failed"
+	}
+	return state.String()
+}
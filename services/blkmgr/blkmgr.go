@@ -6,18 +6,20 @@ import (
 	"container/list"
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer-lib/params/dcr/types"
 	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/consensus/pow"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/node/notify"
 	"github.com/Qitmeer/qitmeer/p2p/peer"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/services/common/progresslog"
+	"github.com/Qitmeer/qitmeer/services/index"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -46,6 +48,9 @@ type BlockManager struct {
 
 	chain *blockchain.BlockChain
 
+	// time index
+	timeIndex *index.TimeIndex
+
 	rejectedTxns        map[hash.Hash]struct{}
 	requestedTxns       map[hash.Hash]struct{}
 	requestedEverTxns   map[hash.Hash]uint8
@@ -80,7 +85,8 @@ type BlockManager struct {
 
 // NewBlockManager returns a new block manager.
 // Use Start to begin processing asynchronous block and inv updates.
-func NewBlockManager(ntmgr notify.Notify, indexManager blockchain.IndexManager, db database.DB,
+func NewBlockManager(ntmgr notify.Notify, indexManager blockchain.IndexManager,
+	timeIndex *index.TimeIndex, db database.DB,
 	timeSource blockchain.MedianTimeSource, sigCache *txscript.SigCache,
 	cfg *config.Config, par *params.Params, blockVersion uint32,
 	interrupt <-chan struct{}) (*BlockManager, error) {
@@ -88,6 +94,7 @@ func NewBlockManager(ntmgr notify.Notify, indexManager blockchain.IndexManager,
 		config:              cfg,
 		params:              par,
 		notify:              ntmgr,
+		timeIndex:           timeIndex,
 		rejectedTxns:        make(map[hash.Hash]struct{}),
 		requestedTxns:       make(map[hash.Hash]struct{}),
 		requestedEverTxns:   make(map[hash.Hash]uint8),
@@ -103,15 +110,17 @@ func NewBlockManager(ntmgr notify.Notify, indexManager blockchain.IndexManager,
 	// Create a new block chain instance with the appropriate configuration.
 	var err error
 	bm.chain, err = blockchain.New(&blockchain.Config{
-		DB:            db,
-		Interrupt:     interrupt,
-		ChainParams:   par,
-		TimeSource:    timeSource,
-		Notifications: bm.handleNotifyMsg,
-		SigCache:      sigCache,
-		IndexManager:  indexManager,
-		DAGType:       cfg.DAGType,
-		BlockVersion:  blockVersion,
+		DB:             db,
+		Interrupt:      interrupt,
+		ChainParams:    par,
+		TimeSource:     timeSource,
+		Notifications:  bm.handleNotifyMsg,
+		SigCache:       sigCache,
+		IndexManager:   indexManager,
+		DAGType:        cfg.DAGType,
+		BlockVersion:   blockVersion,
+		PoW:            pow.New(par),
+		PruneBlockData: cfg.PruneBlockData,
 	})
 	if err != nil {
 		return nil, err
@@ -215,6 +224,10 @@ func (b *BlockManager) handleNotifyMsg(notification *blockchain.Notification) {
 		}
 
 		block := blockSlice[0]
+		// Record how long the transactions of the block waited in the
+		// transaction pool for the fee estimation.
+		b.chain.GetTxManager().FeeEstimator().RegisterBlock(block)
+
 		// Remove all of the transactions (except the coinbase) in the
 		// connected block from the transaction pool.  Secondly, remove any
 		// transactions which are now double spends as a result of these
@@ -230,12 +243,8 @@ func (b *BlockManager) handleNotifyMsg(notification *blockchain.Notification) {
 			b.notify.AnnounceNewTransactions(acceptedTxs)
 		}
 
-		/*
-			if r := b.server.rpcServer; r != nil {
-				// Notify registered websocket clients of incoming block.
-				r.ntfnMgr.NotifyBlockConnected(block)
-			}
-		*/
+		// Notify registered websocket clients of incoming block.
+		b.notify.NotifyBlockConnected(block)
 
 	// A block has been disconnected from the main block chain.
 	case blockchain.BlockDisconnected:
@@ -261,28 +270,20 @@ func (b *BlockManager) handleNotifyMsg(notification *blockchain.Notification) {
 			}
 		}
 
-		/*
-			// Notify registered websocket clients.
-			if r := b.server.rpcServer; r != nil {
-				r.ntfnMgr.NotifyBlockDisconnected(block)
-			}
-		*/
+		b.notify.NotifyBlockDisconnected(block)
 
 	// The blockchain is reorganizing.
 	case blockchain.Reorganization:
 		log.Trace("Chain reorganization notification")
-		/*
-			rd, ok := notification.Data.(*blockchain.ReorganizationNotifyData)
-			if !ok {
-				log.Warn("Chain reorganization notification is malformed")
-				break
-			}
-
-			// Notify registered websocket clients.
-			if r := b.server.rpcServer; r != nil {
-				r.ntfnMgr.NotifyReorganization(rd)
-			}
+		rd, ok := notification.Data.(*blockchain.ReorganizationNotifyData)
+		if !ok {
+			log.Warn("Chain reorganization notification is malformed")
+			break
+		}
 
+		// Notify registered websocket clients.
+		b.notify.NotifyReorganization(rd)
+		/*
 			// Drop the associated mining template from the old chain, since it
 			// will be no longer valid.
 			b.cachedCurrentTemplate = nil
@@ -519,6 +520,9 @@ out:
 			case *invMsg:
 				log.Trace("blkmgr msgChan invMsg", "msg", msg)
 				b.handleInvMsg(msg)
+			case *headersMsg:
+				log.Trace("blkmgr msgChan headersMsg", "msg", msg)
+				b.handleHeadersMsg(msg)
 			case *donePeerMsg:
 				log.Trace("blkmgr msgChan donePeerMsg", "msg", msg)
 				b.handleDonePeerMsg(msg.peer)
@@ -0,0 +1,78 @@
+package blkmgr
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/p2p/peer"
+	"sync/atomic"
+)
+
+// headersMsg packages a headers message and the peer it came from together
+// so the block handler has access to that information.
+type headersMsg struct {
+	headers *message.MsgHeaders
+	peer    *peer.ServerPeer
+}
+
+// QueueHeaders adds the passed headers message and peer to the block handling
+// queue.
+func (b *BlockManager) QueueHeaders(headers *message.MsgHeaders, sp *peer.ServerPeer) {
+	// No channel handling here because peers do not need to block on
+	// headers messages.
+	if atomic.LoadInt32(&b.shutdown) != 0 {
+		return
+	}
+
+	b.msgChan <- &headersMsg{headers: headers, peer: sp}
+}
+
+// handleHeadersMsg handles the headers messages the peers send to announce new
+// blocks after a sendheaders message.  The announced blocks which are not known
+// yet are requested from the peer.  A header only commits to the parents of its
+// block through their merkle root, so the missing DAG parents of the blocks
+// which are already known as orphans are requested as well, and the missing
+// parents of the other blocks are requested by the orphan handling once the
+// blocks are received.
+func (b *BlockManager) handleHeadersMsg(hmsg *headersMsg) {
+	sp, exists := b.peers[hmsg.peer.Peer]
+	if !exists {
+		log.Warn(fmt.Sprintf("Received headers message from unknown peer %s", sp))
+		return
+	}
+	hmsg.peer.UpdateLastGS(hmsg.headers.GS)
+
+	// Ignore the announcements from peers that aren't the sync peer if we
+	// are not current, the same way as for inv messages.
+	if hmsg.peer != b.syncPeer && !b.current() {
+		return
+	}
+	if b.headersFirstMode {
+		return
+	}
+
+	var request []*hash.Hash
+	for _, header := range hmsg.headers.Headers {
+		blockHash := header.BlockHash()
+		iv := message.NewInvVect(message.InvTypeBlock, &blockHash)
+		hmsg.peer.AddKnownInventory(iv)
+
+		haveInv, err := b.haveInventory(iv)
+		if err != nil {
+			log.Warn("Unexpected failure when checking for "+
+				"existing inventory during headers message "+
+				"processing", "error", err)
+			continue
+		}
+		if !haveInv {
+			request = append(request, &blockHash)
+			continue
+		}
+		request = append(request, b.chain.GetOrphanParents(&blockHash)...)
+	}
+
+	if err := b.requestFromPeer(hmsg.peer, request); err != nil {
+		log.Warn("Failed to request the announced blocks", "peer",
+			hmsg.peer, "error", err)
+	}
+}
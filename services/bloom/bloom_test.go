@@ -0,0 +1,350 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// TestFilterLarge ensures a maximum sized filter can be created.
+func TestFilterLarge(t *testing.T) {
+	f := NewFilter(100000000, 0, 0.01, message.BloomUpdateNone)
+	if len(f.MsgFilterLoad().Filter) > message.MaxFilterLoadFilterSize {
+		t.Errorf("TestFilterLarge test failed: %d > %d",
+			len(f.MsgFilterLoad().Filter), message.MaxFilterLoadFilterSize)
+	}
+}
+
+// TestFilterLoad ensures loading and unloading of a filter pass.
+func TestFilterLoad(t *testing.T) {
+	merkle := message.MsgFilterLoad{}
+
+	f := LoadFilter(&merkle)
+	if !f.IsLoaded() {
+		t.Errorf("TestFilterLoad IsLoaded test failed: want %v got %v",
+			true, !f.IsLoaded())
+		return
+	}
+	f.Unload()
+	if f.IsLoaded() {
+		t.Errorf("TestFilterLoad IsLoaded test failed: want %v got %v",
+			f.IsLoaded(), false)
+		return
+	}
+}
+
+// TestFilterFPRange checks that new filters made with out of range
+// false positive targets result in either max or min false positive rates.
+func TestFilterFPRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *Filter
+		want   *Filter
+	}{
+		{
+			name:   "fprates > 1 should be clipped at 1",
+			filter: NewFilter(1, 0, 20.9999999769, message.BloomUpdateAll),
+			want:   NewFilter(1, 0, 1.0, message.BloomUpdateAll),
+		},
+		{
+			name:   "fprates less than 1e-9 should be clipped at min",
+			filter: NewFilter(1, 0, 0, message.BloomUpdateAll),
+			want:   NewFilter(1, 0, 1e-9, message.BloomUpdateAll),
+		},
+		{
+			name:   "negative fprates should be clipped at min",
+			filter: NewFilter(1, 0, -1, message.BloomUpdateAll),
+			want:   NewFilter(1, 0, 1e-9, message.BloomUpdateAll),
+		},
+	}
+
+	for _, test := range tests {
+		var got, want bytes.Buffer
+		if err := test.filter.MsgFilterLoad().Encode(&got, 0); err != nil {
+			t.Errorf("%s: failed to encode filter: %v", test.name, err)
+			continue
+		}
+		if err := test.want.MsgFilterLoad().Encode(&want, 0); err != nil {
+			t.Errorf("%s: failed to encode filter: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("%s: got %x want %x", test.name, got.Bytes(),
+				want.Bytes())
+		}
+	}
+}
+
+// TestFilterInsert ensures inserting data into the filter causes that data
+// to be matched and the resulting serialized MsgFilterLoad is the expected
+// value.
+func TestFilterInsert(t *testing.T) {
+	var tests = []struct {
+		hex    string
+		insert bool
+	}{
+		{"99108ad8ed9bb6274d3980bab5a85c048f0950c8", true},
+		{"19108ad8ed9bb6274d3980bab5a85c048f0950c8", false},
+		{"b5a2c786d9ef4658287ced5914b37a1b4aa32eee", true},
+		{"b9300670b4c5366e95b2699e8b18bc75e5f729c5", true},
+	}
+
+	f := NewFilter(3, 0, 0.01, message.BloomUpdateAll)
+
+	for i, test := range tests {
+		data, err := hex.DecodeString(test.hex)
+		if err != nil {
+			t.Errorf("TestFilterInsert DecodeString failed: %v\n", err)
+			return
+		}
+		if test.insert {
+			f.Add(data)
+		}
+
+		result := f.Matches(data)
+		if test.insert != result {
+			t.Errorf("TestFilterInsert Matches test #%d failure: got %v want %v\n",
+				i, result, test.insert)
+			return
+		}
+	}
+
+	want, err := hex.DecodeString("03614e9b050000000000000001")
+	if err != nil {
+		t.Errorf("TestFilterInsert DecodeString failed: %v\n", err)
+		return
+	}
+
+	got := bytes.NewBuffer(nil)
+	err = f.MsgFilterLoad().Encode(got, 0)
+	if err != nil {
+		t.Errorf("TestFilterInsert Encode failed: %v\n", err)
+		return
+	}
+
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("TestFilterInsert failure: got %v want %v\n",
+			got.Bytes(), want)
+		return
+	}
+}
+
+// TestFilterInsertWithTweak ensures inserting data into the filter with a tweak
+// causes that data to be matched and the resulting serialized MsgFilterLoad is
+// the expected value.
+func TestFilterInsertWithTweak(t *testing.T) {
+	var tests = []struct {
+		hex    string
+		insert bool
+	}{
+		{"99108ad8ed9bb6274d3980bab5a85c048f0950c8", true},
+		{"19108ad8ed9bb6274d3980bab5a85c048f0950c8", false},
+		{"b5a2c786d9ef4658287ced5914b37a1b4aa32eee", true},
+		{"b9300670b4c5366e95b2699e8b18bc75e5f729c5", true},
+	}
+
+	f := NewFilter(3, 2147483649, 0.01, message.BloomUpdateAll)
+
+	for i, test := range tests {
+		data, err := hex.DecodeString(test.hex)
+		if err != nil {
+			t.Errorf("TestFilterInsertWithTweak DecodeString failed: %v\n", err)
+			return
+		}
+		if test.insert {
+			f.Add(data)
+		}
+
+		result := f.Matches(data)
+		if test.insert != result {
+			t.Errorf("TestFilterInsertWithTweak Matches test #%d failure: got %v want %v\n",
+				i, result, test.insert)
+			return
+		}
+	}
+
+	want, err := hex.DecodeString("03ce4299050000000100008001")
+	if err != nil {
+		t.Errorf("TestFilterInsertWithTweak DecodeString failed: %v\n", err)
+		return
+	}
+	got := bytes.NewBuffer(nil)
+	err = f.MsgFilterLoad().Encode(got, 0)
+	if err != nil {
+		t.Errorf("TestFilterInsertWithTweak Encode failed: %v\n", err)
+		return
+	}
+
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("TestFilterInsertWithTweak failure: got %v want %v\n",
+			got.Bytes(), want)
+		return
+	}
+}
+
+// TestFilterEmpty ensures a filter without any bits, as a remote peer is able
+// to load one, matches nothing and ignores the data added to it instead of
+// dividing by zero.
+func TestFilterEmpty(t *testing.T) {
+	f := LoadFilter(message.NewMsgFilterLoad(nil, 10, 0,
+		message.BloomUpdateAll))
+
+	data := []byte{0x01, 0x02, 0x03}
+	f.Add(data)
+	if f.Matches(data) {
+		t.Errorf("TestFilterEmpty: empty filter matches %x", data)
+	}
+	f.AddOutPoint(types.NewOutPoint(&hash.Hash{0x01}, 0))
+	if f.MatchesOutPoint(types.NewOutPoint(&hash.Hash{0x01}, 0)) {
+		t.Errorf("TestFilterEmpty: empty filter matches outpoint")
+	}
+	if f.MatchTxAndUpdate(testTx(0, []byte{0x01}, 0)) {
+		t.Errorf("TestFilterEmpty: empty filter matches transaction")
+	}
+
+	// The empty filter with hash functions is rejected on the wire.
+	var buf bytes.Buffer
+	if err := f.MsgFilterLoad().Encode(&buf, 0); err != nil {
+		t.Fatalf("TestFilterEmpty: Encode failed: %v", err)
+	}
+	var msg message.MsgFilterLoad
+	if err := msg.Decode(&buf, 0); err == nil {
+		t.Errorf("TestFilterEmpty: Decode accepted an empty filter " +
+			"with hash functions")
+	}
+}
+
+// TestFilterReload ensures the filter is replaced by Reload.
+func TestFilterReload(t *testing.T) {
+	f := NewFilter(10, 0, 0.000001, message.BloomUpdateAll)
+
+	bFilter := LoadFilter(f.MsgFilterLoad())
+	if bFilter.MsgFilterLoad() == nil {
+		t.Errorf("TestFilterReload LoadFilter test failed")
+		return
+	}
+	bFilter.Reload(nil)
+
+	if bFilter.MsgFilterLoad() != nil {
+		t.Errorf("TestFilterReload Reload test failed")
+	}
+}
+
+// testPkScript returns a pay-to-pubkey-hash script paying to the passed 20
+// byte hash.
+func testPkScript(pkHash []byte) []byte {
+	script := []byte{0x76, 0xa9, 0x14}
+	script = append(script, pkHash...)
+	return append(script, 0x88, 0xac)
+}
+
+// testTx returns a transaction spending the passed output of a previous
+// transaction with a signature script pushing the passed data and paying to
+// a pay-to-pubkey-hash script derived from the passed seed.
+func testTx(prevSeed byte, sigData []byte, seed byte) *types.Tx {
+	prevHash := hash.HashH([]byte{prevSeed})
+	sigScript := append([]byte{byte(len(sigData))}, sigData...)
+
+	pkHash := make([]byte, 20)
+	for i := range pkHash {
+		pkHash[i] = seed
+	}
+
+	tx := types.NewTransaction()
+	tx.AddTxIn(types.NewTxInput(types.NewOutPoint(&prevHash, 1), sigScript))
+	tx.AddTxOut(types.NewTxOutput(1e8, testPkScript(pkHash)))
+	return types.NewTx(tx)
+}
+
+// TestFilterMatchTx ensures transactions are matched by their hash, the data of
+// their scripts, and the outpoints they spend, and the filter is updated with
+// the outputs of the matched transactions according to its flags.
+func TestFilterMatchTx(t *testing.T) {
+	tx := testTx(1, []byte{0xaa, 0xbb, 0xcc}, 0x11)
+	prevHash := hash.HashH([]byte{1})
+	pkHash := bytes.Repeat([]byte{0x11}, 20)
+	outpoint := types.NewOutPoint(tx.Hash(), 0)
+
+	tests := []struct {
+		name        string
+		flags       message.BloomUpdateType
+		add         func(f *Filter)
+		match       bool
+		addOutpoint bool
+	}{
+		{
+			name:  "transaction hash",
+			flags: message.BloomUpdateAll,
+			add:   func(f *Filter) { f.AddHash(tx.Hash()) },
+			match: true,
+		},
+		{
+			name:        "output data, update all",
+			flags:       message.BloomUpdateAll,
+			add:         func(f *Filter) { f.Add(pkHash) },
+			match:       true,
+			addOutpoint: true,
+		},
+		{
+			name:  "output data, update none",
+			flags: message.BloomUpdateNone,
+			add:   func(f *Filter) { f.Add(pkHash) },
+			match: true,
+		},
+		{
+			name:  "output data, update p2pubkey only",
+			flags: message.BloomUpdateP2PubkeyOnly,
+			add:   func(f *Filter) { f.Add(pkHash) },
+			match: true,
+		},
+		{
+			name:  "spent outpoint",
+			flags: message.BloomUpdateAll,
+			add: func(f *Filter) {
+				f.AddOutPoint(types.NewOutPoint(&prevHash, 1))
+			},
+			match: true,
+		},
+		{
+			name:  "signature script data",
+			flags: message.BloomUpdateAll,
+			add:   func(f *Filter) { f.Add([]byte{0xaa, 0xbb, 0xcc}) },
+			match: true,
+		},
+		{
+			name:  "other outpoint of the spent transaction",
+			flags: message.BloomUpdateAll,
+			add: func(f *Filter) {
+				f.AddOutPoint(types.NewOutPoint(&prevHash, 0))
+			},
+		},
+		{
+			name:  "unrelated data",
+			flags: message.BloomUpdateAll,
+			add:   func(f *Filter) { f.Add([]byte{0xdd, 0xee, 0xff}) },
+		},
+	}
+
+	for _, test := range tests {
+		f := NewFilter(10, 0, 0.000001, test.flags)
+		test.add(f)
+		if got := f.MatchTxAndUpdate(tx); got != test.match {
+			t.Errorf("%s: MatchTxAndUpdate got %v want %v", test.name,
+				got, test.match)
+			continue
+		}
+		if got := f.MatchesOutPoint(outpoint); got != test.addOutpoint {
+			t.Errorf("%s: MatchesOutPoint got %v want %v", test.name,
+				got, test.addOutpoint)
+		}
+	}
+}
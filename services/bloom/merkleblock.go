@@ -0,0 +1,255 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// merkleBlock is used to house intermediate information needed to generate a
+// message.MsgMerkleBlock according to a filter.
+type merkleBlock struct {
+	numTx       uint32
+	allHashes   []*hash.Hash
+	finalHashes []*hash.Hash
+	matchedBits []byte
+	bits        []byte
+}
+
+// calcTreeWidth calculates and returns the number of nodes (width) of a
+// merkle tree at the given depth-first height.
+func (m *merkleBlock) calcTreeWidth(height uint32) uint32 {
+	return (m.numTx + (1 << height) - 1) >> height
+}
+
+// calcHash returns the hash for a sub-tree given a depth-first height and
+// node position.
+func (m *merkleBlock) calcHash(height, pos uint32) *hash.Hash {
+	if height == 0 {
+		return m.allHashes[pos]
+	}
+
+	var right *hash.Hash
+	left := m.calcHash(height-1, pos*2)
+	if pos*2+1 < m.calcTreeWidth(height-1) {
+		right = m.calcHash(height-1, pos*2+1)
+	} else {
+		right = left
+	}
+	return hashMerkleBranches(left, right)
+}
+
+// traverseAndBuild builds a partial merkle tree using a recursive depth-first
+// approach.  As it calculates the hashes, it also saves whether or not each
+// node is a parent node and a list of final hashes to be included in the
+// merkle block.
+func (m *merkleBlock) traverseAndBuild(height, pos uint32) {
+	// Determine whether this node is a parent of a matched node.
+	var isParent byte
+	for i := pos << height; i < (pos+1)<<height && i < m.numTx; i++ {
+		isParent |= m.matchedBits[i]
+	}
+	m.bits = append(m.bits, isParent)
+
+	// When the node is a leaf node or not a parent of a matched node,
+	// append the hash to the list that will be part of the final merkle
+	// block.
+	if height == 0 || isParent == 0x00 {
+		m.finalHashes = append(m.finalHashes, m.calcHash(height, pos))
+		return
+	}
+
+	// At this point, the node is an internal node and it is the parent of
+	// of an included leaf node.
+
+	// Descend into the left child and process its sub-tree.
+	m.traverseAndBuild(height-1, pos*2)
+
+	// Descend into the right child and process its sub-tree if
+	// there is one.
+	if pos*2+1 < m.calcTreeWidth(height-1) {
+		m.traverseAndBuild(height-1, pos*2+1)
+	}
+}
+
+// hashMerkleBranches returns the hash of the concatenation of the passed left
+// and right nodes the way the transaction merkle root of the blocks is built.
+func hashMerkleBranches(left *hash.Hash, right *hash.Hash) *hash.Hash {
+	var h [hash.HashSize * 2]byte
+	copy(h[:hash.HashSize], left[:])
+	copy(h[hash.HashSize:], right[:])
+
+	newHash := hash.DoubleHashH(h[:])
+	return &newHash
+}
+
+// NewMerkleBlock returns a new *message.MsgMerkleBlock and an array of the
+// matched transaction index numbers based on the passed block and filter.
+func NewMerkleBlock(block *types.SerializedBlock, filter *Filter) (*message.MsgMerkleBlock, []uint32) {
+	numTx := uint32(len(block.Transactions()))
+	mBlock := merkleBlock{
+		numTx:       numTx,
+		allHashes:   make([]*hash.Hash, 0, numTx),
+		matchedBits: make([]byte, 0, numTx),
+	}
+
+	// Find and keep track of any transactions that match the filter.
+	var matchedIndices []uint32
+	for txIndex, tx := range block.Transactions() {
+		if filter.MatchTxAndUpdate(tx) {
+			mBlock.matchedBits = append(mBlock.matchedBits, 0x01)
+			matchedIndices = append(matchedIndices, uint32(txIndex))
+		} else {
+			mBlock.matchedBits = append(mBlock.matchedBits, 0x00)
+		}
+		mBlock.allHashes = append(mBlock.allHashes, tx.Hash())
+	}
+
+	// Calculate the number of merkle branches (height) in the tree.
+	height := uint32(0)
+	for mBlock.calcTreeWidth(height) > 1 {
+		height++
+	}
+
+	// Build the depth-first partial merkle tree.
+	mBlock.traverseAndBuild(height, 0)
+
+	// Create and return the merkle block.
+	msgMerkleBlock := message.MsgMerkleBlock{
+		Header:       block.Block().Header,
+		Parents:      block.Block().Parents,
+		Transactions: mBlock.numTx,
+		Hashes:       make([]*hash.Hash, 0, len(mBlock.finalHashes)),
+		Flags:        make([]byte, (len(mBlock.bits)+7)/8),
+	}
+	for _, h := range mBlock.finalHashes {
+		msgMerkleBlock.AddTxHash(h)
+	}
+	for i := uint32(0); i < uint32(len(mBlock.bits)); i++ {
+		msgMerkleBlock.Flags[i/8] |= mBlock.bits[i] << (i % 8)
+	}
+	return &msgMerkleBlock, matchedIndices
+}
+
+// partialMerkleTree is used to house intermediate information needed to
+// extract the matched transactions from a message.MsgMerkleBlock.
+type partialMerkleTree struct {
+	numTx      uint32
+	hashes     []*hash.Hash
+	bits       []byte
+	bitsUsed   int
+	hashesUsed int
+	matches    []*hash.Hash
+	bad        bool
+}
+
+// calcTreeWidth calculates and returns the number of nodes (width) of a merkle
+// tree at the given depth-first height.
+func (t *partialMerkleTree) calcTreeWidth(height uint32) uint32 {
+	return (t.numTx + (1 << height) - 1) >> height
+}
+
+// traverseAndExtract rebuilds the merkle tree from the partial merkle tree
+// using a recursive depth-first approach the same way traverseAndBuild builds
+// it.  It returns the hash of the node at the given depth-first height and
+// position, and saves the hashes of the matched transactions.
+func (t *partialMerkleTree) traverseAndExtract(height, pos uint32) *hash.Hash {
+	if t.bitsUsed >= len(t.bits) {
+		// Overflowed the bits array.
+		t.bad = true
+		return &hash.Hash{}
+	}
+	isParent := t.bits[t.bitsUsed]
+	t.bitsUsed++
+
+	// When the node is a leaf node or not a parent of a matched node, its
+	// hash is the next one of the list.
+	if height == 0 || isParent == 0x00 {
+		if t.hashesUsed >= len(t.hashes) {
+			// Overflowed the hashes array.
+			t.bad = true
+			return &hash.Hash{}
+		}
+		h := t.hashes[t.hashesUsed]
+		t.hashesUsed++
+		if height == 0 && isParent != 0x00 {
+			t.matches = append(t.matches, h)
+		}
+		return h
+	}
+
+	// Otherwise, descend into the children to calculate the hash.
+	left := t.traverseAndExtract(height-1, pos*2)
+	right := left
+	if pos*2+1 < t.calcTreeWidth(height-1) {
+		right = t.traverseAndExtract(height-1, pos*2+1)
+
+		// The left and right branches must never be identical since
+		// that would allow to hide duplicate transactions.
+		if right.IsEqual(left) {
+			t.bad = true
+		}
+	}
+	return hashMerkleBranches(left, right)
+}
+
+// ExtractMatches returns the hashes of the matched transactions of the passed
+// merkle block after ensuring that its partial merkle tree is well formed and
+// commits to the transaction merkle root of its header.
+func ExtractMatches(msg *message.MsgMerkleBlock) ([]*hash.Hash, error) {
+	numHashes := uint32(len(msg.Hashes))
+	if msg.Transactions == 0 {
+		return nil, errors.New("merkle block has no transactions")
+	}
+	if numHashes > msg.Transactions {
+		return nil, fmt.Errorf("merkle block has more hashes (%d) than "+
+			"transactions (%d)", numHashes, msg.Transactions)
+	}
+	if uint32(len(msg.Flags))*8 < numHashes {
+		return nil, fmt.Errorf("merkle block has fewer flag bits (%d) "+
+			"than hashes (%d)", len(msg.Flags)*8, numHashes)
+	}
+
+	t := partialMerkleTree{
+		numTx:  msg.Transactions,
+		hashes: msg.Hashes,
+		bits:   make([]byte, len(msg.Flags)*8),
+	}
+	for i := range t.bits {
+		t.bits[i] = (msg.Flags[i/8] >> uint(i%8)) & 0x01
+	}
+
+	// Calculate the number of merkle branches (height) in the tree.
+	height := uint32(0)
+	for t.calcTreeWidth(height) > 1 {
+		height++
+	}
+
+	root := t.traverseAndExtract(height, 0)
+	if t.bad {
+		return nil, errors.New("merkle block has a malformed partial " +
+			"merkle tree")
+	}
+
+	// All the flag bytes and hashes must have been consumed.
+	if (t.bitsUsed+7)/8 != len(msg.Flags) {
+		return nil, errors.New("merkle block has unused flag bits")
+	}
+	if t.hashesUsed != len(msg.Hashes) {
+		return nil, errors.New("merkle block has unused hashes")
+	}
+
+	if !root.IsEqual(&msg.Header.TxRoot) {
+		return nil, fmt.Errorf("merkle block root %v does not match the "+
+			"transaction root %v of the header", root,
+			&msg.Header.TxRoot)
+	}
+	return t.matches, nil
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/merkle"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// testMerkleBlock returns a block with the passed number of transactions and
+// the transaction root committing to them.
+func testMerkleBlock(numTx int) *types.SerializedBlock {
+	block := types.Block{
+		Header: types.BlockHeader{
+			Version:   1,
+			Timestamp: time.Unix(1568000000, 0),
+		},
+		Parents: []*hash.Hash{{0x01}},
+	}
+	var txns []*types.Tx
+	for i := 0; i < numTx; i++ {
+		tx := testTx(byte(i), []byte{byte(i)}, byte(i))
+		block.Transactions = append(block.Transactions, tx.Tx)
+		txns = append(txns, tx)
+	}
+	merkles := merkle.BuildMerkleTreeStore(txns, false)
+	block.Header.TxRoot = *merkles[len(merkles)-1]
+	return types.NewBlock(&block)
+}
+
+// TestMerkleBlock ensures the merkle block of a block contains the matched
+// transactions and commits to the transaction root of the block.
+func TestMerkleBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		numTx   int
+		matched []uint32
+	}{
+		{"single transaction", 1, []uint32{0}},
+		{"no match", 5, nil},
+		{"one match", 5, []uint32{3}},
+		{"two matches", 7, []uint32{1, 4}},
+		{"all matches", 4, []uint32{0, 1, 2, 3}},
+	}
+
+	for _, test := range tests {
+		block := testMerkleBlock(test.numTx)
+		f := NewFilter(10, 0, 0.000001, message.BloomUpdateNone)
+		for _, i := range test.matched {
+			f.AddHash(block.Transactions()[i].Hash())
+		}
+
+		mBlock, matched := NewMerkleBlock(block, f)
+		if len(matched) != len(test.matched) {
+			t.Errorf("%s: got matched indices %v want %v", test.name,
+				matched, test.matched)
+			continue
+		}
+		for i := range matched {
+			if matched[i] != test.matched[i] {
+				t.Errorf("%s: got matched indices %v want %v",
+					test.name, matched, test.matched)
+				break
+			}
+		}
+
+		hashes, err := ExtractMatches(mBlock)
+		if err != nil {
+			t.Errorf("%s: ExtractMatches: %v", test.name, err)
+			continue
+		}
+		if len(hashes) != len(test.matched) {
+			t.Errorf("%s: got %d extracted hashes want %d", test.name,
+				len(hashes), len(test.matched))
+			continue
+		}
+		for i, h := range hashes {
+			want := block.Transactions()[test.matched[i]].Hash()
+			if !h.IsEqual(want) {
+				t.Errorf("%s: extracted hash #%d is %v want %v",
+					test.name, i, h, want)
+			}
+		}
+	}
+}
+
+// TestExtractMatchesInvalid ensures a merkle block which does not commit to
+// the transaction root of its header or is malformed is rejected.
+func TestExtractMatchesInvalid(t *testing.T) {
+	block := testMerkleBlock(5)
+	f := NewFilter(10, 0, 0.000001, message.BloomUpdateNone)
+	f.AddHash(block.Transactions()[2].Hash())
+
+	tests := []struct {
+		name   string
+		tamper func(msg *message.MsgMerkleBlock)
+	}{
+		{"tampered hash", func(msg *message.MsgMerkleBlock) {
+			msg.Hashes[0] = &hash.Hash{0xff}
+		}},
+		{"tampered root", func(msg *message.MsgMerkleBlock) {
+			msg.Header.TxRoot = hash.Hash{0xff}
+		}},
+		{"missing hash", func(msg *message.MsgMerkleBlock) {
+			msg.Hashes = msg.Hashes[:len(msg.Hashes)-1]
+		}},
+		{"extra hash", func(msg *message.MsgMerkleBlock) {
+			msg.Hashes = append(msg.Hashes, &hash.Hash{0xff})
+		}},
+		{"no transactions", func(msg *message.MsgMerkleBlock) {
+			msg.Transactions = 0
+		}},
+	}
+
+	for _, test := range tests {
+		mBlock, _ := NewMerkleBlock(block, f)
+		if _, err := ExtractMatches(mBlock); err != nil {
+			t.Fatalf("%s: ExtractMatches of the untampered block: %v",
+				test.name, err)
+		}
+		test.tamper(mBlock)
+		if _, err := ExtractMatches(mBlock); err == nil {
+			t.Errorf("%s: ExtractMatches accepted the merkle block",
+				test.name)
+		}
+	}
+}
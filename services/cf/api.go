@@ -0,0 +1,53 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package cf
+
+import (
+	"encoding/hex"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/rpc"
+)
+
+func (idx *CfIndex) API() rpc.API {
+	return rpc.API{
+		NameSpace: rpc.DefaultServiceNameSpace,
+		Service:   NewPublicCFAPI(idx),
+		Public:    true,
+	}
+}
+
+type PublicCFAPI struct {
+	cfIndex *CfIndex
+}
+
+func NewPublicCFAPI(idx *CfIndex) *PublicCFAPI {
+	return &PublicCFAPI{idx}
+}
+
+// Return the hex encoded basic filter of the block with the hash
+func (api *PublicCFAPI) GetCFilter(h hash.Hash) (interface{}, error) {
+	filter, err := api.cfIndex.FilterByBlockHash(&h)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to load filter")
+	}
+	if filter == nil {
+		return nil, rpc.RpcInvalidError("no filter for block %v", h)
+	}
+	return hex.EncodeToString(filter), nil
+}
+
+// Return the basic filter header of the block with the hash, which commits to
+// the filters of the block and of its chain of main parents
+func (api *PublicCFAPI) GetCFilterHeader(h hash.Hash) (interface{}, error) {
+	header, err := api.cfIndex.FilterHeaderByBlockHash(&h)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(),
+			"Failed to load filter header")
+	}
+	if header == nil {
+		return nil, rpc.RpcInvalidError("no filter header for block %v", h)
+	}
+	var headerHash hash.Hash
+	copy(headerHash[:], header)
+	return headerHash.String(), nil
+}
@@ -1 +1,76 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
 package cf
+
+import (
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+)
+
+const (
+	// DefaultP is the collision probability of the basic filters as a
+	// negative power of 2.
+	DefaultP = 19
+
+	// DefaultM is the inverse of the false positive rate of the basic
+	// filters.
+	DefaultM = 784931
+)
+
+// DeriveKey returns the key of the filter of the block with the passed hash,
+// which is the first KeySize bytes of the block hash.
+func DeriveKey(blockHash *hash.Hash) [KeySize]byte {
+	var key [KeySize]byte
+	copy(key[:], blockHash[:KeySize])
+	return key
+}
+
+// BuildBasicFilter builds the basic filter of the passed block.  The filter
+// contains the public key scripts of the outputs created by the block, except
+// the data carrier outputs, and the passed public key scripts of the outputs
+// spent by the block.
+func BuildBasicFilter(block *types.Block, prevOutScripts [][]byte) (*Filter, error) {
+	blockHash := block.BlockHash()
+	data := make([][]byte, 0, len(prevOutScripts))
+	seen := make(map[string]struct{})
+	add := func(script []byte) {
+		if len(script) == 0 {
+			return
+		}
+		if _, ok := seen[string(script)]; ok {
+			return
+		}
+		seen[string(script)] = struct{}{}
+		data = append(data, script)
+	}
+
+	for _, tx := range block.Transactions {
+		for _, txOut := range tx.TxOut {
+			class := txscript.GetScriptClass(
+				txscript.DefaultScriptVersion, txOut.PkScript)
+			if class == txscript.NullDataTy {
+				continue
+			}
+			add(txOut.PkScript)
+		}
+	}
+	for _, script := range prevOutScripts {
+		add(script)
+	}
+
+	return BuildGCSFilter(DefaultP, DefaultM, DeriveKey(&blockHash), data)
+}
+
+// MakeHeaderForFilter returns the filter header which commits to the passed
+// filter and the header of the filter of the main parent of the block.  The
+// header of the filter of the genesis block commits to the zero hash.
+func MakeHeaderForFilter(filter *Filter, prevHeader *hash.Hash) hash.Hash {
+	filterHash := filter.Hash()
+	data := make([]byte, 0, 2*hash.HashSize)
+	data = append(data, filterHash[:]...)
+	data = append(data, prevHeader[:]...)
+	return hash.DoubleHashH(data)
+}
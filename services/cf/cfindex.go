@@ -0,0 +1,245 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package cf
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/services/index"
+)
+
+const (
+	// cfIndexName is the human-readable name for the index.
+	cfIndexName = "committed filter index"
+)
+
+var (
+	// cfIndexParentBucketKey is the key of the committed filter index and
+	// the parent db bucket of the buckets used to house it.
+	cfIndexParentBucketKey = []byte("cfindexparentbucket")
+
+	// cfBucketKey is the name of the db bucket used to house the mapping
+	// of block hashes to the serialized basic filters.
+	cfBucketKey = []byte("cf0byhashidx")
+
+	// cfHashBucketKey is the name of the db bucket used to house the
+	// mapping of block hashes to the hashes of the basic filters.
+	cfHashBucketKey = []byte("cf0hashbyhashidx")
+
+	// cfHeaderBucketKey is the name of the db bucket used to house the
+	// mapping of block hashes to the basic filter headers.
+	cfHeaderBucketKey = []byte("cf0headerbyhashidx")
+
+	// cfBucketKeys are the names of the buckets of the index.
+	cfBucketKeys = [][]byte{cfBucketKey, cfHashBucketKey, cfHeaderBucketKey}
+)
+
+// CfIndex implements a committed filter (cf) by hash index.  The index houses
+// the basic filter of every block along with its filter header, which commits
+// to the filter header of the main parent of the block.  That way the filter
+// header of a block only depends on its past and not on the order of the DAG.
+type CfIndex struct {
+	db    database.DB
+	chain *blockchain.BlockChain
+}
+
+// Ensure the CfIndex type implements the Indexer, NeedsInputser and
+// NeedsChainer interfaces.
+var _ index.Indexer = (*CfIndex)(nil)
+var _ index.NeedsInputser = (*CfIndex)(nil)
+var _ index.NeedsChainer = (*CfIndex)(nil)
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index.
+//
+// This implements the NeedsInputser interface.
+func (idx *CfIndex) NeedsInputs() bool {
+	return true
+}
+
+// SetChain sets the chain the main parents of the blocks are looked up in.
+//
+// This implements the NeedsChainer interface.
+func (idx *CfIndex) SetChain(chain *blockchain.BlockChain) {
+	idx.chain = chain
+}
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) Init() error {
+	// Nothing to do.
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) Key() []byte {
+	return cfIndexParentBucketKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) Name() string {
+	return cfIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs to be
+// created for the first time.  It creates the parent bucket of the index and
+// the buckets within it.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) Create(dbTx database.Tx) error {
+	cfIndexParentBucket, err := dbTx.Metadata().CreateBucket(
+		cfIndexParentBucketKey)
+	if err != nil {
+		return err
+	}
+	for _, bucketName := range cfBucketKeys {
+		_, err = cfIndexParentBucket.CreateBucket(bucketName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the DAG.  This indexer builds the basic filter of the block and
+// its filter header.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	prevScripts := make([][]byte, 0, len(stxos))
+	for _, stxo := range stxos {
+		prevScripts = append(prevScripts, stxo.PkScript)
+	}
+	filter, err := BuildBasicFilter(block.Block(), prevScripts)
+	if err != nil {
+		return err
+	}
+
+	// The header of the filter commits to the filter header of the main
+	// parent of the block, which is always connected before it.
+	parent := dbTx.Metadata().Bucket(cfIndexParentBucketKey)
+	var prevHeader hash.Hash
+	ib := idx.chain.BlockDAG().GetBlock(block.Hash())
+	if ib == nil {
+		return fmt.Errorf("block %v is not in the DAG", block.Hash())
+	}
+	if mainParent := ib.GetMainParent(); mainParent != nil {
+		serialized := parent.Bucket(cfHeaderBucketKey).Get(mainParent[:])
+		if len(serialized) != hash.HashSize {
+			return database.Error{
+				ErrorCode: database.ErrCorruption,
+				Description: fmt.Sprintf("missing filter header "+
+					"of main parent %v", mainParent),
+			}
+		}
+		copy(prevHeader[:], serialized)
+	}
+	header := MakeHeaderForFilter(filter, &prevHeader)
+	filterHash := filter.Hash()
+
+	h := block.Hash()
+	err = parent.Bucket(cfBucketKey).Put(h[:], filter.NBytes())
+	if err != nil {
+		return err
+	}
+	err = parent.Bucket(cfHashBucketKey).Put(h[:], filterHash[:])
+	if err != nil {
+		return err
+	}
+	return parent.Bucket(cfHeaderBucketKey).Put(h[:], header[:])
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the DAG.  This indexer removes the filter of the block and
+// its filter header.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	parent := dbTx.Metadata().Bucket(cfIndexParentBucketKey)
+	h := block.Hash()
+	for _, bucketName := range cfBucketKeys {
+		err := parent.Bucket(bucketName).Delete(h[:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryByBlockHash returns the entry of the passed bucket of the index for the
+// block with the passed hash, or nil when there is none.
+func (idx *CfIndex) entryByBlockHash(bucketName []byte, h *hash.Hash) ([]byte, error) {
+	var entry []byte
+	err := idx.db.View(func(dbTx database.Tx) error {
+		parent := dbTx.Metadata().Bucket(cfIndexParentBucketKey)
+		serialized := parent.Bucket(bucketName).Get(h[:])
+		if serialized != nil {
+			entry = make([]byte, len(serialized))
+			copy(entry, serialized)
+		}
+		return nil
+	})
+	return entry, err
+}
+
+// FilterByBlockHash returns the serialized basic filter of the block with the
+// passed hash, or nil when there is none.
+//
+// This function is safe for concurrent access.
+func (idx *CfIndex) FilterByBlockHash(h *hash.Hash) ([]byte, error) {
+	return idx.entryByBlockHash(cfBucketKey, h)
+}
+
+// FilterHeaderByBlockHash returns the basic filter header of the block with the
+// passed hash, or nil when there is none.
+//
+// This function is safe for concurrent access.
+func (idx *CfIndex) FilterHeaderByBlockHash(h *hash.Hash) ([]byte, error) {
+	return idx.entryByBlockHash(cfHeaderBucketKey, h)
+}
+
+// FilterHashesByBlockHashes returns the hashes of the basic filters of the
+// blocks with the passed hashes.  The entries of the blocks without a filter
+// are nil.
+//
+// This function is safe for concurrent access.
+func (idx *CfIndex) FilterHashesByBlockHashes(hashes []*hash.Hash) ([][]byte, error) {
+	filterHashes := make([][]byte, len(hashes))
+	err := idx.db.View(func(dbTx database.Tx) error {
+		parent := dbTx.Metadata().Bucket(cfIndexParentBucketKey)
+		bucket := parent.Bucket(cfHashBucketKey)
+		for i, h := range hashes {
+			serialized := bucket.Get(h[:])
+			if serialized != nil {
+				filterHashes[i] = make([]byte, len(serialized))
+				copy(filterHashes[i], serialized)
+			}
+		}
+		return nil
+	})
+	return filterHashes, err
+}
+
+// NewCfIndex returns a new instance of an indexer that is used to create a
+// mapping of the hashes of all blocks in the DAG to their basic filters and
+// filter headers.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewCfIndex(db database.DB) *CfIndex {
+	return &CfIndex{db: db}
+}
@@ -0,0 +1,408 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2016-2017 The btcsuite developers
+// Copyright (c) 2016-2017 The Lightning Network Developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package cf implements the compact block filters which allow light clients
+// to privately find the blocks which are relevant to them.  The filters are
+// Golomb-coded sets as specified by BIP158.
+package cf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"math/bits"
+	"sort"
+)
+
+// KeySize is the size of the byte array required for key material for the
+// SipHash keyed hash function.
+const KeySize = 16
+
+var (
+	// ErrNTooBig signifies that the filter can't handle N items.
+	ErrNTooBig = errors.New("N is too big to fit in uint32")
+
+	// ErrPTooBig signifies that the filter can't handle `1/2**P`
+	// collision probability.
+	ErrPTooBig = errors.New("P is too big to fit in uint32")
+
+	// errBitStreamEnd signifies that the end of the bit stream of a filter
+	// was reached before all of its entries were read.
+	errBitStreamEnd = errors.New("unexpected end of filter data")
+)
+
+// Filter describes an immutable filter that can be built from a set of data
+// elements, serialized, deserialized, and queried in a thread-safe manner.
+// The serialized form is compressed as a Golomb Coded Set (GCS), but does not
+// include N or P to allow the user to encode the metadata separately if
+// necessary.  The hash function used is SipHash, a keyed function; the key
+// used in building the filter is required in order to match filter values and
+// is not included in the serialized form.
+type Filter struct {
+	n          uint32
+	p          uint8
+	modulusNM  uint64
+	filterData []byte
+}
+
+// BuildGCSFilter builds a new GCS filter with the collision probability of
+// `1/(2**P)`, key `key`, and including every `[]byte` in `data` as a member of
+// the set.
+func BuildGCSFilter(P uint8, M uint64, key [KeySize]byte, data [][]byte) (*Filter, error) {
+	// Some initial parameter checks: make sure we have data from which to
+	// build the filter, and make sure our parameters will fit the hash
+	// function we're using.
+	if uint64(len(data)) >= (1 << 32) {
+		return nil, ErrNTooBig
+	}
+	if P > 32 {
+		return nil, ErrPTooBig
+	}
+
+	// Create the filter object and insert metadata.
+	f := Filter{
+		n: uint32(len(data)),
+		p: P,
+	}
+
+	// An empty filter has no data.
+	if f.n == 0 {
+		return &f, nil
+	}
+	f.modulusNM = uint64(f.n) * M
+
+	// Build the filter values by hashing each data item into the range
+	// [0, N * M) and sorting the results.
+	k0, k1 := sipKeys(key)
+	values := make([]uint64, 0, len(data))
+	for _, d := range data {
+		v := fastReduction(sipHash(k0, k1, d), f.modulusNM)
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	// Write the sorted list of values into the filter bitstream, compressing
+	// the differences between consecutive values with Golomb-Rice coding.
+	var w bitWriter
+	var lastValue uint64
+	for _, v := range values {
+		w.writeGolomb(v-lastValue, f.p)
+		lastValue = v
+	}
+	f.filterData = w.bytes
+
+	return &f, nil
+}
+
+// FromBytes deserializes a GCS filter from a known N, P, and serialized filter
+// as returned by Bytes().
+func FromBytes(N uint32, P uint8, M uint64, d []byte) (*Filter, error) {
+	// Basic sanity check.
+	if P > 32 {
+		return nil, ErrPTooBig
+	}
+
+	f := &Filter{
+		n:         N,
+		p:         P,
+		modulusNM: uint64(N) * M,
+	}
+	f.filterData = make([]byte, len(d))
+	copy(f.filterData, d)
+
+	return f, nil
+}
+
+// FromNBytes deserializes a GCS filter from a known P, and serialized N and
+// filter as returned by NBytes().
+func FromNBytes(P uint8, M uint64, d []byte) (*Filter, error) {
+	buffer := bytes.NewBuffer(d)
+	N, err := s.ReadVarInt(buffer, 0)
+	if err != nil {
+		return nil, err
+	}
+	if N >= (1 << 32) {
+		return nil, ErrNTooBig
+	}
+	return FromBytes(uint32(N), P, M, buffer.Bytes())
+}
+
+// Bytes returns the serialized format of the GCS filter, which does not
+// include N or P (returned by separate methods) or the key used by SipHash.
+func (f *Filter) Bytes() []byte {
+	filterData := make([]byte, len(f.filterData))
+	copy(filterData, f.filterData)
+	return filterData
+}
+
+// NBytes returns the serialized format of the GCS filter with N, which does
+// not include P (returned by a separate method) or the key used by SipHash.
+func (f *Filter) NBytes() []byte {
+	var buffer bytes.Buffer
+	buffer.Grow(s.VarIntSerializeSize(uint64(f.n)) + len(f.filterData))
+	_ = s.WriteVarInt(&buffer, 0, uint64(f.n))
+	buffer.Write(f.filterData)
+	return buffer.Bytes()
+}
+
+// P returns the filter's collision probability as a negative power of 2 (that
+// is, a collision probability of `1/2**20` is represented as 20).
+func (f *Filter) P() uint8 {
+	return f.p
+}
+
+// N returns the size of the data set used to build the filter.
+func (f *Filter) N() uint32 {
+	return f.n
+}
+
+// Hash returns the hash of the serialized filter with N which the filter
+// headers commit to.
+func (f *Filter) Hash() hash.Hash {
+	return hash.DoubleHashH(f.NBytes())
+}
+
+// Match checks whether a []byte value is likely (within collision probability)
+// to be a member of the set represented by the filter.
+func (f *Filter) Match(key [KeySize]byte, data []byte) (bool, error) {
+	// An empty filter matches nothing.
+	if f.n == 0 {
+		return false, nil
+	}
+
+	// Hash our search term with the same parameters as the filter.
+	k0, k1 := sipKeys(key)
+	term := fastReduction(sipHash(k0, k1, data), f.modulusNM)
+
+	// Go through the search filter and look for the desired value.
+	r := bitReader{data: f.filterData}
+	var value uint64
+	for i := uint32(0); i < f.n; i++ {
+		delta, err := r.readGolomb(f.p)
+		if err != nil {
+			return false, err
+		}
+		value += delta
+
+		switch {
+		case value == term:
+			return true, nil
+		case value > term:
+			return false, nil
+		}
+	}
+
+	// The term is larger than every value in the filter.
+	return false, nil
+}
+
+// MatchAny checks whether any []byte value is likely (within collision
+// probability) to be a member of the set represented by the filter faster than
+// calling Match() for each value individually.
+func (f *Filter) MatchAny(key [KeySize]byte, data [][]byte) (bool, error) {
+	// An empty filter or an empty set of terms matches nothing.
+	if f.n == 0 || len(data) == 0 {
+		return false, nil
+	}
+
+	// Create and sort the hashes of the search terms.
+	k0, k1 := sipKeys(key)
+	values := make([]uint64, 0, len(data))
+	for _, d := range data {
+		v := fastReduction(sipHash(k0, k1, d), f.modulusNM)
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	// Zip down the filter and the sorted terms looking for a value which
+	// is in both.
+	r := bitReader{data: f.filterData}
+	var value uint64
+	next := 0
+	for i := uint32(0); i < f.n; i++ {
+		delta, err := r.readGolomb(f.p)
+		if err != nil {
+			return false, err
+		}
+		value += delta
+
+		for values[next] < value {
+			next++
+			if next == len(values) {
+				return false, nil
+			}
+		}
+		if values[next] == value {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// fastReduction calculates a mapping that's more or less equivalent to: x mod
+// N. However, instead of using a mod operation, which can be expensive, it
+// uses the high bits of the product of the two values.
+func fastReduction(v, nm uint64) uint64 {
+	hi, _ := bits.Mul64(v, nm)
+	return hi
+}
+
+// sipKeys returns the two halves of the passed SipHash key.
+func sipKeys(key [KeySize]byte) (uint64, uint64) {
+	return binary.LittleEndian.Uint64(key[:8]),
+		binary.LittleEndian.Uint64(key[8:])
+}
+
+// sipRound performs a SipHash round on the passed state.
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = bits.RotateLeft64(v1, 13)
+	v1 ^= v0
+	v0 = bits.RotateLeft64(v0, 32)
+
+	v2 += v3
+	v3 = bits.RotateLeft64(v3, 16)
+	v3 ^= v2
+
+	v0 += v3
+	v3 = bits.RotateLeft64(v3, 21)
+	v3 ^= v0
+
+	v2 += v1
+	v1 = bits.RotateLeft64(v1, 17)
+	v1 ^= v2
+	v2 = bits.RotateLeft64(v2, 32)
+
+	return v0, v1, v2, v3
+}
+
+// sipHash returns the SipHash-2-4 of the passed data keyed by k0 and k1.
+func sipHash(k0, k1 uint64, p []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	// Compress the full 8 byte blocks.
+	length := len(p)
+	for len(p) >= 8 {
+		m := binary.LittleEndian.Uint64(p)
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+		p = p[8:]
+	}
+
+	// Compress the last block which holds the remaining bytes along with
+	// the length of the data in its most significant byte.
+	b := uint64(length) << 56
+	for i := len(p) - 1; i >= 0; i-- {
+		b |= uint64(p[i]) << (8 * uint(i))
+	}
+	v3 ^= b
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= b
+
+	// Finalize.
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+// bitWriter writes a stream of bits most significant bit first.
+type bitWriter struct {
+	bytes []byte
+	free  uint8 // The number of unused bits of the last byte.
+}
+
+// writeBit writes a single bit to the stream.
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.bytes = append(w.bytes, 0)
+		w.free = 8
+	}
+	w.free--
+	if bit {
+		w.bytes[len(w.bytes)-1] |= 1 << w.free
+	}
+}
+
+// writeBits writes the count least significant bits of v to the stream.
+func (w *bitWriter) writeBits(v uint64, count uint8) {
+	for i := int(count) - 1; i >= 0; i-- {
+		w.writeBit(v&(1<<uint(i)) != 0)
+	}
+}
+
+// writeGolomb writes the Golomb-Rice code of v with the parameter P, which is
+// the quotient of v by 2**P in unary followed by the P bit remainder.
+func (w *bitWriter) writeGolomb(v uint64, P uint8) {
+	for q := v >> P; q > 0; q-- {
+		w.writeBit(true)
+	}
+	w.writeBit(false)
+	w.writeBits(v, P)
+}
+
+// bitReader reads a stream of bits most significant bit first.
+type bitReader struct {
+	data []byte
+	pos  uint64 // The position of the next bit to read.
+}
+
+// readBit reads a single bit from the stream.
+func (r *bitReader) readBit() (bool, error) {
+	index := r.pos / 8
+	if index >= uint64(len(r.data)) {
+		return false, errBitStreamEnd
+	}
+	bit := r.data[index]&(0x80>>(r.pos%8)) != 0
+	r.pos++
+	return bit, nil
+}
+
+// readBits reads count bits from the stream.
+func (r *bitReader) readBits(count uint8) (uint64, error) {
+	var v uint64
+	for i := uint8(0); i < count; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+	return v, nil
+}
+
+// readGolomb reads a value written by writeGolomb with the parameter P.
+func (r *bitReader) readGolomb(P uint8) (uint64, error) {
+	var q uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		q++
+	}
+	remainder, err := r.readBits(P)
+	if err != nil {
+		return 0, err
+	}
+	return q<<P | remainder, nil
+}
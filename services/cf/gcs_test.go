@@ -0,0 +1,159 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2016-2017 The btcsuite developers
+// Copyright (c) 2016-2017 The Lightning Network Developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package cf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+)
+
+// TestSipHash ensures the SipHash-2-4 implementation matches the test vectors
+// of the reference implementation, which use the key 00 01 .. 0f and the
+// messages 00 01 .. of increasing length.
+func TestSipHash(t *testing.T) {
+	var key [KeySize]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	k0, k1 := sipKeys(key)
+
+	tests := []struct {
+		size int
+		want uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{1, 0x74f839c593dc67fd},
+		{2, 0x0d6c8009d9a94f5a},
+		{15, 0xa129ca6149be45e5},
+	}
+	for _, test := range tests {
+		msg := make([]byte, test.size)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+		if got := sipHash(k0, k1, msg); got != test.want {
+			t.Errorf("sipHash of %d bytes: got %#x, want %#x",
+				test.size, got, test.want)
+		}
+	}
+}
+
+// TestBIP158Filters ensures the filters built from the elements of the BIP158
+// test vectors serialize to the filters of the vectors.  The elements are the
+// public key scripts of the outputs of the blocks of the vectors.
+func TestBIP158Filters(t *testing.T) {
+	tests := []struct {
+		name      string
+		blockHash string
+		elements  []string
+		filter    string
+	}{
+		{
+			name:      "testnet genesis block",
+			blockHash: "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943",
+			elements: []string{
+				"4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac",
+			},
+			filter: "019dfca8",
+		},
+		{
+			name:      "empty filter",
+			blockHash: "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943",
+			filter:    "00",
+		},
+	}
+
+	for _, test := range tests {
+		blockHash, err := hash.NewHashFromStr(test.blockHash)
+		if err != nil {
+			t.Fatalf("%s: NewHashFromStr: %v", test.name, err)
+		}
+		var data [][]byte
+		for _, element := range test.elements {
+			script, err := hex.DecodeString(element)
+			if err != nil {
+				t.Fatalf("%s: DecodeString: %v", test.name, err)
+			}
+			data = append(data, script)
+		}
+		key := DeriveKey(blockHash)
+		filter, err := BuildGCSFilter(DefaultP, DefaultM, key, data)
+		if err != nil {
+			t.Errorf("%s: BuildGCSFilter: %v", test.name, err)
+			continue
+		}
+		if got := hex.EncodeToString(filter.NBytes()); got != test.filter {
+			t.Errorf("%s: got filter %s, want %s", test.name, got,
+				test.filter)
+			continue
+		}
+
+		// The deserialized filter matches all of its elements.
+		want, _ := hex.DecodeString(test.filter)
+		decoded, err := FromNBytes(DefaultP, DefaultM, want)
+		if err != nil {
+			t.Errorf("%s: FromNBytes: %v", test.name, err)
+			continue
+		}
+		for _, element := range data {
+			match, err := decoded.Match(key, element)
+			if err != nil || !match {
+				t.Errorf("%s: element %x does not match (%v)",
+					test.name, element, err)
+			}
+		}
+	}
+}
+
+// TestFilterMatch ensures a filter matches its members, round trips through
+// its serialization, and does not match other data.
+func TestFilterMatch(t *testing.T) {
+	var key [KeySize]byte
+	copy(key[:], "the filter key..")
+	data := make([][]byte, 100)
+	for i := range data {
+		data[i] = []byte{byte(i), byte(i >> 8), 0xaa}
+	}
+	filter, err := BuildGCSFilter(DefaultP, DefaultM, key, data)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: %v", err)
+	}
+	if filter.N() != uint32(len(data)) || filter.P() != DefaultP {
+		t.Fatalf("got N %d P %d, want N %d P %d", filter.N(), filter.P(),
+			len(data), DefaultP)
+	}
+
+	decoded, err := FromNBytes(DefaultP, DefaultM, filter.NBytes())
+	if err != nil {
+		t.Fatalf("FromNBytes: %v", err)
+	}
+	if !bytes.Equal(decoded.Bytes(), filter.Bytes()) ||
+		decoded.Hash() != filter.Hash() {
+		t.Fatalf("deserialized filter differs")
+	}
+
+	for _, f := range []*Filter{filter, decoded} {
+		for _, element := range data {
+			match, err := f.Match(key, element)
+			if err != nil || !match {
+				t.Fatalf("element %x does not match (%v)", element,
+					err)
+			}
+		}
+		match, err := f.MatchAny(key, [][]byte{[]byte("a"), data[42]})
+		if err != nil || !match {
+			t.Fatalf("MatchAny with a member does not match (%v)", err)
+		}
+		match, err = f.MatchAny(key, [][]byte{[]byte("a"), []byte("b")})
+		if err != nil || match {
+			t.Fatalf("MatchAny without a member matches (%v)", err)
+		}
+	}
+}
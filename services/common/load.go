@@ -32,6 +32,7 @@ const (
 	defaultBlockMinSize          = 0
 	defaultBlockMaxSize          = 375000
 	defaultMaxRPCClients         = 10
+	defaultMaxRPCWebsockets      = 25
 	defaultMaxPeers              = 125
 	defaultMiningStateSync       = false
 )
@@ -71,9 +72,11 @@ func LoadConfig() (*config.Config, []string, error) {
 		RPCKey:               defaultRPCKeyFile,
 		RPCCert:              defaultRPCCertFile,
 		RPCMaxClients:        defaultMaxRPCClients,
+		RPCMaxWebsockets:     defaultMaxRPCWebsockets,
 		Generate:             defaultGenerate,
 		MaxPeers:             defaultMaxPeers,
 		MinTxFee:             mempool.DefaultMinRelayTxFee,
+		MaxMempoolSize:       mempool.DefaultMaxPoolSize,
 		BlockMinSize:         defaultBlockMinSize,
 		BlockMaxSize:         defaultBlockMaxSize,
 		SigCacheMaxSize:      defaultSigCacheMaxSize,
@@ -297,6 +300,68 @@ func LoadConfig() (*config.Config, []string, error) {
 		return nil, nil, err
 	}
 
+	// --spentindex and --dropspentindex do not mix.
+	if cfg.SpentIndex && cfg.DropSpentIndex {
+		err := fmt.Errorf("%s: the --spentindex and --dropspentindex "+
+			"options may not be activated at the same time",
+			funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --spentindex and --droptxindex do not mix.
+	if cfg.SpentIndex && cfg.DropTxIndex {
+		err := fmt.Errorf("%s: the --spentindex and --droptxindex "+
+			"options may not be activated at the same time "+
+			"because the spent index relies on the transaction "+
+			"index", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --nulldataindex and --dropnulldataindex do not mix.
+	if cfg.NullDataIndex && cfg.DropNullDataIndex {
+		err := fmt.Errorf("%s: the --nulldataindex and "+
+			"--dropnulldataindex options may not be activated at "+
+			"the same time", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --nulldataindex and --droptxindex do not mix.
+	if cfg.NullDataIndex && cfg.DropTxIndex {
+		err := fmt.Errorf("%s: the --nulldataindex and --droptxindex "+
+			"options may not be activated at the same time "+
+			"because the null data index relies on the transaction "+
+			"index", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --addrutxoindex and --dropaddrutxoindex do not mix.
+	if cfg.AddrUtxoIndex && cfg.DropAddrUtxoIndex {
+		err := fmt.Errorf("%s: the --addrutxoindex and "+
+			"--dropaddrutxoindex options may not be activated at "+
+			"the same time", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --timeindex and --droptimeindex do not mix.
+	if cfg.TimeIndex && cfg.DropTimeIndex {
+		err := fmt.Errorf("%s: the --timeindex and --droptimeindex "+
+			"options may not be activated at the same time",
+			funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// --addrindex and --droptxindex do not mix.
 	if cfg.AddrIndex && cfg.DropTxIndex {
 		err := fmt.Errorf("%s: the --addrindex and --droptxindex "+
@@ -309,6 +374,38 @@ func LoadConfig() (*config.Config, []string, error) {
 		return nil, nil, err
 	}
 
+	// --pruneblockdata does not mix with the indexes relying on the block
+	// data.
+	if cfg.PruneBlockData {
+		var conflicts []string
+		if cfg.TxIndex {
+			conflicts = append(conflicts, "--txindex")
+		}
+		if cfg.AddrIndex {
+			conflicts = append(conflicts, "--addrindex")
+		}
+		if cfg.SpentIndex {
+			conflicts = append(conflicts, "--spentindex")
+		}
+		if cfg.NullDataIndex {
+			conflicts = append(conflicts, "--nulldataindex")
+		}
+		if !cfg.NoCFilters {
+			// The committed filters are enabled by default.
+			conflicts = append(conflicts,
+				"the committed filters (see --nocfilters)")
+		}
+		if len(conflicts) > 0 {
+			err := fmt.Errorf("%s: the --pruneblockdata option may "+
+				"not be activated at the same time as %s "+
+				"because the indexes rely on the block data",
+				funcName, strings.Join(conflicts, ", "))
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	}
+
 	// Check mining addresses are valid and saved parsed versions.
 	for _, strAddr := range cfg.MiningAddrs {
 		addr, err := address.DecodeAddress(strAddr)
@@ -372,6 +469,17 @@ func LoadConfig() (*config.Config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Ensure there is at least one mining address when the Stratum server is
+	// enabled, since the blocks found by its miners pay to them.
+	if cfg.StratumListen != "" && len(cfg.MiningAddrs) == 0 {
+		str := "%s: the stratum option is set, but there are no mining " +
+			"addresses specified "
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Warn about missing config file only after all other configuration is
 	// done.  This prevents the warning on help messages and invalid
 	// options.  Note this should go directly before the return.
@@ -12,10 +12,10 @@ import (
 	"sync"
 
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer-lib/crypto/ecc"
 	"github.com/Qitmeer/qitmeer/core/address"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/params"
@@ -591,6 +591,8 @@ func addrToKey(addr types.Address, params *params.Params) ([addrKeySize]byte, er
 // transactions such as those which are kept in the memory pool before inclusion
 // in a block.
 type AddrIndex struct {
+	indexStatus
+
 	// The following fields are set when the instance is created and can't
 	// be changed afterwards, so there is no need to protect them with a
 	// separate mutex.
@@ -890,6 +892,11 @@ func (idx *AddrIndex) indexUnconfirmedAddresses(pkScript []byte, tx *types.Tx) {
 //
 // This function is safe for concurrent access.
 func (idx *AddrIndex) AddUnconfirmedTx(tx *types.Tx, utxoView *blockchain.UtxoViewpoint) {
+	// Nothing to track while the index isn't maintained.
+	if state := idx.getState(); state == indexDisabled || state == indexDropping {
+		return
+	}
+
 	// Index addresses of all referenced previous transaction outputs.
 	//
 	// The existence checks are elided since this is only called after the
@@ -0,0 +1,732 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+const (
+	// addrUtxoIndexName is the human-readable name for the index.
+	addrUtxoIndexName = "address utxo index"
+
+	// addrUtxoKeySize is the size of a key in the unspent outputs bucket.
+	// It consists of the address key, the transaction hash and the output
+	// index.
+	addrUtxoKeySize = addrKeySize + hash.HashSize + 4
+
+	// addrDeltaKeySize is the size of a key in the deltas bucket.  It
+	// consists of the address key, the block order, the index of the
+	// transaction in the block, the direction and the input or output
+	// index.
+	addrDeltaKeySize = addrKeySize + 4 + 4 + 1 + 4
+)
+
+var (
+	// addrUtxoIndexKey is the key of the address utxo index and the db
+	// bucket used to house it.
+	addrUtxoIndexKey = []byte("addrutxoidx")
+
+	// addrBalanceBucketName, addrUtxoBucketName and addrDeltaBucketName
+	// are the names of the buckets nested in the index bucket which house
+	// the balances, the unspent outputs and the balance changes.
+	addrBalanceBucketName = []byte("balance")
+	addrUtxoBucketName    = []byte("utxo")
+	addrDeltaBucketName   = []byte("delta")
+)
+
+// -----------------------------------------------------------------------------
+// The address utxo index consists of three buckets nested in the index bucket.
+// Only the outputs paying to a single supported address are indexed, so the
+// outputs of bare multisig scripts are not accounted to any address.
+//
+// The serialized format of the balance bucket is:
+//
+//   <addr key> = <balance><received>
+//
+//   Field           Type              Size
+//   addr key        [addrKeySize]byte 21 bytes
+//   balance         uint64            8 bytes
+//   received        uint64            8 bytes
+//   -----
+//   Total: 37 bytes
+//
+// The serialized format of the unspent outputs bucket is:
+//
+//   <addr key><txhash><index> = <amount><block hash><coinbase><pkscript>
+//
+//   Field           Type              Size
+//   addr key        [addrKeySize]byte 21 bytes
+//   txhash          hash.Hash         32 bytes
+//   index           uint32            4 bytes
+//   amount          uint64            8 bytes
+//   block hash      hash.Hash         32 bytes
+//   coinbase        byte              1 byte
+//   pkscript        []byte            variable
+//
+// The serialized format of the deltas bucket is:
+//
+//   <addr key><order><tx index><direction><index> = <txhash><amount>
+//
+//   Field           Type              Size
+//   addr key        [addrKeySize]byte 21 bytes
+//   order           uint32            4 bytes
+//   tx index        uint32            4 bytes
+//   direction       byte              1 byte (0 output, 1 input)
+//   index           uint32            4 bytes
+//   txhash          hash.Hash         32 bytes
+//   amount          uint64            8 bytes
+//
+// The numbers in the keys of the deltas bucket are big endian so the cursor
+// iterates them by DAG order.
+// -----------------------------------------------------------------------------
+
+// AddrUtxo is an unspent output paying to an address.
+type AddrUtxo struct {
+	OutPoint   types.TxOutPoint
+	Amount     uint64
+	PkScript   []byte
+	BlockHash  hash.Hash
+	IsCoinBase bool
+}
+
+// AddrDelta is a change of the balance of an address by an output created by
+// a transaction or by an input of a transaction spending an output.  The
+// amount is negative for inputs.  The order is the DAG order of the block of
+// the transaction and is zero for unconfirmed transactions.
+type AddrDelta struct {
+	TxHash  hash.Hash
+	Index   uint32
+	IsInput bool
+	Amount  int64
+	Order   uint32
+}
+
+// addrUtxoKey returns the key of the passed outpoint in the unspent outputs
+// bucket.
+func addrUtxoKey(addrKey [addrKeySize]byte, outPoint *types.TxOutPoint) []byte {
+	key := make([]byte, addrUtxoKeySize)
+	copy(key, addrKey[:])
+	copy(key[addrKeySize:], outPoint.Hash[:])
+	byteOrder.PutUint32(key[addrKeySize+hash.HashSize:], outPoint.OutIndex)
+	return key
+}
+
+// serializeAddrUtxo returns the value of the passed output in the unspent
+// outputs bucket.
+func serializeAddrUtxo(amount uint64, blockHash *hash.Hash, isCoinBase bool, pkScript []byte) []byte {
+	serialized := make([]byte, 8+hash.HashSize+1+len(pkScript))
+	byteOrder.PutUint64(serialized, amount)
+	copy(serialized[8:], blockHash[:])
+	if isCoinBase {
+		serialized[8+hash.HashSize] = 1
+	}
+	copy(serialized[8+hash.HashSize+1:], pkScript)
+	return serialized
+}
+
+// deserializeAddrUtxo decodes an entry of the unspent outputs bucket.
+func deserializeAddrUtxo(key, serialized []byte) (*AddrUtxo, error) {
+	if len(key) != addrUtxoKeySize || len(serialized) < 8+hash.HashSize+1 {
+		return nil, errDeserialize("unexpected end of data")
+	}
+	utxo := &AddrUtxo{
+		Amount:     byteOrder.Uint64(serialized),
+		IsCoinBase: serialized[8+hash.HashSize] == 1,
+	}
+	copy(utxo.OutPoint.Hash[:], key[addrKeySize:])
+	utxo.OutPoint.OutIndex = byteOrder.Uint32(key[addrKeySize+hash.HashSize:])
+	copy(utxo.BlockHash[:], serialized[8:])
+	utxo.PkScript = make([]byte, len(serialized)-(8+hash.HashSize+1))
+	copy(utxo.PkScript, serialized[8+hash.HashSize+1:])
+	return utxo, nil
+}
+
+// addrDeltaKey returns the key of a balance change in the deltas bucket.
+func addrDeltaKey(addrKey [addrKeySize]byte, order uint32, txIdx int, isInput bool, index uint32) []byte {
+	key := make([]byte, addrDeltaKeySize)
+	copy(key, addrKey[:])
+	binary.BigEndian.PutUint32(key[addrKeySize:], order)
+	binary.BigEndian.PutUint32(key[addrKeySize+4:], uint32(txIdx))
+	if isInput {
+		key[addrKeySize+8] = 1
+	}
+	binary.BigEndian.PutUint32(key[addrKeySize+9:], index)
+	return key
+}
+
+// dbFetchAddrBalance returns the balance and the total received amount of the
+// passed address key.
+func dbFetchAddrBalance(bucket internalBucket, addrKey [addrKeySize]byte) (uint64, uint64) {
+	serialized := bucket.Get(addrKey[:])
+	if len(serialized) < 16 {
+		return 0, 0
+	}
+	return byteOrder.Uint64(serialized), byteOrder.Uint64(serialized[8:])
+}
+
+// dbUpdateAddrBalance adds the passed amounts to the balance and the total
+// received amount of the passed address key.  The entry is removed once both
+// are zero.
+func dbUpdateAddrBalance(bucket internalBucket, addrKey [addrKeySize]byte, balanceDelta, receivedDelta int64) error {
+	balance, received := dbFetchAddrBalance(bucket, addrKey)
+	balance = uint64(int64(balance) + balanceDelta)
+	received = uint64(int64(received) + receivedDelta)
+	if balance == 0 && received == 0 {
+		return bucket.Delete(addrKey[:])
+	}
+	var serialized [16]byte
+	byteOrder.PutUint64(serialized[:], balance)
+	byteOrder.PutUint64(serialized[8:], received)
+	return bucket.Put(addrKey[:], serialized[:])
+}
+
+// AddrUtxoIndex implements an index of the unspent outputs and the balance of
+// every address, along with the changes of the balance by every transaction.
+// It is maintained from the outputs spent by the blocks, so the balance of an
+// address is available without replaying its transactions.
+//
+// In addition, the balance changes of the unconfirmed transactions in the
+// memory pool are kept in memory.
+type AddrUtxoIndex struct {
+	indexStatus
+
+	// The following fields are set when the instance is created and can't
+	// be changed afterwards, so there is no need to protect them with a
+	// separate mutex.
+	db          database.DB
+	chainParams *params.Params
+	chain       *blockchain.BlockChain
+
+	// The balance changes of the unconfirmed transactions by address and
+	// the addresses of every unconfirmed transaction.  They are protected
+	// by the unconfirmedLock field.
+	unconfirmedLock   sync.RWMutex
+	unconfirmedDeltas map[[addrKeySize]byte]map[hash.Hash][]AddrDelta
+	addrsByTx         map[hash.Hash]map[[addrKeySize]byte]struct{}
+}
+
+// Ensure the AddrUtxoIndex type implements the Indexer interface.
+var _ Indexer = (*AddrUtxoIndex)(nil)
+
+// Ensure the AddrUtxoIndex type implements the NeedsInputser interface.
+var _ NeedsInputser = (*AddrUtxoIndex)(nil)
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index.
+//
+// This implements the NeedsInputser interface.
+func (idx *AddrUtxoIndex) NeedsInputs() bool {
+	return true
+}
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *AddrUtxoIndex) Init() error {
+	// Nothing to do.
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *AddrUtxoIndex) Key() []byte {
+	return addrUtxoIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *AddrUtxoIndex) Name() string {
+	return addrUtxoIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the index and
+// the buckets nested in it.
+//
+// This is part of the Indexer interface.
+func (idx *AddrUtxoIndex) Create(dbTx database.Tx) error {
+	bucket, err := dbTx.Metadata().CreateBucket(addrUtxoIndexKey)
+	if err != nil {
+		return err
+	}
+	for _, name := range [][]byte{addrBalanceBucketName,
+		addrUtxoBucketName, addrDeltaBucketName} {
+		if _, err := bucket.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addrKeyForPkScript returns the address key of the passed public key script
+// when it pays to a single supported address.
+func (idx *AddrUtxoIndex) addrKeyForPkScript(pkScript []byte) ([addrKeySize]byte, bool) {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, idx.chainParams)
+	if err != nil || len(addrs) != 1 {
+		return [addrKeySize]byte{}, false
+	}
+	addrKey, err := addrToKey(addrs[0], idx.chainParams)
+	if err != nil {
+		return [addrKeySize]byte{}, false
+	}
+	return addrKey, true
+}
+
+// numSpentOutputs returns the number of outputs spent by the passed block.
+func numSpentOutputs(block *types.SerializedBlock) int {
+	var numSpent int
+	for _, tx := range block.Transactions()[1:] {
+		numSpent += len(tx.Transaction().TxIn)
+	}
+	return numSpent
+}
+
+// isBlockApplied returns whether the transactions of the passed block connected
+// with the passed stxos are applied to the utxo set.  The chain only passes the
+// spent outputs of the blocks it applies, the way spendsOutputs checks.  A block
+// only holding a coinbase spends nothing, so it is decided from the status of
+// its node, which the chain marks invalid before connecting it.
+func (idx *AddrUtxoIndex) isBlockApplied(block *types.SerializedBlock, stxos []blockchain.SpentTxOut) (bool, error) {
+	if numSpent := numSpentOutputs(block); numSpent > 0 {
+		return numSpent == len(stxos), nil
+	}
+	node := idx.chain.BlockIndex().LookupNode(block.Hash())
+	if node == nil {
+		return false, fmt.Errorf("no node %s", block.Hash())
+	}
+	return !node.GetStatus().KnownInvalid(), nil
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer removes the outputs spent by the
+// block from the unspent outputs of their addresses, adds the outputs created
+// by the block and updates the balances accordingly.
+//
+// This is part of the Indexer interface.
+func (idx *AddrUtxoIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	applied, err := idx.isBlockApplied(block, stxos)
+	if err != nil || !applied {
+		return err
+	}
+
+	bucket := dbTx.Metadata().Bucket(addrUtxoIndexKey)
+	balances := bucket.Bucket(addrBalanceBucketName)
+	utxos := bucket.Bucket(addrUtxoBucketName)
+	deltas := bucket.Bucket(addrDeltaBucketName)
+	order := uint32(block.Order())
+
+	stxoIdx := 0
+	for txIdx, tx := range block.Transactions() {
+		txHash := tx.Hash()
+		msgTx := tx.Transaction()
+		if txIdx != 0 {
+			for inIdx, txIn := range msgTx.TxIn {
+				if stxoIdx >= len(stxos) {
+					return AssertError(fmt.Sprintf("missing "+
+						"spent output for block %s",
+						block.Hash()))
+				}
+				stxo := &stxos[stxoIdx]
+				stxoIdx++
+				addrKey, ok := idx.addrKeyForPkScript(stxo.PkScript)
+				if !ok {
+					continue
+				}
+				err := utxos.Delete(addrUtxoKey(addrKey, &txIn.PreviousOut))
+				if err != nil {
+					return err
+				}
+				err = dbUpdateAddrBalance(balances, addrKey,
+					-int64(stxo.Amount), 0)
+				if err != nil {
+					return err
+				}
+				err = deltas.Put(addrDeltaKey(addrKey, order, txIdx,
+					true, uint32(inIdx)), serializeAddrDelta(txHash,
+					stxo.Amount))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for outIdx, txOut := range msgTx.TxOut {
+			if txscript.IsUnspendable(txOut.PkScript) {
+				continue
+			}
+			addrKey, ok := idx.addrKeyForPkScript(txOut.PkScript)
+			if !ok {
+				continue
+			}
+			outPoint := types.NewOutPoint(txHash, uint32(outIdx))
+			err := utxos.Put(addrUtxoKey(addrKey, outPoint),
+				serializeAddrUtxo(txOut.Amount, block.Hash(),
+					txIdx == 0, txOut.PkScript))
+			if err != nil {
+				return err
+			}
+			err = dbUpdateAddrBalance(balances, addrKey,
+				int64(txOut.Amount), int64(txOut.Amount))
+			if err != nil {
+				return err
+			}
+			err = deltas.Put(addrDeltaKey(addrKey, order, txIdx,
+				false, uint32(outIdx)), serializeAddrDelta(txHash,
+				txOut.Amount))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer undoes ConnectBlock, so the
+// transactions are processed in reverse order and the spent outputs are
+// restored from the passed stxos.  The chain can mark the block invalid before
+// disconnecting it, so whether it was applied is decided from the stxos, and
+// only the outputs of a block spending nothing which have their balance change
+// recorded are removed.
+//
+// This is part of the Indexer interface.
+func (idx *AddrUtxoIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	numSpent := numSpentOutputs(block)
+	if numSpent != len(stxos) {
+		return nil
+	}
+
+	bucket := dbTx.Metadata().Bucket(addrUtxoIndexKey)
+	balances := bucket.Bucket(addrBalanceBucketName)
+	utxos := bucket.Bucket(addrUtxoBucketName)
+	deltas := bucket.Bucket(addrDeltaBucketName)
+	order := uint32(block.Order())
+
+	stxoIdx := len(stxos)
+	txns := block.Transactions()
+	for txIdx := len(txns) - 1; txIdx >= 0; txIdx-- {
+		txHash := txns[txIdx].Hash()
+		msgTx := txns[txIdx].Transaction()
+		for outIdx, txOut := range msgTx.TxOut {
+			if txscript.IsUnspendable(txOut.PkScript) {
+				continue
+			}
+			addrKey, ok := idx.addrKeyForPkScript(txOut.PkScript)
+			if !ok {
+				continue
+			}
+			deltaKey := addrDeltaKey(addrKey, order, txIdx, false,
+				uint32(outIdx))
+			if numSpent == 0 && deltas.Get(deltaKey) == nil {
+				continue
+			}
+			outPoint := types.NewOutPoint(txHash, uint32(outIdx))
+			err := utxos.Delete(addrUtxoKey(addrKey, outPoint))
+			if err != nil {
+				return err
+			}
+			err = dbUpdateAddrBalance(balances, addrKey,
+				-int64(txOut.Amount), -int64(txOut.Amount))
+			if err != nil {
+				return err
+			}
+			err = deltas.Delete(deltaKey)
+			if err != nil {
+				return err
+			}
+		}
+
+		if txIdx == 0 {
+			continue
+		}
+		for inIdx := len(msgTx.TxIn) - 1; inIdx >= 0; inIdx-- {
+			stxoIdx--
+			if stxoIdx < 0 {
+				return AssertError(fmt.Sprintf("missing spent "+
+					"output for block %s", block.Hash()))
+			}
+			stxo := &stxos[stxoIdx]
+			addrKey, ok := idx.addrKeyForPkScript(stxo.PkScript)
+			if !ok {
+				continue
+			}
+			err := utxos.Put(addrUtxoKey(addrKey,
+				&msgTx.TxIn[inIdx].PreviousOut),
+				serializeAddrUtxo(stxo.Amount, &stxo.BlockHash,
+					stxo.IsCoinBase, stxo.PkScript))
+			if err != nil {
+				return err
+			}
+			err = dbUpdateAddrBalance(balances, addrKey,
+				int64(stxo.Amount), 0)
+			if err != nil {
+				return err
+			}
+			err = deltas.Delete(addrDeltaKey(addrKey, order, txIdx,
+				true, uint32(inIdx)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// serializeAddrDelta returns the value of a balance change in the deltas
+// bucket.
+func serializeAddrDelta(txHash *hash.Hash, amount uint64) []byte {
+	serialized := make([]byte, hash.HashSize+8)
+	copy(serialized, txHash[:])
+	byteOrder.PutUint64(serialized[hash.HashSize:], amount)
+	return serialized
+}
+
+// Balance returns the confirmed balance of the passed address and the total
+// amount it ever received.
+//
+// This function is safe for concurrent access.
+func (idx *AddrUtxoIndex) Balance(addr types.Address) (uint64, uint64, error) {
+	addrKey, err := addrToKey(addr, idx.chainParams)
+	if err != nil {
+		return 0, 0, err
+	}
+	var balance, received uint64
+	err = idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(addrUtxoIndexKey)
+		balance, received = dbFetchAddrBalance(
+			bucket.Bucket(addrBalanceBucketName), addrKey)
+		return nil
+	})
+	return balance, received, err
+}
+
+// UnspentOutputs returns the confirmed unspent outputs paying to the passed
+// address.
+//
+// This function is safe for concurrent access.
+func (idx *AddrUtxoIndex) UnspentOutputs(addr types.Address) ([]*AddrUtxo, error) {
+	addrKey, err := addrToKey(addr, idx.chainParams)
+	if err != nil {
+		return nil, err
+	}
+	var result []*AddrUtxo
+	err = idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(addrUtxoIndexKey)
+		cursor := bucket.Bucket(addrUtxoBucketName).Cursor()
+		for ok := cursor.Seek(addrKey[:]); ok; ok = cursor.Next() {
+			if !bytes.HasPrefix(cursor.Key(), addrKey[:]) {
+				break
+			}
+			utxo, err := deserializeAddrUtxo(cursor.Key(), cursor.Value())
+			if err != nil {
+				return database.Error{
+					ErrorCode: database.ErrCorruption,
+					Description: fmt.Sprintf("corrupt address "+
+						"utxo entry for %x: %v", addrKey, err),
+				}
+			}
+			result = append(result, utxo)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Deltas returns the confirmed balance changes of the passed address by the
+// blocks with a DAG order in the range [start, end], ordered by DAG order.
+//
+// This function is safe for concurrent access.
+func (idx *AddrUtxoIndex) Deltas(addr types.Address, start, end uint32) ([]AddrDelta, error) {
+	addrKey, err := addrToKey(addr, idx.chainParams)
+	if err != nil {
+		return nil, err
+	}
+	var result []AddrDelta
+	err = idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(addrUtxoIndexKey)
+		cursor := bucket.Bucket(addrDeltaBucketName).Cursor()
+		seek := addrDeltaKey(addrKey, start, 0, false, 0)
+		for ok := cursor.Seek(seek); ok; ok = cursor.Next() {
+			key, value := cursor.Key(), cursor.Value()
+			if !bytes.HasPrefix(key, addrKey[:]) {
+				break
+			}
+			if len(key) != addrDeltaKeySize || len(value) < hash.HashSize+8 {
+				return database.Error{
+					ErrorCode: database.ErrCorruption,
+					Description: fmt.Sprintf("corrupt address "+
+						"delta entry for %x", addrKey),
+				}
+			}
+			order := binary.BigEndian.Uint32(key[addrKeySize:])
+			if order > end {
+				break
+			}
+			delta := AddrDelta{
+				Index:   binary.BigEndian.Uint32(key[addrKeySize+9:]),
+				IsInput: key[addrKeySize+8] == 1,
+				Amount:  int64(byteOrder.Uint64(value[hash.HashSize:])),
+				Order:   order,
+			}
+			copy(delta.TxHash[:], value)
+			if delta.IsInput {
+				delta.Amount = -delta.Amount
+			}
+			result = append(result, delta)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// addUnconfirmedDelta records a balance change of the address paid by the
+// passed public key script by an unconfirmed transaction.
+//
+// This function MUST be called with the unconfirmed lock held (for writes).
+func (idx *AddrUtxoIndex) addUnconfirmedDelta(pkScript []byte, delta AddrDelta) {
+	addrKey, ok := idx.addrKeyForPkScript(pkScript)
+	if !ok {
+		return
+	}
+	txDeltas := idx.unconfirmedDeltas[addrKey]
+	if txDeltas == nil {
+		txDeltas = make(map[hash.Hash][]AddrDelta)
+		idx.unconfirmedDeltas[addrKey] = txDeltas
+	}
+	txDeltas[delta.TxHash] = append(txDeltas[delta.TxHash], delta)
+
+	addrs := idx.addrsByTx[delta.TxHash]
+	if addrs == nil {
+		addrs = make(map[[addrKeySize]byte]struct{})
+		idx.addrsByTx[delta.TxHash] = addrs
+	}
+	addrs[addrKey] = struct{}{}
+}
+
+// AddUnconfirmedTx adds the balance changes of the transaction to the
+// unconfirmed (memory-only) index.
+//
+// NOTE: This transaction MUST have already been validated by the memory pool
+// before calling this function with it and have all of the inputs available in
+// the provided utxo view.
+//
+// This function is safe for concurrent access.
+func (idx *AddrUtxoIndex) AddUnconfirmedTx(tx *types.Tx, utxoView *blockchain.UtxoViewpoint) {
+	// Nothing to track while the index isn't maintained.
+	if state := idx.getState(); state == indexDisabled || state == indexDropping {
+		return
+	}
+
+	idx.unconfirmedLock.Lock()
+	defer idx.unconfirmedLock.Unlock()
+
+	txHash := *tx.Hash()
+	msgTx := tx.Transaction()
+	for inIdx, txIn := range msgTx.TxIn {
+		entry := utxoView.LookupEntry(txIn.PreviousOut)
+		if entry == nil {
+			continue
+		}
+		idx.addUnconfirmedDelta(entry.PkScript(), AddrDelta{
+			TxHash:  txHash,
+			Index:   uint32(inIdx),
+			IsInput: true,
+			Amount:  -int64(entry.Amount()),
+		})
+	}
+	for outIdx, txOut := range msgTx.TxOut {
+		if txscript.IsUnspendable(txOut.PkScript) {
+			continue
+		}
+		idx.addUnconfirmedDelta(txOut.PkScript, AddrDelta{
+			TxHash: txHash,
+			Index:  uint32(outIdx),
+			Amount: int64(txOut.Amount),
+		})
+	}
+}
+
+// RemoveUnconfirmedTx removes the passed transaction from the unconfirmed
+// (memory-only) index.
+//
+// This function is safe for concurrent access.
+func (idx *AddrUtxoIndex) RemoveUnconfirmedTx(txHash *hash.Hash) {
+	idx.unconfirmedLock.Lock()
+	defer idx.unconfirmedLock.Unlock()
+
+	for addrKey := range idx.addrsByTx[*txHash] {
+		delete(idx.unconfirmedDeltas[addrKey], *txHash)
+		if len(idx.unconfirmedDeltas[addrKey]) == 0 {
+			delete(idx.unconfirmedDeltas, addrKey)
+		}
+	}
+	delete(idx.addrsByTx, *txHash)
+}
+
+// UnconfirmedDeltas returns the balance changes of the passed address by the
+// transactions in the unconfirmed (memory-only) index, ordered by transaction
+// hash.
+//
+// This function is safe for concurrent access.
+func (idx *AddrUtxoIndex) UnconfirmedDeltas(addr types.Address) []AddrDelta {
+	addrKey, err := addrToKey(addr, idx.chainParams)
+	if err != nil {
+		return nil
+	}
+
+	idx.unconfirmedLock.RLock()
+	defer idx.unconfirmedLock.RUnlock()
+
+	var result []AddrDelta
+	for _, txDeltas := range idx.unconfirmedDeltas[addrKey] {
+		result = append(result, txDeltas...)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if c := bytes.Compare(result[i].TxHash[:], result[j].TxHash[:]); c != 0 {
+			return c < 0
+		}
+		if result[i].IsInput != result[j].IsInput {
+			return !result[i].IsInput
+		}
+		return result[i].Index < result[j].Index
+	})
+	return result
+}
+
+// NewAddrUtxoIndex returns a new instance of an indexer that is used to keep
+// the unspent outputs and the balance of every address.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewAddrUtxoIndex(db database.DB, chainParams *params.Params) *AddrUtxoIndex {
+	return &AddrUtxoIndex{
+		db:                db,
+		chainParams:       chainParams,
+		unconfirmedDeltas: make(map[[addrKeySize]byte]map[hash.Hash][]AddrDelta),
+		addrsByTx:         make(map[hash.Hash]map[[addrKeySize]byte]struct{}),
+	}
+}
+
+// DropAddrUtxoIndex drops the address utxo index from the provided database if
+// it exists.
+func DropAddrUtxoIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, addrUtxoIndexKey, addrUtxoIndexName, interrupt)
+}
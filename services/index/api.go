@@ -0,0 +1,83 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package index
+
+import (
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/rpc"
+)
+
+func (m *Manager) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			NameSpace: rpc.DefaultServiceNameSpace,
+			Service:   NewPublicIndexAPI(m),
+			Public:    true,
+		},
+		{
+			NameSpace: rpc.IndexNameSpace,
+			Service:   NewPrivateIndexAPI(m),
+			Public:    false,
+		},
+	}
+}
+
+type PublicIndexAPI struct {
+	indexManager *Manager
+}
+
+func NewPublicIndexAPI(m *Manager) *PublicIndexAPI {
+	return &PublicIndexAPI{m}
+}
+
+// Return the state of every optional index, with its tip order and the
+// estimated seconds left for a syncing index to catch up
+func (api *PublicIndexAPI) GetIndexInfo() (interface{}, error) {
+	infos, bestOrder, err := api.indexManager.IndexInfo()
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to load index tips")
+	}
+	result := json.GetIndexInfoResult{
+		BestOrder: bestOrder,
+		Indexes:   make([]json.IndexInfoResult, 0, len(infos)),
+	}
+	for _, info := range infos {
+		indexInfo := json.IndexInfoResult{
+			Name:     info.Name,
+			Key:      info.Key,
+			State:    info.State,
+			TipOrder: info.TipOrder,
+			ETA:      int64(info.ETA.Seconds()),
+		}
+		if info.TipHash != nil {
+			indexInfo.TipHash = info.TipHash.String()
+		}
+		result.Indexes = append(result.Indexes, indexInfo)
+	}
+	return result, nil
+}
+
+// PrivateIndexAPI provides private RPC methods to start and drop the indexes.
+type PrivateIndexAPI struct {
+	indexManager *Manager
+}
+
+func NewPrivateIndexAPI(m *Manager) *PrivateIndexAPI {
+	return &PrivateIndexAPI{m}
+}
+
+// Enable the index with the name or key and catch it up in the background
+func (api *PrivateIndexAPI) StartIndex(name string) (interface{}, error) {
+	if err := api.indexManager.StartIndex(name); err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	return nil, nil
+}
+
+// Disable the index with the name or key and remove it in the background
+func (api *PrivateIndexAPI) DropIndex(name string) (interface{}, error) {
+	if err := api.indexManager.DropIndex(name); err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	return nil, nil
+}
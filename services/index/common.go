@@ -10,9 +10,10 @@ package index
 import (
 	"encoding/binary"
 	"errors"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
+	"sync/atomic"
 )
 
 var (
@@ -23,14 +24,106 @@ var (
 	// errInterruptRequested indicates that an operation was cancelled due
 	// to a user-requested interrupt.
 	errInterruptRequested = errors.New("interrupt requested")
+
+	// ErrIndexDisabled indicates that an index can't be queried because it
+	// isn't enabled.
+	ErrIndexDisabled = errors.New("index not enabled")
+
+	// ErrIndexSyncing indicates that an index can't be queried because it
+	// isn't caught up with the chain yet.
+	ErrIndexSyncing = errors.New("index syncing")
+)
+
+// indexState is the state of an index managed by the index manager.
+type indexState int32
+
+const (
+	// indexDisabled is the state of an index which isn't maintained.
+	indexDisabled indexState = iota
+
+	// indexSyncing is the state of an index which is being caught up with
+	// the chain in the background.
+	indexSyncing
+
+	// indexReady is the state of an index which is caught up with the
+	// chain and maintained along with it.
+	indexReady
+
+	// indexDropping is the state of an index which is being removed from
+	// the database in the background.
+	indexDropping
 )
 
+// String returns the state as a human-readable string.
+func (s indexState) String() string {
+	switch s {
+	case indexSyncing:
+		return "syncing"
+	case indexReady:
+		return "ready"
+	case indexDropping:
+		return "dropping"
+	}
+	return "disabled"
+}
+
+// indexStatus tracks the state of an index, so the queries of the index can be
+// refused until it is caught up with the chain.  It is embedded by the indexes
+// which can be started, caught up and dropped while the node runs.
+type indexStatus struct {
+	state int32
+}
+
+// getState returns the state of the index.
+//
+// This function is safe for concurrent access.
+func (s *indexStatus) getState() indexState {
+	return indexState(atomic.LoadInt32(&s.state))
+}
+
+// setState updates the state of the index.
+//
+// This function is safe for concurrent access.
+func (s *indexStatus) setState(state indexState) {
+	atomic.StoreInt32(&s.state, int32(state))
+}
+
+// Ready returns ErrIndexSyncing while the index is catching up with the chain
+// and ErrIndexDisabled when it isn't maintained.
+//
+// This function is safe for concurrent access.
+func (s *indexStatus) Ready() error {
+	switch s.getState() {
+	case indexReady:
+		return nil
+	case indexSyncing:
+		return ErrIndexSyncing
+	}
+	return ErrIndexDisabled
+}
+
+// managedIndexer is implemented by the indexes embedding indexStatus, which
+// the index manager keeps informed of their state.
+type managedIndexer interface {
+	Indexer
+	getState() indexState
+	setState(state indexState)
+	Ready() error
+}
+
 // NeedsInputser provides a generic interface for an indexer to specify the it
 // requires the ability to look up inputs for a transaction.
 type NeedsInputser interface {
 	NeedsInputs() bool
 }
 
+// NeedsChainer provides a generic interface for an indexer defined outside of
+// this package to be handed the block chain, such as to look up the position
+// of the blocks in the DAG.
+type NeedsChainer interface {
+	SetChain(chain *blockchain.BlockChain)
+}
+
 // Indexer provides a generic interface for an indexer that is managed by an
 // index manager such as the Manager type provided by this package.
 type Indexer interface {
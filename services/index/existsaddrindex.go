@@ -8,8 +8,8 @@ package index
 import (
 	"sync"
 
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/params"
@@ -55,7 +55,7 @@ type ExistsAddrIndex struct {
 	// This allows fairly efficient updates when transactions are removed
 	// once they are included into a block.
 	unconfirmedLock sync.RWMutex
-	mpExistsAddr    map[[addrKeySize]byte]struct{}
+	mpExistsAddr    map[[addrKeySize]byte]int
 }
 
 // NewExistsAddrIndex returns a new instance of an indexer that is used to
@@ -68,7 +68,7 @@ func NewExistsAddrIndex(db database.DB, chainParams *params.Params) *ExistsAddrI
 	return &ExistsAddrIndex{
 		db:           db,
 		chainParams:  chainParams,
-		mpExistsAddr: make(map[[addrKeySize]byte]struct{}),
+		mpExistsAddr: make(map[[addrKeySize]byte]int),
 	}
 }
 
@@ -270,7 +270,7 @@ func (idx *ExistsAddrIndex) ConnectBlock(dbTx database.Tx, block *types.Serializ
 	for k := range idx.mpExistsAddr {
 		usedAddrs[k] = struct{}{}
 	}
-	idx.mpExistsAddr = make(map[[addrKeySize]byte]struct{})
+	idx.mpExistsAddr = make(map[[addrKeySize]byte]int)
 	idx.unconfirmedLock.Unlock()
 
 	meta := dbTx.Metadata()
@@ -301,9 +301,10 @@ func (idx *ExistsAddrIndex) DisconnectBlock(dbTx database.Tx, block *types.Seria
 	return nil
 }
 
-// addUnconfirmedTx adds all addresses related to the transaction to the
-// unconfirmed (memory-only) exists address index.
-func (idx *ExistsAddrIndex) addUnconfirmedTx(tx *types.Transaction) {
+// unconfirmedTxKeys returns the keys of all addresses related to the
+// transaction, without duplicates.
+func (idx *ExistsAddrIndex) unconfirmedTxKeys(tx *types.Transaction) map[[addrKeySize]byte]struct{} {
+	keys := make(map[[addrKeySize]byte]struct{})
 	for _, txIn := range tx.TxIn {
 		if txscript.IsMultisigSigScript(txIn.SignScript) {
 			rs, err :=
@@ -328,10 +329,7 @@ func (idx *ExistsAddrIndex) addUnconfirmedTx(tx *types.Transaction) {
 				if err != nil {
 					continue
 				}
-
-				if _, exists := idx.mpExistsAddr[k]; !exists {
-					idx.mpExistsAddr[k] = struct{}{}
-				}
+				keys[k] = struct{}{}
 			}
 		}
 	}
@@ -350,12 +348,18 @@ func (idx *ExistsAddrIndex) addUnconfirmedTx(tx *types.Transaction) {
 				// Ignore unsupported address types.
 				continue
 			}
-
-			if _, exists := idx.mpExistsAddr[k]; !exists {
-				idx.mpExistsAddr[k] = struct{}{}
-			}
+			keys[k] = struct{}{}
 		}
 	}
+	return keys
+}
+
+// addUnconfirmedTx adds all addresses related to the transaction to the
+// unconfirmed (memory-only) exists address index.
+func (idx *ExistsAddrIndex) addUnconfirmedTx(tx *types.Transaction) {
+	for k := range idx.unconfirmedTxKeys(tx) {
+		idx.mpExistsAddr[k]++
+	}
 }
 
 // AddUnconfirmedTx is the exported form of addUnconfirmedTx.
@@ -368,6 +372,29 @@ func (idx *ExistsAddrIndex) AddUnconfirmedTx(tx *types.Transaction) {
 	idx.addUnconfirmedTx(tx)
 }
 
+// RemoveUnconfirmedTx removes the addresses related to the transaction from
+// the unconfirmed (memory-only) exists address index, unless another
+// unconfirmed transaction is related to them too.  It is invoked when the
+// transaction is removed from the memory pool, either because it was mined,
+// in which case the addresses are in the database, or because it was
+// evicted or replaced.
+//
+// This function is safe for concurrent access.
+func (idx *ExistsAddrIndex) RemoveUnconfirmedTx(tx *types.Transaction) {
+	idx.unconfirmedLock.Lock()
+	defer idx.unconfirmedLock.Unlock()
+
+	for k := range idx.unconfirmedTxKeys(tx) {
+		if n, ok := idx.mpExistsAddr[k]; ok {
+			if n <= 1 {
+				delete(idx.mpExistsAddr, k)
+			} else {
+				idx.mpExistsAddr[k] = n - 1
+			}
+		}
+	}
+}
+
 // DropExistsAddrIndex drops the exists address index from the provided
 // database if it exists.
 func DropExistsAddrIndex(db database.DB, interrupt <-chan struct{}) error {
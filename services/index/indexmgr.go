@@ -6,23 +6,70 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/common/math"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/services/common/progresslog"
+	"sync"
+	"time"
 )
 
+// indexEntry tracks the state of an index known to the index manager.
+type indexEntry struct {
+	indexer Indexer
+	state   indexState
+
+	// syncStart and syncStartOrder are the time the index started catching
+	// up with the chain and its tip order at that time.  They are used to
+	// estimate the time left to catch up.
+	syncStart      time.Time
+	syncStartOrder int64
+}
+
+// IndexInfo describes the state of an index known to the index manager.
+type IndexInfo struct {
+	Name  string
+	Key   string
+	State string
+
+	// TipOrder and TipHash identify the last block connected to the index.
+	// The order is -1 and the hash is nil when no block is indexed yet.
+	TipOrder int64
+	TipHash  *hash.Hash
+
+	// ETA is the estimated time left for a syncing index to catch up with
+	// the chain.  It is zero when it can't be estimated yet.
+	ETA time.Duration
+}
+
 // Manager defines an index manager that manages multiple optional indexes and
 // implements the blockchain.IndexManager interface so it can be seamlessly
 // plugged into normal chain processing.
+//
+// The indexes behind the chain are caught up in the background, and the
+// indexes can be started and dropped while the node is running.
 type Manager struct {
 	params         *params.Params
 	db             database.DB
 	enabledIndexes []Indexer
+	chain          *blockchain.BlockChain
+
+	// The following fields track the state of the indexes, including the
+	// disabled ones, and are protected by the mutex.  The mutex is taken
+	// inside the database transactions which read or update the index
+	// tips, so it is never held while opening a database transaction or
+	// waiting for the chain lock.  The lock order is the chain lock, the
+	// database and then the mutex.
+	mtx     sync.Mutex
+	indexes []*indexEntry
+	syncing bool
+
+	quit chan struct{}
+	wg   sync.WaitGroup
 }
 
 // Ensure the Manager type implements the blockchain.IndexManager interface.
@@ -33,45 +80,89 @@ var _ blockchain.IndexManager = (*Manager)(nil)
 // The manager returned satisfies the blockchain.IndexManager interface and thus
 // cleanly plugs into the normal blockchain processing path.
 func NewManager(db database.DB, enabledIndexes []Indexer, params *params.Params) *Manager {
-	return &Manager{
+	m := &Manager{
 		db:             db,
 		enabledIndexes: enabledIndexes,
 		params:         params,
+		quit:           make(chan struct{}),
 	}
+	for _, indexer := range enabledIndexes {
+		m.indexes = append(m.indexes, &indexEntry{indexer: indexer})
+	}
+	return m
+}
+
+// AddIndex makes a disabled index known to the manager, so it can be started
+// while the node is running.  It must be called before Init.
+func (m *Manager) AddIndex(indexer Indexer) {
+	m.indexes = append(m.indexes, &indexEntry{indexer: indexer})
+}
+
+// setState updates the state of the passed index and the status reported by
+// the index itself.
+//
+// This function MUST be called with the manager lock held.
+func (m *Manager) setState(entry *indexEntry, state indexState) {
+	entry.state = state
+	if idx, ok := entry.indexer.(managedIndexer); ok {
+		idx.setState(state)
+	}
+}
+
+// setSyncing marks the passed index as catching up with the chain from the
+// passed tip order.
+//
+// This function MUST be called with the manager lock held.
+func (m *Manager) setSyncing(entry *indexEntry, tipOrder int64) {
+	entry.syncStart = time.Now()
+	entry.syncStartOrder = tipOrder
+	m.setState(entry, indexSyncing)
 }
 
 // Init initializes the enabled indexes.  This is called during chain
-// initialization and primarily consists of catching up all indexes to the
-// current best chain tip.  This is necessary since each index can be disabled
-// and re-enabled at any time and attempting to catch-up indexes at the same
-// time new blocks are being downloaded would lead to an overall longer time to
-// catch up due to the I/O contention.
+// initialization and primarily consists of rolling back the indexes ahead of
+// the current best chain tip.  The indexes behind the chain are marked as
+// syncing and caught up in the background once the manager is started, so
+// the node doesn't have to wait for them.
 //
 // This is part of the blockchain.IndexManager interface.
 func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{}) error {
-	// Nothing to do when no indexes are enabled.
-	if len(m.enabledIndexes) == 0 {
-		return nil
-	}
-
 	if interruptRequested(interrupt) {
 		return errInterruptRequested
 	}
 
-	// Finish any drops that were previously interrupted.
-	if err := m.maybeFinishDrops(interrupt); err != nil {
-		return err
+	m.chain = chain
+	for _, entry := range m.indexes {
+		switch idx := entry.indexer.(type) {
+		case *TxIndex:
+			idx.chain = chain
+		case *AddrUtxoIndex:
+			idx.chain = chain
+		case *TimeIndex:
+			idx.chain = chain
+		case NeedsChainer:
+			idx.SetChain(chain)
+		}
 	}
 
-	// Create the initial state for the indexes as needed.
+	// Create the bucket for the current tips as needed.  It is created
+	// even when no index is enabled, so the indexes can be started later.
 	err := m.db.Update(func(dbTx database.Tx) error {
-		// Create the bucket for the current tips as needed.
 		meta := dbTx.Metadata()
 		_, err := meta.CreateBucketIfNotExists(dbnamespace.IndexTipsBucketName)
-		if err != nil {
-			return err
-		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Finish any drops that were previously interrupted.
+	if err := m.maybeFinishDrops(interrupt); err != nil {
+		return err
+	}
 
+	// Create the initial state for the indexes as needed.
+	err = m.db.Update(func(dbTx database.Tx) error {
 		return m.maybeCreateIndexes(dbTx)
 	})
 	if err != nil {
@@ -83,48 +174,82 @@ func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{})
 		if err := indexer.Init(); err != nil {
 			return err
 		}
-		if indexer.Name() == txIndexName {
-			indexer.(*TxIndex).chain = chain
-		}
 	}
 
-	bestOrder := uint32(chain.BestSnapshot().GraphState.GetMainOrder())
-
 	// Rollback indexes to the main chain if their tip is an orphaned fork.
-	// This is fairly unlikely, but it can happen if the chain is
-	// reorganized while the index is disabled.  This has to be done in
-	// reverse order because later indexes can depend on earlier ones.
-	var spentTxos []blockchain.SpentTxOut
+	// This has to be done in reverse order because later indexes can
+	// depend on earlier ones.
 	for i := len(m.enabledIndexes); i > 0; i-- {
-		indexer := m.enabledIndexes[i-1]
-
-		// Fetch the current tip for the index.
-		var order uint32
-		err := m.db.View(func(dbTx database.Tx) error {
-			idxKey := indexer.Key()
-			_, order, err = dbFetchIndexerTip(dbTx, idxKey)
-			return err
-		})
-		if err != nil {
+		if err := m.rollbackIndex(m.enabledIndexes[i-1], interrupt); err != nil {
 			return err
 		}
+	}
 
-		// Nothing to do if the index does not have any entries yet.
-		if order == math.MaxUint32 {
-			continue
+	// Mark the indexes behind the current best chain tip as syncing.
+	bestOrder := int64(chain.BestSnapshot().GraphState.GetMainOrder())
+	return m.db.View(func(dbTx database.Tx) error {
+		m.mtx.Lock()
+		defer m.mtx.Unlock()
+
+		for _, entry := range m.indexes[:len(m.enabledIndexes)] {
+			h, order, err := dbFetchIndexerTip(dbTx, entry.indexer.Key())
+			if err != nil {
+				return err
+			}
+			tipOrder := tipOrderOf(order)
+			log.Debug(fmt.Sprintf("Current %s tip", entry.indexer.Name()),
+				"order", tipOrder, "hash", h)
+			if tipOrder >= bestOrder {
+				m.setState(entry, indexReady)
+				continue
+			}
+			log.Info(fmt.Sprintf("Catching up %s from order %d to %d",
+				entry.indexer.Name(), tipOrder, bestOrder))
+			m.setSyncing(entry, tipOrder)
 		}
-		var block *types.SerializedBlock
-		for order > bestOrder {
-			err = m.db.Update(func(dbTx database.Tx) error {
-				// Load the block for the height since it is required to index
-				// it.
-				block, err = blockchain.DBFetchBlockByOrder(dbTx, uint64(order))
+		return nil
+	})
+}
+
+// tipOrderOf returns the order of an index tip as a signed integer, which is
+// -1 when the index does not have any entries yet.
+func tipOrderOf(order uint32) int64 {
+	if order == math.MaxUint32 {
+		return -1
+	}
+	return int64(order)
+}
+
+// rollbackIndex disconnects the blocks ahead of the current best chain tip
+// from the passed index.  This is fairly unlikely, but it can happen if the
+// chain is reorganized while the index is disabled.
+func (m *Manager) rollbackIndex(indexer Indexer, interrupt <-chan struct{}) error {
+	for done := false; !done; {
+		err := m.chain.WithChainLock(func() error {
+			bestOrder := uint32(m.chain.BestSnapshot().GraphState.GetMainOrder())
+			return m.db.Update(func(dbTx database.Tx) error {
+				idxKey := indexer.Key()
+				_, order, err := dbFetchIndexerTip(dbTx, idxKey)
 				if err != nil {
 					return err
 				}
-				spentTxos = nil
+
+				// Nothing to do if the index does not have any
+				// entries yet or isn't ahead of the chain.
+				if order == math.MaxUint32 || order <= bestOrder {
+					done = true
+					return nil
+				}
+
+				// Load the block for the order since it is
+				// required to disconnect it.
+				block, err := blockchain.DBFetchBlockByOrder(dbTx, uint64(order))
+				if err != nil {
+					return err
+				}
+				var spentTxos []blockchain.SpentTxOut
 				if indexNeedsInputs(indexer) {
-					spentTxos, err = chain.FetchSpendJournal(block)
+					spentTxos, err = m.chain.DBFetchSpendJournal(dbTx, block)
 					if err != nil {
 						return err
 					}
@@ -134,127 +259,441 @@ func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{})
 					return err
 				}
 				log.Trace(fmt.Sprintf("%s rollback order= %d", indexer.Name(), order))
-				order--
-				return nil
-			})
-			if err != nil {
+
+				// Stop when the tip couldn't be disconnected, which
+				// has already been logged.
+				_, newOrder, err := dbFetchIndexerTip(dbTx, idxKey)
+				done = newOrder == order
 				return err
-			}
-			if interruptRequested(interrupt) {
-				return errInterruptRequested
-			}
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
 		}
 	}
+	return nil
+}
 
-	// Fetch the current tip heights for each index along with tracking the
-	// lowest one so the catchup code only needs to start at the earliest
-	// block and is able to skip connecting the block for the indexes that
-	// don't need it.
+// Start begins catching up the syncing indexes in the background.
+func (m *Manager) Start() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
 
-	lowestOrder := int64(bestOrder)
-	indexerOrders := make([]int64, len(m.enabledIndexes))
-	err = m.db.View(func(dbTx database.Tx) error {
-		for i, indexer := range m.enabledIndexes {
-			idxKey := indexer.Key()
-			h, order, err := dbFetchIndexerTip(dbTx, idxKey)
-			if err != nil {
-				return err
-			}
-			orderShow := int64(order)
-			if order == math.MaxUint32 {
-				lowestOrder = -1
-				indexerOrders[i] = -1
-				orderShow = -1
-			} else if int64(order) < lowestOrder {
-				lowestOrder = int64(order)
-				indexerOrders[i] = int64(order)
-			}
-			log.Debug(fmt.Sprintf("Current %s tip", indexer.Name()),
-				"order", orderShow, "hash", h)
+	for _, entry := range m.indexes {
+		if entry.state == indexSyncing {
+			m.startSyncer()
+			return
 		}
-		return nil
-	})
-	if err != nil {
-		return err
 	}
+}
 
-	// Nothing to index if all of the indexes are caught up.
-	if lowestOrder == int64(bestOrder) {
-		return nil
+// Stop interrupts the index catch-ups and drops in progress and waits for
+// them to finish.  An interrupted drop is resumed on the next start.
+func (m *Manager) Stop() {
+	close(m.quit)
+	m.wg.Wait()
+}
+
+// startSyncer launches the goroutine catching up the syncing indexes unless it
+// is already running.
+//
+// This function MUST be called with the manager lock held.
+func (m *Manager) startSyncer() {
+	if m.syncing {
+		return
 	}
+	m.syncing = true
+	m.wg.Add(1)
+	go m.syncHandler()
+}
+
+// syncHandler connects the blocks to the syncing indexes one at a time until
+// all of them are caught up with the chain.
+//
+// It must be run as a goroutine.
+func (m *Manager) syncHandler() {
+	defer m.wg.Done()
 
-	// Create a progress logger for the indexing process below.
 	progressLogger := progresslog.NewBlockProgressLogger("Indexed", log.Root())
+	for !interruptRequested(m.quit) {
+		block, err := m.syncBlock()
+		if err != nil {
+			log.Error("Failed to catch up indexes", "error", err)
+			m.mtx.Lock()
+			for _, entry := range m.indexes {
+				if entry.state == indexSyncing {
+					m.setState(entry, indexDisabled)
+				}
+			}
+			m.syncing = false
+			m.mtx.Unlock()
+			return
+		}
+		if block == nil {
+			return
+		}
+		progressLogger.LogBlockHeight(block)
+	}
 
-	// At this point, one or more indexes are behind the current best chain
-	// tip and need to be caught up, so log the details and loop through
-	// each block that needs to be indexed.
-	log.Info(fmt.Sprintf("Catching up indexes from order %d to %d", lowestOrder,
-		bestOrder))
+	m.mtx.Lock()
+	m.syncing = false
+	m.mtx.Unlock()
+}
 
-	for order := lowestOrder + 1; order <= int64(bestOrder); order++ {
+// CatchUp connects the blocks to the syncing indexes until all of them are
+// caught up with the chain, without waiting for the manager to be started.  It
+// is used by the tools which don't run the manager in the background.
+func (m *Manager) CatchUp(interrupt <-chan struct{}) error {
+	progressLogger := progresslog.NewBlockProgressLogger("Indexed", log.Root())
+	for {
 		if interruptRequested(interrupt) {
 			return errInterruptRequested
 		}
+		block, err := m.syncBlock()
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			return nil
+		}
+		progressLogger.LogBlockHeight(block)
+	}
+}
+
+// syncBlock connects the block following the lowest tip of the syncing indexes
+// to the indexes at that tip.  The indexes reaching the current best chain tip
+// become ready.  It returns the connected block, or nil once no index is left
+// syncing, in which case the syncer is marked as stopped.
+//
+// The chain lock is held while the block is connected, so no block is
+// connected to the chain in the meantime and the indexes reaching the best
+// chain tip don't miss any block.
+func (m *Manager) syncBlock() (*types.SerializedBlock, error) {
+	var block *types.SerializedBlock
+	err := m.chain.WithChainLock(func() error {
+		bestOrder := int64(m.chain.BestSnapshot().GraphState.GetMainOrder())
+		return m.db.Update(func(dbTx database.Tx) error {
+			m.mtx.Lock()
+			defer m.mtx.Unlock()
+
+			// Fetch the tip of each syncing index along with
+			// tracking the lowest one.
+			lowestOrder := bestOrder
+			tips := make(map[*indexEntry]int64)
+			for _, entry := range m.indexes {
+				if entry.state != indexSyncing {
+					continue
+				}
+				_, order, err := dbFetchIndexerTip(dbTx, entry.indexer.Key())
+				if err != nil {
+					return err
+				}
+				tipOrder := tipOrderOf(order)
+				if tipOrder >= bestOrder {
+					log.Info(fmt.Sprintf("%s caught up to order %d",
+						entry.indexer.Name(), bestOrder))
+					m.setState(entry, indexReady)
+					continue
+				}
+				tips[entry] = tipOrder
+				if tipOrder < lowestOrder {
+					lowestOrder = tipOrder
+				}
+			}
+			if len(tips) == 0 {
+				m.syncing = false
+				return nil
+			}
 
-		var block *types.SerializedBlock
-		err = m.db.Update(func(dbTx database.Tx) error {
-			// Load the block for the height since it is required to index
-			// it.
+			// Load the block for the order since it is required to
+			// index it.
+			order := lowestOrder + 1
+			var err error
 			block, err = blockchain.DBFetchBlockByOrder(dbTx, uint64(order))
 			if err != nil {
 				return err
 			}
 
-			if interruptRequested(interrupt) {
-				return errInterruptRequested
-			}
-
-			// Connect the block for all indexes that need it.
-			spentTxos = nil
-			for i, indexer := range m.enabledIndexes {
-				// Skip indexes that don't need to be updated with this
-				// block.
-				if indexerOrders[i] >= order {
+			// Connect the block for all indexes that need it.  The
+			// indexes are connected in the order they were added
+			// since later indexes can depend on earlier ones.
+			var spentTxos []blockchain.SpentTxOut
+			var spentTxosLoaded bool
+			for _, entry := range m.indexes {
+				tipOrder, ok := tips[entry]
+				if !ok || tipOrder != lowestOrder {
 					continue
 				}
 
 				// When the index requires all of the referenced
 				// txouts and they haven't been loaded yet, they
-				// need to be retrieved from the transaction
-				// index.
-				if spentTxos == nil && indexNeedsInputs(indexer) {
-					spentTxos, err = chain.FetchSpendJournal(block)
+				// need to be retrieved from the spend journal.
+				if !spentTxosLoaded && indexNeedsInputs(entry.indexer) {
+					spentTxos, err = m.chain.DBFetchSpendJournal(dbTx, block)
 					if err != nil {
 						return err
 					}
+					spentTxosLoaded = true
 				}
-				err = dbIndexConnectBlock(dbTx, indexer, block, spentTxos)
+				err = dbIndexConnectBlock(dbTx, entry.indexer, block, spentTxos)
 				if err != nil {
 					return err
 				}
 
-				indexerOrders[i] = order
+				if order == bestOrder {
+					log.Info(fmt.Sprintf("%s caught up to order %d",
+						entry.indexer.Name(), bestOrder))
+					m.setState(entry, indexReady)
+				}
 			}
-
 			return nil
 		})
-		if err != nil {
+	})
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// lookupIndex returns the index known to the manager with the passed name or
+// key, or nil when there is none.
+//
+// This function MUST be called with the manager lock held.
+func (m *Manager) lookupIndex(name string) *indexEntry {
+	for _, entry := range m.indexes {
+		if entry.indexer.Name() == name || string(entry.indexer.Key()) == name {
+			return entry
+		}
+	}
+	return nil
+}
+
+// requiresTxIndex returns whether the passed index relies on the block IDs of
+// the transaction index.
+func requiresTxIndex(indexer Indexer) bool {
+	switch indexer.(type) {
+	case *AddrIndex, *SpentIndex, *NullDataIndex:
+		return true
+	}
+	return false
+}
+
+// StartIndex enables the index with the passed name or key while the node is
+// running.  The index is created as needed and caught up with the chain in the
+// background, while its queries fail with ErrIndexSyncing.  The transaction
+// index is started as well when the index requires it.
+//
+// This function is safe for concurrent access.
+func (m *Manager) StartIndex(name string) error {
+	m.mtx.Lock()
+	entry := m.lookupIndex(name)
+	var state, txState indexState
+	var txEntry *indexEntry
+	if entry != nil {
+		state = entry.state
+		if requiresTxIndex(entry.indexer) {
+			txEntry = m.lookupIndex(txIndexName)
+			if txEntry != nil {
+				txState = txEntry.state
+			}
+		}
+	}
+	m.mtx.Unlock()
+
+	if entry == nil {
+		return fmt.Errorf("unknown index %q", name)
+	}
+	if state != indexDisabled {
+		return fmt.Errorf("%s is already %v", entry.indexer.Name(), state)
+	}
+	if requiresTxIndex(entry.indexer) {
+		if txEntry == nil {
+			return fmt.Errorf("%s requires the %s", entry.indexer.Name(),
+				txIndexName)
+		}
+		switch txState {
+		case indexDropping:
+			return fmt.Errorf("%s requires the %s, which is being dropped",
+				entry.indexer.Name(), txIndexName)
+		case indexDisabled:
+			if err := m.startIndex(txEntry); err != nil {
+				return err
+			}
+		}
+	}
+	return m.startIndex(entry)
+}
+
+// startIndex creates the passed index as needed, rolls it back to the main
+// chain and marks it as syncing.
+func (m *Manager) startIndex(entry *indexEntry) error {
+	indexer := entry.indexer
+
+	// Create the index unless it is left from a previous run, in which
+	// case it is caught up from its tip.
+	err := m.db.Update(func(dbTx database.Tx) error {
+		idxKey := indexer.Key()
+		indexesBucket := dbTx.Metadata().Bucket(dbnamespace.IndexTipsBucketName)
+		if indexesBucket.Get(idxKey) != nil {
+			return nil
+		}
+		if err := indexer.Create(dbTx); err != nil {
 			return err
 		}
-		progressLogger.LogBlockHeight(block)
+		return dbPutIndexerTip(dbTx, idxKey, &hash.ZeroHash, math.MaxUint32)
+	})
+	if err != nil {
+		return err
+	}
+	if err := indexer.Init(); err != nil {
+		return err
+	}
+	if err := m.rollbackIndex(indexer, m.quit); err != nil {
+		return err
 	}
 
-	log.Info(fmt.Sprintf("Indexes caught up to order %d", bestOrder))
+	var tipOrder int64
+	err = m.db.View(func(dbTx database.Tx) error {
+		_, order, err := dbFetchIndexerTip(dbTx, indexer.Key())
+		tipOrder = tipOrderOf(order)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if entry.state != indexDisabled {
+		return fmt.Errorf("%s is already %v", indexer.Name(), entry.state)
+	}
+	log.Info(fmt.Sprintf("Starting %s from order %d", indexer.Name(), tipOrder))
+	m.setSyncing(entry, tipOrder)
+	m.startSyncer()
 	return nil
 }
 
-// maybeFinishDrops determines if each of the enabled indexes are in the middle
+// DropIndex disables the index with the passed name or key and removes it from
+// the database in the background while the node is running.  Dropping the
+// transaction index drops the indexes requiring it as well.
+//
+// This function is safe for concurrent access.
+func (m *Manager) DropIndex(name string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	entry := m.lookupIndex(name)
+	if entry == nil {
+		return fmt.Errorf("unknown index %q", name)
+	}
+	if entry.state == indexDropping {
+		return fmt.Errorf("%s is already being dropped", entry.indexer.Name())
+	}
+
+	// The indexes requiring the transaction index are dropped first.
+	var drops []*indexEntry
+	if _, ok := entry.indexer.(*TxIndex); ok {
+		for _, dep := range m.indexes {
+			if requiresTxIndex(dep.indexer) && dep.state != indexDropping {
+				drops = append(drops, dep)
+			}
+		}
+	}
+	drops = append(drops, entry)
+	for _, drop := range drops {
+		m.setState(drop, indexDropping)
+	}
+
+	m.wg.Add(1)
+	go m.dropHandler(drops)
+	return nil
+}
+
+// dropHandler removes the passed indexes from the database and marks them as
+// disabled.  An interrupted drop is resumed on the next start.
+//
+// It must be run as a goroutine.
+func (m *Manager) dropHandler(drops []*indexEntry) {
+	defer m.wg.Done()
+
+	for _, entry := range drops {
+		indexer := entry.indexer
+		var err error
+		if dropper, ok := indexer.(IndexDropper); ok {
+			err = dropper.DropIndex(m.db, m.quit)
+		} else {
+			err = dropIndex(m.db, indexer.Key(), indexer.Name(), m.quit)
+		}
+		if err != nil {
+			log.Error(fmt.Sprintf("Failed to drop %s", indexer.Name()),
+				"error", err)
+		}
+
+		m.mtx.Lock()
+		m.setState(entry, indexDisabled)
+		m.mtx.Unlock()
+	}
+}
+
+// IndexInfo returns the state of every index known to the manager along with
+// the current best chain order.
+//
+// This function is safe for concurrent access.
+func (m *Manager) IndexInfo() ([]IndexInfo, int64, error) {
+	bestOrder := int64(m.chain.BestSnapshot().GraphState.GetMainOrder())
+
+	var infos []IndexInfo
+	err := m.db.View(func(dbTx database.Tx) error {
+		m.mtx.Lock()
+		defer m.mtx.Unlock()
+
+		indexesBucket := dbTx.Metadata().Bucket(dbnamespace.IndexTipsBucketName)
+		for _, entry := range m.indexes {
+			info := IndexInfo{
+				Name:     entry.indexer.Name(),
+				Key:      string(entry.indexer.Key()),
+				State:    entry.state.String(),
+				TipOrder: -1,
+			}
+
+			// The tip only exists once the index is created.
+			if indexesBucket != nil && indexesBucket.Get(entry.indexer.Key()) != nil {
+				h, order, err := dbFetchIndexerTip(dbTx, entry.indexer.Key())
+				if err != nil {
+					return err
+				}
+				info.TipOrder = tipOrderOf(order)
+				if info.TipOrder >= 0 {
+					info.TipHash = h
+				}
+			}
+
+			// Estimate the time left from the rate the index
+			// caught up since it started syncing.
+			if entry.state == indexSyncing {
+				indexed := info.TipOrder - entry.syncStartOrder
+				if indexed > 0 {
+					elapsed := time.Since(entry.syncStart)
+					info.ETA = time.Duration(int64(elapsed) /
+						indexed * (bestOrder - info.TipOrder))
+				}
+			}
+			infos = append(infos, info)
+		}
+		return nil
+	})
+	return infos, bestOrder, err
+}
+
+// maybeFinishDrops determines if each of the known indexes are in the middle
 // of being dropped and finishes dropping them when the are.  This is necessary
 // because dropping and index has to be done in several atomic steps rather than
 // one big atomic step due to the massive number of entries.
 func (m *Manager) maybeFinishDrops(interrupt <-chan struct{}) error {
-	indexNeedsDrop := make([]bool, len(m.enabledIndexes))
+	indexNeedsDrop := make([]bool, len(m.indexes))
 	err := m.db.View(func(dbTx database.Tx) error {
 		// None of the indexes needs to be dropped if the index tips
 		// bucket hasn't been created yet.
@@ -265,8 +704,8 @@ func (m *Manager) maybeFinishDrops(interrupt <-chan struct{}) error {
 
 		// Mark the indexer as requiring a drop if one is already in
 		// progress.
-		for i, indexer := range m.enabledIndexes {
-			dropKey := indexDropKey(indexer.Key())
+		for i, entry := range m.indexes {
+			dropKey := indexDropKey(entry.indexer.Key())
 			if indexesBucket.Get(dropKey) != nil {
 				indexNeedsDrop[i] = true
 			}
@@ -282,15 +721,21 @@ func (m *Manager) maybeFinishDrops(interrupt <-chan struct{}) error {
 		return errInterruptRequested
 	}
 
-	// Finish dropping any of the enabled indexes that are already in the
+	// Finish dropping any of the known indexes that are already in the
 	// middle of being dropped.
-	for i, indexer := range m.enabledIndexes {
+	for i, entry := range m.indexes {
 		if !indexNeedsDrop[i] {
 			continue
 		}
 
+		indexer := entry.indexer
 		log.Info(fmt.Sprintf("Resuming %s drop", indexer.Name()))
-		err := dropIndex(m.db, indexer.Key(), indexer.Name(), interrupt)
+		var err error
+		if dropper, ok := indexer.(IndexDropper); ok {
+			err = dropper.DropIndex(m.db, interrupt)
+		} else {
+			err = dropIndex(m.db, indexer.Key(), indexer.Name(), interrupt)
+		}
 		if err != nil {
 			return err
 		}
@@ -341,15 +786,40 @@ func (m *Manager) maybeCreateIndexes(dbTx database.Tx) error {
 // keeps track of the state of each index it is managing, performs some sanity
 // checks, and invokes each indexer.
 //
+// The syncing indexes are only connected once the block extends their tip,
+// which makes them ready.
+//
 // This is part of the blockchain.IndexManager interface.
 func (m *Manager) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
 	// Call each of the currently active optional indexes with the block
 	// being connected so they can update accordingly.
-	for _, index := range m.enabledIndexes {
-		err := dbIndexConnectBlock(dbTx, index, block, stxos)
+	for _, entry := range m.indexes {
+		switch entry.state {
+		case indexReady:
+		case indexSyncing:
+			_, order, err := dbFetchIndexerTip(dbTx, entry.indexer.Key())
+			if err != nil {
+				return err
+			}
+			if tipOrderOf(order)+1 != int64(block.Order()) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		err := dbIndexConnectBlock(dbTx, entry.indexer, block, stxos)
 		if err != nil {
 			return err
 		}
+		if entry.state == indexSyncing {
+			log.Info(fmt.Sprintf("%s caught up to order %d",
+				entry.indexer.Name(), block.Order()))
+			m.setState(entry, indexReady)
+		}
 	}
 	return nil
 }
@@ -359,12 +829,34 @@ func (m *Manager) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, s
 // managing, performs some sanity checks, and invokes each indexer to remove
 // the index entries associated with the block.
 //
+// The syncing indexes are only disconnected when the block is their tip.
+//
 // This is part of the blockchain.IndexManager interface.
 func (m *Manager) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
 	// Call each of the currently active optional indexes with the block
-	// being disconnected so they can update accordingly.
-	for _, index := range m.enabledIndexes {
-		err := m.dbIndexDisconnectBlock(dbTx, index, block, stxos)
+	// being disconnected so they can update accordingly.  This has to be
+	// done in reverse order because later indexes can depend on earlier
+	// ones.
+	for i := len(m.indexes); i > 0; i-- {
+		entry := m.indexes[i-1]
+		switch entry.state {
+		case indexReady:
+		case indexSyncing:
+			h, _, err := dbFetchIndexerTip(dbTx, entry.indexer.Key())
+			if err != nil {
+				return err
+			}
+			if !h.IsEqual(block.Hash()) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		err := m.dbIndexDisconnectBlock(dbTx, entry.indexer, block, stxos)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,267 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+)
+
+const (
+	// nullDataIndexName is the human-readable name for the index.
+	nullDataIndexName = "null data index"
+
+	// NullDataPrefixSize is the number of leading bytes of the payloads the
+	// null data index is keyed by.  Shorter payloads are padded with zeros.
+	NullDataPrefixSize = 8
+
+	// nullDataKeySize is the size of a key in the null data index.  It
+	// consists of the payload prefix, the block ID, the index of the
+	// transaction in the block and the output index.
+	nullDataKeySize = NullDataPrefixSize + 4 + 4 + 4
+
+	// nullDataEntryHeaderSize is the size of a value in the null data
+	// index without the payload.  It consists of the offset and the length
+	// of the transaction in the block.
+	nullDataEntryHeaderSize = 4 + 4
+)
+
+var (
+	// nullDataIndexKey is the key of the null data index and the db bucket
+	// used to house it.
+	nullDataIndexKey = []byte("nulldataidx")
+)
+
+// -----------------------------------------------------------------------------
+// The null data index maps the payloads of the null data outputs to the
+// regions of their transactions.  The block of a transaction is identified by
+// the internal block ID of the transaction index, which is why the null data
+// index requires it.
+//
+// The serialized format for the keys and values in the null data index bucket
+// is:
+//
+//   <prefix><block id><tx index><index> = <start offset><tx length><payload>
+//
+//   Field           Type              Size
+//   prefix          [8]byte           8 bytes
+//   block id        uint32            4 bytes
+//   tx index        uint32            4 bytes
+//   index           uint32            4 bytes
+//   start offset    uint32            4 bytes
+//   tx length       uint32            4 bytes
+//   payload         []byte            variable
+//
+// The numbers in the keys are big endian so the cursor iterates the entries
+// with the same prefix in the order their blocks were indexed.
+// -----------------------------------------------------------------------------
+
+// NullData is the payload of a null data output along with the region of the
+// transaction in its block.
+type NullData struct {
+	Region   database.BlockRegion
+	OutIndex uint32
+	Payload  []byte
+}
+
+// nullDataKey returns the key of the passed null data output in the index.
+func nullDataKey(payload []byte, blockID uint32, txIdx int, index uint32) []byte {
+	key := make([]byte, nullDataKeySize)
+	copy(key[:NullDataPrefixSize], payload)
+	binary.BigEndian.PutUint32(key[NullDataPrefixSize:], blockID)
+	binary.BigEndian.PutUint32(key[NullDataPrefixSize+4:], uint32(txIdx))
+	binary.BigEndian.PutUint32(key[NullDataPrefixSize+8:], index)
+	return key
+}
+
+// NullDataIndex implements an index of the payloads of the null data outputs,
+// keyed by their leading bytes.  That is to say, it supports searching the
+// transactions carrying data with a given prefix, such as a protocol tag.
+type NullDataIndex struct {
+	indexStatus
+	db database.DB
+}
+
+// Ensure the NullDataIndex type implements the Indexer interface.
+var _ Indexer = (*NullDataIndex)(nil)
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *NullDataIndex) Init() error {
+	// Nothing to do.
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *NullDataIndex) Key() []byte {
+	return nullDataIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *NullDataIndex) Name() string {
+	return nullDataIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the null data
+// index.
+//
+// This is part of the Indexer interface.
+func (idx *NullDataIndex) Create(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucket(nullDataIndexKey)
+	return err
+}
+
+// forEachNullData calls the passed function with the payload of every null data
+// output of the passed block.  The outputs with an empty payload are skipped.
+func forEachNullData(block *types.SerializedBlock, fn func(txIdx int, index uint32, payload []byte) error) error {
+	for txIdx, tx := range block.Transactions() {
+		for index, txOut := range tx.Transaction().TxOut {
+			payload, ok := txscript.ExtractNullData(txOut.PkScript)
+			if !ok || len(payload) == 0 {
+				continue
+			}
+			if err := fn(txIdx, uint32(index), payload); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer adds a mapping from the payload of
+// every null data output of the block to its transaction.
+//
+// This is part of the Indexer interface.
+func (idx *NullDataIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	// The offset and length of the transactions within the serialized
+	// block.
+	txLocs, err := block.TxLoc()
+	if err != nil {
+		return err
+	}
+	blockID, err := dbFetchBlockIDByHash(dbTx, block.Hash())
+	if err != nil {
+		return err
+	}
+
+	bucket := dbTx.Metadata().Bucket(nullDataIndexKey)
+	return forEachNullData(block, func(txIdx int, index uint32, payload []byte) error {
+		serialized := make([]byte, nullDataEntryHeaderSize+len(payload))
+		byteOrder.PutUint32(serialized, uint32(txLocs[txIdx].TxStart))
+		byteOrder.PutUint32(serialized[4:], uint32(txLocs[txIdx].TxLen))
+		copy(serialized[nullDataEntryHeaderSize:], payload)
+		return bucket.Put(nullDataKey(payload, blockID, txIdx, index),
+			serialized)
+	})
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the mappings of the
+// null data outputs of the block.
+//
+// This is part of the Indexer interface.
+func (idx *NullDataIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	blockID, err := dbFetchBlockIDByHash(dbTx, block.Hash())
+	if err != nil {
+		return err
+	}
+
+	bucket := dbTx.Metadata().Bucket(nullDataIndexKey)
+	return forEachNullData(block, func(txIdx int, index uint32, payload []byte) error {
+		return bucket.Delete(nullDataKey(payload, blockID, txIdx, index))
+	})
+}
+
+// SearchNullData returns the null data outputs with a payload starting with
+// the passed prefix, in the order their blocks were indexed.  The first
+// numToSkip outputs are skipped and at most numRequested outputs are returned,
+// along with the number of outputs actually skipped.
+//
+// NOTE: This result only includes the transactions confirmed in blocks.
+//
+// This function is safe for concurrent access.
+func (idx *NullDataIndex) SearchNullData(prefix []byte, numToSkip, numRequested uint32) ([]*NullData, uint32, error) {
+	// Only the leading bytes of the payloads are part of the keys, the
+	// rest of a longer prefix is matched against the payloads.
+	keyPrefix := prefix
+	if len(keyPrefix) > NullDataPrefixSize {
+		keyPrefix = keyPrefix[:NullDataPrefixSize]
+	}
+
+	var results []*NullData
+	var skipped uint32
+	err := idx.db.View(func(dbTx database.Tx) error {
+		cursor := dbTx.Metadata().Bucket(nullDataIndexKey).Cursor()
+		for ok := cursor.Seek(keyPrefix); ok &&
+			uint32(len(results)) < numRequested; ok = cursor.Next() {
+
+			key := cursor.Key()
+			if !bytes.HasPrefix(key, keyPrefix) {
+				break
+			}
+			serialized := cursor.Value()
+			if len(key) != nullDataKeySize ||
+				len(serialized) < nullDataEntryHeaderSize {
+				return errDeserialize("unexpected end of data")
+			}
+			payload := serialized[nullDataEntryHeaderSize:]
+			if !bytes.HasPrefix(payload, prefix) {
+				continue
+			}
+			if skipped < numToSkip {
+				skipped++
+				continue
+			}
+
+			blockID := binary.BigEndian.Uint32(key[NullDataPrefixSize:])
+			blockHash, err := dbFetchBlockHashByID(dbTx, blockID)
+			if err != nil {
+				return err
+			}
+			nullData := &NullData{
+				Region: database.BlockRegion{
+					Hash:   blockHash,
+					Offset: byteOrder.Uint32(serialized),
+					Len:    byteOrder.Uint32(serialized[4:]),
+				},
+				OutIndex: binary.BigEndian.Uint32(key[NullDataPrefixSize+8:]),
+				Payload:  make([]byte, len(payload)),
+			}
+			copy(nullData.Payload, payload)
+			results = append(results, nullData)
+		}
+		return nil
+	})
+	return results, skipped, err
+}
+
+// NewNullDataIndex returns a new instance of an indexer that is used to create
+// a mapping of the payloads of the null data outputs to their transactions.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewNullDataIndex(db database.DB) *NullDataIndex {
+	return &NullDataIndex{db: db}
+}
+
+// DropNullDataIndex drops the null data index from the provided database if it
+// exists.
+func DropNullDataIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, nullDataIndexKey, nullDataIndexName, interrupt)
+}
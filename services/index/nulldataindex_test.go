@@ -0,0 +1,152 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+)
+
+// TestNullDataIndex ensures the payloads of the null data outputs are found by
+// prefix, including prefixes shorter and longer than the part of the payloads
+// the index is keyed by, with the transaction regions of their blocks, that
+// the other outputs aren't indexed, and that the payloads of a disconnected
+// block are removed.
+func TestNullDataIndex(t *testing.T) {
+	db, teardown := newTestIndexDB(t)
+	defer teardown()
+	idx := NewNullDataIndex(db)
+	err := db.Update(func(dbTx database.Tx) error {
+		return idx.Create(dbTx)
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	prevHash := hash.HashH([]byte("null data"))
+	nullDataTx := func(payloads ...[]byte) *types.Transaction {
+		tx := types.NewTransaction()
+		tx.AddTxIn(types.NewTxInput(types.NewOutPoint(&prevHash, 0),
+			[]byte{0x51}))
+		for _, payload := range payloads {
+			builder := txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN)
+			if payload != nil {
+				builder.AddData(payload)
+			}
+			script, err := builder.Script()
+			if err != nil {
+				t.Fatalf("failed to build null data script: %v", err)
+			}
+			tx.AddTxOut(types.NewTxOutput(0, script))
+		}
+		tx.AddTxOut(types.NewTxOutput(1e8, []byte{0x51}))
+		return tx
+	}
+	tagged := nullDataTx([]byte("QTAG:hello"), []byte("QTAG:world"))
+	others := nullDataTx(nil, []byte("QT"), []byte("OTHER"))
+	first := testIndexBlock(t, db, 1, time.Unix(1570000000, 0), tagged,
+		others)
+	second := testIndexBlock(t, db, 2, time.Unix(1570000120, 0),
+		nullDataTx([]byte("QTAG:hello again")))
+	err = db.Update(func(dbTx database.Tx) error {
+		for _, block := range []*types.SerializedBlock{first, second} {
+			if err := idx.ConnectBlock(dbTx, block, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ConnectBlock: %v", err)
+	}
+
+	// An output is identified by its block, the index of its transaction
+	// and its own index.
+	type output struct {
+		block   *types.SerializedBlock
+		txIdx   int
+		index   uint32
+		payload string
+	}
+	hello := output{first, 1, 0, "QTAG:hello"}
+	world := output{first, 1, 1, "QTAG:world"}
+	short := output{first, 2, 1, "QT"}
+	again := output{second, 1, 0, "QTAG:hello again"}
+	tests := []struct {
+		name    string
+		prefix  string
+		skip    uint32
+		count   uint32
+		want    []output
+		skipped uint32
+	}{
+		{"tag", "QTAG", 0, 10, []output{hello, again, world}, 0},
+		{"prefix longer than the key", "QTAG:hello", 0, 10,
+			[]output{hello, again}, 0},
+		{"prefix longer than a payload", "QTAG:hello ", 0, 10,
+			[]output{again}, 0},
+		{"short payload", "QT", 0, 10,
+			[]output{short, hello, again, world}, 0},
+		{"skipped and limited", "QTAG", 1, 1, []output{again}, 1},
+		{"skipped past the end", "QTAG", 5, 10, nil, 3},
+		{"unknown prefix", "Z", 0, 10, nil, 0},
+	}
+	check := func(name string, results []*NullData, skipped uint32, want []output, wantSkipped uint32) {
+		t.Helper()
+		if len(results) != len(want) || skipped != wantSkipped {
+			t.Errorf("%s: found %d payloads and skipped %d, want %d and "+
+				"%d", name, len(results), skipped, len(want),
+				wantSkipped)
+			return
+		}
+		for i, result := range results {
+			want := want[i]
+			txLocs, err := want.block.TxLoc()
+			if err != nil {
+				t.Fatalf("%s: TxLoc: %v", name, err)
+			}
+			loc := txLocs[want.txIdx]
+			if !bytes.Equal(result.Payload, []byte(want.payload)) ||
+				result.OutIndex != want.index ||
+				!result.Region.Hash.IsEqual(want.block.Hash()) ||
+				result.Region.Offset != uint32(loc.TxStart) ||
+				result.Region.Len != uint32(loc.TxLen) {
+				t.Errorf("%s: result %d is %q output %d at %d+%d of "+
+					"block %v, want %q output %d at %d+%d of %v",
+					name, i, result.Payload, result.OutIndex,
+					result.Region.Offset, result.Region.Len,
+					result.Region.Hash, want.payload, want.index,
+					loc.TxStart, loc.TxLen, want.block.Hash())
+			}
+		}
+	}
+	for _, test := range tests {
+		results, skipped, err := idx.SearchNullData([]byte(test.prefix),
+			test.skip, test.count)
+		if err != nil {
+			t.Errorf("%s: SearchNullData: %v", test.name, err)
+			continue
+		}
+		check(test.name, results, skipped, test.want, test.skipped)
+	}
+
+	err = db.Update(func(dbTx database.Tx) error {
+		return idx.DisconnectBlock(dbTx, second, nil)
+	})
+	if err != nil {
+		t.Fatalf("DisconnectBlock: %v", err)
+	}
+	results, skipped, err := idx.SearchNullData([]byte("QTAG"), 0, 10)
+	if err != nil {
+		t.Fatalf("SearchNullData: %v", err)
+	}
+	check("disconnected", results, skipped, []output{hello, world}, 0)
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"fmt"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+)
+
+const (
+	// spentIndexName is the human-readable name for the index.
+	spentIndexName = "spent index"
+
+	// spentKeySize is the size of a key in the spent index.  It consists
+	// of the hash of the transaction and the index of the spent output.
+	spentKeySize = hash.HashSize + 4
+
+	// spentEntrySize is the size of a value in the spent index.  It
+	// consists of the hash of the spending transaction, the index of the
+	// spending input and the block ID of the spending block.
+	spentEntrySize = hash.HashSize + 4 + 4
+)
+
+var (
+	// spentIndexKey is the key of the spent index and the db bucket used to
+	// house it.
+	spentIndexKey = []byte("spentidx")
+)
+
+// -----------------------------------------------------------------------------
+// The spent index maps every spent output to the input spending it.  The block
+// of the spending transaction is identified by the internal block ID of the
+// transaction index, which is why the spent index requires it.
+//
+// The serialized format for the keys and values in the spent index bucket is:
+//
+//   <txhash><index> = <spender hash><input index><block id>
+//
+//   Field           Type              Size
+//   txhash          hash.Hash         32 bytes
+//   index           uint32            4 bytes
+//   spender hash    hash.Hash         32 bytes
+//   input index     uint32            4 bytes
+//   block id        uint32            4 bytes
+//   -----
+//   Total: 76 bytes
+// -----------------------------------------------------------------------------
+
+// SpendingTx identifies the input spending an output.  The block hash is nil
+// for the transactions in the memory pool.
+type SpendingTx struct {
+	TxHash    hash.Hash
+	InIndex   uint32
+	BlockHash *hash.Hash
+}
+
+// spentKey returns the key of the passed outpoint in the spent index.
+func spentKey(outPoint *types.TxOutPoint) []byte {
+	key := make([]byte, spentKeySize)
+	copy(key, outPoint.Hash[:])
+	byteOrder.PutUint32(key[hash.HashSize:], outPoint.OutIndex)
+	return key
+}
+
+// SpentIndex implements an index of the inputs spending the outputs.  That is
+// to say, it supports querying which transaction spent an output.
+type SpentIndex struct {
+	indexStatus
+	db database.DB
+}
+
+// Ensure the SpentIndex type implements the Indexer interface.
+var _ Indexer = (*SpentIndex)(nil)
+
+// Ensure the SpentIndex type implements the NeedsInputser interface.
+var _ NeedsInputser = (*SpentIndex)(nil)
+
+// Ensure the SpentIndex type implements the IndexDropper interface.
+var _ IndexDropper = (*SpentIndex)(nil)
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index.  The outputs a block spends are only known
+// from them, since the inputs of the blocks known to be invalid don't spend
+// anything.
+//
+// This implements the NeedsInputser interface.
+func (idx *SpentIndex) NeedsInputs() bool {
+	return true
+}
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *SpentIndex) Init() error {
+	// Nothing to do.
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *SpentIndex) Key() []byte {
+	return spentIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *SpentIndex) Name() string {
+	return spentIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the spent
+// index.
+//
+// This is part of the Indexer interface.
+func (idx *SpentIndex) Create(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucket(spentIndexKey)
+	return err
+}
+
+// spendsOutputs returns whether the inputs of the passed block spent outputs,
+// which is the case when there is a stxo for each of them.
+func spendsOutputs(block *types.SerializedBlock, stxos []blockchain.SpentTxOut) bool {
+	var numSpent int
+	for _, tx := range block.Transactions()[1:] {
+		numSpent += len(tx.Transaction().TxIn)
+	}
+	return numSpent > 0 && numSpent == len(stxos)
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer adds a mapping from every output
+// spent by the block to the input spending it.
+//
+// This is part of the Indexer interface.
+func (idx *SpentIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	if !spendsOutputs(block, stxos) {
+		return nil
+	}
+	blockID, err := dbFetchBlockIDByHash(dbTx, block.Hash())
+	if err != nil {
+		return err
+	}
+
+	bucket := dbTx.Metadata().Bucket(spentIndexKey)
+	for _, tx := range block.Transactions()[1:] {
+		for inIdx, txIn := range tx.Transaction().TxIn {
+			serialized := make([]byte, spentEntrySize)
+			copy(serialized, tx.Hash()[:])
+			byteOrder.PutUint32(serialized[hash.HashSize:], uint32(inIdx))
+			byteOrder.PutUint32(serialized[hash.HashSize+4:], blockID)
+			err := bucket.Put(spentKey(&txIn.PreviousOut), serialized)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the mapping of every
+// output spent by the block.
+//
+// This is part of the Indexer interface.
+func (idx *SpentIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	if !spendsOutputs(block, stxos) {
+		return nil
+	}
+
+	bucket := dbTx.Metadata().Bucket(spentIndexKey)
+	for _, tx := range block.Transactions()[1:] {
+		for _, txIn := range tx.Transaction().TxIn {
+			err := bucket.Delete(spentKey(&txIn.PreviousOut))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SpendingTx returns the input of a transaction in the blockchain spending the
+// passed output.  When the output isn't spent, nil will be returned for both
+// the input and the error.
+//
+// NOTE: This result only includes the transactions confirmed in blocks.
+//
+// This function is safe for concurrent access.
+func (idx *SpentIndex) SpendingTx(outPoint *types.TxOutPoint) (*SpendingTx, error) {
+	var spender *SpendingTx
+	err := idx.db.View(func(dbTx database.Tx) error {
+		serialized := dbTx.Metadata().Bucket(spentIndexKey).Get(
+			spentKey(outPoint))
+		if serialized == nil {
+			return nil
+		}
+		if len(serialized) < spentEntrySize {
+			return database.Error{
+				ErrorCode: database.ErrCorruption,
+				Description: fmt.Sprintf("corrupt spent index "+
+					"entry for %v", outPoint),
+			}
+		}
+
+		blockHash, err := dbFetchBlockHashBySerializedID(dbTx,
+			serialized[hash.HashSize+4:spentEntrySize])
+		if err != nil {
+			return database.Error{
+				ErrorCode: database.ErrCorruption,
+				Description: fmt.Sprintf("corrupt spent index "+
+					"entry for %v: %v", outPoint, err),
+			}
+		}
+		spender = &SpendingTx{
+			InIndex:   byteOrder.Uint32(serialized[hash.HashSize:]),
+			BlockHash: blockHash,
+		}
+		copy(spender.TxHash[:], serialized)
+		return nil
+	})
+	return spender, err
+}
+
+// DropIndex drops the spent index from the provided database if it exists.
+//
+// This implements the IndexDropper interface.
+func (idx *SpentIndex) DropIndex(db database.DB, interrupt <-chan struct{}) error {
+	return DropSpentIndex(db, interrupt)
+}
+
+// NewSpentIndex returns a new instance of an indexer that is used to create a
+// mapping of every spent output to the input spending it.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewSpentIndex(db database.DB) *SpentIndex {
+	return &SpentIndex{db: db}
+}
+
+// DropSpentIndex drops the spent index from the provided database if it
+// exists.
+func DropSpentIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, spentIndexKey, spentIndexName, interrupt)
+}
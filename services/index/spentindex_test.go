@@ -0,0 +1,178 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/merkle"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+// newTestIndexDB returns a new database in a temporary directory with the
+// buckets of the block IDs of the transaction index, along with a function to
+// close and remove it.
+func newTestIndexDB(t *testing.T) (database.DB, func()) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	db, err := database.Create("ffldb", dir, params.PrivNetParams.Net)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to create database: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+	err = db.Update(func(dbTx database.Tx) error {
+		return NewTxIndex(db).Create(dbTx)
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("failed to create the transaction index: %v", err)
+	}
+	return db, teardown
+}
+
+// testIndexBlock returns a block holding a coinbase followed by the passed
+// transactions, which has the passed block ID in the transaction index.  The
+// coinbase pays an amount depending on the ID, so every block has its own
+// hash.
+func testIndexBlock(t *testing.T, db database.DB, id uint32, timestamp time.Time, txns ...*types.Transaction) *types.SerializedBlock {
+	coinbase := types.NewTransaction()
+	coinbase.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{},
+		types.MaxPrevOutIndex), nil))
+	coinbase.AddTxOut(types.NewTxOutput(1e8+uint64(id), []byte{0x51}))
+	block := &types.Block{Header: types.BlockHeader{
+		Version:   1,
+		Timestamp: timestamp,
+	}}
+	block.AddTransaction(coinbase)
+	for _, tx := range txns {
+		block.AddTransaction(tx)
+	}
+	sblock := types.NewBlock(block)
+	merkles := merkle.BuildMerkleTreeStore(sblock.Transactions(), false)
+	block.Header.TxRoot = *merkles[len(merkles)-1]
+	sblock = types.NewBlock(block)
+	err := db.Update(func(dbTx database.Tx) error {
+		return dbPutBlockIDIndexEntry(dbTx, sblock.Hash(), id)
+	})
+	if err != nil {
+		t.Fatalf("failed to add the block ID: %v", err)
+	}
+	return sblock
+}
+
+// TestSpentIndex ensures the outputs spent by a connected block map to the
+// transaction and the input spending them in the block, that nothing is
+// indexed for a block whose inputs don't spend anything, and that the
+// mappings are removed when the block is disconnected.
+func TestSpentIndex(t *testing.T) {
+	db, teardown := newTestIndexDB(t)
+	defer teardown()
+	idx := NewSpentIndex(db)
+	err := db.Update(func(dbTx database.Tx) error {
+		return idx.Create(dbTx)
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	prevHashes := []hash.Hash{hash.HashH([]byte{1}), hash.HashH([]byte{2})}
+	spendTx := func(prevOuts ...*types.TxOutPoint) *types.Transaction {
+		tx := types.NewTransaction()
+		for _, prevOut := range prevOuts {
+			tx.AddTxIn(types.NewTxInput(prevOut, []byte{0x51}))
+		}
+		tx.AddTxOut(types.NewTxOutput(1e8, []byte{0x51}))
+		return tx
+	}
+	first := spendTx(types.NewOutPoint(&prevHashes[0], 0),
+		types.NewOutPoint(&prevHashes[0], 1))
+	second := spendTx(types.NewOutPoint(&prevHashes[1], 3))
+	block := testIndexBlock(t, db, 1, time.Unix(1570000000, 0), first,
+		second)
+	invalid := testIndexBlock(t, db, 2, time.Unix(1570000120, 0),
+		spendTx(types.NewOutPoint(&prevHashes[1], 0)))
+	err = db.Update(func(dbTx database.Tx) error {
+		err := idx.ConnectBlock(dbTx, block, make([]blockchain.SpentTxOut, 3))
+		if err != nil {
+			return err
+		}
+		return idx.ConnectBlock(dbTx, invalid, nil)
+	})
+	if err != nil {
+		t.Fatalf("ConnectBlock: %v", err)
+	}
+
+	firstHash, secondHash := first.TxHash(), second.TxHash()
+	tests := []struct {
+		name     string
+		outPoint *types.TxOutPoint
+		spender  *hash.Hash
+		inIndex  uint32
+	}{
+		{"first input", types.NewOutPoint(&prevHashes[0], 0), &firstHash, 0},
+		{"second input", types.NewOutPoint(&prevHashes[0], 1), &firstHash, 1},
+		{"other transaction", types.NewOutPoint(&prevHashes[1], 3),
+			&secondHash, 0},
+		{"unspent output", types.NewOutPoint(&prevHashes[0], 2), nil, 0},
+		{"spent by an invalid block", types.NewOutPoint(&prevHashes[1], 0),
+			nil, 0},
+	}
+	for _, test := range tests {
+		spender, err := idx.SpendingTx(test.outPoint)
+		if err != nil {
+			t.Errorf("%s: SpendingTx: %v", test.name, err)
+			continue
+		}
+		if test.spender == nil {
+			if spender != nil {
+				t.Errorf("%s: output spent by %v", test.name,
+					spender.TxHash)
+			}
+			continue
+		}
+		if spender == nil {
+			t.Errorf("%s: output is unspent, want spent by %v",
+				test.name, test.spender)
+			continue
+		}
+		if spender.TxHash != *test.spender ||
+			spender.InIndex != test.inIndex ||
+			!spender.BlockHash.IsEqual(block.Hash()) {
+			t.Errorf("%s: spent by %v:%d in block %v, want %v:%d in "+
+				"block %v", test.name, spender.TxHash,
+				spender.InIndex, spender.BlockHash, test.spender,
+				test.inIndex, block.Hash())
+		}
+	}
+
+	err = db.Update(func(dbTx database.Tx) error {
+		return idx.DisconnectBlock(dbTx, block,
+			make([]blockchain.SpentTxOut, 3))
+	})
+	if err != nil {
+		t.Fatalf("DisconnectBlock: %v", err)
+	}
+	for _, test := range tests {
+		spender, err := idx.SpendingTx(test.outPoint)
+		if err != nil || spender != nil {
+			t.Errorf("%s: disconnected output spent by %v, error %v",
+				test.name, spender, err)
+		}
+	}
+}
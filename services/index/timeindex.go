@@ -0,0 +1,345 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+)
+
+const (
+	// timeIndexName is the human-readable name for the index.
+	timeIndexName = "time index"
+
+	// blockTimeKeySize is the size of a key in the timestamp and median
+	// time buckets.  It consists of the time and the block order.
+	blockTimeKeySize = 8 + 4
+
+	// blockTimeEntrySize is the size of a value in the block bucket.  It
+	// consists of the block order, the timestamp and the median time.
+	blockTimeEntrySize = 4 + 8 + 8
+)
+
+var (
+	// timeIndexKey is the key of the time index and the db bucket used to
+	// house it.
+	timeIndexKey = []byte("timeidx")
+
+	// timestampBucketName, medianTimeBucketName and blockTimeBucketName
+	// are the names of the buckets nested in the index bucket which house
+	// the blocks by timestamp, the blocks by median time and the times of
+	// every block.
+	timestampBucketName  = []byte("timestamp")
+	medianTimeBucketName = []byte("median")
+	blockTimeBucketName  = []byte("block")
+)
+
+// -----------------------------------------------------------------------------
+// The time index consists of three buckets nested in the index bucket.  Since
+// the timestamps of the blocks in the DAG aren't ordered, the blocks are kept
+// sorted by the header timestamp and by the median time separately.
+//
+// The serialized format of the timestamp and median time buckets is:
+//
+//   <time><order> = <block hash>
+//
+//   Field           Type              Size
+//   time            uint64            8 bytes
+//   order           uint32            4 bytes
+//   block hash      hash.Hash         32 bytes
+//   -----
+//   Total: 44 bytes
+//
+// The numbers in the keys are big endian so the cursor iterates them by time,
+// and by DAG order for the blocks with the same time.
+//
+// The serialized format of the block bucket, which is used to remove the
+// entries of a block, is:
+//
+//   <block hash> = <order><timestamp><median time>
+//
+//   Field           Type              Size
+//   block hash      hash.Hash         32 bytes
+//   order           uint32            4 bytes
+//   timestamp       uint64            8 bytes
+//   median time     uint64            8 bytes
+//   -----
+//   Total: 52 bytes
+// -----------------------------------------------------------------------------
+
+// BlockTime identifies a block along with its header timestamp and its past
+// median time.
+type BlockTime struct {
+	Hash       hash.Hash
+	Order      uint32
+	Timestamp  time.Time
+	MedianTime time.Time
+}
+
+// blockTimeKey returns the key of a block in the timestamp and median time
+// buckets.
+func blockTimeKey(t int64, order uint32) []byte {
+	key := make([]byte, blockTimeKeySize)
+	binary.BigEndian.PutUint64(key, uint64(t))
+	binary.BigEndian.PutUint32(key[8:], order)
+	return key
+}
+
+// TimeIndex implements an index of the blocks by header timestamp and by past
+// median time.  That is to say, it supports querying the blocks of the DAG
+// within a time range, which have no single height to search by.
+type TimeIndex struct {
+	indexStatus
+
+	// The following fields are set when the instance is created and can't
+	// be changed afterwards, so there is no need to protect them with a
+	// separate mutex.
+	db    database.DB
+	chain *blockchain.BlockChain
+}
+
+// Ensure the TimeIndex type implements the Indexer interface.
+var _ Indexer = (*TimeIndex)(nil)
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *TimeIndex) Init() error {
+	// Nothing to do.
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *TimeIndex) Key() []byte {
+	return timeIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *TimeIndex) Name() string {
+	return timeIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the index and
+// the buckets nested in it.
+//
+// This is part of the Indexer interface.
+func (idx *TimeIndex) Create(dbTx database.Tx) error {
+	bucket, err := dbTx.Metadata().CreateBucket(timeIndexKey)
+	if err != nil {
+		return err
+	}
+	for _, name := range [][]byte{timestampBucketName,
+		medianTimeBucketName, blockTimeBucketName} {
+		if _, err := bucket.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbRemoveBlockTime removes the entries of the block with the passed hash from
+// the index, if any.
+func dbRemoveBlockTime(bucket database.Bucket, blockHash *hash.Hash) error {
+	blocks := bucket.Bucket(blockTimeBucketName)
+	serialized := blocks.Get(blockHash[:])
+	if serialized == nil {
+		return nil
+	}
+	if len(serialized) < blockTimeEntrySize {
+		return database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt time index entry "+
+				"for %v", blockHash),
+		}
+	}
+	order := byteOrder.Uint32(serialized)
+	timestamp := int64(byteOrder.Uint64(serialized[4:]))
+	medianTime := int64(byteOrder.Uint64(serialized[12:]))
+
+	err := bucket.Bucket(timestampBucketName).Delete(
+		blockTimeKey(timestamp, order))
+	if err != nil {
+		return err
+	}
+	err = bucket.Bucket(medianTimeBucketName).Delete(
+		blockTimeKey(medianTime, order))
+	if err != nil {
+		return err
+	}
+	return blocks.Delete(blockHash[:])
+}
+
+// dbPutBlockTime adds the block with the passed hash, order, header timestamp
+// and past median time to the index, replacing the entries left by a previous
+// order of the block.
+func dbPutBlockTime(bucket database.Bucket, blockHash *hash.Hash, order uint32, timestamp, medianTime int64) error {
+	if err := dbRemoveBlockTime(bucket, blockHash); err != nil {
+		return err
+	}
+
+	err := bucket.Bucket(timestampBucketName).Put(
+		blockTimeKey(timestamp, order), blockHash[:])
+	if err != nil {
+		return err
+	}
+	err = bucket.Bucket(medianTimeBucketName).Put(
+		blockTimeKey(medianTime, order), blockHash[:])
+	if err != nil {
+		return err
+	}
+
+	var serialized [blockTimeEntrySize]byte
+	byteOrder.PutUint32(serialized[:], order)
+	byteOrder.PutUint64(serialized[4:], uint64(timestamp))
+	byteOrder.PutUint64(serialized[12:], uint64(medianTime))
+	return bucket.Bucket(blockTimeBucketName).Put(blockHash[:],
+		serialized[:])
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer adds the block by its header
+// timestamp and by its past median time.
+//
+// This is part of the Indexer interface.
+func (idx *TimeIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	node := idx.chain.BlockIndex().LookupNode(block.Hash())
+	if node == nil {
+		return fmt.Errorf("no node %s", block.Hash())
+	}
+	medianTime := node.CalcPastMedianTime(idx.chain).Unix()
+	return dbPutBlockTime(dbTx.Metadata().Bucket(timeIndexKey), block.Hash(),
+		uint32(block.Order()), block.Block().Header.Timestamp.Unix(),
+		medianTime)
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the entries of the
+// block.
+//
+// This is part of the Indexer interface.
+func (idx *TimeIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	return dbRemoveBlockTime(dbTx.Metadata().Bucket(timeIndexKey), block.Hash())
+}
+
+// dbFetchBlockTime returns the times of the block with the passed hash from the
+// block bucket.
+func dbFetchBlockTime(bucket database.Bucket, blockHash []byte) (*BlockTime, error) {
+	serialized := bucket.Bucket(blockTimeBucketName).Get(blockHash)
+	if len(blockHash) != hash.HashSize || len(serialized) < blockTimeEntrySize {
+		return nil, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt time index entry "+
+				"for %x", blockHash),
+		}
+	}
+	blockTime := &BlockTime{
+		Order:      byteOrder.Uint32(serialized),
+		Timestamp:  time.Unix(int64(byteOrder.Uint64(serialized[4:])), 0),
+		MedianTime: time.Unix(int64(byteOrder.Uint64(serialized[12:])), 0),
+	}
+	copy(blockTime.Hash[:], blockHash)
+	return blockTime, nil
+}
+
+// BlockByTime returns the block with the earliest time at or after the passed
+// time, or the block with the latest time at or before it when before is set.
+// The time is the header timestamp, or the past median time when median is
+// set.  The block with the lowest order is returned among the blocks with the
+// same time when searching forward, and the one with the highest order when
+// searching backward.  When there is no such block, nil will be returned for
+// both the block and the error.
+//
+// This function is safe for concurrent access.
+func (idx *TimeIndex) BlockByTime(t time.Time, median, before bool) (*BlockTime, error) {
+	bucketName := timestampBucketName
+	if median {
+		bucketName = medianTimeBucketName
+	}
+
+	var blockTime *BlockTime
+	err := idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(timeIndexKey)
+		cursor := bucket.Bucket(bucketName).Cursor()
+		var ok bool
+		if before {
+			// Seek past the blocks at the time and step back.
+			if cursor.Seek(blockTimeKey(t.Unix()+1, 0)) {
+				ok = cursor.Prev()
+			} else {
+				ok = cursor.Last()
+			}
+		} else {
+			ok = cursor.Seek(blockTimeKey(t.Unix(), 0))
+		}
+		if !ok {
+			return nil
+		}
+
+		var err error
+		blockTime, err = dbFetchBlockTime(bucket, cursor.Value())
+		return err
+	})
+	return blockTime, err
+}
+
+// BlocksInTimeRange returns the blocks with a header timestamp within the
+// passed range, which includes the start and excludes the end.  The blocks are
+// ordered by timestamp then by DAG order, and at most limit blocks are
+// returned.
+//
+// This function is safe for concurrent access.
+func (idx *TimeIndex) BlocksInTimeRange(start, end time.Time, limit int) ([]*BlockTime, error) {
+	var blockTimes []*BlockTime
+	err := idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(timeIndexKey)
+		cursor := bucket.Bucket(timestampBucketName).Cursor()
+		for ok := cursor.Seek(blockTimeKey(start.Unix(), 0)); ok &&
+			len(blockTimes) < limit; ok = cursor.Next() {
+
+			key := cursor.Key()
+			if len(key) != blockTimeKeySize {
+				continue
+			}
+			if int64(binary.BigEndian.Uint64(key)) >= end.Unix() {
+				break
+			}
+			blockTime, err := dbFetchBlockTime(bucket, cursor.Value())
+			if err != nil {
+				return err
+			}
+			blockTimes = append(blockTimes, blockTime)
+		}
+		return nil
+	})
+	return blockTimes, err
+}
+
+// NewTimeIndex returns a new instance of an indexer that is used to create a
+// mapping of the header timestamps and the past median times of the blocks to
+// their DAG order.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewTimeIndex(db database.DB) *TimeIndex {
+	return &TimeIndex{db: db}
+}
+
+// DropTimeIndex drops the time index from the provided database if it exists.
+func DropTimeIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, timeIndexKey, timeIndexName, interrupt)
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+)
+
+// TestTimeIndex ensures the blocks are found by header timestamp and by past
+// median time, forward and backward, with the order breaking the ties, that
+// the time ranges include their start and exclude their end, and that the
+// entries of a block are replaced when its order changes and removed when it
+// is disconnected.
+func TestTimeIndex(t *testing.T) {
+	db, teardown := newTestIndexDB(t)
+	defer teardown()
+	idx := NewTimeIndex(db)
+
+	// The timestamps of the blocks in the DAG aren't ordered, and the
+	// order of the fourth block changes before the last one is
+	// disconnected.
+	blocks := []struct {
+		order      uint32
+		timestamp  int64
+		medianTime int64
+	}{
+		{1, 1000, 900},
+		{2, 1000, 950},
+		{3, 900, 1000},
+		{4, 1200, 1000},
+		{6, 1300, 1100},
+	}
+	hashes := make([]hash.Hash, len(blocks))
+	var disconnected *types.SerializedBlock
+	for i, b := range blocks {
+		disconnected = testIndexBlock(t, db, b.order,
+			time.Unix(b.timestamp, 0))
+		hashes[i] = *disconnected.Hash()
+	}
+	err := db.Update(func(dbTx database.Tx) error {
+		if err := idx.Create(dbTx); err != nil {
+			return err
+		}
+		bucket := dbTx.Metadata().Bucket(timeIndexKey)
+		for i, b := range blocks {
+			err := dbPutBlockTime(bucket, &hashes[i], b.order,
+				b.timestamp, b.medianTime)
+			if err != nil {
+				return err
+			}
+		}
+		blocks[3].order = 5
+		err := dbPutBlockTime(bucket, &hashes[3], 5, blocks[3].timestamp,
+			blocks[3].medianTime)
+		if err != nil {
+			return err
+		}
+		return idx.DisconnectBlock(dbTx, disconnected, nil)
+	})
+	if err != nil {
+		t.Fatalf("failed to index the blocks: %v", err)
+	}
+
+	const none = -1
+	searchTests := []struct {
+		name   string
+		time   int64
+		median bool
+		before bool
+		want   int
+	}{
+		{"same timestamp forward", 1000, false, false, 0},
+		{"same timestamp backward", 1000, false, true, 1},
+		{"between timestamps forward", 950, false, false, 0},
+		{"between timestamps backward", 950, false, true, 2},
+		{"before the first timestamp", 800, false, true, none},
+		{"after the disconnected block", 1201, false, false, none},
+		{"backward from the disconnected block", 1300, false, true, 3},
+		{"same median time forward", 1000, true, false, 2},
+		{"same median time backward", 1000, true, true, 3},
+		{"between median times forward", 925, true, false, 1},
+	}
+	for _, test := range searchTests {
+		blockTime, err := idx.BlockByTime(time.Unix(test.time, 0),
+			test.median, test.before)
+		if err != nil {
+			t.Errorf("%s: BlockByTime: %v", test.name, err)
+			continue
+		}
+		if test.want == none {
+			if blockTime != nil {
+				t.Errorf("%s: found block %v", test.name,
+					blockTime.Hash)
+			}
+			continue
+		}
+		want := blocks[test.want]
+		if blockTime == nil || blockTime.Hash != hashes[test.want] ||
+			blockTime.Order != want.order ||
+			blockTime.Timestamp.Unix() != want.timestamp ||
+			blockTime.MedianTime.Unix() != want.medianTime {
+			t.Errorf("%s: found %+v, want block %v of order %d",
+				test.name, blockTime, hashes[test.want], want.order)
+		}
+	}
+
+	rangeTests := []struct {
+		name  string
+		start int64
+		end   int64
+		limit int
+		want  []int
+	}{
+		{"end excluded", 900, 1200, 10, []int{2, 0, 1}},
+		{"end after the last block", 900, 1201, 10, []int{2, 0, 1, 3}},
+		{"limited", 1000, 1301, 2, []int{0, 1}},
+		{"empty", 1001, 1200, 10, nil},
+		{"all blocks", 0, 2000, 10, []int{2, 0, 1, 3}},
+	}
+	for _, test := range rangeTests {
+		blockTimes, err := idx.BlocksInTimeRange(time.Unix(test.start, 0),
+			time.Unix(test.end, 0), test.limit)
+		if err != nil {
+			t.Errorf("%s: BlocksInTimeRange: %v", test.name, err)
+			continue
+		}
+		if len(blockTimes) != len(test.want) {
+			t.Errorf("%s: found %d blocks, want %d", test.name,
+				len(blockTimes), len(test.want))
+			continue
+		}
+		for i, blockTime := range blockTimes {
+			want := test.want[i]
+			if blockTime.Hash != hashes[want] ||
+				blockTime.Order != blocks[want].order {
+				t.Errorf("%s: block %d is %v of order %d, want %v "+
+					"of order %d", test.name, i, blockTime.Hash,
+					blockTime.Order, hashes[want],
+					blocks[want].order)
+			}
+		}
+	}
+}
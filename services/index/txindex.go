@@ -9,8 +9,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/log"
 )
@@ -299,6 +299,7 @@ func dbRemoveTxIndexEntries(dbTx database.Tx, block *types.SerializedBlock) erro
 // TxIndex implements a transaction by hash index.  That is to say, it supports
 // querying all transactions by their hash.
 type TxIndex struct {
+	indexStatus
 	db         database.DB
 	curBlockID uint32
 
@@ -495,13 +496,23 @@ func dropBlockIDIndex(db database.DB) error {
 }
 
 // DropTxIndex drops the transaction index from the provided database if it
-// exists.  Since the address index relies on it, the address index will also be
-// dropped when it exists.
+// exists.  Since the address, spent and null data indexes rely on it, they
+// will also be dropped when they exist.
 func DropTxIndex(db database.DB, interrupt <-chan struct{}) error {
 	err := dropIndex(db, addrIndexKey, addrIndexName, interrupt)
 	if err != nil {
 		return err
 	}
 
+	err = DropSpentIndex(db, interrupt)
+	if err != nil {
+		return err
+	}
+
+	err = DropNullDataIndex(db, interrupt)
+	if err != nil {
+		return err
+	}
+
 	return dropIndex(db, txIndexKey, txIndexName, interrupt)
 }
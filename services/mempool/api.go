@@ -1,6 +1,11 @@
 package mempool
 
 import (
+	"bytes"
+	"encoding/hex"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/json"
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/rpc"
 	"sort"
@@ -35,3 +40,116 @@ func (api *PublicMempoolAPI) GetMempool(txType *string , verbose bool) (interfac
 	sort.Strings(hashStrings)
 	return hashStrings,nil
 }
+
+// GetMempoolEntry returns the fee, size, time, priority and dependencies of the
+// passed transaction of the mempool.
+func (api *PublicMempoolAPI) GetMempoolEntry(txHash hash.Hash) (interface{}, error) {
+	desc, err := api.txPool.FetchTxDesc(&txHash)
+	if err != nil {
+		return nil, rpc.RpcNoTxInfoError(&txHash)
+	}
+	return api.txPool.mempoolEntry(desc), nil
+}
+
+// GetMempoolInfo returns the usage of the mempool and of the orphan pool and
+// the fee rates required to enter the mempool.
+func (api *PublicMempoolAPI) GetMempoolInfo() (interface{}, error) {
+	return api.txPool.MempoolInfo(), nil
+}
+
+// GetMempoolFeeHistogram returns the number, size and fees of the
+// transactions of the mempool per fee rate range.
+func (api *PublicMempoolAPI) GetMempoolFeeHistogram() (interface{}, error) {
+	return api.txPool.FeeHistogram(), nil
+}
+
+// TestMempoolAccept checks whether every passed raw transaction would be
+// accepted into the mempool without adding it, with the reject code and
+// reason when it would not.  The transactions are checked independently, so
+// a transaction spending another one of the list is rejected.
+func (api *PublicMempoolAPI) TestMempoolAccept(rawTxs []string, allowHighFees *bool) (interface{}, error) {
+	highFees := false
+	if allowHighFees != nil {
+		highFees = *allowHighFees
+	}
+
+	results := make([]json.TestMempoolAcceptResult, 0, len(rawTxs))
+	for _, hexStr := range rawTxs {
+		if len(hexStr)%2 != 0 {
+			hexStr = "0" + hexStr
+		}
+		serializedTx, err := hex.DecodeString(hexStr)
+		if err != nil {
+			return nil, rpc.RpcDecodeHexError(hexStr)
+		}
+		msgTx := types.NewTransaction()
+		err = msgTx.Deserialize(bytes.NewReader(serializedTx))
+		if err != nil {
+			return nil, rpc.RpcDeserializationError("Could not decode Tx: %v",
+				err)
+		}
+
+		tx := types.NewTx(msgTx)
+		result := json.TestMempoolAcceptResult{
+			TxId:    tx.Hash().String(),
+			Allowed: true,
+		}
+		err = api.txPool.TestAccept(tx, highFees)
+		if err != nil {
+			code, reason := ErrToRejectErr(err)
+			result.Allowed = false
+			result.RejectCode = code.String()
+			result.RejectReason = reason
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// SaveMempool writes the transactions of the mempool to the file in the data
+// directory it is loaded from on startup.
+func (api *PublicMempoolAPI) SaveMempool() (interface{}, error) {
+	err := api.txPool.Save()
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Save mempool")
+	}
+	return nil, nil
+}
+
+// GetMempoolAncestors returns the unconfirmed ancestors in the mempool of the
+// passed transaction, as hashes or as entries keyed by hash when verbose.
+func (api *PublicMempoolAPI) GetMempoolAncestors(txHash hash.Hash, verbose *bool) (interface{}, error) {
+	descs, err := api.txPool.TxAncestors(&txHash)
+	if err != nil {
+		return nil, rpc.RpcNoTxInfoError(&txHash)
+	}
+	return api.mempoolEntries(descs, verbose != nil && *verbose), nil
+}
+
+// GetMempoolDescendants returns the descendants in the mempool of the passed
+// transaction, as hashes or as entries keyed by hash when verbose.
+func (api *PublicMempoolAPI) GetMempoolDescendants(txHash hash.Hash, verbose *bool) (interface{}, error) {
+	descs, err := api.txPool.TxDescendants(&txHash)
+	if err != nil {
+		return nil, rpc.RpcNoTxInfoError(&txHash)
+	}
+	return api.mempoolEntries(descs, verbose != nil && *verbose), nil
+}
+
+// mempoolEntries returns the hashes of the passed transactions, or their
+// entries keyed by hash when verbose.
+func (api *PublicMempoolAPI) mempoolEntries(descs []*TxDesc, verbose bool) interface{} {
+	if !verbose {
+		hashStrings := make([]string, 0, len(descs))
+		for _, desc := range descs {
+			hashStrings = append(hashStrings, desc.Tx.Hash().String())
+		}
+		return hashStrings
+	}
+
+	entries := make(map[string]*json.MempoolEntryResult, len(descs))
+	for _, desc := range descs {
+		entries[desc.Tx.Hash().String()] = api.txPool.mempoolEntry(desc)
+	}
+	return entries
+}
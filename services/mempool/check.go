@@ -7,9 +7,9 @@ package mempool
 
 import (
 	"fmt"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"time"
 )
@@ -109,7 +109,7 @@ func checkTransactionStandard(tx *types.Tx, height uint64,
 		// TODO DUST decision (may careful about reject Dust for token base tx)
 		if scriptClass == txscript.NullDataTy {
 			numNullDataOutputs++
-		} else if isDust(txOut, minRelayTxFee) {
+		} else if IsDust(txOut, minRelayTxFee) {
 			str := fmt.Sprintf("transaction output %d: payment "+
 				"of %d is dust", i, txOut.Amount)
 			return txRuleError(message.RejectDust, str)
@@ -193,12 +193,12 @@ func checkPkScriptStandard(pkScript []byte,
 	return nil
 }
 
-// isDust returns whether or not the passed transaction output amount is
+// IsDust returns whether or not the passed transaction output amount is
 // considered dust or not based on the passed minimum transaction relay fee.
 // Dust is defined in terms of the minimum transaction relay fee.  In
 // particular, if the cost to the network to spend coins is more than 1/3 of the
 // minimum transaction relay fee, it is considered dust.
-func isDust(txOut *types.TxOutput, minRelayTxFee types.Amount) bool {
+func IsDust(txOut *types.TxOutput, minRelayTxFee types.Amount) bool {
 	// Unspendable outputs are considered dust.
 	if txscript.IsUnspendable(txOut.PkScript) {
 		return true
@@ -270,19 +270,31 @@ func isDust(txOut *types.TxOutput, minRelayTxFee types.Amount) bool {
 
 // checkPoolDoubleSpend checks whether or not the passed transaction is
 // attempting to spend coins already spent by other transactions in the pool.
-// Note it does not check for double spends against transactions already in the
-// main chain.
+// If it does, it is only allowed when the transactions it conflicts with
+// signal replacement, in which case it is a replacement which must be
+// validated further with validateReplacement.  Note it does not check for
+// double spends against transactions already in the main chain.
 //
 // This function MUST be called with the mempool lock held (for reads).
-func (mp *TxPool) checkPoolDoubleSpend(tx *types.Tx) error {
+func (mp *TxPool) checkPoolDoubleSpend(tx *types.Tx) (bool, error) {
+	var isReplacement bool
 	for _, txIn := range tx.Transaction().TxIn {
-		if txR, exists := mp.outpoints[txIn.PreviousOut]; exists {
+		txR, exists := mp.outpoints[txIn.PreviousOut]
+		if !exists {
+			continue
+		}
+
+		// Reject the transaction if replacements are not accepted or
+		// the conflicting transaction doesn't signal replacement.
+		if mp.cfg.Policy.RejectReplacement ||
+			!mp.signalsReplacement(txR) {
 			str := fmt.Sprintf("transaction %v in the pool "+
 				"already spends the same coins", txR.Hash())
-			return txRuleError(message.RejectDuplicate, str)
+			return false, txRuleError(message.RejectDuplicate, str)
 		}
+		isReplacement = true
 	}
-	return nil
+	return isReplacement, nil
 }
 
 // checkInputsStandard performs a series of checks on a transaction's inputs
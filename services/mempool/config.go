@@ -7,9 +7,9 @@ package mempool
 
 import (
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/services/index"
@@ -74,6 +74,22 @@ type Config struct {
 	// This can be nil if the address index is not enabled.
 	ExistsAddrIndex *index.ExistsAddrIndex
 
+	// AddrUtxoIndex defines the optional address utxo index instance to use
+	// for tracking the balance changes of the unconfirmed transactions in
+	// the memory pool.
+	// This can be nil if the address utxo index is not enabled.
+	AddrUtxoIndex *index.AddrUtxoIndex
+
 	// block dag
 	BD *blockdag.BlockDAG
+
+	// FeeEstimator defines the optional fee estimator which observes the
+	// new transactions accepted into the memory pool until they are
+	// confirmed or removed from it.
+	FeeEstimator *FeeEstimator
+
+	// PersistFile is the path of the file the memory pool is saved to on
+	// shutdown and loaded from on startup.  The memory pool is not
+	// persisted when the path is empty.
+	PersistFile string
 }
@@ -0,0 +1,338 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	// EstimateFeeMaxConfirms is the maximum number of blocks the estimator
+	// waits for a transaction to be confirmed, which is the maximum target
+	// of an estimation.
+	EstimateFeeMaxConfirms = 25
+
+	// DefaultEstimateFeeConfidence is the default share of the transactions
+	// paying the estimated fee rate which were confirmed within the target.
+	DefaultEstimateFeeConfidence = 0.85
+
+	// estimateFeeDecay is the factor the statistics are multiplied by on
+	// every block, so the recent blocks weigh more.
+	estimateFeeDecay = 0.998
+
+	// estimateFeeMinBucket and estimateFeeMaxBucket are the fee rates in
+	// atoms/kB of the lowest and the highest bucket.
+	estimateFeeMinBucket = 1000
+	estimateFeeMaxBucket = 1e7
+
+	// estimateFeeBucketSpacing is the ratio between the fee rates of two
+	// consecutive buckets.
+	estimateFeeBucketSpacing = 1.1
+
+	// estimateFeeMinTxs is the decayed number of transactions a range of
+	// buckets needs before it is used for an estimation.
+	estimateFeeMinTxs = 5
+
+	// estimateFeeVersion is the version of the serialized state of the
+	// estimator.
+	estimateFeeVersion uint32 = 1
+)
+
+// errNoFeeEstimate is returned when the estimator doesn't have enough data to
+// estimate a fee rate for the passed target and confidence.
+var errNoFeeEstimate = errors.New("insufficient data for a fee estimate")
+
+// observedTx is a transaction in the pool which hasn't been confirmed yet.
+type observedTx struct {
+	feeRate int64
+	order   uint64
+}
+
+// FeeEstimator estimates the fee rate which gets a transaction confirmed
+// within a number of blocks.  It records the DAG order at which the
+// transactions entered the pool and at which they were confirmed, bucketed by
+// their fee rate.  A fee rate is estimated for a target once the share of the
+// transactions paying at least that much confirmed within the target reaches
+// the requested confidence.
+type FeeEstimator struct {
+	mtx sync.RWMutex
+
+	// buckets holds the lowest fee rate of every bucket.
+	buckets []float64
+
+	// The decayed number of resolved transactions, the decayed sum of
+	// their fee rates and the decayed number of the ones confirmed within
+	// every target, per bucket.
+	txCount   []float64
+	feeSum    []float64
+	confirmed [EstimateFeeMaxConfirms][]float64
+
+	observed  map[hash.Hash]observedTx
+	lastOrder uint64
+}
+
+// NewFeeEstimator returns a new estimator without statistics which starts
+// tracking the transactions after the block of the passed order.
+func NewFeeEstimator(bestOrder uint64) *FeeEstimator {
+	var buckets []float64
+	for rate := float64(estimateFeeMinBucket); rate <= estimateFeeMaxBucket; rate *= estimateFeeBucketSpacing {
+		buckets = append(buckets, rate)
+	}
+	ef := &FeeEstimator{
+		buckets:   buckets,
+		txCount:   make([]float64, len(buckets)),
+		feeSum:    make([]float64, len(buckets)),
+		observed:  make(map[hash.Hash]observedTx),
+		lastOrder: bestOrder,
+	}
+	for i := range ef.confirmed {
+		ef.confirmed[i] = make([]float64, len(buckets))
+	}
+	return ef
+}
+
+// bucketIndex returns the bucket of the passed fee rate.  The rates below the
+// lowest bucket fall into it.
+func (ef *FeeEstimator) bucketIndex(feeRate int64) int {
+	i := sort.SearchFloat64s(ef.buckets, float64(feeRate))
+	if i < len(ef.buckets) && ef.buckets[i] == float64(feeRate) {
+		return i
+	}
+	if i > 0 {
+		i--
+	}
+	return i
+}
+
+// ObserveTransaction starts tracking the passed transaction, which has just
+// entered the pool, until it is confirmed.
+//
+// This function is safe for concurrent access.
+func (ef *FeeEstimator) ObserveTransaction(txD *TxDesc) {
+	ef.mtx.Lock()
+	defer ef.mtx.Unlock()
+
+	txHash := *txD.Tx.Hash()
+	if _, ok := ef.observed[txHash]; ok {
+		return
+	}
+	ef.observed[txHash] = observedTx{
+		feeRate: txD.FeePerKB,
+		order:   ef.lastOrder,
+	}
+}
+
+// RemoveTransaction stops tracking the passed transaction, which left the pool
+// without being confirmed because it was evicted, replaced or became invalid.
+//
+// This function is safe for concurrent access.
+func (ef *FeeEstimator) RemoveTransaction(txHash *hash.Hash) {
+	ef.mtx.Lock()
+	delete(ef.observed, *txHash)
+	ef.mtx.Unlock()
+}
+
+// RegisterBlock records the number of blocks the tracked transactions of the
+// passed block connected to the chain waited for.  The tracked transactions
+// which waited longer than the maximum target without being confirmed are
+// counted as failing every target.
+//
+// This function is safe for concurrent access.
+func (ef *FeeEstimator) RegisterBlock(block *types.SerializedBlock) {
+	ef.mtx.Lock()
+	defer ef.mtx.Unlock()
+
+	for i := range ef.buckets {
+		ef.txCount[i] *= estimateFeeDecay
+		ef.feeSum[i] *= estimateFeeDecay
+		for t := range ef.confirmed {
+			ef.confirmed[t][i] *= estimateFeeDecay
+		}
+	}
+
+	order := block.Order()
+	for _, tx := range block.Transactions()[1:] {
+		o, ok := ef.observed[*tx.Hash()]
+		if !ok {
+			continue
+		}
+		delete(ef.observed, *tx.Hash())
+
+		confirms := 1
+		if order > o.order {
+			confirms = int(order - o.order)
+		}
+		b := ef.bucketIndex(o.feeRate)
+		ef.txCount[b]++
+		ef.feeSum[b] += float64(o.feeRate)
+		for t := confirms; t <= EstimateFeeMaxConfirms; t++ {
+			ef.confirmed[t-1][b]++
+		}
+	}
+
+	for txHash, o := range ef.observed {
+		if o.order+EstimateFeeMaxConfirms >= order {
+			continue
+		}
+		delete(ef.observed, txHash)
+		b := ef.bucketIndex(o.feeRate)
+		ef.txCount[b]++
+		ef.feeSum[b] += float64(o.feeRate)
+	}
+
+	if order > ef.lastOrder {
+		ef.lastOrder = order
+	}
+}
+
+// EstimateFee returns the lowest fee rate in atoms/kB for which at least the
+// passed share of the transactions were confirmed within target blocks.  The
+// buckets are scanned from the highest fee rate and grouped until they hold
+// enough transactions, and the scan stops at the first group confirming too
+// few of them.
+//
+// This function is safe for concurrent access.
+func (ef *FeeEstimator) EstimateFee(target int, confidence float64) (types.Amount, error) {
+	if target < 1 || target > EstimateFeeMaxConfirms {
+		return 0, fmt.Errorf("target %d is out of range [1, %d]",
+			target, EstimateFeeMaxConfirms)
+	}
+	if confidence <= 0 || confidence > 1 {
+		return 0, fmt.Errorf("confidence %v is out of range (0, 1]",
+			confidence)
+	}
+
+	ef.mtx.RLock()
+	defer ef.mtx.RUnlock()
+
+	var estimate, count, confirmed, feeSum float64
+	for i := len(ef.buckets) - 1; i >= 0; i-- {
+		count += ef.txCount[i]
+		confirmed += ef.confirmed[target-1][i]
+		feeSum += ef.feeSum[i]
+		if count < estimateFeeMinTxs {
+			continue
+		}
+		if confirmed/count < confidence {
+			break
+		}
+		estimate = feeSum / count
+		count, confirmed, feeSum = 0, 0, 0
+	}
+	if estimate == 0 {
+		return 0, errNoFeeEstimate
+	}
+	return types.Amount(math.Ceil(estimate)), nil
+}
+
+// Save returns the serialized state of the estimator, which can be restored
+// with RestoreFeeEstimator.
+//
+// This function is safe for concurrent access.
+func (ef *FeeEstimator) Save() []byte {
+	ef.mtx.RLock()
+	defer ef.mtx.RUnlock()
+
+	var buf bytes.Buffer
+	write := func(data interface{}) {
+		// Writing to a bytes.Buffer never fails.
+		binary.Write(&buf, binary.LittleEndian, data)
+	}
+	write(estimateFeeVersion)
+	write(ef.lastOrder)
+	write(uint32(len(ef.buckets)))
+	write(ef.buckets)
+	write(ef.txCount)
+	write(ef.feeSum)
+	for t := range ef.confirmed {
+		write(ef.confirmed[t])
+	}
+	write(uint32(len(ef.observed)))
+	for txHash, o := range ef.observed {
+		write(txHash[:])
+		write(o.feeRate)
+		write(o.order)
+	}
+	return buf.Bytes()
+}
+
+// RestoreFeeEstimator returns the estimator of the passed state serialized by
+// Save.
+func RestoreFeeEstimator(data []byte) (*FeeEstimator, error) {
+	r := bytes.NewReader(data)
+	read := func(data interface{}) error {
+		return binary.Read(r, binary.LittleEndian, data)
+	}
+
+	var version, numBuckets uint32
+	ef := &FeeEstimator{observed: make(map[hash.Hash]observedTx)}
+	err := read(&version)
+	if err != nil {
+		return nil, err
+	}
+	if version != estimateFeeVersion {
+		return nil, fmt.Errorf("unsupported fee estimator version %d",
+			version)
+	}
+	err = read(&ef.lastOrder)
+	if err != nil {
+		return nil, err
+	}
+	err = read(&numBuckets)
+	if err != nil {
+		return nil, err
+	}
+	if int(numBuckets)*8 > len(data) {
+		return nil, fmt.Errorf("corrupt fee estimator state")
+	}
+	ef.buckets = make([]float64, numBuckets)
+	ef.txCount = make([]float64, numBuckets)
+	ef.feeSum = make([]float64, numBuckets)
+	for _, stats := range [][]float64{ef.buckets, ef.txCount, ef.feeSum} {
+		err = read(stats)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for t := range ef.confirmed {
+		ef.confirmed[t] = make([]float64, numBuckets)
+		err = read(ef.confirmed[t])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var numObserved uint32
+	err = read(&numObserved)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < numObserved; i++ {
+		var txHash hash.Hash
+		var o observedTx
+		err = read(txHash[:])
+		if err != nil {
+			return nil, err
+		}
+		err = read(&o.feeRate)
+		if err != nil {
+			return nil, err
+		}
+		err = read(&o.order)
+		if err != nil {
+			return nil, err
+		}
+		ef.observed[txHash] = o
+	}
+	return ef, nil
+}
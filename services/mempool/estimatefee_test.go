@@ -0,0 +1,101 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// testFeeBlock returns a block of the passed order confirming the passed
+// transactions after its coinbase.
+func testFeeBlock(order uint64, txs ...*types.Tx) *types.SerializedBlock {
+	block := &types.Block{}
+	block.AddTransaction(testExternalTx(0).Transaction())
+	for _, tx := range txs {
+		block.AddTransaction(tx.Transaction())
+	}
+	sb := types.NewBlock(block)
+	sb.SetOrder(order)
+	return sb
+}
+
+// TestFeeEstimatorSaveRestore ensures the restored state of an estimator has
+// the statistics and the tracked transactions of the saved one.
+func TestFeeEstimatorSaveRestore(t *testing.T) {
+	ef := NewFeeEstimator(10)
+	var txs []*types.Tx
+	for i := 0; i < 20; i++ {
+		tx := testExternalTx(byte(i + 1))
+		txs = append(txs, tx)
+		ef.ObserveTransaction(&TxDesc{TxDesc: types.TxDesc{Tx: tx,
+			FeePerKB: int64(1000 * (i + 1))}})
+	}
+	// The first transactions are confirmed and the others are still
+	// tracked.
+	ef.RegisterBlock(testFeeBlock(11, txs[:10]...))
+	ef.RegisterBlock(testFeeBlock(12, txs[10:15]...))
+
+	restored, err := RestoreFeeEstimator(ef.Save())
+	if err != nil {
+		t.Fatalf("RestoreFeeEstimator: %v", err)
+	}
+	if restored.lastOrder != ef.lastOrder {
+		t.Errorf("restored last order %d, want %d", restored.lastOrder,
+			ef.lastOrder)
+	}
+	if len(restored.observed) != 5 ||
+		!reflect.DeepEqual(restored.observed, ef.observed) {
+		t.Errorf("restored tracked transactions %v, want %v",
+			restored.observed, ef.observed)
+	}
+	for _, stats := range []struct {
+		name          string
+		got, expected interface{}
+	}{
+		{"buckets", restored.buckets, ef.buckets},
+		{"txCount", restored.txCount, ef.txCount},
+		{"feeSum", restored.feeSum, ef.feeSum},
+		{"confirmed", restored.confirmed, ef.confirmed},
+	} {
+		if !reflect.DeepEqual(stats.got, stats.expected) {
+			t.Errorf("restored %s differ", stats.name)
+		}
+	}
+
+	if _, err := RestoreFeeEstimator(ef.Save()[:20]); err == nil {
+		t.Errorf("RestoreFeeEstimator of a truncated state succeeded")
+	}
+}
+
+// TestFeeEstimatorRemoveTransaction ensures the estimator stops tracking the
+// transactions which leave the pool unconfirmed.
+func TestFeeEstimatorRemoveTransaction(t *testing.T) {
+	ef := NewFeeEstimator(10)
+	mp := New(&Config{FeeEstimator: ef})
+	view := blockchain.NewUtxoViewpoint()
+
+	parent := testExternalTx(1)
+	child := testSpendTx(types.NewOutPoint(parent.Hash(), 0))
+	other := testExternalTx(2)
+	for _, tx := range []*types.Tx{parent, child, other} {
+		mp.addTransaction(view, tx, 1, 10000)
+		ef.ObserveTransaction(mp.pool[*tx.Hash()])
+	}
+
+	mp.RemoveTransaction(parent, true)
+	for _, tx := range []*types.Tx{parent, child} {
+		if _, ok := ef.observed[*tx.Hash()]; ok {
+			t.Errorf("removed transaction %v is still tracked",
+				tx.Hash())
+		}
+	}
+	if _, ok := ef.observed[*other.Hash()]; !ok {
+		t.Errorf("transaction %v is no longer tracked", other.Hash())
+	}
+}
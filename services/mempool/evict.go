@@ -0,0 +1,174 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/log"
+	"math"
+	"time"
+)
+
+const (
+	// rollingFeeHalfLife is the time in seconds it takes the rolling
+	// minimum fee rate of the pool to halve once transactions are no
+	// longer evicted.
+	rollingFeeHalfLife = 12 * 60 * 60
+
+	// rollingFeeUpdateInterval is the minimum time in seconds between two
+	// decays of the rolling minimum fee rate.
+	rollingFeeUpdateInterval = 10
+)
+
+// feeRateHeap is a min-heap of the transactions of the pool ordered by the fee
+// rate of their descendant packages, so the transaction to evict next is at its
+// root.  It implements heap.Interface and keeps the position of every
+// descriptor in its heapIndex, so a descriptor whose package statistics change
+// can be fixed or removed in logarithmic time.
+type feeRateHeap []*TxDesc
+
+func (h feeRateHeap) Len() int { return len(h) }
+
+func (h feeRateHeap) Less(i, j int) bool {
+	return h[i].descendantFeeRate() < h[j].descendantFeeRate()
+}
+
+func (h feeRateHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *feeRateHeap) Push(x interface{}) {
+	txD := x.(*TxDesc)
+	txD.heapIndex = len(*h)
+	*h = append(*h, txD)
+}
+
+func (h *feeRateHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	txD := old[n-1]
+	old[n-1] = nil
+	txD.heapIndex = -1
+	*h = old[:n-1]
+	return txD
+}
+
+// txDescendants returns the passed transaction followed by all the
+// transactions in the pool which spend its outputs, recursively.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txDescendants(tx *types.Tx) []*types.Tx {
+	descendants := []*types.Tx{tx}
+	seen := map[hash.Hash]struct{}{*tx.Hash(): {}}
+	for i := 0; i < len(descendants); i++ {
+		txHash := descendants[i].Hash()
+		for o := range descendants[i].Transaction().TxOut {
+			redeemer, exists := mp.outpoints[*types.NewOutPoint(txHash, uint32(o))]
+			if !exists {
+				continue
+			}
+			// A redeemer spending several outputs is only added
+			// once.
+			if _, ok := seen[*redeemer.Hash()]; ok {
+				continue
+			}
+			seen[*redeemer.Hash()] = struct{}{}
+			descendants = append(descendants, redeemer)
+		}
+	}
+	return descendants
+}
+
+// limitPoolSize evicts the transactions with the lowest fee rate, together
+// with their descendants, until the pool is under its maximum size.  The fee
+// rate of a transaction includes its descendants, so a parent paid for by its
+// children is kept.  The rolling minimum fee rate is raised above the fee rate
+// of the evicted transactions, so they can't immediately be replaced by
+// transactions paying the same.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) limitPoolSize() {
+	maxSize := mp.cfg.Policy.MaxPoolSize
+	if maxSize <= 0 || mp.poolSize <= maxSize {
+		return
+	}
+
+	evicted := 0
+	for mp.poolSize > maxSize && mp.feeRates.Len() > 0 {
+		worst := mp.feeRates[0]
+		worstFeeRate := worst.descendantFeeRate()
+
+		evicted += len(mp.txDescendants(worst.Tx))
+		mp.removeTransaction(worst.Tx, true)
+
+		feeRate := float64(worstFeeRate) + float64(mp.cfg.Policy.MinRelayTxFee)
+		if feeRate > mp.rollingMinFee {
+			mp.rollingMinFee = feeRate
+		}
+		mp.lastRollingFeeUpdate = time.Now().Unix()
+	}
+
+	log.Debug("Evicted transactions from the full mempool", "count",
+		evicted, "pool size", mp.poolSize, "min fee rate",
+		types.Amount(mp.rollingMinFee))
+}
+
+// minFeeRate returns the fee rate in atoms/kB a new transaction has to pay to
+// enter the pool.  The rolling minimum fee rate decays faster when the pool
+// is less than half full and is dropped once it is below half the relay fee.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) minFeeRate() types.Amount {
+	if mp.rollingMinFee == 0 {
+		return mp.cfg.Policy.MinRelayTxFee
+	}
+
+	now := time.Now().Unix()
+	if now >= mp.lastRollingFeeUpdate+rollingFeeUpdateInterval {
+		halfLife := float64(rollingFeeHalfLife)
+		maxSize := mp.cfg.Policy.MaxPoolSize
+		if mp.poolSize < maxSize/4 {
+			halfLife /= 4
+		} else if mp.poolSize < maxSize/2 {
+			halfLife /= 2
+		}
+		elapsed := float64(now - mp.lastRollingFeeUpdate)
+		mp.rollingMinFee /= math.Pow(2, elapsed/halfLife)
+		mp.lastRollingFeeUpdate = now
+
+		if mp.rollingMinFee < float64(mp.cfg.Policy.MinRelayTxFee)/2 {
+			mp.rollingMinFee = 0
+			return mp.cfg.Policy.MinRelayTxFee
+		}
+	}
+
+	if types.Amount(mp.rollingMinFee) < mp.cfg.Policy.MinRelayTxFee {
+		return mp.cfg.Policy.MinRelayTxFee
+	}
+	return types.Amount(mp.rollingMinFee)
+}
+
+// MinFeeRate returns the fee rate in atoms/kB a new transaction has to pay to
+// enter the pool, which is above the minimum relay fee when transactions were
+// recently evicted from the full pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MinFeeRate() types.Amount {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+	return mp.minFeeRate()
+}
+
+// PoolSize returns the total serialized size of the transactions in the pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) PoolSize() int64 {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+	return mp.poolSize
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// testSpendTx returns a transaction with a single output spending the passed
+// outpoint.
+func testSpendTx(prevOut *types.TxOutPoint) *types.Tx {
+	tx := types.NewTransaction()
+	tx.AddTxIn(types.NewTxInput(prevOut, []byte{0x51}))
+	tx.AddTxOut(types.NewTxOutput(1e8, []byte{0x51}))
+	return types.NewTx(tx)
+}
+
+// testExternalTx returns a transaction spending an outpoint which isn't in the
+// pool.
+func testExternalTx(seed byte) *types.Tx {
+	prevHash := hash.HashH([]byte{seed})
+	return testSpendTx(types.NewOutPoint(&prevHash, 0))
+}
+
+// TestLimitPoolSize ensures the transaction packages with the lowest fee rate
+// are evicted from the full pool, a parent paid for by its child is kept, and
+// the minimum fee rate of the pool is raised above the evicted fee rate.
+func TestLimitPoolSize(t *testing.T) {
+	mp := New(&Config{Policy: Policy{MinRelayTxFee: 1000}})
+	view := blockchain.NewUtxoViewpoint()
+
+	// The parent pays the lowest fee, but its child pays for both of them.
+	parent := testExternalTx(1)
+	child := testSpendTx(types.NewOutPoint(parent.Hash(), 0))
+	cheap := testExternalTx(2)
+	cheapChild := testSpendTx(types.NewOutPoint(cheap.Hash(), 0))
+	other := testExternalTx(3)
+
+	mp.addTransaction(view, parent, 1, 10)
+	mp.addTransaction(view, cheap, 1, 100)
+	mp.addTransaction(view, cheapChild, 1, 150)
+	mp.addTransaction(view, other, 1, 5000)
+	mp.addTransaction(view, child, 1, 100000)
+
+	if mp.feeRates.Len() != len(mp.pool) {
+		t.Fatalf("fee rate heap has %d transactions, pool has %d",
+			mp.feeRates.Len(), len(mp.pool))
+	}
+	if worst := mp.feeRates[0]; !worst.Tx.Hash().IsEqual(cheap.Hash()) {
+		t.Fatalf("lowest fee rate transaction %v, want %v",
+			worst.Tx.Hash(), cheap.Hash())
+	}
+
+	mp.cfg.Policy.MaxPoolSize = mp.poolSize - 1
+	mp.limitPoolSize()
+
+	for _, tx := range []*types.Tx{cheap, cheapChild} {
+		if mp.haveTransaction(tx.Hash()) {
+			t.Errorf("transaction %v was not evicted", tx.Hash())
+		}
+	}
+	for _, tx := range []*types.Tx{parent, child, other} {
+		if !mp.haveTransaction(tx.Hash()) {
+			t.Errorf("transaction %v was evicted", tx.Hash())
+		}
+	}
+	if mp.feeRates.Len() != len(mp.pool) {
+		t.Fatalf("fee rate heap has %d transactions, pool has %d",
+			mp.feeRates.Len(), len(mp.pool))
+	}
+	for i, txD := range mp.feeRates {
+		if txD.heapIndex != i {
+			t.Errorf("transaction %v has heap index %d, want %d",
+				txD.Tx.Hash(), txD.heapIndex, i)
+		}
+	}
+
+	size := int64(cheap.Transaction().SerializeSize())
+	evictedFeeRate := 250 * 1000 / (2 * size)
+	if want := float64(evictedFeeRate + 1000); mp.rollingMinFee != want {
+		t.Errorf("rolling minimum fee rate %v, want %v", mp.rollingMinFee,
+			want)
+	}
+	if got := mp.minFeeRate(); got <= mp.cfg.Policy.MinRelayTxFee {
+		t.Errorf("minimum fee rate %v was not raised", got)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"sort"
+)
+
+// feeHistogramRates are the lower fee rates in atoms/kB of the ranges of the
+// fee rate histogram of the pool.
+var feeHistogramRates = []int64{
+	1000, 2000, 3000, 4000, 5000, 6000, 7000, 8000, 10000, 12000, 14000,
+	17000, 20000, 25000, 30000, 40000, 50000, 60000, 70000, 80000, 100000,
+	120000, 140000, 170000, 200000, 250000, 300000, 400000, 500000,
+	600000, 700000, 800000, 1000000, 1200000, 1400000, 1700000, 2000000,
+	2500000, 3000000, 4000000, 5000000, 6000000, 7000000, 8000000,
+	10000000,
+}
+
+// FetchTxDesc returns a copy of the descriptor of the passed transaction of
+// the pool, so its package statistics don't change once the lock is released.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchTxDesc(txHash *hash.Hash) (*TxDesc, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txD, exists := mp.pool[*txHash]
+	if !exists {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+	descCopy := *txD
+	return &descCopy, nil
+}
+
+// TestAccept runs all the checks done before accepting the passed transaction
+// into the pool without adding it.  The error tells why the transaction would
+// be rejected and can be turned into a reject code with ErrToRejectErr.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) TestAccept(tx *types.Tx, allowHighFees bool) error {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	missingParents, err := mp.maybeAcceptTransaction(tx, true, false,
+		allowHighFees, true)
+	if err != nil {
+		return err
+	}
+	if len(missingParents) > 0 {
+		str := fmt.Sprintf("transaction %v spends unknown output of "+
+			"transaction %v", tx.Hash(), missingParents[0])
+		return txRuleError(message.RejectInvalid, str)
+	}
+	return nil
+}
+
+// MempoolInfo returns the usage of the pool and of the orphan pool and the fee
+// rates required to enter it.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MempoolInfo() *json.GetMempoolInfoResult {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	var orphanBytes int64
+	for _, tx := range mp.orphans {
+		orphanBytes += int64(tx.Transaction().SerializeSize())
+	}
+	return &json.GetMempoolInfoResult{
+		Size:          int64(len(mp.pool)),
+		Bytes:         mp.poolSize,
+		MaxMempool:    mp.cfg.Policy.MaxPoolSize,
+		MempoolMinFee: mp.minFeeRate().ToUnit(types.AmountCoin),
+		MinRelayTxFee: mp.cfg.Policy.MinRelayTxFee.ToUnit(types.AmountCoin),
+		Orphans:       int64(len(mp.orphans)),
+		OrphanBytes:   orphanBytes,
+	}
+}
+
+// FeeHistogram returns the number, the total size and the total fees of the
+// transactions of the pool per fee rate range.  The transactions below the
+// lowest range are counted in it.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FeeHistogram() []json.FeeHistogramBucket {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	histogram := make([]json.FeeHistogramBucket, len(feeHistogramRates))
+	for i, rate := range feeHistogramRates {
+		histogram[i].FeeRateFrom = types.Amount(rate).ToUnit(types.AmountCoin)
+		if i+1 < len(feeHistogramRates) {
+			histogram[i].FeeRateTo = types.Amount(feeHistogramRates[i+1]).
+				ToUnit(types.AmountCoin)
+		}
+	}
+
+	fees := make([]int64, len(feeHistogramRates))
+	for _, txD := range mp.pool {
+		i := sort.Search(len(feeHistogramRates), func(i int) bool {
+			return feeHistogramRates[i] > txD.FeePerKB
+		}) - 1
+		if i < 0 {
+			i = 0
+		}
+		histogram[i].Count++
+		histogram[i].Size += int64(txD.Tx.Transaction().SerializeSize())
+		fees[i] += txD.Fee
+	}
+	for i := range histogram {
+		histogram[i].Fees = types.Amount(fees[i]).ToUnit(types.AmountCoin)
+	}
+	return histogram
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// testFundingTx returns a confirmed transaction with the passed number of
+// outputs the transactions of the tests can spend.
+func testFundingTx(outputs int) *types.Tx {
+	fundingTx := types.NewTransaction()
+	fundingTx.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{1}, 0),
+		[]byte{0x51}))
+	for i := 0; i < outputs; i++ {
+		fundingTx.AddTxOut(types.NewTxOutput(1e8, []byte{0x51}))
+	}
+	return types.NewTx(fundingTx)
+}
+
+// testFeeTx returns a transaction spending the passed outpoint of 1e8 atoms
+// which pays the passed fee.
+func testFeeTx(prevOut *types.TxOutPoint, fee uint64) *types.Tx {
+	tx := testSpendTx(prevOut)
+	tx.Tx.TxOut[0].Amount -= fee
+	tx.RefreshHash()
+	return tx
+}
+
+// TestGetMempoolEntry ensures the entry of a transaction of the pool has its
+// fee, size, time and package statistics along with the transactions of the
+// pool it spends, and that the descriptor it is built from is a copy which
+// doesn't change with the pool.
+func TestGetMempoolEntry(t *testing.T) {
+	funding := testFundingTx(2)
+	mp := newTestPersistPool("", funding)
+	api := NewPublicMempoolAPI(mp)
+
+	parent := testFeeTx(types.NewOutPoint(funding.Hash(), 0), 10000)
+	other := testFeeTx(types.NewOutPoint(funding.Hash(), 1), 20000)
+	childTx := types.NewTransaction()
+	childTx.AddTxIn(types.NewTxInput(types.NewOutPoint(parent.Hash(), 0),
+		[]byte{0x51}))
+	childTx.AddTxIn(types.NewTxInput(types.NewOutPoint(other.Hash(), 0),
+		[]byte{0x51}))
+	childTx.AddTxOut(types.NewTxOutput(2e8-30000-40000, []byte{0x51}))
+	child := types.NewTx(childTx)
+
+	if _, err := mp.ProcessTransaction(parent, false, false, true); err != nil {
+		t.Fatalf("ProcessTransaction %v: %v", parent.Hash(), err)
+	}
+	parentDesc, err := mp.FetchTxDesc(parent.Hash())
+	if err != nil {
+		t.Fatalf("FetchTxDesc: %v", err)
+	}
+	for _, tx := range []*types.Tx{other, child} {
+		if _, err := mp.ProcessTransaction(tx, false, false, true); err != nil {
+			t.Fatalf("ProcessTransaction %v: %v", tx.Hash(), err)
+		}
+	}
+	if parentDesc.DescendantCount != 1 || parentDesc.DescendantFees != 10000 {
+		t.Errorf("fetched descriptor changed with the pool: %d "+
+			"descendants paying %d", parentDesc.DescendantCount,
+			parentDesc.DescendantFees)
+	}
+
+	size := func(tx *types.Tx) int64 {
+		return int64(tx.Transaction().SerializeSize())
+	}
+	coins := func(atoms int64) float64 {
+		return types.Amount(atoms).ToUnit(types.AmountCoin)
+	}
+	parents := []string{parent.Hash().String(), other.Hash().String()}
+	if parents[1] < parents[0] {
+		parents[0], parents[1] = parents[1], parents[0]
+	}
+	tests := []struct {
+		name        string
+		tx          *types.Tx
+		fee         int64
+		ancestors   int64
+		ancestorFee int64
+		descendants int64
+		descSize    int64
+		depends     []string
+	}{
+		{"parent", parent, 10000, 1, 10000, 2,
+			size(parent) + size(child), nil},
+		{"other parent", other, 20000, 1, 20000, 2,
+			size(other) + size(child), nil},
+		{"child", child, 40000, 3, 70000, 1, size(child), parents},
+	}
+	for _, test := range tests {
+		result, err := api.GetMempoolEntry(*test.tx.Hash())
+		if err != nil {
+			t.Errorf("%s: GetMempoolEntry: %v", test.name, err)
+			continue
+		}
+		entry := result.(*json.MempoolEntryResult)
+		txD := mp.pool[*test.tx.Hash()]
+		if entry.Size != int32(size(test.tx)) ||
+			entry.Fee != coins(test.fee) ||
+			entry.Time != txD.Added.Unix() ||
+			entry.StartingPriority != txD.StartingPriority {
+			t.Errorf("%s: entry has size %d fee %v time %d priority "+
+				"%v, want %d, %v, %d and %v", test.name, entry.Size,
+				entry.Fee, entry.Time, entry.StartingPriority,
+				size(test.tx), coins(test.fee), txD.Added.Unix(),
+				txD.StartingPriority)
+		}
+		if entry.AncestorCount != test.ancestors ||
+			entry.AncestorFees != coins(test.ancestorFee) ||
+			entry.DescendantCount != test.descendants ||
+			entry.DescendantSize != test.descSize {
+			t.Errorf("%s: entry has %d ancestors paying %v and %d "+
+				"descendants of %d bytes, want %d paying %v and "+
+				"%d of %d bytes", test.name, entry.AncestorCount,
+				entry.AncestorFees, entry.DescendantCount,
+				entry.DescendantSize, test.ancestors,
+				coins(test.ancestorFee), test.descendants,
+				test.descSize)
+		}
+		if !reflect.DeepEqual(entry.Depends, test.depends) {
+			t.Errorf("%s: entry depends on %v, want %v", test.name,
+				entry.Depends, test.depends)
+		}
+	}
+
+	if _, err := api.GetMempoolEntry(hash.HashH([]byte("unknown"))); err == nil {
+		t.Errorf("GetMempoolEntry of an unknown transaction succeeded")
+	}
+}
+
+// TestTestMempoolAccept ensures the transactions checked against the pool get
+// the reject code and reason they would be rejected with and are not added.
+func TestTestMempoolAccept(t *testing.T) {
+	funding := testFundingTx(3)
+	mp := newTestPersistPool("", funding)
+	api := NewPublicMempoolAPI(mp)
+
+	inPool := testFeeTx(types.NewOutPoint(funding.Hash(), 0), 10000)
+	if _, err := mp.ProcessTransaction(inPool, false, false, true); err != nil {
+		t.Fatalf("ProcessTransaction %v: %v", inPool.Hash(), err)
+	}
+	prevHash := hash.HashH([]byte("unknown"))
+
+	tests := []struct {
+		name    string
+		tx      *types.Tx
+		allowed bool
+		code    message.RejectCode
+	}{
+		{"valid", testFeeTx(types.NewOutPoint(funding.Hash(), 1), 10000),
+			true, 0},
+		{"fee too low", testFeeTx(types.NewOutPoint(funding.Hash(), 2), 0),
+			false, message.RejectInsufficientFee},
+		{"already in the pool", inPool, false, message.RejectDuplicate},
+		{"spends the outputs of a transaction of the pool",
+			testFeeTx(types.NewOutPoint(funding.Hash(), 0), 20000),
+			false, message.RejectDuplicate},
+		{"missing parent", testFeeTx(types.NewOutPoint(&prevHash, 0),
+			10000), false, message.RejectInvalid},
+	}
+	for _, test := range tests {
+		serialized, err := test.tx.Transaction().Serialize()
+		if err != nil {
+			t.Fatalf("%s: Serialize: %v", test.name, err)
+		}
+		result, err := api.TestMempoolAccept(
+			[]string{hex.EncodeToString(serialized)}, nil)
+		if err != nil {
+			t.Errorf("%s: TestMempoolAccept: %v", test.name, err)
+			continue
+		}
+		got := result.([]json.TestMempoolAcceptResult)[0]
+		if got.TxId != test.tx.Hash().String() || got.Allowed != test.allowed {
+			t.Errorf("%s: transaction %s allowed %v, want %v allowed %v",
+				test.name, got.TxId, got.Allowed, test.tx.Hash(),
+				test.allowed)
+			continue
+		}
+		if test.allowed {
+			if got.RejectCode != "" || got.RejectReason != "" {
+				t.Errorf("%s: allowed transaction rejected with %s: %s",
+					test.name, got.RejectCode, got.RejectReason)
+			}
+			continue
+		}
+		if got.RejectCode != test.code.String() || got.RejectReason == "" {
+			t.Errorf("%s: rejected with %s: %q, want %s", test.name,
+				got.RejectCode, got.RejectReason, test.code)
+		}
+	}
+	if len(mp.pool) != 1 || len(mp.orphans) != 0 {
+		t.Errorf("pool has %d transactions and %d orphans, want only the "+
+			"transaction added", len(mp.pool), len(mp.orphans))
+	}
+
+	if _, err := api.TestMempoolAccept([]string{"zz"}, nil); err == nil {
+		t.Errorf("TestMempoolAccept of invalid hex succeeded")
+	}
+}
+
+// TestFeeHistogram ensures the transactions of the pool are counted in the
+// range of their fee rate, with the ones below the lowest range counted in it
+// and the last range open ended.
+func TestFeeHistogram(t *testing.T) {
+	mp := New(&Config{Policy: Policy{MinRelayTxFee: 1000}})
+	view := blockchain.NewUtxoViewpoint()
+
+	tests := []struct {
+		name   string
+		rate   int64
+		bucket int
+	}{
+		{"below the lowest range", 500, 0},
+		{"lowest rate", 1000, 0},
+		{"just below the second range", 1980, 0},
+		{"second range", 2000, 1},
+		{"between ranges", 9000, 7},
+		{"highest rate", 10000000, len(feeHistogramRates) - 1},
+		{"above the highest rate", 50000000, len(feeHistogramRates) - 1},
+	}
+	want := make([]json.FeeHistogramBucket, len(feeHistogramRates))
+	fees := make([]int64, len(feeHistogramRates))
+	for i, test := range tests {
+		tx := testExternalTx(byte(i))
+		size := int64(tx.Transaction().SerializeSize())
+		fee := (test.rate*size + 999) / 1000
+		mp.addTransaction(view, tx, 1, fee)
+		want[test.bucket].Count++
+		want[test.bucket].Size += size
+		fees[test.bucket] += fee
+	}
+
+	histogram := mp.FeeHistogram()
+	if len(histogram) != len(want) {
+		t.Fatalf("histogram has %d ranges, want %d", len(histogram),
+			len(want))
+	}
+	for i, bucket := range histogram {
+		from := types.Amount(feeHistogramRates[i]).ToUnit(types.AmountCoin)
+		to := 0.0
+		if i+1 < len(feeHistogramRates) {
+			to = types.Amount(feeHistogramRates[i+1]).
+				ToUnit(types.AmountCoin)
+		}
+		fee := types.Amount(fees[i]).ToUnit(types.AmountCoin)
+		if bucket.FeeRateFrom != from || bucket.FeeRateTo != to ||
+			bucket.Count != want[i].Count || bucket.Size != want[i].Size ||
+			bucket.Fees != fee {
+			t.Errorf("range %d is %v-%v with %d transactions of %d "+
+				"bytes paying %v, want %v-%v with %d of %d paying "+
+				"%v", i, bucket.FeeRateFrom, bucket.FeeRateTo,
+				bucket.Count, bucket.Size, bucket.Fees, from, to,
+				want[i].Count, want[i].Size, fee)
+		}
+	}
+}
@@ -6,10 +6,11 @@
 package mempool
 
 import (
+	"container/heap"
 	"container/list"
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/message"
 	"github.com/Qitmeer/qitmeer/log"
@@ -35,6 +36,20 @@ type TxPool struct {
 
 	pennyTotal    float64 // exponentially decaying total for penny spends.
 	lastPennyUnix int64   // unix time of last ``penny spend''
+
+	// poolSize is the total serialized size of the transactions in the
+	// pool.
+	poolSize int64
+
+	// rollingMinFee is the minimum fee rate in atoms/kB required to enter
+	// the pool after transactions were evicted to keep it under its
+	// maximum size.  It decays exponentially since lastRollingFeeUpdate.
+	rollingMinFee        float64
+	lastRollingFeeUpdate int64
+
+	// feeRates orders the transactions of the pool by the fee rate of
+	// their descendant packages for the eviction of the cheapest ones.
+	feeRates feeRateHeap
 }
 
 // New returns a new memory pool for validating and storing standalone
@@ -57,6 +72,30 @@ type TxDesc struct {
 	// StartingPriority is the priority of the transaction when it was added
 	// to the pool.
 	StartingPriority float64
+
+	// The count, the total serialized size and the total fees of the
+	// unconfirmed ancestors of the transaction in the pool, including the
+	// transaction itself.
+	AncestorCount int64
+	AncestorSize  int64
+	AncestorFees  int64
+
+	// The count, the total serialized size and the total fees of the
+	// descendants of the transaction in the pool, including the
+	// transaction itself.
+	DescendantCount int64
+	DescendantSize  int64
+	DescendantFees  int64
+
+	// heapIndex is the position of the descriptor in the fee rate heap of
+	// the pool.
+	heapIndex int
+}
+
+// descendantFeeRate returns the fee rate in atoms/kB of the transaction with
+// all its descendants, which is what a miner gets by including them.
+func (txD *TxDesc) descendantFeeRate() int64 {
+	return txD.DescendantFees * 1000 / txD.DescendantSize
 }
 
 // TxDescs returns a slice of descriptors for all the transactions in the pool.
@@ -103,12 +142,31 @@ func (mp *TxPool) removeTransaction(theTx *types.Tx, removeRedeemers bool) {
 		if mp.cfg.AddrIndex != nil {
 			mp.cfg.AddrIndex.RemoveUnconfirmedTx(txHash)
 		}
+		if mp.cfg.ExistsAddrIndex != nil {
+			mp.cfg.ExistsAddrIndex.RemoveUnconfirmedTx(tx)
+		}
+		if mp.cfg.AddrUtxoIndex != nil {
+			mp.cfg.AddrUtxoIndex.RemoveUnconfirmedTx(txHash)
+		}
+
+		// The transactions of a connected block were already
+		// registered with the fee estimator, so only the ones leaving
+		// the pool unconfirmed are still tracked.
+		if mp.cfg.FeeEstimator != nil {
+			mp.cfg.FeeEstimator.RemoveTransaction(txHash)
+		}
 		// Mark the referenced outpoints as unspent by the pool.
 
 		for _, txIn := range txDesc.Tx.Transaction().TxIn {
 			delete(mp.outpoints, txIn.PreviousOut)
 		}
 		delete(mp.pool, *txHash)
+		heap.Remove(&mp.feeRates, txDesc.heapIndex)
+		mp.poolSize -= int64(tx.SerializeSize())
+
+		// The transaction is no longer part of the packages of its
+		// ancestors and descendants.
+		mp.updatePackageStats(txDesc, mp.txPackage(theTx), -1)
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 	}
 }
@@ -155,21 +213,34 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint,
 	tx *types.Tx, height uint64, fee int64) {
 
 	// Add the transaction to the pool and mark the referenced outpoints
-	// as spent by the pool.
+	// as spent by the pool.  Its package is looked up first, since it is
+	// described without the transaction.
+	pkg := mp.txPackage(tx)
 	msgTx := tx.Transaction()
-	mp.pool[*tx.Hash()] = &TxDesc{
+	size := int64(tx.Tx.SerializeSize())
+	txD := &TxDesc{
 		TxDesc: types.TxDesc{
 			Tx:       tx,
 			Added:    time.Now(),
 			Height:   int64(height), //todo: fix type conversion
 			Fee:      fee,
-			FeePerKB: fee * 1000 / int64(tx.Tx.SerializeSize()),
+			FeePerKB: fee * 1000 / size,
 		},
 		StartingPriority: CalcPriority(msgTx, utxoView, height, mp.cfg.BD),
+		AncestorCount:    1,
+		AncestorSize:     size,
+		AncestorFees:     fee,
+		DescendantCount:  1,
+		DescendantSize:   size,
+		DescendantFees:   fee,
 	}
+	mp.pool[*tx.Hash()] = txD
+	heap.Push(&mp.feeRates, txD)
 	for _, txIn := range msgTx.TxIn {
 		mp.outpoints[txIn.PreviousOut] = tx
 	}
+	mp.updatePackageStats(txD, pkg, 1)
+	mp.poolSize += int64(msgTx.SerializeSize())
 	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 
 	// Add unconfirmed address index entries associated with the transaction
@@ -180,6 +251,9 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint,
 	if mp.cfg.ExistsAddrIndex != nil {
 		mp.cfg.ExistsAddrIndex.AddUnconfirmedTx(msgTx)
 	}
+	if mp.cfg.AddrUtxoIndex != nil {
+		mp.cfg.AddrUtxoIndex.AddUnconfirmedTx(tx, utxoView)
+	}
 }
 
 //Call addTransaction
@@ -190,10 +264,11 @@ func (mp *TxPool) AddTransaction(utxoView *blockchain.UtxoViewpoint,
 
 // maybeAcceptTransaction is the internal function which implements the public
 // MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
-// more details.
+// more details.  When testAccept is set, the transaction is only checked and
+// not added to the pool.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHighFees bool) ([]*hash.Hash, error) {
+func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHighFees, testAccept bool) ([]*hash.Hash, error) {
 	msgTx := tx.Transaction()
 	txHash := tx.Hash()
 
@@ -273,7 +348,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 	// at this point.  There is a more in-depth check that happens later
 	// after fetching the referenced transaction inputs from the main chain
 	// which examines the actual spend data and prevents double spends.
-	err = mp.checkPoolDoubleSpend(tx)
+	isReplacement, err := mp.checkPoolDoubleSpend(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -323,6 +398,13 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 		return missingParents, nil
 	}
 
+	// Don't allow the transaction to grow the unconfirmed packages it is
+	// part of beyond the limits of the policy.
+	err = mp.checkPackageLimits(tx)
+	if err != nil {
+		return nil, err
+	}
+
 	// Don't allow the transaction into the mempool unless its sequence
 	// lock is active, meaning that it'll be allowed into the next block
 	// with respect to its defined relative lock times.
@@ -396,7 +478,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 
 	// Don't allow transactions with fees too low to get into a mined block.
 	serializedSize := int64(msgTx.SerializeSize())
-	minFee := calcMinRequiredTxRelayFee(serializedSize,
+	minFee := CalcMinRequiredTxRelayFee(serializedSize,
 		mp.cfg.Policy.MinRelayTxFee)
 	if txFee < minFee {
 		str := fmt.Sprintf("transaction %v has %v fees which "+
@@ -405,6 +487,24 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 		return nil, txRuleError(message.RejectInsufficientFee, str)
 	}
 
+	// Don't allow new transactions with fees too low to replace the ones
+	// recently evicted from the full pool.  Transactions which are being
+	// added back to the memory pool from blocks that have been
+	// disconnected during a reorg are exempted.
+	if isNew {
+		poolMinFeeRate := mp.minFeeRate()
+		if poolMinFeeRate > mp.cfg.Policy.MinRelayTxFee {
+			poolMinFee := CalcMinRequiredTxRelayFee(serializedSize,
+				poolMinFeeRate)
+			if txFee < poolMinFee {
+				str := fmt.Sprintf("transaction %v has %v fees which "+
+					"is under the mempool min fee of %v", txHash,
+					txFee, poolMinFee)
+				return nil, txRuleError(message.RejectInsufficientFee, str)
+			}
+		}
+	}
+
 	// Require that free transactions have sufficient priority to be mined
 	// in the next block.  Transactions which are being added back to the
 	// memory pool from blocks that have been disconnected during a reorg
@@ -452,7 +552,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 	// sure the current fee is sensible.  If people would like to avoid this
 	// check then they can AllowHighFees = true
 	if !allowHighFees {
-		maxFee := calcMinRequiredTxRelayFee(serializedSize*maxRelayFeeMultiplier,
+		maxFee := CalcMinRequiredTxRelayFee(serializedSize*maxRelayFeeMultiplier,
 			mp.cfg.Policy.MinRelayTxFee)
 		if txFee > maxFee {
 			err = fmt.Errorf("transaction %v has %v fee which is above the "+
@@ -462,6 +562,16 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 		}
 	}
 
+	// If the transaction replaces others in the pool, make sure it pays
+	// enough more fees than the transactions it evicts.
+	var conflicts []*TxDesc
+	if isReplacement {
+		conflicts, err = mp.validateReplacement(tx, txFee)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Verify crypto signatures for each input and reject the transaction if
 	// any don't verify.
 	flags, err := mp.cfg.Policy.StandardVerifyFlags()
@@ -477,9 +587,40 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 		return nil, err
 	}
 
+	// The transaction would be accepted, but it is only being tested.
+	if testAccept {
+		return nil, nil
+	}
+
+	// Now that the transaction is known to be valid, remove the
+	// transactions it replaces together with their descendants, which are
+	// all part of the conflicts, before adding it to the pool.
+	for _, conflict := range conflicts {
+		log.Debug("Replacing transaction", "replaced", conflict.Tx.Hash(),
+			"by", txHash)
+		mp.removeTransaction(conflict.Tx, false)
+	}
+
 	// Add to transaction pool.
 	mp.addTransaction(utxoView, tx, nextBlockHeight, txFee)
 
+	// Evict the transactions with the lowest fee rate when the pool is
+	// over its maximum size, which might be the new transaction itself, in
+	// which case the transactions it replaced are put back.
+	mp.limitPoolSize()
+	if !mp.isTransactionInPool(txHash) {
+		mp.restoreTransactions(conflicts)
+		str := fmt.Sprintf("transaction %v has been evicted from the "+
+			"full mempool due to low fees", txHash)
+		return nil, txRuleError(message.RejectInsufficientFee, str)
+	}
+
+	// Only the transactions which are new to the network tell how long
+	// the transactions paying their fee rate wait to be confirmed.
+	if isNew && mp.cfg.FeeEstimator != nil {
+		mp.cfg.FeeEstimator.ObserveTransaction(mp.pool[*txHash])
+	}
+
 	log.Debug("Accepted transaction", "txHash", txHash, "pool size", len(mp.pool))
 
 	return nil, nil
@@ -540,7 +681,7 @@ func (mp *TxPool) ProcessTransaction(tx *types.Tx, allowOrphan, rateLimit, allow
 	// Potentially accept the transaction to the memory pool.
 	var missingParents []*hash.Hash
 	missingParents, err = mp.maybeAcceptTransaction(tx, true, rateLimit,
-		allowHighFees)
+		allowHighFees, false)
 	if err != nil {
 		return nil, err
 	}
@@ -624,7 +765,7 @@ func (mp *TxPool) maybeAddOrphan(tx *types.Tx) error {
 func (mp *TxPool) MaybeAcceptTransaction(tx *types.Tx, isNew, rateLimit bool) ([]*hash.Hash, error) {
 	// Protect concurrent access.
 	mp.mtx.Lock()
-	hashes, err := mp.maybeAcceptTransaction(tx, isNew, rateLimit, true)
+	hashes, err := mp.maybeAcceptTransaction(tx, isNew, rateLimit, true, false)
 	mp.mtx.Unlock()
 
 	return hashes, err
@@ -716,7 +857,7 @@ func (mp *TxPool) processOrphans(h *hash.Hash) []*types.Tx {
 			// Potentially accept the transaction into the
 			// transaction pool.
 			missingParents, err := mp.maybeAcceptTransaction(tx,
-				true, true, true)
+				true, true, true, false)
 			if err != nil {
 				// TODO: Remove orphans that depend on this
 				// failed transaction.
@@ -821,6 +962,19 @@ func (mp *TxPool) FetchTransaction(txHash *hash.Hash) (*types.Tx, error) {
 	return nil, fmt.Errorf("transaction is not in the pool")
 }
 
+// CheckSpend checks whether the passed outpoint is already spent by a
+// transaction in the mempool.  If that's the case the spending transaction
+// will be returned, if not nil will be returned.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) CheckSpend(op types.TxOutPoint) *types.Tx {
+	mp.mtx.RLock()
+	txR := mp.outpoints[op]
+	mp.mtx.RUnlock()
+
+	return txR
+}
+
 // HaveAllTransactions returns whether or not all of the passed transaction
 // hashes exist in the mempool.
 //
@@ -918,17 +1072,17 @@ func (mp *TxPool) LastUpdated() time.Time {
 }
 
 // MiningDescs returns a slice of mining descriptors for all the transactions
-// in the pool.
+// in the pool.  The descriptors are copies, so their package statistics don't
+// change while a block template is built from them.
 //
 // This is part of the mining.TxSource interface implementation and is safe for
 // concurrent access as required by the interface contract.
-func (mp *TxPool) MiningDescs() []*types.TxDesc {
+func (mp *TxPool) MiningDescs() []*TxDesc {
 	mp.mtx.RLock()
-	descs := make([]*types.TxDesc, len(mp.pool))
-	i := 0
+	descs := make([]*TxDesc, 0, len(mp.pool))
 	for _, desc := range mp.pool {
-		descs[i] = &desc.TxDesc
-		i++
+		descCopy := *desc
+		descs = append(descs, &descCopy)
 	}
 	mp.mtx.RUnlock()
 
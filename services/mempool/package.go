@@ -0,0 +1,299 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"sort"
+)
+
+// txAncestors returns the unconfirmed ancestors in the pool of the passed
+// transaction, which are the transactions of the pool it spends, recursively.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txAncestors(tx *types.Tx) map[hash.Hash]*types.Tx {
+	ancestors := make(map[hash.Hash]*types.Tx)
+	queue := []*types.Tx{tx}
+	for i := 0; i < len(queue); i++ {
+		for _, txIn := range queue[i].Transaction().TxIn {
+			h := txIn.PreviousOut.Hash
+			if _, ok := ancestors[h]; ok {
+				continue
+			}
+			parent, exists := mp.pool[h]
+			if !exists {
+				continue
+			}
+			ancestors[h] = parent.Tx
+			queue = append(queue, parent.Tx)
+		}
+	}
+	return ancestors
+}
+
+// txPackage holds the unconfirmed ancestors and the descendants in the pool of
+// a transaction which is not in the pool, along with the ancestors each
+// descendant has without it.  They tell how the package statistics change when
+// the transaction is added to or removed from the pool.
+type txPackage struct {
+	ancestors   map[hash.Hash]*types.Tx
+	descendants []*types.Tx
+
+	// linked holds, for each descendant, the ancestors of the transaction
+	// which are its ancestors through other transactions too.
+	linked map[hash.Hash]map[hash.Hash]struct{}
+}
+
+// txPackage returns the package of the passed transaction, which must not be
+// in the pool.  Only the descendants of transactions re-added from a
+// disconnected block, or of a transaction being removed while they stay, have
+// ancestors to look up.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txPackage(tx *types.Tx) *txPackage {
+	pkg := &txPackage{
+		ancestors:   mp.txAncestors(tx),
+		descendants: mp.txDescendants(tx)[1:],
+	}
+	if len(pkg.ancestors) == 0 || len(pkg.descendants) == 0 {
+		return pkg
+	}
+	pkg.linked = make(map[hash.Hash]map[hash.Hash]struct{})
+	for _, d := range pkg.descendants {
+		linked := make(map[hash.Hash]struct{})
+		for h := range mp.txAncestors(d) {
+			if _, ok := pkg.ancestors[h]; ok {
+				linked[h] = struct{}{}
+			}
+		}
+		pkg.linked[*d.Hash()] = linked
+	}
+	return pkg
+}
+
+// updatePackageStats adjusts the ancestor and descendant statistics along the
+// edges of the package of the passed transaction, which was just added to the
+// pool when the passed sign is 1 or removed from it when it is -1, and
+// restores the order of its ancestors in the fee rate heap.  A descendant
+// gains or loses the transaction and the ancestors it only has through it,
+// and an ancestor gains or loses the transaction and the descendants it only
+// has through it.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) updatePackageStats(txD *TxDesc, pkg *txPackage, sign int64) {
+	size := int64(txD.Tx.Transaction().SerializeSize())
+	for _, d := range pkg.descendants {
+		descendant := mp.pool[*d.Hash()]
+		descendant.AncestorCount += sign
+		descendant.AncestorSize += sign * size
+		descendant.AncestorFees += sign * txD.Fee
+		for h := range pkg.ancestors {
+			if _, ok := pkg.linked[*d.Hash()][h]; ok {
+				continue
+			}
+			ancestor := mp.pool[h]
+			descendant.AncestorCount += sign
+			descendant.AncestorSize += sign *
+				int64(ancestor.Tx.Transaction().SerializeSize())
+			descendant.AncestorFees += sign * ancestor.Fee
+		}
+	}
+
+	for h := range pkg.ancestors {
+		ancestor := mp.pool[h]
+		ancestor.DescendantCount += sign
+		ancestor.DescendantSize += sign * size
+		ancestor.DescendantFees += sign * txD.Fee
+		for _, d := range pkg.descendants {
+			if _, ok := pkg.linked[*d.Hash()][h]; ok {
+				continue
+			}
+			descendant := mp.pool[*d.Hash()]
+			ancestor.DescendantCount += sign
+			ancestor.DescendantSize += sign *
+				int64(d.Transaction().SerializeSize())
+			ancestor.DescendantFees += sign * descendant.Fee
+		}
+		heap.Fix(&mp.feeRates, ancestor.heapIndex)
+	}
+
+	if sign < 0 {
+		return
+	}
+	for h := range pkg.ancestors {
+		ancestor := mp.pool[h]
+		txD.AncestorCount++
+		txD.AncestorSize += int64(ancestor.Tx.Transaction().SerializeSize())
+		txD.AncestorFees += ancestor.Fee
+	}
+	for _, d := range pkg.descendants {
+		descendant := mp.pool[*d.Hash()]
+		txD.DescendantCount++
+		txD.DescendantSize += int64(d.Transaction().SerializeSize())
+		txD.DescendantFees += descendant.Fee
+	}
+	heap.Fix(&mp.feeRates, txD.heapIndex)
+}
+
+// checkPackageLimits ensures that adding the passed transaction to the pool
+// neither gives it more unconfirmed ancestors than the policy allows nor
+// gives any of its ancestors more descendants than the policy allows.  This
+// bounds the work needed to maintain the package statistics and to select
+// the packages when generating block templates.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkPackageLimits(tx *types.Tx) error {
+	policy := &mp.cfg.Policy
+	size := int64(tx.Transaction().SerializeSize())
+	ancestors := mp.txAncestors(tx)
+
+	ancestorCount, ancestorSize := int64(len(ancestors))+1, size
+	for h := range ancestors {
+		ancestor := mp.pool[h]
+		ancestorSize += int64(ancestor.Tx.Transaction().SerializeSize())
+
+		if policy.MaxDescendantCount > 0 &&
+			ancestor.DescendantCount+1 > policy.MaxDescendantCount {
+			str := fmt.Sprintf("transaction %v would give ancestor "+
+				"%v more than %d descendants in the pool",
+				tx.Hash(), h, policy.MaxDescendantCount)
+			return txRuleError(message.RejectNonstandard, str)
+		}
+		if policy.MaxDescendantSize > 0 &&
+			ancestor.DescendantSize+size > policy.MaxDescendantSize {
+			str := fmt.Sprintf("transaction %v would exceed the "+
+				"descendant size limit of %d bytes of ancestor %v",
+				tx.Hash(), policy.MaxDescendantSize, h)
+			return txRuleError(message.RejectNonstandard, str)
+		}
+	}
+
+	if policy.MaxAncestorCount > 0 && ancestorCount > policy.MaxAncestorCount {
+		str := fmt.Sprintf("transaction %v has too many unconfirmed "+
+			"ancestors: %d > %d", tx.Hash(), ancestorCount,
+			policy.MaxAncestorCount)
+		return txRuleError(message.RejectNonstandard, str)
+	}
+	if policy.MaxAncestorSize > 0 && ancestorSize > policy.MaxAncestorSize {
+		str := fmt.Sprintf("transaction %v exceeds the ancestor size "+
+			"limit: %d > %d bytes", tx.Hash(), ancestorSize,
+			policy.MaxAncestorSize)
+		return txRuleError(message.RejectNonstandard, str)
+	}
+	return nil
+}
+
+// packageDescs returns the descriptors of the passed transactions sorted by
+// their number of ancestors, so parents come before their children.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) packageDescs(txs map[hash.Hash]*types.Tx) []*TxDesc {
+	descs := make([]*TxDesc, 0, len(txs))
+	for h := range txs {
+		if txD, exists := mp.pool[h]; exists {
+			descs = append(descs, txD)
+		}
+	}
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].AncestorCount < descs[j].AncestorCount
+	})
+	return descs
+}
+
+// copyDescs returns copies of the passed descriptors, which stay unchanged
+// once the lock is released.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func copyDescs(descs []*TxDesc) []*TxDesc {
+	copies := make([]*TxDesc, 0, len(descs))
+	for _, desc := range descs {
+		descCopy := *desc
+		copies = append(copies, &descCopy)
+	}
+	return copies
+}
+
+// TxAncestors returns copies of the descriptors of the unconfirmed ancestors
+// in the pool of the passed transaction, parents first.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) TxAncestors(txHash *hash.Hash) ([]*TxDesc, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txD, exists := mp.pool[*txHash]
+	if !exists {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+	return copyDescs(mp.packageDescs(mp.txAncestors(txD.Tx))), nil
+}
+
+// TxDescendants returns copies of the descriptors of the descendants in the
+// pool of the passed transaction, parents first.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) TxDescendants(txHash *hash.Hash) ([]*TxDesc, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txD, exists := mp.pool[*txHash]
+	if !exists {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+	descendants := make(map[hash.Hash]*types.Tx)
+	for _, d := range mp.txDescendants(txD.Tx)[1:] {
+		descendants[*d.Hash()] = d
+	}
+	return copyDescs(mp.packageDescs(descendants)), nil
+}
+
+// mempoolEntry returns the json entry of the passed transaction of the pool.
+// Its dependencies are the transactions of the pool it spends.
+func (mp *TxPool) mempoolEntry(desc *TxDesc) *json.MempoolEntryResult {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	var depends []string
+	seen := make(map[hash.Hash]struct{})
+	for _, txIn := range desc.Tx.Transaction().TxIn {
+		h := txIn.PreviousOut.Hash
+		if _, ok := seen[h]; ok {
+			continue
+		}
+		if _, exists := mp.pool[h]; exists {
+			seen[h] = struct{}{}
+			depends = append(depends, h.String())
+		}
+	}
+	sort.Strings(depends)
+
+	var currentPriority float64
+	utxos, err := mp.fetchInputUtxos(desc.Tx)
+	if err == nil {
+		currentPriority = CalcPriority(desc.Tx.Transaction(), utxos,
+			mp.cfg.BestHeight()+1, mp.cfg.BD)
+	}
+
+	return &json.MempoolEntryResult{
+		Size:             int32(desc.Tx.Transaction().SerializeSize()),
+		Fee:              types.Amount(desc.Fee).ToUnit(types.AmountCoin),
+		Time:             desc.Added.Unix(),
+		Height:           desc.Height,
+		StartingPriority: desc.StartingPriority,
+		CurrentPriority:  currentPriority,
+		AncestorCount:    desc.AncestorCount,
+		AncestorSize:     desc.AncestorSize,
+		AncestorFees:     types.Amount(desc.AncestorFees).ToUnit(types.AmountCoin),
+		DescendantCount:  desc.DescendantCount,
+		DescendantSize:   desc.DescendantSize,
+		DescendantFees:   types.Amount(desc.DescendantFees).ToUnit(types.AmountCoin),
+		Depends:          depends,
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// testPackageTx returns a transaction with the passed number of outputs
+// spending the passed outpoints.
+func testPackageTx(outputs int, prevOuts ...*types.TxOutPoint) *types.Tx {
+	tx := types.NewTransaction()
+	for _, prevOut := range prevOuts {
+		tx.AddTxIn(types.NewTxInput(prevOut, []byte{0x51}))
+	}
+	for i := 0; i < outputs; i++ {
+		tx.AddTxOut(types.NewTxOutput(1e8, []byte{0x51}))
+	}
+	return types.NewTx(tx)
+}
+
+// checkPackageStats ensures the package statistics of every transaction of the
+// pool match the ones recalculated from its ancestors and descendants.
+func checkPackageStats(t *testing.T, name string, mp *TxPool) {
+	t.Helper()
+	for h, txD := range mp.pool {
+		size := int64(txD.Tx.Transaction().SerializeSize())
+		count, ancestorSize, fees := int64(1), size, txD.Fee
+		for ah, ancestor := range mp.txAncestors(txD.Tx) {
+			count++
+			ancestorSize += int64(ancestor.Transaction().SerializeSize())
+			fees += mp.pool[ah].Fee
+		}
+		if txD.AncestorCount != count || txD.AncestorSize != ancestorSize ||
+			txD.AncestorFees != fees {
+			t.Errorf("%s: transaction %v has ancestor stats %d/%d/%d, "+
+				"want %d/%d/%d", name, h, txD.AncestorCount,
+				txD.AncestorSize, txD.AncestorFees, count,
+				ancestorSize, fees)
+		}
+
+		count, descendantSize, fees := int64(0), int64(0), int64(0)
+		for _, d := range mp.txDescendants(txD.Tx) {
+			count++
+			descendantSize += int64(d.Transaction().SerializeSize())
+			fees += mp.pool[*d.Hash()].Fee
+		}
+		if txD.DescendantCount != count ||
+			txD.DescendantSize != descendantSize ||
+			txD.DescendantFees != fees {
+			t.Errorf("%s: transaction %v has descendant stats "+
+				"%d/%d/%d, want %d/%d/%d", name, h,
+				txD.DescendantCount, txD.DescendantSize,
+				txD.DescendantFees, count, descendantSize, fees)
+		}
+	}
+	for i, txD := range mp.feeRates {
+		if txD.heapIndex != i {
+			t.Errorf("%s: transaction %v has heap index %d, want %d",
+				name, txD.Tx.Hash(), txD.heapIndex, i)
+		}
+	}
+}
+
+// TestPackageStats ensures the package statistics adjusted along the edges of
+// the added and removed transactions stay exact, also when a transaction is
+// added below transactions already spending it, as when the transactions of a
+// disconnected block are added back, and when a transaction reaches an
+// ancestor through several paths.
+func TestPackageStats(t *testing.T) {
+	// root is spent by left and right, which are both spent by join, which
+	// is spent by tail.
+	prevHash := hash.HashH([]byte{1})
+	root := testPackageTx(2, types.NewOutPoint(&prevHash, 0))
+	left := testPackageTx(1, types.NewOutPoint(root.Hash(), 0))
+	right := testPackageTx(1, types.NewOutPoint(root.Hash(), 1))
+	join := testPackageTx(1, types.NewOutPoint(left.Hash(), 0),
+		types.NewOutPoint(right.Hash(), 0))
+	tail := testPackageTx(1, types.NewOutPoint(join.Hash(), 0))
+	fees := map[*types.Tx]int64{root: 1000, left: 2000, right: 3000,
+		join: 4000, tail: 5000}
+
+	tests := []struct {
+		name   string
+		add    []*types.Tx
+		remove []*types.Tx
+	}{
+		{"parents first", []*types.Tx{root, left, right, join, tail}, nil},
+		{"children first", []*types.Tx{tail, join, right, left, root}, nil},
+		{"middle last", []*types.Tx{root, tail, join, left, right}, nil},
+		{"remove root", []*types.Tx{root, left, right, join, tail},
+			[]*types.Tx{root}},
+		{"remove one path", []*types.Tx{root, left, right, join, tail},
+			[]*types.Tx{left}},
+		{"remove the join", []*types.Tx{root, left, right, join, tail},
+			[]*types.Tx{join, right}},
+	}
+	for _, test := range tests {
+		mp := New(&Config{Policy: Policy{MinRelayTxFee: 1000}})
+		view := blockchain.NewUtxoViewpoint()
+		for _, tx := range test.add {
+			mp.addTransaction(view, tx, 1, fees[tx])
+			checkPackageStats(t, test.name, mp)
+		}
+		for _, tx := range test.remove {
+			mp.removeTransaction(tx, false)
+			checkPackageStats(t, test.name, mp)
+		}
+	}
+}
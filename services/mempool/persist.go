@@ -0,0 +1,192 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/log"
+	"os"
+	"time"
+)
+
+// persistFileVersion is the version of the format of the file the memory
+// pool is saved to.  Files of other versions are ignored.
+const persistFileVersion uint32 = 1
+
+// persistEntry houses a transaction of the pool as it is saved, with the time
+// it was added to the pool.
+type persistEntry struct {
+	tx    *types.Tx
+	added time.Time
+}
+
+// writePersistEntry writes the serialized transaction followed by the time it
+// was added to the pool.
+func writePersistEntry(w *bufio.Writer, entry *persistEntry) error {
+	serializedTx, err := entry.tx.Transaction().Serialize()
+	if err != nil {
+		return err
+	}
+	err = s.WriteVarBytes(w, 0, serializedTx)
+	if err != nil {
+		return err
+	}
+	return s.WriteElements(w, entry.added.Unix())
+}
+
+// readPersistEntry decodes an entry written by writePersistEntry.
+func readPersistEntry(r *bufio.Reader, maxTxSize uint32) (*persistEntry, error) {
+	serializedTx, err := s.ReadVarBytes(r, 0, maxTxSize, "transaction")
+	if err != nil {
+		return nil, err
+	}
+	var msgTx types.Transaction
+	err = msgTx.Deserialize(bytes.NewReader(serializedTx))
+	if err != nil {
+		return nil, err
+	}
+	var added int64
+	err = s.ReadElements(r, &added)
+	if err != nil {
+		return nil, err
+	}
+	return &persistEntry{
+		tx:    types.NewTx(&msgTx),
+		added: time.Unix(added, 0),
+	}, nil
+}
+
+// Save writes all the transactions of the pool to the persist file, parents
+// before their children, so they can be loaded back with Load.  The file is
+// replaced atomically.  The entries are copied with the pool locked and written
+// without blocking the pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Save() error {
+	if mp.cfg.PersistFile == "" {
+		return fmt.Errorf("mempool persistence is disabled")
+	}
+
+	mp.mtx.RLock()
+	txs := make(map[hash.Hash]*types.Tx, len(mp.pool))
+	for h, txD := range mp.pool {
+		txs[h] = txD.Tx
+	}
+	descs := mp.packageDescs(txs)
+	entries := make([]persistEntry, len(descs))
+	for i, txD := range descs {
+		entries[i] = persistEntry{tx: txD.Tx, added: txD.Added}
+	}
+	mp.mtx.RUnlock()
+
+	tmpFile := mp.cfg.PersistFile + ".new"
+	err := func() error {
+		f, err := os.Create(tmpFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w := bufio.NewWriter(f)
+		err = s.WriteElements(w, persistFileVersion, uint64(len(entries)))
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			err = writePersistEntry(w, &entries[i])
+			if err != nil {
+				return err
+			}
+		}
+		err = w.Flush()
+		if err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	err = os.Rename(tmpFile, mp.cfg.PersistFile)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Saved mempool", "transactions", len(entries), "file",
+		mp.cfg.PersistFile)
+	return nil
+}
+
+// Load reads the transactions saved by Save and adds them back to the pool
+// after validating them again against the current chain.  The expired
+// transactions and the ones which are no longer valid are dropped.  It is not
+// an error when there is no persist file.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Load() error {
+	if mp.cfg.PersistFile == "" {
+		return nil
+	}
+	f, err := os.Open(mp.cfg.PersistFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var version uint32
+	var count uint64
+	err = s.ReadElements(r, &version, &count)
+	if err != nil {
+		return err
+	}
+	if version != persistFileVersion {
+		return fmt.Errorf("unsupported mempool file version %d", version)
+	}
+
+	maxTxSize := uint32(mp.cfg.ChainParams.MaxTxSize)
+	var accepted, expired, failed int
+	for i := uint64(0); i < count; i++ {
+		entry, err := readPersistEntry(r, maxTxSize)
+		if err != nil {
+			return err
+		}
+		txHash := entry.tx.Hash()
+		if blockchain.IsExpired(entry.tx, mp.cfg.BestHeight()+1) {
+			expired++
+			continue
+		}
+		missingParents, err := mp.MaybeAcceptTransaction(entry.tx, true,
+			false)
+		if err != nil || len(missingParents) > 0 {
+			log.Debug("Dropping saved mempool transaction", "hash",
+				txHash, "error", err)
+			failed++
+			continue
+		}
+
+		// Keep the time the transaction was first added to the pool.
+		mp.mtx.Lock()
+		if txD, exists := mp.pool[*txHash]; exists {
+			txD.Added = entry.added
+		}
+		mp.mtx.Unlock()
+		accepted++
+	}
+
+	log.Info("Loaded mempool", "accepted", accepted, "expired", expired,
+		"failed", failed)
+	return nil
+}
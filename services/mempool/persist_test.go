@@ -0,0 +1,119 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+// newTestPersistPool returns a pool persisted to the passed file which accepts
+// the transactions spending the outputs of the passed confirmed transaction.
+func newTestPersistPool(persistFile string, funding *types.Tx) *TxPool {
+	blockHash := hash.HashH([]byte("block"))
+	bd := &blockdag.BlockDAG{}
+	bd.Init(blockdag.GetDAGTypeByIndex(0))
+	return New(&Config{
+		Policy: Policy{
+			MaxTxVersion:         1,
+			DisableRelayPriority: true,
+			AcceptNonStd:         true,
+			MaxOrphanTxs:         10,
+			MaxSigOpsPerTx:       100,
+			MinRelayTxFee:        1000,
+			StandardVerifyFlags: func() (txscript.ScriptFlags, error) {
+				return 0, nil
+			},
+		},
+		ChainParams: &params.PrivNetParams,
+		FetchUtxoView: func(tx *types.Tx) (*blockchain.UtxoViewpoint, error) {
+			view := blockchain.NewUtxoViewpoint()
+			view.AddTxOuts(funding, &blockHash)
+			return view, nil
+		},
+		BestHeight:     func() uint64 { return 100 },
+		PastMedianTime: func() time.Time { return time.Now() },
+		CalcSequenceLock: func(*types.Tx, *blockchain.UtxoViewpoint) (*blockchain.SequenceLock, error) {
+			return &blockchain.SequenceLock{BlockHeight: -1, Time: -1}, nil
+		},
+		BD:          bd,
+		PersistFile: persistFile,
+	})
+}
+
+// TestSaveLoad ensures the transactions saved by a pool are loaded back by a
+// new pool, parents before their children, with the time they were first added
+// to the pool.
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mempool-persist")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	persistFile := filepath.Join(dir, "mempool.dat")
+
+	fundingTx := types.NewTransaction()
+	fundingTx.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{1}, 0),
+		[]byte{0x51}))
+	fundingTx.AddTxOut(types.NewTxOutput(1e8, []byte{0x51}))
+	funding := types.NewTx(fundingTx)
+
+	parentTx := types.NewTransaction()
+	parentTx.AddTxIn(types.NewTxInput(types.NewOutPoint(funding.Hash(), 0),
+		[]byte{0x51}))
+	parentTx.AddTxOut(types.NewTxOutput(1e8-10000, []byte{0x51}))
+	parent := types.NewTx(parentTx)
+	childTx := types.NewTransaction()
+	childTx.AddTxIn(types.NewTxInput(types.NewOutPoint(parent.Hash(), 0),
+		[]byte{0x51}))
+	childTx.AddTxOut(types.NewTxOutput(1e8-20000, []byte{0x51}))
+	child := types.NewTx(childTx)
+
+	mp := newTestPersistPool(persistFile, funding)
+	for _, tx := range []*types.Tx{parent, child} {
+		if _, err := mp.ProcessTransaction(tx, false, false, true); err != nil {
+			t.Fatalf("ProcessTransaction %v: %v", tx.Hash(), err)
+		}
+	}
+	added := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, txD := range mp.TxDescs() {
+		txD.Added = added
+	}
+	if err := mp.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded := newTestPersistPool(persistFile, funding)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(loaded.TxDescs()) != 2 {
+		t.Fatalf("loaded %d transactions, want 2", len(loaded.TxDescs()))
+	}
+	for _, tx := range []*types.Tx{parent, child} {
+		txD, ok := loaded.pool[*tx.Hash()]
+		if !ok {
+			t.Fatalf("transaction %v was not loaded", tx.Hash())
+		}
+		if !txD.Added.Equal(added) {
+			t.Errorf("transaction %v added at %v, want %v", tx.Hash(),
+				txD.Added, added)
+		}
+	}
+	if loaded.PoolSize() != mp.PoolSize() {
+		t.Errorf("loaded pool size %d, want %d", loaded.PoolSize(),
+			mp.PoolSize())
+	}
+}
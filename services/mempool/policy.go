@@ -7,7 +7,7 @@
 package mempool
 
 import (
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 )
 
@@ -90,6 +90,31 @@ const (
 	// when it has not yet been mined into a block.
 	UnminedLayer = 0x7fffffff
 
+	// DefaultMaxPoolSize is the default maximum size in bytes of the
+	// transactions kept in the memory pool.
+	DefaultMaxPoolSize = 300 * 1000 * 1000
+
+	// DefaultMaxAncestorCount is the default maximum number of unconfirmed
+	// ancestors of a transaction in the memory pool, including itself.
+	DefaultMaxAncestorCount = 25
+
+	// DefaultMaxAncestorSize is the default maximum total size in bytes of
+	// a transaction and its unconfirmed ancestors in the memory pool.
+	DefaultMaxAncestorSize = 101000
+
+	// DefaultMaxDescendantCount is the default maximum number of
+	// descendants of a transaction in the memory pool, including itself.
+	DefaultMaxDescendantCount = 25
+
+	// DefaultMaxDescendantSize is the default maximum total size in bytes
+	// of a transaction and its descendants in the memory pool.
+	DefaultMaxDescendantSize = 101000
+
+	// DefaultMaxReplacementEvictions is the default maximum number of
+	// transactions a replacement can evict from the memory pool, including
+	// their descendants.
+	DefaultMaxReplacementEvictions = 100
+
 	// MinHighPriority is the minimum priority value that allows a
 	// transaction to be considered high priority.
 	MinHighPriority = types.AtomsPerCoin * 144.0 / 250
@@ -126,6 +151,23 @@ type Policy struct {
 	// of big orphans.
 	MaxOrphanTxSize int
 
+	// MaxPoolSize is the maximum size in bytes of the transactions kept in
+	// the memory pool.  When it is exceeded the transactions with the
+	// lowest fee rate are evicted.  A value of 0 disables the limit.
+	MaxPoolSize int64
+
+	// MaxAncestorCount and MaxAncestorSize limit the number and the total
+	// size of the unconfirmed ancestors of a transaction in the pool,
+	// including the transaction itself.  A value of 0 disables the limit.
+	MaxAncestorCount int64
+	MaxAncestorSize  int64
+
+	// MaxDescendantCount and MaxDescendantSize limit the number and the
+	// total size of the descendants of a transaction in the pool,
+	// including the transaction itself.  A value of 0 disables the limit.
+	MaxDescendantCount int64
+	MaxDescendantSize  int64
+
 	// MaxSigOpsPerTx is the maximum number of signature operations
 	// in a single transaction we will relay or mine.  It is a fraction
 	// of the max signature operations for a block.
@@ -134,6 +176,16 @@ type Policy struct {
 	// MinRelayTxFee defines the minimum transaction fee in AtomQitmeer/kB
 	MinRelayTxFee types.Amount
 
+	// RejectReplacement, if true, rejects accepting replacement
+	// transactions using the Replace-By-Fee (RBF) signaling policy into
+	// the mempool.
+	RejectReplacement bool
+
+	// MaxReplacementEvictions is the maximum number of transactions a
+	// replacement can evict from the pool, including their descendants.
+	// A value of 0 disables the limit.
+	MaxReplacementEvictions int
+
 	// StandardVerifyFlags defines the function to retrieve the flags to
 	// use for verifying scripts for the block after the current best block.
 	// It must set the verification flags properly depending on the result
@@ -0,0 +1,174 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/log"
+)
+
+// maxRBFSequence is the highest sequence number of an input which signals
+// that the transaction spending it can be replaced by a transaction paying
+// more fees.
+const maxRBFSequence = types.MaxTxInSequenceNum - 2
+
+// optsInReplacement returns whether one of the inputs of the passed
+// transaction signals replacement with its sequence number.
+func optsInReplacement(tx *types.Tx) bool {
+	for _, txIn := range tx.Transaction().TxIn {
+		if txIn.Sequence <= maxRBFSequence {
+			return true
+		}
+	}
+	return false
+}
+
+// signalsReplacement returns whether the passed transaction of the pool can be
+// replaced, which is when it or one of its unconfirmed ancestors opts in to
+// replacement.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) signalsReplacement(tx *types.Tx) bool {
+	if optsInReplacement(tx) {
+		return true
+	}
+	for _, ancestor := range mp.txAncestors(tx) {
+		if optsInReplacement(ancestor) {
+			return true
+		}
+	}
+	return false
+}
+
+// txConflicts returns the transactions of the pool spending the same outputs
+// as the passed transaction along with their descendants, which all leave the
+// pool when it is accepted.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txConflicts(tx *types.Tx) map[hash.Hash]*types.Tx {
+	conflicts := make(map[hash.Hash]*types.Tx)
+	for _, txIn := range tx.Transaction().TxIn {
+		conflict, exists := mp.outpoints[txIn.PreviousOut]
+		if !exists {
+			continue
+		}
+		for _, descendant := range mp.txDescendants(conflict) {
+			conflicts[*descendant.Hash()] = descendant
+		}
+	}
+	return conflicts
+}
+
+// validateReplacement ensures the passed transaction paying the passed fee can
+// replace the transactions of the pool it conflicts with, and returns their
+// descriptors, parents first.  The replacement must not evict more
+// transactions than the policy allows nor spend their outputs or other new
+// unconfirmed outputs, and it must pay a higher fee rate than each of them and
+// at least their fees plus the relay fee of its own size.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) validateReplacement(tx *types.Tx, txFee int64) ([]*TxDesc, error) {
+	conflicts := mp.txConflicts(tx)
+	maxEvictions := mp.cfg.Policy.MaxReplacementEvictions
+	if maxEvictions > 0 && len(conflicts) > maxEvictions {
+		str := fmt.Sprintf("replacement transaction %v evicts %d "+
+			"transactions, more than the maximum of %d", tx.Hash(),
+			len(conflicts), maxEvictions)
+		return nil, txRuleError(message.RejectNonstandard, str)
+	}
+
+	// The conflicts other than the transactions it replaces directly are
+	// their descendants, which no longer exist once it is accepted.
+	for _, txIn := range tx.Transaction().TxIn {
+		if _, ok := conflicts[txIn.PreviousOut.Hash]; ok {
+			str := fmt.Sprintf("replacement transaction %v spends "+
+				"transaction %v which it replaces", tx.Hash(),
+				txIn.PreviousOut.Hash)
+			return nil, txRuleError(message.RejectInvalid, str)
+		}
+	}
+
+	size := int64(tx.Transaction().SerializeSize())
+	feePerKB := txFee * 1000 / size
+	var conflictsFee int64
+	conflictsParents := make(map[hash.Hash]struct{})
+	descs := mp.packageDescs(conflicts)
+	for _, conflict := range descs {
+		if feePerKB <= conflict.FeePerKB {
+			str := fmt.Sprintf("replacement transaction %v has a fee "+
+				"rate of %v/kB, not above the %v/kB of transaction "+
+				"%v", tx.Hash(), types.Amount(feePerKB),
+				types.Amount(conflict.FeePerKB), conflict.Tx.Hash())
+			return nil, txRuleError(message.RejectInsufficientFee, str)
+		}
+		conflictsFee += conflict.Fee
+		for _, txIn := range conflict.Tx.Transaction().TxIn {
+			conflictsParents[txIn.PreviousOut.Hash] = struct{}{}
+		}
+	}
+
+	minFee := conflictsFee + CalcMinRequiredTxRelayFee(size,
+		mp.cfg.Policy.MinRelayTxFee)
+	if txFee < minFee {
+		str := fmt.Sprintf("replacement transaction %v has %v fees "+
+			"which is under the required amount of %v", tx.Hash(),
+			types.Amount(txFee), types.Amount(minFee))
+		return nil, txRuleError(message.RejectInsufficientFee, str)
+	}
+
+	// The unconfirmed outputs it spends must already be spent by the
+	// transactions it replaces, so it can't pull in new ancestors.
+	for _, txIn := range tx.Transaction().TxIn {
+		h := txIn.PreviousOut.Hash
+		if _, ok := conflictsParents[h]; ok {
+			continue
+		}
+		if _, exists := mp.pool[h]; exists {
+			str := fmt.Sprintf("replacement transaction %v spends new "+
+				"unconfirmed output %v", tx.Hash(),
+				txIn.PreviousOut)
+			return nil, txRuleError(message.RejectInvalid, str)
+		}
+	}
+
+	return descs, nil
+}
+
+// restoreTransactions adds back to the pool the passed descriptors of the
+// transactions replaced by a transaction which didn't stay in the pool,
+// parents first.  A transaction whose inputs were evicted or spent meanwhile
+// is not restored.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) restoreTransactions(descs []*TxDesc) {
+	for _, txD := range descs {
+		utxoView, err := mp.fetchInputUtxos(txD.Tx)
+		if err != nil {
+			log.Warn("Unable to restore replaced transaction", "tx",
+				txD.Tx.Hash(), "error", err)
+			continue
+		}
+		spendable := true
+		for _, txIn := range txD.Tx.Transaction().TxIn {
+			entry := utxoView.LookupEntry(txIn.PreviousOut)
+			_, spent := mp.outpoints[txIn.PreviousOut]
+			if entry == nil || entry.IsSpent() || spent {
+				spendable = false
+				break
+			}
+		}
+		if !spendable {
+			continue
+		}
+
+		mp.addTransaction(utxoView, txD.Tx, uint64(txD.Height), txD.Fee)
+		restored := mp.pool[*txD.Tx.Hash()]
+		restored.Added = txD.Added
+		restored.StartingPriority = txD.StartingPriority
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// testReplaceableTx returns a transaction with a single output spending the
+// passed outpoints, whose first input signals replacement when the passed flag
+// is set.
+func testReplaceableTx(signal bool, prevOuts ...*types.TxOutPoint) *types.Tx {
+	tx := types.NewTransaction()
+	for _, prevOut := range prevOuts {
+		tx.AddTxIn(types.NewTxInput(prevOut, []byte{0x51}))
+	}
+	if signal {
+		tx.TxIn[0].Sequence = maxRBFSequence
+	}
+	tx.AddTxOut(types.NewTxOutput(1e8, []byte{0x51}))
+	return types.NewTx(tx)
+}
+
+// TestSignalsReplacement ensures a transaction can be replaced when one of its
+// inputs or one of the inputs of its unconfirmed ancestors signals it.
+func TestSignalsReplacement(t *testing.T) {
+	mp := New(&Config{Policy: Policy{MinRelayTxFee: 1000}})
+	view := blockchain.NewUtxoViewpoint()
+
+	external := func(seed byte) *types.TxOutPoint {
+		prevHash := hash.HashH([]byte{seed})
+		return types.NewOutPoint(&prevHash, 0)
+	}
+	signalling := testReplaceableTx(true, external(1))
+	final := testReplaceableTx(false, external(2))
+	lastSequence := testReplaceableTx(false, external(3))
+	lastSequence.Tx.TxIn[0].Sequence = maxRBFSequence + 1
+	lastSequence.RefreshHash()
+	inherits := testReplaceableTx(false,
+		types.NewOutPoint(signalling.Hash(), 0))
+	grandchild := testReplaceableTx(false,
+		types.NewOutPoint(inherits.Hash(), 0))
+	finalChild := testReplaceableTx(false, types.NewOutPoint(final.Hash(), 0))
+	for _, tx := range []*types.Tx{signalling, final, lastSequence,
+		inherits, grandchild, finalChild} {
+		mp.addTransaction(view, tx, 1, 10000)
+	}
+
+	tests := []struct {
+		name string
+		tx   *types.Tx
+		want bool
+	}{
+		{"signalling", signalling, true},
+		{"final sequence", final, false},
+		{"sequence above the maximum", lastSequence, false},
+		{"child of signalling parent", inherits, true},
+		{"grandchild of signalling transaction", grandchild, true},
+		{"child of final parent", finalChild, false},
+	}
+	for _, test := range tests {
+		if got := mp.signalsReplacement(test.tx); got != test.want {
+			t.Errorf("%s: signalsReplacement = %v, want %v", test.name,
+				got, test.want)
+		}
+	}
+}
+
+// TestValidateReplacement ensures a replacement must pay more than the
+// transactions it evicts, must not evict too many transactions and must not
+// spend the outputs of the transactions it evicts or other new unconfirmed
+// outputs.
+func TestValidateReplacement(t *testing.T) {
+	mp := New(&Config{Policy: Policy{MinRelayTxFee: 1000}})
+	view := blockchain.NewUtxoViewpoint()
+
+	prevHash := hash.HashH([]byte{1})
+	prevOut := types.NewOutPoint(&prevHash, 0)
+	orig := testReplaceableTx(true, prevOut)
+	origChild := testReplaceableTx(false, types.NewOutPoint(orig.Hash(), 0))
+	other := testExternalTx(2)
+	mp.addTransaction(view, orig, 1, 50000)
+	mp.addTransaction(view, origChild, 1, 40000)
+	mp.addTransaction(view, other, 1, 10000)
+
+	tests := []struct {
+		name         string
+		tx           *types.Tx
+		fee          int64
+		maxEvictions int
+		code         message.RejectCode
+		valid        bool
+	}{
+		{"higher fees", testReplaceableTx(false, prevOut), 100000, 0,
+			0, true},
+		{"evictions at the maximum", testReplaceableTx(false, prevOut),
+			100000, 2, 0, true},
+		{"too many evictions", testReplaceableTx(false, prevOut), 100000,
+			1, message.RejectNonstandard, false},
+		{"fee rate not above the evicted one",
+			testReplaceableTx(false, prevOut), 50000, 0,
+			message.RejectInsufficientFee, false},
+		{"absolute fee under the evicted fees",
+			testReplaceableTx(false, prevOut), 60000, 0,
+			message.RejectInsufficientFee, false},
+		{"spends an evicted output", testReplaceableTx(false, prevOut,
+			types.NewOutPoint(orig.Hash(), 0)), 200000, 0,
+			message.RejectInvalid, false},
+		{"spends a new unconfirmed output",
+			testReplaceableTx(false, prevOut,
+				types.NewOutPoint(other.Hash(), 0)), 200000, 0,
+			message.RejectInvalid, false},
+	}
+	for _, test := range tests {
+		mp.cfg.Policy.MaxReplacementEvictions = test.maxEvictions
+		conflicts, err := mp.validateReplacement(test.tx, test.fee)
+		if !test.valid {
+			code, _ := extractRejectCode(err)
+			if err == nil || code != test.code {
+				t.Errorf("%s: got error %v, want reject code %v",
+					test.name, err, test.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if len(conflicts) != 2 || conflicts[0].Tx != orig ||
+			conflicts[1].Tx != origChild {
+			t.Errorf("%s: got %d conflicts, want the original "+
+				"transaction and its child", test.name, len(conflicts))
+		}
+	}
+}
+
+// TestReplacementEvicted ensures the transactions replaced by a transaction
+// which is evicted from the full pool are put back in the pool.
+func TestReplacementEvicted(t *testing.T) {
+	fundingTx := types.NewTransaction()
+	fundingTx.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{1}, 0),
+		[]byte{0x51}))
+	for i := 0; i < 3; i++ {
+		fundingTx.AddTxOut(types.NewTxOutput(1e8, []byte{0x51}))
+	}
+	funding := types.NewTx(fundingTx)
+	mp := newTestPersistPool("", funding)
+
+	spend := func(index uint32, fee uint64, signal bool, outputs int) *types.Tx {
+		tx := testReplaceableTx(signal, types.NewOutPoint(funding.Hash(),
+			index))
+		tx.Tx.TxOut[0].Amount -= fee
+		for i := 1; i < outputs; i++ {
+			tx.Tx.AddTxOut(types.NewTxOutput(0, []byte{0x51}))
+		}
+		tx.RefreshHash()
+		return tx
+	}
+	orig := spend(0, 10000, true, 1)
+	rich := []*types.Tx{spend(1, 1000000, false, 1),
+		spend(2, 1000000, false, 1)}
+	for _, tx := range append([]*types.Tx{orig}, rich...) {
+		if _, err := mp.ProcessTransaction(tx, false, false, true); err != nil {
+			t.Fatalf("ProcessTransaction %v: %v", tx.Hash(), err)
+		}
+	}
+
+	// The replacement pays more than the original transaction but less
+	// than the others, and it is larger, so it doesn't fit in the pool.
+	mp.cfg.Policy.MaxPoolSize = mp.poolSize
+	poolSize := mp.poolSize
+	replacement := spend(0, 100000, false, 3)
+	_, err := mp.ProcessTransaction(replacement, false, false, true)
+	if code, _ := extractRejectCode(err); code != message.RejectInsufficientFee {
+		t.Fatalf("got error %v, want the replacement to be evicted", err)
+	}
+	if mp.haveTransaction(replacement.Hash()) {
+		t.Fatalf("evicted replacement is in the pool")
+	}
+	for _, tx := range append([]*types.Tx{orig}, rich...) {
+		if !mp.haveTransaction(tx.Hash()) {
+			t.Fatalf("transaction %v is not in the pool", tx.Hash())
+		}
+	}
+	if mp.poolSize != poolSize {
+		t.Errorf("pool size %d, want %d", mp.poolSize, poolSize)
+	}
+	if txD := mp.pool[*orig.Hash()]; txD.Fee != 10000 ||
+		txD.heapIndex < 0 || mp.feeRates.Len() != len(mp.pool) {
+		t.Errorf("restored transaction has fee %d and heap index %d",
+			txD.Fee, txD.heapIndex)
+	}
+	if spender := mp.outpoints[*types.NewOutPoint(funding.Hash(), 0)]; spender != orig {
+		t.Errorf("funding output is not spent by the restored transaction")
+	}
+}
@@ -5,12 +5,12 @@
 // license that can be found in the LICENSE file.
 package mempool
 
-import "github.com/Qitmeer/qitmeer-lib/core/types"
+import "github.com/Qitmeer/qitmeer/core/types"
 
-// calcMinRequiredTxRelayFee returns the minimum transaction fee required for a
+// CalcMinRequiredTxRelayFee returns the minimum transaction fee required for a
 // transaction with the passed serialized size to be accepted into the memory
 // pool and relayed.
-func calcMinRequiredTxRelayFee(serializedSize int64, minRelayTxFee types.Amount) int64 {
+func CalcMinRequiredTxRelayFee(serializedSize int64, minRelayTxFee types.Amount) int64 {
 	// Calculate the minimum fee for a transaction to be allowed into the
 	// mempool and relayed by scaling the base fee (which is the minimum
 	// free transaction relay fee).  minTxRelayFee is in Atom/KB, so
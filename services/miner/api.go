@@ -3,20 +3,24 @@
 package miner
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"math/rand"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer-lib/params/dcr/types"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/merkle"
 	"github.com/Qitmeer/qitmeer/rpc"
 	"github.com/Qitmeer/qitmeer/services/mining"
 )
@@ -55,8 +59,7 @@ type PublicMinerAPI struct {
 }
 
 func NewPublicMinerAPI(c *CPUMiner) *PublicMinerAPI {
-	pmAPI := &PublicMinerAPI{miner: c}
-	pmAPI.gbtWorkState = &gbtWorkState{timeSource: c.timeSource}
+	pmAPI := &PublicMinerAPI{miner: c, gbtWorkState: c.gbtWorkState}
 
 	pmAPI.gbtCoinbaseAux = &json.GetBlockTemplateResultAux{
 		Flags: hex.EncodeToString(builderScript(txscript.NewScriptBuilder().
@@ -66,13 +69,19 @@ func NewPublicMinerAPI(c *CPUMiner) *PublicMinerAPI {
 }
 
 //func (api *PublicMinerAPI) GetBlockTemplate(request *mining.TemplateRequest) (interface{}, error){
-func (api *PublicMinerAPI) GetBlockTemplate(capabilities []string) (interface{}, error) {
+// GetBlockTemplate returns a block template to work on.  When the long poll ID
+// of a previous template is passed, the call blocks until that template is
+// stale.  See BIP 0022 for the long polling specification.
+func (api *PublicMinerAPI) GetBlockTemplate(ctx context.Context, capabilities []string, longPollID *string) (interface{}, error) {
 	// Set the default mode and override it if supplied.
 	mode := "template"
 	request := json.TemplateRequest{Mode: mode, Capabilities: capabilities}
+	if longPollID != nil {
+		request.LongPollID = *longPollID
+	}
 	switch mode {
 	case "template":
-		return handleGetBlockTemplateRequest(api, &request)
+		return handleGetBlockTemplateRequest(api, &request, ctx.Done())
 	case "proposal":
 		//TODO LL, will be added
 		//return handleGetBlockTemplateProposal(s, request)
@@ -163,7 +172,7 @@ func (api *PublicMinerAPI) SubmitBlock(hexBlock string) (interface{}, error) {
 // in regards to whether or not it supports creating its own coinbase (the
 // coinbasetxn and coinbasevalue capabilities) and modifies the returned block
 // template accordingly.
-func handleGetBlockTemplateRequest(api *PublicMinerAPI, request *json.TemplateRequest) (interface{}, error) {
+func handleGetBlockTemplateRequest(api *PublicMinerAPI, request *json.TemplateRequest, closeChan <-chan struct{}) (interface{}, error) {
 	// Extract the relevant passed capabilities and restrict the result to
 	// either a coinbase value or a coinbase transaction object depending on
 	// the request.  Default to only providing a coinbase value.
@@ -200,6 +209,14 @@ func handleGetBlockTemplateRequest(api *PublicMinerAPI, request *json.TemplateRe
 			"qitmeer is downloading blocks...")
 	}
 
+	// When a long poll ID was provided, this is a long poll request by the
+	// client to be notified when block template referenced by the ID should
+	// be replaced with a new one.
+	if request != nil && request.LongPollID != "" {
+		return handleGetBlockTemplateLongPoll(api, request.LongPollID,
+			useCoinbaseValue, closeChan)
+	}
+
 	// Protect concurrent access when updating block templates.
 	state := api.gbtWorkState
 	state.Lock()
@@ -216,6 +233,88 @@ func handleGetBlockTemplateRequest(api *PublicMinerAPI, request *json.TemplateRe
 	return state.blockTemplateResult(api, useCoinbaseValue, nil)
 }
 
+// errClientQuit is returned to a long poll request whose client has closed
+// the connection before the template was stale.
+var errClientQuit = errors.New("client quit")
+
+// handleGetBlockTemplateLongPoll is a helper for handleGetBlockTemplateRequest
+// which deals with handling long polling for block templates.  When a caller
+// sends a request with a long poll ID that was previously returned, a response
+// is not sent until the caller should stop working on the previous block
+// template in favor of the new one.  In particular, this is the case when the
+// tips of the DAG change or the transactions in the memory pool have changed
+// and at least gbtRegenerateSeconds have passed since the template was
+// generated.
+func handleGetBlockTemplateLongPoll(api *PublicMinerAPI, longPollID string, useCoinbaseValue bool, closeChan <-chan struct{}) (interface{}, error) {
+	state := api.gbtWorkState
+	state.Lock()
+	// The state unlock is intentionally not deferred here since it needs to
+	// be manually unlocked before waiting for a notification about block
+	// template changes.
+
+	if err := state.updateBlockTemplate(api, useCoinbaseValue); err != nil {
+		state.Unlock()
+		return nil, err
+	}
+
+	// Just return the current block template if the long poll ID provided by
+	// the caller is invalid.
+	parentRoot, lastGenerated, err := decodeTemplateID(longPollID)
+	if err != nil {
+		result, err := state.blockTemplateResult(api, useCoinbaseValue, nil)
+		state.Unlock()
+		return result, err
+	}
+
+	// Return the block template now if the specific block template
+	// identified by the long poll ID no longer matches the current block
+	// template as this means the provided template is stale.
+	templateRoot := &state.template.Block.Header.ParentRoot
+	if !parentRoot.IsEqual(templateRoot) ||
+		lastGenerated != state.lastGenerated.Unix() {
+
+		// Include whether or not it is valid to submit work against the
+		// old block template depending on whether or not the tips of the
+		// DAG have changed since.
+		submitOld := parentRoot.IsEqual(templateRoot)
+		result, err := state.blockTemplateResult(api, useCoinbaseValue,
+			&submitOld)
+		state.Unlock()
+		return result, err
+	}
+
+	// Get a channel that will be notified when the template associated with
+	// the provided ID is stale and a new block template should be returned
+	// to the caller.
+	longPollChan := state.templateUpdateChan(parentRoot, lastGenerated)
+	state.Unlock()
+
+	select {
+	// When the client closes before it's time to send a reply, just return
+	// now so the goroutine doesn't hang around.
+	case <-closeChan:
+		return nil, errClientQuit
+
+	// Wait until signal received to send the reply.
+	case <-longPollChan:
+		// Fallthrough
+	}
+
+	// Get the lastest block template.
+	state.Lock()
+	defer state.Unlock()
+
+	if err := state.updateBlockTemplate(api, useCoinbaseValue); err != nil {
+		return nil, err
+	}
+
+	// Include whether or not it is valid to submit work against the old
+	// block template depending on whether or not the tips of the DAG have
+	// changed since.
+	submitOld := parentRoot.IsEqual(&state.template.Block.Header.ParentRoot)
+	return state.blockTemplateResult(api, useCoinbaseValue, &submitOld)
+}
+
 //LL
 // encodeTemplateID encodes the passed details into an ID that can be used to
 // uniquely identify a block template.
@@ -223,6 +322,28 @@ func encodeTemplateID(prevHash hash.Hash, lastGenerated time.Time) string {
 	return fmt.Sprintf("%s-%d", prevHash.String(), lastGenerated.Unix())
 }
 
+// decodeTemplateID decodes an ID that is used to uniquely identify a block
+// template.  This is mainly used as a mechanism to track when to update clients
+// that are using long polling for block templates.  The ID consists of the
+// parent root of the template and the time it was generated.
+func decodeTemplateID(templateID string) (*hash.Hash, int64, error) {
+	fields := strings.Split(templateID, "-")
+	if len(fields) != 2 {
+		return nil, 0, errors.New("invalid longpollid format")
+	}
+
+	parentRoot, err := hash.NewHashFromStr(fields[0])
+	if err != nil {
+		return nil, 0, errors.New("invalid longpollid format")
+	}
+	lastGenerated, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, 0, errors.New("invalid longpollid format")
+	}
+
+	return parentRoot, lastGenerated, nil
+}
+
 // gbtWorkState houses state that is used in between multiple RPC invocations to
 // getblocktemplate.
 type gbtWorkState struct {
@@ -232,9 +353,128 @@ type gbtWorkState struct {
 	parentsSet    *blockdag.HashSet
 	minTimestamp  time.Time
 	template      *types.BlockTemplate
+	notifyMap     map[hash.Hash]map[int64]chan struct{}
 	timeSource    blockchain.MedianTimeSource
 }
 
+// newGbtWorkState returns a new instance of a gbtWorkState with all internal
+// fields initialized and ready to use.
+func newGbtWorkState(timeSource blockchain.MedianTimeSource) *gbtWorkState {
+	return &gbtWorkState{
+		notifyMap:  make(map[hash.Hash]map[int64]chan struct{}),
+		timeSource: timeSource,
+	}
+}
+
+// notifyLongPollers notifies any channels that have been registered to be
+// notified when block templates are stale.
+//
+// This function MUST be called with the state locked.
+func (state *gbtWorkState) notifyLongPollers(parentRoot *hash.Hash, lastGenerated time.Time) {
+	// Notify anything that is waiting for a block template update from
+	// parents which are not the current tips of the DAG since their work
+	// is now invalid.
+	for root, channels := range state.notifyMap {
+		if !root.IsEqual(parentRoot) {
+			for _, c := range channels {
+				close(c)
+			}
+			delete(state.notifyMap, root)
+		}
+	}
+
+	// Return now if the provided last generated timestamp has not been
+	// initialized.
+	if lastGenerated.IsZero() {
+		return
+	}
+
+	// Return now if there is nothing registered for updates to the current
+	// tips.
+	channels, ok := state.notifyMap[*parentRoot]
+	if !ok {
+		return
+	}
+
+	// Notify anything that is waiting for a block template update from a
+	// block template generated before the most recently generated block
+	// template.
+	lastGeneratedUnix := lastGenerated.Unix()
+	for lastGen, c := range channels {
+		if lastGen < lastGeneratedUnix {
+			close(c)
+			delete(channels, lastGen)
+		}
+	}
+
+	// Remove the entry altogether if there are no more registered
+	// channels.
+	if len(channels) == 0 {
+		delete(state.notifyMap, *parentRoot)
+	}
+}
+
+// NotifyBlockConnected uses the new tips of the DAG to notify any long poll
+// clients with a new block template when their existing block template is
+// stale due to the newly connected block.
+func (state *gbtWorkState) NotifyBlockConnected(tips []*hash.Hash) {
+	state.Lock()
+	defer state.Unlock()
+
+	paMerkles := merkle.BuildParentsMerkleTreeStore(tips)
+	state.notifyLongPollers(paMerkles[len(paMerkles)-1], state.lastTxUpdate)
+}
+
+// NotifyMempoolTx uses the new last updated time for the transaction memory
+// pool to notify any long poll clients with a new block template when their
+// existing block template is stale due to enough time passing and the contents
+// of the memory pool changing.
+func (state *gbtWorkState) NotifyMempoolTx(lastUpdated time.Time) {
+	state.Lock()
+	defer state.Unlock()
+
+	// No need to notify anything if no block templates have been generated
+	// yet.
+	if state.template == nil || state.lastGenerated.IsZero() {
+		return
+	}
+
+	if time.Now().After(state.lastGenerated.Add(time.Second *
+		gbtRegenerateSeconds)) {
+
+		state.notifyLongPollers(&state.template.Block.Header.ParentRoot,
+			lastUpdated)
+	}
+}
+
+// templateUpdateChan returns a channel that will be closed once the block
+// template associated with the passed parent root and last generated time is
+// stale.  The function will return existing channels for duplicate parameters
+// which allows multiple clients to wait for the same block template without
+// requiring a different channel for each client.
+//
+// This function MUST be called with the state locked.
+func (state *gbtWorkState) templateUpdateChan(parentRoot *hash.Hash, lastGenerated int64) chan struct{} {
+	// Either get the current list of channels waiting for updates about
+	// changes to block template for the parent root or create a new one.
+	channels, ok := state.notifyMap[*parentRoot]
+	if !ok {
+		m := make(map[int64]chan struct{})
+		state.notifyMap[*parentRoot] = m
+		channels = m
+	}
+
+	// Get the current channel associated with the time the block template
+	// was last generated or create a new one.
+	c, ok := channels[lastGenerated]
+	if !ok {
+		c = make(chan struct{})
+		channels[lastGenerated] = c
+	}
+
+	return c
+}
+
 // updateBlockTemplate creates or updates a block template for the work state.
 // A new block template will be generated when the current best block has
 // changed or the transactions in the memory pool have been updated and it has
@@ -534,6 +774,35 @@ func (api *PrivateMinerAPI) Generate(numBlocks uint32) ([]string, error) {
 	return reply, nil
 }
 
+// GetStratumWorkers returns the connections of the miners to the Stratum
+// server with their share difficulty, shares and estimated hash rate.
+func (api *PrivateMinerAPI) GetStratumWorkers() (interface{}, error) {
+	if api.miner.stratum == nil {
+		return nil, rpc.RpcInvalidError("The Stratum server is not " +
+			"enabled, see --stratum")
+	}
+	workers := api.miner.stratum.workers()
+	result := make([]json.StratumWorkerResult, 0, len(workers))
+	for _, w := range workers {
+		var lastShare int64
+		if !w.lastShare.IsZero() {
+			lastShare = w.lastShare.Unix()
+		}
+		result = append(result, json.StratumWorkerResult{
+			Worker:     w.worker,
+			Addr:       w.addr,
+			Difficulty: w.difficulty,
+			Accepted:   w.accepted,
+			Rejected:   w.rejected,
+			Blocks:     w.blocks,
+			HashRate:   w.hashrate,
+			LastShare:  lastShare,
+			ConnTime:   w.connected.Unix(),
+		})
+	}
+	return result, nil
+}
+
 func builderScript(builder *txscript.ScriptBuilder) []byte {
 	script, err := builder.Script()
 	if err != nil {
@@ -0,0 +1,125 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/merkle"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// TestDecodeTemplateID ensures the long poll IDs of the templates decode to
+// the parent root and the time they were generated, and the malformed ones
+// are rejected.
+func TestDecodeTemplateID(t *testing.T) {
+	parentRoot := hash.HashH([]byte("parents"))
+	generated := time.Unix(1570000000, 0)
+	tests := []struct {
+		name  string
+		id    string
+		valid bool
+	}{
+		{"encoded", encodeTemplateID(parentRoot, generated), true},
+		{"missing time", parentRoot.String(), false},
+		{"extra field", encodeTemplateID(parentRoot, generated) + "-1",
+			false},
+		{"invalid hash", "zz-1570000000", false},
+		{"invalid time", parentRoot.String() + "-now", false},
+	}
+	for _, test := range tests {
+		root, lastGenerated, err := decodeTemplateID(test.id)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: decoded invalid ID %q", test.name, test.id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: decodeTemplateID: %v", test.name, err)
+			continue
+		}
+		if !root.IsEqual(&parentRoot) || lastGenerated != generated.Unix() {
+			t.Errorf("%s: decoded %v and %d, want %v and %d", test.name,
+				root, lastGenerated, parentRoot, generated.Unix())
+		}
+	}
+}
+
+// TestLongPollNotifications ensures the long poll clients are woken up when
+// the tips of the DAG change, and when the pool changes only once their
+// template is old enough and was generated before the change.
+func TestLongPollNotifications(t *testing.T) {
+	tips := []*hash.Hash{{1}, {2}}
+	paMerkles := merkle.BuildParentsMerkleTreeStore(tips)
+	tipsRoot := *paMerkles[len(paMerkles)-1]
+	otherRoot := hash.HashH([]byte("other parents"))
+	now := time.Now()
+	old := now.Add(-2 * time.Second * gbtRegenerateSeconds)
+
+	// A poller is identified by the parent root and the generation time of
+	// the template it waits on.
+	type poller struct {
+		root      hash.Hash
+		generated int64
+	}
+	pollers := []poller{
+		{tipsRoot, old.Unix()},
+		{tipsRoot, now.Unix()},
+		{otherRoot, now.Unix()},
+	}
+	tests := []struct {
+		name          string
+		notify        func(state *gbtWorkState)
+		lastGenerated time.Time
+		woken         []bool
+	}{
+		{"block connected", func(state *gbtWorkState) {
+			state.NotifyBlockConnected(tips)
+		}, now, []bool{false, false, true}},
+		{"pool changed after a recent template", func(state *gbtWorkState) {
+			state.NotifyMempoolTx(now.Add(time.Second))
+		}, now, []bool{false, false, false}},
+		{"pool changed after an old template", func(state *gbtWorkState) {
+			state.NotifyMempoolTx(now)
+		}, old, []bool{true, false, true}},
+		{"pool changed after every template", func(state *gbtWorkState) {
+			state.NotifyMempoolTx(now.Add(time.Second))
+		}, old, []bool{true, true, true}},
+	}
+	for _, test := range tests {
+		state := newGbtWorkState(blockchain.NewMedianTime())
+		state.lastGenerated = test.lastGenerated
+		state.template = &types.BlockTemplate{Block: &types.Block{
+			Header: types.BlockHeader{ParentRoot: tipsRoot},
+		}}
+		chans := make([]chan struct{}, len(pollers))
+		for i, p := range pollers {
+			chans[i] = state.templateUpdateChan(&p.root, p.generated)
+		}
+		if c := state.templateUpdateChan(&tipsRoot, now.Unix()); c != chans[1] {
+			t.Fatalf("%s: the pollers of the same template don't share "+
+				"a channel", test.name)
+		}
+
+		test.notify(state)
+		for i, c := range chans {
+			woken := false
+			select {
+			case <-c:
+				woken = true
+			default:
+			}
+			if woken != test.woken[i] {
+				t.Errorf("%s: poller of %v at %d woken %v, want %v",
+					test.name, pollers[i].root, pollers[i].generated,
+					woken, test.woken[i])
+			}
+		}
+	}
+}
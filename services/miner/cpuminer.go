@@ -10,11 +10,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/consensus"
+	"github.com/Qitmeer/qitmeer/consensus/pow"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/merkle"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/services/blkmgr"
@@ -28,10 +31,13 @@ const (
 	// maxNonce is the maximum value a nonce can be in a block header.
 	maxNonce = ^uint64(0) // 2^64 - 1
 
-	// TODO, decided if th extra nonce for coinbase-tx need
-	// maxExtraNonce is the maximum value an extra nonce used in a coinbase
-	// transaction can be.
-	maxExtraNonce = ^uint64(0) // 2^64 - 1
+	// workerExtraNonceBits is the number of the low bits of the extra nonces
+	// searched by a worker.  The bits above hold the index of the worker.
+	workerExtraNonceBits = 48
+
+	// maxWorkerExtraNonce is the maximum value of the low bits of the extra
+	// nonces searched by a worker.
+	maxWorkerExtraNonce = 1<<workerExtraNonceBits - 1 // 2^48 - 1
 
 	// hpsUpdateSecs is the number of seconds to wait in between each
 	// update to the hashes per second monitor.
@@ -65,6 +71,7 @@ type CPUMiner struct {
 	txSource          mining.TxSource
 	timeSource        blockchain.MedianTimeSource
 	blockManager      *blkmgr.BlockManager
+	pow               consensus.PoW
 	numWorkers        uint32
 	started           bool
 	discreteMining    bool
@@ -83,6 +90,14 @@ type CPUMiner struct {
 	// exhaustion. It should not race because it's only
 	// accessed in a single threaded loop below.
 	minedOnParents map[hash.Hash]uint8
+
+	// gbtWorkState is shared by the getblocktemplate callers, so the long
+	// poll clients can be notified of the stale templates.
+	gbtWorkState *gbtWorkState
+
+	// stratum is the Stratum server handing out work to the remote miners,
+	// nil unless it is enabled.
+	stratum *StratumServer
 }
 
 // newCPUMiner returns a new instance of a CPU miner for the provided server.
@@ -99,12 +114,35 @@ func NewCPUMiner(cfg *config.Config, par *params.Params, policy *mining.Policy,
 		txSource:          source,
 		timeSource:        tsource,
 		blockManager:      blkMgr,
+		pow:               pow.New(par),
 		numWorkers:        numWorkers,
 		updateNumWorkers:  make(chan struct{}),
 		queryHashesPerSec: make(chan float64),
 		updateHashes:      make(chan uint64),
 		minedOnParents:    make(map[hash.Hash]uint8),
+		gbtWorkState:      newGbtWorkState(tsource),
+	}
+}
+
+// NotifyBlockConnected notifies the getblocktemplate long poll clients and the
+// Stratum miners that the tips of the DAG have changed.  The work state is
+// locked while a template is generated, so the notification is done
+// asynchronously to not block the caller.
+func (m *CPUMiner) NotifyBlockConnected() {
+	if m.stratum != nil {
+		m.stratum.notifyBlockConnected()
 	}
+	go func() {
+		tips := m.blockManager.GetChain().GetMiningTips()
+		m.gbtWorkState.NotifyBlockConnected(tips)
+	}()
+}
+
+// NotifyMempoolTx notifies the getblocktemplate long poll clients that the
+// transactions in the memory pool have changed.  Like NotifyBlockConnected, it
+// doesn't block the caller.
+func (m *CPUMiner) NotifyMempoolTx() {
+	go m.gbtWorkState.NotifyMempoolTx(m.txSource.LastUpdated())
 }
 
 // GenerateNBlocks generates the requested number of blocks. It is self
@@ -194,7 +232,7 @@ func (m *CPUMiner) GenerateNBlocks(n uint32) ([]*hash.Hash, error) {
 		// with false when conditions that trigger a stale block, so
 		// a new block template can be generated.  When the return is
 		// true a solution was found, so submit the solved block.
-		if m.solveBlock(template.Block, ticker, nil) {
+		if m.solveBlock(template.Block, template.Height, 0, ticker, nil) {
 			block := types.NewBlock(template.Block)
 			block.SetHeight(uint(template.Height))
 			m.submitBlock(block)
@@ -259,69 +297,118 @@ out:
 }
 
 // solveBlock attempts to find some combination of a nonce, extra nonce, and
-// current timestamp which makes the passed block hash to a value less than the
-// target difficulty.  The timestamp is updated periodically and the passed
-// block is modified with all tweaks during this process.  This means that
-// when the function returns true, the block is ready for submission.
+// current timestamp which makes the passed block satisfy the proof of work of
+// the target difficulty by using the proof of work engine of the miner.  The
+// timestamp is updated periodically and the passed block is modified with all
+// tweaks during this process.  This means that when the function returns true,
+// the block is ready for submission.
+//
+// The extra nonce is set in both the coinbase, which changes the merkle root,
+// and the header of the block at the passed height, and moves on whenever the
+// engine exhausts the nonce range.  Every worker searches its own range of
+// extra nonces, see workerExtraNonce, starting at a random offset.
 //
 // This function will return early with false when conditions that trigger a
 // stale block such as a new block showing up or periodically when there are
 // new transactions and enough time has elapsed without finding a solution.
-func (m *CPUMiner) solveBlock(msgBlock *types.Block, ticker *time.Ticker, quit chan struct{}) bool {
+func (m *CPUMiner) solveBlock(msgBlock *types.Block, height uint64, worker uint32, ticker *time.Ticker, quit chan struct{}) bool {
 
-	// TODO, decided if need extra nonce for coinbase-tx
-	// Choose a random extra nonce offset for this block template and
-	// worker.
-	/*
-		enOffset, err := s.RandomUint64()
-		if err != nil {
-			log.Error("Unexpected error while generating random "+
-				"extra nonce offset: %v", err)
-			enOffset = 0
-		}
-	*/
+	// Choose a random extra nonce offset in the range of the worker for
+	// this block template.
+	enOffset, err := s.RandomUint64()
+	if err != nil {
+		log.Error("Unexpected error while generating random "+
+			"extra nonce offset", "error", err)
+		enOffset = 0
+	}
 
 	// Create a couple of convenience variables.
 	header := &msgBlock.Header
-	targetDifficulty := blockchain.CompactToBig(header.Difficulty)
+	chain := m.blockManager.GetChain()
+
+	// Let the engine initialize the consensus fields of the header.
+	err = m.pow.Prepare(chain, header)
+	if err != nil {
+		log.Warn("CPU miner unable to prepare block template", "error", err)
+		return false
+	}
 
 	// Initial state.
 	lastGenerated := time.Now()
 	lastTxUpdate := m.txSource.LastUpdated()
-	hashesCompleted := uint64(0)
-
-	// TODO, decided if need extra nonce for coinbase-tx
-	// Note that the entire extra nonce range is iterated and the offset is
-	// added relying on the fact that overflow will wrap around 0 as
-	// provided by the Go spec.
-	// for extraNonce := uint64(0); extraNonce < maxExtraNonce; extraNonce++ {
-
-	// Update the extra nonce in the block template with the
-	// new value by regenerating the coinbase script and
-	// setting the merkle root to the new value.
-	// TODO, decided if need extra nonce for coinbase-tx
-	// updateExtraNonce(msgBlock, extraNonce+enOffset)
-
-	// Update the extra nonce in the block template header with the
-	// new value.
-	// binary.LittleEndian.PutUint64(header.ExtraData[:], extraNonce+enOffset)
-
-	// Search through the entire nonce range for a solution while
-	// periodically checking for early quit and stale block
-	// conditions along with updates to the speed monitor.
-	for i := uint64(0); i <= maxNonce; i++ {
-		select {
-		case <-quit:
+
+	// Note that the entire extra nonce range of the worker is iterated and
+	// the offset is added relying on workerExtraNonce to wrap around 0.
+	for extraNonce := uint64(0); extraNonce <= maxWorkerExtraNonce; extraNonce++ {
+		// Update the extra nonce in the block template with the
+		// new value by regenerating the coinbase script and
+		// setting the merkle root to the new value.
+		exNonce := workerExtraNonce(worker, extraNonce+enOffset)
+		err = m.updateExtraNonce(msgBlock, height, exNonce)
+		if err != nil {
+			log.Warn("CPU miner unable to update the extra nonce",
+				"error", err)
 			return false
+		}
 
-		case <-ticker.C:
-			m.updateHashes <- hashesCompleted
-			hashesCompleted = 0
+		// Update the extra nonce in the block template header with the
+		// new value.
+		header.ExNonce = exNonce
+
+		// Let the engine search through the nonce range for a solution
+		// until the ticker fires, then check for early quit and stale
+		// block conditions along with updates to the speed monitor
+		// before resuming the search where it stopped.  Once the
+		// engine exhausts the nonce range, the next extra nonce is
+		// searched.
+		header.Nonce = 0
+		for {
+			startNonce := header.Nonce
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			quitting := make(chan bool, 1)
+			go func() {
+				defer close(stop)
+				select {
+				case <-quit:
+					quitting <- true
+				case <-ticker.C:
+					quitting <- false
+				case <-done:
+				}
+			}()
+			solved, err := m.pow.Generate(chain, msgBlock, stop)
+			close(done)
+			<-stop
+			if err == pow.ErrNonceExhausted {
+				m.updateHashes <- header.Nonce - startNonce + 1
+				break
+			}
+			if err != nil {
+				log.Warn("CPU miner unable to solve block template",
+					"error", err)
+				return false
+			}
+
+			// The block is solved when the engine returns it.  Yay!
+			if solved != nil {
+				m.updateHashes <- header.Nonce - startNonce + 1
+				return true
+			}
+			m.updateHashes <- header.Nonce - startNonce
+
+			select {
+			case q := <-quitting:
+				if q {
+					return false
+				}
+			default:
+			}
 
-			// The current block is stale if the memory pool
-			// has been updated since the block template was
-			// generated and it has been at least 3 seconds,
-			// or if it's been one minute.
+			// The current block is stale if the memory pool has been
+			// updated since the block template was generated and it
+			// has been at least 3 seconds, or if it's been one
+			// minute.
 			if (lastTxUpdate != m.txSource.LastUpdated() &&
 				time.Now().After(lastGenerated.Add(3*time.Second))) ||
 				time.Now().After(lastGenerated.Add(60*time.Second)) {
@@ -329,35 +416,26 @@ func (m *CPUMiner) solveBlock(msgBlock *types.Block, ticker *time.Ticker, quit c
 				return false
 			}
 
-			err := mining.UpdateBlockTime(msgBlock, m.blockManager.GetChain(), m.timeSource, m.params)
+			err = mining.UpdateBlockTime(msgBlock, chain, m.timeSource, m.params)
 			if err != nil {
 				log.Warn("CPU miner unable to update block template "+
-					"time: %v", err)
+					"time", "error", err)
 				return false
 			}
-
-		default:
-			// Non-blocking select to fall through
-		}
-
-		// Update the nonce and hash the block header.
-		header.Nonce = i
-		h := header.BlockHash()
-		// Each hash is actually a double hash (tow hashes), so
-		// increment the number of hashes by 2
-		hashesCompleted += 2
-
-		// The block is solved when the new block hash is less
-		// than the target difficulty.  Yay!
-		if blockchain.HashToBig(&h).Cmp(targetDifficulty) <= 0 {
-			m.updateHashes <- hashesCompleted
-			return true
 		}
 	}
-	//}
 	return false
 }
 
+// workerExtraNonce returns the extra nonce of the passed worker whose low bits
+// are the passed extra nonce.  The index of the worker plus one is set in the
+// high bits, so the workers never build the same coinbase, neither with each
+// other nor with the Stratum connections, whose extra nonces are 32 bits.
+func workerExtraNonce(worker uint32, extraNonce uint64) uint64 {
+	return uint64(worker+1)<<workerExtraNonceBits |
+		extraNonce&maxWorkerExtraNonce
+}
+
 // submitBlock submits the passed block to network after ensuring it passes all
 // of the consensus validation rules.
 func (m *CPUMiner) submitBlock(block *types.SerializedBlock) bool {
@@ -444,11 +522,14 @@ func (m *CPUMiner) miningWorkerController() {
 	var runningWorkers []chan struct{}
 	launchWorkers := func(numWorkers uint32) {
 		for i := uint32(0); i < numWorkers; i++ {
+			// The index of a worker is unique among the running
+			// workers since the most recent ones are stopped first.
+			worker := uint32(len(runningWorkers))
 			quit := make(chan struct{})
 			runningWorkers = append(runningWorkers, quit)
 
 			m.workerWg.Add(1)
-			go m.generateBlocks(quit)
+			go m.generateBlocks(quit, worker)
 		}
 	}
 
@@ -587,10 +668,10 @@ func (m *CPUMiner) NumWorkers() int32 {
 // It is self contained in that it creates block templates and attempts to solve
 // them while detecting when it is performing stale work and reacting
 // accordingly by generating a new block template.  When a block is solved, it
-// is submitted.
+// is submitted.  The passed index of the worker selects its extra nonce range.
 //
 // It must be run as a goroutine.
-func (m *CPUMiner) generateBlocks(quit chan struct{}) {
+func (m *CPUMiner) generateBlocks(quit chan struct{}, worker uint32) {
 	log.Trace("Starting generate blocks worker")
 
 	// Start a ticker which is used to signal checks for stale work and
@@ -660,7 +741,7 @@ out:
 		// with false when conditions that trigger a stale block, so
 		// a new block template can be generated.  When the return is
 		// true a solution was found, so submit the solved block.
-		if m.solveBlock(template.Block, ticker, quit) {
+		if m.solveBlock(template.Block, template.Height, worker, ticker, quit) {
 			block := types.NewBlock(template.Block)
 			block.SetHeight(uint(template.Height))
 			m.submitBlock(block)
@@ -672,16 +753,13 @@ out:
 	log.Trace("Generate blocks worker done")
 }
 
-func (m *CPUMiner) updateExtraNonce(msgBlock *types.Block, extraNonce uint64) error {
-	// TODO, decided if need extra nonce for coinbase-tx
-	// do nothing for now
-	blockHash := msgBlock.BlockHash()
-	order, err := m.blockManager.GetChain().BlockOrderByHash(&blockHash)
-	if err != nil {
-		return err
-	}
-	coinbaseScript, err := txscript.NewScriptBuilder().AddInt64(int64(order)).
-		AddInt64(int64(extraNonce)).AddData([]byte("qitmeer/test")).
+// updateExtraNonce replaces the extra nonce in the signature script of the
+// coinbase of the passed block at the passed height.  The witness commitment of
+// the coinbase covers its signature script, so it is recalculated along with
+// the merkle root of the transactions in the header.
+func (m *CPUMiner) updateExtraNonce(msgBlock *types.Block, nextBlockHeight uint64, extraNonce uint64) error {
+	coinbaseScript, err := txscript.NewScriptBuilder().AddInt64(int64(nextBlockHeight)).
+		AddInt64(int64(extraNonce)).AddData([]byte(mining.CoinbaseFlags)).
 		Script()
 	if err != nil {
 		return err
@@ -694,8 +772,13 @@ func (m *CPUMiner) updateExtraNonce(msgBlock *types.Block, extraNonce uint64) er
 	}
 	msgBlock.Transactions[0].TxIn[0].SignScript = coinbaseScript
 
-	// Recalculate the merkle root with the updated extra nonce.
+	// Recalculate the witness commitment and the merkle root with the
+	// updated extra nonce.
 	block := types.NewBlock(msgBlock)
+	err = mining.FillWitnessToCoinBase(block.Transactions())
+	if err != nil {
+		return err
+	}
 	merkles := merkle.BuildMerkleTreeStore(block.Transactions(), false)
 	msgBlock.Header.TxRoot = *merkles[len(merkles)-1]
 	return nil
@@ -785,7 +868,7 @@ func (m *CPUMiner) GenerateBlockByParents(parents []*hash.Hash) (*hash.Hash, err
 		// with false when conditions that trigger a stale block, so
 		// a new block template can be generated.  When the return is
 		// true a solution was found, so submit the solved block.
-		if m.solveBlock(template.Block, ticker, nil) {
+		if m.solveBlock(template.Block, template.Height, 0, ticker, nil) {
 			block := types.NewBlock(template.Block)
 			block.SetHeight(uint(template.Height))
 			//
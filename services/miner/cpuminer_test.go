@@ -0,0 +1,94 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// testCoinbaseBlock returns a block only holding a coinbase.
+func testCoinbaseBlock() *types.Block {
+	coinbase := types.NewTransaction()
+	coinbase.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{},
+		types.MaxPrevOutIndex), nil))
+	coinbase.AddTxOut(types.NewTxOutput(1e8, []byte{0x51}))
+	block := &types.Block{Header: types.BlockHeader{
+		Version:   1,
+		Timestamp: time.Unix(time.Now().Unix(), 0),
+	}}
+	block.AddTransaction(coinbase)
+	return block
+}
+
+// TestWorkerExtraNonce ensures the extra nonce ranges of the workers are
+// disjoint, the offsets wrap around within the range of a worker, and the
+// ranges are above the extra nonces of the Stratum connections.
+func TestWorkerExtraNonce(t *testing.T) {
+	tests := []struct {
+		worker     uint32
+		extraNonce uint64
+		want       uint64
+	}{
+		{0, 0, 1 << 48},
+		{0, maxWorkerExtraNonce, 2<<48 - 1},
+		{0, maxWorkerExtraNonce + 1, 1 << 48},
+		{1, 0, 2 << 48},
+		{1, 5, 2<<48 + 5},
+		{7, ^uint64(0), 9<<48 - 1},
+	}
+	for _, test := range tests {
+		got := workerExtraNonce(test.worker, test.extraNonce)
+		if got != test.want {
+			t.Errorf("workerExtraNonce(%d, %x) = %x, want %x",
+				test.worker, test.extraNonce, got, test.want)
+		}
+		if got>>workerExtraNonceBits != uint64(test.worker)+1 {
+			t.Errorf("extra nonce %x is out of the range of worker %d",
+				got, test.worker)
+		}
+		if got <= uint64(^uint32(0)) {
+			t.Errorf("extra nonce %x is in the range of the Stratum "+
+				"connections", got)
+		}
+	}
+}
+
+// TestUpdateExtraNonce ensures every extra nonce of the workers gives the
+// coinbase, and so the merkle root of the header, a distinct value.
+func TestUpdateExtraNonce(t *testing.T) {
+	m := &CPUMiner{}
+	block := testCoinbaseBlock()
+	txRoots := make(map[hash.Hash]uint64)
+	for worker := uint32(0); worker < 3; worker++ {
+		for extraNonce := uint64(0); extraNonce < 3; extraNonce++ {
+			exNonce := workerExtraNonce(worker, extraNonce)
+			err := m.updateExtraNonce(block, 1, exNonce)
+			if err != nil {
+				t.Fatalf("updateExtraNonce(%x): %v", exNonce, err)
+			}
+			txRoot := block.Header.TxRoot
+			if prev, ok := txRoots[txRoot]; ok {
+				t.Fatalf("extra nonces %x and %x give the merkle root %v",
+					prev, exNonce, txRoot)
+			}
+			txRoots[txRoot] = exNonce
+		}
+	}
+
+	// The same extra nonce gives the same merkle root again.
+	exNonce := workerExtraNonce(1, 1)
+	if err := m.updateExtraNonce(block, 1, exNonce); err != nil {
+		t.Fatalf("updateExtraNonce(%x): %v", exNonce, err)
+	}
+	if txRoots[block.Header.TxRoot] != exNonce {
+		t.Fatalf("extra nonce %x gives the merkle root %v, want the one of "+
+			"extra nonce %x", exNonce, block.Header.TxRoot,
+			txRoots[block.Header.TxRoot])
+	}
+}
@@ -0,0 +1,1062 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/services/mining"
+	"math/big"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// The Stratum server speaks the line based JSON protocol of Stratum v1 with
+// the jobs adapted to the block header of the chain, which the miners hash as
+// is instead of assembling it from a coinbase and a merkle branch:
+//
+//	mining.subscribe() -> [subscriptions, extranonce1, extranonce2_size]
+//	mining.authorize(worker, password) -> true
+//	mining.notify(job_id, header, clean_jobs)
+//	mining.set_difficulty(difficulty)
+//	mining.submit(worker, job_id, extranonce2, ntime, nonce) -> true
+//
+// The header of a job is the hex of the serialized block header, which embeds
+// the extra nonce of the coinbase assigned to the connection, extranonce1, in
+// its merkle root.  The miners search the ExNonce field of the header at
+// offset 104 as extranonce2, along with the Nonce at offset 120, and may roll
+// the Timestamp at offset 112.  The extranonce2, ntime and nonce of a share
+// are the hex of the 8 bytes of the fields in the serialized header.
+const (
+	// stratumExtraNonce2Size is the size in bytes of the header extra nonce
+	// searched by the miners.
+	stratumExtraNonce2Size = 8
+
+	// stratumMaxJobs is the number of the most recent jobs the shares are
+	// accepted for.
+	stratumMaxJobs = 8
+
+	// stratumJobRefreshSecs is the number of seconds after which a new job
+	// is sent to refresh the timestamp even when the tips of the DAG and
+	// the memory pool have not changed.
+	stratumJobRefreshSecs = 30
+
+	// stratumInitialDifficulty is the share difficulty of a new connection.
+	stratumInitialDifficulty = 1
+
+	// stratumMinDifficulty is the lowest share difficulty vardiff sets.
+	stratumMinDifficulty = 1
+
+	// stratumTargetShareSecs is the number of seconds between two shares of
+	// a connection vardiff aims at.
+	stratumTargetShareSecs = 10
+
+	// stratumRetargetSecs is the number of seconds between two adjustments
+	// of the share difficulty of a connection.
+	stratumRetargetSecs = 60
+
+	// stratumRetargetShares is the number of shares after which the share
+	// difficulty is adjusted early, since the shares come way too fast.
+	stratumRetargetShares = 30
+
+	// stratumHashrateWindow is the period the hash rate of a connection is
+	// measured over.
+	stratumHashrateWindow = 10 * time.Minute
+
+	// stratumIdleTimeout is the time a connection is dropped after when
+	// the miner sends nothing.
+	stratumIdleTimeout = 10 * time.Minute
+
+	// stratumWriteTimeout is the time a message to a miner has to be sent
+	// within.
+	stratumWriteTimeout = 30 * time.Second
+
+	// stratumMaxMessageSize is the maximum size of a message of a miner.
+	stratumMaxMessageSize = 4096
+
+	// stratumMaxClients is the maximum number of connections of miners
+	// served at the same time.
+	stratumMaxClients = 256
+)
+
+// The error codes of Stratum v1.
+const (
+	stratumErrOther         = 20
+	stratumErrJobNotFound   = 21
+	stratumErrDuplicate     = 22
+	stratumErrLowDiff       = 23
+	stratumErrUnauthorized  = 24
+	stratumErrNotSubscribed = 25
+)
+
+// stratumError returns the error of a Stratum response.
+func stratumError(code int, msg string) []interface{} {
+	return []interface{}{code, msg, nil}
+}
+
+// stratumRequest is a request of a miner.
+type stratumRequest struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// stratumResponse is the response to a request of a miner.
+type stratumResponse struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result"`
+	Error  interface{} `json:"error"`
+}
+
+// stratumNotification is a message the server sends to a miner unrequested.
+type stratumNotification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// stratumJob is a block template handed out to the miners.  Its block is never
+// modified once the job is created.
+type stratumJob struct {
+	id       string
+	template *types.BlockTemplate
+	block    *types.Block
+	height   uint64
+	parents  *blockdag.HashSet
+	target   *big.Int
+
+	// generated is the time the job was created, templateTime the time
+	// the first job of the template was created, and lastTxUpdate the
+	// last update of the memory pool at that time.
+	generated    time.Time
+	templateTime time.Time
+	lastTxUpdate time.Time
+
+	// submitted holds the shares found for the job to reject duplicates.
+	// It is protected by the mutex of the server.
+	submitted map[string]struct{}
+}
+
+// stratumWork is the header and the coinbase of a job for a connection, which
+// carry the extra nonce of the connection.
+type stratumWork struct {
+	header   types.BlockHeader
+	coinbase *types.Transaction
+}
+
+// stratumShare is an accepted share of a connection.
+type stratumShare struct {
+	time       time.Time
+	difficulty float64
+}
+
+// StratumServer hands out the work of the miner to the remote miners speaking
+// the Stratum protocol and submits the blocks they find.  The jobs are built
+// from the current block template of the block manager, every connection gets
+// its own extra nonce range in the coinbase, and the share difficulty of the
+// connections is adjusted to their hash rate.
+type StratumServer struct {
+	miner      *CPUMiner
+	listenAddr string
+	listener   net.Listener
+
+	// hashesPerDiff1 is the expected number of hashes to find a share of
+	// difficulty 1, whose target is the proof of work limit.
+	hashesPerDiff1 float64
+
+	mtx             sync.Mutex
+	clients         map[*stratumClient]struct{}
+	job             *stratumJob
+	jobs            map[string]*stratumJob
+	jobOrder        []string
+	nextJobID       uint64
+	nextExtraNonce1 uint32
+
+	blockConnected chan struct{}
+	wg             sync.WaitGroup
+	quit           chan struct{}
+}
+
+// NewStratumServer returns a new Stratum server of the passed miner listening
+// on the passed address.  Use Start to begin accepting connections.
+func NewStratumServer(m *CPUMiner, listenAddr string) *StratumServer {
+	diff1 := new(big.Float).SetInt(new(big.Int).Add(m.params.PowLimit,
+		big.NewInt(1)))
+	space := new(big.Float).SetInt(new(big.Int).Lsh(big.NewInt(1), 256))
+	hashesPerDiff1, _ := new(big.Float).Quo(space, diff1).Float64()
+
+	s := &StratumServer{
+		miner:           m,
+		listenAddr:      listenAddr,
+		hashesPerDiff1:  hashesPerDiff1,
+		clients:         make(map[*stratumClient]struct{}),
+		jobs:            make(map[string]*stratumJob),
+		nextExtraNonce1: rand.Uint32(),
+		blockConnected:  make(chan struct{}, 1),
+	}
+	m.stratum = s
+	return s
+}
+
+// Start begins accepting the connections of the miners and handing out jobs.
+func (s *StratumServer) Start() error {
+	if s.miner.params.PowType != params.Blake2bPow {
+		return fmt.Errorf("the Stratum server is not supported by the "+
+			"proof of work of the %v network", s.miner.params.Name)
+	}
+	listener, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.quit = make(chan struct{})
+
+	s.wg.Add(2)
+	go s.acceptHandler()
+	go s.jobHandler()
+	log.Info("Stratum server listening", "addr", listener.Addr())
+	return nil
+}
+
+// Stop disconnects the miners and stops the server.
+func (s *StratumServer) Stop() {
+	if s.listener == nil {
+		return
+	}
+	close(s.quit)
+	s.listener.Close()
+
+	s.mtx.Lock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mtx.Unlock()
+
+	s.wg.Wait()
+	log.Info("Stratum server stopped")
+}
+
+// notifyBlockConnected makes the server send new jobs since the tips of the
+// DAG have changed.  It doesn't block the caller.
+func (s *StratumServer) notifyBlockConnected() {
+	select {
+	case s.blockConnected <- struct{}{}:
+	default:
+	}
+}
+
+// acceptHandler accepts the connections of the miners.  It must be run as a
+// goroutine.
+func (s *StratumServer) acceptHandler() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			log.Warn("Stratum server failed to accept a connection",
+				"error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		c := s.addClient(conn)
+		if c == nil {
+			log.Warn("Max Stratum connections reached, refusing "+
+				"connection", "max", stratumMaxClients,
+				"addr", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		log.Debug("New Stratum connection", "addr", c.remote)
+		s.wg.Add(1)
+		go c.inHandler()
+	}
+}
+
+// addClient returns the connection of a miner for the passed accepted
+// connection, or nil when the server already serves the maximum number of
+// connections.
+func (s *StratumServer) addClient(conn net.Conn) *stratumClient {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.clients) >= stratumMaxClients {
+		return nil
+	}
+	s.nextExtraNonce1++
+	c := newStratumClient(s, conn, s.nextExtraNonce1)
+	s.clients[c] = struct{}{}
+	return c
+}
+
+// removeClient forgets the passed disconnected connection.
+func (s *StratumServer) removeClient(c *stratumClient) {
+	s.mtx.Lock()
+	delete(s.clients, c)
+	s.mtx.Unlock()
+}
+
+// jobHandler sends new jobs to the miners when the tips of the DAG change, or
+// the memory pool changes and the job is old enough, or periodically to
+// refresh the timestamp.  It must be run as a goroutine.
+func (s *StratumServer) jobHandler() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.blockConnected:
+			s.updateJob()
+		case <-ticker.C:
+			s.updateJob()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// updateJob creates a new job from the current block template when the job
+// the miners work on is stale and sends it to them.
+func (s *StratumServer) updateJob() {
+	m := s.miner
+	chain := m.blockManager.GetChain()
+
+	// No point in handing out work before the chain is synced.
+	currentOrder := chain.BestSnapshot().GraphState.GetTotal() - 1
+	if currentOrder != 0 && !m.blockManager.IsCurrent() {
+		return
+	}
+
+	tips := blockdag.NewHashSet()
+	tips.AddList(chain.GetMiningTips())
+	lastTxUpdate := m.txSource.LastUpdated()
+
+	s.mtx.Lock()
+	job := s.job
+	s.mtx.Unlock()
+	now := time.Now()
+	mempoolStale := job != nil && job.lastTxUpdate != lastTxUpdate &&
+		now.After(job.templateTime.Add(gbtRegenerateSeconds*time.Second))
+	if job != nil && job.parents.IsEqual(tips) && !mempoolStale &&
+		now.Before(job.generated.Add(stratumJobRefreshSecs*time.Second)) {
+		return
+	}
+
+	// Generate a new template unless the cached one is on the current
+	// tips and isn't the one of the job made stale by the memory pool.
+	template := m.blockManager.GetCurrentTemplate()
+	templateTime, templateTxUpdate := now, lastTxUpdate
+	if job != nil && template == job.template {
+		templateTime, templateTxUpdate = job.templateTime, job.lastTxUpdate
+	}
+	if template == nil || !templateParents(template).IsEqual(tips) ||
+		(mempoolStale && template == job.template) {
+
+		miningAddrs := m.config.GetMinningAddrs()
+		payToAddr := miningAddrs[rand.Intn(len(miningAddrs))]
+		m.submitBlockLock.Lock()
+		_, err := mining.NewBlockTemplate(m.policy, m.params, m.sigCache,
+			m.txSource, m.timeSource, m.blockManager, payToAddr, nil)
+		m.submitBlockLock.Unlock()
+		if err != nil {
+			log.Warn("Stratum server failed to create a block template",
+				"error", err)
+			return
+		}
+		template = m.blockManager.GetCurrentTemplate()
+		if template == nil {
+			return
+		}
+		templateTime, templateTxUpdate = now, lastTxUpdate
+	}
+
+	newJob, err := s.newJob(template, templateTime, templateTxUpdate)
+	if err != nil {
+		log.Warn("Stratum server failed to create a job", "error", err)
+		return
+	}
+
+	// The jobs on other tips can no longer become blocks.
+	cleanJobs := job == nil || !job.parents.IsEqual(newJob.parents)
+
+	s.mtx.Lock()
+	if cleanJobs {
+		s.jobs = make(map[string]*stratumJob)
+		s.jobOrder = nil
+	}
+	s.job = newJob
+	s.jobs[newJob.id] = newJob
+	s.jobOrder = append(s.jobOrder, newJob.id)
+	if len(s.jobOrder) > stratumMaxJobs {
+		delete(s.jobs, s.jobOrder[0])
+		s.jobOrder = s.jobOrder[1:]
+	}
+	clients := make([]*stratumClient, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mtx.Unlock()
+
+	log.Debug("New Stratum job", "id", newJob.id, "height", newJob.height,
+		"txs", len(newJob.block.Transactions), "clean", cleanJobs)
+	for _, c := range clients {
+		c.sendJob(newJob, cleanJobs)
+	}
+}
+
+// templateParents returns the set of the parents of the passed template.
+func templateParents(template *types.BlockTemplate) *blockdag.HashSet {
+	parents := blockdag.NewHashSet()
+	parents.AddList(template.Block.Parents)
+	return parents
+}
+
+// newJob returns a new job of a copy of the passed template with the current
+// time.
+func (s *StratumServer) newJob(template *types.BlockTemplate, templateTime, lastTxUpdate time.Time) (*stratumJob, error) {
+	m := s.miner
+	chain := m.blockManager.GetChain()
+	block, err := copyBlock(template.Block)
+	if err != nil {
+		return nil, err
+	}
+	err = mining.UpdateBlockTime(block, chain, m.timeSource, m.params)
+	if err != nil {
+		return nil, err
+	}
+	err = m.pow.Prepare(chain, &block.Header)
+	if err != nil {
+		return nil, err
+	}
+	block.Header.ExNonce = 0
+	block.Header.Nonce = 0
+
+	s.mtx.Lock()
+	s.nextJobID++
+	id := fmt.Sprintf("%x", s.nextJobID)
+	s.mtx.Unlock()
+
+	return &stratumJob{
+		id:           id,
+		template:     template,
+		block:        block,
+		height:       template.Height,
+		parents:      templateParents(template),
+		target:       blockchain.CompactToBig(block.Header.Difficulty),
+		generated:    time.Now(),
+		templateTime: templateTime,
+		lastTxUpdate: lastTxUpdate,
+		submitted:    make(map[string]struct{}),
+	}, nil
+}
+
+// findJob returns the job of the passed id, or nil if it is unknown or stale.
+func (s *StratumServer) findJob(id string) *stratumJob {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.jobs[id]
+}
+
+// markSubmitted records the passed share of the passed job and returns whether
+// it wasn't submitted before.
+func (s *StratumServer) markSubmitted(job *stratumJob, share string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := job.submitted[share]; ok {
+		return false
+	}
+	job.submitted[share] = struct{}{}
+	return true
+}
+
+// submitBlock assembles the block of the passed job, work and solved header
+// and submits it the same way as the blocks of the CPU miner.
+func (s *StratumServer) submitBlock(job *stratumJob, work *stratumWork, header *types.BlockHeader) bool {
+	msgBlock := *job.block
+	msgBlock.Header = *header
+	msgBlock.Transactions = make([]*types.Transaction, len(job.block.Transactions))
+	copy(msgBlock.Transactions, job.block.Transactions)
+	msgBlock.Transactions[0] = work.coinbase
+
+	// Copy the assembled block, so the block manager doesn't share the
+	// transactions of the job.
+	blockCopy, err := copyBlock(&msgBlock)
+	if err != nil {
+		log.Error("Stratum server failed to assemble a block", "error", err)
+		return false
+	}
+	block := types.NewBlock(blockCopy)
+	block.SetHeight(uint(job.height))
+	return s.miner.submitBlock(block)
+}
+
+// shareTarget returns the target of a share of the passed difficulty.
+func (s *StratumServer) shareTarget(difficulty float64) *big.Int {
+	target := new(big.Float).SetInt(s.miner.params.PowLimit)
+	target.Quo(target, big.NewFloat(difficulty))
+	result, _ := target.Int(nil)
+	return result
+}
+
+// workers returns the state of the connections of the miners.
+//
+// This function is safe for concurrent access.
+func (s *StratumServer) workers() []stratumWorkerState {
+	s.mtx.Lock()
+	clients := make([]*stratumClient, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mtx.Unlock()
+
+	workers := make([]stratumWorkerState, 0, len(clients))
+	for _, c := range clients {
+		workers = append(workers, c.state())
+	}
+	return workers
+}
+
+// stratumWorkerState is the state of the connection of a miner.
+type stratumWorkerState struct {
+	worker     string
+	addr       string
+	difficulty float64
+	accepted   uint64
+	rejected   uint64
+	blocks     uint64
+	hashrate   float64
+	lastShare  time.Time
+	connected  time.Time
+}
+
+// stratumClient is the connection of a miner.
+type stratumClient struct {
+	server      *StratumServer
+	conn        net.Conn
+	remote      string
+	extraNonce1 uint32
+	connected   time.Time
+
+	writeMtx sync.Mutex
+
+	mtx           sync.Mutex
+	subscribed    bool
+	authorized    bool
+	worker        string
+	difficulty    float64
+	target        *big.Int
+	oldDifficulty float64
+	oldTarget     *big.Int
+	retargetTime  time.Time
+	retargetCount int
+	work          map[string]*stratumWork
+	workOrder     []string
+	accepted      uint64
+	rejected      uint64
+	blocks        uint64
+	shares        []stratumShare
+	lastShare     time.Time
+}
+
+// newStratumClient returns the connection of a miner whose coinbase carries
+// the passed extra nonce.
+func newStratumClient(s *StratumServer, conn net.Conn, extraNonce1 uint32) *stratumClient {
+	now := time.Now()
+	return &stratumClient{
+		server:       s,
+		conn:         conn,
+		remote:       conn.RemoteAddr().String(),
+		extraNonce1:  extraNonce1,
+		connected:    now,
+		difficulty:   stratumInitialDifficulty,
+		target:       s.shareTarget(stratumInitialDifficulty),
+		retargetTime: now,
+		work:         make(map[string]*stratumWork),
+	}
+}
+
+// inHandler reads and handles the requests of the miner until the connection
+// is closed.  It must be run as a goroutine.
+func (c *stratumClient) inHandler() {
+	defer c.server.wg.Done()
+	defer c.server.removeClient(c)
+	defer c.conn.Close()
+
+	reader := bufio.NewReaderSize(c.conn, stratumMaxMessageSize)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(stratumIdleTimeout))
+		line, err := reader.ReadSlice('\n')
+		if err != nil {
+			log.Debug("Stratum connection closed", "addr", c.remote,
+				"error", err)
+			return
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var req stratumRequest
+		err = json.Unmarshal(line, &req)
+		if err != nil {
+			log.Debug("Invalid Stratum request", "addr", c.remote,
+				"error", err)
+			return
+		}
+		c.handleRequest(&req)
+	}
+}
+
+// send writes the passed message to the miner.
+func (c *stratumClient) send(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Error("Failed to encode Stratum message", "error", err)
+		return
+	}
+	data = append(data, '\n')
+
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(stratumWriteTimeout))
+	_, err = c.conn.Write(data)
+	if err != nil {
+		log.Debug("Failed to send Stratum message", "addr", c.remote,
+			"error", err)
+		c.conn.Close()
+	}
+}
+
+// stringParams decodes the passed parameters as strings.
+func stringParams(raw []json.RawMessage) ([]string, error) {
+	params := make([]string, len(raw))
+	for i, p := range raw {
+		err := json.Unmarshal(p, &params[i])
+		if err != nil {
+			return nil, errors.New("invalid parameters")
+		}
+	}
+	return params, nil
+}
+
+// handleRequest handles a request of the miner and sends the response.
+func (c *stratumClient) handleRequest(req *stratumRequest) {
+	var result interface{}
+	var stratumErr []interface{}
+	switch req.Method {
+	case "mining.subscribe":
+		result = c.handleSubscribe()
+
+	case "mining.authorize":
+		result, stratumErr = c.handleAuthorize(req.Params)
+
+	case "mining.extranonce.subscribe":
+		result = true
+
+	case "mining.submit":
+		result, stratumErr = c.handleSubmit(req.Params)
+
+	default:
+		stratumErr = stratumError(stratumErrOther, "Unknown method")
+	}
+	c.send(&stratumResponse{ID: req.ID, Result: result, Error: stratumErr})
+
+	// The work is sent once the miner is subscribed and authorized.
+	if req.Method == "mining.authorize" && stratumErr == nil {
+		c.server.mtx.Lock()
+		job := c.server.job
+		c.server.mtx.Unlock()
+		c.sendDifficulty()
+		if job != nil {
+			c.sendJob(job, true)
+		}
+	}
+}
+
+// handleSubscribe handles the mining.subscribe request.
+func (c *stratumClient) handleSubscribe() interface{} {
+	c.mtx.Lock()
+	c.subscribed = true
+	c.mtx.Unlock()
+
+	var extraNonce1 [4]byte
+	binary.BigEndian.PutUint32(extraNonce1[:], c.extraNonce1)
+	subscription := hex.EncodeToString(extraNonce1[:])
+	return []interface{}{
+		[][]string{
+			{"mining.set_difficulty", subscription},
+			{"mining.notify", subscription},
+		},
+		subscription,
+		stratumExtraNonce2Size,
+	}
+}
+
+// handleAuthorize handles the mining.authorize request.  The miners are not
+// authenticated since the blocks they find pay to the mining addresses of the
+// node, the worker name only identifies them.
+func (c *stratumClient) handleAuthorize(raw []json.RawMessage) (interface{}, []interface{}) {
+	params, err := stringParams(raw)
+	if err != nil || len(params) < 1 {
+		return nil, stratumError(stratumErrOther, "Invalid parameters")
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if !c.subscribed {
+		return nil, stratumError(stratumErrNotSubscribed, "Not subscribed")
+	}
+	c.authorized = true
+	c.worker = params[0]
+	log.Debug("Stratum worker authorized", "addr", c.remote,
+		"worker", c.worker)
+	return true, nil
+}
+
+// sendDifficulty sends the share difficulty to the miner.
+func (c *stratumClient) sendDifficulty() {
+	c.mtx.Lock()
+	difficulty := c.difficulty
+	c.mtx.Unlock()
+	c.send(&stratumNotification{
+		Method: "mining.set_difficulty",
+		Params: []interface{}{difficulty},
+	})
+}
+
+// sendJob sends the passed job to the miner with the extra nonce of the
+// connection in the coinbase.
+func (c *stratumClient) sendJob(job *stratumJob, cleanJobs bool) {
+	c.mtx.Lock()
+	if !c.authorized {
+		c.mtx.Unlock()
+		return
+	}
+	difficultyChanged := c.retargetIdle(time.Now())
+	c.mtx.Unlock()
+
+	// The coinbase is copied since its extra nonce is replaced for the
+	// connection.
+	msgBlock := *job.block
+	msgBlock.Transactions = make([]*types.Transaction, len(job.block.Transactions))
+	copy(msgBlock.Transactions, job.block.Transactions)
+	coinbase, err := copyTransaction(job.block.Transactions[0])
+	if err != nil {
+		log.Error("Stratum server failed to copy the coinbase", "error", err)
+		return
+	}
+	msgBlock.Transactions[0] = coinbase
+	err = c.server.miner.updateExtraNonce(&msgBlock, job.height,
+		uint64(c.extraNonce1))
+	if err != nil {
+		log.Error("Stratum server failed to update the extra nonce",
+			"error", err)
+		return
+	}
+	var header bytes.Buffer
+	err = msgBlock.Header.Serialize(&header)
+	if err != nil {
+		log.Error("Stratum server failed to serialize the header",
+			"error", err)
+		return
+	}
+
+	c.mtx.Lock()
+	if cleanJobs {
+		c.work = make(map[string]*stratumWork)
+		c.workOrder = nil
+	}
+	c.work[job.id] = &stratumWork{header: msgBlock.Header, coinbase: coinbase}
+	c.workOrder = append(c.workOrder, job.id)
+	if len(c.workOrder) > stratumMaxJobs {
+		delete(c.work, c.workOrder[0])
+		c.workOrder = c.workOrder[1:]
+	}
+	// The shares at the previous difficulty are accepted until the miner
+	// works on a new job.
+	c.oldDifficulty, c.oldTarget = 0, nil
+	c.mtx.Unlock()
+
+	if difficultyChanged {
+		c.sendDifficulty()
+	}
+	c.send(&stratumNotification{
+		Method: "mining.notify",
+		Params: []interface{}{
+			job.id,
+			hex.EncodeToString(header.Bytes()),
+			cleanJobs,
+		},
+	})
+}
+
+// decodeHeaderField decodes the hex of an 8 byte field of the serialized
+// header.
+func decodeHeaderField(field string) (uint64, error) {
+	b, err := hex.DecodeString(field)
+	if err != nil || len(b) != 8 {
+		return 0, errors.New("invalid header field")
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// handleSubmit handles the mining.submit request of a share and submits it as
+// a block when it meets the target difficulty of the network.
+func (c *stratumClient) handleSubmit(raw []json.RawMessage) (interface{}, []interface{}) {
+	params, err := stringParams(raw)
+	if err != nil || len(params) < 5 {
+		return nil, stratumError(stratumErrOther, "Invalid parameters")
+	}
+
+	c.mtx.Lock()
+	authorized := c.authorized
+	c.mtx.Unlock()
+	if !authorized {
+		return nil, stratumError(stratumErrUnauthorized, "Unauthorized worker")
+	}
+
+	job := c.server.findJob(params[1])
+	c.mtx.Lock()
+	work := c.work[params[1]]
+	c.mtx.Unlock()
+	if job == nil || work == nil {
+		c.reject()
+		return nil, stratumError(stratumErrJobNotFound, "Job not found")
+	}
+
+	exNonce, err := decodeHeaderField(params[2])
+	if err != nil {
+		c.reject()
+		return nil, stratumError(stratumErrOther, "Invalid extranonce2")
+	}
+	ntime, err := decodeHeaderField(params[3])
+	if err != nil {
+		c.reject()
+		return nil, stratumError(stratumErrOther, "Invalid ntime")
+	}
+	nonce, err := decodeHeaderField(params[4])
+	if err != nil {
+		c.reject()
+		return nil, stratumError(stratumErrOther, "Invalid nonce")
+	}
+
+	// The timestamp may be rolled forward up to the maximum time allowed
+	// in the future.
+	header := work.header
+	header.ExNonce = exNonce
+	header.Timestamp = time.Unix(int64(ntime), 0)
+	header.Nonce = nonce
+	maxTime := c.server.miner.timeSource.AdjustedTime().Add(
+		time.Second * blockchain.MaxTimeOffsetSeconds)
+	if header.Timestamp.Before(work.header.Timestamp) ||
+		header.Timestamp.After(maxTime) {
+		c.reject()
+		return nil, stratumError(stratumErrOther, "Time out of range")
+	}
+
+	if !c.server.markSubmitted(job, fmt.Sprintf("%x-%s-%s-%s",
+		c.extraNonce1, params[2], params[3], params[4])) {
+		c.reject()
+		return nil, stratumError(stratumErrDuplicate, "Duplicate share")
+	}
+
+	h := header.BlockHash()
+	hashNum := blockchain.HashToBig(&h)
+	isBlock := hashNum.Cmp(job.target) <= 0
+	if isBlock {
+		log.Info("Stratum worker found a block", "worker", params[0],
+			"hash", h, "height", job.height)
+		if c.server.submitBlock(job, work, &header) {
+			c.mtx.Lock()
+			c.blocks++
+			c.mtx.Unlock()
+		}
+	}
+
+	c.mtx.Lock()
+	difficulty := c.difficulty
+	if !isBlock && hashNum.Cmp(c.target) > 0 {
+		if c.oldTarget == nil || hashNum.Cmp(c.oldTarget) > 0 {
+			c.rejected++
+			c.mtx.Unlock()
+			return nil, stratumError(stratumErrLowDiff, "Low difficulty share")
+		}
+		difficulty = c.oldDifficulty
+	}
+	now := time.Now()
+	c.accepted++
+	c.lastShare = now
+	c.shares = append(c.shares, stratumShare{time: now, difficulty: difficulty})
+	c.pruneShares(now)
+	difficultyChanged := c.retarget(now)
+	c.mtx.Unlock()
+
+	if difficultyChanged {
+		c.sendDifficulty()
+	}
+	return true, nil
+}
+
+// reject counts a rejected share.
+func (c *stratumClient) reject() {
+	c.mtx.Lock()
+	c.rejected++
+	c.mtx.Unlock()
+}
+
+// setDifficulty sets the share difficulty of the connection, keeping the
+// previous one for the shares of the current job.
+//
+// This function MUST be called with the client lock held.
+func (c *stratumClient) setDifficulty(difficulty float64, now time.Time) {
+	if difficulty < stratumMinDifficulty {
+		difficulty = stratumMinDifficulty
+	}
+	if c.oldTarget == nil || c.difficulty < c.oldDifficulty {
+		c.oldDifficulty, c.oldTarget = c.difficulty, c.target
+	}
+	c.difficulty = difficulty
+	c.target = c.server.shareTarget(difficulty)
+	c.retargetTime = now
+	c.retargetCount = 0
+}
+
+// retarget adjusts the share difficulty of the connection after an accepted
+// share when it has been long enough or the shares come way too fast, so the
+// miner finds a share every stratumTargetShareSecs.  It returns whether the
+// difficulty has changed.
+//
+// This function MUST be called with the client lock held.
+func (c *stratumClient) retarget(now time.Time) bool {
+	c.retargetCount++
+	elapsed := now.Sub(c.retargetTime).Seconds()
+	if elapsed < stratumRetargetSecs && c.retargetCount < stratumRetargetShares {
+		return false
+	}
+	if elapsed <= 0 {
+		elapsed = 1
+	}
+
+	// The adjustment is bounded, so a burst of lucky shares doesn't move
+	// the difficulty too far.
+	ratio := stratumTargetShareSecs * float64(c.retargetCount) / elapsed
+	if ratio > 4 {
+		ratio = 4
+	} else if ratio < 0.25 {
+		ratio = 0.25
+	}
+	if ratio > 0.8 && ratio < 1.2 {
+		c.retargetTime = now
+		c.retargetCount = 0
+		return false
+	}
+	difficulty := c.difficulty
+	c.setDifficulty(difficulty*ratio, now)
+	return c.difficulty != difficulty
+}
+
+// retargetIdle lowers the share difficulty of a connection which hasn't found
+// a share for twice the retarget period.  It returns whether the difficulty
+// has changed.
+//
+// This function MUST be called with the client lock held.
+func (c *stratumClient) retargetIdle(now time.Time) bool {
+	if c.retargetCount > 0 || c.difficulty <= stratumMinDifficulty ||
+		now.Sub(c.retargetTime) < 2*stratumRetargetSecs*time.Second {
+		return false
+	}
+	c.setDifficulty(c.difficulty/4, now)
+	return true
+}
+
+// pruneShares forgets the shares found before the hash rate window.
+//
+// This function MUST be called with the client lock held.
+func (c *stratumClient) pruneShares(now time.Time) {
+	i := 0
+	for i < len(c.shares) && now.Sub(c.shares[i].time) > stratumHashrateWindow {
+		i++
+	}
+	c.shares = c.shares[i:]
+}
+
+// state returns the state of the connection.  The hash rate is estimated from
+// the difficulty of the shares found within the hash rate window.
+func (c *stratumClient) state() stratumWorkerState {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+	c.pruneShares(now)
+
+	var difficulty float64
+	for _, share := range c.shares {
+		difficulty += share.difficulty
+	}
+	window := now.Sub(c.connected)
+	if window > stratumHashrateWindow {
+		window = stratumHashrateWindow
+	}
+	if window < time.Second {
+		window = time.Second
+	}
+
+	return stratumWorkerState{
+		worker:     c.worker,
+		addr:       c.remote,
+		difficulty: c.difficulty,
+		accepted:   c.accepted,
+		rejected:   c.rejected,
+		blocks:     c.blocks,
+		hashrate:   difficulty * c.server.hashesPerDiff1 / window.Seconds(),
+		lastShare:  c.lastShare,
+		connected:  c.connected,
+	}
+}
+
+// copyBlock returns a deep copy of the passed block.
+func copyBlock(block *types.Block) (*types.Block, error) {
+	var buf bytes.Buffer
+	err := block.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+	var newBlock types.Block
+	err = newBlock.Deserialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return &newBlock, nil
+}
+
+// copyTransaction returns a deep copy of the passed transaction.
+func copyTransaction(tx *types.Transaction) (*types.Transaction, error) {
+	data, err := tx.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	var newTx types.Transaction
+	err = newTx.Deserialize(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return &newTx, nil
+}
@@ -0,0 +1,312 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+// newTestStratumServer returns a server whose shares of difficulty 1 meet any
+// hash, with a job whose shares are never blocks.
+func newTestStratumServer() *StratumServer {
+	p := params.PrivNetParams
+	p.PowLimit = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256),
+		big.NewInt(1))
+	m := &CPUMiner{params: &p, timeSource: blockchain.NewMedianTime()}
+	s := NewStratumServer(m, "")
+
+	s.job = &stratumJob{
+		id:        "1",
+		block:     testCoinbaseBlock(),
+		height:    1,
+		parents:   blockdag.NewHashSet(),
+		target:    big.NewInt(-1),
+		generated: time.Now(),
+		submitted: make(map[string]struct{}),
+	}
+	s.jobs[s.job.id] = s.job
+	s.jobOrder = []string{s.job.id}
+	return s
+}
+
+// stratumTestMessage is a response or a notification of the server.
+type stratumTestMessage struct {
+	ID     interface{}     `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  []interface{}   `json:"error"`
+	Method string          `json:"method"`
+	Params []interface{}   `json:"params"`
+}
+
+// stratumTestMiner is the end of a connection of the server the test speaks
+// the protocol on.
+type stratumTestMiner struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+}
+
+// request sends a request of the passed method and parameters.
+func (m *stratumTestMiner) request(method string, params ...string) {
+	m.nextID++
+	data, err := json.Marshal(map[string]interface{}{
+		"id":     m.nextID,
+		"method": method,
+		"params": params,
+	})
+	if err != nil {
+		m.t.Fatalf("failed to encode request: %v", err)
+	}
+	m.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := m.conn.Write(append(data, '\n')); err != nil {
+		m.t.Fatalf("failed to send %s request: %v", method, err)
+	}
+}
+
+// read returns the next message of the server.
+func (m *stratumTestMiner) read() *stratumTestMessage {
+	m.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := m.reader.ReadBytes('\n')
+	if err != nil {
+		m.t.Fatalf("failed to read message: %v", err)
+	}
+	var msg stratumTestMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		m.t.Fatalf("invalid message %q: %v", line, err)
+	}
+	return &msg
+}
+
+// call sends a request and returns its response.
+func (m *stratumTestMiner) call(method string, params ...string) *stratumTestMessage {
+	m.request(method, params...)
+	resp := m.read()
+	if resp.ID != float64(m.nextID) {
+		m.t.Fatalf("%s response has id %v, want %d", method, resp.ID,
+			m.nextID)
+	}
+	return resp
+}
+
+// expectResult ensures the passed response succeeded with a true result.
+func (m *stratumTestMiner) expectResult(resp *stratumTestMessage, method string) {
+	if resp.Error != nil || string(resp.Result) != "true" {
+		m.t.Fatalf("%s: got result %s error %v, want true", method,
+			resp.Result, resp.Error)
+	}
+}
+
+// expectError ensures the passed response failed with the passed code.
+func (m *stratumTestMiner) expectError(resp *stratumTestMessage, method string, code int) {
+	if len(resp.Error) == 0 || resp.Error[0] != float64(code) {
+		m.t.Fatalf("%s: got error %v, want code %d", method, resp.Error,
+			code)
+	}
+}
+
+// expectDifficulty ensures the next message sets the passed share difficulty.
+func (m *stratumTestMiner) expectDifficulty(difficulty float64) {
+	msg := m.read()
+	if msg.Method != "mining.set_difficulty" || len(msg.Params) != 1 ||
+		msg.Params[0] != difficulty {
+		m.t.Fatalf("got %s %v, want mining.set_difficulty [%v]",
+			msg.Method, msg.Params, difficulty)
+	}
+}
+
+// TestStratumSession ensures a miner is handed work once subscribed and
+// authorized, its shares are checked and counted, and its share difficulty is
+// raised when the shares come too fast.
+func TestStratumSession(t *testing.T) {
+	s := newTestStratumServer()
+	serverConn, minerConn := net.Pipe()
+	c := s.addClient(serverConn)
+	s.wg.Add(1)
+	go c.inHandler()
+	defer s.wg.Wait()
+	defer minerConn.Close()
+	m := &stratumTestMiner{t: t, conn: minerConn,
+		reader: bufio.NewReader(minerConn)}
+
+	m.expectError(m.call("mining.authorize", "worker", "x"),
+		"mining.authorize", stratumErrNotSubscribed)
+
+	resp := m.call("mining.subscribe")
+	var subscribe []json.RawMessage
+	if err := json.Unmarshal(resp.Result, &subscribe); err != nil ||
+		len(subscribe) != 3 {
+		t.Fatalf("invalid mining.subscribe result %s", resp.Result)
+	}
+	var extraNonce1 string
+	json.Unmarshal(subscribe[1], &extraNonce1)
+	if want := fmt.Sprintf("%08x", c.extraNonce1); extraNonce1 != want {
+		t.Fatalf("extranonce1 %q, want %q", extraNonce1, want)
+	}
+	if string(subscribe[2]) != fmt.Sprint(stratumExtraNonce2Size) {
+		t.Fatalf("extranonce2 size %s, want %d", subscribe[2],
+			stratumExtraNonce2Size)
+	}
+
+	// The work follows the authorization.
+	m.expectResult(m.call("mining.authorize", "worker", "x"),
+		"mining.authorize")
+	m.expectDifficulty(stratumInitialDifficulty)
+	notify := m.read()
+	if notify.Method != "mining.notify" || len(notify.Params) != 3 ||
+		notify.Params[0] != s.job.id || notify.Params[2] != true {
+		t.Fatalf("got %s %v, want mining.notify of job %s", notify.Method,
+			notify.Params, s.job.id)
+	}
+	headerHex, _ := notify.Params[1].(string)
+	headerBytes, err := hex.DecodeString(headerHex)
+	if err != nil {
+		t.Fatalf("invalid header %q: %v", headerHex, err)
+	}
+	var header types.BlockHeader
+	if err := header.Deserialize(bytes.NewReader(headerBytes)); err != nil {
+		t.Fatalf("invalid header: %v", err)
+	}
+	if header.TxRoot == s.job.block.Header.TxRoot {
+		t.Fatalf("the merkle root of the work doesn't commit to the " +
+			"extra nonce of the connection")
+	}
+	ntime := hex.EncodeToString(headerBytes[112:120])
+	if got, _ := decodeHeaderField(ntime); got != uint64(header.Timestamp.Unix()) {
+		t.Fatalf("ntime %d at offset 112, want %d", got,
+			header.Timestamp.Unix())
+	}
+
+	share := func(nonce uint64) []string {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], nonce)
+		return []string{"worker", s.job.id, "0000000000000000", ntime,
+			hex.EncodeToString(b[:])}
+	}
+	m.expectResult(m.call("mining.submit", share(0)...), "mining.submit")
+	m.expectError(m.call("mining.submit", share(0)...), "mining.submit",
+		stratumErrDuplicate)
+	unknownJob := share(1)
+	unknownJob[1] = "ff"
+	m.expectError(m.call("mining.submit", unknownJob...), "mining.submit",
+		stratumErrJobNotFound)
+	badNonce := share(1)
+	badNonce[4] = "00"
+	m.expectError(m.call("mining.submit", badNonce...), "mining.submit",
+		stratumErrOther)
+
+	// The shares come way too fast, so the difficulty is raised as much
+	// as allowed once enough of them are found, before the last share is
+	// answered.
+	for nonce := uint64(1); nonce < stratumRetargetShares-1; nonce++ {
+		m.expectResult(m.call("mining.submit", share(nonce)...),
+			"mining.submit")
+	}
+	m.request("mining.submit", share(stratumRetargetShares-1)...)
+	m.expectDifficulty(4 * stratumInitialDifficulty)
+	m.expectResult(m.read(), "mining.submit")
+
+	// The shares found before the hash rate window are forgotten, and the
+	// shares at the previous difficulty are still accepted for the job.
+	c.mtx.Lock()
+	c.shares = append([]stratumShare{{
+		time:       time.Now().Add(-2 * stratumHashrateWindow),
+		difficulty: 1,
+	}}, c.shares...)
+	c.mtx.Unlock()
+	nonce := uint64(stratumRetargetShares)
+	for {
+		h := header
+		h.Nonce = nonce
+		blockHash := h.BlockHash()
+		if blockchain.HashToBig(&blockHash).Cmp(c.target) > 0 {
+			break
+		}
+		nonce++
+	}
+	m.expectResult(m.call("mining.submit", share(nonce)...), "mining.submit")
+
+	state := c.state()
+	if state.accepted != stratumRetargetShares+1 || state.rejected != 3 {
+		t.Fatalf("got %d accepted and %d rejected shares, want %d and 3",
+			state.accepted, state.rejected, stratumRetargetShares+1)
+	}
+	if state.difficulty != 4*stratumInitialDifficulty {
+		t.Fatalf("share difficulty %v, want %v", state.difficulty,
+			4*stratumInitialDifficulty)
+	}
+	c.mtx.Lock()
+	numShares := len(c.shares)
+	c.mtx.Unlock()
+	if numShares != stratumRetargetShares+1 {
+		t.Fatalf("%d shares are kept, want %d", numShares,
+			stratumRetargetShares+1)
+	}
+	if state.hashrate <= 0 {
+		t.Fatalf("hash rate %v, want a positive one", state.hashrate)
+	}
+}
+
+// TestStratumMaxClients ensures the server refuses the connections above the
+// maximum number of connections.
+func TestStratumMaxClients(t *testing.T) {
+	s := newTestStratumServer()
+	for i := 0; i < stratumMaxClients; i++ {
+		conn, _ := net.Pipe()
+		defer conn.Close()
+		if s.addClient(conn) == nil {
+			t.Fatalf("connection %d was refused", i)
+		}
+	}
+	conn, _ := net.Pipe()
+	defer conn.Close()
+	if s.addClient(conn) != nil {
+		t.Fatalf("connection above the maximum was accepted")
+	}
+}
+
+// TestDecodeHeaderField ensures the fields of the shares are decoded from the
+// hex of the 8 bytes of the serialized header.
+func TestDecodeHeaderField(t *testing.T) {
+	tests := []struct {
+		field string
+		want  uint64
+		valid bool
+	}{
+		{"0100000000000000", 1, true},
+		{"efcdab8967452301", 0x0123456789abcdef, true},
+		{"ffffffffffffffff", 0xffffffffffffffff, true},
+		{"01000000", 0, false},
+		{"010000000000000000", 0, false},
+		{"zz00000000000000", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		got, err := decodeHeaderField(test.field)
+		if (err == nil) != test.valid {
+			t.Errorf("decodeHeaderField(%q): got error %v, want valid %v",
+				test.field, err, test.valid)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("decodeHeaderField(%q) = %x, want %x", test.field,
+				got, test.want)
+		}
+	}
+}
@@ -64,6 +64,14 @@ const (
 
 	// ErrFetchTxStore indicates a transaction store failed to fetch.
 	ErrFetchTxStore
+
+	// ErrGettingStateRoot indicates that there was an error calculating
+	// the utxo set commitment of a block template.
+	ErrGettingStateRoot
+
+	// ErrGettingBlockVersion indicates that there was an error calculating
+	// the version bits of a block template.
+	ErrGettingBlockVersion
 )
 
 // Map of MiningErrorCode values back to their constant names for pretty printing.
@@ -81,6 +89,8 @@ var miningErrorCodeStrings = map[MiningErrorCode]string{
 	ErrCoinbaseLengthOverflow: "ErrCoinbaseLengthOverflow",
 	ErrFraudProofIndex:        "ErrFraudProofIndex",
 	ErrFetchTxStore:           "ErrFetchTxStore",
+	ErrGettingStateRoot:       "ErrGettingStateRoot",
+	ErrGettingBlockVersion:    "ErrGettingBlockVersion",
 }
 
 // String returns the MiningErrorCode as a human-readable name.
@@ -9,12 +9,13 @@ package mining
 import (
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
 	"github.com/Qitmeer/qitmeer-lib/core/protocol"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/merkle"
 	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/services/mempool"
 	"time"
 )
 
@@ -60,8 +61,9 @@ type TxSource interface {
 	LastUpdated() time.Time
 
 	// MiningDescs returns a slice of mining descriptors for all the
-	// transactions in the source pool.
-	MiningDescs() []*types.TxDesc
+	// transactions in the source pool, along with their ancestor
+	// statistics.
+	MiningDescs() []*mempool.TxDesc
 
 	// HaveTransaction returns whether or not the passed transaction hash
 	// exists in the source pool.
@@ -190,7 +192,34 @@ func BlockVersion(net protocol.Network) uint32 {
 	return blockVersion
 }
 
-func fillWitnessToCoinBase(blockTxns []*types.Tx) error {
+// powBlockVersion returns the block version flags which the proof of work of
+// the network requires, which flag the header to carry the cycle proof when
+// the network uses the cuckaroo proof of work.
+func powBlockVersion(par *params.Params) uint32 {
+	if par.PowType == params.CuckarooPow {
+		return types.CycleProofBlockVersion
+	}
+	return 0
+}
+
+// stateRootBlockVersion returns the block version flag which commits the next
+// block to the utxo set, which is only set once the state root deployment is
+// active so the blocks agree with the version the chain expects.
+func stateRootBlockVersion(chain *blockchain.BlockChain) (uint32, error) {
+	active, err := chain.IsDeploymentActive(params.DeploymentIdStateRoot)
+	if err != nil {
+		return 0, err
+	}
+	if active {
+		return blockchain.StateRootBlockVersion, nil
+	}
+	return 0, nil
+}
+
+// FillWitnessToCoinBase commits the coinbase of the passed transactions to the
+// witness merkle root of the block and to its own signature script, so it has
+// to be called again whenever the extra nonce of the coinbase changes.
+func FillWitnessToCoinBase(blockTxns []*types.Tx) error {
 	merkles := merkle.BuildMerkleTreeStore(blockTxns, true)
 	txWitnessRoot := merkles[len(merkles)-1]
 	witnessPreimage := append(txWitnessRoot.Bytes(), blockTxns[0].Tx.TxIn[0].SignScript...)
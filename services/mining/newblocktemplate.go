@@ -1,13 +1,14 @@
 package mining
 
 import (
+	"bytes"
 	"container/heap"
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/merkle"
 	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/params"
@@ -28,9 +29,12 @@ import (
 // factors.  First, each transaction has a priority calculated based on its
 // value, age of inputs, and size.  Transactions which consist of larger
 // amounts, older inputs, and small sizes have the highest priority.  Second, a
-// fee per kilobyte is calculated for each transaction.  Transactions with a
-// higher fee per kilobyte are preferred.  Finally, the block generation related
-// policy settings are all taken into account.
+// fee per kilobyte is calculated for the package of each transaction, which is
+// the transaction together with its ancestors in the source pool that are not
+// in the block yet.  Packages with a higher fee per kilobyte are preferred, so
+// a child paying a high fee gets its parents mined (child-pays-for-parent).
+// Finally, the block generation related policy settings are all taken into
+// account.
 //
 // Transactions which only spend outputs from other transactions already in the
 // block chain are immediately added to a priority queue which either
@@ -43,13 +47,14 @@ import (
 //
 // Once the high-priority area (if configured) has been filled with
 // transactions, or the priority falls below what is considered high-priority,
-// the priority queue is updated to prioritize by fees per kilobyte (then
-// priority).
+// the remaining transactions are selected by the fees per kilobyte of their
+// packages (then priority).  Including a package updates the packages of the
+// transactions depending on it.
 //
-// When the fees per kilobyte drop below the TxMinFreeFee policy setting, the
-// transaction will be skipped unless the BlockMinSize policy setting is
-// nonzero, in which case the block will be filled with the low-fee/free
-// transactions until the block size reaches that minimum size.
+// When the package fees per kilobyte drop below the TxMinFreeFee policy
+// setting, the package will be skipped unless the BlockMinSize policy setting
+// is nonzero, in which case the block will be filled with the low-fee/free
+// packages until the block size reaches that minimum size.
 //
 // Any transactions which would cause the block to exceed the BlockMaxSize
 // policy setting, exceed the maximum allowed signature operations per block, or
@@ -156,6 +161,9 @@ func NewBlockTemplate(policy *Policy, params *params.Params,
 	// determine which dependent transactions are now eligible for inclusion
 	// in the block once each transaction has been included.
 	dependers := make(map[hash.Hash]map[hash.Hash]*txPrioItem)
+	// candidates holds all the transactions of the source pool which can
+	// be included in the block once their dependencies are.
+	candidates := make(map[hash.Hash]*txPrioItem, len(sourceTxns))
 	// Create slices to hold the fees and number of signature operations
 	// for each of the selected transactions and add an entry for the
 	// coinbase.  This allows the code below to simply append details about
@@ -199,7 +207,15 @@ mempoolLoop:
 		// Setup dependencies for any transactions which reference
 		// other transactions in the mempool so they can be properly
 		// ordered below.
-		prioItem := &txPrioItem{tx: tx}
+		prioItem := &txPrioItem{
+			tx:            tx,
+			size:          uint32(tx.Transaction().SerializeSize()),
+			sigOpCost:     blockchain.CountSigOps(tx),
+			ancestorSize:  txDesc.AncestorSize,
+			ancestorFees:  txDesc.AncestorFees,
+			ancestorCount: txDesc.AncestorCount,
+			index:         -1,
+		}
 		for _, txIn := range tx.Tx.TxIn {
 			originHash := &txIn.PreviousOut.Hash
 			entry := utxos.LookupEntry(txIn.PreviousOut)
@@ -245,6 +261,7 @@ mempoolLoop:
 
 		// Add the transaction to the priority queue to mark it ready
 		// for inclusion in the block unless it has dependencies.
+		candidates[*tx.Hash()] = prioItem
 		if prioItem.dependsOn == nil {
 			heap.Push(priorityQueue, prioItem)
 		}
@@ -263,10 +280,99 @@ mempoolLoop:
 	blockSigOpCost := coinbaseSigOpCost
 	totalFees := int64(0)
 
-	// Choose which transactions make it into the block.
-	for priorityQueue.Len() > 0 {
-		// Grab the highest priority (or highest fee per kilobyte
-		// depending on the sort order) transaction.
+	// packageQueue holds the transactions ordered by the fee per kilobyte
+	// of their packages once the high-priority area is filled.
+	var packageQueue *txPriorityQueue
+
+	// includeTx adds the passed transaction to the block after checking its
+	// inputs and scripts against the transactions already in the block.
+	// The transactions which depend on it no longer wait for it and are
+	// added to the priority queue once all their dependencies are in the
+	// block, and it is no longer part of the packages of its descendants.
+	includeTx := func(prioItem *txPrioItem) bool {
+		tx := prioItem.tx
+
+		// Ensure the transaction inputs pass all of the necessary
+		// preconditions before allowing it to be added to the block.
+		_, err := blockchain.CheckTransactionInputs(tx,
+			0, blockUtxos, params, blockManager.GetChain().BlockDAG())
+		if err != nil {
+			log.Trace(fmt.Sprintf("Skipping tx %s due to error in "+
+				"CheckTransactionInputs: %v", tx.Hash(), err))
+			prioItem.failed = true
+			logSkippedDeps(tx, dependers[*tx.Hash()])
+			return false
+		}
+		err = blockchain.ValidateTransactionScripts(tx, blockUtxos,
+			scriptFlags, sigCache)
+		if err != nil {
+			log.Trace(fmt.Sprintf("Skipping tx %s due to error in "+
+				"ValidateTransactionScripts: %v", tx.Hash(), err))
+			prioItem.failed = true
+			logSkippedDeps(tx, dependers[*tx.Hash()])
+			return false
+		}
+
+		// Spend the transaction inputs in the block utxo view and add
+		// an entry for it to ensure any transactions which reference
+		// this one have it available as an input and can ensure they
+		// aren't double spending.
+		err = spendTransaction(blockUtxos, tx, &hash.ZeroHash)
+		if err != nil {
+			log.Warn(fmt.Sprintf("Unable to spend transaction %v in the preliminary "+
+				"UTXO view for the block template: %v",
+				tx.Hash(), err))
+		}
+		// Add the transaction to the block, increment counters, and
+		// save the fees and signature operation counts to the block
+		// template.
+		prioItem.included = true
+		blockTxns = append(blockTxns, tx)
+		blockSize += prioItem.size
+		blockSigOpCost += int64(prioItem.sigOpCost)
+		totalFees += prioItem.fee
+		txFees = append(txFees, prioItem.fee)
+		txSigOpCosts = append(txSigOpCosts, int64(prioItem.sigOpCost))
+
+		log.Trace(fmt.Sprintf("Adding tx %s (priority %.2f, feePerKB %.2d)",
+			prioItem.tx.Hash(), prioItem.priority, prioItem.feePerKB))
+
+		// Add transactions which depend on this one (and also do not
+		// have any other unsatisified dependencies) to the priority
+		// queue.
+		for _, item := range dependers[*tx.Hash()] {
+			// Add the transaction to the priority queue if there
+			// are no more dependencies after this one.  The
+			// packages are selected from all the transactions once
+			// the high-priority area is filled.
+			delete(item.dependsOn, *tx.Hash())
+			if len(item.dependsOn) == 0 && !sortedByFee {
+				heap.Push(priorityQueue, item)
+			}
+		}
+		descendants := []*types.Tx{tx}
+		seen := make(map[hash.Hash]struct{})
+		for i := 0; i < len(descendants); i++ {
+			for dh, item := range dependers[*descendants[i].Hash()] {
+				if _, ok := seen[dh]; ok {
+					continue
+				}
+				seen[dh] = struct{}{}
+				descendants = append(descendants, item.tx)
+				item.ancestorSize -= int64(prioItem.size)
+				item.ancestorFees -= prioItem.fee
+				if packageQueue != nil && item.index >= 0 {
+					heap.Fix(packageQueue, item.index)
+				}
+			}
+		}
+		return true
+	}
+
+	// Fill the high-priority area first, if there is one, with the
+	// transactions whose dependencies are in the block already.
+	for !sortedByFee && priorityQueue.Len() > 0 {
+		// Grab the highest priority transaction.
 		prioItem := heap.Pop(priorityQueue).(*txPrioItem)
 		tx := prioItem.tx
 
@@ -274,12 +380,11 @@ mempoolLoop:
 		deps := dependers[*tx.Hash()]
 
 		// Enforce maximum block size.  Also check for overflow.
-		txSize := uint32(tx.Transaction().SerializeSize())
-		blockPlusTxSize := blockSize + txSize
+		blockPlusTxSize := blockSize + prioItem.size
 		if blockPlusTxSize < blockSize || blockPlusTxSize >= policy.BlockMaxSize {
 			log.Trace(fmt.Sprintf("Skipping tx %s (size %v) because it "+
 				"would exceed the max block size; cur block "+
-				"size %v, cur num tx %v", tx.Hash(), txSize,
+				"size %v, cur num tx %v", tx.Hash(), prioItem.size,
 				blockSize, len(blockTxns)))
 			logSkippedDeps(tx, deps)
 			continue
@@ -287,34 +392,20 @@ mempoolLoop:
 
 		// Enforce maximum signature operation cost per block.  Also
 		// check for overflow.
-		sigOpCost := blockchain.CountSigOps(tx)
-		if blockSigOpCost+int64(sigOpCost) < blockSigOpCost ||
-			blockSigOpCost+int64(sigOpCost) > blockchain.MaxSigOpsPerBlock {
+		sigOpCost := int64(prioItem.sigOpCost)
+		if blockSigOpCost+sigOpCost < blockSigOpCost ||
+			blockSigOpCost+sigOpCost > blockchain.MaxSigOpsPerBlock {
 			log.Trace(fmt.Sprintf("Skipping tx %s because it would "+
 				"exceed the maximum sigops per block", tx.Hash()))
 			logSkippedDeps(tx, deps)
 			continue
 		}
 
-		// Skip free transactions once the block is larger than the
-		// minimum block size.
-		if sortedByFee &&
-			prioItem.feePerKB < int64(policy.TxMinFreeFee) &&
-			(blockPlusTxSize >= policy.BlockMinSize) {
-			log.Trace(fmt.Sprintf("Skipping tx %s with feePerKB %.2d "+
-				"< TxMinFreeFee %d and block size %d >= "+
-				"minBlockSize %d", tx.Hash(), prioItem.feePerKB,
-				policy.TxMinFreeFee, blockPlusTxSize,
-				policy.BlockMinSize))
-			logSkippedDeps(tx, deps)
-			continue
-		}
-
 		// Prioritize by fee per kilobyte once the block is larger than
 		// the priority size or there are no more high-priority
 		// transactions.
-		if !sortedByFee && (blockPlusTxSize >= policy.BlockPrioritySize ||
-			prioItem.priority <= mempool.MinHighPriority) {
+		if blockPlusTxSize >= policy.BlockPrioritySize ||
+			prioItem.priority <= mempool.MinHighPriority {
 			log.Trace(fmt.Sprintf("Switching to sort by fees per "+
 				"kilobyte blockSize %d >= BlockPrioritySize "+
 				"%d || priority %.2f <= minHighPriority %.2f",
@@ -322,72 +413,93 @@ mempoolLoop:
 				prioItem.priority, mempool.MinHighPriority))
 
 			sortedByFee = true
-			priorityQueue.SetLessFunc(txPQByFee)
-			// Put the transaction back into the priority queue and
-			// skip it so it is re-priortized by fees if it won't
-			// fit into the high-priority section or the priority is
-			// too low.  Otherwise this transaction will be the
-			// final one in the high-priority section, so just fall
-			// though to the code below so it is added now.
+			// Leave the transaction to be re-prioritized by fees if
+			// it won't fit into the high-priority section or the
+			// priority is too low.  Otherwise this transaction will
+			// be the final one in the high-priority section, so
+			// just fall though to the code below so it is added
+			// now.
 			if blockPlusTxSize > policy.BlockPrioritySize ||
 				prioItem.priority < mempool.MinHighPriority {
-
-				heap.Push(priorityQueue, prioItem)
-				continue
+				break
 			}
 		}
 
-		// Ensure the transaction inputs pass all of the necessary
-		// preconditions before allowing it to be added to the block.
-		_, err = blockchain.CheckTransactionInputs(tx,
-			0, blockUtxos, params, blockManager.GetChain().BlockDAG())
-		if err != nil {
-			log.Trace(fmt.Sprintf("Skipping tx %s due to error in "+
-				"CheckTransactionInputs: %v", tx.Hash(), err))
-			logSkippedDeps(tx, deps)
+		includeTx(prioItem)
+	}
+
+	// Select the remaining transactions by the fee per kilobyte of their
+	// package, which is the transaction together with its ancestors in
+	// the source pool which are not in the block yet, as tracked by the
+	// ancestor statistics of the pool.  This way a child paying a high fee
+	// gets its low fee parents mined with it (child-pays-for-parent).
+	packageQueue = newTxPriorityQueue(len(candidates), txPQByAncestorFee)
+	for _, item := range candidates {
+		// The items left in the priority queue are no longer tracked
+		// by it.
+		item.index = -1
+		if item.included || item.failed {
 			continue
 		}
-		err = blockchain.ValidateTransactionScripts(tx, blockUtxos,
-			scriptFlags, sigCache)
-		if err != nil {
-			log.Trace(fmt.Sprintf("Skipping tx %s due to error in "+
-				"ValidateTransactionScripts: %v", tx.Hash(), err))
-			logSkippedDeps(tx, deps)
+		heap.Push(packageQueue, item)
+	}
+
+	for packageQueue.Len() > 0 {
+		// Grab the transaction with the highest package fee per
+		// kilobyte.
+		prioItem := heap.Pop(packageQueue).(*txPrioItem)
+		tx := prioItem.tx
+		pkg, ok := pendingAncestors(prioItem, candidates)
+		if !ok {
 			continue
 		}
 
-		// Spend the transaction inputs in the block utxo view and add
-		// an entry for it to ensure any transactions which reference
-		// this one have it available as an input and can ensure they
-		// aren't double spending.
-		err = spendTransaction(blockUtxos, tx, &hash.ZeroHash)
-		if err != nil {
-			log.Warn(fmt.Sprintf("Unable to spend transaction %v in the preliminary "+
-				"UTXO view for the block template: %v",
-				tx.Hash(), err))
+		// Enforce maximum block size for the whole package.  Also
+		// check for overflow.
+		pkgSize := uint32(prioItem.ancestorSize)
+		blockPlusPkgSize := blockSize + pkgSize
+		if blockPlusPkgSize < blockSize || blockPlusPkgSize >= policy.BlockMaxSize {
+			log.Trace(fmt.Sprintf("Skipping tx %s (package size %v) "+
+				"because it would exceed the max block size; cur "+
+				"block size %v, cur num tx %v", tx.Hash(),
+				pkgSize, blockSize, len(blockTxns)))
+			continue
 		}
-		// Add the transaction to the block, increment counters, and
-		// save the fees and signature operation counts to the block
-		// template.
-		blockTxns = append(blockTxns, tx)
-		blockSize += txSize
-		blockSigOpCost += int64(sigOpCost)
-		totalFees += prioItem.fee
-		txFees = append(txFees, prioItem.fee)
-		txSigOpCosts = append(txSigOpCosts, int64(sigOpCost))
 
-		log.Trace(fmt.Sprintf("Adding tx %s (priority %.2f, feePerKB %.2d)",
-			prioItem.tx.Hash(), prioItem.priority, prioItem.feePerKB))
+		// Enforce maximum signature operation cost per block for the
+		// whole package.  Also check for overflow.
+		var pkgSigOpCost int64
+		for _, item := range pkg {
+			pkgSigOpCost += int64(item.sigOpCost)
+		}
+		if blockSigOpCost+pkgSigOpCost < blockSigOpCost ||
+			blockSigOpCost+pkgSigOpCost > blockchain.MaxSigOpsPerBlock {
+			log.Trace(fmt.Sprintf("Skipping tx %s because its package "+
+				"would exceed the maximum sigops per block", tx.Hash()))
+			continue
+		}
 
-		// Add transactions which depend on this one (and also do not
-		// have any other unsatisified dependencies) to the priority
-		// queue.
-		for _, item := range deps {
-			// Add the transaction to the priority queue if there
-			// are no more dependencies after this one.
-			delete(item.dependsOn, *tx.Hash())
-			if len(item.dependsOn) == 0 {
-				heap.Push(priorityQueue, item)
+		// Skip free packages once the block is larger than the minimum
+		// block size.
+		pkgFeePerKB := prioItem.ancestorFeePerKB()
+		if pkgFeePerKB < int64(policy.TxMinFreeFee) &&
+			(blockPlusPkgSize >= policy.BlockMinSize) {
+			log.Trace(fmt.Sprintf("Skipping tx %s with package "+
+				"feePerKB %.2d < TxMinFreeFee %d and block size "+
+				"%d >= minBlockSize %d", tx.Hash(),
+				pkgFeePerKB, policy.TxMinFreeFee,
+				blockPlusPkgSize, policy.BlockMinSize))
+			continue
+		}
+
+		// Add the package parents first.  A transaction of the package
+		// which fails the checks leaves the rest of the package out.
+		for _, item := range pkg {
+			if item.index >= 0 {
+				heap.Remove(packageQueue, item.index)
+			}
+			if !includeTx(item) {
+				break
 			}
 		}
 	}
@@ -396,7 +508,7 @@ mempoolLoop:
 	txFees[0] = -totalFees
 
 	// Fill witness
-	err = fillWitnessToCoinBase(blockTxns)
+	err = FillWitnessToCoinBase(blockTxns)
 	if err != nil {
 		return nil, miningRuleError(ErrCreatingCoinbase, err.Error())
 	}
@@ -408,8 +520,19 @@ mempoolLoop:
 		return nil, miningRuleError(ErrGettingDifficulty, err.Error())
 	}
 
-	// Choose the block version to generate based on the network.
-	blockVersion := BlockVersion(params.Net)
+	// Choose the block version to generate based on the network and the
+	// deployments being voted on, and signal the commitment to the utxo
+	// set once it is required along with the proof of work of the network.
+	blockVersion, err := blockManager.GetChain().CalcNextBlockVersion()
+	if err != nil {
+		return nil, miningRuleError(ErrGettingBlockVersion, err.Error())
+	}
+	stateRootVersion, err := stateRootBlockVersion(blockManager.GetChain())
+	if err != nil {
+		return nil, miningRuleError(ErrGettingBlockVersion, err.Error())
+	}
+	blockVersion |= stateRootVersion
+	blockVersion |= powBlockVersion(params)
 
 	// Create a new block ready to be solved.
 	merkles := merkle.BuildMerkleTreeStore(blockTxns, false)
@@ -417,13 +540,17 @@ mempoolLoop:
 	if parents == nil {
 		parents = blockManager.GetChain().GetMiningTips()
 	}
+	stateRoot, err := blockManager.GetChain().CalcNextStateRoot(parents)
+	if err != nil {
+		return nil, miningRuleError(ErrGettingStateRoot, err.Error())
+	}
 	paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
 	var block types.Block
 	block.Header = types.BlockHeader{
 		Version:    blockVersion,
 		ParentRoot: *paMerkles[len(paMerkles)-1],
 		TxRoot:     *merkles[len(merkles)-1],
-		StateRoot:  hash.Hash{}, //TODO, state root
+		StateRoot:  *stateRoot,
 		Timestamp:  ts,
 		Difficulty: reqDifficulty,
 		// Size declared below
@@ -566,10 +693,20 @@ func handleCreatedBlockTemplate(blockTemplate *types.BlockTemplate, bm *blkmgr.B
 	nextBlockHeight := blockTemplate.Height
 
 	// Overwrite the old cached block if it's out of date.
-	if curTemplate != nil {
-		if curTemplate.Height == nextBlockHeight {
-			bm.SetCurrentTemplate(blockTemplate)
+	if curTemplate == nil || curTemplate.Height <= nextBlockHeight {
+		var buf bytes.Buffer
+		err := blockTemplate.Block.Serialize(&buf)
+		if err != nil {
+			return nil, err
+		}
+		var block types.Block
+		err = block.Deserialize(&buf)
+		if err != nil {
+			return nil, err
 		}
+		templateCopy := *blockTemplate
+		templateCopy.Block = &block
+		bm.SetCurrentTemplate(&templateCopy)
 	}
 
 	return blockTemplate, nil
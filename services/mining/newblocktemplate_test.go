@@ -0,0 +1,221 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mining
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/consensus/pow"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/merkle"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/services/blkmgr"
+	"github.com/Qitmeer/qitmeer/services/index"
+	"github.com/Qitmeer/qitmeer/services/mempool"
+)
+
+// testOpTrueScript is the script of the outputs anyone can spend.
+var testOpTrueScript = []byte{txscript.OP_TRUE}
+
+// addTestBlock adds to the passed chain a block on top of its tips whose
+// coinbase pays the subsidy of the passed height to anyone.
+func addTestBlock(t *testing.T, chain *blockchain.BlockChain, height uint64, timestamp time.Time) *types.SerializedBlock {
+	par := &params.PrivNetParams
+	coinbaseScript, err := standardCoinbaseScript(height, 0)
+	if err != nil {
+		t.Fatalf("failed to create coinbase script: %v", err)
+	}
+	coinbase, err := createCoinbaseTx(chain.FetchSubsidyCache(),
+		coinbaseScript, nil, int64(height), nil, par)
+	if err != nil {
+		t.Fatalf("failed to create coinbase: %v", err)
+	}
+	txns := []*types.Tx{coinbase}
+	if err := FillWitnessToCoinBase(txns); err != nil {
+		t.Fatalf("failed to commit the witness: %v", err)
+	}
+
+	difficulty, err := chain.CalcNextRequiredDifficulty(timestamp)
+	if err != nil {
+		t.Fatalf("failed to calculate difficulty: %v", err)
+	}
+	version, err := chain.CalcNextBlockVersion()
+	if err != nil {
+		t.Fatalf("failed to calculate block version: %v", err)
+	}
+	parents := chain.GetMiningTips()
+	merkles := merkle.BuildMerkleTreeStore(txns, false)
+	paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
+	var block types.Block
+	block.Header = types.BlockHeader{
+		Version:    version,
+		ParentRoot: *paMerkles[len(paMerkles)-1],
+		TxRoot:     *merkles[len(merkles)-1],
+		Timestamp:  timestamp,
+		Difficulty: difficulty,
+	}
+	for _, parent := range parents {
+		if err := block.AddParent(parent); err != nil {
+			t.Fatalf("failed to add parent: %v", err)
+		}
+	}
+	if err := block.AddTransaction(coinbase.Transaction()); err != nil {
+		t.Fatalf("failed to add coinbase: %v", err)
+	}
+	sblock := types.NewBlock(&block)
+	_, isOrphan, err := chain.ProcessBlock(sblock, blockchain.BFNoPoWCheck)
+	if err != nil || isOrphan {
+		t.Fatalf("failed to process block %d: orphan %v error %v", height,
+			isOrphan, err)
+	}
+	return sblock
+}
+
+// testSpend returns a transaction paying the passed output of the passed
+// transaction to anyone, less the passed fee.
+func testSpend(tx *types.Tx, index uint32, fee uint64) *types.Tx {
+	spend := types.NewTransaction()
+	spend.AddTxIn(types.NewTxInput(types.NewOutPoint(tx.Hash(), index),
+		nil))
+	amount := tx.Transaction().TxOut[index].Amount - fee
+	spend.AddTxOut(types.NewTxOutput(amount, testOpTrueScript))
+	return types.NewTx(spend)
+}
+
+// TestNewBlockTemplatePackages ensures a parent paying a fee rate too low to
+// be mined on its own is included before its child paying for both of them,
+// while a transaction paying the same low fee rate on its own is left out.
+func TestNewBlockTemplatePackages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mining")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	par := &params.PrivNetParams
+	db, err := database.Create("ffldb", dir, par.Net)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Mine enough blocks to spend the first coinbases, before the block
+	// manager takes over the chain.
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:           db,
+		ChainParams:  par,
+		TimeSource:   blockchain.NewMedianTime(),
+		DAGType:      "phantom",
+		BlockVersion: BlockVersion(par.Net),
+		PoW:          pow.New(par),
+	})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	var coinbases []*types.Tx
+	timestamp := par.GenesisBlock.Header.Timestamp
+	for height := uint64(1); height <= uint64(par.CoinbaseMaturity)+3; height++ {
+		timestamp = timestamp.Add(par.TargetTimePerBlock)
+		block := addTestBlock(t, chain, height, timestamp)
+		coinbases = append(coinbases, block.Transactions()[0])
+	}
+
+	timeSource := blockchain.NewMedianTime()
+	sigCache := txscript.NewSigCache(100)
+	bm, err := blkmgr.NewBlockManager(nil, nil, (*index.TimeIndex)(nil),
+		db, timeSource, sigCache, &config.Config{DAGType: "phantom",
+			DisableCheckpoints: true}, par, BlockVersion(par.Net), nil)
+	if err != nil {
+		t.Fatalf("failed to create block manager: %v", err)
+	}
+	bm.Start()
+	defer bm.WaitForStop()
+	defer bm.Stop()
+	chain = bm.GetChain()
+
+	verifyFlags := func() (txscript.ScriptFlags, error) { return 0, nil }
+	mp := mempool.New(&mempool.Config{
+		Policy: mempool.Policy{
+			MaxTxVersion:         2,
+			DisableRelayPriority: true,
+			AcceptNonStd:         true,
+			MaxOrphanTxs:         10,
+			MaxSigOpsPerTx:       blockchain.MaxSigOpsPerBlock / 5,
+			MinRelayTxFee:        1000,
+			StandardVerifyFlags:  verifyFlags,
+		},
+		ChainParams:   par,
+		FetchUtxoView: chain.FetchUtxoView,
+		BestHash:      func() *hash.Hash { return &chain.BestSnapshot().Hash },
+		BestHeight: func() uint64 {
+			return uint64(chain.BestSnapshot().GraphState.GetMainHeight())
+		},
+		CalcSequenceLock: chain.CalcSequenceLock,
+		SubsidyCache:     chain.FetchSubsidyCache(),
+		SigCache:         sigCache,
+		PastMedianTime: func() time.Time {
+			return chain.BestSnapshot().MedianTime
+		},
+		BD: chain.BlockDAG(),
+	})
+
+	// Both the parent and the lonely transaction pay less than the minimum
+	// fee rate of the block, but the child pays for its parent.  The heavy
+	// child pays as much, which isn't enough for its large parent.
+	const minFeeRate = 100000
+	parent := testSpend(coinbases[0], 0, 1000)
+	child := testSpend(parent, 0, 100000)
+	lonely := testSpend(coinbases[1], 0, 1000)
+	heavyParent := testSpend(coinbases[2], 0, 2000)
+	for i := 0; i < 100; i++ {
+		heavyParent.Tx.AddTxOut(types.NewTxOutput(0, testOpTrueScript))
+	}
+	heavyParent.RefreshHash()
+	heavyChild := testSpend(heavyParent, 0, 100000)
+	for _, tx := range []*types.Tx{lonely, parent, child, heavyParent,
+		heavyChild} {
+		_, err := mp.ProcessTransaction(tx, false, false, true)
+		if err != nil {
+			t.Fatalf("ProcessTransaction %v: %v", tx.Hash(), err)
+		}
+	}
+
+	policy := &Policy{
+		BlockMaxSize:        types.MaxBlockPayload,
+		TxMinFreeFee:        minFeeRate,
+		StandardVerifyFlags: verifyFlags,
+	}
+	template, err := NewBlockTemplate(policy, par, sigCache, mp, timeSource,
+		bm, nil, nil)
+	if err != nil {
+		t.Fatalf("NewBlockTemplate: %v", err)
+	}
+
+	txns := template.Block.Transactions
+	if len(txns) != 3 || txns[1].TxHash() != *parent.Hash() ||
+		txns[2].TxHash() != *child.Hash() {
+		var got []hash.Hash
+		for _, tx := range txns[1:] {
+			got = append(got, tx.TxHash())
+		}
+		t.Fatalf("template has transactions %v, want only the parent "+
+			"%v followed by the child %v", got, parent.Hash(),
+			child.Hash())
+	}
+	if template.Fees[1] != 1000 || template.Fees[2] != 100000 ||
+		template.Fees[0] != -101000 {
+		t.Fatalf("template has fees %v, want [-101000 1000 100000]",
+			template.Fees)
+	}
+}
@@ -3,17 +3,41 @@ package mining
 import (
 	"container/heap"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"sort"
 )
 
 // txPrioItem houses a transaction along with extra information that allows the
 // transaction to be prioritized and track dependencies on other transactions
 // which have not been mined into a block yet.
 type txPrioItem struct {
-	tx       *types.Tx
-	fee      int64
-	priority float64
-	feePerKB int64
+	tx        *types.Tx
+	fee       int64
+	priority  float64
+	feePerKB  int64
+	size      uint32
+	sigOpCost int
+
+	// The size and the fees of the transaction together with its
+	// ancestors in the source pool which are not in the block yet.  They
+	// start from the ancestor statistics of the source pool and lose the
+	// ancestors as they are included in the block.
+	ancestorSize int64
+	ancestorFees int64
+
+	// ancestorCount is the number of ancestors of the transaction in the
+	// source pool, including itself, which orders a package parents
+	// first.
+	ancestorCount int64
+
+	// included and failed are set once the transaction has been added to
+	// the block or failed the checks to be added.
+	included bool
+	failed   bool
+
+	// index is the position of the item in the priority queue it is in,
+	// which is needed to update it in place, or -1.
+	index int
 
 	// dependsOn holds a map of transaction hashes which this one depends
 	// on.  It will only be set when the transaction references other
@@ -50,12 +74,16 @@ func (pq *txPriorityQueue) Less(i, j int) bool {
 // part of the heap.Interface implementation.
 func (pq *txPriorityQueue) Swap(i, j int) {
 	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
+	pq.items[i].index = i
+	pq.items[j].index = j
 }
 
 // Push pushes the passed item onto the priority queue.  It is part of the
 // heap.Interface implementation.
 func (pq *txPriorityQueue) Push(x interface{}) {
-	pq.items = append(pq.items, x.(*txPrioItem))
+	item := x.(*txPrioItem)
+	item.index = len(pq.items)
+	pq.items = append(pq.items, item)
 }
 
 // Pop removes the highest priority item (according to Less) from the priority
@@ -63,6 +91,7 @@ func (pq *txPriorityQueue) Push(x interface{}) {
 func (pq *txPriorityQueue) Pop() interface{} {
 	n := len(pq.items)
 	item := pq.items[n-1]
+	item.index = -1
 	pq.items[n-1] = nil
 	pq.items = pq.items[0 : n-1]
 	return item
@@ -113,3 +142,52 @@ func txPQByPriority(pq *txPriorityQueue, i, j int) bool {
 	return pq.items[i].priority > pq.items[j].priority
 
 }
+
+// ancestorFeePerKB returns the fee per kilobyte of the transaction together
+// with its ancestors which are not in the block yet.
+func (item *txPrioItem) ancestorFeePerKB() int64 {
+	return item.ancestorFees * kilobyte / item.ancestorSize
+}
+
+// txPQByAncestorFee sorts a txPriorityQueue by the fees per kilobyte of the
+// transactions together with their ancestors which are not in the block yet,
+// and then transaction priority.
+func txPQByAncestorFee(pq *txPriorityQueue, i, j int) bool {
+	// Using > here so that pop gives the highest fee item as opposed
+	// to the lowest.  Sort by ancestor fee first, then priority.
+	feeI, feeJ := pq.items[i].ancestorFeePerKB(), pq.items[j].ancestorFeePerKB()
+	if feeI == feeJ {
+		return pq.items[i].priority > pq.items[j].priority
+	}
+	return feeI > feeJ
+}
+
+// pendingAncestors returns the ancestors of the passed transaction which are
+// not in the block yet followed by the transaction itself, parents first.  It
+// returns false when one of them can't be included in the block.
+func pendingAncestors(item *txPrioItem, candidates map[hash.Hash]*txPrioItem) ([]*txPrioItem, bool) {
+	pkg := []*txPrioItem{item}
+	seen := map[hash.Hash]struct{}{*item.tx.Hash(): {}}
+	for i := 0; i < len(pkg); i++ {
+		if pkg[i].failed {
+			return nil, false
+		}
+		// The dependencies which are in the block already have been
+		// removed.
+		for h := range pkg[i].dependsOn {
+			if _, ok := seen[h]; ok {
+				continue
+			}
+			seen[h] = struct{}{}
+			parent, ok := candidates[h]
+			if !ok {
+				return nil, false
+			}
+			pkg = append(pkg, parent)
+		}
+	}
+	sort.Slice(pkg, func(i, j int) bool {
+		return pkg[i].ancestorCount < pkg[j].ancestorCount
+	})
+	return pkg, true
+}
@@ -1,10 +1,14 @@
 package notifymgr
 
 import (
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/json"
 	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/p2p/peerserver"
 	"github.com/Qitmeer/qitmeer/rpc"
+	"github.com/Qitmeer/qitmeer/services/acct"
+	"github.com/Qitmeer/qitmeer/services/miner"
 )
 
 // NotifyMgr manage message announce & relay & notification between mempool, websocket, gbt long pull
@@ -12,6 +16,14 @@ import (
 type NotifyMgr struct {
 	Server    *peerserver.PeerServer
 	RpcServer *rpc.RpcServer
+
+	// AcctManager tracks the outputs of the wallet as the blocks are
+	// connected and disconnected.
+	AcctManager *acct.AccountManager
+
+	// CPUMiner wakes up the getblocktemplate long poll clients when their
+	// templates are stale.
+	CPUMiner *miner.CPUMiner
 }
 
 // AnnounceNewTransactions generates and relays inventory vectors and notifies
@@ -29,16 +41,16 @@ func (ntmgr *NotifyMgr) AnnounceNewTransactions(newTxs []*types.Tx) {
 		ntmgr.RelayInventory(iv, tx)
 		// reply to rpc
 		if ntmgr.RpcServer != nil {
-			//TODO reply to rpc layer (if websockect long connection or gbt long poll)
 			// Notify websocket clients about mempool transactions.
-			//qitmeer.node.rpcServer.ntfnMgr.NotifyMempoolTx(tx, true)
-			//
-			// Potentially notify any getblocktemplate long poll clients
-			// about stale block templates due to the new transaction.
-			//qitmeer.node.rpcServer.gbtWorkState.NotifyMempoolTx(
-			//	qitmeer.txMemPool.LastUpdated())
+			ntmgr.RpcServer.NotifyMempoolTx(tx)
 		}
 	}
+
+	// Potentially notify any getblocktemplate long poll clients about stale
+	// block templates due to the new transactions.
+	if ntmgr.CPUMiner != nil && len(newTxs) > 0 {
+		ntmgr.CPUMiner.NotifyMempoolTx()
+	}
 }
 
 // RelayInventory relays the passed inventory vector to all connected peers
@@ -50,3 +62,45 @@ func (ntmgr *NotifyMgr) RelayInventory(invVect *message.InvVect, data interface{
 func (ntmgr *NotifyMgr) BroadcastMessage(msg message.Message) {
 	ntmgr.Server.BroadcastMessage(msg)
 }
+
+// NotifyBlockConnected notifies the wallet, the websocket clients and the
+// getblocktemplate long poll clients that the passed block has been connected.
+func (ntmgr *NotifyMgr) NotifyBlockConnected(block *types.SerializedBlock) {
+	if ntmgr.AcctManager != nil {
+		ntmgr.AcctManager.ConnectBlock(block)
+	}
+	if ntmgr.RpcServer != nil {
+		ntmgr.RpcServer.NotifyBlockConnected(block)
+	}
+	if ntmgr.CPUMiner != nil {
+		ntmgr.CPUMiner.NotifyBlockConnected()
+	}
+}
+
+// NotifyBlockDisconnected notifies the wallet that the passed block has been
+// disconnected.
+func (ntmgr *NotifyMgr) NotifyBlockDisconnected(block *types.SerializedBlock) {
+	if ntmgr.AcctManager != nil {
+		ntmgr.AcctManager.DisconnectBlock(block)
+	}
+}
+
+// NotifyReorganization notifies the websocket clients that the DAG order of
+// the blocks has changed.
+func (ntmgr *NotifyMgr) NotifyReorganization(rd *blockchain.ReorganizationNotifyData) {
+	if ntmgr.RpcServer == nil {
+		return
+	}
+	ntfn := &json.ReorganizationNtfn{
+		Block:    rd.NewHash.String(),
+		Detached: make([]json.BlockOrderResult, len(rd.Detached)),
+		Attached: make([]json.BlockOrderResult, len(rd.Attached)),
+	}
+	for i, bo := range rd.Detached {
+		ntfn.Detached[i] = json.BlockOrderResult{Hash: bo.Hash.String(), Order: bo.Order}
+	}
+	for i, bo := range rd.Attached {
+		ntfn.Attached[i] = json.BlockOrderResult{Hash: bo.Hash.String(), Order: bo.Order}
+	}
+	ntmgr.RpcServer.NotifyReorganization(ntfn)
+}
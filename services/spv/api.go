@@ -0,0 +1,100 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package spv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/common/marshal"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/rpc"
+)
+
+func (m *SyncManager) API() rpc.API {
+	return rpc.API{
+		NameSpace: rpc.DefaultServiceNameSpace,
+		Service:   NewPublicLightAPI(m),
+		Public:    true,
+	}
+}
+
+// PublicLightAPI is the reduced set of the RPCs served by a light node from
+// the headers it synced.
+type PublicLightAPI struct {
+	sm *SyncManager
+}
+
+func NewPublicLightAPI(sm *SyncManager) *PublicLightAPI {
+	return &PublicLightAPI{sm}
+}
+
+// Return the number of blocks in DAG order, which is the main order plus one
+func (api *PublicLightAPI) GetBlockCount() (interface{}, error) {
+	gs := api.sm.GraphState()
+	return gs.GetMainOrder() + 1, nil
+}
+
+// GetBlockHeader implements the getblockheader command.
+func (api *PublicLightAPI) GetBlockHeader(hash hash.Hash, verbose bool) (interface{}, error) {
+	headers := api.sm.Headers()
+	blockHeader, err := headers.HeaderByHash(&hash)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), fmt.Sprintf("Block not found: %v", hash))
+	}
+	if blockHeader == nil {
+		return nil, rpc.RpcInternalError(fmt.Errorf("no block").Error(), fmt.Sprintf("Block not found: %v", hash))
+	}
+
+	// When the verbose flag isn't set, simply return the serialized block
+	// header as a hex-encoded string.
+	if !verbose {
+		var headerBuf bytes.Buffer
+		err := blockHeader.Serialize(&headerBuf)
+		if err != nil {
+			context := "Failed to serialize block header"
+			return nil, rpc.RpcInternalError(err.Error(), context)
+		}
+		return hex.EncodeToString(headerBuf.Bytes()), nil
+	}
+	blockHeaderReply := json.GetBlockHeaderVerboseResult{
+		Hash:          hash.String(),
+		Confirmations: int64(headers.Confirmations(&hash)),
+		Version:       int32(blockHeader.Version),
+		ParentRoot:    blockHeader.ParentRoot.String(),
+		TxRoot:        blockHeader.TxRoot.String(),
+		StateRoot:     blockHeader.StateRoot.String(),
+		Difficulty:    blockHeader.Difficulty,
+		Layer:         uint32(headers.Layer(&hash)),
+		Time:          blockHeader.Timestamp.Unix(),
+		Nonce:         blockHeader.Nonce,
+	}
+	return blockHeaderReply, nil
+}
+
+// Return the transaction with the hash from the block with the hash.  Since a
+// light node has no transaction index the block is required, and the
+// transaction is fetched from a full node along with the merkle proof of its
+// inclusion in the block, which is verified against the synced header.
+func (api *PublicLightAPI) GetRawTransaction(txHash hash.Hash, verbose bool, blockHash *hash.Hash) (interface{}, error) {
+	if blockHash == nil {
+		return nil, rpc.RpcInvalidError("the hash of the block of the " +
+			"transaction is required by a light node")
+	}
+	tx, err := api.sm.FetchTransaction(&txHash, blockHash)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(),
+			fmt.Sprintf("Failed to fetch transaction %v", txHash))
+	}
+	if tx == nil {
+		return nil, rpc.RpcNoTxInfoError(&txHash)
+	}
+	if !verbose {
+		return marshal.MessageToHex(&message.MsgTx{Tx: tx.Transaction()})
+	}
+	confirmations := int64(api.sm.Headers().Confirmations(blockHash))
+	return marshal.MarshalJsonTransaction(tx.Transaction(), api.sm.Params(),
+		blockHash.String(), confirmations)
+}
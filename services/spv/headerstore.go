@@ -0,0 +1,443 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/consensus/pow"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/merkle"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/params"
+	"sync"
+	"time"
+)
+
+var (
+	// headerBucketName is the name of the db bucket used to house the
+	// mapping of block hashes to the serialized headers and parents.
+	headerBucketName = []byte("spvheaders")
+
+	// headerIDBucketName is the name of the db bucket used to house the
+	// mapping of DAG ids to block hashes, which is the order the headers
+	// were added to the DAG in.
+	headerIDBucketName = []byte("spvheaderids")
+)
+
+// headerNode houses a block header along with the parents of the block, which
+// is what is needed to place the block in the DAG.
+type headerNode struct {
+	hash    hash.Hash
+	header  types.BlockHeader
+	parents []*hash.Hash
+}
+
+// GetHash returns the hash of the block.
+//
+// This is part of the blockdag.IBlockData interface implementation.
+func (node *headerNode) GetHash() *hash.Hash {
+	return &node.hash
+}
+
+// GetParents returns the parents of the block.
+//
+// This is part of the blockdag.IBlockData interface implementation.
+func (node *headerNode) GetParents() []*hash.Hash {
+	return node.parents
+}
+
+// GetTimestamp returns the timestamp of the block.
+//
+// This is part of the blockdag.IBlockData interface implementation.
+func (node *headerNode) GetTimestamp() int64 {
+	return node.header.Timestamp.Unix()
+}
+
+// dagHeaderNode adapts a header of the store to the blockchain.HeaderNode
+// interface, so the headers are checked against the difficulty retarget and
+// past median time rules of the chain.
+type dagHeaderNode struct {
+	hs   *HeaderStore
+	node *headerNode
+}
+
+func (n dagHeaderNode) Bits() uint32     { return n.node.header.Difficulty }
+func (n dagHeaderNode) Timestamp() int64 { return n.node.header.Timestamp.Unix() }
+
+func (n dagHeaderNode) Order() uint {
+	return n.hs.bd.GetBlock(&n.node.hash).GetOrder()
+}
+
+func (n dagHeaderNode) Height() uint {
+	return n.hs.bd.GetBlock(&n.node.hash).GetHeight()
+}
+
+func (n dagHeaderNode) MainParent() blockchain.HeaderNode {
+	mainParent := n.hs.bd.GetBlock(&n.node.hash).GetMainParent()
+	if mainParent == nil {
+		return nil
+	}
+	return n.hs.headerNode(mainParent)
+}
+
+// serialize returns the serialized header followed by the parents of the node.
+func (node *headerNode) serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	err := node.header.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+	err = s.WriteVarInt(&buf, 0, uint64(len(node.parents)))
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range node.parents {
+		err = s.WriteElements(&buf, h)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// deserializeHeaderNode decodes a node serialized by serialize.
+func deserializeHeaderNode(serialized []byte) (*headerNode, error) {
+	r := bytes.NewReader(serialized)
+	node := &headerNode{}
+	err := node.header.Deserialize(r)
+	if err != nil {
+		return nil, err
+	}
+	count, err := s.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if count > types.MaxParentsPerBlock {
+		return nil, fmt.Errorf("too many parents %d", count)
+	}
+	node.parents = make([]*hash.Hash, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var h hash.Hash
+		err = s.ReadElements(r, &h)
+		if err != nil {
+			return nil, err
+		}
+		node.parents = append(node.parents, &h)
+	}
+	node.hash = node.header.BlockHash()
+	return node, nil
+}
+
+// idKey returns the key of the passed DAG id in the bucket of the ids.
+func idKey(id uint) []byte {
+	var key [4]byte
+	binary.BigEndian.PutUint32(key[:], uint32(id))
+	return key[:]
+}
+
+// HeaderStore validates and houses the block headers synced by a light node.
+// The headers are kept in the database and placed in an in-memory block DAG
+// in order to track the graph state without the block bodies.
+type HeaderStore struct {
+	mtx        sync.RWMutex
+	db         database.DB
+	params     *params.Params
+	pow        blockchain.PowVerifier
+	timeSource blockchain.MedianTimeSource
+	bd         *blockdag.BlockDAG
+
+	// nodes houses the headers of the DAG, which the difficulty and the
+	// timestamps of the new headers are checked against.
+	nodes map[hash.Hash]*headerNode
+}
+
+// NewHeaderStore returns a header store which loads the headers already
+// synced from the database, or stores the genesis header when there are none.
+func NewHeaderStore(db database.DB, par *params.Params,
+	timeSource blockchain.MedianTimeSource, dagType string) (*HeaderStore, error) {
+	hs := HeaderStore{
+		db:         db,
+		params:     par,
+		pow:        pow.New(par),
+		timeSource: timeSource,
+		bd:         &blockdag.BlockDAG{},
+		nodes:      make(map[hash.Hash]*headerNode),
+	}
+	hs.bd.Init(dagType)
+	err := hs.load()
+	if err != nil {
+		return nil, err
+	}
+	return &hs, nil
+}
+
+// load adds the headers of the database to the DAG in the order they were
+// added originally, so every header gets the same DAG id again.
+func (hs *HeaderStore) load() error {
+	return hs.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		if meta.Bucket(headerBucketName) == nil {
+			_, err := meta.CreateBucket(headerBucketName)
+			if err != nil {
+				return err
+			}
+			_, err = meta.CreateBucket(headerIDBucketName)
+			if err != nil {
+				return err
+			}
+			genesis := hs.params.GenesisBlock
+			node := &headerNode{
+				hash:    genesis.BlockHash(),
+				header:  genesis.Header,
+				parents: genesis.Parents,
+			}
+			if hs.bd.AddBlock(node) == nil {
+				return fmt.Errorf("failed to add genesis %v to "+
+					"the DAG", node.hash)
+			}
+			hs.nodes[node.hash] = node
+			return hs.storeNode(dbTx, node)
+		}
+
+		headerBucket := meta.Bucket(headerBucketName)
+		idBucket := meta.Bucket(headerIDBucketName)
+		for id := uint(0); ; id++ {
+			h := idBucket.Get(idKey(id))
+			if h == nil {
+				break
+			}
+			serialized := headerBucket.Get(h)
+			if serialized == nil {
+				return database.Error{
+					ErrorCode: database.ErrCorruption,
+					Description: fmt.Sprintf("missing header "+
+						"of DAG id %d", id),
+				}
+			}
+			node, err := deserializeHeaderNode(serialized)
+			if err != nil {
+				return err
+			}
+			if hs.bd.AddBlock(node) == nil {
+				return fmt.Errorf("failed to add header %v to "+
+					"the DAG", node.hash)
+			}
+			hs.nodes[node.hash] = node
+		}
+		log.Info("Loaded headers", "total", hs.bd.GetBlockTotal())
+		return nil
+	})
+}
+
+// storeNode stores the passed node which has been added to the DAG.
+func (hs *HeaderStore) storeNode(dbTx database.Tx, node *headerNode) error {
+	serialized, err := node.serialize()
+	if err != nil {
+		return err
+	}
+	meta := dbTx.Metadata()
+	err = meta.Bucket(headerBucketName).Put(node.hash[:], serialized)
+	if err != nil {
+		return err
+	}
+	id := hs.bd.GetBlock(&node.hash).GetID()
+	return meta.Bucket(headerIDBucketName).Put(idKey(id), node.hash[:])
+}
+
+// headerNode returns the header node of the block with the passed hash, or nil
+// when it is not known.
+//
+// This function MUST be called with the header store lock held.
+func (hs *HeaderStore) headerNode(h *hash.Hash) blockchain.HeaderNode {
+	node, ok := hs.nodes[*h]
+	if !ok {
+		return nil
+	}
+	return dagHeaderNode{hs, node}
+}
+
+// ruleError creates a blockchain.RuleError given a set of arguments.
+func ruleError(c blockchain.ErrorCode, format string, a ...interface{}) error {
+	return blockchain.RuleError{ErrorCode: c, Description: fmt.Sprintf(format, a...)}
+}
+
+// AddHeader validates the passed header and parents of a block and adds it to
+// the DAG.  Only the rules which can be checked without the block body are
+// enforced, namely the proof of work and the difficulty expected from the main
+// parents of the block, the commitment of the header to the parents, the
+// timestamp which has to be after the past median time, and the layer gap of
+// the parents.  The returned flag
+// indicates whether the block is an orphan because some of its parents are
+// not known yet, in which case it is not added.
+//
+// This function is safe for concurrent access.
+func (hs *HeaderStore) AddHeader(header *types.BlockHeader, parents []*hash.Hash) (bool, error) {
+	hs.mtx.Lock()
+	defer hs.mtx.Unlock()
+
+	node := &headerNode{
+		hash:    header.BlockHash(),
+		header:  *header,
+		parents: parents,
+	}
+	if hs.bd.HasBlock(&node.hash) {
+		return false, ruleError(blockchain.ErrDuplicateBlock,
+			"already have header %v", node.hash)
+	}
+
+	if len(parents) == 0 {
+		return false, ruleError(blockchain.ErrNoParents,
+			"header %v has no parents", node.hash)
+	}
+	if len(parents) > types.MaxParentsPerBlock {
+		return false, ruleError(blockchain.ErrBlockTooBig,
+			"header %v has too many parents - got %d, max %d",
+			node.hash, len(parents), types.MaxParentsPerBlock)
+	}
+	paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
+	paMerkleRoot := paMerkles[len(paMerkles)-1]
+	if !header.ParentRoot.IsEqual(paMerkleRoot) {
+		return false, ruleError(blockchain.ErrBadParentsMerkleRoot,
+			"parents merkle root is invalid - header indicates %v, "+
+				"but calculated value is %v", &header.ParentRoot,
+			paMerkleRoot)
+	}
+
+	// The proof of work engines verify the header on its own, so there is
+	// no chain to pass.
+	err := hs.pow.Verify(nil, header)
+	if err != nil {
+		return false, err
+	}
+
+	if !header.Timestamp.Equal(time.Unix(header.Timestamp.Unix(), 0)) {
+		return false, ruleError(blockchain.ErrInvalidTime,
+			"header timestamp of %v has a higher precision than "+
+				"one second", header.Timestamp)
+	}
+	maxTimestamp := hs.timeSource.AdjustedTime().Add(time.Second *
+		blockchain.MaxTimeOffsetSeconds)
+	if header.Timestamp.After(maxTimestamp) {
+		return false, ruleError(blockchain.ErrTimeTooNew,
+			"header timestamp of %v is too far in the future",
+			header.Timestamp)
+	}
+
+	if !hs.bd.HasBlocks(parents) {
+		return true, nil
+	}
+	err = hs.bd.CheckLayerGap(parents)
+	if err != nil {
+		return false, err
+	}
+
+	// Ensure the difficulty and the timestamp of the header match the chain
+	// of main parents it extends, the way the chain checks the context of
+	// the block headers.
+	parentsSet := blockdag.NewHashSet()
+	parentsSet.AddList(parents)
+	mainParent := hs.bd.GetMainParent(parentsSet)
+	if mainParent == nil {
+		return false, fmt.Errorf("no main parent for header %v", node.hash)
+	}
+	prevNode := hs.headerNode(mainParent.GetHash())
+	expDiff := blockchain.NextRequiredDifficulty(hs.params, prevNode,
+		header.Timestamp)
+	if header.Difficulty != expDiff {
+		return false, ruleError(blockchain.ErrUnexpectedDifficulty,
+			"header difficulty of %d is not the expected value of %d",
+			header.Difficulty, expDiff)
+	}
+	medianTime := blockchain.PastMedianTime(prevNode)
+	if !header.Timestamp.After(medianTime) {
+		return false, ruleError(blockchain.ErrTimeTooOld,
+			"header timestamp of %v is not after expected %v",
+			header.Timestamp.Unix(), medianTime.Unix())
+	}
+
+	if hs.bd.AddBlock(node) == nil {
+		return false, fmt.Errorf("failed to add header %v to the DAG",
+			node.hash)
+	}
+	hs.nodes[node.hash] = node
+
+	return false, hs.db.Update(func(dbTx database.Tx) error {
+		return hs.storeNode(dbTx, node)
+	})
+}
+
+// HeaderByHash returns the header of the block with the passed hash, or nil
+// when it is not known.
+//
+// This function is safe for concurrent access.
+func (hs *HeaderStore) HeaderByHash(h *hash.Hash) (*types.BlockHeader, error) {
+	var node *headerNode
+	err := hs.db.View(func(dbTx database.Tx) error {
+		serialized := dbTx.Metadata().Bucket(headerBucketName).Get(h[:])
+		if serialized == nil {
+			return nil
+		}
+		var err error
+		node, err = deserializeHeaderNode(serialized)
+		return err
+	})
+	if err != nil || node == nil {
+		return nil, err
+	}
+	return &node.header, nil
+}
+
+// HaveHeader returns whether the header of the block with the passed hash is
+// known.
+//
+// This function is safe for concurrent access.
+func (hs *HeaderStore) HaveHeader(h *hash.Hash) bool {
+	hs.mtx.RLock()
+	defer hs.mtx.RUnlock()
+	return hs.bd.HasBlock(h)
+}
+
+// GraphState returns the graph state of the DAG of the headers.
+//
+// This function is safe for concurrent access.
+func (hs *HeaderStore) GraphState() *blockdag.GraphState {
+	hs.mtx.RLock()
+	defer hs.mtx.RUnlock()
+	return hs.bd.GetGraphState()
+}
+
+// Confirmations returns the number of confirmations of the block with the
+// passed hash.
+//
+// This function is safe for concurrent access.
+func (hs *HeaderStore) Confirmations(h *hash.Hash) uint {
+	hs.mtx.RLock()
+	defer hs.mtx.RUnlock()
+	return hs.bd.GetConfirmations(h)
+}
+
+// Layer returns the layer of the block with the passed hash in the DAG.
+//
+// This function is safe for concurrent access.
+func (hs *HeaderStore) Layer(h *hash.Hash) uint {
+	hs.mtx.RLock()
+	defer hs.mtx.RUnlock()
+	return hs.bd.GetLayer(h)
+}
+
+// LatestTime returns the time of the latest header in the DAG.
+//
+// This function is safe for concurrent access.
+func (hs *HeaderStore) LatestTime() time.Time {
+	hs.mtx.RLock()
+	defer hs.mtx.RUnlock()
+	return *hs.bd.GetLastTime()
+}
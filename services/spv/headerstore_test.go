@@ -0,0 +1,142 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/consensus/pow"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/merkle"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+// newTestHeaderStore returns a header store of the private network in a new
+// database along with a function removing it.
+func newTestHeaderStore(t *testing.T) (*HeaderStore, func()) {
+	dbPath, err := ioutil.TempDir("", "spv-headers")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	par := &params.PrivNetParams
+	db, err := database.Create("ffldb", dbPath, par.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("Create: %v", err)
+	}
+	hs, err := NewHeaderStore(db, par, blockchain.NewMedianTime(),
+		blockdag.GetDAGTypeByIndex(0))
+	if err != nil {
+		db.Close()
+		os.RemoveAll(dbPath)
+		t.Fatalf("NewHeaderStore: %v", err)
+	}
+	return hs, func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+}
+
+// solveHeader returns a header extending the passed parent with the passed
+// difficulty and timestamp whose proof of work is valid.
+func solveHeader(t *testing.T, parent *hash.Hash, difficulty uint32, timestamp time.Time) *types.BlockHeader {
+	paMerkles := merkle.BuildParentsMerkleTreeStore([]*hash.Hash{parent})
+	header := &types.BlockHeader{
+		Version:    1,
+		ParentRoot: *paMerkles[len(paMerkles)-1],
+		Timestamp:  timestamp,
+		Difficulty: difficulty,
+	}
+	engine := pow.New(&params.PrivNetParams)
+	for ; header.Nonce < 1<<16; header.Nonce++ {
+		if engine.Verify(nil, header) == nil {
+			return header
+		}
+	}
+	t.Fatalf("unable to solve header")
+	return nil
+}
+
+// TestAddHeaderContext ensures the headers are only added when their difficulty
+// is the one expected from their main parents and their timestamp is after the
+// past median time.
+func TestAddHeaderContext(t *testing.T) {
+	hs, teardown := newTestHeaderStore(t)
+	defer teardown()
+
+	genesis := hs.params.GenesisBlock.Header
+	genesisHash := genesis.BlockHash()
+	parents := []*hash.Hash{&genesisHash}
+
+	tests := []struct {
+		name       string
+		difficulty uint32
+		timestamp  time.Time
+		code       blockchain.ErrorCode
+		ok         bool
+	}{
+		{
+			name:       "harder difficulty",
+			difficulty: 0x2007ffff,
+			timestamp:  genesis.Timestamp.Add(time.Second * 30),
+			code:       blockchain.ErrUnexpectedDifficulty,
+		},
+		{
+			name:       "timestamp of the median time",
+			difficulty: genesis.Difficulty,
+			timestamp:  genesis.Timestamp,
+			code:       blockchain.ErrTimeTooOld,
+		},
+		{
+			name:       "timestamp before the median time",
+			difficulty: genesis.Difficulty,
+			timestamp:  genesis.Timestamp.Add(-time.Second),
+			code:       blockchain.ErrTimeTooOld,
+		},
+		{
+			name:       "valid header",
+			difficulty: genesis.Difficulty,
+			timestamp:  genesis.Timestamp.Add(time.Second * 30),
+			ok:         true,
+		},
+	}
+
+	for _, test := range tests {
+		header := solveHeader(t, &genesisHash, test.difficulty,
+			test.timestamp)
+		orphan, err := hs.AddHeader(header, parents)
+		if orphan {
+			t.Errorf("%s: header is an orphan", test.name)
+			continue
+		}
+		h := header.BlockHash()
+		if test.ok {
+			if err != nil {
+				t.Errorf("%s: AddHeader: %v", test.name, err)
+			}
+			if !hs.HaveHeader(&h) {
+				t.Errorf("%s: header was not added", test.name)
+			}
+			continue
+		}
+		rerr, ok := err.(blockchain.RuleError)
+		if !ok || rerr.ErrorCode != test.code {
+			t.Errorf("%s: AddHeader got %v, want %v", test.name, err,
+				test.code)
+			continue
+		}
+		if hs.HaveHeader(&h) {
+			t.Errorf("%s: rejected header was added", test.name)
+		}
+	}
+}
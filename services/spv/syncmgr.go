@@ -0,0 +1,673 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer-lib/core/protocol"
+	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/p2p/peer"
+	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/services/bloom"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// FetchTxTimeout is the time after which a request for a transaction
+	// and its inclusion proof is abandoned.
+	FetchTxTimeout = 30 * time.Second
+
+	// proofFilterFPRate is the false positive rate of the filters loaded
+	// to fetch transactions.
+	proofFilterFPRate = 0.0001
+)
+
+// syncServices are the services a peer must offer to sync the headers from it
+// and to fetch transactions with their inclusion proofs.
+const syncServices = protocol.Full | protocol.Bloom
+
+// txRequest houses a pending request for a transaction along with the proof
+// of its inclusion in a block.
+type txRequest struct {
+	txHash    hash.Hash
+	blockHash hash.Hash
+	peer      *peer.ServerPeer
+	proven    bool
+	expiry    time.Time
+	reply     chan fetchTxResponse
+}
+
+// SyncManager syncs and validates the block headers from the full nodes it is
+// connected to, and fetches the transactions with the proofs of their
+// inclusion in the blocks on demand.  This is what a light node runs instead
+// of a block manager.
+type SyncManager struct {
+	started  int32
+	shutdown int32
+
+	params  *params.Params
+	headers *HeaderStore
+
+	// noMatchFilter is loaded by every sync peer, so the merkle blocks
+	// they send carry the headers without any transactions.
+	noMatchFilter *message.MsgFilterLoad
+
+	peers           map[*peer.Peer]*peer.ServerPeer
+	syncPeer        *peer.ServerPeer
+	requestedBlocks map[hash.Hash]struct{}
+	txRequests      map[hash.Hash][]*txRequest
+	msgChan         chan interface{}
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// NewSyncManager returns a new sync manager which stores the headers in the
+// passed database.  Use Start to begin syncing.
+func NewSyncManager(db database.DB, timeSource blockchain.MedianTimeSource,
+	cfg *config.Config, par *params.Params) (*SyncManager, error) {
+	headers, err := NewHeaderStore(db, par, timeSource, cfg.DAGType)
+	if err != nil {
+		return nil, err
+	}
+	filter := bloom.NewFilter(1, 0, proofFilterFPRate,
+		message.BloomUpdateNone)
+	m := SyncManager{
+		params:          par,
+		headers:         headers,
+		noMatchFilter:   filter.MsgFilterLoad(),
+		peers:           make(map[*peer.Peer]*peer.ServerPeer),
+		requestedBlocks: make(map[hash.Hash]struct{}),
+		txRequests:      make(map[hash.Hash][]*txRequest),
+		msgChan:         make(chan interface{}, cfg.MaxPeers*3),
+		quit:            make(chan struct{}),
+	}
+	return &m, nil
+}
+
+// Headers returns the store of the headers synced by the manager.
+func (m *SyncManager) Headers() *HeaderStore {
+	return m.headers
+}
+
+// Params returns the parameters of the network the manager syncs.
+func (m *SyncManager) Params() *params.Params {
+	return m.params
+}
+
+// GraphState returns the graph state of the headers synced so far.
+//
+// This function is safe for concurrent access.
+func (m *SyncManager) GraphState() *blockdag.GraphState {
+	return m.headers.GraphState()
+}
+
+// Start begins the handler which syncs the headers and serves the requests
+// for transactions.
+func (m *SyncManager) Start() {
+	// Already started?
+	if atomic.AddInt32(&m.started, 1) != 1 {
+		return
+	}
+
+	log.Trace("Starting sync manager")
+	m.wg.Add(1)
+	go m.syncHandler()
+}
+
+// Stop gracefully shuts down the sync manager.
+func (m *SyncManager) Stop() error {
+	if atomic.AddInt32(&m.shutdown, 1) != 1 {
+		log.Warn("Sync manager is already in the process of " +
+			"shutting down")
+		return nil
+	}
+	log.Info("Sync manager shutting down")
+	close(m.quit)
+	return nil
+}
+
+// WaitForStop blocks until the sync manager has stopped.
+func (m *SyncManager) WaitForStop() {
+	log.Info("Wait For sync manager stop ...")
+	m.wg.Wait()
+	log.Info("Sync manager stopped")
+}
+
+// syncHandler is the main handler for the sync manager.  It must be run as a
+// goroutine.  It processes the messages of the peers in a single goroutine so
+// there is no need for locking.
+func (m *SyncManager) syncHandler() {
+	expiryTicker := time.NewTicker(FetchTxTimeout)
+	defer expiryTicker.Stop()
+
+out:
+	for {
+		select {
+		case msg := <-m.msgChan:
+			switch msg := msg.(type) {
+			case *newPeerMsg:
+				m.handleNewPeerMsg(msg.peer)
+			case *donePeerMsg:
+				m.handleDonePeerMsg(msg.peer)
+			case *invMsg:
+				m.handleInvMsg(msg)
+			case *merkleBlockMsg:
+				m.handleMerkleBlockMsg(msg)
+			case *txMsg:
+				m.handleTxMsg(msg)
+			case *fetchTxMsg:
+				m.handleFetchTxMsg(msg)
+			case isCurrentMsg:
+				msg.reply <- m.current()
+			default:
+				log.Warn(fmt.Sprintf("Invalid message type in sync "+
+					"handler: %T", msg))
+			}
+
+		case <-expiryTicker.C:
+			m.expireTxRequests()
+
+		case <-m.quit:
+			break out
+		}
+	}
+
+	m.wg.Done()
+	log.Trace("Sync manager done")
+}
+
+// isSyncCandidate returns whether or not the peer is a candidate to consider
+// syncing from.
+func (m *SyncManager) isSyncCandidate(sp *peer.ServerPeer) bool {
+	return protocol.HasServices(sp.Services(), syncServices)
+}
+
+// handleNewPeerMsg deals with new peers that have signalled they may be
+// considered as a sync peer.  It loads the filter which matches nothing on the
+// peer so it can be asked for merkle blocks, and starts syncing if needed.
+func (m *SyncManager) handleNewPeerMsg(sp *peer.ServerPeer) {
+	log.Info(fmt.Sprintf("New valid peer: %s,user-agent:%s", sp, sp.UserAgent()))
+
+	sp.SyncCandidate = m.isSyncCandidate(sp)
+	m.peers[sp.Peer] = sp
+	if !sp.SyncCandidate {
+		return
+	}
+	sp.QueueMessage(m.noMatchFilter, nil)
+
+	if m.syncPeer == nil {
+		m.startSync()
+	}
+}
+
+// handleDonePeerMsg removes the peer which has disconnected and fails its
+// pending requests.  It selects a new sync peer when it was the sync peer.
+func (m *SyncManager) handleDonePeerMsg(sp *peer.ServerPeer) {
+	if _, exists := m.peers[sp.Peer]; !exists {
+		log.Warn(fmt.Sprintf("Received done peer message for unknown peer %s", sp))
+		return
+	}
+	delete(m.peers, sp.Peer)
+	log.Info("Lost peer", "peer", sp)
+
+	for h := range sp.RequestedBlocks {
+		delete(m.requestedBlocks, h)
+	}
+	m.removeTxRequests(func(r *txRequest) bool {
+		return r.peer == sp
+	}, errors.New("peer disconnected"))
+
+	if m.syncPeer == sp {
+		m.syncPeer = nil
+		m.startSync()
+	}
+}
+
+// startSync chooses the most updated candidate peer which is ahead of the
+// headers synced so far and requests the blocks from it.
+func (m *SyncManager) startSync() {
+	if m.syncPeer != nil {
+		return
+	}
+
+	gs := m.headers.GraphState()
+	var bestPeer *peer.ServerPeer
+	for _, sp := range m.peers {
+		if !sp.SyncCandidate {
+			continue
+		}
+		if !sp.LastGS().IsExcellent(gs) {
+			continue
+		}
+		if bestPeer == nil || sp.LastGS().IsExcellent(bestPeer.LastGS()) {
+			bestPeer = sp
+		}
+	}
+	if bestPeer == nil {
+		log.Debug("No sync peer candidates available")
+		return
+	}
+
+	log.Info(fmt.Sprintf("Syncing headers to state %s from peer %s cur "+
+		"graph state:%s", bestPeer.LastGS().String(), bestPeer.Addr(),
+		gs.String()))
+	err := bestPeer.PushGetBlocksMsg(gs, nil)
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to push getblocksmsg for the "+
+			"latest GS: %v", err))
+		return
+	}
+	m.syncPeer = bestPeer
+}
+
+// current returns whether the headers are believed to be synced with the
+// peers.
+func (m *SyncManager) current() bool {
+	if m.syncPeer == nil {
+		return true
+	}
+	return !m.syncPeer.LastGS().IsExcellent(m.headers.GraphState())
+}
+
+// handleInvMsg requests the merkle blocks of the advertised blocks which are
+// not known yet.  The transactions are ignored since a light node has no
+// mempool.
+func (m *SyncManager) handleInvMsg(imsg *invMsg) {
+	sp, exists := m.peers[imsg.peer.Peer]
+	if !exists {
+		log.Warn(fmt.Sprintf("Received inv message from unknown peer %s",
+			imsg.peer))
+		return
+	}
+	if !sp.SyncCandidate {
+		return
+	}
+
+	gdmsg := message.NewMsgGetData()
+	haveBlocks := false
+	for _, iv := range imsg.inv.InvList {
+		if iv.Type != message.InvTypeBlock {
+			continue
+		}
+		haveBlocks = true
+		sp.AddKnownInventory(iv)
+		if m.headers.HaveHeader(&iv.Hash) {
+			continue
+		}
+		if _, exists := m.requestedBlocks[iv.Hash]; exists {
+			continue
+		}
+		m.requestedBlocks[iv.Hash] = struct{}{}
+		sp.RequestedBlocks[iv.Hash] = struct{}{}
+		gdmsg.AddInvVect(message.NewInvVect(message.InvTypeFilteredBlock,
+			&iv.Hash))
+		if len(gdmsg.InvList) >= message.MaxInvPerMsg {
+			break
+		}
+	}
+	if !haveBlocks {
+		return
+	}
+	sp.UpdateLastGS(imsg.inv.GS)
+
+	if len(gdmsg.InvList) > 0 {
+		sp.QueueMessage(gdmsg, nil)
+		return
+	}
+
+	// All the advertised blocks are known already, so ask for the next
+	// ones when the peer is still ahead.
+	if m.syncPeer == nil {
+		m.startSync()
+	} else if sp == m.syncPeer {
+		m.continueSync()
+	}
+}
+
+// continueSync requests the next blocks from the sync peer once all the blocks
+// requested from it have arrived, or finishes the sync when it is not ahead
+// anymore.
+func (m *SyncManager) continueSync() {
+	if len(m.syncPeer.RequestedBlocks) > 0 {
+		return
+	}
+	gs := m.headers.GraphState()
+	if !m.syncPeer.LastGS().IsExcellent(gs) {
+		log.Info(fmt.Sprintf("Headers synced to state %s", gs.String()))
+		m.syncPeer = nil
+		return
+	}
+	err := m.syncPeer.PushGetBlocksMsg(gs, nil)
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to push getblocksmsg for the "+
+			"latest GS: %v", err))
+	}
+}
+
+// handleMerkleBlockMsg adds the header of the merkle block to the header store
+// and checks the proofs of the transactions requested from the block.
+func (m *SyncManager) handleMerkleBlockMsg(bmsg *merkleBlockMsg) {
+	sp, exists := m.peers[bmsg.peer.Peer]
+	if !exists {
+		log.Warn(fmt.Sprintf("Received merkle block message from unknown "+
+			"peer %s", bmsg.peer))
+		return
+	}
+	mb := bmsg.merkleBlock
+	blockHash := mb.Header.BlockHash()
+	delete(m.requestedBlocks, blockHash)
+	delete(sp.RequestedBlocks, blockHash)
+
+	if !m.headers.HaveHeader(&blockHash) {
+		isOrphan, err := m.headers.AddHeader(&mb.Header, mb.Parents)
+		if err != nil {
+			log.Info(fmt.Sprintf("Rejected header %v from %s: %v",
+				blockHash, sp, err))
+			sp.Disconnect()
+			return
+		}
+		if isOrphan {
+			// Ask for the blocks between the headers synced so
+			// far and the orphan.
+			log.Debug(fmt.Sprintf("Orphan header %v from %s",
+				blockHash, sp))
+			err := sp.PushGetBlocksMsg(m.headers.GraphState(), nil)
+			if err != nil {
+				log.Error(fmt.Sprintf("Failed to push "+
+					"getblocksmsg for the orphan: %v", err))
+			}
+		}
+	}
+
+	// Check the proofs of the transactions requested from the block.
+	var matches []*hash.Hash
+	var proofErr error
+	checked := false
+	for _, reqs := range m.txRequests {
+		for _, r := range reqs {
+			if r.proven || r.peer != sp || !r.blockHash.IsEqual(&blockHash) {
+				continue
+			}
+			if !checked {
+				matches, proofErr = bloom.ExtractMatches(mb)
+				checked = true
+			}
+			if proofErr != nil {
+				continue
+			}
+			for _, h := range matches {
+				if h.IsEqual(&r.txHash) {
+					r.proven = true
+					break
+				}
+			}
+		}
+	}
+	if proofErr != nil {
+		log.Info(fmt.Sprintf("Invalid merkle block %v from %s: %v",
+			blockHash, sp, proofErr))
+		m.removeTxRequests(func(r *txRequest) bool {
+			return r.peer == sp && r.blockHash.IsEqual(&blockHash)
+		}, proofErr)
+		sp.Disconnect()
+		return
+	}
+	if checked {
+		m.removeTxRequests(func(r *txRequest) bool {
+			return !r.proven && r.peer == sp &&
+				r.blockHash.IsEqual(&blockHash)
+		}, fmt.Errorf("transaction is not in block %v", blockHash))
+	}
+
+	if sp == m.syncPeer {
+		m.continueSync()
+	}
+}
+
+// handleTxMsg replies to the requests for the transaction which have the
+// proof of its inclusion in the block.
+func (m *SyncManager) handleTxMsg(tmsg *txMsg) {
+	txHash := tmsg.tx.Hash()
+	reqs := m.txRequests[*txHash]
+	remaining := reqs[:0]
+	for _, r := range reqs {
+		if r.proven && r.peer == tmsg.peer {
+			r.reply <- fetchTxResponse{tx: tmsg.tx}
+			continue
+		}
+		remaining = append(remaining, r)
+	}
+	if len(remaining) == 0 {
+		delete(m.txRequests, *txHash)
+	} else {
+		m.txRequests[*txHash] = remaining
+	}
+}
+
+// handleFetchTxMsg asks a full node for the merkle block of the block matching
+// only the requested transaction.  The filter which matches nothing is loaded
+// again afterwards.
+func (m *SyncManager) handleFetchTxMsg(fmsg *fetchTxMsg) {
+	if !m.headers.HaveHeader(&fmsg.blockHash) {
+		fmsg.reply <- fetchTxResponse{err: fmt.Errorf("block %v is "+
+			"not known", fmsg.blockHash)}
+		return
+	}
+	sp := m.syncPeer
+	if sp == nil {
+		for _, candidate := range m.peers {
+			if candidate.SyncCandidate {
+				sp = candidate
+				break
+			}
+		}
+	}
+	if sp == nil {
+		fmsg.reply <- fetchTxResponse{err: errors.New("no peers to " +
+			"fetch the transaction from")}
+		return
+	}
+
+	filter := bloom.NewFilter(1, 0, proofFilterFPRate,
+		message.BloomUpdateNone)
+	filter.AddHash(&fmsg.txHash)
+	gdmsg := message.NewMsgGetData()
+	gdmsg.AddInvVect(message.NewInvVect(message.InvTypeFilteredBlock,
+		&fmsg.blockHash))
+	sp.QueueMessage(filter.MsgFilterLoad(), nil)
+	sp.QueueMessage(gdmsg, nil)
+	sp.QueueMessage(m.noMatchFilter, nil)
+
+	m.txRequests[fmsg.txHash] = append(m.txRequests[fmsg.txHash],
+		&txRequest{
+			txHash:    fmsg.txHash,
+			blockHash: fmsg.blockHash,
+			peer:      sp,
+			expiry:    time.Now().Add(FetchTxTimeout),
+			reply:     fmsg.reply,
+		})
+}
+
+// removeTxRequests fails and removes the pending requests for which the
+// passed function returns true.
+func (m *SyncManager) removeTxRequests(match func(r *txRequest) bool, err error) {
+	for txHash, reqs := range m.txRequests {
+		remaining := reqs[:0]
+		for _, r := range reqs {
+			if match(r) {
+				r.reply <- fetchTxResponse{err: err}
+				continue
+			}
+			remaining = append(remaining, r)
+		}
+		if len(remaining) == 0 {
+			delete(m.txRequests, txHash)
+		} else {
+			m.txRequests[txHash] = remaining
+		}
+	}
+}
+
+// expireTxRequests removes the pending requests which timed out.
+func (m *SyncManager) expireTxRequests() {
+	now := time.Now()
+	m.removeTxRequests(func(r *txRequest) bool {
+		return now.After(r.expiry)
+	}, errors.New("request timed out"))
+}
+
+// newPeerMsg signifies a newly connected peer to the sync handler.
+type newPeerMsg struct {
+	peer *peer.ServerPeer
+}
+
+// NewPeer informs the sync manager of a newly active peer.
+func (m *SyncManager) NewPeer(sp *peer.ServerPeer) {
+	// Ignore if we are shutting down.
+	if atomic.LoadInt32(&m.shutdown) != 0 {
+		return
+	}
+	m.msgChan <- &newPeerMsg{peer: sp}
+}
+
+// donePeerMsg signifies a newly disconnected peer to the sync handler.
+type donePeerMsg struct {
+	peer *peer.ServerPeer
+}
+
+// DonePeer informs the sync manager that a peer has disconnected.
+func (m *SyncManager) DonePeer(sp *peer.ServerPeer) {
+	// Ignore if we are shutting down.
+	if atomic.LoadInt32(&m.shutdown) != 0 {
+		return
+	}
+	m.msgChan <- &donePeerMsg{peer: sp}
+}
+
+// invMsg packages an inv message and the peer it came from together so the
+// sync handler has access to that information.
+type invMsg struct {
+	inv  *message.MsgInv
+	peer *peer.ServerPeer
+}
+
+// QueueInv adds the passed inv message and peer to the sync handling queue.
+func (m *SyncManager) QueueInv(inv *message.MsgInv, sp *peer.ServerPeer) {
+	if atomic.LoadInt32(&m.shutdown) != 0 {
+		return
+	}
+	m.msgChan <- &invMsg{inv: inv, peer: sp}
+}
+
+// merkleBlockMsg packages a merkle block message and the peer it came from
+// together so the sync handler has access to that information.
+type merkleBlockMsg struct {
+	merkleBlock *message.MsgMerkleBlock
+	peer        *peer.ServerPeer
+}
+
+// QueueMerkleBlock adds the passed merkle block message and peer to the sync
+// handling queue.
+func (m *SyncManager) QueueMerkleBlock(mb *message.MsgMerkleBlock, sp *peer.ServerPeer) {
+	if atomic.LoadInt32(&m.shutdown) != 0 {
+		return
+	}
+	m.msgChan <- &merkleBlockMsg{merkleBlock: mb, peer: sp}
+}
+
+// txMsg packages a tx message and the peer it came from together so the sync
+// handler has access to that information.
+type txMsg struct {
+	tx   *types.Tx
+	peer *peer.ServerPeer
+}
+
+// QueueTx adds the passed transaction message and peer to the sync handling
+// queue.
+func (m *SyncManager) QueueTx(tx *types.Tx, sp *peer.ServerPeer) {
+	if atomic.LoadInt32(&m.shutdown) != 0 {
+		return
+	}
+	m.msgChan <- &txMsg{tx: tx, peer: sp}
+}
+
+// fetchTxResponse is the response sent to the reply channel of a fetchTxMsg.
+type fetchTxResponse struct {
+	tx  *types.Tx
+	err error
+}
+
+// fetchTxMsg is a message type to be sent across the message channel for
+// requesting a transaction along with the proof of its inclusion in a block.
+type fetchTxMsg struct {
+	txHash    hash.Hash
+	blockHash hash.Hash
+	reply     chan fetchTxResponse
+}
+
+// FetchTransaction fetches the transaction with the passed hash from a full
+// node and returns it once the merkle block of the passed block proves that
+// the block contains it.
+//
+// This function is safe for concurrent access.
+func (m *SyncManager) FetchTransaction(txHash *hash.Hash, blockHash *hash.Hash) (*types.Tx, error) {
+	if atomic.LoadInt32(&m.shutdown) != 0 {
+		return nil, errors.New("sync manager is shutting down")
+	}
+	// The reply channel is buffered since the handler may time out the
+	// request on its own.
+	reply := make(chan fetchTxResponse, 1)
+	select {
+	case m.msgChan <- &fetchTxMsg{txHash: *txHash, blockHash: *blockHash,
+		reply: reply}:
+	case <-m.quit:
+		return nil, errors.New("sync manager is shutting down")
+	}
+
+	select {
+	case resp := <-reply:
+		return resp.tx, resp.err
+	case <-time.After(FetchTxTimeout):
+		return nil, errors.New("request timed out")
+	case <-m.quit:
+		return nil, errors.New("sync manager is shutting down")
+	}
+}
+
+// isCurrentMsg is a message type to be sent across the message channel for
+// requesting whether or not the sync manager believes it is synced with the
+// connected peers.
+type isCurrentMsg struct {
+	reply chan bool
+}
+
+// IsCurrent returns whether or not the sync manager believes the headers are
+// synced with the connected peers.  It returns false once the sync manager is
+// stopped.
+func (m *SyncManager) IsCurrent() bool {
+	reply := make(chan bool, 1)
+	select {
+	case m.msgChan <- isCurrentMsg{reply: reply}:
+	case <-m.quit:
+		return false
+	}
+
+	select {
+	case current := <-reply:
+		return current
+	case <-m.quit:
+		return false
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+)
+
+// TestIsCurrentAfterStop ensures IsCurrent and FetchTransaction return once the
+// sync manager is stopped instead of blocking forever.
+func TestIsCurrentAfterStop(t *testing.T) {
+	hs, teardown := newTestHeaderStore(t)
+	defer teardown()
+
+	cfg := &config.Config{MaxPeers: 1, DAGType: blockdag.GetDAGTypeByIndex(0)}
+	m, err := NewSyncManager(hs.db, hs.timeSource, cfg, hs.params)
+	if err != nil {
+		t.Fatalf("NewSyncManager: %v", err)
+	}
+	m.Start()
+	m.Stop()
+	m.WaitForStop()
+
+	done := make(chan struct{})
+	go func() {
+		// Fill the message queue so the requests can't be queued.
+		for i := 0; i < cap(m.msgChan)+1; i++ {
+			if m.IsCurrent() {
+				t.Errorf("IsCurrent returned true after stop")
+			}
+		}
+		genesisHash := hs.params.GenesisBlock.BlockHash()
+		if _, err := m.FetchTransaction(&genesisHash, &genesisHash); err == nil {
+			t.Errorf("FetchTransaction succeeded after stop")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("IsCurrent blocked after stop")
+	}
+}
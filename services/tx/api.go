@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
 	"github.com/Qitmeer/qitmeer-lib/core/protocol"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer-lib/crypto/ecc"
 	"github.com/Qitmeer/qitmeer/common/marshal"
 	"github.com/Qitmeer/qitmeer/core/address"
@@ -18,7 +18,9 @@ import (
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/rpc"
+	"github.com/Qitmeer/qitmeer/services/index"
 	"github.com/Qitmeer/qitmeer/services/mempool"
+	"math"
 )
 
 func (tm *TxManager) APIs() []rpc.API {
@@ -236,8 +238,8 @@ func (api *PublicTxAPI) GetRawTransaction(txHash hash.Hash, verbose bool) (inter
 	if tx == nil {
 		//not found from mem-pool, try db
 		txIndex := api.txManager.txIndex
-		if txIndex == nil {
-			return nil, fmt.Errorf("the transaction index " +
+		if err := txIndex.Ready(); err != nil {
+			return nil, indexError(err, "the transaction index "+
 				"must be enabled to query the blockchain (specify --txindex in configuration)")
 		}
 		// Look up the location of the transaction.
@@ -428,6 +430,25 @@ func (api *PublicTxAPI) GetUtxo(txHash hash.Hash, vout uint32, includeMempool *b
 	return txOutReply, nil
 }
 
+// EstimateFee returns the fee rate in coins/kB which gets a transaction
+// confirmed within numBlocks blocks with the passed confidence, 0.85 by
+// default.  The estimate is never below the fee rate the mempool requires.
+func (api *PublicTxAPI) EstimateFee(numBlocks int, confidence *float64) (interface{}, error) {
+	conf := mempool.DefaultEstimateFeeConfidence
+	if confidence != nil {
+		conf = *confidence
+	}
+	feeRate, err := api.txManager.feeEstimator.EstimateFee(numBlocks, conf)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("Unable to estimate fee: %v", err)
+	}
+	minFeeRate := api.txManager.txMemPool.MinFeeRate()
+	if feeRate < minFeeRate {
+		feeRate = minFeeRate
+	}
+	return feeRate.ToUnit(types.AmountCoin), nil
+}
+
 func (api *PublicTxAPI) TxSign(privkeyStr string, rawTxStr string) (interface{}, error) {
 	privkeyByte, err := hex.DecodeString(privkeyStr)
 	if err != nil {
@@ -469,8 +490,8 @@ func (api *PublicTxAPI) TxSign(privkeyStr string, rawTxStr string) (interface{},
 	}
 	//
 	txIndex := api.txManager.txIndex
-	if txIndex == nil {
-		return nil, fmt.Errorf("the transaction index " +
+	if err := txIndex.Ready(); err != nil {
+		return nil, indexError(err, "the transaction index "+
 			"must be enabled to query the blockchain (specify --txindex in configuration)")
 	}
 	confirmationsM := map[hash.Hash]uint{}
@@ -537,16 +558,18 @@ func (api *PublicTxAPI) TxSign(privkeyStr string, rawTxStr string) (interface{},
 // handleSearchRawTransactions implements the searchrawtransactions command.
 func (api *PublicTxAPI) GetRawTransactions(addre string, vinext *bool, count *uint, skip *uint, revers *bool, verbose *bool, filterAddrs *[]string) (interface{}, error) {
 	addrIndex := api.txManager.addrIndex
-	if addrIndex == nil {
-		return nil, fmt.Errorf("Address index must be enabled (--addrindex)")
+	if err := addrIndex.Ready(); err != nil {
+		return nil, indexError(err, "Address index must be enabled (--addrindex)")
 	}
 	vinExtra := false
 	if vinext != nil {
 		vinExtra = *vinext
 	}
 
-	if vinExtra && api.txManager.txIndex == nil {
-		return nil, fmt.Errorf("Transaction index must be enabled (--txindex)")
+	if vinExtra {
+		if err := api.txManager.txIndex.Ready(); err != nil {
+			return nil, indexError(err, "Transaction index must be enabled (--txindex)")
+		}
 	}
 	params := api.txManager.bm.ChainParams()
 	addr, err := address.DecodeAddress(addre)
@@ -938,3 +961,272 @@ func (api *PublicTxAPI) fetchInputTxos(tx *message.MsgTx) (map[types.TxOutPoint]
 
 	return originOutputs, nil
 }
+
+// indexError returns the error of a query of an index which isn't ready.  The
+// passed message tells how to enable the index when it is disabled.
+func indexError(err error, disabledMsg string) error {
+	if err == index.ErrIndexSyncing {
+		return err
+	}
+	return errors.New(disabledMsg)
+}
+
+// addrUtxoIndexAddress returns the address utxo index along with the decoded
+// address, or an error when the index isn't ready.
+func (api *PublicTxAPI) addrUtxoIndexAddress(encodedAddr string) (*index.AddrUtxoIndex, types.Address, error) {
+	addrUtxoIndex := api.txManager.addrUtxoIndex
+	if err := addrUtxoIndex.Ready(); err != nil {
+		return nil, nil, indexError(err, "Address utxo index must be enabled (--addrutxoindex)")
+	}
+	addr, err := address.DecodeAddress(encodedAddr)
+	if err != nil {
+		return nil, nil, rpc.RpcAddressKeyError("Could not decode "+
+			"address: %v", err)
+	}
+	if !address.IsForNetwork(addr, api.txManager.bm.ChainParams()) {
+		return nil, nil, rpc.RpcAddressKeyError("Wrong network: %v",
+			addr)
+	}
+	return addrUtxoIndex, addr, nil
+}
+
+// GetAddressBalance returns the confirmed balance of the address and the total
+// amount it received in atoms, along with the change of the balance by the
+// transactions in the mempool.
+func (api *PublicTxAPI) GetAddressBalance(encodedAddr string) (interface{}, error) {
+	addrUtxoIndex, addr, err := api.addrUtxoIndexAddress(encodedAddr)
+	if err != nil {
+		return nil, err
+	}
+	balance, received, err := addrUtxoIndex.Balance(addr)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Address balance")
+	}
+	var unconfirmed int64
+	for _, delta := range addrUtxoIndex.UnconfirmedDeltas(addr) {
+		unconfirmed += delta.Amount
+	}
+	return json.GetAddressBalanceResult{
+		Balance:     balance,
+		Received:    received,
+		Unconfirmed: unconfirmed,
+	}, nil
+}
+
+// GetAddressUtxos returns the unspent outputs paying to the address.  When
+// includeMempool is true, which is the default, the outputs spent by the
+// transactions in the mempool are left out and the outputs they create are
+// added.
+func (api *PublicTxAPI) GetAddressUtxos(encodedAddr string, includeMempool *bool) (interface{}, error) {
+	addrUtxoIndex, addr, err := api.addrUtxoIndexAddress(encodedAddr)
+	if err != nil {
+		return nil, err
+	}
+	includeMempoolTx := true
+	if includeMempool != nil {
+		includeMempoolTx = *includeMempool
+	}
+
+	utxos, err := addrUtxoIndex.UnspentOutputs(addr)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Address utxos")
+	}
+	txMemPool := api.txManager.txMemPool
+	bd := api.txManager.bm.GetChain().BlockDAG()
+	result := make([]json.AddressUtxoResult, 0, len(utxos))
+	for _, utxo := range utxos {
+		if includeMempoolTx && txMemPool.CheckSpend(utxo.OutPoint) != nil {
+			continue
+		}
+		result = append(result, json.AddressUtxoResult{
+			Txid:          utxo.OutPoint.Hash.String(),
+			Vout:          utxo.OutPoint.OutIndex,
+			Amount:        utxo.Amount,
+			ScriptPubKey:  hex.EncodeToString(utxo.PkScript),
+			BlockHash:     utxo.BlockHash.String(),
+			Confirmations: int64(bd.GetConfirmations(&utxo.BlockHash)),
+			Coinbase:      utxo.IsCoinBase,
+		})
+	}
+	if !includeMempoolTx {
+		return result, nil
+	}
+
+	for _, delta := range addrUtxoIndex.UnconfirmedDeltas(addr) {
+		if delta.IsInput {
+			continue
+		}
+		outPoint := types.TxOutPoint{Hash: delta.TxHash, OutIndex: delta.Index}
+		if txMemPool.CheckSpend(outPoint) != nil {
+			continue
+		}
+		tx, err := txMemPool.FetchTransaction(&delta.TxHash)
+		if err != nil {
+			// The transaction left the mempool meanwhile.
+			continue
+		}
+		result = append(result, json.AddressUtxoResult{
+			Txid:         delta.TxHash.String(),
+			Vout:         delta.Index,
+			Amount:       uint64(delta.Amount),
+			ScriptPubKey: hex.EncodeToString(tx.Tx.TxOut[delta.Index].PkScript),
+		})
+	}
+	return result, nil
+}
+
+// GetAddressDeltas returns the changes of the balance of the address by the
+// blocks with a DAG order in the range [start, end], ordered by DAG order.  The
+// whole DAG is covered by default.  When includeMempool is true the changes
+// by the transactions in the mempool are appended.
+func (api *PublicTxAPI) GetAddressDeltas(encodedAddr string, start *uint, end *uint, includeMempool *bool) (interface{}, error) {
+	addrUtxoIndex, addr, err := api.addrUtxoIndexAddress(encodedAddr)
+	if err != nil {
+		return nil, err
+	}
+	startOrder, endOrder := uint32(0), uint32(math.MaxUint32)
+	if start != nil {
+		startOrder = uint32(*start)
+	}
+	if end != nil {
+		endOrder = uint32(*end)
+	}
+	if startOrder > endOrder {
+		return nil, rpc.RpcInvalidError("Start order %d is greater "+
+			"than end order %d", startOrder, endOrder)
+	}
+
+	deltas, err := addrUtxoIndex.Deltas(addr, startOrder, endOrder)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Address deltas")
+	}
+	bd := api.txManager.bm.GetChain().BlockDAG()
+	result := make([]json.AddressDeltaResult, 0, len(deltas))
+	for _, delta := range deltas {
+		var blockHash string
+		if h := bd.GetBlockByOrder(uint(delta.Order)); h != nil {
+			blockHash = h.String()
+		}
+		result = append(result, json.AddressDeltaResult{
+			Txid:       delta.TxHash.String(),
+			Index:      delta.Index,
+			Input:      delta.IsInput,
+			Amount:     delta.Amount,
+			BlockHash:  blockHash,
+			BlockOrder: uint64(delta.Order),
+		})
+	}
+	if includeMempool != nil && *includeMempool {
+		for _, delta := range addrUtxoIndex.UnconfirmedDeltas(addr) {
+			result = append(result, json.AddressDeltaResult{
+				Txid:   delta.TxHash.String(),
+				Index:  delta.Index,
+				Input:  delta.IsInput,
+				Amount: delta.Amount,
+			})
+		}
+	}
+	return result, nil
+}
+
+// GetSpendingTx returns the transaction and the input spending the output vout
+// of the transaction txHash, or nothing when the output is unspent.  The
+// transactions in the mempool are searched first.
+func (api *PublicTxAPI) GetSpendingTx(txHash hash.Hash, vout uint32) (interface{}, error) {
+	spentIndex := api.txManager.spentIndex
+	if err := spentIndex.Ready(); err != nil {
+		return nil, indexError(err, "Spent index must be enabled (--spentindex)")
+	}
+
+	outPoint := types.TxOutPoint{Hash: txHash, OutIndex: vout}
+	if tx := api.txManager.txMemPool.CheckSpend(outPoint); tx != nil {
+		for i, txIn := range tx.Tx.TxIn {
+			if txIn.PreviousOut == outPoint {
+				return json.SpendingTxResult{
+					Txid: tx.Hash().String(),
+					Vin:  uint32(i),
+				}, nil
+			}
+		}
+	}
+
+	spender, err := spentIndex.SpendingTx(&outPoint)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Spending transaction")
+	}
+	if spender == nil {
+		return nil, nil
+	}
+	bd := api.txManager.bm.GetChain().BlockDAG()
+	return json.SpendingTxResult{
+		Txid:          spender.TxHash.String(),
+		Vin:           spender.InIndex,
+		BlockHash:     spender.BlockHash.String(),
+		Confirmations: int64(bd.GetConfirmations(spender.BlockHash)),
+	}, nil
+}
+
+// SearchNullData returns the null data outputs of the transactions in the
+// blockchain with a payload starting with the hex encoded prefix, in the order
+// their blocks were indexed.  The first skip outputs are skipped and at most
+// count outputs are returned, 100 by default.
+func (api *PublicTxAPI) SearchNullData(prefixHex string, skip *uint, count *uint) (interface{}, error) {
+	nullDataIndex := api.txManager.nullDataIndex
+	if err := nullDataIndex.Ready(); err != nil {
+		return nil, indexError(err, "Null data index must be enabled (--nulldataindex)")
+	}
+	prefix, err := hex.DecodeString(prefixHex)
+	if err != nil {
+		return nil, rpc.RpcDecodeHexError(prefixHex)
+	}
+	numToSkip := uint32(0)
+	if skip != nil {
+		numToSkip = uint32(*skip)
+	}
+	numRequested := uint32(100)
+	if count != nil {
+		numRequested = uint32(*count)
+	}
+
+	nullDatas, _, err := nullDataIndex.SearchNullData(prefix, numToSkip,
+		numRequested)
+	if err != nil {
+		context := "Failed to search the null data index"
+		return nil, rpc.RpcInternalError(err.Error(), context)
+	}
+
+	// Load the raw transaction bytes from the database.
+	regions := make([]database.BlockRegion, len(nullDatas))
+	for i, nullData := range nullDatas {
+		regions[i] = nullData.Region
+	}
+	var serializedTxns [][]byte
+	err = api.txManager.db.View(func(dbTx database.Tx) error {
+		var err error
+		serializedTxns, err = dbTx.FetchBlockRegions(regions)
+		return err
+	})
+	if err != nil {
+		context := "Failed to load transactions"
+		return nil, rpc.RpcInternalError(err.Error(), context)
+	}
+
+	bd := api.txManager.bm.GetChain().BlockDAG()
+	result := make([]json.NullDataResult, 0, len(nullDatas))
+	for i, nullData := range nullDatas {
+		var msgTx types.Transaction
+		err := msgTx.Deserialize(bytes.NewReader(serializedTxns[i]))
+		if err != nil {
+			context := "Failed to deserialize transaction"
+			return nil, rpc.RpcInternalError(err.Error(), context)
+		}
+		result = append(result, json.NullDataResult{
+			Txid:          msgTx.TxHash().String(),
+			Vout:          nullData.OutIndex,
+			BlockHash:     nullData.Region.Hash.String(),
+			Confirmations: int64(bd.GetConfirmations(nullData.Region.Hash)),
+			Payload:       hex.EncodeToString(nullData.Payload),
+		})
+	}
+	return result, nil
+}
@@ -2,10 +2,10 @@ package tx
 
 import (
 	"github.com/Qitmeer/qitmeer-lib/common/hash"
-	"github.com/Qitmeer/qitmeer-lib/core/types"
 	"github.com/Qitmeer/qitmeer/config"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/node/notify"
@@ -13,9 +13,23 @@ import (
 	"github.com/Qitmeer/qitmeer/services/common"
 	"github.com/Qitmeer/qitmeer/services/index"
 	"github.com/Qitmeer/qitmeer/services/mempool"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
+// mempoolFileName is the name of the file in the data directory the mempool is
+// saved to on shutdown.
+const mempoolFileName = "mempool.dat"
+
+// feeEstimatorSaveInterval is the interval at which the state of the fee
+// estimator is saved, so it survives an unclean shutdown.
+const feeEstimatorSaveInterval = 10 * time.Minute
+
+// feeEstimatorKey is the key of the state of the fee estimator in the metadata
+// of the database.
+var feeEstimatorKey = []byte("feeestimator")
+
 type TxManager struct {
 	bm *blkmgr.BlockManager
 	// tx index
@@ -23,6 +37,15 @@ type TxManager struct {
 
 	// addr index
 	addrIndex *index.AddrIndex
+
+	// spent index
+	spentIndex *index.SpentIndex
+
+	// null data index
+	nullDataIndex *index.NullDataIndex
+
+	// addr utxo index
+	addrUtxoIndex *index.AddrUtxoIndex
 	// mempool hold tx that need to be mined into blocks and relayed to other peers.
 	txMemPool *mempool.TxPool
 
@@ -34,22 +57,100 @@ type TxManager struct {
 
 	//invalidTx hash->block hash
 	invalidTx map[hash.Hash]*blockdag.HashSet
+
+	// config
+	cfg *config.Config
+
+	// fee estimator
+	feeEstimator *mempool.FeeEstimator
+
+	quit chan struct{}
+	wg   sync.WaitGroup
 }
 
 func (tm *TxManager) Start() error {
 	log.Info("Starting tx manager")
+	// The transactions of the saved mempool are validated again, which
+	// can fail when the chain changed meanwhile.
+	err := tm.txMemPool.Load()
+	if err != nil {
+		log.Warn("Failed to load the mempool", "error", err)
+	}
+	tm.wg.Add(1)
+	go tm.feeEstimatorHandler()
 	return nil
 }
 
 func (tm *TxManager) Stop() error {
 	log.Info("Stopping tx manager")
+	close(tm.quit)
+	tm.wg.Wait()
+	if !tm.cfg.NoPersistMempool {
+		err := tm.txMemPool.Save()
+		if err != nil {
+			log.Error("Failed to save the mempool", "error", err)
+		}
+	}
+	tm.saveFeeEstimator()
 	return nil
 }
 
+// feeEstimatorHandler saves the state of the fee estimator periodically until
+// the manager is stopped.
+//
+// It must be run as a goroutine.
+func (tm *TxManager) feeEstimatorHandler() {
+	ticker := time.NewTicker(feeEstimatorSaveInterval)
+	defer ticker.Stop()
+out:
+	for {
+		select {
+		case <-ticker.C:
+			tm.saveFeeEstimator()
+		case <-tm.quit:
+			break out
+		}
+	}
+	tm.wg.Done()
+}
+
+// saveFeeEstimator saves the state of the fee estimator to the database.
+func (tm *TxManager) saveFeeEstimator() {
+	err := tm.db.Update(func(dbTx database.Tx) error {
+		return dbTx.Metadata().Put(feeEstimatorKey, tm.feeEstimator.Save())
+	})
+	if err != nil {
+		log.Error("Failed to save the fee estimator", "error", err)
+	}
+}
+
 func (tm *TxManager) MemPool() blockchain.TxPool {
 	return tm.txMemPool
 }
 
+func (tm *TxManager) FeeEstimator() blockchain.FeeEstimator {
+	return tm.feeEstimator
+}
+
+// loadFeeEstimator restores the fee estimator saved in the database, or
+// returns a new one when there is none or it can't be restored.
+func loadFeeEstimator(db database.DB, bestOrder uint64) *mempool.FeeEstimator {
+	var serialized []byte
+	err := db.View(func(dbTx database.Tx) error {
+		serialized = dbTx.Metadata().Get(feeEstimatorKey)
+		return nil
+	})
+	if err != nil || serialized == nil {
+		return mempool.NewFeeEstimator(bestOrder)
+	}
+	feeEstimator, err := mempool.RestoreFeeEstimator(serialized)
+	if err != nil {
+		log.Warn("Failed to restore the fee estimator", "error", err)
+		return mempool.NewFeeEstimator(bestOrder)
+	}
+	return feeEstimator
+}
+
 func (tm *TxManager) IsInvalidTx(txh *hash.Hash) bool {
 	_, ok := tm.invalidTx[*txh]
 	return ok
@@ -97,19 +198,31 @@ func (tm *TxManager) RemoveInvalidTx(bh *hash.Hash) {
 }
 
 func NewTxManager(bm *blkmgr.BlockManager, txIndex *index.TxIndex,
-	addrIndex *index.AddrIndex, cfg *config.Config, ntmgr notify.Notify,
-	sigCache *txscript.SigCache, db database.DB) (*TxManager, error) {
+	addrIndex *index.AddrIndex, spentIndex *index.SpentIndex,
+	nullDataIndex *index.NullDataIndex, addrUtxoIndex *index.AddrUtxoIndex,
+	cfg *config.Config, ntmgr notify.Notify, sigCache *txscript.SigCache,
+	db database.DB) (*TxManager, error) {
+	feeEstimator := loadFeeEstimator(db,
+		uint64(bm.GetChain().BestSnapshot().GraphState.GetTotal())-1)
+
 	// mem-pool
 	txC := mempool.Config{
 		Policy: mempool.Policy{
-			MaxTxVersion:         2,
-			DisableRelayPriority: cfg.NoRelayPriority,
-			AcceptNonStd:         cfg.AcceptNonStd,
-			FreeTxRelayLimit:     cfg.FreeTxRelayLimit,
-			MaxOrphanTxs:         cfg.MaxOrphanTxs,
-			MaxOrphanTxSize:      mempool.DefaultMaxOrphanTxSize,
-			MaxSigOpsPerTx:       blockchain.MaxSigOpsPerBlock / 5,
-			MinRelayTxFee:        types.Amount(cfg.MinTxFee),
+			MaxTxVersion:            2,
+			DisableRelayPriority:    cfg.NoRelayPriority,
+			AcceptNonStd:            cfg.AcceptNonStd,
+			RejectReplacement:       cfg.RejectReplacement,
+			FreeTxRelayLimit:        cfg.FreeTxRelayLimit,
+			MaxOrphanTxs:            cfg.MaxOrphanTxs,
+			MaxOrphanTxSize:         mempool.DefaultMaxOrphanTxSize,
+			MaxPoolSize:             cfg.MaxMempoolSize,
+			MaxAncestorCount:        mempool.DefaultMaxAncestorCount,
+			MaxAncestorSize:         mempool.DefaultMaxAncestorSize,
+			MaxDescendantCount:      mempool.DefaultMaxDescendantCount,
+			MaxDescendantSize:       mempool.DefaultMaxDescendantSize,
+			MaxReplacementEvictions: mempool.DefaultMaxReplacementEvictions,
+			MaxSigOpsPerTx:          blockchain.MaxSigOpsPerBlock / 5,
+			MinRelayTxFee:           types.Amount(cfg.MinTxFee),
 			StandardVerifyFlags: func() (txscript.ScriptFlags, error) {
 				return common.StandardScriptVerifyFlags()
 			},
@@ -124,9 +237,18 @@ func NewTxManager(bm *blkmgr.BlockManager, txIndex *index.TxIndex,
 		SigCache:         sigCache,
 		PastMedianTime:   func() time.Time { return bm.GetChain().BestSnapshot().MedianTime },
 		AddrIndex:        addrIndex,
+		AddrUtxoIndex:    addrUtxoIndex,
 		BD:               bm.GetChain().BlockDAG(),
+		FeeEstimator:     feeEstimator,
+	}
+	if !cfg.NoPersistMempool {
+		txC.PersistFile = filepath.Join(cfg.DataDir, mempoolFileName)
 	}
 	txMemPool := mempool.New(&txC)
 	invalidTx := make(map[hash.Hash]*blockdag.HashSet)
-	return &TxManager{bm, txIndex, addrIndex, txMemPool, ntmgr, db, invalidTx}, nil
+	return &TxManager{bm: bm, txIndex: txIndex, addrIndex: addrIndex,
+		spentIndex: spentIndex, nullDataIndex: nullDataIndex,
+		addrUtxoIndex: addrUtxoIndex, txMemPool: txMemPool, ntmgr: ntmgr,
+		db: db, invalidTx: invalidTx, cfg: cfg, feeEstimator: feeEstimator,
+		quit: make(chan struct{})}, nil
 }
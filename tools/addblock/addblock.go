@@ -0,0 +1,193 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"github.com/Qitmeer/qitmeer/consensus/pow"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/log"
+	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/services/index"
+	"github.com/Qitmeer/qitmeer/services/mining"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	// blockDbNamePrefix is the prefix for the qitmeerd block database.
+	blockDbNamePrefix = "blocks"
+)
+
+// interruptSignals defines the signals to catch in order to stop the import
+// in between two blocks.
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// interruptListener listens for OS Signals such as SIGINT (Ctrl+C).  It returns
+// a channel that is closed when a signal is received.
+func interruptListener() <-chan struct{} {
+	c := make(chan struct{})
+	go func() {
+		interruptChannel := make(chan os.Signal, 1)
+		signal.Notify(interruptChannel, interruptSignals...)
+
+		sig := <-interruptChannel
+		log.Info("Stopping the import...", "Received signal", sig)
+		close(c)
+	}()
+
+	return c
+}
+
+// interruptRequested returns true when the channel returned by
+// interruptListener was closed.
+func interruptRequested(interrupted <-chan struct{}) bool {
+	select {
+	case <-interrupted:
+		return true
+	default:
+	}
+
+	return false
+}
+
+// loadBlockDB opens the block database and returns a handle to it.  The
+// database is created when it doesn't exist yet.
+func loadBlockDB(cfg *config) (database.DB, error) {
+	// The database name is based on the database type.
+	dbName := blockDbNamePrefix + "_" + cfg.DbType
+	dbPath := filepath.Join(cfg.DataDir, dbName)
+
+	log.Info("Loading block database", "dbPath", dbPath)
+	db, err := database.Open(cfg.DbType, dbPath, params.ActiveNetParams.Net)
+	if err != nil {
+		// Return the error if it's not because the database doesn't
+		// exist.
+		if dbErr, ok := err.(database.Error); !ok || dbErr.ErrorCode !=
+			database.ErrDbDoesNotExist {
+
+			return nil, err
+		}
+
+		// Create the db if it does not exist.
+		err = os.MkdirAll(cfg.DataDir, 0700)
+		if err != nil {
+			return nil, err
+		}
+		db, err = database.Create(cfg.DbType, dbPath, params.ActiveNetParams.Net)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info("Block database loaded")
+	return db, nil
+}
+
+// realMain is the real main function for the utility.  It is necessary to work
+// around the fact that deferred functions do not run when os.Exit() is called.
+func realMain() error {
+	// Load configuration and parse command line.
+	cfg, _, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	// Load the block database.
+	db, err := loadBlockDB(cfg)
+	if err != nil {
+		log.Error("Failed to load database", "error", err)
+		return err
+	}
+	defer func() {
+		// Ensure the database is sync'd and closed on shutdown.
+		log.Info("Gracefully shutting down the database...")
+		db.Close()
+	}()
+
+	fi, err := os.Open(cfg.InFile)
+	if err != nil {
+		log.Error("Failed to open file", "file", cfg.InFile, "error", err)
+		return err
+	}
+	defer fi.Close()
+
+	interrupt := interruptListener()
+
+	// Create the optional indexes which are built while the blocks are
+	// processed.
+	par := params.ActiveNetParams.Params
+	var indexes []index.Indexer
+	if cfg.TxIndex {
+		log.Info("Transaction index is enabled")
+		indexes = append(indexes, index.NewTxIndex(db))
+	}
+	if cfg.AddrIndex {
+		log.Info("Address index is enabled")
+		indexes = append(indexes, index.NewAddrIndex(db, par))
+	}
+	var indexManager *index.Manager
+	var chainIndexManager blockchain.IndexManager
+	if len(indexes) > 0 {
+		indexManager = index.NewManager(db, indexes, par)
+		chainIndexManager = indexManager
+	}
+
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:           db,
+		Interrupt:    interrupt,
+		ChainParams:  par,
+		TimeSource:   blockchain.NewMedianTime(),
+		IndexManager: chainIndexManager,
+		DAGType:      cfg.DAGType,
+		BlockVersion: mining.BlockVersion(par.Net),
+		PoW:          pow.New(par),
+	})
+	if err != nil {
+		log.Error("Failed to initialize block chain", "error", err)
+		return err
+	}
+
+	// Catch up the indexes behind the chain before importing, since the
+	// index manager isn't running in the background here.
+	if indexManager != nil {
+		if err := indexManager.CatchUp(interrupt); err != nil {
+			log.Error("Failed to catch up indexes", "error", err)
+			return err
+		}
+	}
+
+	flags := blockchain.BFNone
+	if cfg.FastAdd {
+		flags |= blockchain.BFFastAdd
+	}
+	if cfg.NoPoWCheck {
+		flags |= blockchain.BFNoPoWCheck
+	}
+
+	log.Info("Starting import", "file", cfg.InFile)
+	importer := newBlockImporter(chain, bufio.NewReader(fi), par.Net,
+		flags, interrupt)
+	err = importer.Import()
+	results := importer.results
+	log.Info("Processed blocks", "total", results.blocksProcessed,
+		"imported", results.blocksImported,
+		"skipped", results.blocksSkipped)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+func main() {
+	if err := realMain(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/util"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"github.com/Qitmeer/qitmeer/params"
+	"github.com/jessevdk/go-flags"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultDbType   = "ffldb"
+	defaultDAGType  = "phantom"
+	defaultDataFile = "blocks.bin"
+)
+
+var (
+	qitmeerdHomeDir = util.AppDataDir("qitmeerd", false)
+	defaultDataDir  = filepath.Join(qitmeerdHomeDir, "data")
+	knownDbTypes    = database.SupportedDrivers()
+)
+
+// config defines the configuration options for addblock.
+//
+// See loadConfig for details on the configuration load process.
+type config struct {
+	DataDir    string `short:"b" long:"datadir" description:"Location of the qitmeerd data directory"`
+	DbType     string `long:"dbtype" description:"Database backend to use for the Block Chain"`
+	TestNet    bool   `long:"testnet" description:"Use the test network"`
+	PrivNet    bool   `long:"privnet" description:"Use the private network"`
+	DAGType    string `short:"G" long:"dagtype" description:"DAG type {phantom,conflux,spectre}"`
+	InFile     string `short:"i" long:"infile" description:"File containing the block(s)"`
+	FastAdd    bool   `long:"fastadd" description:"Skip the contextual checks of the imported blocks -- only use with a trusted file"`
+	NoPoWCheck bool   `long:"nopowcheck" description:"Skip the proof of work check of the imported blocks -- only use with a trusted file"`
+	TxIndex    bool   `long:"txindex" description:"Build a full hash-based transaction index which makes all transactions available via the getrawtransaction RPC"`
+	AddrIndex  bool   `long:"addrindex" description:"Build a full address-based transaction index which makes the searchrawtransactions RPC available"`
+}
+
+// fileExists reports whether the named file or directory exists.
+func fileExists(name string) bool {
+	if _, err := os.Stat(name); err != nil {
+		if os.IsNotExist(err) {
+			return false
+		}
+	}
+	return true
+}
+
+// validDbType returns whether or not dbType is a supported database type.
+func validDbType(dbType string) bool {
+	for _, knownType := range knownDbTypes {
+		if dbType == knownType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadConfig initializes and parses the config using command line options.
+func loadConfig() (*config, []string, error) {
+	// Default config.
+	cfg := config{
+		DataDir: defaultDataDir,
+		DbType:  defaultDbType,
+		DAGType: defaultDAGType,
+		InFile:  defaultDataFile,
+	}
+
+	// Parse command line options.
+	parser := flags.NewParser(&cfg, flags.Default)
+	remainingArgs, err := parser.Parse()
+	if err != nil {
+		if e, ok := err.(*flags.Error); !ok || e.Type != flags.ErrHelp {
+			parser.WriteHelp(os.Stderr)
+		}
+		return nil, nil, err
+	}
+
+	// Multiple networks can't be selected simultaneously.
+	funcName := "loadConfig"
+	numNets := 0
+	if cfg.TestNet {
+		numNets++
+		params.ActiveNetParams = &params.TestNetParam
+	}
+	if cfg.PrivNet {
+		numNets++
+		params.ActiveNetParams = &params.PrivNetParam
+	}
+	if numNets > 1 {
+		str := "%s: the testnet and privnet params can't be " +
+			"used together -- choose one"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// Validate database type.
+	if !validDbType(cfg.DbType) {
+		str := "%s: the specified database type [%v] is invalid -- " +
+			"supported types %v"
+		err := fmt.Errorf(str, funcName, cfg.DbType, strings.Join(knownDbTypes, ", "))
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// The address index relies on the transaction index.
+	if cfg.AddrIndex {
+		cfg.TxIndex = true
+	}
+
+	// Append the network type to the data directory so it is "namespaced"
+	// per network in the same way qitmeerd does it.
+	cfg.DataDir = util.CleanAndExpandPath(cfg.DataDir)
+	cfg.DataDir = filepath.Join(cfg.DataDir, params.ActiveNetParams.Name)
+
+	// Ensure the specified block file exists.
+	cfg.InFile = util.CleanAndExpandPath(cfg.InFile)
+	if !fileExists(cfg.InFile) {
+		str := "%s: the specified block file [%v] does not exist"
+		err := fmt.Errorf(str, funcName, cfg.InFile)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	return &cfg, remainingArgs, nil
+}
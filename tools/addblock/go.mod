@@ -0,0 +1,11 @@
+module addblock
+
+go 1.12
+
+require (
+	github.com/Qitmeer/qitmeer v0.0.0-20190928033548-1aed0cf3e311
+	github.com/Qitmeer/qitmeer-lib v0.0.0-20190929044832-b10740b316a8
+	github.com/jessevdk/go-flags v1.4.0
+)
+
+replace github.com/Qitmeer/qitmeer => ./../../
@@ -0,0 +1,171 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/Qitmeer/qitmeer-lib/core/protocol"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/log"
+	"github.com/Qitmeer/qitmeer/services/common/progresslog"
+	"io"
+)
+
+// importResults houses the stats and result as an import operation.
+type importResults struct {
+	blocksProcessed int64
+	blocksImported  int64
+	blocksSkipped   int64
+}
+
+// blockImporter houses information about an ongoing import from a block data
+// file written by qitmeerd with --dumpblockchain.
+type blockImporter struct {
+	r              io.Reader
+	chain          *blockchain.BlockChain
+	net            protocol.Network
+	flags          blockchain.BehaviorFlags
+	interrupt      <-chan struct{}
+	progressLogger *progresslog.BlockProgressLogger
+	results        importResults
+}
+
+// readBlock reads the next block from the input file.  It returns nil when the
+// end of the file is reached.
+func (bi *blockImporter) readBlock() ([]byte, error) {
+	// The block file format is:
+	//  <network> <block length> <serialized block>
+	var net uint32
+	err := binary.Read(bi.r, binary.LittleEndian, &net)
+	if err != nil {
+		if err != io.EOF {
+			return nil, err
+		}
+
+		// No block and no error means there are no more blocks to read.
+		return nil, nil
+	}
+	if net != uint32(bi.net) {
+		return nil, fmt.Errorf("network mismatch -- got %x, want %x",
+			net, uint32(bi.net))
+	}
+
+	// Read the block length and ensure it is sane.
+	var blockLen uint32
+	if err := binary.Read(bi.r, binary.LittleEndian, &blockLen); err != nil {
+		return nil, err
+	}
+	if blockLen > types.MaxBlockPayload {
+		return nil, fmt.Errorf("block payload of %d bytes is larger "+
+			"than the max allowed %d bytes", blockLen,
+			types.MaxBlockPayload)
+	}
+
+	serializedBlock := make([]byte, blockLen)
+	if _, err := io.ReadFull(bi.r, serializedBlock); err != nil {
+		return nil, err
+	}
+
+	return serializedBlock, nil
+}
+
+// processBlock potentially imports the block into the database.  It first
+// deserializes the raw block while checking for errors.  Already known blocks
+// are skipped, which allows an interrupted import to be resumed by running it
+// again with the same file.  Orphan blocks are considered an error since the
+// file contains the blocks in DAG order.
+func (bi *blockImporter) processBlock(serializedBlock []byte) (bool, error) {
+	// Deserialize the block which includes checks for malformed blocks.
+	block, err := types.NewBlockFromBytes(serializedBlock)
+	if err != nil {
+		return false, err
+	}
+
+	// Skip blocks that already exist.
+	blockHash := block.Hash()
+	exists, err := bi.chain.HaveBlock(blockHash)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	// Don't bother trying to process orphans.
+	parents := block.Block().Parents
+	for _, parent := range parents {
+		exists, err := bi.chain.HaveBlock(parent)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			return false, fmt.Errorf("import file contains block "+
+				"%v which does not link to the available "+
+				"block chain", parent)
+		}
+	}
+
+	// Ensure the blocks follows all of the chain rules.
+	_, isOrphan, err := bi.chain.ProcessBlock(block, bi.flags)
+	if err != nil {
+		return false, err
+	}
+	if isOrphan {
+		return false, fmt.Errorf("import file contains an orphan "+
+			"block: %v", blockHash)
+	}
+	bi.progressLogger.LogBlockHeight(block)
+
+	return true, nil
+}
+
+// Import reads the blocks from the file and processes them in order until the
+// end of the file is reached, an error occurs or an interrupt is received.
+func (bi *blockImporter) Import() error {
+	for {
+		if interruptRequested(bi.interrupt) {
+			log.Info("Import interrupted -- run the same command " +
+				"again to resume it")
+			return nil
+		}
+
+		serializedBlock, err := bi.readBlock()
+		if err != nil {
+			return fmt.Errorf("error reading block %d from file: %v",
+				bi.results.blocksProcessed+1, err)
+		}
+		if serializedBlock == nil {
+			return nil
+		}
+		bi.results.blocksProcessed++
+
+		imported, err := bi.processBlock(serializedBlock)
+		if err != nil {
+			return fmt.Errorf("error processing block %d: %v",
+				bi.results.blocksProcessed, err)
+		}
+		if imported {
+			bi.results.blocksImported++
+		} else {
+			bi.results.blocksSkipped++
+		}
+	}
+}
+
+// newBlockImporter returns a new importer for the provided file reader and
+// block chain.
+func newBlockImporter(chain *blockchain.BlockChain, r io.Reader, net protocol.Network, flags blockchain.BehaviorFlags, interrupt <-chan struct{}) *blockImporter {
+	return &blockImporter{
+		r:              r,
+		chain:          chain,
+		net:            net,
+		flags:          flags,
+		interrupt:      interrupt,
+		progressLogger: progresslog.NewBlockProgressLogger("Processed", log.Root()),
+	}
+}
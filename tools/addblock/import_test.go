@@ -0,0 +1,245 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer/consensus/pow"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/merkle"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/services/mining"
+)
+
+// testImportBlocks is the number of blocks the exported chain has besides the
+// genesis block.
+const testImportBlocks = 10
+
+// newTestChain returns a new chain of the privnet on a fresh database in the
+// passed directory along with a function to close its database.
+func newTestChain(t *testing.T, dir string) (*blockchain.BlockChain, func()) {
+	par := &params.PrivNetParams
+	db, err := database.Create("ffldb", dir, par.Net)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:           db,
+		ChainParams:  par,
+		TimeSource:   blockchain.NewMedianTime(),
+		DAGType:      defaultDAGType,
+		BlockVersion: mining.BlockVersion(par.Net),
+		PoW:          pow.New(par),
+	})
+	if err != nil {
+		db.Close()
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	return chain, func() { db.Close() }
+}
+
+// nextTestBlock returns a block on top of the tips of the passed chain whose
+// coinbase pays the subsidy of the passed height to anyone.
+func nextTestBlock(t *testing.T, chain *blockchain.BlockChain, height uint64, timestamp time.Time) *types.SerializedBlock {
+	par := &params.PrivNetParams
+	coinbaseScript, err := txscript.NewScriptBuilder().
+		AddInt64(int64(height)).AddInt64(0).Script()
+	if err != nil {
+		t.Fatalf("failed to create coinbase script: %v", err)
+	}
+	pkScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_TRUE).Script()
+	if err != nil {
+		t.Fatalf("failed to create coinbase output script: %v", err)
+	}
+	subsidyCache := blockchain.NewSubsidyCache(0, par)
+	coinbase := types.NewTransaction()
+	coinbase.AddTxIn(&types.TxInput{
+		PreviousOut: *types.NewOutPoint(&hash.Hash{},
+			types.MaxPrevOutIndex),
+		Sequence:   types.MaxTxInSequenceNum,
+		SignScript: coinbaseScript,
+	})
+	coinbase.AddTxOut(&types.TxOutput{
+		Amount: blockchain.CalcBlockWorkSubsidy(subsidyCache,
+			int64(height), par),
+		PkScript: pkScript,
+	})
+	coinbase.AddTxOut(&types.TxOutput{
+		Amount: uint64(blockchain.CalcBlockTaxSubsidy(subsidyCache,
+			int64(height), par)),
+		PkScript: par.OrganizationPkScript,
+	})
+	txns := []*types.Tx{types.NewTx(coinbase)}
+	if err := mining.FillWitnessToCoinBase(txns); err != nil {
+		t.Fatalf("failed to commit the witness: %v", err)
+	}
+
+	difficulty, err := chain.CalcNextRequiredDifficulty(timestamp)
+	if err != nil {
+		t.Fatalf("failed to calculate difficulty: %v", err)
+	}
+	version, err := chain.CalcNextBlockVersion()
+	if err != nil {
+		t.Fatalf("failed to calculate block version: %v", err)
+	}
+	parents := chain.GetMiningTips()
+	merkles := merkle.BuildMerkleTreeStore(txns, false)
+	paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
+	var block types.Block
+	block.Header = types.BlockHeader{
+		Version:    version,
+		ParentRoot: *paMerkles[len(paMerkles)-1],
+		TxRoot:     *merkles[len(merkles)-1],
+		Timestamp:  timestamp,
+		Difficulty: difficulty,
+	}
+	for _, parent := range parents {
+		if err := block.AddParent(parent); err != nil {
+			t.Fatalf("failed to add parent: %v", err)
+		}
+	}
+	if err := block.AddTransaction(txns[0].Transaction()); err != nil {
+		t.Fatalf("failed to add coinbase: %v", err)
+	}
+	return types.NewBlock(&block)
+}
+
+// TestImportResume ensures the blocks exported by DumpBlockChain are framed by
+// the network and their length, and that an interrupted import resumed with
+// the same file skips the blocks it already has.
+func TestImportResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "addblock")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	par := &params.PrivNetParams
+	srcChain, closeSrc := newTestChain(t, filepath.Join(dir, "src"))
+	defer closeSrc()
+	timestamp := par.GenesisBlock.Header.Timestamp
+	for i := uint64(1); i <= testImportBlocks; i++ {
+		timestamp = timestamp.Add(par.TargetTimePerBlock)
+		block := nextTestBlock(t, srcChain, i, timestamp)
+		_, isOrphan, err := srcChain.ProcessBlock(block,
+			blockchain.BFNoPoWCheck)
+		if err != nil || isOrphan {
+			t.Fatalf("failed to process block %d: orphan %v error %v",
+				i, isOrphan, err)
+		}
+	}
+
+	dumpFile := filepath.Join(dir, "blocks.bin")
+	err = srcChain.DumpBlockChain(dumpFile, par, testImportBlocks)
+	if err != nil {
+		t.Fatalf("failed to export blocks: %v", err)
+	}
+	data, err := ioutil.ReadFile(dumpFile)
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+
+	// Every block is preceded by the network and its length, in order.
+	var frameEnds []int
+	r := bytes.NewReader(data)
+	for i := uint64(1); i <= testImportBlocks; i++ {
+		var net, blockLen uint32
+		binary.Read(r, binary.LittleEndian, &net)
+		binary.Read(r, binary.LittleEndian, &blockLen)
+		if net != uint32(par.Net) {
+			t.Fatalf("block %d: network %x, want %x", i, net,
+				uint32(par.Net))
+		}
+		serializedBlock := make([]byte, blockLen)
+		if _, err := io.ReadFull(r, serializedBlock); err != nil {
+			t.Fatalf("block %d: failed to read %d bytes: %v", i,
+				blockLen, err)
+		}
+		block, err := srcChain.BlockByOrder(i)
+		if err != nil {
+			t.Fatalf("failed to fetch block %d: %v", i, err)
+		}
+		want, _ := block.Bytes()
+		if !bytes.Equal(serializedBlock, want) {
+			t.Fatalf("block %d doesn't match the block of order %d",
+				i, i)
+		}
+		frameEnds = append(frameEnds, len(data)-r.Len())
+	}
+	if r.Len() != 0 {
+		t.Fatalf("%d bytes left after the last block", r.Len())
+	}
+
+	// The import of the first blocks stands for an interrupted import,
+	// which is resumed with the whole file, and the file is imported once
+	// more after that.
+	const interruptedAt = testImportBlocks / 2
+	dstChain, closeDst := newTestChain(t, filepath.Join(dir, "dst"))
+	defer closeDst()
+	tests := []struct {
+		name      string
+		data      []byte
+		processed int64
+		imported  int64
+		skipped   int64
+	}{
+		{"interrupted", data[:frameEnds[interruptedAt-1]],
+			interruptedAt, interruptedAt, 0},
+		{"resumed", data, testImportBlocks,
+			testImportBlocks - interruptedAt, interruptedAt},
+		{"again", data, testImportBlocks, 0, testImportBlocks},
+	}
+	for _, test := range tests {
+		bi := newBlockImporter(dstChain, bytes.NewReader(test.data),
+			par.Net, blockchain.BFNoPoWCheck, nil)
+		if err := bi.Import(); err != nil {
+			t.Fatalf("%s: import failed: %v", test.name, err)
+		}
+		results := bi.results
+		if results.blocksProcessed != test.processed ||
+			results.blocksImported != test.imported ||
+			results.blocksSkipped != test.skipped {
+			t.Fatalf("%s: processed %d imported %d skipped %d, want "+
+				"%d, %d and %d", test.name, results.blocksProcessed,
+				results.blocksImported, results.blocksSkipped,
+				test.processed, test.imported, test.skipped)
+		}
+	}
+
+	// A block cut short is an error.
+	bi := newBlockImporter(dstChain,
+		bytes.NewReader(data[:frameEnds[0]-1]), par.Net,
+		blockchain.BFNoPoWCheck, nil)
+	if err := bi.Import(); err == nil {
+		t.Fatalf("import of a truncated block succeeded")
+	}
+
+	if got, want := dstChain.BestSnapshot().GraphState.GetMainOrder(),
+		srcChain.BestSnapshot().GraphState.GetMainOrder(); got != want {
+		t.Fatalf("imported chain has main order %d, want %d", got, want)
+	}
+	for i := uint64(1); i <= testImportBlocks; i++ {
+		block, err := srcChain.BlockByOrder(i)
+		if err != nil {
+			t.Fatalf("failed to fetch block %d: %v", i, err)
+		}
+		if have, _ := dstChain.HaveBlock(block.Hash()); !have {
+			t.Fatalf("block %d %v wasn't imported", i, block.Hash())
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/Qitmeer/qitmeer-lib/core/protocol"
 	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/consensus/pow"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/dbnamespace"
@@ -66,6 +67,7 @@ func buildLedger(cfg *config.Config, db database.DB, params *params.Params) erro
 		TimeSource:   blockchain.NewMedianTime(),
 		DAGType:      cfg.DAGType,
 		BlockVersion: mining.BlockVersion(params.Net),
+		PoW:          pow.New(params),
 	})
 	if err != nil {
 		log.Error(err.Error())